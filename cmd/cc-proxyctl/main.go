@@ -0,0 +1,13 @@
+// Package main provides cc-proxyctl, a command line client of the CLI Proxy API
+// management API.
+package main
+
+import (
+	"os"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/ctl"
+)
+
+func main() {
+	os.Exit(ctl.Execute())
+}
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/joho/godotenv"
+	chainaccess "github.com/router-for-me/CLIProxyAPI/v6/internal/access/chain_access"
 	configaccess "github.com/router-for-me/CLIProxyAPI/v6/internal/access/config_access"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/buildinfo"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/cmd"
@@ -23,6 +24,7 @@ import (
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/logging"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/managementasset"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/misc"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/pii"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/store"
 	_ "github.com/router-for-me/CLIProxyAPI/v6/internal/translator"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
@@ -106,6 +108,7 @@ func main() {
 			}
 			_, _ = fmt.Fprint(out, s+"\n")
 		})
+		_, _ = fmt.Fprintf(out, "Commands:\n  %s -from <backend> -to <backend> [-dry-run]\n    Copy device bindings, bans and key usage between store backends\n", cmd.MigrateStoreCommand)
 	}
 
 	// Parse the command-line flags.
@@ -404,6 +407,7 @@ func main() {
 	}
 	usage.SetStatisticsEnabled(cfg.UsageStatisticsEnabled)
 	coreauth.SetQuotaCooldownDisabled(cfg.DisableCooling)
+	pii.Configure(cfg.PIIRedaction)
 
 	if err = logging.ConfigureLogOutput(cfg); err != nil {
 		log.Errorf("failed to configure log output: %v", err)
@@ -441,10 +445,16 @@ func main() {
 
 	// Register built-in access providers before constructing services.
 	configaccess.Register()
+	chainaccess.Register()
 
 	// Handle different command modes based on the provided flags.
 
-	if vertexImport != "" {
+	if flag.Arg(0) == cmd.MigrateStoreCommand {
+		if errMigrate := cmd.DoMigrateStore(cfg, flag.Args()[1:]); errMigrate != nil {
+			log.Error(errMigrate)
+			os.Exit(1)
+		}
+	} else if vertexImport != "" {
 		// Handle Vertex service account import
 		cmd.DoVertexImport(cfg, vertexImport)
 	} else if login {
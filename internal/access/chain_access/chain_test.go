@@ -0,0 +1,143 @@
+package chainaccess
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
+	sdkconfig "github.com/router-for-me/CLIProxyAPI/v6/sdk/config"
+)
+
+func signHS256(t *testing.T, secret string, claims map[string]any) string {
+	t.Helper()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(raw)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func bearerRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestJWTProvider(t *testing.T) {
+	p, err := newJWTProvider(&sdkconfig.AccessProvider{Name: "jwt", Config: map[string]any{
+		"secret":   "s3cret",
+		"issuer":   "https://issuer.test",
+		"audience": "cc-proxy",
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	valid := map[string]any{"sub": "alice", "iss": "https://issuer.test", "aud": []any{"cc-proxy"}, "exp": exp}
+
+	res, err := p.Authenticate(t.Context(), bearerRequest(signHS256(t, "s3cret", valid)))
+	if err != nil || res.Principal != "alice" {
+		t.Fatalf("valid token: res=%+v err=%v", res, err)
+	}
+
+	cases := map[string]string{
+		"wrong secret": signHS256(t, "other", valid),
+		"expired":      signHS256(t, "s3cret", map[string]any{"sub": "alice", "iss": "https://issuer.test", "aud": "cc-proxy", "exp": float64(time.Now().Add(-time.Hour).Unix())}),
+		"wrong issuer": signHS256(t, "s3cret", map[string]any{"sub": "alice", "iss": "https://other.test", "aud": "cc-proxy", "exp": exp}),
+		"no audience":  signHS256(t, "s3cret", map[string]any{"sub": "alice", "iss": "https://issuer.test", "exp": exp}),
+	}
+	for name, token := range cases {
+		if _, err = p.Authenticate(t.Context(), bearerRequest(token)); !errors.Is(err, sdkaccess.ErrInvalidCredential) {
+			t.Errorf("%s: err = %v, want ErrInvalidCredential", name, err)
+		}
+	}
+
+	if _, err = p.Authenticate(t.Context(), bearerRequest("plain-api-key")); !errors.Is(err, sdkaccess.ErrNotHandled) {
+		t.Errorf("plain key: err = %v, want ErrNotHandled", err)
+	}
+}
+
+func TestHTTPProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Authorizer-Token") != "t" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var body authorizerRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		switch body.APIKey {
+		case "good":
+			_, _ = w.Write([]byte(`{"principal":"team-a","metadata":{"plan":"pro"}}`))
+		case "bare":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	p, err := newHTTPProvider(&sdkconfig.AccessProvider{Name: "authz", Config: map[string]any{
+		"url":     server.URL,
+		"headers": map[string]any{"X-Authorizer-Token": "t"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := p.Authenticate(t.Context(), bearerRequest("good"))
+	if err != nil || res.Principal != "team-a" || res.Metadata["plan"] != "pro" {
+		t.Fatalf("good key: res=%+v err=%v", res, err)
+	}
+	res, err = p.Authenticate(t.Context(), bearerRequest("bare"))
+	if err != nil || res.Principal != "bare" {
+		t.Fatalf("bare answer: res=%+v err=%v", res, err)
+	}
+	if _, err = p.Authenticate(t.Context(), bearerRequest("bad")); !errors.Is(err, sdkaccess.ErrInvalidCredential) {
+		t.Fatalf("bad key: err = %v, want ErrInvalidCredential", err)
+	}
+	if _, err = p.Authenticate(t.Context(), httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, sdkaccess.ErrNoCredentials) {
+		t.Fatalf("no key: err = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestMTLSProvider(t *testing.T) {
+	p, err := newMTLSProvider(&sdkconfig.AccessProvider{Name: "mtls", Config: map[string]any{
+		"principal": "cn",
+		"allowed":   []any{"billing"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withCert := func(cn string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}, Raw: []byte(cn)}
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return r
+	}
+
+	res, err := p.Authenticate(t.Context(), withCert("billing"))
+	if err != nil || res.Principal != "billing" {
+		t.Fatalf("allowed cert: res=%+v err=%v", res, err)
+	}
+	if _, err = p.Authenticate(t.Context(), withCert("intruder")); !errors.Is(err, sdkaccess.ErrInvalidCredential) {
+		t.Fatalf("other cert: err = %v, want ErrInvalidCredential", err)
+	}
+	if _, err = p.Authenticate(t.Context(), httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, sdkaccess.ErrNotHandled) {
+		t.Fatalf("no cert: err = %v, want ErrNotHandled", err)
+	}
+}
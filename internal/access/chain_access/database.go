@@ -0,0 +1,114 @@
+package chainaccess
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
+	sdkconfig "github.com/router-for-me/CLIProxyAPI/v6/sdk/config"
+)
+
+const defaultQueryTimeout = 5 * time.Second
+
+// Ways a database provider passes the key to its query
+const (
+	keyHashSHA256 = "sha256"
+	keyHashNone   = "none"
+)
+
+// databaseProvider looks the request key up with a SQL query that takes the
+// key, or its hex SHA-256 by default, as its only parameter. A returned row
+// accepts the key; its first column, when not empty, becomes the principal.
+type databaseProvider struct {
+	name    string
+	db      *sql.DB
+	query   string
+	hash    string
+	timeout time.Duration
+}
+
+func newDatabaseProvider(cfg *sdkconfig.AccessProvider, _ *sdkconfig.SDKConfig) (sdkaccess.Provider, error) {
+	opts := options(cfg.Config)
+	dsn, err := opts.required("dsn")
+	if err != nil {
+		return nil, err
+	}
+	query, err := opts.required("query")
+	if err != nil {
+		return nil, err
+	}
+	var driver string
+	switch opts.string("driver") {
+	case "postgres", "postgresql", "pgx":
+		driver = "pgx"
+	case "sqlite", "sqlite3":
+		driver = "sqlite3"
+	default:
+		return nil, fmt.Errorf("driver must be postgres or sqlite")
+	}
+	hash := opts.string("key-hash")
+	switch hash {
+	case "":
+		hash = keyHashSHA256
+	case keyHashSHA256, keyHashNone:
+	default:
+		return nil, fmt.Errorf("key-hash must be %s or %s", keyHashSHA256, keyHashNone)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(8)
+	return &databaseProvider{
+		name:    cfg.Name,
+		db:      db,
+		query:   query,
+		hash:    hash,
+		timeout: opts.seconds("timeout-seconds", defaultQueryTimeout),
+	}, nil
+}
+
+func (p *databaseProvider) Identifier() string { return p.name }
+
+// Close closes the connection pool when the provider is replaced.
+func (p *databaseProvider) Close() error { return p.db.Close() }
+
+func (p *databaseProvider) Authenticate(ctx context.Context, r *http.Request) (*sdkaccess.Result, error) {
+	credential, ok := firstCredential(sdkaccess.Credentials(r))
+	if !ok {
+		return nil, sdkaccess.ErrNoCredentials
+	}
+	arg := credential.Value
+	if p.hash == keyHashSHA256 {
+		sum := sha256.Sum256([]byte(arg))
+		arg = hex.EncodeToString(sum[:])
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	var principal sql.NullString
+	err := p.db.QueryRowContext(ctx, p.query, arg).Scan(&principal)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, sdkaccess.ErrInvalidCredential
+	}
+	if err != nil {
+		return nil, fmt.Errorf("auth provider %s: %w", p.name, err)
+	}
+	value := strings.TrimSpace(principal.String)
+	if value == "" {
+		value = credential.Value
+	}
+	return &sdkaccess.Result{
+		Provider:  p.name,
+		Principal: value,
+		Metadata:  map[string]string{"source": credential.Source},
+	}, nil
+}
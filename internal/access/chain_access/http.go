@@ -0,0 +1,125 @@
+package chainaccess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
+	sdkconfig "github.com/router-for-me/CLIProxyAPI/v6/sdk/config"
+)
+
+const defaultAuthorizerTimeout = 5 * time.Second
+
+// httpProvider asks an external authorizer about the request credential. The
+// authorizer receives a JSON POST and answers 200 with an optional principal
+// and metadata to accept, or 401 or 403 to reject; anything else fails the
+// request with an authentication service error.
+type httpProvider struct {
+	name    string
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// authorizerRequest is the body sent to the authorizer.
+type authorizerRequest struct {
+	APIKey   string `json:"api_key"`
+	Source   string `json:"source"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	ClientIP string `json:"client_ip"`
+}
+
+// authorizerResponse is the body of an accepting authorizer answer.
+type authorizerResponse struct {
+	Principal string            `json:"principal"`
+	Metadata  map[string]string `json:"metadata"`
+}
+
+func newHTTPProvider(cfg *sdkconfig.AccessProvider, _ *sdkconfig.SDKConfig) (sdkaccess.Provider, error) {
+	opts := options(cfg.Config)
+	url, err := opts.required("url")
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("url must be an http or https URL")
+	}
+	return &httpProvider{
+		name:    cfg.Name,
+		url:     url,
+		headers: opts.stringMap("headers"),
+		client:  &http.Client{Timeout: opts.seconds("timeout-seconds", defaultAuthorizerTimeout)},
+	}, nil
+}
+
+func (p *httpProvider) Identifier() string { return p.name }
+
+func (p *httpProvider) Authenticate(ctx context.Context, r *http.Request) (*sdkaccess.Result, error) {
+	credential, ok := firstCredential(sdkaccess.Credentials(r))
+	if !ok {
+		return nil, sdkaccess.ErrNoCredentials
+	}
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	body, err := json.Marshal(authorizerRequest{
+		APIKey:   credential.Value,
+		Source:   credential.Source,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		ClientIP: clientIP,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("auth provider %s: %w", p.name, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("auth provider %s: %w", p.name, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, sdkaccess.ErrInvalidCredential
+	default:
+		return nil, fmt.Errorf("auth provider %s: authorizer answered HTTP %d", p.name, resp.StatusCode)
+	}
+
+	var answer authorizerResponse
+	if len(bytes.TrimSpace(raw)) > 0 {
+		if err = json.Unmarshal(raw, &answer); err != nil {
+			return nil, fmt.Errorf("auth provider %s: invalid authorizer response: %w", p.name, err)
+		}
+	}
+	metadata := answer.Metadata
+	if metadata == nil {
+		metadata = make(map[string]string, 1)
+	}
+	metadata["source"] = credential.Source
+	principal := strings.TrimSpace(answer.Principal)
+	if principal == "" {
+		principal = credential.Value
+	}
+	return &sdkaccess.Result{Provider: p.name, Principal: principal, Metadata: metadata}, nil
+}
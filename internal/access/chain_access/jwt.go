@@ -0,0 +1,241 @@
+package chainaccess
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
+	sdkconfig "github.com/router-for-me/CLIProxyAPI/v6/sdk/config"
+)
+
+const defaultJWTLeeway = 60 * time.Second
+
+// jwtProvider accepts bearer JSON Web Tokens signed with a shared secret
+// (HS256/384/512) or the key in public-key-file (RS256/384/512, ES256/384).
+// Tokens must not be expired and, when configured, must match the issuer and
+// audience. Bearer values that are not JWTs are left to the next provider.
+type jwtProvider struct {
+	name      string
+	secret    []byte
+	publicKey crypto.PublicKey
+	issuer    string
+	audience  string
+	claim     string
+	leeway    time.Duration
+	now       func() time.Time
+}
+
+func newJWTProvider(cfg *sdkconfig.AccessProvider, _ *sdkconfig.SDKConfig) (sdkaccess.Provider, error) {
+	opts := options(cfg.Config)
+	p := &jwtProvider{
+		name:     cfg.Name,
+		issuer:   opts.string("issuer"),
+		audience: opts.string("audience"),
+		claim:    opts.string("principal-claim"),
+		leeway:   opts.seconds("leeway-seconds", defaultJWTLeeway),
+		now:      time.Now,
+	}
+	if p.claim == "" {
+		p.claim = "sub"
+	}
+	if secret := opts.string("secret"); secret != "" {
+		p.secret = []byte(secret)
+	}
+	if path := opts.string("public-key-file"); path != "" {
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		p.publicKey = key
+	}
+	if p.secret == nil && p.publicKey == nil {
+		return nil, fmt.Errorf("secret or public-key-file is required")
+	}
+	return p, nil
+}
+
+func (p *jwtProvider) Identifier() string { return p.name }
+
+func (p *jwtProvider) Authenticate(_ context.Context, r *http.Request) (*sdkaccess.Result, error) {
+	var token string
+	for _, credential := range sdkaccess.Credentials(r) {
+		if credential.Source == "authorization" && strings.Count(credential.Value, ".") == 2 {
+			token = credential.Value
+			break
+		}
+	}
+	if token == "" {
+		return nil, sdkaccess.ErrNotHandled
+	}
+	claims, err := p.verify(token)
+	if err != nil {
+		return nil, sdkaccess.ErrInvalidCredential
+	}
+	principal, _ := claims[p.claim].(string)
+	if principal == "" {
+		return nil, sdkaccess.ErrInvalidCredential
+	}
+	metadata := map[string]string{"source": "jwt"}
+	if iss, ok := claims["iss"].(string); ok {
+		metadata["issuer"] = iss
+	}
+	return &sdkaccess.Result{Provider: p.name, Principal: principal, Metadata: metadata}, nil
+}
+
+// verify checks the signature and registered claims of token.
+func (p *jwtProvider) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err = json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	if err = p.verifySignature(header.Alg, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]any
+	if err = json.Unmarshal(rawClaims, &claims); err != nil {
+		return nil, err
+	}
+	now := p.now()
+	if exp, ok := claims["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(p.leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(p.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token not yet valid")
+	}
+	if p.issuer != "" && claims["iss"] != p.issuer {
+		return nil, errors.New("issuer mismatch")
+	}
+	if p.audience != "" && !hasAudience(claims["aud"], p.audience) {
+		return nil, errors.New("audience mismatch")
+	}
+	return claims, nil
+}
+
+func (p *jwtProvider) verifySignature(alg string, signed, signature []byte) error {
+	var (
+		newHash func() hash.Hash
+		kind    crypto.Hash
+	)
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	switch alg[2:] {
+	case "256":
+		newHash, kind = sha256.New, crypto.SHA256
+	case "384":
+		newHash, kind = sha512.New384, crypto.SHA384
+	case "512":
+		newHash, kind = sha512.New, crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+
+	switch alg {
+	case "HS256", "HS384", "HS512":
+		if p.secret == nil {
+			return errors.New("no secret configured")
+		}
+		mac := hmac.New(newHash, p.secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	}
+
+	h := newHash()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch alg {
+	case "RS256", "RS384", "RS512":
+		key, ok := p.publicKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("no RSA key configured")
+		}
+		return rsa.VerifyPKCS1v15(key, kind, digest, signature)
+	case "ES256", "ES384":
+		key, ok := p.publicKey.(*ecdsa.PublicKey)
+		if !ok || len(signature)%2 != 0 {
+			return errors.New("no ECDSA key configured")
+		}
+		half := len(signature) / 2
+		rInt := new(big.Int).SetBytes(signature[:half])
+		sInt := new(big.Int).SetBytes(signature[half:])
+		if !ecdsa.Verify(key, digest, rInt, sInt) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported alg %q", alg)
+}
+
+func hasAudience(aud any, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []any:
+		for _, item := range v {
+			if item == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadPublicKey reads a PEM public key or certificate.
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block", path)
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, errParse := x509.ParseCertificate(block.Bytes)
+		if errParse != nil {
+			return nil, errParse
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
@@ -0,0 +1,89 @@
+package chainaccess
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
+	sdkconfig "github.com/router-for-me/CLIProxyAPI/v6/sdk/config"
+)
+
+// Principals an mtls provider can derive from a client certificate
+const (
+	principalFingerprint = "fingerprint"
+	principalCommonName  = "cn"
+	principalEmail       = "san-email"
+	principalDNS         = "san-dns"
+)
+
+// mtlsProvider accepts requests over connections with a client certificate
+// verified against tls.client-ca. It needs tls.client-auth optional or require.
+type mtlsProvider struct {
+	name      string
+	principal string
+	allowed   map[string]struct{}
+}
+
+func newMTLSProvider(cfg *sdkconfig.AccessProvider, _ *sdkconfig.SDKConfig) (sdkaccess.Provider, error) {
+	opts := options(cfg.Config)
+	principal := opts.string("principal")
+	switch principal {
+	case "":
+		principal = principalFingerprint
+	case principalFingerprint, principalCommonName, principalEmail, principalDNS:
+	default:
+		return nil, fmt.Errorf("principal must be %s, %s, %s or %s", principalFingerprint, principalCommonName, principalEmail, principalDNS)
+	}
+	p := &mtlsProvider{name: cfg.Name, principal: principal}
+	if allowed := opts.strings("allowed"); len(allowed) > 0 {
+		p.allowed = make(map[string]struct{}, len(allowed))
+		for _, value := range allowed {
+			p.allowed[value] = struct{}{}
+		}
+	}
+	return p, nil
+}
+
+func (p *mtlsProvider) Identifier() string { return p.name }
+
+// Authenticate leaves requests without a verified certificate to the next provider.
+func (p *mtlsProvider) Authenticate(_ context.Context, r *http.Request) (*sdkaccess.Result, error) {
+	fingerprint := sdkaccess.ClientCertFingerprint(r)
+	if fingerprint == "" {
+		return nil, sdkaccess.ErrNotHandled
+	}
+	principal := principalOf(r.TLS.PeerCertificates[0], p.principal, fingerprint)
+	if principal == "" {
+		return nil, sdkaccess.ErrInvalidCredential
+	}
+	if p.allowed != nil {
+		if _, ok := p.allowed[principal]; !ok {
+			return nil, sdkaccess.ErrInvalidCredential
+		}
+	}
+	return &sdkaccess.Result{
+		Provider:  p.name,
+		Principal: principal,
+		Metadata:  map[string]string{"source": "client-certificate", "fingerprint": fingerprint},
+	}, nil
+}
+
+func principalOf(cert *x509.Certificate, kind, fingerprint string) string {
+	switch kind {
+	case principalCommonName:
+		return cert.Subject.CommonName
+	case principalEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case principalDNS:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	default:
+		return fingerprint
+	}
+	return ""
+}
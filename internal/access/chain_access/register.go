@@ -0,0 +1,103 @@
+// Package chainaccess provides the authentication chain providers beyond the
+// static api-keys: keys looked up in a database, JSON Web Tokens, verified
+// client certificates and an external HTTP authorizer.
+package chainaccess
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
+)
+
+var registerOnce sync.Once
+
+// Register makes the chain provider types available to the access manager.
+func Register() {
+	registerOnce.Do(func() {
+		sdkaccess.RegisterProvider(config.AuthProviderDatabase, newDatabaseProvider)
+		sdkaccess.RegisterProvider(config.AuthProviderJWT, newJWTProvider)
+		sdkaccess.RegisterProvider(config.AuthProviderMTLS, newMTLSProvider)
+		sdkaccess.RegisterProvider(config.AuthProviderHTTP, newHTTPProvider)
+	})
+}
+
+// options reads provider options from the config map.
+type options map[string]any
+
+func (o options) string(key string) string {
+	if v, ok := o[key].(string); ok {
+		return strings.TrimSpace(v)
+	}
+	return ""
+}
+
+func (o options) required(key string) (string, error) {
+	if v := o.string(key); v != "" {
+		return v, nil
+	}
+	return "", fmt.Errorf("option %q is required", key)
+}
+
+func (o options) strings(key string) []string {
+	switch v := o[key].(type) {
+	case string:
+		if v = strings.TrimSpace(v); v != "" {
+			return []string{v}
+		}
+	case []string:
+		return v
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok && strings.TrimSpace(s) != "" {
+				out = append(out, strings.TrimSpace(s))
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func (o options) stringMap(key string) map[string]string {
+	out := make(map[string]string)
+	switch v := o[key].(type) {
+	case map[string]string:
+		for k, s := range v {
+			out[k] = s
+		}
+	case map[string]any:
+		for k, item := range v {
+			if s, ok := item.(string); ok {
+				out[k] = s
+			}
+		}
+	}
+	return out
+}
+
+func (o options) seconds(key string, fallback time.Duration) time.Duration {
+	switch v := o[key].(type) {
+	case int:
+		if v > 0 {
+			return time.Duration(v) * time.Second
+		}
+	case float64:
+		if v > 0 {
+			return time.Duration(v * float64(time.Second))
+		}
+	}
+	return fallback
+}
+
+// firstCredential returns the API key candidate providers other than api-keys
+// check: the first one the request carries.
+func firstCredential(credentials []sdkaccess.Credential) (sdkaccess.Credential, bool) {
+	if len(credentials) == 0 {
+		return sdkaccess.Credential{}, false
+	}
+	return credentials[0], true
+}
@@ -3,7 +3,6 @@ package configaccess
 import (
 	"context"
 	"net/http"
-	"strings"
 	"sync"
 
 	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
@@ -53,42 +52,17 @@ func (p *provider) Authenticate(_ context.Context, r *http.Request) (*sdkaccess.
 	if len(p.keys) == 0 {
 		return nil, sdkaccess.ErrNotHandled
 	}
-	authHeader := r.Header.Get("Authorization")
-	authHeaderGoogle := r.Header.Get("X-Goog-Api-Key")
-	authHeaderAnthropic := r.Header.Get("X-Api-Key")
-	queryKey := ""
-	queryAuthToken := ""
-	if r.URL != nil {
-		queryKey = r.URL.Query().Get("key")
-		queryAuthToken = r.URL.Query().Get("auth_token")
-	}
-	if authHeader == "" && authHeaderGoogle == "" && authHeaderAnthropic == "" && queryKey == "" && queryAuthToken == "" {
+	candidates := sdkaccess.Credentials(r)
+	if len(candidates) == 0 {
 		return nil, sdkaccess.ErrNoCredentials
 	}
-
-	apiKey := extractBearerToken(authHeader)
-
-	candidates := []struct {
-		value  string
-		source string
-	}{
-		{apiKey, "authorization"},
-		{authHeaderGoogle, "x-goog-api-key"},
-		{authHeaderAnthropic, "x-api-key"},
-		{queryKey, "query-key"},
-		{queryAuthToken, "query-auth-token"},
-	}
-
 	for _, candidate := range candidates {
-		if candidate.value == "" {
-			continue
-		}
-		if _, ok := p.keys[candidate.value]; ok {
+		if _, ok := p.keys[candidate.Value]; ok {
 			return &sdkaccess.Result{
 				Provider:  p.Identifier(),
-				Principal: candidate.value,
+				Principal: candidate.Value,
 				Metadata: map[string]string{
-					"source": candidate.source,
+					"source": candidate.Source,
 				},
 			}, nil
 		}
@@ -96,17 +70,3 @@ func (p *provider) Authenticate(_ context.Context, r *http.Request) (*sdkaccess.
 
 	return nil, sdkaccess.ErrInvalidCredential
 }
-
-func extractBearerToken(header string) string {
-	if header == "" {
-		return ""
-	}
-	parts := strings.SplitN(header, " ", 2)
-	if len(parts) != 2 {
-		return header
-	}
-	if strings.ToLower(parts[0]) != "bearer" {
-		return header
-	}
-	return strings.TrimSpace(parts[1])
-}
@@ -2,7 +2,10 @@ package access
 
 import (
 	"fmt"
+	"io"
+	"maps"
 	"reflect"
+	"slices"
 	"sort"
 	"strings"
 
@@ -149,6 +152,7 @@ func ApplyAccessProviders(manager *sdkaccess.Manager, oldCfg, newCfg *config.Con
 	}
 
 	manager.SetProviders(providers)
+	closeReplacedProviders(existing, providers)
 
 	if len(added)+len(updated)+len(removed) > 0 {
 		log.Debugf("auth providers reconciled (added=%d updated=%d removed=%d)", len(added), len(updated), len(removed))
@@ -160,11 +164,31 @@ func ApplyAccessProviders(manager *sdkaccess.Manager, oldCfg, newCfg *config.Con
 	return false, nil
 }
 
+// closeReplacedProviders closes providers of old that are not part of current,
+// such as a database provider whose options changed.
+func closeReplacedProviders(old, current []sdkaccess.Provider) {
+	for _, provider := range old {
+		closer, ok := provider.(io.Closer)
+		if !ok || slices.Contains(current, provider) {
+			continue
+		}
+		if err := closer.Close(); err != nil {
+			log.Warnf("failed to close auth provider %s: %v", provider.Identifier(), err)
+		}
+	}
+}
+
 func accessProviderMap(cfg *config.Config) map[string]*sdkConfig.AccessProvider {
 	result := make(map[string]*sdkConfig.AccessProvider)
 	if cfg == nil {
 		return result
 	}
+	if len(cfg.AuthChain.Providers) > 0 {
+		for _, providerCfg := range authChainEntries(cfg) {
+			result[providerIdentifier(providerCfg)] = providerCfg
+		}
+		return result
+	}
 	for i := range cfg.Access.Providers {
 		providerCfg := &cfg.Access.Providers[i]
 		if providerCfg.Type == "" {
@@ -187,6 +211,9 @@ func accessProviderMap(cfg *config.Config) map[string]*sdkConfig.AccessProvider
 }
 
 func collectProviderEntries(cfg *config.Config) []*sdkConfig.AccessProvider {
+	if len(cfg.AuthChain.Providers) > 0 {
+		return authChainEntries(cfg)
+	}
 	entries := make([]*sdkConfig.AccessProvider, 0, len(cfg.Access.Providers))
 	for i := range cfg.Access.Providers {
 		providerCfg := &cfg.Access.Providers[i]
@@ -205,6 +232,67 @@ func collectProviderEntries(cfg *config.Config) []*sdkConfig.AccessProvider {
 	return entries
 }
 
+// Cache defaults of the authentication chain in seconds
+const (
+	defaultAuthCacheTTL         = 60
+	defaultAuthNegativeCacheTTL = 5
+)
+
+// authChainEntries converts the authentication chain into provider entries.
+// The api-keys provider reads the top-level api-keys; providers other than
+// api-keys and mtls get the chain cache settings unless they override them.
+func authChainEntries(cfg *config.Config) []*sdkConfig.AccessProvider {
+	chain := cfg.AuthChain
+	ttl := chain.CacheTTLSeconds
+	if ttl == 0 {
+		ttl = defaultAuthCacheTTL
+	}
+	negativeTTL := chain.NegativeCacheTTLSeconds
+	if negativeTTL <= 0 {
+		negativeTTL = defaultAuthNegativeCacheTTL
+	}
+	entries := make([]*sdkConfig.AccessProvider, 0, len(chain.Providers))
+	seen := make(map[string]struct{}, len(chain.Providers))
+	for _, providerCfg := range chain.Providers {
+		typ := strings.ToLower(strings.TrimSpace(providerCfg.Type))
+		if typ == "" {
+			continue
+		}
+		name := strings.TrimSpace(providerCfg.Name)
+		if name == "" {
+			name = typ
+		}
+		if _, dup := seen[name]; dup {
+			log.Warnf("auth-chain: skipping duplicate provider name %q", name)
+			continue
+		}
+		seen[name] = struct{}{}
+		entry := &sdkConfig.AccessProvider{Name: name, Type: typ, Config: maps.Clone(providerCfg.Config)}
+		if typ == config.AuthProviderAPIKeys {
+			entry.Type = sdkConfig.AccessProviderTypeConfigAPIKey
+			entry.APIKeys = slices.Clone(cfg.APIKeys)
+			entries = append(entries, entry)
+			continue
+		}
+		providerTTL := 0
+		if typ != config.AuthProviderMTLS {
+			providerTTL = ttl
+		}
+		if providerCfg.CacheTTLSeconds != 0 {
+			providerTTL = providerCfg.CacheTTLSeconds
+		}
+		if providerTTL > 0 {
+			if entry.Config == nil {
+				entry.Config = make(map[string]any, 2)
+			}
+			entry.Config[sdkaccess.CacheTTLOption] = providerTTL
+			entry.Config[sdkaccess.NegativeCacheTTLOption] = min(negativeTTL, providerTTL)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
 func providerIdentifier(provider *sdkConfig.AccessProvider) string {
 	if provider == nil {
 		return ""
@@ -0,0 +1,28 @@
+// Package admindashboard serves the embedded admin dashboard, a single page
+// showing device bindings, banned keys, live request rate and usage per key.
+// The page holds no data itself; it calls the management API with the remote
+// management key the operator enters, so it is safe to serve unauthenticated.
+package admindashboard
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Path is where the dashboard is served.
+const Path = "/admin"
+
+//go:embed index.html
+var page []byte
+
+// Handler serves the dashboard page.
+func Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Frame-Options", "DENY")
+		c.Header("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+	}
+}
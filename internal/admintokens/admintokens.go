@@ -0,0 +1,333 @@
+// Package admintokens manages scoped tokens for the management API. Unlike the
+// single management key, each token carries a scope limiting which routes it
+// may call, an optional expiry, and can be revoked on its own. Only SHA-256
+// hashes of the tokens are stored, in a file inside the auth directory.
+package admintokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileName is the token file inside the auth directory.
+const FileName = "management-tokens.json"
+
+// Prefix starts every minted token so it is recognisable in logs and configs.
+const Prefix = "cpa-mt-"
+
+// Scopes a token can be minted with
+const (
+	// ScopeRead allows the read routes in readRoutes only.
+	ScopeRead = "read-only"
+	// ScopeUnban allows read requests plus lifting key and device bans.
+	ScopeUnban = "unban"
+	// ScopeFull allows every management route, including minting tokens.
+	ScopeFull = "full"
+)
+
+// Token states reported by List
+const (
+	StateActive  = "active"
+	StateExpired = "expired"
+)
+
+// randomBytes is the entropy of a minted token.
+const randomBytes = 24
+
+// readRoutes are the route patterns, relative to the management group, a token
+// with ScopeRead or ScopeUnban may GET or HEAD. GETs that return secrets (config,
+// credentials, provider keys, request logs) or have side effects (OAuth flows,
+// self tests) are left out and need ScopeFull, as are listings that carry raw
+// client API keys (bindings, bans, events, agents, usage, spend, search,
+// boosts). Per-key lookups stay: they only echo the key the caller passed.
+var readRoutes = map[string]struct{}{
+	"/alerts":                                   {},
+	"/ampcode/force-model-mappings":             {},
+	"/ampcode/model-mappings":                   {},
+	"/ampcode/restrict-management-to-localhost": {},
+	"/ampcode/upstream-url":                     {},
+	"/analytics/schema":                         {},
+	"/api-versions":                             {},
+	"/auth-files":                               {},
+	"/auth-files/models":                        {},
+	"/auth-rotations":                           {},
+	"/backups":                                  {},
+	"/canary":                                   {},
+	"/circuit-breakers":                         {},
+	"/cost-export":                              {},
+	"/debug":                                    {},
+	"/device-bindings/activity":                 {},
+	"/device-bindings/devices":                  {},
+	"/device-bindings/history":                  {},
+	"/device-bindings/metadata":                 {},
+	"/device-bindings/policy":                   {},
+	"/device-store/dual-write":                  {},
+	"/errors/recent":                            {},
+	"/fair-share/queues":                        {},
+	"/force-model-prefix":                       {},
+	"/ip-access":                                {},
+	"/logging-to-file":                          {},
+	"/logs-max-total-size-mb":                   {},
+	"/max-retry-interval":                       {},
+	"/oauth-excluded-models":                    {},
+	"/oauth-model-mappings":                     {},
+	"/payload-stats":                            {},
+	"/policy/effective":                         {},
+	"/quota-exceeded/switch-preview-model":      {},
+	"/quota-exceeded/switch-project":            {},
+	"/request-log":                              {},
+	"/request-retry":                            {},
+	"/routing/strategy":                         {},
+	"/upstream-health":                          {},
+	"/upstream-rate-limits":                     {},
+	"/usage-statistics-enabled":                 {},
+	"/ws-auth":                                  {},
+}
+
+// unbanRoutes are the write routes, relative to the management group, a token
+// with ScopeUnban may call.
+var unbanRoutes = map[string]struct{}{
+	"POST /device-bindings/unban":        {},
+	"POST /device-bindings/unban-device": {},
+}
+
+// ErrInvalidScope is returned when minting a token with an unknown scope.
+var ErrInvalidScope = errors.New("scope must be read-only, unban or full")
+
+// Token is the stored description of a minted token.
+type Token struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	Hash      string     `json:"hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// LastUsedAt is kept in memory and written with the next mint or revoke.
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Info is a token as listed by the management API, without its hash.
+type Info struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	State      string     `json:"state"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// ValidScope reports whether scope is one a token can be minted with.
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeRead, ScopeUnban, ScopeFull:
+		return true
+	}
+	return false
+}
+
+// Allows reports whether a token of scope may call route, the request method
+// and path relative to the management group, e.g. "POST /device-bindings/unban".
+func Allows(scope, method, path string) bool {
+	switch scope {
+	case ScopeFull:
+		return true
+	case ScopeRead, ScopeUnban:
+		if method == http.MethodGet || method == http.MethodHead {
+			_, ok := readRoutes[path]
+			return ok
+		}
+		if scope == ScopeUnban {
+			_, ok := unbanRoutes[method+" "+path]
+			return ok
+		}
+	}
+	return false
+}
+
+// Manager holds the tokens of one server.
+type Manager struct {
+	path string
+
+	mu     sync.Mutex
+	tokens map[string]*Token // by ID
+	now    func() time.Time
+}
+
+// New loads the tokens stored in authDir. A missing file means no tokens.
+func New(authDir string) (*Manager, error) {
+	m := &Manager{path: filepath.Join(authDir, FileName), tokens: make(map[string]*Token), now: time.Now}
+	raw, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, fmt.Errorf("read management tokens: %w", err)
+	}
+	var stored []*Token
+	if err = json.Unmarshal(raw, &stored); err != nil {
+		return m, fmt.Errorf("parse management tokens: %w", err)
+	}
+	for _, token := range stored {
+		if token != nil && token.ID != "" && token.Hash != "" {
+			m.tokens[token.ID] = token
+		}
+	}
+	return m, nil
+}
+
+// Mint creates a token and returns its secret, which is not stored and cannot
+// be shown again. A zero ttl never expires.
+func (m *Manager) Mint(name, scope string, ttl time.Duration) (string, Info, error) {
+	if !ValidScope(scope) {
+		return "", Info{}, ErrInvalidScope
+	}
+	secret, err := randomHex(randomBytes)
+	if err != nil {
+		return "", Info{}, err
+	}
+	id, err := randomHex(6)
+	if err != nil {
+		return "", Info{}, err
+	}
+	secret = Prefix + secret
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token := &Token{
+		ID:        id,
+		Name:      strings.TrimSpace(name),
+		Scope:     scope,
+		Hash:      hash(secret),
+		CreatedAt: m.now().UTC(),
+	}
+	if ttl > 0 {
+		expiresAt := token.CreatedAt.Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+	m.tokens[id] = token
+	if err = m.saveLocked(); err != nil {
+		delete(m.tokens, id)
+		return "", Info{}, err
+	}
+	return secret, m.infoLocked(token), nil
+}
+
+// Revoke deletes a token. It reports whether the token existed.
+func (m *Manager) Revoke(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.tokens[id]
+	if !ok {
+		return false, nil
+	}
+	delete(m.tokens, id)
+	if err := m.saveLocked(); err != nil {
+		m.tokens[id] = token
+		return false, err
+	}
+	return true, nil
+}
+
+// List returns every token, oldest first.
+func (m *Manager) List() []Info {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Info, 0, len(m.tokens))
+	for _, token := range m.tokens {
+		out = append(out, m.infoLocked(token))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Verify returns the token matching secret when it exists and has not expired.
+func (m *Manager) Verify(secret string) (Info, bool) {
+	if m == nil || !strings.HasPrefix(secret, Prefix) {
+		return Info{}, false
+	}
+	sum := hash(secret)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now().UTC()
+	for _, token := range m.tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(sum)) != 1 {
+			continue
+		}
+		if token.ExpiresAt != nil && !now.Before(*token.ExpiresAt) {
+			return Info{}, false
+		}
+		token.LastUsedAt = &now
+		return m.infoLocked(token), true
+	}
+	return Info{}, false
+}
+
+func (m *Manager) infoLocked(token *Token) Info {
+	state := StateActive
+	if token.ExpiresAt != nil && !m.now().Before(*token.ExpiresAt) {
+		state = StateExpired
+	}
+	return Info{
+		ID:         token.ID,
+		Name:       token.Name,
+		Scope:      token.Scope,
+		State:      state,
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+	}
+}
+
+// saveLocked writes the tokens atomically. Callers must hold m.mu.
+func (m *Manager) saveLocked() error {
+	stored := make([]*Token, 0, len(m.tokens))
+	for _, token := range m.tokens {
+		stored = append(stored, token)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].ID < stored[j].ID })
+	raw, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(m.path), 0o700); err != nil {
+		return fmt.Errorf("save management tokens: %w", err)
+	}
+	tmp := m.path + ".tmp"
+	if err = os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("save management tokens: %w", err)
+	}
+	if err = os.Rename(tmp, m.path); err != nil {
+		return fmt.Errorf("save management tokens: %w", err)
+	}
+	return nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate management token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
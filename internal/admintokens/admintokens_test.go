@@ -0,0 +1,80 @@
+package admintokens
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMintVerifyRevokeAndReload(t *testing.T) {
+	dir := t.TempDir()
+	m, err := New(dir)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	secret, info, err := m.Mint("support", ScopeUnban, time.Hour)
+	if err != nil {
+		t.Fatalf("mint: %v", err)
+	}
+	if got, ok := m.Verify(secret); !ok || got.ID != info.ID || got.Scope != ScopeUnban {
+		t.Fatalf("expected the minted token to verify, got %+v", got)
+	}
+	if _, ok := m.Verify(secret + "x"); ok {
+		t.Fatal("expected a wrong secret to be rejected")
+	}
+
+	reloaded, err := New(dir)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, ok := reloaded.Verify(secret); !ok {
+		t.Fatal("expected the token to survive a reload")
+	}
+	reloaded.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, ok := reloaded.Verify(secret); ok {
+		t.Fatal("expected an expired token to be rejected")
+	}
+	if list := reloaded.List(); len(list) != 1 || list[0].State != StateExpired {
+		t.Fatalf("expected one expired token, got %+v", list)
+	}
+
+	if revoked, _ := m.Revoke(info.ID); !revoked {
+		t.Fatal("expected revoke to find the token")
+	}
+	if _, ok := m.Verify(secret); ok {
+		t.Fatal("expected a revoked token to be rejected")
+	}
+	if _, _, err = m.Mint("x", "admin", 0); err != ErrInvalidScope {
+		t.Fatalf("expected ErrInvalidScope, got %v", err)
+	}
+}
+
+func TestAllows(t *testing.T) {
+	cases := []struct {
+		scope, method, path string
+		want                bool
+	}{
+		{ScopeRead, http.MethodGet, "/device-bindings/devices", true},
+		{ScopeRead, http.MethodGet, "/device-bindings", false},
+		{ScopeRead, http.MethodGet, "/events", false},
+		{ScopeRead, http.MethodGet, "/companion/agents", false},
+		{ScopeRead, http.MethodPost, "/device-bindings/unban", false},
+		{ScopeRead, http.MethodGet, "/auth-files/download", false},
+		{ScopeRead, http.MethodGet, "/config.yaml", false},
+		{ScopeRead, http.MethodGet, "/config", false},
+		{ScopeRead, http.MethodGet, "/keys", false},
+		{ScopeRead, http.MethodGet, "/codex-auth-url", false},
+		{ScopeUnban, http.MethodGet, "/export", false},
+		{ScopeUnban, http.MethodHead, "/device-bindings/devices", true},
+		{ScopeFull, http.MethodGet, "/config.yaml", true},
+		{ScopeUnban, http.MethodPost, "/device-bindings/unban", true},
+		{ScopeUnban, http.MethodPost, "/device-bindings/ban", false},
+		{ScopeUnban, http.MethodPost, "/admin-tokens", false},
+		{ScopeFull, http.MethodDelete, "/admin-tokens", true},
+	}
+	for _, tc := range cases {
+		if got := Allows(tc.scope, tc.method, tc.path); got != tc.want {
+			t.Errorf("Allows(%s, %s %s) = %v, want %v", tc.scope, tc.method, tc.path, got, tc.want)
+		}
+	}
+}
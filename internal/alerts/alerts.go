@@ -0,0 +1,337 @@
+// Package alerts evaluates declarative alert rules against the proxy's own
+// metrics registry and publishes firing and resolved alerts on the event bus,
+// where the webhook, Slack-compatible, Discord and Telegram integrations pick
+// them up. It gives operators basic alerting without Prometheus and
+// Alertmanager.
+package alerts
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	defaultSeverity = "warning"
+)
+
+// Rule states reported by Status
+const (
+	StateInactive = "inactive"
+	StatePending  = "pending"
+	StateFiring   = "firing"
+)
+
+// RuleStatus describes the current state of one rule.
+type RuleStatus struct {
+	Name     string     `json:"name"`
+	Expr     string     `json:"expr"`
+	Severity string     `json:"severity"`
+	State    string     `json:"state"`
+	Value    *float64   `json:"value,omitempty"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+type rule struct {
+	name     string
+	expr     string
+	severity string
+	summary  string
+	hold     time.Duration
+	root     node
+
+	state string
+	since time.Time
+}
+
+// Engine evaluates the configured rules on an interval.
+type Engine struct {
+	registry  *metrics.Registry
+	interval  time.Duration
+	rules     []*rule
+	selectors []*selectorNode
+	history   *history
+	publish   func(events.Event)
+	now       func() time.Time
+
+	mu sync.RWMutex
+}
+
+// New creates an engine from configuration. It returns nil when alerting is
+// disabled or no rule is valid; invalid rules are logged and skipped.
+func New(cfg config.AlertsConfig, registry *metrics.Registry) *Engine {
+	if !cfg.Enabled {
+		return nil
+	}
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	e := &Engine{
+		registry: registry,
+		interval: interval,
+		publish:  events.Publish,
+		now:      time.Now,
+	}
+	seen := make(map[string]struct{}, len(cfg.Rules))
+	var longest time.Duration
+	for _, rc := range cfg.Rules {
+		name := strings.TrimSpace(rc.Name)
+		if name == "" {
+			log.Warnf("alerts: skipping rule without a name (expr %q)", rc.Expr)
+			continue
+		}
+		if _, dup := seen[name]; dup {
+			log.Warnf("alerts: skipping duplicate rule %q", name)
+			continue
+		}
+		root, selectors, err := parseExpr(rc.Expr)
+		if err != nil {
+			log.Warnf("alerts: skipping rule %q: %v", name, err)
+			continue
+		}
+		seen[name] = struct{}{}
+		severity := strings.ToLower(strings.TrimSpace(rc.Severity))
+		if severity == "" {
+			severity = defaultSeverity
+		}
+		e.rules = append(e.rules, &rule{
+			name:     name,
+			expr:     strings.TrimSpace(rc.Expr),
+			severity: severity,
+			summary:  strings.TrimSpace(rc.Summary),
+			hold:     time.Duration(rc.For) * time.Second,
+			root:     root,
+			state:    StateInactive,
+		})
+		for _, sel := range selectors {
+			e.selectors = append(e.selectors, sel)
+			if sel.window > longest {
+				longest = sel.window
+			}
+		}
+	}
+	if len(e.rules) == 0 {
+		log.Warn("alerts: enabled but no valid rules are configured, skipping")
+		return nil
+	}
+	e.history = newHistory(longest + interval)
+	return e
+}
+
+// Run evaluates the rules on every interval until ctx is done.
+func (e *Engine) Run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	log.Infof("alerts: evaluating %d rule(s) every %s", len(e.rules), e.interval)
+	e.Evaluate()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.Evaluate()
+		}
+	}
+}
+
+// Evaluate records the current metric values and evaluates every rule once.
+func (e *Engine) Evaluate() {
+	now := e.now()
+	families := e.registry.Gather()
+
+	e.mu.Lock()
+	for _, sel := range e.selectors {
+		e.history.record(sel, families, now)
+	}
+	var notifications []events.Event
+	for _, r := range e.rules {
+		active := eval(r.root, e.history) == 1
+		switch {
+		case active && r.state == StateInactive:
+			r.state, r.since = StatePending, now
+			fallthrough
+		case active && r.state == StatePending:
+			if now.Sub(r.since) >= r.hold {
+				r.state, r.since = StateFiring, now
+				notifications = append(notifications, r.event(events.TypeAlertFiring, e.history))
+			}
+		case !active && r.state == StateFiring:
+			r.state, r.since = StateInactive, time.Time{}
+			notifications = append(notifications, r.event(events.TypeAlertResolved, e.history))
+		case !active:
+			r.state, r.since = StateInactive, time.Time{}
+		}
+	}
+	e.mu.Unlock()
+
+	for _, ev := range notifications {
+		if ev.Type == events.TypeAlertFiring {
+			log.Warnf("alerts: %s firing (%s)", ev.Data["rule"], ev.Data["expr"])
+		} else {
+			log.Infof("alerts: %s resolved", ev.Data["rule"])
+		}
+		e.publish(ev)
+	}
+}
+
+// event builds the notification for r. The reported value is the left-hand
+// side of the rule's comparison, i.e. the measured quantity.
+func (r *rule) event(t events.Type, h *history) events.Event {
+	data := map[string]any{
+		"rule":     r.name,
+		"expr":     r.expr,
+		"severity": r.severity,
+	}
+	if cmp, ok := r.root.(*binaryNode); ok {
+		if value := eval(cmp.left, h); !math.IsNaN(value) && !math.IsInf(value, 0) {
+			data["value"] = value
+		}
+	}
+	return events.Event{Type: t, Actor: "system", Reason: r.summary, Data: data}
+}
+
+// Status returns the state of every rule, sorted by name.
+func (e *Engine) Status() []RuleStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make([]RuleStatus, 0, len(e.rules))
+	for _, r := range e.rules {
+		status := RuleStatus{Name: r.name, Expr: r.expr, Severity: r.severity, State: r.state}
+		if cmp, ok := r.root.(*binaryNode); ok {
+			if value := eval(cmp.left, e.history); !math.IsNaN(value) && !math.IsInf(value, 0) {
+				status.Value = &value
+			}
+		}
+		if !r.since.IsZero() {
+			since := r.since
+			status.Since = &since
+		}
+		out = append(out, status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// point is the aggregated value of a selector at one evaluation. For
+// histograms value is the observation count and sum and buckets are set.
+type point struct {
+	time    time.Time
+	value   float64
+	sum     float64
+	bounds  []float64
+	buckets []uint64
+}
+
+// history keeps recent points per selector for the range functions.
+type history struct {
+	retention time.Duration
+	points    map[string][]point
+}
+
+func newHistory(retention time.Duration) *history {
+	return &history{retention: retention, points: make(map[string][]point)}
+}
+
+// record appends the current value of sel, once per evaluation, and drops
+// points older than the retention. A registered metric without matching
+// series counts as zero; an unknown metric records nothing.
+func (h *history) record(sel *selectorNode, families []metrics.Family, now time.Time) {
+	points := h.points[sel.key]
+	if n := len(points); n > 0 && points[n-1].time.Equal(now) {
+		return
+	}
+	if p, ok := aggregate(sel, families, now); ok {
+		points = append(points, p)
+	}
+	cutoff := now.Add(-h.retention)
+	drop := 0
+	for drop < len(points)-1 && points[drop].time.Before(cutoff) {
+		drop++
+	}
+	h.points[sel.key] = points[drop:]
+}
+
+func (h *history) latest(key string) (point, bool) {
+	points := h.points[key]
+	if len(points) == 0 {
+		return point{}, false
+	}
+	return points[len(points)-1], true
+}
+
+// window returns the oldest point within d of the latest one and the latest
+// point. It needs at least two points.
+func (h *history) window(key string, d time.Duration) (point, point, bool) {
+	points := h.points[key]
+	if len(points) < 2 {
+		return point{}, point{}, false
+	}
+	last := points[len(points)-1]
+	start := last.time.Add(-d)
+	for _, p := range points[:len(points)-1] {
+		if !p.time.Before(start) {
+			return p, last, true
+		}
+	}
+	return point{}, point{}, false
+}
+
+func aggregate(sel *selectorNode, families []metrics.Family, now time.Time) (point, bool) {
+	for _, family := range families {
+		if family.Name != sel.metric {
+			continue
+		}
+		p := point{time: now}
+	samples:
+		for _, sample := range family.Samples {
+			for _, m := range sel.matchers {
+				if !m.matches(sample.Labels) {
+					continue samples
+				}
+			}
+			if family.Kind != metrics.KindHistogram {
+				p.value += sample.Value
+				continue
+			}
+			p.value += float64(sample.Count)
+			p.sum += sample.Sum
+			if p.buckets == nil {
+				p.bounds = sample.Bounds
+				p.buckets = make([]uint64, len(sample.BucketCounts))
+			}
+			if len(sample.BucketCounts) == len(p.buckets) {
+				for i, count := range sample.BucketCounts {
+					p.buckets[i] += count
+				}
+			}
+		}
+		return p, true
+	}
+	return point{}, false
+}
+
+// RegisterRoutes registers the alert management routes.
+func (e *Engine) RegisterRoutes(group *gin.RouterGroup) {
+	group.GET("/alerts", e.GetAlerts)
+}
+
+// GetAlerts returns the state of every alert rule
+// GET /v0/management/alerts
+func (e *Engine) GetAlerts(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"rules": e.Status()})
+}
@@ -0,0 +1,153 @@
+package alerts
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+)
+
+func newTestEngine(t *testing.T, registry *metrics.Registry, rules ...config.AlertRule) (*Engine, *time.Time, *[]events.Event) {
+	t.Helper()
+	engine := New(config.AlertsConfig{Enabled: true, Interval: 10, Rules: rules}, registry)
+	if engine == nil {
+		t.Fatal("expected an engine")
+	}
+	now := time.Unix(1_700_000_000, 0)
+	var published []events.Event
+	engine.now = func() time.Time { return now }
+	engine.publish = func(ev events.Event) { published = append(published, ev) }
+	return engine, &now, &published
+}
+
+func TestParseExprRejectsInvalidRules(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"rate(x[5m])",
+		"x[5m] > 1",
+		"rate(x) > 1",
+		"quantile(2, h[5m]) > 1",
+		`x{status=~"("} > 1`,
+		"x > ",
+	} {
+		if _, _, err := parseExpr(expr); err == nil {
+			t.Errorf("expected %q to be rejected", expr)
+		}
+	}
+	if _, selectors, err := parseExpr(`rate(x{status=~"5.."}[5m]) / rate(x[5m]) > 0.05`); err != nil || len(selectors) != 2 {
+		t.Fatalf("valid expression: selectors=%d err=%v", len(selectors), err)
+	}
+}
+
+func TestRuleFiresAfterHoldAndResolves(t *testing.T) {
+	registry := metrics.NewRegistry()
+	requests := registry.NewCounterVec("requests_total", "", "status")
+	engine, now, published := newTestEngine(t, registry, config.AlertRule{
+		Name:     "errors",
+		Expr:     `rate(requests_total{status=~"5.."}[1m]) / rate(requests_total[1m]) > 0.5`,
+		For:      20,
+		Severity: "Critical",
+	})
+
+	step := func(ok, failed float64) {
+		requests.Add(ok, "200")
+		requests.Add(failed, "502")
+		engine.Evaluate()
+		*now = now.Add(10 * time.Second)
+	}
+
+	step(1, 0)
+	step(0, 10) // pending
+	if state := engine.Status()[0].State; state != StatePending {
+		t.Fatalf("state = %s, want pending", state)
+	}
+	step(0, 10)
+	step(0, 10) // held for 20s
+	if len(*published) != 1 || (*published)[0].Type != events.TypeAlertFiring {
+		t.Fatalf("published = %+v, want one firing event", *published)
+	}
+	if severity := (*published)[0].Data["severity"]; severity != "critical" {
+		t.Fatalf("severity = %v", severity)
+	}
+	for i := 0; i < 7; i++ {
+		step(10, 0)
+	}
+	if len(*published) != 2 || (*published)[1].Type != events.TypeAlertResolved {
+		t.Fatalf("published = %+v, want a resolved event", *published)
+	}
+	if state := engine.Status()[0].State; state != StateInactive {
+		t.Fatalf("state = %s, want inactive", state)
+	}
+}
+
+func TestIncreaseCountsFromZeroAndSurvivesReset(t *testing.T) {
+	registry := metrics.NewRegistry()
+	bans := registry.NewCounterVec("bans_total", "")
+	engine, now, published := newTestEngine(t, registry, config.AlertRule{Name: "bans", Expr: "increase(bans_total[1m]) >= 3"})
+
+	engine.Evaluate()
+	*now = now.Add(10 * time.Second)
+	bans.Add(3)
+	engine.Evaluate()
+	if len(*published) != 1 {
+		t.Fatalf("expected the first bans to fire the rule, got %+v", *published)
+	}
+	if value := (*published)[0].Data["value"]; value != 3.0 {
+		t.Fatalf("value = %v, want 3", value)
+	}
+
+	if got := counterDelta(10, 4); got != 4 {
+		t.Fatalf("counterDelta after reset = %v, want 4", got)
+	}
+}
+
+func TestQuantileAndAverageOverHistogram(t *testing.T) {
+	registry := metrics.NewRegistry()
+	latency := registry.NewHistogramVec("latency_seconds", "", []float64{1, 2, 4})
+	engine, now, _ := newTestEngine(t, registry,
+		config.AlertRule{Name: "p50", Expr: "quantile(0.5, latency_seconds[1m]) > 1"},
+		config.AlertRule{Name: "mean", Expr: "avg(latency_seconds[1m]) > 100"},
+	)
+	engine.Evaluate()
+	*now = now.Add(10 * time.Second)
+	for _, v := range []float64{0.5, 1.5, 1.5, 3} {
+		latency.Observe(v)
+	}
+	engine.Evaluate()
+
+	status := engine.Status()
+	if status[1].Name != "p50" || status[1].State != StateFiring || status[1].Value == nil || *status[1].Value != 1.5 {
+		t.Fatalf("p50 status = %+v", status[1])
+	}
+	if status[0].Name != "mean" || status[0].State != StateInactive || *status[0].Value != 1.625 {
+		t.Fatalf("mean status = %+v", status[0])
+	}
+}
+
+func TestMissingDataNeverFires(t *testing.T) {
+	registry := metrics.NewRegistry()
+	engine, _, published := newTestEngine(t, registry,
+		config.AlertRule{Name: "unknown", Expr: "unknown_metric < 1"},
+		config.AlertRule{Name: "division", Expr: "1 / 0 > 0"},
+	)
+	engine.Evaluate()
+	if len(*published) != 0 {
+		t.Fatalf("published = %+v", *published)
+	}
+	if value := eval(&binaryNode{op: "/", left: &numberNode{1}, right: &numberNode{0}}, engine.history); !math.IsNaN(value) {
+		t.Fatalf("division by zero = %v, want NaN", value)
+	}
+}
+
+func TestNewSkipsInvalidRules(t *testing.T) {
+	cfg := config.AlertsConfig{Enabled: true, Rules: []config.AlertRule{{Name: "bad", Expr: "rate(x) > 1"}}}
+	if New(cfg, metrics.NewRegistry()) != nil {
+		t.Fatal("expected no engine when every rule is invalid")
+	}
+	if New(config.AlertsConfig{}, metrics.NewRegistry()) != nil {
+		t.Fatal("expected no engine when disabled")
+	}
+}
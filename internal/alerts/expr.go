@@ -0,0 +1,497 @@
+package alerts
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The rule expression language is a small PromQL subset:
+//
+//	expr     = sum [ ("=="|"!="|">"|">="|"<"|"<=") sum ]
+//	sum      = product { ("+"|"-") product }
+//	product  = unary { ("*"|"/") unary }
+//	unary    = number | selector | call | "(" sum ")"
+//	selector = metric [ "{" label op "value" { "," label op "value" } "}" ] [ "[" duration "]" ]
+//	call     = ("rate"|"increase"|"avg") "(" selector "[" duration "]" ")"
+//	         | "quantile" "(" number "," selector "[" duration "]" ")"
+//
+// A selector sums all matching samples; for histograms it yields the
+// observation count. Label ops are =, !=, =~ and !~ (anchored regular
+// expressions). rate and increase work on counters, avg and quantile on
+// histograms, all over the given look-back window.
+
+// node is a parsed expression.
+type node interface{}
+
+type numberNode struct{ value float64 }
+
+type matcher struct {
+	label string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (m matcher) matches(labels map[string]string) bool {
+	v := labels[m.label]
+	switch m.op {
+	case "=":
+		return v == m.value
+	case "!=":
+		return v != m.value
+	case "=~":
+		return m.re.MatchString(v)
+	case "!~":
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+type selectorNode struct {
+	metric   string
+	matchers []matcher
+	window   time.Duration
+	// key identifies the selector's series in the evaluation history.
+	key string
+}
+
+type callNode struct {
+	fn       string
+	quantile float64
+	selector *selectorNode
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+// parseExpr parses a rule expression. The top level must be a comparison.
+func parseExpr(input string) (node, []*selectorNode, error) {
+	p := &parser{input: input}
+	n, err := p.parseComparison()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, nil, fmt.Errorf("unexpected %q at offset %d", p.input[p.pos:], p.pos)
+	}
+	if b, ok := n.(*binaryNode); !ok || !isComparison(b.op) {
+		return nil, nil, fmt.Errorf("expression must compare a value with a threshold, e.g. rate(x[5m]) > 1")
+	}
+	var selectors []*selectorNode
+	collectSelectors(n, &selectors)
+	return n, selectors, nil
+}
+
+func collectSelectors(n node, out *[]*selectorNode) {
+	switch v := n.(type) {
+	case *selectorNode:
+		*out = append(*out, v)
+	case *callNode:
+		*out = append(*out, v.selector)
+	case *binaryNode:
+		collectSelectors(v.left, out)
+		collectSelectors(v.right, out)
+	}
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", ">", ">=", "<", "<=":
+		return true
+	}
+	return false
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume skips whitespace and then token, reporting whether it was present.
+func (p *parser) consume(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(token string) error {
+	if !p.consume(token) {
+		return fmt.Errorf("expected %q at offset %d", token, p.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", ">=", "<=", ">", "<"} {
+		if p.consume(op) {
+			right, errRight := p.parseSum()
+			if errRight != nil {
+				return nil, errRight
+			}
+			return &binaryNode{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseSum() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.consume("+"):
+			op = "+"
+		case p.consume("-"):
+			op = "-"
+		default:
+			return left, nil
+		}
+		right, errRight := p.parseProduct()
+		if errRight != nil {
+			return nil, errRight
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseProduct() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.consume("*"):
+			op = "*"
+		case p.consume("/"):
+			op = "/"
+		default:
+			return left, nil
+		}
+		right, errRight := p.parseUnary()
+		if errRight != nil {
+			return nil, errRight
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	p.skipSpace()
+	if p.consume("(") {
+		n, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	}
+	if p.pos < len(p.input) && (isDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+		return p.parseNumber()
+	}
+	name := p.parseIdent()
+	if name == "" {
+		return nil, fmt.Errorf("expected a number, metric or function at offset %d", p.pos)
+	}
+	switch name {
+	case "rate", "increase", "avg", "quantile":
+		if p.consume("(") {
+			return p.parseCall(name)
+		}
+	}
+	sel, err := p.parseSelector(name)
+	if err != nil {
+		return nil, err
+	}
+	if sel.window > 0 {
+		return nil, fmt.Errorf("range selector %s[...] must be wrapped in rate, increase, avg or quantile", name)
+	}
+	return sel, nil
+}
+
+func (p *parser) parseCall(fn string) (node, error) {
+	call := &callNode{fn: fn}
+	if fn == "quantile" {
+		q, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		call.quantile = q.(*numberNode).value
+		if call.quantile < 0 || call.quantile > 1 {
+			return nil, fmt.Errorf("quantile must be between 0 and 1")
+		}
+		if err = p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+	p.skipSpace()
+	name := p.parseIdent()
+	if name == "" {
+		return nil, fmt.Errorf("%s expects a metric at offset %d", fn, p.pos)
+	}
+	sel, err := p.parseSelector(name)
+	if err != nil {
+		return nil, err
+	}
+	if sel.window <= 0 {
+		return nil, fmt.Errorf("%s needs a range selector, e.g. %s(%s[5m])", fn, fn, name)
+	}
+	call.selector = sel
+	return call, p.expect(")")
+}
+
+func (p *parser) parseSelector(metric string) (*selectorNode, error) {
+	sel := &selectorNode{metric: metric}
+	if p.consume("{") {
+		for !p.consume("}") {
+			if len(sel.matchers) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			p.skipSpace()
+			label := p.parseIdent()
+			if label == "" {
+				return nil, fmt.Errorf("expected a label name at offset %d", p.pos)
+			}
+			var op string
+			for _, candidate := range []string{"=~", "!~", "!=", "="} {
+				if p.consume(candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("expected a label operator at offset %d", p.pos)
+			}
+			value, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			m := matcher{label: label, op: op, value: value}
+			if op == "=~" || op == "!~" {
+				if m.re, err = regexp.Compile("^(?:" + value + ")$"); err != nil {
+					return nil, fmt.Errorf("label %s: %w", label, err)
+				}
+			}
+			sel.matchers = append(sel.matchers, m)
+		}
+	}
+	if p.consume("[") {
+		start := p.pos
+		for p.pos < len(p.input) && p.input[p.pos] != ']' {
+			p.pos++
+		}
+		window, err := time.ParseDuration(strings.TrimSpace(p.input[start:p.pos]))
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("invalid range %q", p.input[start:p.pos])
+		}
+		sel.window = window
+		if err = p.expect("]"); err != nil {
+			return nil, err
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString(metric)
+	for _, m := range sel.matchers {
+		sb.WriteString("," + m.label + m.op + strconv.Quote(m.value))
+	}
+	sel.key = sb.String()
+	return sel, nil
+}
+
+func (p *parser) parseIdent() string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		ch := p.input[p.pos]
+		if ch == '_' || ch == ':' || unicode.IsLetter(rune(ch)) || (p.pos > start && isDigit(ch)) {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *parser) parseNumber() (node, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && (isDigit(p.input[p.pos]) || strings.ContainsRune(".eE", rune(p.input[p.pos]))) {
+		p.pos++
+	}
+	value, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q at offset %d", p.input[start:p.pos], start)
+	}
+	return &numberNode{value: value}, nil
+}
+
+func (p *parser) parseString() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.input) || p.input[p.pos] != '"' {
+		return "", fmt.Errorf("expected a quoted label value at offset %d", p.pos)
+	}
+	end := p.pos + 1
+	for end < len(p.input) && p.input[end] != '"' {
+		if p.input[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.input) {
+		return "", fmt.Errorf("unterminated label value at offset %d", p.pos)
+	}
+	value, err := strconv.Unquote(p.input[p.pos : end+1])
+	if err != nil {
+		return "", fmt.Errorf("invalid label value at offset %d: %w", p.pos, err)
+	}
+	p.pos = end + 1
+	return value, nil
+}
+
+func isDigit(ch byte) bool { return ch >= '0' && ch <= '9' }
+
+// eval computes n. Missing data yields NaN, which makes every comparison false.
+func eval(n node, h *history) float64 {
+	switch v := n.(type) {
+	case *numberNode:
+		return v.value
+	case *selectorNode:
+		latest, ok := h.latest(v.key)
+		if !ok {
+			return math.NaN()
+		}
+		return latest.value
+	case *callNode:
+		first, last, ok := h.window(v.selector.key, v.selector.window)
+		if !ok {
+			return math.NaN()
+		}
+		switch v.fn {
+		case "increase":
+			return counterDelta(first.value, last.value)
+		case "rate":
+			return counterDelta(first.value, last.value) / last.time.Sub(first.time).Seconds()
+		case "avg":
+			return counterDelta(first.sum, last.sum) / counterDelta(first.value, last.value)
+		case "quantile":
+			return bucketQuantile(v.quantile, first, last)
+		}
+	case *binaryNode:
+		left, right := eval(v.left, h), eval(v.right, h)
+		switch v.op {
+		case "+":
+			return left + right
+		case "-":
+			return left - right
+		case "*":
+			return left * right
+		case "/":
+			if right == 0 {
+				return math.NaN()
+			}
+			return left / right
+		default:
+			if math.IsNaN(left) || math.IsNaN(right) {
+				return math.NaN()
+			}
+			if compare(v.op, left, right) {
+				return 1
+			}
+			return 0
+		}
+	}
+	return math.NaN()
+}
+
+func compare(op string, left, right float64) bool {
+	switch op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	case ">":
+		return left > right
+	case ">=":
+		return left >= right
+	case "<":
+		return left < right
+	case "<=":
+		return left <= right
+	}
+	return false
+}
+
+// counterDelta is the increase of a counter, treating a decrease as a restart.
+func counterDelta(first, last float64) float64 {
+	if last < first {
+		return last
+	}
+	return last - first
+}
+
+// bucketQuantile estimates a quantile from the histogram observations made
+// between two points, interpolating linearly inside the matching bucket.
+func bucketQuantile(q float64, first, last point) float64 {
+	if len(last.buckets) == 0 || (len(first.buckets) != 0 && len(first.buckets) != len(last.buckets)) {
+		return math.NaN()
+	}
+	deltas := make([]float64, len(last.buckets))
+	var total float64
+	for i := range last.buckets {
+		var before uint64
+		if len(first.buckets) != 0 {
+			before = first.buckets[i]
+		}
+		deltas[i] = counterDelta(float64(before), float64(last.buckets[i]))
+		total += deltas[i]
+	}
+	if total == 0 {
+		return math.NaN()
+	}
+	rank := q * total
+	var cumulative float64
+	for i, count := range deltas {
+		if cumulative+count < rank || count == 0 {
+			cumulative += count
+			continue
+		}
+		if i >= len(last.bounds) {
+			// +Inf bucket: report the highest finite bound.
+			if len(last.bounds) == 0 {
+				return math.NaN()
+			}
+			return last.bounds[len(last.bounds)-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = last.bounds[i-1]
+		}
+		return lower + (last.bounds[i]-lower)*(rank-cumulative)/count
+	}
+	return last.bounds[len(last.bounds)-1]
+}
@@ -0,0 +1,270 @@
+// Package analytics records usage events in an embedded SQLite database and
+// answers constrained, read-only SQL queries over them, so small deployments
+// can run ad-hoc analysis without a separate warehouse.
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultFileName is the database file created in the auth directory when no path is configured.
+const DefaultFileName = "analytics.db"
+
+const (
+	// DefaultRowLimit caps query results when the caller does not ask for a limit.
+	DefaultRowLimit = 1000
+	// MaxRowLimit is the largest row limit a query may request.
+	MaxRowLimit = 10000
+
+	queryTimeout = 10 * time.Second
+	writeTimeout = 5 * time.Second
+	pruneEvery   = time.Hour
+)
+
+// Schema is the table layout exposed to queries.
+const Schema = `CREATE TABLE IF NOT EXISTS usage_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	requested_at TEXT NOT NULL,
+	provider TEXT NOT NULL DEFAULT '',
+	model TEXT NOT NULL DEFAULT '',
+	api_key TEXT NOT NULL DEFAULT '',
+	auth_id TEXT NOT NULL DEFAULT '',
+	source TEXT NOT NULL DEFAULT '',
+	failed INTEGER NOT NULL DEFAULT 0,
+	input_tokens INTEGER NOT NULL DEFAULT 0,
+	output_tokens INTEGER NOT NULL DEFAULT 0,
+	reasoning_tokens INTEGER NOT NULL DEFAULT 0,
+	cached_tokens INTEGER NOT NULL DEFAULT 0,
+	total_tokens INTEGER NOT NULL DEFAULT 0
+)`
+
+// timeLayout is understood by SQLite's date and time functions.
+const timeLayout = "2006-01-02 15:04:05"
+
+// ErrInvalidQuery is returned for queries that are not a single read-only SELECT.
+var ErrInvalidQuery = errors.New("query must be a single SELECT statement")
+
+// Result is the outcome of a query.
+type Result struct {
+	Columns   []string `json:"columns"`
+	Rows      [][]any  `json:"rows"`
+	Truncated bool     `json:"truncated"`
+}
+
+// Store is the embedded analytics database. Writes go through a read-write
+// handle; queries use a separate read-only handle so they cannot modify data.
+type Store struct {
+	db        *sql.DB
+	ro        *sql.DB
+	retention time.Duration
+	closed    atomic.Bool
+
+	pruneMu   sync.Mutex
+	lastPrune time.Time
+}
+
+// Open opens (creating if needed) the analytics database at path. Events older
+// than retention are pruned periodically; zero keeps them forever.
+func Open(path string, retention time.Duration) (*Store, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("analytics: create directory: %w", err)
+		}
+	}
+	params := url.Values{}
+	params.Set("_journal_mode", "WAL")
+	params.Set("_busy_timeout", "5000")
+	params.Set("_synchronous", "NORMAL")
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("analytics: open database: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	for _, stmt := range []string{
+		Schema,
+		`CREATE INDEX IF NOT EXISTS idx_usage_events_requested_at ON usage_events (requested_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_usage_events_api_key ON usage_events (api_key, requested_at)`,
+	} {
+		if _, err = db.ExecContext(ctx, stmt); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("analytics: create schema: %w", err)
+		}
+	}
+
+	roParams := url.Values{}
+	roParams.Set("mode", "ro")
+	roParams.Set("_query_only", "1")
+	roParams.Set("_busy_timeout", "5000")
+	ro, err := sql.Open("sqlite3", "file:"+path+"?"+roParams.Encode())
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("analytics: open read-only connection: %w", err)
+	}
+	return &Store{db: db, ro: ro, retention: retention}, nil
+}
+
+// Close closes the database. Events recorded afterwards are dropped.
+func (s *Store) Close() error {
+	if s.closed.Swap(true) {
+		return nil
+	}
+	return errors.Join(s.ro.Close(), s.db.Close())
+}
+
+// Record stores a usage event.
+func (s *Store) Record(ctx context.Context, record coreusage.Record) error {
+	if s.closed.Load() {
+		return nil
+	}
+	requestedAt := record.RequestedAt
+	if requestedAt.IsZero() {
+		requestedAt = time.Now()
+	}
+	failed := 0
+	if record.Failed {
+		failed = 1
+	}
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO usage_events
+		(requested_at, provider, model, api_key, auth_id, source, failed,
+		 input_tokens, output_tokens, reasoning_tokens, cached_tokens, total_tokens)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		requestedAt.UTC().Format(timeLayout), record.Provider, record.Model, record.APIKey, record.AuthID, record.Source, failed,
+		record.Detail.InputTokens, record.Detail.OutputTokens, record.Detail.ReasoningTokens, record.Detail.CachedTokens, record.Detail.TotalTokens)
+	if err != nil {
+		return fmt.Errorf("analytics: insert usage event: %w", err)
+	}
+	s.maybePrune(requestedAt)
+	return nil
+}
+
+// maybePrune deletes events older than the retention period at most once per pruneEvery.
+func (s *Store) maybePrune(now time.Time) {
+	if s.retention <= 0 {
+		return
+	}
+	s.pruneMu.Lock()
+	if now.Sub(s.lastPrune) < pruneEvery {
+		s.pruneMu.Unlock()
+		return
+	}
+	s.lastPrune = now
+	s.pruneMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	cutoff := now.Add(-s.retention).UTC().Format(timeLayout)
+	res, err := s.db.ExecContext(ctx, `DELETE FROM usage_events WHERE requested_at < ?`, cutoff)
+	if err != nil {
+		log.Warnf("analytics: failed to prune usage events: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Debugf("analytics: pruned %d usage events older than %s", n, cutoff)
+	}
+}
+
+// Query runs a single read-only SELECT (optionally with a WITH clause) and
+// returns at most limit rows. A limit of zero uses DefaultRowLimit.
+func (s *Store) Query(ctx context.Context, query string, limit int) (*Result, error) {
+	query, err := validateQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultRowLimit
+	}
+	if limit > MaxRowLimit {
+		limit = MaxRowLimit
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	rows, err := s.ro.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("analytics: query: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("analytics: read columns: %w", err)
+	}
+	result := &Result{Columns: columns, Rows: make([][]any, 0)}
+	for rows.Next() {
+		if len(result.Rows) == limit {
+			result.Truncated = true
+			break
+		}
+		values := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err = rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("analytics: scan row: %w", err)
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("analytics: query: %w", err)
+	}
+	return result, nil
+}
+
+// validateQuery accepts exactly one SELECT or WITH statement. The read-only
+// connection is the real guard; this rejects obvious misuse with a clear error.
+func validateQuery(query string) (string, error) {
+	query = strings.TrimSpace(query)
+	query = strings.TrimSpace(strings.TrimSuffix(query, ";"))
+	if query == "" || strings.Contains(query, ";") {
+		return "", ErrInvalidQuery
+	}
+	first := strings.ToLower(strings.Fields(query)[0])
+	if first != "select" && first != "with" {
+		return "", ErrInvalidQuery
+	}
+	return query, nil
+}
+
+// Plugin feeds usage records into the store.
+type Plugin struct {
+	store *Store
+}
+
+// NewPlugin creates a usage plugin writing to store.
+func NewPlugin(store *Store) *Plugin {
+	return &Plugin{store: store}
+}
+
+// HandleUsage implements coreusage.Plugin.
+func (p *Plugin) HandleUsage(ctx context.Context, record coreusage.Record) {
+	if p == nil || p.store == nil || usage.IsExcludedAPIKey(record.APIKey) {
+		return
+	}
+	if err := p.store.Record(ctx, record); err != nil {
+		log.Warn(err)
+	}
+}
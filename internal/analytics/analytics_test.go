@@ -0,0 +1,60 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+func TestStoreRecordsAndQueriesUsage(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "analytics.db"), 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, model := range []string{"claude-sonnet", "claude-sonnet", "gpt-5"} {
+		record := coreusage.Record{
+			Provider:    "test",
+			Model:       model,
+			APIKey:      "k1",
+			RequestedAt: now.Add(time.Duration(i) * time.Minute),
+			Detail:      coreusage.Detail{TotalTokens: 100},
+		}
+		if err = store.Record(ctx, record); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	result, err := store.Query(ctx, "SELECT model, SUM(total_tokens) AS tokens FROM usage_events GROUP BY model ORDER BY model;", 0)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(result.Rows) != 2 || result.Rows[0][0] != "claude-sonnet" || result.Rows[0][1] != int64(200) {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	result, err = store.Query(ctx, "SELECT id FROM usage_events", 2)
+	if err != nil || len(result.Rows) != 2 || !result.Truncated {
+		t.Fatalf("expected truncated result, got %+v, %v", result, err)
+	}
+
+	for _, query := range []string{"DELETE FROM usage_events", "SELECT 1; DROP TABLE usage_events", ""} {
+		if _, err = store.Query(ctx, query, 0); !errors.Is(err, ErrInvalidQuery) {
+			t.Fatalf("Query(%q) error = %v, want ErrInvalidQuery", query, err)
+		}
+	}
+	// The read-only connection rejects writes that slip past validation.
+	if _, err = store.Query(ctx, "WITH x AS (SELECT 1) DELETE FROM usage_events", 0); err == nil {
+		t.Fatal("expected write through WITH to fail")
+	}
+	if result, _ = store.Query(ctx, "SELECT COUNT(*) FROM usage_events", 0); result.Rows[0][0] != int64(3) {
+		t.Fatalf("expected rows to survive, got %+v", result.Rows)
+	}
+}
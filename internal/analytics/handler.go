@@ -0,0 +1,65 @@
+package analytics
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler serves the analytics management API.
+type Handler struct {
+	store *Store
+}
+
+// NewHandler creates a management handler for the store.
+func NewHandler(store *Store) *Handler {
+	return &Handler{store: store}
+}
+
+// RegisterRoutes registers the analytics management routes.
+func (h *Handler) RegisterRoutes(group *gin.RouterGroup) {
+	group.GET("/analytics/schema", h.GetSchema)
+	group.POST("/analytics/query", h.RunQuery)
+}
+
+type queryRequest struct {
+	SQL   string `json:"sql"`
+	Limit int    `json:"limit"`
+}
+
+// GetSchema returns the table layout available to queries
+// GET /v0/management/analytics/schema
+func (h *Handler) GetSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"schema": Schema})
+}
+
+// RunQuery runs a read-only SELECT against the usage events
+// POST /v0/management/analytics/query
+// {"sql": "SELECT model, SUM(total_tokens) FROM usage_events GROUP BY model", "limit": 100}
+func (h *Handler) RunQuery(c *gin.Context) {
+	var body queryRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_body",
+			"message": "request body must be a JSON object",
+		})
+		return
+	}
+	result, err := h.store.Query(c.Request.Context(), body.SQL, body.Limit)
+	if errors.Is(err, ErrInvalidQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_query",
+			"message": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "query_failed",
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
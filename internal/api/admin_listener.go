@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultAdminHost keeps the admin listener off public interfaces unless configured.
+const defaultAdminHost = "127.0.0.1"
+
+// newAdminEngine creates the Gin engine of the dedicated admin listener. It
+// carries its own middleware chain: none of the proxy's request logging, error
+// buffering or client-facing middleware applies to management traffic.
+func newAdminEngine(cfg config.AdminListenerConfig) *gin.Engine {
+	engine := gin.New()
+	engine.Use(logging.GinLogrusLogger())
+	engine.Use(logging.GinLogrusRecovery())
+	if strings.TrimSpace(cfg.Socket) != "" {
+		engine.Use(unixSocketPeerMiddleware())
+	}
+	engine.Use(corsMiddleware())
+	return engine
+}
+
+// unixSocketPeerMiddleware gives Unix socket peers a loopback address. Only
+// local processes can reach the socket, so they count as localhost clients.
+func unixSocketPeerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, _, err := net.SplitHostPort(c.Request.RemoteAddr); err != nil {
+			c.Request.RemoteAddr = "127.0.0.1:0"
+		}
+		c.Next()
+	}
+}
+
+// managementEngine returns the engine serving the management API and panel.
+func (s *Server) managementEngine() *gin.Engine {
+	if s.adminEngine != nil {
+		return s.adminEngine
+	}
+	return s.engine
+}
+
+// listenAdmin opens the admin listener. It returns nil when no dedicated admin
+// listener is configured.
+func listenAdmin(cfg config.AdminListenerConfig) (net.Listener, error) {
+	if socket := strings.TrimSpace(cfg.Socket); socket != "" {
+		if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("remove stale admin socket: %w", err)
+		}
+		listener, err := net.Listen("unix", socket)
+		if err != nil {
+			return nil, err
+		}
+		if err = os.Chmod(socket, 0o660); err != nil {
+			_ = listener.Close()
+			return nil, fmt.Errorf("restrict admin socket permissions: %w", err)
+		}
+		return listener, nil
+	}
+	host := strings.TrimSpace(cfg.Host)
+	if host == "" {
+		host = defaultAdminHost
+	}
+	if cfg.Port <= 0 {
+		return nil, fmt.Errorf("admin-listener.port must be set when no socket is configured")
+	}
+	return net.Listen("tcp", net.JoinHostPort(host, fmt.Sprint(cfg.Port)))
+}
+
+// startAdminListener binds the admin listener and serves it in the background.
+// Bind errors are returned so a misconfigured admin surface fails startup.
+func (s *Server) startAdminListener() error {
+	if s.adminEngine == nil || s.cfg == nil {
+		return nil
+	}
+	cfg := s.cfg.AdminListener
+	useTLS := cfg.TLS.Enable
+	cert, key := strings.TrimSpace(cfg.TLS.Cert), strings.TrimSpace(cfg.TLS.Key)
+	if useTLS && (cert == "" || key == "") {
+		return fmt.Errorf("failed to start admin listener: admin-listener.tls.cert or admin-listener.tls.key is empty")
+	}
+	var tlsConfig *tls.Config
+	if useTLS {
+		var errClientAuth error
+		if tlsConfig, errClientAuth = applyClientAuth(nil, cfg.TLS); errClientAuth != nil {
+			return fmt.Errorf("failed to start admin listener: %w", errClientAuth)
+		}
+	}
+	listener, err := listenAdmin(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to start admin listener: %w", err)
+	}
+	s.adminServer = &http.Server{Handler: s.adminEngine, TLSConfig: tlsConfig}
+	log.Infof("Management API listening on %s (tls: %v)", listener.Addr(), useTLS)
+	go func() {
+		var errServe error
+		if useTLS {
+			errServe = s.adminServer.ServeTLS(listener, cert, key)
+		} else {
+			errServe = s.adminServer.Serve(listener)
+		}
+		if errServe != nil && !errors.Is(errServe, http.ErrServerClosed) {
+			log.Errorf("admin listener stopped: %v", errServe)
+		}
+	}()
+	return nil
+}
+
+// stopAdminListener gracefully shuts down the admin listener, if running.
+func (s *Server) stopAdminListener(ctx context.Context) error {
+	if s.adminServer == nil {
+		return nil
+	}
+	if err := s.adminServer.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shutdown admin listener: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bytes"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/branding"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/canary"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/contentfilter"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/ipacl"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/latencybudget"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/reqfeatures"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/requestvalidation"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/responsepolicy"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// canarySection is a config section whose request middleware is staged on the
+// canary keys. Changes to any other section are applied to all keys right away.
+type canarySection struct {
+	key   string
+	copy  func(dst, src *config.Config)
+	build func(cfg *config.Config) gin.HandlerFunc
+}
+
+var canarySections = []canarySection{
+	{
+		key:  "request-validation",
+		copy: func(dst, src *config.Config) { dst.RequestValidation = src.RequestValidation },
+		build: func(cfg *config.Config) gin.HandlerFunc {
+			return requestvalidation.New(cfg.RequestValidation).Middleware()
+		},
+	},
+	{
+		key:   "ip-access",
+		copy:  func(dst, src *config.Config) { dst.IPAccess = src.IPAccess },
+		build: func(cfg *config.Config) gin.HandlerFunc { return ipacl.New(cfg.IPAccess).Middleware() },
+	},
+	{
+		key:   "request-features",
+		copy:  func(dst, src *config.Config) { dst.RequestFeatures = src.RequestFeatures },
+		build: func(cfg *config.Config) gin.HandlerFunc { return reqfeatures.New(cfg.RequestFeatures).Middleware() },
+	},
+	{
+		key:   "latency-budget",
+		copy:  func(dst, src *config.Config) { dst.LatencyBudget = src.LatencyBudget },
+		build: func(cfg *config.Config) gin.HandlerFunc { return latencybudget.New(cfg.LatencyBudget).Middleware() },
+	},
+	{
+		key:   "branding",
+		copy:  func(dst, src *config.Config) { dst.Branding = src.Branding },
+		build: func(cfg *config.Config) gin.HandlerFunc { return branding.New(cfg.Branding).Middleware() },
+	},
+	{
+		key:   "content-filters",
+		copy:  func(dst, src *config.Config) { dst.ContentFilters = src.ContentFilters },
+		build: func(cfg *config.Config) gin.HandlerFunc { return contentfilter.New(cfg.ContentFilters).Middleware() },
+	},
+	{
+		key:   "response-policy",
+		copy:  func(dst, src *config.Config) { dst.ResponsePolicy = src.ResponsePolicy },
+		build: func(cfg *config.Config) gin.HandlerFunc { return responsepolicy.New(cfg.ResponsePolicy).Middleware() },
+	},
+}
+
+// canaryStage holds the request middlewares built from a configuration under
+// canary, by section key as passed to staged.
+type canaryStage map[string]gin.HandlerFunc
+
+func newCanaryStage(cfg *config.Config) canaryStage {
+	stage := make(canaryStage, len(canarySections))
+	for _, section := range canarySections {
+		stage[section.key] = section.build(cfg)
+	}
+	return stage
+}
+
+// withCanarySections returns a copy of cfg whose staged sections are taken from src.
+func withCanarySections(cfg, src *config.Config) *config.Config {
+	out := *cfg
+	for _, section := range canarySections {
+		section.copy(&out, src)
+	}
+	return &out
+}
+
+// canarySectionsYAML marshals only the staged sections of cfg, for comparison.
+func canarySectionsYAML(cfg *config.Config) []byte {
+	data, _ := yaml.Marshal(withCanarySections(&config.Config{}, cfg))
+	return data
+}
+
+// staged runs the canary variant of a middleware for requests of canary keys
+// while a rollout is in progress, and stable otherwise.
+func (s *Server) staged(name string, stable gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if canary.Active(c) {
+			if stage := s.canaryStage.Load(); stage != nil {
+				if handler := (*stage)[name]; handler != nil {
+					handler(c)
+					return
+				}
+			}
+		}
+		stable(c)
+	}
+}
+
+// StageCanary splits a reloaded cfg for a canary rollout and returns the
+// configuration to apply right away. When canary deployment is enabled and the
+// staged sections of cfg differ from the applied configuration, they run on
+// the canary keys only and the returned configuration keeps the applied ones;
+// every other change is in the returned configuration. apply is called with
+// the latest reloaded configuration on promotion and must apply it like a
+// regular reload.
+func (s *Server) StageCanary(cfg *config.Config, apply func(*config.Config)) *config.Config {
+	s.canaryMu.Lock()
+	defer s.canaryMu.Unlock()
+	if s.canary == nil || cfg == nil {
+		return cfg
+	}
+	var applied *config.Config
+	if err := yaml.Unmarshal(s.oldConfigYaml, &applied); err != nil || applied == nil {
+		return cfg
+	}
+	candidate := canarySectionsYAML(cfg)
+	if bytes.Equal(candidate, canarySectionsYAML(applied)) {
+		// Staged sections back at their applied values, e.g. after a rollback, end any rollout.
+		s.canary.Cancel("config reloaded without staged changes")
+		s.endCanaryStage()
+		return cfg
+	}
+	if !s.canary.Enabled() {
+		return cfg
+	}
+	s.canaryPending.Store(cfg)
+	if s.canaryStage.Load() != nil && bytes.Equal(candidate, s.canaryCandidate) {
+		// Only unstaged sections changed; the rollout in progress goes on.
+		return withCanarySections(cfg, applied)
+	}
+	if s.canaryStage.Load() == nil {
+		s.canaryBaseline = s.appliedConfigFile()
+	}
+	stage := newCanaryStage(cfg)
+	s.canaryStage.Store(&stage)
+	s.canaryCandidate = candidate
+	s.canary.Start(func() {
+		s.canaryMu.Lock()
+		pending := s.canaryPending.Load()
+		s.endCanaryStage()
+		s.canaryMu.Unlock()
+		if pending != nil {
+			apply(pending)
+		}
+	}, func() {
+		s.canaryMu.Lock()
+		defer s.canaryMu.Unlock()
+		baseline := s.canaryBaseline
+		s.endCanaryStage()
+		s.restoreCanarySections(baseline)
+	})
+	return withCanarySections(cfg, applied)
+}
+
+// endCanaryStage drops the staged middlewares. The caller holds canaryMu.
+func (s *Server) endCanaryStage() {
+	s.canaryStage.Store(nil)
+	s.canaryPending.Store(nil)
+	s.canaryCandidate = nil
+	s.canaryBaseline = nil
+}
+
+// appliedConfigFile returns the config file content applied before a rollout,
+// falling back to the applied configuration when the history lacks it.
+func (s *Server) appliedConfigFile() []byte {
+	if s.configHistory != nil {
+		if content, ok := s.configHistory.Get(s.configHistory.Current()); ok {
+			return content
+		}
+	}
+	return s.oldConfigYaml
+}
+
+// restoreCanarySections sets the staged sections of the config file back to
+// their content in baseline after a rollback, so a restart does not load them
+// again. Other sections, including management writes made during the rollout,
+// are left as they are.
+func (s *Server) restoreCanarySections(baseline []byte) {
+	if s.configFilePath == "" || len(baseline) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(canarySections))
+	for _, section := range canarySections {
+		keys = append(keys, section.key)
+	}
+	changed, err := config.RestoreSectionsPreserveComments(s.configFilePath, baseline, keys)
+	if err != nil {
+		log.Errorf("canary: failed to restore staged sections in config file: %v", err)
+		return
+	}
+	if changed {
+		log.Info("canary: restored staged sections in config file")
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// drainer counts in-flight requests so shutdown can wait for active streams,
+// and turns away requests that arrive once draining has started, e.g. on a
+// kept-alive or multiplexed connection.
+type drainer struct {
+	draining atomic.Bool
+	inflight atomic.Int64
+}
+
+func (d *drainer) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d.draining.Load() {
+			c.Header("Connection", "close")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error":   "shutting_down",
+				"message": "The server is shutting down; retry the request.",
+			})
+			return
+		}
+		d.inflight.Add(1)
+		defer d.inflight.Add(-1)
+		c.Next()
+	}
+}
+
+// drain stops the HTTP server from accepting new requests and waits for the
+// in-flight ones, including SSE streams, to finish. Connections still active
+// when the drain timeout or ctx runs out are closed.
+func (s *Server) drain(ctx context.Context) error {
+	s.drainer.draining.Store(true)
+	timeout := s.cfg.DrainTimeout()
+	if n := s.drainer.inflight.Load(); n > 0 {
+		log.Infof("Draining %d in-flight request(s), waiting up to %s", n, timeout)
+	}
+	// Live event streams never finish on their own.
+	if s.mgmt != nil {
+		s.mgmt.CloseEventStreams()
+	}
+	drainCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := s.server.Shutdown(drainCtx); err == nil {
+		return nil
+	}
+	log.Warnf("Drain timeout reached with %d request(s) still in flight; closing their connections", s.drainer.inflight.Load())
+	return s.server.Close()
+}
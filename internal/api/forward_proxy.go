@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/forwardproxy"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Default file names of the interception CA, relative to the auth directory.
+const (
+	defaultForwardProxyCACert = "forward-proxy-ca.crt"
+	defaultForwardProxyCAKey  = "forward-proxy-ca.key"
+)
+
+// startForwardProxy binds the forward-proxy listener and serves it in the
+// background. Intercepted requests are served by the proxy engine itself.
+func (s *Server) startForwardProxy() error {
+	if s.cfg == nil || !s.cfg.ForwardProxy.Enabled {
+		return nil
+	}
+	cfg := s.cfg.ForwardProxy
+	if cfg.Port <= 0 {
+		return fmt.Errorf("failed to start forward proxy: forward-proxy.port must be set")
+	}
+	authDir, err := util.ResolveAuthDir(s.cfg.AuthDir)
+	if err != nil {
+		return fmt.Errorf("failed to start forward proxy: %w", err)
+	}
+	certPath, keyPath := strings.TrimSpace(cfg.CACert), strings.TrimSpace(cfg.CAKey)
+	if certPath == "" {
+		certPath = filepath.Join(authDir, defaultForwardProxyCACert)
+	} else if certPath, err = util.ResolveAuthDir(certPath); err != nil {
+		return fmt.Errorf("failed to start forward proxy: %w", err)
+	}
+	if keyPath == "" {
+		keyPath = filepath.Join(authDir, defaultForwardProxyCAKey)
+	} else if keyPath, err = util.ResolveAuthDir(keyPath); err != nil {
+		return fmt.Errorf("failed to start forward proxy: %w", err)
+	}
+	ca, err := forwardproxy.LoadOrCreateCA(certPath, keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to start forward proxy: %w", err)
+	}
+
+	host := strings.TrimSpace(cfg.Host)
+	if host == "" {
+		host = defaultAdminHost
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, fmt.Sprint(cfg.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to start forward proxy: %w", err)
+	}
+	s.forwardProxy = forwardproxy.New(forwardproxy.Config{
+		Hosts:           cfg.Hosts,
+		AllowedNetworks: device.ParseCIDRs(cfg.AllowedCIDRs),
+		Passthrough:     cfg.Passthrough,
+		CA:              ca,
+	}, s.engine)
+	log.Infof("Forward proxy listening on %s (CA certificate: %s)", listener.Addr(), certPath)
+	go func() {
+		if errServe := s.forwardProxy.Serve(listener); errServe != nil && !errors.Is(errServe, http.ErrServerClosed) {
+			log.Errorf("forward proxy stopped: %v", errServe)
+		}
+	}()
+	return nil
+}
+
+// stopForwardProxy shuts down the forward-proxy listener, if running.
+func (s *Server) stopForwardProxy(ctx context.Context) error {
+	if s.forwardProxy == nil {
+		return nil
+	}
+	if err := s.forwardProxy.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shutdown forward proxy: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package management
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/admintokens"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+)
+
+// adminTokenContextKey holds the admintokens.Info of a request authenticated with a scoped token.
+const adminTokenContextKey = "managementAdminToken"
+
+// SetAdminTokens sets the scoped management token store.
+func (h *Handler) SetAdminTokens(m *admintokens.Manager) { h.adminTokens = m }
+
+// authorizeAdminToken checks a scoped management token. It reports whether
+// provided is a valid token; the request is aborted with 403 when the token's
+// scope does not cover the route.
+func (h *Handler) authorizeAdminToken(c *gin.Context, provided string) bool {
+	token, ok := h.adminTokens.Verify(provided)
+	if !ok {
+		return false
+	}
+	route := c.FullPath()
+	if i := strings.Index(route, "/management"); i >= 0 {
+		route = route[i+len("/management"):]
+	}
+	if !admintokens.Allows(token.Scope, c.Request.Method, route) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":   "insufficient_scope",
+			"message": "management token " + token.ID + " has scope " + token.Scope,
+		})
+		return true
+	}
+	c.Set(adminTokenContextKey, token)
+	c.Next()
+	return true
+}
+
+// ListAdminTokens returns the scoped management tokens without their secrets.
+// GET /v0/management/admin-tokens
+func (h *Handler) ListAdminTokens(c *gin.Context) {
+	if h.adminTokens == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "admin tokens unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": h.adminTokens.List()})
+}
+
+// CreateAdminToken mints a scoped management token. The secret is returned
+// once and cannot be retrieved again.
+// POST /v0/management/admin-tokens
+// {"name": "support desk", "scope": "unban", "ttl_seconds": 2592000}
+func (h *Handler) CreateAdminToken(c *gin.Context) {
+	if h.adminTokens == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "admin tokens unavailable"})
+		return
+	}
+	var body struct {
+		Name       string `json:"name"`
+		Scope      string `json:"scope"`
+		TTLSeconds int64  `json:"ttl_seconds"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.TTLSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	secret, info, err := h.adminTokens.Mint(body.Name, strings.ToLower(strings.TrimSpace(body.Scope)), time.Duration(body.TTLSeconds)*time.Second)
+	if errors.Is(err, admintokens.ErrInvalidScope) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	events.Publish(events.Event{
+		Type:   events.TypeAdminTokenCreated,
+		Actor:  h.managementActor(c),
+		Reason: info.Name,
+		Data:   map[string]any{"id": info.ID, "scope": info.Scope},
+	})
+	c.JSON(http.StatusCreated, gin.H{"token": secret, "info": info})
+}
+
+// RevokeAdminToken deletes a scoped management token.
+// DELETE /v0/management/admin-tokens?id=...
+func (h *Handler) RevokeAdminToken(c *gin.Context) {
+	if h.adminTokens == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "admin tokens unavailable"})
+		return
+	}
+	id := strings.TrimSpace(c.Query("id"))
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id is required"})
+		return
+	}
+	revoked, err := h.adminTokens.Revoke(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+	events.Publish(events.Event{Type: events.TypeAdminTokenRevoked, Actor: h.managementActor(c), Data: map[string]any{"id": id}})
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
+
+// managementActor names who made a management request: the scoped token, or
+// "admin" for the management key.
+func (h *Handler) managementActor(c *gin.Context) string {
+	if token, ok := c.Get(adminTokenContextKey); ok {
+		if info, okInfo := token.(admintokens.Info); okInfo {
+			return "token:" + info.ID
+		}
+	}
+	return "admin"
+}
@@ -0,0 +1,72 @@
+package management
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/backup"
+)
+
+// SetBackups sets the device binding store backup manager.
+func (h *Handler) SetBackups(m *backup.Manager) { h.backups = m }
+
+// ListBackups returns the stored backups, newest first.
+// GET /v0/management/backups
+func (h *Handler) ListBackups(c *gin.Context) {
+	if h.backups == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "backups unavailable"})
+		return
+	}
+	list, err := h.backups.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "list_failed", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"target": h.backups.Target(), "backups": list})
+}
+
+// CreateBackup takes a backup of the device binding store now.
+// POST /v0/management/backups
+func (h *Handler) CreateBackup(c *gin.Context) {
+	if h.backups == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "backups unavailable"})
+		return
+	}
+	info, err := h.backups.Backup(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "backup_failed", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, info)
+}
+
+// RestoreBackup replaces the device bindings with the content of a backup.
+// The current bindings are backed up first and that backup is returned as undo.
+// POST /v0/management/backups/restore
+// {"name": "store-20250101T000000.000Z.yaml.gz"}
+func (h *Handler) RestoreBackup(c *gin.Context) {
+	if h.backups == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "backups unavailable"})
+		return
+	}
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "message": "name is required"})
+		return
+	}
+	undo, restored, err := h.backups.Restore(c.Request.Context(), strings.TrimSpace(body.Name), h.managementActor(c))
+	switch {
+	case errors.Is(err, backup.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "message": "backup not found"})
+	case errors.Is(err, backup.ErrRestoreUnsupported):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "unsupported", "message": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "restore_failed", "message": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"status": "restored", "name": body.Name, "bindings": restored, "undo": undo})
+	}
+}
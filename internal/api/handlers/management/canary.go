@@ -0,0 +1,52 @@
+package management
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/canary"
+)
+
+// SetCanary sets the controller of canary config rollouts.
+func (h *Handler) SetCanary(c *canary.Controller) { h.canary = c }
+
+// GetCanary returns the canary rollout in progress with the error counts of the
+// canary keys and of every other key.
+// GET /v0/management/canary
+func (h *Handler) GetCanary(c *gin.Context) {
+	if h.canary == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "canary deployment unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, h.canary.Status())
+}
+
+// PromoteCanary applies the config under canary to all traffic without waiting
+// for the soak period to end.
+// POST /v0/management/canary/promote
+func (h *Handler) PromoteCanary(c *gin.Context) {
+	if h.canary == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "canary deployment unavailable"})
+		return
+	}
+	if !h.canary.Promote(h.managementActor(c)) {
+		c.JSON(http.StatusConflict, gin.H{"error": "no canary rollout in progress"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "promoted"})
+}
+
+// RollbackCanary discards the config under canary and restores the config file
+// to the applied configuration.
+// POST /v0/management/canary/rollback
+func (h *Handler) RollbackCanary(c *gin.Context) {
+	if h.canary == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "canary deployment unavailable"})
+		return
+	}
+	if !h.canary.Rollback(h.managementActor(c)) {
+		c.JSON(http.StatusConflict, gin.H{"error": "no canary rollout in progress"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "rolled back"})
+}
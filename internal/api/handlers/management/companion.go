@@ -0,0 +1,24 @@
+package management
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/companion"
+)
+
+// SetCompanion sets the companion agent hub.
+func (h *Handler) SetCompanion(hub *companion.Hub) { h.companion = hub }
+
+// ListCompanionAgents returns the connected desktop companion agents.
+// GET /v0/management/companion/agents
+func (h *Handler) ListCompanionAgents(c *gin.Context) {
+	if h.companion == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "companion agents unavailable"})
+		return
+	}
+	agents := h.companion.Agents()
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ConnectedAt.Before(agents[j].ConnectedAt) })
+	c.JSON(http.StatusOK, gin.H{"agents": agents})
+}
@@ -228,6 +228,19 @@ func (h *Handler) PutRequestLog(c *gin.Context) {
 	h.updateBoolField(c, func(v bool) { h.cfg.RequestLog = v })
 }
 
+// DisableVerboseLogging switches debug and request logging off and persists the
+// change. It reports which of the two were on.
+func (h *Handler) DisableVerboseLogging() (debug, requestLog bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	debug, requestLog = h.cfg.Debug, h.cfg.RequestLog
+	if !debug && !requestLog {
+		return false, false, nil
+	}
+	h.cfg.Debug, h.cfg.RequestLog = false, false
+	return debug, requestLog, config.SaveConfigPreserveComments(h.configFilePath, h.cfg)
+}
+
 // Websocket auth
 func (h *Handler) GetWebsocketAuth(c *gin.Context) {
 	c.JSON(200, gin.H{"ws-auth": h.cfg.WebsocketAuth})
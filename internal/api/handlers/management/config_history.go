@@ -0,0 +1,67 @@
+package management
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/confighistory"
+	log "github.com/sirupsen/logrus"
+)
+
+// SetConfigHistory sets the history of applied configurations.
+func (h *Handler) SetConfigHistory(history *confighistory.History) { h.configHistory = history }
+
+// GetConfigHistory lists the recently applied configurations, newest first,
+// each with a unified diff against the one applied before it.
+// GET /v0/management/config/history
+func (h *Handler) GetConfigHistory(c *gin.Context) {
+	if h.configHistory == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "config history unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"current":  h.configHistory.Current(),
+		"versions": h.configHistory.List(),
+	})
+}
+
+// RollbackConfig writes a previously applied configuration back to the config
+// file. The file watcher hot-reloads it, which records it as a new version.
+// POST /v0/management/config/rollback?version=3
+func (h *Handler) RollbackConfig(c *gin.Context) {
+	if h.configHistory == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "config history unavailable"})
+		return
+	}
+	version, err := strconv.Atoi(c.Query("version"))
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version", "message": "version must be a positive integer"})
+		return
+	}
+	content, ok := h.configHistory.Get(version)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return
+	}
+	if current := h.configHistory.Current(); version == current {
+		c.JSON(http.StatusConflict, gin.H{"error": "version is already applied", "version": version})
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err = WriteConfig(h.configFilePath, content); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "write_failed", "message": err.Error()})
+		return
+	}
+	newCfg, err := config.LoadConfig(h.configFilePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "reload_failed", "message": err.Error()})
+		return
+	}
+	h.cfg = newCfg
+	log.Infof("config rolled back to version %d", version)
+	c.JSON(http.StatusOK, gin.H{"status": "rolled back", "version": version})
+}
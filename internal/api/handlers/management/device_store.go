@@ -0,0 +1,108 @@
+package management
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	log "github.com/sirupsen/logrus"
+)
+
+// dualStore returns the dual-write device store, answering 409 when dual-write is off.
+func (h *Handler) dualStore(c *gin.Context) (*device.DualStore, bool) {
+	dual, ok := h.deviceStore.(*device.DualStore)
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "dual_write_disabled", "message": "device-binding.store.dual-write is not configured"})
+		return nil, false
+	}
+	return dual, true
+}
+
+// GetDeviceStoreDualWrite returns the roles of both stores, the last verification and mirror failures.
+// GET /v0/management/device-store/dual-write
+func (h *Handler) GetDeviceStoreDualWrite(c *gin.Context) {
+	dual, ok := h.dualStore(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, dual.Status())
+}
+
+// VerifyDeviceStore compares both stores now.
+// POST /v0/management/device-store/verify
+func (h *Handler) VerifyDeviceStore(c *gin.Context) {
+	dual, ok := h.dualStore(c)
+	if !ok {
+		return
+	}
+	status, err := dual.Verify()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "verify_failed", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// BackfillDeviceStore copies existing bindings and key usage to the secondary store.
+// POST /v0/management/device-store/backfill
+func (h *Handler) BackfillDeviceStore(c *gin.Context) {
+	dual, ok := h.dualStore(c)
+	if !ok {
+		return
+	}
+	report, err := dual.Backfill()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "backfill_failed", "message": err.Error(), "report": report})
+		return
+	}
+	status, err := dual.Verify()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "verify_failed", "message": err.Error(), "report": report})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"report": report, "status": status})
+}
+
+// CutoverDeviceStore makes the secondary store serve reads and mirrors writes back
+// to the former primary. The backends in device-binding.store and its dual-write
+// block are swapped in the config file so a restart keeps the new roles; calling
+// it again rolls back. Diverged stores are refused unless ?force=true.
+// POST /v0/management/device-store/cutover
+func (h *Handler) CutoverDeviceStore(c *gin.Context) {
+	dual, ok := h.dualStore(c)
+	if !ok {
+		return
+	}
+	status, err := dual.Cutover(c.Query("force") == "true")
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, device.ErrStoresDiverged) {
+			code = http.StatusConflict
+		}
+		c.JSON(code, gin.H{"error": "cutover_refused", "message": err.Error(), "status": status})
+		return
+	}
+
+	h.mu.Lock()
+	swapDeviceStoreRoles(&h.cfg.DeviceBinding.Store)
+	errSave := config.SaveConfigPreserveComments(h.configFilePath, h.cfg)
+	h.mu.Unlock()
+	if errSave != nil {
+		log.Errorf("device-binding: cut over but failed to save config: %v", errSave)
+		c.JSON(http.StatusOK, gin.H{"status": status, "warning": "cut over, but the config could not be saved; update device-binding.store before restarting"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": status})
+}
+
+// swapDeviceStoreRoles exchanges the primary and dual-write backends of a store config.
+func swapDeviceStoreRoles(store *config.DeviceStoreConfig) {
+	primary := config.DeviceDualWriteConfig{Backend: store.Backend, Path: store.Path, DSN: store.DSN}
+	if primary.Backend == "" {
+		primary.Backend = device.BackendYAML
+	}
+	store.Backend, store.Path, store.DSN = store.DualWrite.Backend, store.DualWrite.Path, store.DualWrite.DSN
+	store.DualWrite.Backend, store.DualWrite.Path, store.DualWrite.DSN = primary.Backend, primary.Path, primary.DSN
+}
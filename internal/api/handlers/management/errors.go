@@ -0,0 +1,46 @@
+package management
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/errorlog"
+)
+
+// GetRecentErrors returns recent sanitized proxy and upstream errors, newest first.
+// Supported query parameters: source (proxy|upstream), status, min-status, path, since (RFC3339), limit.
+func (h *Handler) GetRecentErrors(c *gin.Context) {
+	filter := errorlog.Filter{
+		Source: strings.TrimSpace(c.Query("source")),
+		Path:   strings.TrimSpace(c.Query("path")),
+	}
+	for name, target := range map[string]*int{"status": &filter.Status, "min-status": &filter.MinStatus, "limit": &filter.Limit} {
+		raw := strings.TrimSpace(c.Query(name))
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+			return
+		}
+		*target = value
+	}
+	if raw := strings.TrimSpace(c.Query("since")); raw != "" {
+		since, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since, expected RFC3339"})
+			return
+		}
+		filter.Since = since
+	}
+
+	entries := errorlog.Default().Recent(filter)
+	c.JSON(http.StatusOK, gin.H{
+		"errors": entries,
+		"count":  len(entries),
+	})
+}
@@ -0,0 +1,184 @@
+package management
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+)
+
+const (
+	eventStreamBuffer    = 256
+	eventStreamKeepAlive = 15 * time.Second
+	eventStreamWrite     = 10 * time.Second
+)
+
+// eventStreamUpgrader keeps the default origin check: browsers may only open the
+// stream from the page that serves the management API.
+var eventStreamUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// eventStream is one subscriber of the live event stream. Publishing never
+// blocks: events that do not fit into the buffer are dropped and reported to
+// the client as a "dropped" message before the next event.
+type eventStream struct {
+	ch      chan events.Event
+	types   map[events.Type]struct{}
+	apiKey  string
+	dropped atomic.Uint64
+}
+
+func newEventStream(c *gin.Context) *eventStream {
+	s := &eventStream{ch: make(chan events.Event, eventStreamBuffer), apiKey: strings.TrimSpace(c.Query("api-key"))}
+	for _, raw := range strings.Split(c.Query("types"), ",") {
+		if t := strings.TrimSpace(raw); t != "" {
+			if s.types == nil {
+				s.types = make(map[events.Type]struct{})
+			}
+			s.types[events.Type(t)] = struct{}{}
+		}
+	}
+	return s
+}
+
+func (s *eventStream) handle(ev events.Event) {
+	if s.types != nil {
+		if _, ok := s.types[ev.Type]; !ok {
+			return
+		}
+	}
+	if s.apiKey != "" && ev.APIKey != s.apiKey {
+		return
+	}
+	if ev.APIKey != "" {
+		ev.APIKey = device.MaskKey(ev.APIKey)
+	}
+	select {
+	case s.ch <- ev:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// CloseEventStreams ends the live event streams, which would otherwise keep a
+// graceful shutdown waiting until its drain timeout.
+func (h *Handler) CloseEventStreams() {
+	h.closeStreamsOne.Do(func() {
+		if h.streamsDone != nil {
+			close(h.streamsDone)
+		}
+	})
+}
+
+// StreamEvents streams proxy events (bans, device registrations, upstream
+// failovers, rate limit hits, ...) as they are published. The stream is
+// Server-Sent Events unless the request asks for a WebSocket upgrade. Optional
+// query parameters: types (comma-separated event types) and api-key. API keys
+// are masked in the streamed events.
+// GET /v0/management/events
+func (h *Handler) StreamEvents(c *gin.Context) {
+	stream := newEventStream(c)
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		h.streamEventsWebSocket(c, stream)
+		return
+	}
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "streaming unsupported"})
+		return
+	}
+	unsubscribe := events.Subscribe(stream.handle)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	_, _ = fmt.Fprint(c.Writer, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-h.streamsDone:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+		case ev := <-stream.ch:
+			if n := stream.dropped.Swap(0); n > 0 {
+				_, _ = fmt.Fprintf(c.Writer, "event: dropped\ndata: {\"count\":%d}\n\n", n)
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			if _, err = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// streamEventsWebSocket sends each event as a JSON text message. Messages from
+// the client are ignored; reading only notices when it goes away.
+func (h *Handler) streamEventsWebSocket(c *gin.Context, stream *eventStream) {
+	conn, err := eventStreamUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	unsubscribe := events.Subscribe(stream.handle)
+	defer unsubscribe()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, errRead := conn.ReadMessage(); errRead != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-h.streamsDone:
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(eventStreamWrite))
+			return
+		case <-keepAlive.C:
+			if errPing := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventStreamWrite)); errPing != nil {
+				return
+			}
+		case ev := <-stream.ch:
+			_ = conn.SetWriteDeadline(time.Now().Add(eventStreamWrite))
+			if n := stream.dropped.Swap(0); n > 0 {
+				if errWrite := conn.WriteJSON(gin.H{"type": "dropped", "data": gin.H{"count": n}}); errWrite != nil {
+					return
+				}
+			}
+			if errWrite := conn.WriteJSON(ev); errWrite != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package management
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+)
+
+func TestStreamEventsFiltersByTypeAndMaskedKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{}
+	engine := gin.New()
+	engine.GET("/v0/management/events", h.StreamEvents)
+	srv := httptest.NewServer(engine)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v0/management/events?types=ban,upstream_failover&api-key=sk-stream-key-0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("unexpected preamble %q", line)
+	}
+
+	events.Publish(events.Event{Type: events.TypeRateLimited, APIKey: "sk-stream-key-0001"})
+	events.Publish(events.Event{Type: events.TypeBan, APIKey: "sk-stream-key-0002"})
+	events.Publish(events.Event{Type: events.TypeBan, APIKey: "sk-stream-key-0001", Reason: "abuse"})
+
+	lines := make(chan string)
+	go func() {
+		for {
+			line, errRead := reader.ReadString('\n')
+			if errRead != nil {
+				close(lines)
+				return
+			}
+			if line = strings.TrimSpace(line); line != "" {
+				lines <- line
+			}
+		}
+	}()
+	var got []string
+	for len(got) < 2 {
+		select {
+		case line := <-lines:
+			got = append(got, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out, got %v", got)
+		}
+	}
+	if got[0] != "event: ban" || !strings.Contains(got[1], `"api_key":"sk-s****0001"`) || !strings.Contains(got[1], `"reason":"abuse"`) {
+		t.Fatalf("expected only the masked ban of the first key, got %v", got)
+	}
+}
+
+func TestStreamEventsRefusesCrossOriginWebSockets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{}
+	engine := gin.New()
+	engine.GET("/v0/management/events", h.StreamEvents)
+	srv := httptest.NewServer(engine)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v0/management/events"
+	if _, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}}); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a cross-origin upgrade to be refused, got %v", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatalf("same-origin upgrade: %v", err)
+	}
+	_ = conn.Close()
+}
+
+func TestStreamEventsEndOnShutdown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{streamsDone: make(chan struct{})}
+	engine := gin.New()
+	engine.GET("/v0/management/events", h.StreamEvents)
+	srv := httptest.NewServer(engine)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v0/management/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	reader := bufio.NewReader(resp.Body)
+	if _, err = reader.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	h.CloseEventStreams()
+	done := make(chan error, 1)
+	go func() {
+		_, errRead := io.ReadAll(reader)
+		done <- errRead
+	}()
+	select {
+	case errRead := <-done:
+		if errRead != nil {
+			t.Fatalf("stream ended with %v", errRead)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still open after shutdown")
+	}
+}
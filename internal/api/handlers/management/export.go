@@ -0,0 +1,117 @@
+package management
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/bundle"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// maxImportSize bounds the body of an import request.
+const maxImportSize = 64 << 20
+
+// Export returns a bundle of the device bindings, bans, per-key settings and
+// key groups. The bundle holds client API keys and must be kept secret.
+// GET /v0/management/export?format=json|yaml
+func (h *Handler) Export(c *gin.Context) {
+	format := strings.ToLower(strings.TrimSpace(c.DefaultQuery("format", bundle.FormatJSON)))
+	if format != bundle.FormatJSON && format != bundle.FormatYAML {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid format", "message": "format must be json or yaml"})
+		return
+	}
+	now := time.Now()
+	h.mu.Lock()
+	b := bundle.Export(h.cfg, h.deviceStore, now)
+	data, err := bundle.Encode(b, format)
+	h.mu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "export_failed", "message": err.Error()})
+		return
+	}
+	contentType := "application/json"
+	if format == bundle.FormatYAML {
+		contentType = "application/yaml"
+	}
+	c.Header("Content-Disposition", `attachment; filename="cc-proxy-export-`+now.UTC().Format("20060102-150405")+`.`+format+`"`)
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// Import applies a bundle written by Export. mode=merge (default) adds to the
+// current state and replaces entries of the same key or group; mode=replace
+// discards the current bindings and per-key settings first. With dry_run=true
+// nothing is written and the report describes what would change.
+// POST /v0/management/import?mode=merge|replace&dry_run=true
+func (h *Handler) Import(c *gin.Context) {
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxImportSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body", "message": err.Error()})
+		return
+	}
+	if len(data) > maxImportSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "bundle too large"})
+		return
+	}
+	b, err := bundle.Parse(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bundle", "message": err.Error()})
+		return
+	}
+	mode := strings.ToLower(strings.TrimSpace(c.DefaultQuery("mode", bundle.ModeMerge)))
+	if mode != bundle.ModeMerge && mode != bundle.ModeReplace {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid mode", "message": "mode must be merge or replace"})
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// Settings are applied to a copy so a failed or dry-run import leaves the
+	// running configuration untouched.
+	target, err := cloneConfig(h.cfg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "import_failed", "message": err.Error()})
+		return
+	}
+	report, err := bundle.Import(target, h.deviceStore, b, mode, dryRun)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, bundle.ErrBindingsUnsupported) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": "import_failed", "message": err.Error()})
+		return
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+	target.Access.Providers = nil
+	if err = config.SaveConfigPreserveComments(h.configFilePath, target); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save config: " + err.Error(), "report": report})
+		return
+	}
+	h.cfg = target
+	log.Infof("management: imported bundle (%s): %d bindings, %d keys added, %d settings, %d groups",
+		mode, report.Bindings, report.APIKeysAdded, report.Settings, report.Groups)
+	c.JSON(http.StatusOK, report)
+}
+
+// cloneConfig deep-copies cfg through its YAML form, which holds every field.
+func cloneConfig(cfg *config.Config) (*config.Config, error) {
+	raw, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var clone config.Config
+	if err = yaml.Unmarshal(raw, &clone); err != nil {
+		return nil, err
+	}
+	return &clone, nil
+}
@@ -13,8 +13,18 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/admintokens"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/apikeys"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/backup"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/buildinfo"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/canary"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/companion"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/confighistory"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/ipacl"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/recovery"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/spend"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
 	sdkAuth "github.com/router-for-me/CLIProxyAPI/v6/sdk/auth"
 	coreauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
@@ -33,6 +43,12 @@ const attemptCleanupInterval = 1 * time.Hour
 // attemptMaxIdleTime controls how long an IP can be idle before cleanup
 const attemptMaxIdleTime = 2 * time.Hour
 
+// maxFailedAttempts failed key checks from one IP trigger a ban of failedAttemptBan
+const (
+	maxFailedAttempts = 5
+	failedAttemptBan  = 30 * time.Minute
+)
+
 // Handler aggregates config reference, persistence path and helpers.
 type Handler struct {
 	cfg                 *config.Config
@@ -42,11 +58,25 @@ type Handler struct {
 	failedAttempts      map[string]*attemptInfo // keyed by client IP
 	authManager         *coreauth.Manager
 	usageStats          *usage.RequestStatistics
+	keyUsage            *usage.KeyUsageRecorder
+	spend               *spend.Tracker
+	ipAccess            *ipacl.Lists
+	apiKeys             *apikeys.Manager
+	deviceStore         device.Store
+	configHistory       *confighistory.History
+	canary              *canary.Controller
+	backups             *backup.Manager
+	companion           *companion.Hub
 	tokenStore          coreauth.Store
 	localPassword       string
 	allowRemoteOverride bool
 	envSecret           string
 	logDir              string
+	recovery            *recovery.Manager
+	adminTokens         *admintokens.Manager
+	// streamsDone is closed on shutdown to end live event streams.
+	streamsDone     chan struct{}
+	closeStreamsOne sync.Once
 }
 
 // NewHandler creates a new management handler instance.
@@ -63,6 +93,7 @@ func NewHandler(cfg *config.Config, configFilePath string, manager *coreauth.Man
 		tokenStore:          sdkAuth.GetTokenStore(),
 		allowRemoteOverride: envSecret != "",
 		envSecret:           envSecret,
+		streamsDone:         make(chan struct{}),
 	}
 	h.startAttemptCleanup()
 	return h
@@ -112,6 +143,9 @@ func (h *Handler) SetAuthManager(manager *coreauth.Manager) { h.authManager = ma
 // SetUsageStatistics allows replacing the usage statistics reference.
 func (h *Handler) SetUsageStatistics(stats *usage.RequestStatistics) { h.usageStats = stats }
 
+// SetKeyUsageRecorder sets the daily per-key token accounting served by GET /usage.
+func (h *Handler) SetKeyUsageRecorder(recorder *usage.KeyUsageRecorder) { h.keyUsage = recorder }
+
 // SetLocalPassword configures the runtime-local password accepted for localhost requests.
 func (h *Handler) SetLocalPassword(password string) { h.localPassword = password }
 
@@ -132,9 +166,6 @@ func (h *Handler) SetLogDirectory(dir string) {
 // All requests (local and remote) require a valid management key.
 // Additionally, remote access requires allow-remote-management=true.
 func (h *Handler) Middleware() gin.HandlerFunc {
-	const maxFailures = 5
-	const banDuration = 30 * time.Minute
-
 	return func(c *gin.Context) {
 		c.Header("X-CPA-VERSION", buildinfo.Version)
 		c.Header("X-CPA-COMMIT", buildinfo.Commit)
@@ -142,15 +173,17 @@ func (h *Handler) Middleware() gin.HandlerFunc {
 
 		clientIP := c.ClientIP()
 		localClient := clientIP == "127.0.0.1" || clientIP == "::1"
-		cfg := h.cfg
 		var (
 			allowRemote bool
 			secretHash  string
 		)
-		if cfg != nil {
+		// Recovery replaces the key under h.mu.
+		h.mu.Lock()
+		if cfg := h.cfg; cfg != nil {
 			allowRemote = cfg.RemoteManagement.AllowRemote
 			secretHash = cfg.RemoteManagement.SecretKey
 		}
+		h.mu.Unlock()
 		if h.allowRemoteOverride {
 			allowRemote = true
 		}
@@ -189,8 +222,8 @@ func (h *Handler) Middleware() gin.HandlerFunc {
 				}
 				aip.count++
 				aip.lastActivity = time.Now()
-				if aip.count >= maxFailures {
-					aip.blockedUntil = time.Now().Add(banDuration)
+				if aip.count >= maxFailedAttempts {
+					aip.blockedUntil = time.Now().Add(failedAttemptBan)
 					aip.count = 0
 				}
 				h.attemptsMu.Unlock()
@@ -223,6 +256,11 @@ func (h *Handler) Middleware() gin.HandlerFunc {
 			return
 		}
 
+		// Scoped tokens are checked first; an unknown token falls through to the key check below.
+		if h.adminTokens != nil && strings.HasPrefix(provided, admintokens.Prefix) && h.authorizeAdminToken(c, provided) {
+			return
+		}
+
 		if localClient {
 			if lp := h.localPassword; lp != "" {
 				if subtle.ConstantTimeCompare([]byte(provided), []byte(lp)) == 1 {
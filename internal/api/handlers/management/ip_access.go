@@ -0,0 +1,111 @@
+package management
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/ipacl"
+)
+
+// SetIPAccess sets the per-key IP lists updated by the ip-access endpoints.
+func (h *Handler) SetIPAccess(lists *ipacl.Lists) { h.ipAccess = lists }
+
+// GetIPAccess returns the IP rules of one key, or of every key with keys masked.
+// GET /v0/management/ip-access?api-key=xxx
+func (h *Handler) GetIPAccess(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if apiKey != "" {
+		rule, ok := h.cfg.IPAccess.Keys[apiKey]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "message": "No IP rules for this API key"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"api_key": apiKey, "allow": rule.Allow, "deny": rule.Deny})
+		return
+	}
+	keys := make(map[string]config.IPAccessRule, len(h.cfg.IPAccess.Keys))
+	for key, rule := range h.cfg.IPAccess.Keys {
+		keys[device.MaskKey(key)] = rule
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// PutIPAccess replaces the allow and deny lists of a key. Entries are CIDRs or
+// single addresses; deny wins and a non-empty allow list rejects other addresses.
+// PUT /v0/management/ip-access {"api_key":"xxx","allow":["10.0.0.0/8"],"deny":["10.66.0.0/16"]}
+func (h *Handler) PutIPAccess(c *gin.Context) {
+	var body struct {
+		APIKey string   `json:"api_key"`
+		Allow  []string `json:"allow"`
+		Deny   []string `json:"deny"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.APIKey) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body", "message": "api_key is required"})
+		return
+	}
+	allow, err := ipacl.NormalizeEntries(body.Allow)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid allow list", "message": err.Error()})
+		return
+	}
+	deny, err := ipacl.NormalizeEntries(body.Deny)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid deny list", "message": err.Error()})
+		return
+	}
+	apiKey := strings.TrimSpace(body.APIKey)
+
+	h.mu.Lock()
+	if len(allow) == 0 && len(deny) == 0 {
+		delete(h.cfg.IPAccess.Keys, apiKey)
+	} else {
+		if h.cfg.IPAccess.Keys == nil {
+			h.cfg.IPAccess.Keys = make(map[string]config.IPAccessRule)
+		}
+		h.cfg.IPAccess.Keys[apiKey] = config.IPAccessRule{Allow: allow, Deny: deny}
+	}
+	err = h.saveIPAccessLocked()
+	h.mu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save config: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_key": apiKey, "allow": allow, "deny": deny})
+}
+
+// DeleteIPAccess removes the IP rules of a key.
+// DELETE /v0/management/ip-access?api-key=xxx
+func (h *Handler) DeleteIPAccess(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	if apiKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "api-key is required"})
+		return
+	}
+	h.mu.Lock()
+	if _, ok := h.cfg.IPAccess.Keys[apiKey]; !ok {
+		h.mu.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "message": "No IP rules for this API key"})
+		return
+	}
+	delete(h.cfg.IPAccess.Keys, apiKey)
+	err := h.saveIPAccessLocked()
+	h.mu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save config: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// saveIPAccessLocked applies the configured rules and persists the config. Callers hold h.mu.
+func (h *Handler) saveIPAccessLocked() error {
+	if h.ipAccess != nil {
+		h.ipAccess.Update(h.cfg.IPAccess)
+	}
+	return config.SaveConfigPreserveComments(h.configFilePath, h.cfg)
+}
@@ -0,0 +1,190 @@
+package management
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/apikeys"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// SetAPIKeyManager sets the key lifecycle manager used by the key endpoints.
+func (h *Handler) SetAPIKeyManager(manager *apikeys.Manager) { h.apiKeys = manager }
+
+// SetDeviceStore sets the device binding store that key rotation and revocation update.
+func (h *Handler) SetDeviceStore(store device.Store) { h.deviceStore = store }
+
+// ListKeys returns the client API keys with their lifecycle state. Keys whose
+// rotation grace window ended are removed first.
+// GET /v0/management/keys
+func (h *Handler) ListKeys(c *gin.Context) {
+	if h.apiKeys == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "key management unavailable"})
+		return
+	}
+	h.mu.Lock()
+	if h.apiKeys.Prune(h.cfg) {
+		if err := config.SaveConfigPreserveComments(h.configFilePath, h.cfg); err != nil {
+			log.Warnf("api keys: failed to save pruned keys: %v", err)
+		}
+	}
+	keys := h.apiKeys.List(h.cfg)
+	h.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// CreateKey mints a random key with the configured prefix and adds it to api-keys.
+// POST /v0/management/keys
+func (h *Handler) CreateKey(c *gin.Context) {
+	if h.apiKeys == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "key management unavailable"})
+		return
+	}
+	key, err := h.apiKeys.Generate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.mu.Lock()
+	h.apiKeys.Prune(h.cfg)
+	h.cfg.APIKeys = append(h.cfg.APIKeys, key)
+	h.cfg.Access.Providers = nil
+	err = config.SaveConfigPreserveComments(h.configFilePath, h.cfg)
+	h.mu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save config: " + err.Error()})
+		return
+	}
+	events.Publish(events.Event{Type: events.TypeKeyCreated, APIKey: key, Actor: "admin"})
+	c.JSON(http.StatusCreated, apikeys.KeyInfo{Key: key, State: apikeys.StateActive})
+}
+
+// RotateKey mints a successor for a key. The new key inherits the old key's
+// per-key settings and binding policy; the old key keeps working for the
+// grace window (grace_seconds, default rotation-grace) and is removed after.
+// POST /v0/management/keys/rotate
+// {"key": "...", "grace_seconds": 3600}
+func (h *Handler) RotateKey(c *gin.Context) {
+	if h.apiKeys == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "key management unavailable"})
+		return
+	}
+	var body struct {
+		Key          string `json:"key"`
+		GraceSeconds *int64 `json:"grace_seconds"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Key) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body", "message": "key is required"})
+		return
+	}
+	if body.GraceSeconds != nil && *body.GraceSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "grace_seconds must be >= 0"})
+		return
+	}
+	oldKey := strings.TrimSpace(body.Key)
+	grace := h.apiKeys.RotationGrace()
+	if body.GraceSeconds != nil {
+		grace = time.Duration(*body.GraceSeconds) * time.Second
+	}
+	newKey, err := h.apiKeys.Generate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.mu.Lock()
+	h.apiKeys.Prune(h.cfg)
+	if !containsKey(h.cfg.APIKeys, oldKey) {
+		h.mu.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+		return
+	}
+	if expiresAt, ok := h.cfg.APIKeyLifecycle.Expiring[oldKey]; ok {
+		h.mu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "api key is already being rotated out", "expires_at": expiresAt})
+		return
+	}
+	expiresAt := time.Now().Add(grace).UTC()
+	h.cfg.APIKeys = append(h.cfg.APIKeys, newKey)
+	h.cfg.Access.Providers = nil
+	if h.cfg.APIKeyLifecycle.Expiring == nil {
+		h.cfg.APIKeyLifecycle.Expiring = make(map[string]time.Time)
+	}
+	h.cfg.APIKeyLifecycle.Expiring[oldKey] = expiresAt
+	apikeys.CopySettings(h.cfg, oldKey, newKey)
+	err = config.SaveConfigPreserveComments(h.configFilePath, h.cfg)
+	h.mu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save config: " + err.Error()})
+		return
+	}
+	h.apiKeys.Expire(oldKey, expiresAt)
+	if errCopy := apikeys.CopyBinding(h.deviceStore, oldKey, newKey); errCopy != nil {
+		log.Warnf("api keys: failed to copy device binding policy to rotated key: %v", errCopy)
+	}
+	events.Publish(events.Event{Type: events.TypeKeyRotated, APIKey: oldKey, Actor: "admin", Data: map[string]any{"expires_at": expiresAt}})
+	log.Infof("api key rotated; old key expires at %s", expiresAt.Format(time.RFC3339))
+	c.JSON(http.StatusCreated, gin.H{
+		"key":         apikeys.KeyInfo{Key: newKey, State: apikeys.StateActive},
+		"rotated_key": apikeys.KeyInfo{Key: oldKey, State: apikeys.StateExpiring, ExpiresAt: &expiresAt},
+	})
+}
+
+// RevokeKey invalidates a key immediately: it is refused from now on and
+// removed from api-keys, every per-key setting and the device binding store.
+// POST /v0/management/keys/revoke
+// {"key": "..."}
+func (h *Handler) RevokeKey(c *gin.Context) {
+	if h.apiKeys == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "key management unavailable"})
+		return
+	}
+	var body struct {
+		Key string `json:"key"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Key) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body", "message": "key is required"})
+		return
+	}
+	key := strings.TrimSpace(body.Key)
+
+	h.mu.Lock()
+	if !apikeys.RemoveKey(h.cfg, key) {
+		h.mu.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+		return
+	}
+	h.apiKeys.Revoke(key)
+	apikeys.RemoveSettings(h.cfg, key)
+	h.cfg.Access.Providers = nil
+	err := config.SaveConfigPreserveComments(h.configFilePath, h.cfg)
+	h.mu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save config: " + err.Error()})
+		return
+	}
+	bindingRemoved := false
+	if h.deviceStore != nil {
+		if bindingRemoved, err = h.deviceStore.Delete(key); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "key revoked but removing its device binding failed: " + err.Error()})
+			return
+		}
+	}
+	events.Publish(events.Event{Type: events.TypeKeyRevoked, APIKey: key, Actor: "admin"})
+	c.JSON(http.StatusOK, gin.H{"status": "revoked", "device_binding_removed": bindingRemoved})
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, existing := range keys {
+		if existing == key {
+			return true
+		}
+	}
+	return false
+}
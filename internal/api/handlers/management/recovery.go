@@ -0,0 +1,134 @@
+package management
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/recovery"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minManagementKeyLength is the shortest management key accepted by recovery.
+const minManagementKeyLength = 12
+
+// SetRecovery sets the manager redeeming recovery codes and break-glass tokens.
+func (h *Handler) SetRecovery(m *recovery.Manager) { h.recovery = m }
+
+// RecoverManagementKey replaces the management key after a valid recovery code
+// or break-glass token. It is served without management authentication, so
+// failures count towards the same per-IP ban as invalid management keys.
+func (h *Handler) RecoverManagementKey(c *gin.Context) {
+	if h.recovery == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	clientIP := c.ClientIP()
+	localClient := clientIP == "127.0.0.1" || clientIP == "::1"
+	if !localClient {
+		h.mu.Lock()
+		allowRemote := h.cfg != nil && h.cfg.RemoteManagement.AllowRemote
+		h.mu.Unlock()
+		if !allowRemote {
+			c.JSON(http.StatusForbidden, gin.H{"error": "remote management disabled"})
+			return
+		}
+		if remaining := h.banRemaining(clientIP); remaining > 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "IP banned due to too many failed attempts. Try again in " + remaining.String()})
+			return
+		}
+	}
+
+	var body struct {
+		Code      string `json:"code"`
+		SecretKey string `json:"secret-key"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	secret := strings.TrimSpace(body.SecretKey)
+	if len(secret) < minManagementKeyLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "secret-key must be at least 12 characters"})
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash secret-key"})
+		return
+	}
+	// The credential is only consumed once the new key is saved, so a failed
+	// config write does not lock the operator out.
+	var errSave error
+	method, err := h.recovery.Redeem(body.Code, clientIP, func(string) error {
+		errSave = h.replaceSecretKey(string(hashed))
+		return errSave
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, recovery.ErrInvalidCredential):
+			h.recordFailedAttempt(clientIP)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid recovery code"})
+		case errSave != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to save config: %v", errSave)})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	h.attemptsMu.Lock()
+	delete(h.failedAttempts, clientIP)
+	h.attemptsMu.Unlock()
+	log.Warnf("management key replaced via %s", method)
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// replaceSecretKey saves hashed as the management key and, once the config
+// file is written, switches the in-memory key to it.
+func (h *Handler) replaceSecretKey(hashed string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cfg == nil {
+		return errors.New("no configuration loaded")
+	}
+	next := *h.cfg
+	next.RemoteManagement.SecretKey = hashed
+	if err := config.SaveConfigPreserveComments(h.configFilePath, &next); err != nil {
+		return err
+	}
+	h.cfg.RemoteManagement.SecretKey = hashed
+	return nil
+}
+
+// banRemaining returns how long clientIP stays banned, or 0.
+func (h *Handler) banRemaining(clientIP string) time.Duration {
+	h.attemptsMu.Lock()
+	defer h.attemptsMu.Unlock()
+	if ai := h.failedAttempts[clientIP]; ai != nil && time.Now().Before(ai.blockedUntil) {
+		return time.Until(ai.blockedUntil).Round(time.Second)
+	}
+	return 0
+}
+
+// recordFailedAttempt counts a failed credential check towards the per-IP ban.
+func (h *Handler) recordFailedAttempt(clientIP string) {
+	h.attemptsMu.Lock()
+	defer h.attemptsMu.Unlock()
+	ai := h.failedAttempts[clientIP]
+	if ai == nil {
+		ai = &attemptInfo{}
+		h.failedAttempts[clientIP] = ai
+	}
+	ai.count++
+	ai.lastActivity = time.Now()
+	if ai.count >= maxFailedAttempts {
+		ai.blockedUntil = time.Now().Add(failedAttemptBan)
+		ai.count = 0
+	}
+}
@@ -0,0 +1,59 @@
+package management
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/recovery"
+)
+
+func TestRecoverManagementKeyOnlyConsumesCodeOnceSaved(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	rec := recovery.New(dir, filepath.Join(dir, "recovery.jsonl"))
+	h := &Handler{
+		cfg:            &config.Config{RemoteManagement: config.RemoteManagement{SecretKey: "old-hash"}},
+		configFilePath: filepath.Join(dir, "missing", "config.yaml"),
+		failedAttempts: make(map[string]*attemptInfo),
+		recovery:       rec,
+	}
+	engine := gin.New()
+	engine.POST("/recover", h.RecoverManagementKey)
+	redeem := func(code string) int {
+		req := httptest.NewRequest(http.MethodPost, "/recover", strings.NewReader(`{"code":"`+code+`","secret-key":"new-management-key"}`))
+		req.RemoteAddr = "127.0.0.1:1234"
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		engine.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	code, err := rec.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := redeem(code); status != http.StatusInternalServerError {
+		t.Fatalf("unwritable config: status %d", status)
+	}
+	if h.cfg.RemoteManagement.SecretKey != "old-hash" {
+		t.Fatal("key changed although the config could not be saved")
+	}
+
+	h.configFilePath = filepath.Join(dir, "config.yaml")
+	if err = os.WriteFile(h.configFilePath, []byte("port: 8317\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The failed attempt must not have used up the code
+	if status := redeem(code); status != http.StatusOK {
+		t.Fatalf("recover with the same code: status %d", status)
+	}
+	if h.cfg.RemoteManagement.SecretKey == "old-hash" {
+		t.Fatal("key not replaced after a successful save")
+	}
+}
@@ -0,0 +1,113 @@
+package management
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	coreauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+	log "github.com/sirupsen/logrus"
+)
+
+type rotationRequest struct {
+	OldID        string  `json:"old_id"`
+	NewID        string  `json:"new_id"`
+	DrainSeconds int64   `json:"drain_seconds"`
+	MaxErrorRate float64 `json:"max_error_rate"`
+}
+
+// ListAuthRotations returns all in-progress upstream credential rotations.
+// GET /v0/management/auth-rotations
+func (h *Handler) ListAuthRotations(c *gin.Context) {
+	if h.authManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "core auth manager unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rotations": h.authManager.Rotations()})
+}
+
+// StartAuthRotation starts draining traffic from an old upstream credential to
+// a new one that was already added through the auth file or config endpoints.
+// POST /v0/management/auth-rotations
+func (h *Handler) StartAuthRotation(c *gin.Context) {
+	if h.authManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "core auth manager unavailable"})
+		return
+	}
+	var body rotationRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	if body.DrainSeconds < 0 || body.MaxErrorRate < 0 || body.MaxErrorRate > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "drain_seconds must be >= 0 and max_error_rate between 0 and 1"})
+		return
+	}
+	status, err := h.authManager.StartRotation(strings.TrimSpace(body.OldID), strings.TrimSpace(body.NewID), time.Duration(body.DrainSeconds)*time.Second, body.MaxErrorRate)
+	if err != nil {
+		writeRotationError(c, err, nil)
+		return
+	}
+	log.Infof("auth rotation started: %s -> %s over %s", status.OldID, status.NewID, status.DrainPeriod)
+	c.JSON(http.StatusCreated, status)
+}
+
+// RetireAuthRotation disables the old credential once it has drained and the
+// new credential's error rate is acceptable. force=true skips the checks.
+// POST /v0/management/auth-rotations/retire?old-id=...
+func (h *Handler) RetireAuthRotation(c *gin.Context) {
+	if h.authManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "core auth manager unavailable"})
+		return
+	}
+	oldID := strings.TrimSpace(c.Query("old-id"))
+	if oldID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing old-id"})
+		return
+	}
+	force := c.Query("force") == "true" || c.Query("force") == "1"
+	status, err := h.authManager.RetireRotation(c.Request.Context(), oldID, force)
+	if err != nil {
+		writeRotationError(c, err, &status)
+		return
+	}
+	log.Infof("auth rotation completed: %s retired in favour of %s", status.OldID, status.NewID)
+	c.JSON(http.StatusOK, gin.H{"status": "retired", "rotation": status})
+}
+
+// CancelAuthRotation stops a rotation and returns all traffic to the old credential.
+// DELETE /v0/management/auth-rotations?old-id=...
+func (h *Handler) CancelAuthRotation(c *gin.Context) {
+	if h.authManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "core auth manager unavailable"})
+		return
+	}
+	oldID := strings.TrimSpace(c.Query("old-id"))
+	if oldID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing old-id"})
+		return
+	}
+	if !h.authManager.CancelRotation(oldID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rotation not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func writeRotationError(c *gin.Context, err error, status *coreauth.RotationStatus) {
+	code := http.StatusInternalServerError
+	resp := gin.H{"error": err.Error()}
+	var authErr *coreauth.Error
+	if errors.As(err, &authErr) {
+		if authErr.HTTPStatus != 0 {
+			code = authErr.HTTPStatus
+		}
+		resp = gin.H{"error": authErr.Code, "message": authErr.Message}
+	}
+	if status != nil && status.OldID != "" {
+		resp["rotation"] = status
+	}
+	c.JSON(code, resp)
+}
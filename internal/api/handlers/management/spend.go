@@ -0,0 +1,74 @@
+package management
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/spend"
+)
+
+// SetSpendTracker sets the spend tracker served by the spend endpoints.
+func (h *Handler) SetSpendTracker(tracker *spend.Tracker) { h.spend = tracker }
+
+// GetSpend returns the current month's spend and cap per key
+// GET /v0/management/spend?api-key=xxx
+func (h *Handler) GetSpend(c *gin.Context) {
+	if h.spend == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "spend tracking unavailable"})
+		return
+	}
+	period, resetsAt := h.spend.Period()
+	response := gin.H{
+		"enabled":   h.spend.Enabled(),
+		"period":    period,
+		"resets_at": resetsAt,
+	}
+	if apiKey := strings.TrimSpace(c.Query("api-key")); apiKey != "" {
+		response["keys"] = []spend.KeySpend{h.spend.KeySpend(apiKey)}
+	} else {
+		response["keys"] = h.spend.Keys()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// PutSpendCap sets the monthly spend cap of one key and persists it to the config.
+// A negative cap removes the per-key override so the default cap applies again.
+// PUT /v0/management/spend/caps
+// {"api_key": "...", "cap": 100}
+func (h *Handler) PutSpendCap(c *gin.Context) {
+	var body struct {
+		APIKey string   `json:"api_key"`
+		Cap    *float64 `json:"cap"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.APIKey) == "" || body.Cap == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body", "message": "api_key and cap are required"})
+		return
+	}
+	apiKey := strings.TrimSpace(body.APIKey)
+
+	h.mu.Lock()
+	if *body.Cap < 0 {
+		delete(h.cfg.Spend.Keys, apiKey)
+	} else {
+		if h.cfg.Spend.Keys == nil {
+			h.cfg.Spend.Keys = make(map[string]float64)
+		}
+		h.cfg.Spend.Keys[apiKey] = *body.Cap
+	}
+	if h.spend != nil {
+		h.spend.Update(h.cfg.Spend)
+	}
+	err := config.SaveConfigPreserveComments(h.configFilePath, h.cfg)
+	h.mu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save config: " + err.Error()})
+		return
+	}
+	if h.spend == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		return
+	}
+	c.JSON(http.StatusOK, h.spend.KeySpend(apiKey))
+}
@@ -0,0 +1,67 @@
+package management
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	coreauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+)
+
+// GetUpstreamHealth returns the health of every tracked upstream endpoint.
+// GET /v0/management/upstream-health
+func (h *Handler) GetUpstreamHealth(c *gin.Context) {
+	if h.authManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "core auth manager unavailable"})
+		return
+	}
+	enabled := h.cfg != nil && h.cfg.Routing.HealthCheck.Enabled
+	c.JSON(http.StatusOK, gin.H{"enabled": enabled, "endpoints": h.authManager.UpstreamHealth()})
+}
+
+// GetCircuitBreakers returns the circuit breaker state of every upstream endpoint.
+// GET /v0/management/circuit-breakers
+func (h *Handler) GetCircuitBreakers(c *gin.Context) {
+	if h.authManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "core auth manager unavailable"})
+		return
+	}
+	enabled := h.cfg != nil && h.cfg.Routing.CircuitBreaker.Enabled
+	c.JSON(http.StatusOK, gin.H{"enabled": enabled, "circuits": h.authManager.CircuitBreakers()})
+}
+
+// ResetCircuitBreakers closes the circuit of one endpoint, or of all endpoints
+// when no endpoint is given.
+// POST /v0/management/circuit-breakers/reset?endpoint=https://api.example.com
+func (h *Handler) ResetCircuitBreakers(c *gin.Context) {
+	if h.authManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "core auth manager unavailable"})
+		return
+	}
+	endpoint := strings.TrimRight(strings.TrimSpace(c.Query("endpoint")), "/")
+	reset := h.authManager.ResetCircuitBreaker(endpoint)
+	if endpoint != "" && reset == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "circuit not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reset": reset})
+}
+
+// GetUpstreamRateLimits returns the rate limits last reported by the upstream
+// for each credential, and until when rate-limited credentials are parked.
+// GET /v0/management/upstream-rate-limits
+func (h *Handler) GetUpstreamRateLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"credentials": coreauth.RateLimitStates()})
+}
+
+// GetFairShareQueues returns the in-flight and queued requests of each
+// upstream credential under fair-share scheduling.
+// GET /v0/management/fair-share/queues
+func (h *Handler) GetFairShareQueues(c *gin.Context) {
+	if h.authManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "core auth manager unavailable"})
+		return
+	}
+	enabled := h.cfg != nil && h.cfg.FairShare.Enabled
+	c.JSON(http.StatusOK, gin.H{"enabled": enabled, "upstreams": h.authManager.FairShareQueues()})
+}
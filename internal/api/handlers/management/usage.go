@@ -2,10 +2,13 @@ package management
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
 )
 
@@ -20,16 +23,81 @@ type usageImportPayload struct {
 	Usage   usage.StatisticsSnapshot `json:"usage"`
 }
 
-// GetUsageStatistics returns the in-memory request statistics snapshot.
+// defaultKeyUsageDays is the date range of daily key usage when no "from" is given.
+const defaultKeyUsageDays = 30
+
+// GetUsageStatistics returns the in-memory request statistics snapshot and the
+// persisted daily token usage per key and model.
+//
+// GET /v0/management/usage?from=2025-01-01&to=2025-01-31&api-key=xxx&model=yyy
+//
+// from and to are inclusive UTC days; to defaults to today and from to 30 days before it.
 func (h *Handler) GetUsageStatistics(c *gin.Context) {
 	var snapshot usage.StatisticsSnapshot
 	if h != nil && h.usageStats != nil {
 		snapshot = h.usageStats.Snapshot()
 	}
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"usage":           snapshot,
 		"failed_requests": snapshot.FailureCount,
-	})
+	}
+	if h != nil && h.keyUsage != nil {
+		filter, err := keyUsageFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_parameter", "message": err.Error()})
+			return
+		}
+		rows, err := h.keyUsage.Query(filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "query_failed", "message": err.Error()})
+			return
+		}
+		totals := make(map[string]*device.KeyUsage)
+		for _, row := range rows {
+			total, ok := totals[row.APIKey]
+			if !ok {
+				total = &device.KeyUsage{APIKey: row.APIKey}
+				totals[row.APIKey] = total
+			}
+			total.Add(row)
+		}
+		response["daily"] = gin.H{
+			"from":   filter.From,
+			"to":     filter.To,
+			"rows":   rows,
+			"totals": totals,
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// keyUsageFilter parses the date range and key/model filters of a usage query.
+func keyUsageFilter(c *gin.Context) (device.KeyUsageFilter, error) {
+	filter := device.KeyUsageFilter{
+		APIKey: strings.TrimSpace(c.Query("api-key")),
+		Model:  strings.TrimSpace(c.Query("model")),
+	}
+	to := time.Now().UTC()
+	if raw := strings.TrimSpace(c.Query("to")); raw != "" {
+		parsed, err := time.Parse(usage.KeyUsageDayLayout, raw)
+		if err != nil {
+			return filter, fmt.Errorf("to must be a date formatted as YYYY-MM-DD")
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -(defaultKeyUsageDays - 1))
+	if raw := strings.TrimSpace(c.Query("from")); raw != "" {
+		parsed, err := time.Parse(usage.KeyUsageDayLayout, raw)
+		if err != nil {
+			return filter, fmt.Errorf("from must be a date formatted as YYYY-MM-DD")
+		}
+		from = parsed
+	}
+	if from.After(to) {
+		return filter, fmt.Errorf("from must not be after to")
+	}
+	filter.From, filter.To = from.Format(usage.KeyUsageDayLayout), to.Format(usage.KeyUsageDayLayout)
+	return filter, nil
 }
 
 // ExportUsageStatistics returns a complete usage snapshot for backup/migration.
@@ -0,0 +1,37 @@
+package management
+
+import "github.com/gin-gonic/gin"
+
+// Management API versions. v0 is the original surface and stays available as a
+// compatibility shim; new automation should target v1.
+const (
+	APIVersionV0 = "v0"
+	APIVersionV1 = "v1"
+
+	// LatestAPIVersion is the newest management API version.
+	LatestAPIVersion = APIVersionV1
+
+	apiVersionContextKey = "managementAPIVersion"
+	apiVersionHeader     = "X-Management-API-Version"
+)
+
+// VersionMiddleware records the management API version serving the request and
+// echoes it in the X-Management-API-Version response header.
+func VersionMiddleware(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiVersionContextKey, version)
+		c.Header(apiVersionHeader, version)
+		c.Next()
+	}
+}
+
+// APIVersion returns the management API version serving the request, so handlers
+// shared between versions can adapt their responses. It defaults to v0.
+func APIVersion(c *gin.Context) string {
+	if version, ok := c.Get(apiVersionContextKey); ok {
+		if v, okStr := version.(string); okStr && v != "" {
+			return v
+		}
+	}
+	return APIVersionV0
+}
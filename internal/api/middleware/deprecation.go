@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecation describes a deprecated API surface and where clients should move.
+type Deprecation struct {
+	// Since is when the surface was deprecated; zero reports it as deprecated without a date.
+	Since time.Time
+	// Sunset is when the surface will be removed; zero omits the Sunset header.
+	Sunset time.Time
+	// Successor maps the request path to its replacement; nil omits the successor link.
+	Successor func(path string) string
+}
+
+// DeprecationMiddleware marks responses with the standard Deprecation (RFC 9745),
+// Sunset (RFC 8594) and successor-version Link headers so automation can detect
+// deprecated endpoints before they are removed.
+func DeprecationMiddleware(d Deprecation) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d.Since.IsZero() {
+			c.Header("Deprecation", "true")
+		} else {
+			c.Header("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+		}
+		if !d.Sunset.IsZero() {
+			c.Header("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.Successor != nil {
+			if successor := strings.TrimSpace(d.Successor(c.Request.URL.Path)); successor != "" {
+				c.Writer.Header().Add("Link", "<"+successor+">; rel=\"successor-version\"")
+			}
+		}
+		c.Next()
+	}
+}
+
+// ReplacePrefix returns a Successor function that swaps the path prefix from with to.
+func ReplacePrefix(from, to string) func(string) string {
+	return func(path string) string {
+		if !strings.HasPrefix(path, from) {
+			return ""
+		}
+		return to + strings.TrimPrefix(path, from)
+	}
+}
@@ -110,7 +110,7 @@ func captureRequestInfo(c *gin.Context) (*RequestInfo, error) {
 // It skips management endpoints to avoid leaking secrets but allows
 // all other routes, including module-provided ones, to honor request-log.
 func shouldLogRequest(path string) bool {
-	if strings.HasPrefix(path, "/v0/management") || strings.HasPrefix(path, "/management") {
+	if isManagementPath(path) || strings.HasPrefix(path, "/management") {
 		return false
 	}
 
@@ -120,3 +120,15 @@ func shouldLogRequest(path string) bool {
 
 	return true
 }
+
+// isManagementPath reports whether path belongs to any /vN/management API version.
+func isManagementPath(path string) bool {
+	if !strings.HasPrefix(path, "/v") {
+		return false
+	}
+	version, rest, _ := strings.Cut(path[2:], "/")
+	if version == "" || strings.Trim(version, "0123456789") != "" {
+		return false
+	}
+	return rest == "management" || strings.HasPrefix(rest, "management/")
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+// Client certificate modes accepted in tls.client-auth
+const (
+	clientAuthNone     = "none"
+	clientAuthOptional = "optional"
+	clientAuthRequire  = "require"
+)
+
+// applyClientAuth configures client certificate verification on base according to
+// cfg. base may be nil; the returned config is nil when mutual TLS is off and base is nil.
+func applyClientAuth(base *tls.Config, cfg config.TLSConfig) (*tls.Config, error) {
+	mode := strings.ToLower(strings.TrimSpace(cfg.ClientAuth))
+	var clientAuth tls.ClientAuthType
+	switch mode {
+	case "", clientAuthNone:
+		return base, nil
+	case clientAuthOptional:
+		clientAuth = tls.VerifyClientCertIfGiven
+	case clientAuthRequire:
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown tls.client-auth %q (want none, optional or require)", cfg.ClientAuth)
+	}
+	caPath := strings.TrimSpace(cfg.ClientCA)
+	if caPath == "" {
+		return nil, fmt.Errorf("tls.client-auth %q needs tls.client-ca", mode)
+	}
+	pem, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, fmt.Errorf("read tls.client-ca: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls.client-ca %s contains no PEM certificates", caPath)
+	}
+	out := &tls.Config{}
+	if base != nil {
+		out = base.Clone()
+	}
+	out.ClientAuth = clientAuth
+	out.ClientCAs = pool
+	return out, nil
+}
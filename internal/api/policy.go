@@ -0,0 +1,149 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getEffectivePolicy resolves every policy layer that applies to one client key
+// (plan tier, device binding overrides, request limits and boosts, cost ceiling,
+// spend cap, branding) and reports where each value comes from.
+//
+// GET /v0/management/policy/effective?api-key=xxx
+func (s *Server) getEffectivePolicy(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	if apiKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+	cfg := s.cfg
+
+	var metadata map[string]string
+	response := gin.H{"api_key": apiKey}
+	known := false
+	for _, key := range cfg.APIKeys {
+		if key == apiKey {
+			known = true
+			break
+		}
+	}
+	isTrial := s.trial != nil && s.trial.IsTrialKey(apiKey)
+	response["configured"] = known
+	response["trial"] = isTrial
+
+	deviceInfo := gin.H{"enabled": cfg.DeviceBinding.Enabled}
+	if s.deviceStore != nil {
+		binding, exists := s.deviceStore.Get(apiKey)
+		metadata = binding.Metadata
+		deviceInfo["bound"] = exists
+		deviceInfo["banned"] = binding.Banned
+		if binding.Banned {
+			deviceInfo["ban_reason"] = binding.BanReason
+			if !binding.BanExpiresAt.IsZero() {
+				deviceInfo["ban_expires_at"] = binding.BanExpiresAt
+			}
+		}
+		effective, override := s.deviceMiddleware.PolicyFor(apiKey)
+		deviceInfo["override"] = override
+		deviceInfo["effective"] = gin.H{
+			"max_devices":                  effective.MaxDevices,
+			"detect_concurrent":            effective.DetectConcurrent,
+			"concurrent_threshold_seconds": int(effective.ConcurrentThreshold.Seconds()),
+			"concurrent_action":            effective.ConcurrentAction,
+			"ban_duration_seconds":         int(effective.BanDuration.Seconds()),
+		}
+	}
+	response["device_binding"] = deviceInfo
+	response["metadata"] = metadata
+
+	limit, source, boost := s.limiter.Effective(apiKey)
+	enabled := s.limiter.Enabled()
+	if isTrial {
+		limit, source, boost, enabled = s.trial.Limit(), "trial", nil, true
+	}
+	limitsInfo := gin.H{
+		"enabled":             enabled,
+		"source":              source,
+		"requests_per_minute": limit.RequestsPerMinute,
+		"algorithm":           limit.Algorithm,
+		"daily_requests":      limit.DailyRequests,
+		"tokens_per_minute":   limit.TokensPerMinute,
+		"soft_quota":          limit.SoftQuota,
+		"overage_multiplier":  limit.OverageMultiplier,
+	}
+	if boost != nil {
+		limitsInfo["boost"] = boost
+	}
+	response["limits"] = limitsInfo
+
+	tier, tierSource := s.keyTier(apiKey)
+	weight := 1
+	for name, w := range cfg.FairShare.Tiers {
+		if strings.EqualFold(strings.TrimSpace(name), tier) && w > 0 {
+			weight = w
+		}
+	}
+	priorityLevel := 0
+	for name, level := range cfg.FairShare.TierPriorities {
+		if strings.EqualFold(strings.TrimSpace(name), tier) {
+			priorityLevel = level
+		}
+	}
+	response["plan"] = gin.H{
+		"tier":               tier,
+		"source":             tierSource,
+		"fair_share_enabled": cfg.FairShare.Enabled,
+		"fair_share_weight":  weight,
+		"priority_level":     priorityLevel,
+	}
+
+	maxCost, costSource, mode := s.costCeiling.MaxCost(apiKey)
+	response["cost_ceiling"] = gin.H{
+		"enabled":  s.costCeiling.Enabled(),
+		"max_cost": maxCost,
+		"source":   costSource,
+		"mode":     mode,
+	}
+	keySpend := s.spend.KeySpend(apiKey)
+	response["spend"] = gin.H{
+		"enabled":     s.spend.Enabled(),
+		"monthly_cap": keySpend.Cap,
+		"source":      keySpend.Source,
+		"spent":       keySpend.Spent,
+		"exceeded":    keySpend.Exceeded,
+	}
+
+	var brandingGroup any
+	if group := s.branding.Resolve(apiKey, metadata); group != nil {
+		brandingGroup = group.Name
+	}
+	response["branding_group"] = brandingGroup
+
+	c.JSON(http.StatusOK, response)
+}
+
+// keyTier resolves the plan tier of a client key: an active boost tier, then
+// fair-share key-tiers, then the "tier" attribute of the key metadata.
+func (s *Server) keyTier(apiKey string) (tier, source string) {
+	tier, source = "default", "default"
+	var metadata map[string]string
+	if s.deviceStore != nil {
+		binding, _ := s.deviceStore.Get(apiKey)
+		metadata = binding.Metadata
+	}
+	_, _, boost := s.limiter.Effective(apiKey)
+	switch {
+	case boost != nil && boost.Tier != "":
+		tier, source = boost.Tier, "boost"
+	case s.cfg.FairShare.KeyTiers[apiKey] != "":
+		tier, source = s.cfg.FairShare.KeyTiers[apiKey], "key-tiers"
+	case strings.TrimSpace(metadata["tier"]) != "":
+		tier, source = metadata["tier"], "metadata"
+	}
+	return strings.ToLower(strings.TrimSpace(tier)), source
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/selftest"
+	sdkAuth "github.com/router-for-me/CLIProxyAPI/v6/sdk/auth"
+)
+
+// runSelfTest runs the startup self-test and keeps its report for the management
+// API. Failed fatal checks abort startup unless self-test.warn-only is set.
+func (s *Server) runSelfTest() error {
+	if s.cfg == nil || s.cfg.SelfTest.Disable {
+		return nil
+	}
+	report := selftest.Run(context.Background(), selftest.Checks(s.cfg, sdkAuth.GetTokenStore()))
+	report.Log()
+	s.selfTest.Store(&report)
+	if s.cfg.SelfTest.WarnOnly {
+		return nil
+	}
+	return report.FatalErr()
+}
+
+// getSelfTest returns the report of the startup self-test.
+//
+// GET /v1/management/selftest
+func (s *Server) getSelfTest(c *gin.Context) {
+	report := s.selfTest.Load()
+	if report == nil {
+		c.JSON(http.StatusOK, gin.H{"ran": false})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -7,6 +7,7 @@ package api
 import (
 	"context"
 	"crypto/subtle"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
@@ -19,15 +20,57 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/access"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/admindashboard"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/admintokens"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/alerts"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/analytics"
 	managementHandlers "github.com/router-for-me/CLIProxyAPI/v6/internal/api/handlers/management"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/api/middleware"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/api/modules"
 	ampmodule "github.com/router-for-me/CLIProxyAPI/v6/internal/api/modules/amp"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/apikeys"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/audit"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/backup"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/branding"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/byok"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/canary"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/companion"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/confighistory"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/contentfilter"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/costceiling"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/costexport"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/customroutes"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/degraded"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/errorlog"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/forwardproxy"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/ipacl"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/latencybudget"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/limits"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/logging"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/managementasset"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/notify/discord"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/notify/telegram"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/notify/webhook"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/payloadstats"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/peering"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/pii"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/probe"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/recovery"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/reqfeatures"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/requestclass"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/requestvalidation"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/responsepolicy"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/selftest"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/snapshot"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/spend"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/tlsfingerprint"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/tracing"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/trial"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usagewebhook"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
 	"github.com/router-for-me/CLIProxyAPI/v6/sdk/api/handlers"
@@ -36,12 +79,16 @@ import (
 	"github.com/router-for-me/CLIProxyAPI/v6/sdk/api/handlers/openai"
 	sdkAuth "github.com/router-for-me/CLIProxyAPI/v6/sdk/auth"
 	"github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 )
 
 const oauthCallbackSuccessHTML = `<html><head><meta charset="utf-8"><title>Authentication successful</title><script>setTimeout(function(){window.close();},5000);</script></head><body><h1>Authentication successful!</h1><p>You can close this window.</p><p>This window will close automatically in 5 seconds.</p></body></html>`
 
+// spendSeedRetryInterval is how often loading this month's spend is retried after it failed at startup.
+const spendSeedRetryInterval = 30 * time.Second
+
 type serverOptionConfig struct {
 	extraMiddleware      []gin.HandlerFunc
 	engineConfigurator   func(*gin.Engine)
@@ -120,6 +167,14 @@ type Server struct {
 	// server is the underlying HTTP server.
 	server *http.Server
 
+	// adminEngine and adminServer serve the management API on a dedicated
+	// listener; adminEngine is nil when management shares the proxy listener.
+	adminEngine *gin.Engine
+	adminServer *http.Server
+
+	// forwardProxy intercepts upstream-bound traffic from clients that use it as HTTP or SOCKS5 proxy
+	forwardProxy *forwardproxy.Proxy
+
 	// handlers contains the API handlers for processing requests.
 	handlers *handlers.BaseAPIHandler
 
@@ -156,9 +211,114 @@ type Server struct {
 	ampModule *ampmodule.AmpModule
 
 	// deviceStore and deviceMiddleware handle device binding restrictions
-	deviceStore      *device.Store
+	deviceStore      device.Store
 	deviceMiddleware *device.Middleware
 	deviceHandler    *device.Handler
+	deviceActivity   *device.ActivityLog
+	// tlsFingerprints records the ClientHello fingerprint of TLS connections for device pinning
+	tlsFingerprints *tlsfingerprint.Registry
+
+	// backgroundCancel stops optional background integrations (chat bots, exporters).
+	backgroundCancel context.CancelFunc
+
+	// prober sends synthetic end-to-end requests when enabled.
+	prober *probe.Prober
+
+	// selfTest holds the report of the startup self-test once it has run.
+	selfTest atomic.Pointer[selftest.Report]
+
+	// limiter enforces per-client-key request limits and emits limit headers.
+	limiter *limits.Limiter
+
+	// branding applies reseller headers and support details per key group.
+	branding *branding.Branding
+
+	// contentFilters redacts or blocks prompt and response content per key group.
+	contentFilters *contentfilter.Chain
+
+	// responsePolicy requires completions in a language or without code per key group.
+	responsePolicy *responsepolicy.Enforcer
+
+	// costCeiling caps the worst-case cost of a single request per client key.
+	costCeiling *costceiling.Ceiling
+
+	// trial issues and authenticates anonymous trial keys.
+	trial *trial.Trial
+
+	// byok forwards client-supplied upstream keys for keys in bring-your-own-key mode.
+	byok *byok.BYOK
+
+	// requestValidation rejects oversized and malformed request bodies.
+	requestValidation *requestvalidation.Validator
+
+	// customRoutes rewrites operator-defined paths onto the API endpoints before routing.
+	customRoutes *customroutes.Router
+
+	// verboseLogging switches debug and request logging off after their timeout.
+	verboseLogging verboseLoggingGuard
+
+	// spend prices usage and enforces monthly spend caps per client key.
+	spend *spend.Tracker
+
+	// latencyBudget cancels requests exceeding their per-endpoint or per-key latency budget.
+	latencyBudget *latencybudget.Budgets
+
+	// ipAccess rejects requests from client IPs a key's allow or deny list excludes.
+	ipAccess *ipacl.Lists
+
+	// requestFeatures negotiates the optional X-CC-* behaviours a request asks for.
+	requestFeatures *reqfeatures.Negotiator
+
+	// audit writes one JSONL record per proxied request.
+	audit *audit.Logger
+
+	// accessLog writes one structured, redacted line per HTTP request.
+	accessLog *logging.AccessLogger
+
+	// drainer tracks in-flight requests so Stop can let them finish.
+	drainer *drainer
+
+	// apiKeys mints, rotates and revokes client API keys.
+	apiKeys *apikeys.Manager
+
+	// keyUsage accounts daily token usage per client key and model.
+	keyUsage *usage.KeyUsageRecorder
+
+	// payloadStats records token and body size distributions per model and key tier.
+	payloadStats *payloadstats.Recorder
+
+	// analytics stores usage events for ad-hoc management queries; nil when disabled.
+	analytics *analytics.Store
+
+	// alerts evaluates the configured alert rules; nil when disabled.
+	alerts *alerts.Engine
+
+	// usageWebhooks posts per-request usage to billing systems; nil when disabled.
+	usageWebhooks *usagewebhook.Sender
+
+	// costExport writes scheduled FinOps FOCUS cost exports.
+	costExport *costexport.Exporter
+
+	// configHistory keeps recently applied config file versions for rollback.
+	configHistory *confighistory.History
+
+	// canary stages config reloads on the canary keys; canaryStage holds the
+	// middlewares built from the staged config while a rollout is in progress.
+	// canaryPending is the full reloaded config to apply on promotion,
+	// canaryCandidate its staged sections as YAML and canaryBaseline the config
+	// file applied before the rollout, whose sections a rollback restores.
+	canary          *canary.Controller
+	canaryStage     atomic.Pointer[canaryStage]
+	canaryPending   atomic.Pointer[config.Config]
+	canaryCandidate []byte
+	canaryBaseline  []byte
+	canaryMu        sync.Mutex
+
+	// companion serves the desktop companion agent protocol.
+	companion *companion.Hub
+
+	// snapshots saves and restores runtime state across restarts; nil when disabled.
+	snapshots *snapshot.Manager
 
 	// managementRoutesRegistered tracks whether the management routes have been attached to the engine.
 	managementRoutesRegistered atomic.Bool
@@ -208,6 +368,14 @@ func NewServer(cfg *config.Config, authManager *auth.Manager, accessManager *sdk
 	// Add middleware
 	engine.Use(logging.GinLogrusLogger())
 	engine.Use(logging.GinLogrusRecovery())
+	engine.Use(metrics.Middleware())
+	engine.Use(tracing.Middleware())
+	accessLog := logging.NewAccessLogger(cfg.AccessLog)
+	engine.Use(accessLog.Middleware())
+	errorlog.Default().Resize(cfg.ErrorBufferSize)
+	engine.Use(errorlog.Middleware(errorlog.Default(), device.MaskKey))
+	drain := &drainer{}
+	engine.Use(drain.middleware())
 	for _, mw := range optionState.extraMiddleware {
 		engine.Use(mw)
 	}
@@ -250,6 +418,8 @@ func NewServer(cfg *config.Config, authManager *auth.Manager, accessManager *sdk
 		currentPath:         wd,
 		envManagementSecret: envManagementSecret,
 		wsRoutes:            make(map[string]struct{}),
+		accessLog:           accessLog,
+		drainer:             drain,
 	}
 	s.wsAuthEnabled.Store(cfg.WebsocketAuth)
 	// Save initial YAML snapshot
@@ -262,6 +432,7 @@ func NewServer(cfg *config.Config, authManager *auth.Manager, accessManager *sdk
 	auth.SetQuotaCooldownDisabled(cfg.DisableCooling)
 	// Initialize management handler
 	s.mgmt = managementHandlers.NewHandler(cfg, configFilePath, authManager)
+	s.scheduleVerboseLoggingTimeout(cfg)
 	if optionState.localPassword != "" {
 		s.mgmt.SetLocalPassword(optionState.localPassword)
 	}
@@ -270,21 +441,232 @@ func NewServer(cfg *config.Config, authManager *auth.Manager, accessManager *sdk
 		logDir = filepath.Join(base, "logs")
 	}
 	s.mgmt.SetLogDirectory(logDir)
+	if cfg.AuthDir != "" {
+		rec := recovery.New(cfg.AuthDir, filepath.Join(logDir, "audit", "recovery.jsonl"))
+		if code, errInit := rec.Init(); errInit != nil {
+			log.Errorf("failed to initialize management recovery code: %v", errInit)
+		} else if code != "" {
+			// Printed to the console only, never to the log files.
+			fmt.Printf("Management recovery code (shown once, store it offline): %s\n", code)
+		}
+		s.mgmt.SetRecovery(rec)
+		adminTokens, errTokens := admintokens.New(cfg.AuthDir)
+		if errTokens != nil {
+			log.Errorf("failed to load management tokens: %v", errTokens)
+		}
+		s.mgmt.SetAdminTokens(adminTokens)
+	}
 	s.localPassword = optionState.localPassword
 
 	// Initialize device binding store and middleware
 	cfg.DeviceBinding.SetDefaults()
-	if deviceStore, err := device.NewStore("."); err != nil {
+	if deviceStore, err := device.OpenStore(device.StoreConfig{
+		Backend: cfg.DeviceBinding.Store.Backend,
+		Dir:     ".",
+		Path:    cfg.DeviceBinding.Store.Path,
+		Postgres: device.PostgresConfig{
+			DSN:             cfg.DeviceBinding.Store.DSN,
+			MaxOpenConns:    cfg.DeviceBinding.Store.MaxOpenConns,
+			MaxIdleConns:    cfg.DeviceBinding.Store.MaxIdleConns,
+			ConnMaxLifetime: time.Duration(cfg.DeviceBinding.Store.ConnMaxLifetime) * time.Second,
+		},
+		HistoryRetention: time.Duration(cfg.DeviceBinding.BanHistoryRetentionDays) * 24 * time.Hour,
+		DualWrite:        deviceDualWriteStore(cfg.DeviceBinding.Store),
+	}); err != nil {
 		log.Warnf("device-binding: failed to initialize store: %v", err)
 	} else {
 		s.deviceStore = deviceStore
+		if cfg.DeviceBinding.Activity.Enabled {
+			s.deviceActivity = device.NewActivityLog(device.ActivityConfig{
+				Retention:       time.Duration(cfg.DeviceBinding.Activity.RetentionHours) * time.Hour,
+				MaxPointsPerKey: cfg.DeviceBinding.Activity.MaxPointsPerKey,
+			})
+		}
 		s.deviceMiddleware = device.NewMiddleware(deviceStore, device.Config{
 			Enabled:             cfg.DeviceBinding.Enabled,
 			MaxDevices:          cfg.DeviceBinding.MaxDevices,
 			HeaderName:          cfg.DeviceBinding.HeaderName,
 			ConcurrentThreshold: time.Duration(cfg.DeviceBinding.ConcurrentThreshold) * time.Second,
+			BanDuration:         time.Duration(cfg.DeviceBinding.BanDuration) * time.Second,
+			Escalation:          deviceEscalation(cfg.DeviceBinding.BanEscalation),
+			StrikeResetAfter:    time.Duration(cfg.DeviceBinding.StrikeResetAfter) * time.Second,
+			GracePeriod:         time.Duration(cfg.DeviceBinding.GracePeriod) * time.Second,
+			MaxStreamingDevices: cfg.DeviceBinding.MaxStreamingDevices,
+			TrustedCIDRs:        cfg.DeviceBinding.TrustedCIDRs,
+			TrustedCIDRsByKey:   cfg.DeviceBinding.TrustedCIDRsByKey,
+			RequireApproval:     cfg.DeviceBinding.RequireApproval,
+			TokenSecret:         cfg.DeviceBinding.DeviceTokenSecret,
+			TokenHeader:         cfg.DeviceBinding.DeviceTokenHeader,
+			GeoIPDatabase:       cfg.DeviceBinding.GeoIPDatabase,
+			MinDistanceKm:       cfg.DeviceBinding.MinDistanceKm,
+			MaxTravelSpeedKmh:   cfg.DeviceBinding.MaxTravelSpeedKmh,
+			ASNDatabase:         cfg.DeviceBinding.ASNDatabase,
+			ExemptASNs:          cfg.DeviceBinding.ExemptASNs,
+			DetectCGNAT:         cfg.DeviceBinding.DetectCGNAT,
+			ClientCertIdentity:  cfg.DeviceBinding.ClientCertIdentity,
+			TLSFingerprintMode:  cfg.DeviceBinding.TLSFingerprint.Mode,
+			TLSFingerprint:      s.tlsFingerprintSource(cfg),
+			Attestation:         deviceAttestation(cfg.DeviceBinding.Attestation),
+			Activity:            s.deviceActivity,
+			FailClosed:          degraded.ParseMode(cfg.DegradedMode.DeviceBinding) == degraded.FailClosed,
+			FallbackTTL:         time.Duration(cfg.DegradedMode.FallbackCacheTTL) * time.Second,
+			Anomaly: device.AnomalyConfig{
+				Enabled:             cfg.DeviceBinding.AnomalyScoring.Enabled,
+				Weights:             cfg.DeviceBinding.AnomalyScoring.Weights,
+				WarnScore:           cfg.DeviceBinding.AnomalyScoring.WarnScore,
+				BanScore:            cfg.DeviceBinding.AnomalyScoring.BanScore,
+				CadenceMaxPerMinute: cfg.DeviceBinding.AnomalyScoring.CadenceMaxPerMinute,
+			},
 		})
 		s.deviceHandler = device.NewHandler(deviceStore)
+		s.deviceHandler.SetActivityLog(s.deviceActivity)
+	}
+	keyUsageStore, _ := s.deviceStore.(device.KeyUsageStore)
+	s.keyUsage = usage.NewKeyUsageRecorder(keyUsageStore)
+	coreusage.RegisterPlugin(s.keyUsage)
+	s.mgmt.SetKeyUsageRecorder(s.keyUsage)
+	s.spend = spend.New(cfg.Spend)
+	s.spend.SetFailClosed(degraded.ParseMode(cfg.DegradedMode.Quotas) == degraded.FailClosed)
+	if err := s.seedSpend(); err != nil {
+		log.Warnf("spend: failed to load this month's usage, retrying in the background: %v", err)
+	}
+	coreusage.RegisterPlugin(s.spend)
+	s.latencyBudget = latencybudget.New(cfg.LatencyBudget)
+	s.ipAccess = ipacl.New(cfg.IPAccess)
+	s.mgmt.SetIPAccess(s.ipAccess)
+	s.requestFeatures = reqfeatures.New(cfg.RequestFeatures)
+	s.audit = audit.New(cfg.Audit, filepath.Join(logDir, "audit", "audit.jsonl"))
+	coreusage.RegisterPlugin(s.audit)
+	s.mgmt.SetSpendTracker(s.spend)
+	if sender, err := usagewebhook.New(cfg, usagewebhook.Options{Cost: s.spend.Cost, Metadata: s.keyMetadata}); err != nil {
+		log.Warnf("usage-webhooks: %v", err)
+	} else if sender != nil {
+		s.usageWebhooks = sender
+		coreusage.RegisterPlugin(sender)
+	}
+	s.costExport = costexport.New(cfg.CostExport, costexport.Options{Cost: s.spend.Cost, Metadata: s.keyMetadata})
+	coreusage.RegisterPlugin(s.costExport)
+	s.apiKeys = apikeys.New(cfg)
+	s.mgmt.SetAPIKeyManager(s.apiKeys)
+	s.mgmt.SetDeviceStore(s.deviceStore)
+	s.configHistory = confighistory.New(cfg.ConfigHistory.MaxVersions)
+	s.recordConfigVersion()
+	s.mgmt.SetConfigHistory(s.configHistory)
+	s.canary = canary.New(cfg.Canary)
+	s.mgmt.SetCanary(s.canary)
+	s.limiter = limits.New(limits.ConfigFromProxy(cfg.ClientLimits))
+	coreusage.RegisterPlugin(limits.NewUsagePlugin(s.limiter))
+	s.companion = companion.New(cfg.Companion, s.deviceMiddleware, s.deviceStore, s.limiter)
+	s.mgmt.SetCompanion(s.companion)
+	s.branding = branding.New(cfg.Branding)
+	s.contentFilters = contentfilter.New(cfg.ContentFilters)
+	s.responsePolicy = responsepolicy.New(cfg.ResponsePolicy)
+	pii.Configure(cfg.PIIRedaction)
+	s.costCeiling = costceiling.New(cfg.CostCeiling)
+	s.trial = trial.New(cfg.Trial)
+	s.byok = byok.New(cfg.BYOK)
+	s.requestValidation = requestvalidation.New(cfg.RequestValidation)
+	s.payloadStats = payloadstats.New(func(apiKey string) string {
+		tier, _ := s.keyTier(apiKey)
+		return tier
+	})
+	coreusage.RegisterPlugin(s.payloadStats)
+	if cfg.Snapshot.Enabled {
+		s.snapshots = snapshot.New(cfg.Snapshot, s.limiter, authManager)
+		if err := s.snapshots.Restore(); err != nil {
+			log.Warnf("snapshot: failed to restore state: %v", err)
+		}
+	}
+	if cfg.Analytics.Enabled {
+		path := strings.TrimSpace(cfg.Analytics.Path)
+		if path == "" {
+			path = analytics.DefaultFileName
+		}
+		if store, err := analytics.Open(path, time.Duration(cfg.Analytics.RetentionDays)*24*time.Hour); err != nil {
+			log.Warnf("analytics: failed to open database: %v", err)
+		} else {
+			s.analytics = store
+			coreusage.RegisterPlugin(analytics.NewPlugin(store))
+		}
+	}
+
+	// Start optional background integrations
+	backgroundCtx, backgroundCancel := context.WithCancel(context.Background())
+	s.backgroundCancel = backgroundCancel
+	if bot := telegram.New(cfg, s.deviceStore); bot != nil {
+		go bot.Run(backgroundCtx)
+	}
+	if s.snapshots != nil {
+		go s.snapshots.Run(backgroundCtx)
+	}
+	if s.deviceStore != nil {
+		if backups, err := backup.New(cfg.Backup, s.deviceStore); err != nil {
+			log.Warnf("backup: %v", err)
+		} else {
+			s.mgmt.SetBackups(backups)
+			if cfg.Backup.Enabled {
+				go backups.Run(backgroundCtx)
+			}
+		}
+	}
+	if janitor := device.NewJanitor(s.deviceStore, device.JanitorConfig{
+		TTL:         time.Duration(cfg.DeviceBinding.StaleBindingTTLDays) * 24 * time.Hour,
+		ArchivePath: strings.TrimSpace(cfg.DeviceBinding.StaleBindingArchive),
+	}); janitor != nil {
+		go janitor.Run(backgroundCtx)
+	}
+	if s.deviceActivity != nil {
+		go s.deviceActivity.Run(backgroundCtx)
+	}
+	if dual, ok := s.deviceStore.(*device.DualStore); ok {
+		interval := time.Duration(cfg.DeviceBinding.Store.DualWrite.VerifyInterval) * time.Second
+		if interval <= 0 {
+			interval = 5 * time.Minute
+		}
+		go dual.Run(backgroundCtx, interval)
+	}
+	go s.keyUsage.Run(backgroundCtx, usage.DefaultKeyUsageFlushInterval)
+	if !s.spend.Seeded() {
+		go s.retrySeedSpend(backgroundCtx)
+	}
+	s.prober = probe.New(cfg)
+	usage.SetExcludedAPIKeys(s.prober.APIKey())
+	if s.prober != nil {
+		go s.prober.Run(backgroundCtx)
+	}
+	if cfg.Metrics.Enabled {
+		path := strings.TrimSpace(cfg.Metrics.Path)
+		if path == "" {
+			path = "/metrics"
+		}
+		engine.GET(path, metrics.Handler(metrics.Default(), cfg.Metrics.BearerToken))
+	}
+	if tracer := tracing.New(cfg.Tracing); tracer != nil {
+		tracing.SetDefault(tracer)
+		go tracer.Run(backgroundCtx)
+	}
+	if pusher := metrics.NewPusher(metrics.Default(), cfg.MetricsPush); pusher != nil {
+		go pusher.Run(backgroundCtx)
+	}
+	if s.alerts = alerts.New(cfg.Alerts, metrics.Default()); s.alerts != nil {
+		go s.alerts.Run(backgroundCtx)
+	}
+	if dispatcher := webhook.New(cfg); dispatcher != nil {
+		go dispatcher.Run(backgroundCtx)
+	}
+	if s.usageWebhooks != nil {
+		go s.usageWebhooks.Run(backgroundCtx)
+	}
+	go s.costExport.Run(backgroundCtx)
+	if integration := discord.New(cfg, s.deviceStore); integration != nil {
+		go integration.Run(backgroundCtx)
+		if integration.CommandsEnabled() {
+			engine.POST(discord.InteractionsPath, integration.HandleInteraction)
+		}
+	}
+
+	if cfg.AdminListener.Enabled {
+		s.adminEngine = newAdminEngine(cfg.AdminListener)
 	}
 
 	// Setup routes
@@ -319,18 +701,134 @@ func NewServer(cfg *config.Config, authManager *auth.Manager, accessManager *sdk
 	}
 
 	// Create HTTP server
+	s.customRoutes = customroutes.New(cfg.CustomRoutes)
 	s.server = &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
-		Handler: engine,
+		Handler: s.customRoutes.Wrap(engine),
+	}
+	if s.tlsFingerprints != nil {
+		s.server.TLSConfig = &tls.Config{GetConfigForClient: s.tlsFingerprints.GetConfigForClient}
+		s.server.ConnState = s.tlsFingerprints.ConnState
 	}
 
 	return s
 }
 
+// seedSpend loads this month's usage into the spend tracker.
+func (s *Server) seedSpend() error {
+	period, _ := s.spend.Period()
+	rows, err := s.keyUsage.Query(device.KeyUsageFilter{From: period + "-01", To: period + "-31"})
+	degraded.Default().Report(degraded.ComponentSpend, err)
+	if err != nil {
+		return err
+	}
+	s.spend.Seed(rows)
+	return nil
+}
+
+// retrySeedSpend retries seedSpend until it succeeds, so spend caps recover
+// once the store is back.
+func (s *Server) retrySeedSpend(ctx context.Context) {
+	ticker := time.NewTicker(spendSeedRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.seedSpend(); err != nil {
+				log.Debugf("spend: still unable to load this month's usage: %v", err)
+				continue
+			}
+			log.Info("spend: loaded this month's usage after store recovery")
+			return
+		}
+	}
+}
+
+// keyMetadata returns the admin-defined metadata of a client key, if any.
+func (s *Server) keyMetadata(apiKey string) map[string]string {
+	if s.deviceStore == nil {
+		return nil
+	}
+	binding, _ := s.deviceStore.Get(apiKey)
+	return binding.Metadata
+}
+
+// tlsFingerprintSource returns how the device middleware reads client TLS
+// fingerprints, or nil when pinning is off. Handshakes are only fingerprinted
+// when the server terminates TLS itself.
+func (s *Server) tlsFingerprintSource(cfg *config.Config) func(*http.Request) string {
+	fp := cfg.DeviceBinding.TLSFingerprint
+	mode := strings.TrimSpace(fp.Mode)
+	if mode == "" || mode == device.TLSFingerprintOff {
+		return nil
+	}
+	source := tlsfingerprint.Source{Algorithm: fp.Algorithm, Header: strings.TrimSpace(fp.Header)}
+	if cfg.TLS.Enable {
+		s.tlsFingerprints = tlsfingerprint.NewRegistry()
+		source.Registry = s.tlsFingerprints
+	} else if source.Header == "" {
+		log.Warn("device-binding: tls-fingerprint needs tls.enable or a fingerprint header; pinning is inactive")
+		return nil
+	}
+	return source.Fingerprint
+}
+
+// deviceDualWriteStore returns the store configuration receiving mirrored binding
+// writes, or nil when dual-write is off. Pool settings are shared with the primary.
+func deviceDualWriteStore(store config.DeviceStoreConfig) *device.StoreConfig {
+	mirror := store.DualWrite
+	if strings.TrimSpace(mirror.Backend) == "" {
+		return nil
+	}
+	return &device.StoreConfig{
+		Backend: mirror.Backend,
+		Dir:     ".",
+		Path:    mirror.Path,
+		Postgres: device.PostgresConfig{
+			DSN:             mirror.DSN,
+			MaxOpenConns:    store.MaxOpenConns,
+			MaxIdleConns:    store.MaxIdleConns,
+			ConnMaxLifetime: time.Duration(store.ConnMaxLifetime) * time.Second,
+		},
+	}
+}
+
+// deviceEscalation converts configured escalation steps into device middleware steps.
+func deviceEscalation(steps []config.BanEscalationStep) []device.EscalationStep {
+	if len(steps) == 0 {
+		return nil
+	}
+	out := make([]device.EscalationStep, 0, len(steps))
+	for _, step := range steps {
+		out = append(out, device.EscalationStep{
+			Warn:     strings.EqualFold(strings.TrimSpace(step.Action), "warn"),
+			Duration: time.Duration(step.Duration) * time.Second,
+		})
+	}
+	return out
+}
+
+// deviceAttestation converts the attestation settings into device middleware settings.
+func deviceAttestation(cfg config.AttestationConfig) device.AttestationConfig {
+	return device.AttestationConfig{
+		Required:            cfg.Required,
+		ChallengeTTL:        time.Duration(cfg.ChallengeTTL) * time.Second,
+		AppleAppIDs:         cfg.AppleAppIDs,
+		AppleRootCA:         cfg.AppleRootCA,
+		AllowDevelopment:    cfg.AllowDevelopment,
+		AndroidPackageNames: cfg.AndroidPackageNames,
+		PlayDecryptionKey:   cfg.PlayDecryptionKey,
+		PlayVerificationKey: cfg.PlayVerificationKey,
+	}
+}
+
 // setupRoutes configures the API routes for the server.
 // It defines the endpoints and associates them with their respective handlers.
 func (s *Server) setupRoutes() {
-	s.engine.GET("/management.html", s.serveManagementControlPanel)
+	s.managementEngine().GET("/management.html", s.serveManagementControlPanel)
+	s.managementEngine().GET(admindashboard.Path, s.serveAdminDashboard)
 	openaiHandlers := openai.NewOpenAIAPIHandler(s.handlers)
 	geminiHandlers := gemini.NewGeminiAPIHandler(s.handlers)
 	geminiCLIHandlers := gemini.NewGeminiCLIAPIHandler(s.handlers)
@@ -339,10 +837,29 @@ func (s *Server) setupRoutes() {
 
 	// OpenAI compatible API routes
 	v1 := s.engine.Group("/v1")
-	v1.Use(AuthMiddleware(s.accessManager))
+	v1.Use(s.audit.Middleware())
+	v1.Use(peering.Middleware())
+	v1.Use(s.trial.Authenticate(AuthMiddleware(s.accessManager)))
+	v1.Use(s.canary.Middleware())
+	v1.Use(s.staged("request-validation", s.requestValidation.Middleware()))
+	v1.Use(s.apiKeys.Middleware())
+	v1.Use(s.staged("ip-access", s.ipAccess.Middleware()))
+	v1.Use(s.staged("request-features", s.requestFeatures.Middleware()))
+	v1.Use(s.staged("latency-budget", s.latencyBudget.Middleware()))
+	v1.Use(s.staged("branding", s.branding.Middleware()))
 	if s.deviceMiddleware != nil {
-		v1.Use(s.deviceMiddleware.Handler())
-	}
+		v1.Use(tracing.Stage("device-binding", s.deviceMiddleware.Handler())...)
+	}
+	v1.Use(requestclass.Middleware())
+	v1.Use(s.limiter.ConcurrencyMiddleware())
+	v1.Use(s.limiter.Middleware())
+	v1.Use(s.trial.Middleware())
+	v1.Use(s.costCeiling.Middleware())
+	v1.Use(s.spend.Middleware())
+	v1.Use(s.byok.Middleware())
+	v1.Use(s.staged("content-filters", s.contentFilters.Middleware()))
+	v1.Use(s.staged("response-policy", s.responsePolicy.Middleware()))
+	v1.Use(s.payloadStats.Middleware())
 	{
 		v1.GET("/models", s.unifiedModelsHandler(openaiHandlers, claudeCodeHandlers))
 		v1.POST("/chat/completions", openaiHandlers.ChatCompletions)
@@ -352,12 +869,45 @@ func (s *Server) setupRoutes() {
 		v1.POST("/responses", openaiResponsesHandlers.Responses)
 	}
 
+	// Anonymous trial key issuance; answers 404 while trial access is disabled
+	s.engine.POST("/v0/trial/key", s.trial.IssueKey)
+
+	// Signed device token registration
+	if s.deviceMiddleware.TokensEnabled() {
+		deviceGroup := s.engine.Group("/v0/device")
+		deviceGroup.Use(AuthMiddleware(s.accessManager))
+		deviceGroup.POST("/register", s.deviceMiddleware.RegisterDevice)
+		deviceGroup.POST("/attestation/challenge", s.deviceMiddleware.AttestationChallenge)
+	}
+
+	// Desktop companion agent WebSocket; answers 404 while companion agents are disabled
+	s.engine.GET("/v0/device/agent", AuthMiddleware(s.accessManager), s.companion.Handler())
+
 	// Gemini compatible API routes
 	v1beta := s.engine.Group("/v1beta")
-	v1beta.Use(AuthMiddleware(s.accessManager))
+	v1beta.Use(s.audit.Middleware())
+	v1beta.Use(peering.Middleware())
+	v1beta.Use(s.trial.Authenticate(AuthMiddleware(s.accessManager)))
+	v1beta.Use(s.canary.Middleware())
+	v1beta.Use(s.staged("request-validation", s.requestValidation.Middleware()))
+	v1beta.Use(s.apiKeys.Middleware())
+	v1beta.Use(s.staged("ip-access", s.ipAccess.Middleware()))
+	v1beta.Use(s.staged("request-features", s.requestFeatures.Middleware()))
+	v1beta.Use(s.staged("latency-budget", s.latencyBudget.Middleware()))
+	v1beta.Use(s.staged("branding", s.branding.Middleware()))
 	if s.deviceMiddleware != nil {
-		v1beta.Use(s.deviceMiddleware.Handler())
-	}
+		v1beta.Use(tracing.Stage("device-binding", s.deviceMiddleware.Handler())...)
+	}
+	v1beta.Use(requestclass.Middleware())
+	v1beta.Use(s.limiter.ConcurrencyMiddleware())
+	v1beta.Use(s.limiter.Middleware())
+	v1beta.Use(s.trial.Middleware())
+	v1beta.Use(s.costCeiling.Middleware())
+	v1beta.Use(s.spend.Middleware())
+	v1beta.Use(s.byok.Middleware())
+	v1beta.Use(s.staged("content-filters", s.contentFilters.Middleware()))
+	v1beta.Use(s.staged("response-policy", s.responsePolicy.Middleware()))
+	v1beta.Use(s.payloadStats.Middleware())
 	{
 		v1beta.GET("/models", geminiHandlers.GeminiModels)
 		v1beta.POST("/models/*action", geminiHandlers.GeminiHandler)
@@ -376,6 +926,7 @@ func (s *Server) setupRoutes() {
 		})
 	})
 	s.engine.POST("/v1internal:method", geminiCLIHandlers.CLIHandler)
+	s.engine.GET("/healthz", s.healthHandler)
 
 	// OAuth callback endpoints (reuse main server port)
 	// These endpoints receive provider redirects and persist
@@ -500,16 +1051,70 @@ func (s *Server) registerManagementRoutes() {
 
 	log.Info("management routes registered after secret key configuration")
 
-	mgmt := s.engine.Group("/v0/management")
-	mgmt.Use(s.managementAvailabilityMiddleware(), s.mgmt.Middleware())
+	for _, version := range managementAPIVersions {
+		mgmt := s.managementEngine().Group("/" + version.name + "/management")
+		mgmt.Use(s.managementAvailabilityMiddleware(), managementHandlers.VersionMiddleware(version.name))
+		if version.deprecation != nil {
+			mgmt.Use(middleware.DeprecationMiddleware(*version.deprecation))
+		}
+		// Recovery is how a lost management key is replaced, so it sits before the key check.
+		mgmt.POST("/recover", s.mgmt.RecoverManagementKey)
+		mgmt.Use(s.mgmt.Middleware())
+		s.registerManagementEndpoints(mgmt)
+	}
+}
+
+// managementAPIVersion is one /vN/management route tree.
+type managementAPIVersion struct {
+	name string
+	// deprecation marks every response of the version as deprecated; nil when current.
+	deprecation *middleware.Deprecation
+}
+
+// managementAPIVersions lists the served management API versions, oldest first.
+// v0 is kept as a compatibility shim for existing automation and points clients
+// at v1 through deprecation headers.
+var managementAPIVersions = []managementAPIVersion{
 	{
+		name: managementHandlers.APIVersionV0,
+		deprecation: &middleware.Deprecation{
+			Successor: middleware.ReplacePrefix("/v0/management", "/"+managementHandlers.LatestAPIVersion+"/management"),
+		},
+	},
+	{name: managementHandlers.APIVersionV1},
+}
+
+// registerManagementEndpoints registers the management routes of one API version.
+// Versions share handlers; a handler whose response changes between versions
+// branches on managementHandlers.APIVersion.
+func (s *Server) registerManagementEndpoints(mgmt *gin.RouterGroup) {
+	{
+		mgmt.GET("/api-versions", s.getManagementAPIVersions)
+		mgmt.GET("/selftest", s.getSelfTest)
+		mgmt.GET("/policy/effective", s.getEffectivePolicy)
 		mgmt.GET("/usage", s.mgmt.GetUsageStatistics)
 		mgmt.GET("/usage/export", s.mgmt.ExportUsageStatistics)
 		mgmt.POST("/usage/import", s.mgmt.ImportUsageStatistics)
 		mgmt.GET("/config", s.mgmt.GetConfig)
 		mgmt.GET("/config.yaml", s.mgmt.GetConfigYAML)
 		mgmt.PUT("/config.yaml", s.mgmt.PutConfigYAML)
+		mgmt.GET("/config/history", s.mgmt.GetConfigHistory)
+		mgmt.POST("/config/rollback", s.mgmt.RollbackConfig)
+		mgmt.GET("/export", s.mgmt.Export)
+		mgmt.POST("/import", s.mgmt.Import)
+		mgmt.GET("/canary", s.mgmt.GetCanary)
+		mgmt.POST("/canary/promote", s.mgmt.PromoteCanary)
+		mgmt.POST("/canary/rollback", s.mgmt.RollbackCanary)
+
+		mgmt.GET("/backups", s.mgmt.ListBackups)
+		mgmt.POST("/backups", s.mgmt.CreateBackup)
+		mgmt.POST("/backups/restore", s.mgmt.RestoreBackup)
+
+		mgmt.GET("/companion/agents", s.mgmt.ListCompanionAgents)
+
+		mgmt.GET("/events", s.mgmt.StreamEvents)
 		mgmt.GET("/latest-version", s.mgmt.GetLatestVersion)
+		mgmt.GET("/errors/recent", s.mgmt.GetRecentErrors)
 
 		mgmt.GET("/debug", s.mgmt.GetDebug)
 		mgmt.PUT("/debug", s.mgmt.PutDebug)
@@ -547,6 +1152,14 @@ func (s *Server) registerManagementRoutes() {
 		mgmt.PATCH("/api-keys", s.mgmt.PatchAPIKeys)
 		mgmt.DELETE("/api-keys", s.mgmt.DeleteAPIKeys)
 
+		mgmt.GET("/keys", s.mgmt.ListKeys)
+		mgmt.POST("/keys", s.mgmt.CreateKey)
+		mgmt.POST("/keys/rotate", s.mgmt.RotateKey)
+		mgmt.POST("/keys/revoke", s.mgmt.RevokeKey)
+		mgmt.GET("/admin-tokens", s.mgmt.ListAdminTokens)
+		mgmt.POST("/admin-tokens", s.mgmt.CreateAdminToken)
+		mgmt.DELETE("/admin-tokens", s.mgmt.RevokeAdminToken)
+
 		mgmt.GET("/gemini-api-key", s.mgmt.GetGeminiKeys)
 		mgmt.PUT("/gemini-api-key", s.mgmt.PutGeminiKeys)
 		mgmt.PATCH("/gemini-api-key", s.mgmt.PatchGeminiKey)
@@ -650,13 +1263,67 @@ func (s *Server) registerManagementRoutes() {
 		mgmt.POST("/oauth-callback", s.mgmt.PostOAuthCallback)
 		mgmt.GET("/get-auth-status", s.mgmt.GetAuthStatus)
 
+		mgmt.GET("/auth-rotations", s.mgmt.ListAuthRotations)
+		mgmt.POST("/auth-rotations", s.mgmt.StartAuthRotation)
+		mgmt.POST("/auth-rotations/retire", s.mgmt.RetireAuthRotation)
+		mgmt.DELETE("/auth-rotations", s.mgmt.CancelAuthRotation)
+		mgmt.GET("/upstream-health", s.mgmt.GetUpstreamHealth)
+		mgmt.GET("/fair-share/queues", s.mgmt.GetFairShareQueues)
+		mgmt.GET("/upstream-rate-limits", s.mgmt.GetUpstreamRateLimits)
+		mgmt.GET("/circuit-breakers", s.mgmt.GetCircuitBreakers)
+		mgmt.POST("/circuit-breakers/reset", s.mgmt.ResetCircuitBreakers)
+		mgmt.GET("/payload-stats", s.payloadStats.GetSummary)
+		mgmt.GET("/spend", s.mgmt.GetSpend)
+		mgmt.GET("/device-store/dual-write", s.mgmt.GetDeviceStoreDualWrite)
+		mgmt.POST("/device-store/verify", s.mgmt.VerifyDeviceStore)
+		mgmt.POST("/device-store/backfill", s.mgmt.BackfillDeviceStore)
+		mgmt.POST("/device-store/cutover", s.mgmt.CutoverDeviceStore)
+		mgmt.PUT("/spend/caps", s.mgmt.PutSpendCap)
+		mgmt.GET("/ip-access", s.mgmt.GetIPAccess)
+		mgmt.PUT("/ip-access", s.mgmt.PutIPAccess)
+		mgmt.DELETE("/ip-access", s.mgmt.DeleteIPAccess)
+
 		// Device binding management routes
 		if s.deviceHandler != nil {
 			s.deviceHandler.RegisterRoutes(mgmt)
 		}
+		limits.NewHandler(s.limiter).RegisterRoutes(mgmt)
+		if s.analytics != nil {
+			analytics.NewHandler(s.analytics).RegisterRoutes(mgmt)
+		}
+		if s.alerts != nil {
+			s.alerts.RegisterRoutes(mgmt)
+		}
+		if s.usageWebhooks != nil {
+			s.usageWebhooks.RegisterRoutes(mgmt)
+		}
+		s.costExport.RegisterRoutes(mgmt)
 	}
 }
 
+// getManagementAPIVersions lists the served management API versions and their status.
+//
+// GET /v1/management/api-versions
+func (s *Server) getManagementAPIVersions(c *gin.Context) {
+	versions := make([]gin.H, 0, len(managementAPIVersions))
+	for _, version := range managementAPIVersions {
+		entry := gin.H{
+			"version":    version.name,
+			"path":       "/" + version.name + "/management",
+			"deprecated": version.deprecation != nil,
+		}
+		if version.deprecation != nil && !version.deprecation.Sunset.IsZero() {
+			entry["sunset"] = version.deprecation.Sunset
+		}
+		versions = append(versions, entry)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"current":  managementHandlers.APIVersion(c),
+		"latest":   managementHandlers.LatestAPIVersion,
+		"versions": versions,
+	})
+}
+
 func (s *Server) managementAvailabilityMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !s.managementRoutesEnabled.Load() {
@@ -694,6 +1361,15 @@ func (s *Server) serveManagementControlPanel(c *gin.Context) {
 	c.File(filePath)
 }
 
+// serveAdminDashboard serves the embedded admin dashboard unless the control panel is disabled.
+func (s *Server) serveAdminDashboard(c *gin.Context) {
+	if s.cfg == nil || s.cfg.RemoteManagement.DisableControlPanel {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	admindashboard.Handler()(c)
+}
+
 func (s *Server) enableKeepAlive(timeout time.Duration, onTimeout func()) {
 	if timeout <= 0 || onTimeout == nil {
 		return
@@ -772,6 +1448,29 @@ func (s *Server) watchKeepAlive() {
 	}
 }
 
+// healthHandler reports liveness together with the synthetic probe outcome.
+// It returns 503 once the probe has failed more than its configured threshold.
+func (s *Server) healthHandler(c *gin.Context) {
+	probeStatus := s.prober.Status()
+	status := "ok"
+	code := http.StatusOK
+	tracker := degraded.Default()
+	if tracker.Degraded() {
+		// Still serving, so load balancers keep the instance; monitoring sees the flag.
+		status = "degraded"
+	}
+	if !probeStatus.Healthy {
+		status = "unhealthy"
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{
+		"status":     status,
+		"probe":      probeStatus,
+		"degraded":   tracker.Degraded(),
+		"components": tracker.States(),
+	})
+}
+
 // unifiedModelsHandler creates a unified handler for the /v1/models endpoint
 // that routes to different handlers based on the User-Agent header.
 // If User-Agent starts with "claude-cli", it routes to Claude handler,
@@ -801,6 +1500,16 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to start HTTP server: server not initialized")
 	}
 
+	if err := s.runSelfTest(); err != nil {
+		return err
+	}
+	if err := s.startAdminListener(); err != nil {
+		return err
+	}
+	if err := s.startForwardProxy(); err != nil {
+		return err
+	}
+
 	useTLS := s.cfg != nil && s.cfg.TLS.Enable
 	if useTLS {
 		cert := strings.TrimSpace(s.cfg.TLS.Cert)
@@ -808,6 +1517,11 @@ func (s *Server) Start() error {
 		if cert == "" || key == "" {
 			return fmt.Errorf("failed to start HTTPS server: tls.cert or tls.key is empty")
 		}
+		tlsConfig, errClientAuth := applyClientAuth(s.server.TLSConfig, s.cfg.TLS)
+		if errClientAuth != nil {
+			return fmt.Errorf("failed to start HTTPS server: %w", errClientAuth)
+		}
+		s.server.TLSConfig = tlsConfig
 		log.Debugf("Starting API server on %s with TLS", s.server.Addr)
 		if errServeTLS := s.server.ListenAndServeTLS(cert, key); errServeTLS != nil && !errors.Is(errServeTLS, http.ErrServerClosed) {
 			return fmt.Errorf("failed to start HTTPS server: %v", errServeTLS)
@@ -823,8 +1537,9 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Stop gracefully shuts down the API server without interrupting any
-// active connections.
+// Stop gracefully shuts down the API server. It stops accepting requests,
+// waits up to the configured drain timeout for in-flight requests and
+// streams to finish, and then persists state and releases resources.
 //
 // Parameters:
 //   - ctx: The context for graceful shutdown
@@ -841,10 +1556,61 @@ func (s *Server) Stop(ctx context.Context) error {
 		}
 	}
 
-	// Shutdown the HTTP server.
-	if err := s.server.Shutdown(ctx); err != nil {
+	// Drain the HTTP server before stopping background work that in-flight
+	// requests may still rely on.
+	if err := s.drain(ctx); err != nil {
 		return fmt.Errorf("failed to shutdown HTTP server: %v", err)
 	}
+	if err := s.stopAdminListener(ctx); err != nil {
+		log.Warn(err)
+	}
+	if err := s.stopForwardProxy(ctx); err != nil {
+		log.Warn(err)
+	}
+
+	if s.backgroundCancel != nil {
+		s.backgroundCancel()
+	}
+	s.verboseLogging.stop()
+
+	if s.snapshots != nil {
+		if err := s.snapshots.Save(); err != nil {
+			log.Warnf("snapshot: failed to save state on shutdown: %v", err)
+		}
+	}
+	if s.analytics != nil {
+		if err := s.analytics.Close(); err != nil {
+			log.Warnf("analytics: failed to close database: %v", err)
+		}
+	}
+	if s.audit != nil {
+		if err := s.audit.Close(); err != nil {
+			log.Warnf("audit: failed to close log: %v", err)
+		}
+	}
+	s.accessLog.Close()
+	if err := s.usageWebhooks.Close(); err != nil {
+		log.Warnf("usage-webhooks: failed to close outbox: %v", err)
+	}
+	if err := s.costExport.Close(); err != nil {
+		log.Warnf("cost-export: failed to write final export: %v", err)
+	}
+	if s.companion != nil {
+		s.companion.Close()
+	}
+	if s.deviceMiddleware != nil {
+		if err := s.deviceMiddleware.Close(); err != nil {
+			log.Warnf("device-binding: failed to close lookup databases: %v", err)
+		}
+	}
+	if err := s.keyUsage.Flush(); err != nil {
+		log.Warnf("usage: failed to persist key usage: %v", err)
+	}
+	if s.deviceStore != nil {
+		if err := s.deviceStore.Close(); err != nil {
+			log.Warnf("device-binding: failed to close store: %v", err)
+		}
+	}
 
 	log.Debug("API server stopped")
 	return nil
@@ -879,6 +1645,20 @@ func (s *Server) applyAccessConfig(oldCfg, newCfg *config.Config) {
 	}
 }
 
+// recordConfigVersion adds the config file as currently on disk to the history.
+func (s *Server) recordConfigVersion() {
+	if s.configFilePath == "" {
+		return
+	}
+	data, err := os.ReadFile(s.configFilePath)
+	if err != nil || len(data) == 0 {
+		return
+	}
+	if version, added := s.configHistory.Record(data, time.Now()); added {
+		log.Debugf("config history: recorded version %d", version)
+	}
+}
+
 // UpdateClients updates the server's client list and configuration.
 // This method is called when the configuration or authentication tokens change.
 //
@@ -892,6 +1672,8 @@ func (s *Server) UpdateClients(cfg *config.Config) {
 		_ = yaml.Unmarshal(s.oldConfigYaml, &oldCfg)
 	}
 
+	pii.Configure(cfg.PIIRedaction)
+
 	// Update request logger enabled state if it has changed
 	previousRequestLog := false
 	if oldCfg != nil {
@@ -936,6 +1718,70 @@ func (s *Server) UpdateClients(cfg *config.Config) {
 		}
 	}
 
+	if oldCfg == nil || oldCfg.ErrorBufferSize != cfg.ErrorBufferSize {
+		errorlog.Default().Resize(cfg.ErrorBufferSize)
+	}
+	if s.limiter != nil {
+		s.limiter.Update(limits.ConfigFromProxy(cfg.ClientLimits))
+	}
+	if s.branding != nil {
+		s.branding.Update(cfg.Branding)
+	}
+	if s.contentFilters != nil {
+		s.contentFilters.Update(cfg.ContentFilters)
+	}
+	if s.costCeiling != nil {
+		s.costCeiling.Update(cfg.CostCeiling)
+	}
+	if s.responsePolicy != nil {
+		s.responsePolicy.Update(cfg.ResponsePolicy)
+	}
+	if s.spend != nil {
+		s.spend.Update(cfg.Spend)
+	}
+	if s.latencyBudget != nil {
+		s.latencyBudget.Update(cfg.LatencyBudget)
+	}
+	if s.ipAccess != nil {
+		s.ipAccess.Update(cfg.IPAccess)
+	}
+	if s.requestFeatures != nil {
+		s.requestFeatures.Update(cfg.RequestFeatures)
+	}
+	if s.audit != nil {
+		s.audit.Update(cfg.Audit)
+	}
+	s.usageWebhooks.Update(cfg.UsageWebhooks)
+	s.costExport.Update(cfg.CostExport)
+	if s.accessLog != nil {
+		s.accessLog.Update(cfg.AccessLog)
+	}
+	if s.apiKeys != nil {
+		s.apiKeys.Update(cfg)
+	}
+	if s.configHistory != nil {
+		s.configHistory.SetMaxVersions(cfg.ConfigHistory.MaxVersions)
+		s.recordConfigVersion()
+	}
+	if s.canary != nil {
+		s.canary.Update(cfg.Canary)
+	}
+	if s.companion != nil {
+		s.companion.Update(cfg.Companion)
+	}
+	if s.trial != nil {
+		s.trial.Update(cfg.Trial)
+	}
+	if s.byok != nil {
+		s.byok.Update(cfg.BYOK)
+	}
+	if s.customRoutes != nil {
+		s.customRoutes.Update(cfg.CustomRoutes)
+	}
+	if s.requestValidation != nil {
+		s.requestValidation.Update(cfg.RequestValidation)
+	}
+
 	if oldCfg == nil || oldCfg.DisableCooling != cfg.DisableCooling {
 		auth.SetQuotaCooldownDisabled(cfg.DisableCooling)
 		if oldCfg != nil {
@@ -1007,9 +1853,16 @@ func (s *Server) UpdateClients(cfg *config.Config) {
 		go managementasset.EnsureLatestManagementHTML(context.Background(), staticDir, cfg.ProxyURL, cfg.RemoteManagement.PanelGitHubRepository)
 	}
 	if s.mgmt != nil {
-		s.mgmt.SetConfig(cfg)
+		// During a canary rollout management writes must keep the staged sections
+		// in the config file, so they edit the full reloaded config.
+		if pending := s.canaryPending.Load(); pending != nil {
+			s.mgmt.SetConfig(pending)
+		} else {
+			s.mgmt.SetConfig(cfg)
+		}
 		s.mgmt.SetAuthManager(s.handlers.AuthManager)
 	}
+	s.scheduleVerboseLoggingTimeout(cfg)
 
 	// Notify Amp module of config changes (for model mapping hot-reload)
 	if s.ampModule != nil {
@@ -1,22 +1,37 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	gin "github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/admintokens"
 	proxyconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/limits"
 	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
 	"github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
 	sdkconfig "github.com/router-for-me/CLIProxyAPI/v6/sdk/config"
+	"gopkg.in/yaml.v3"
 )
 
 func newTestServer(t *testing.T) *Server {
 	t.Helper()
+	return newTestServerWithConfig(t, nil)
+}
+
+func newTestServerWithConfig(t *testing.T, configure func(*proxyconfig.Config)) *Server {
+	t.Helper()
 
 	gin.SetMode(gin.TestMode)
 
@@ -36,6 +51,9 @@ func newTestServer(t *testing.T) *Server {
 		LoggingToFile:          false,
 		UsageStatisticsEnabled: false,
 	}
+	if configure != nil {
+		configure(cfg)
+	}
 
 	authManager := auth.NewManager(nil, nil, nil)
 	accessManager := sdkaccess.NewManager()
@@ -109,3 +127,478 @@ func TestAmpProviderModelRoutes(t *testing.T) {
 		})
 	}
 }
+
+func TestManagementAPIVersions(t *testing.T) {
+	t.Setenv("MANAGEMENT_PASSWORD", "mgmt-secret")
+	server := newTestServer(t)
+
+	for _, tc := range []struct {
+		path       string
+		deprecated bool
+	}{
+		{path: "/v0/management/api-versions", deprecated: true},
+		{path: "/v1/management/api-versions"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set("Authorization", "Bearer mgmt-secret")
+		rr := httptest.NewRecorder()
+		server.engine.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("unexpected status code for %s: got %d; body=%s", tc.path, rr.Code, rr.Body.String())
+		}
+		version := strings.Split(tc.path, "/")[1]
+		if got := rr.Header().Get("X-Management-API-Version"); got != version {
+			t.Fatalf("expected version header %q for %s, got %q", version, tc.path, got)
+		}
+		if !strings.Contains(rr.Body.String(), `"current":"`+version+`"`) {
+			t.Fatalf("unexpected body for %s: %s", tc.path, rr.Body.String())
+		}
+		if got := rr.Header().Get("Deprecation"); (got != "") != tc.deprecated {
+			t.Fatalf("unexpected Deprecation header for %s: %q", tc.path, got)
+		}
+		if tc.deprecated {
+			if link := rr.Header().Get("Link"); link != `</v1/management/api-versions>; rel="successor-version"` {
+				t.Fatalf("unexpected Link header: %q", link)
+			}
+		}
+	}
+}
+
+func TestEffectivePolicyReportsSources(t *testing.T) {
+	t.Setenv("MANAGEMENT_PASSWORD", "mgmt-secret")
+	server := newTestServer(t)
+	server.cfg.FairShare = proxyconfig.FairShareConfig{
+		Enabled:  true,
+		Tiers:    map[string]int{"pro": 4},
+		KeyTiers: map[string]string{"test-key": "Pro"},
+	}
+	server.limiter.Update(limits.ConfigFromProxy(proxyconfig.ClientLimitsConfig{
+		Enabled:           true,
+		RequestsPerMinute: 10,
+		Keys:              map[string]proxyconfig.ClientLimit{"test-key": {RequestsPerMinute: 60}},
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/management/policy/effective?api-key=test-key", nil)
+	req.Header.Set("Authorization", "Bearer mgmt-secret")
+	rr := httptest.NewRecorder()
+	server.engine.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	for _, want := range []string{
+		`"configured":true`,
+		`"requests_per_minute":60`,
+		`"source":"key"`,
+		`"tier":"pro"`,
+		`"source":"key-tiers"`,
+		`"fair_share_weight":4`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("effective policy missing %s: %s", want, body)
+		}
+	}
+}
+
+func TestAdminListenerServesManagementSeparately(t *testing.T) {
+	t.Setenv("MANAGEMENT_PASSWORD", "mgmt-secret")
+	socket := filepath.Join(t.TempDir(), "admin.sock")
+	server := newTestServerWithConfig(t, func(cfg *proxyconfig.Config) {
+		cfg.AdminListener = proxyconfig.AdminListenerConfig{Enabled: true, Socket: socket}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/management/api-versions", nil)
+	req.Header.Set("Authorization", "Bearer mgmt-secret")
+	rr := httptest.NewRecorder()
+	server.engine.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected management API to be absent from the proxy listener, got %d", rr.Code)
+	}
+
+	if err := server.startAdminListener(); err != nil {
+		t.Fatalf("start admin listener: %v", err)
+	}
+	defer func() { _ = server.stopAdminListener(context.Background()) }()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+	adminReq, _ := http.NewRequest(http.MethodGet, "http://admin/v1/management/api-versions", nil)
+	adminReq.Header.Set("Authorization", "Bearer mgmt-secret")
+	resp, err := client.Do(adminReq)
+	if err != nil {
+		t.Fatalf("admin request: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected management API on the admin socket, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminDashboardServedUnlessControlPanelDisabled(t *testing.T) {
+	server := newTestServer(t)
+	rr := httptest.NewRecorder()
+	server.engine.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "/device-bindings/unban") {
+		t.Fatalf("expected the dashboard page, got %d", rr.Code)
+	}
+
+	disabled := newTestServerWithConfig(t, func(cfg *proxyconfig.Config) {
+		cfg.RemoteManagement.DisableControlPanel = true
+	})
+	rr = httptest.NewRecorder()
+	disabled.engine.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with the control panel disabled, got %d", rr.Code)
+	}
+}
+
+func TestVerboseLoggingSwitchedOffAfterTimeout(t *testing.T) {
+	server := newTestServerWithConfig(t, func(cfg *proxyconfig.Config) {
+		cfg.RequestLog = true
+		cfg.VerboseLoggingTimeoutMinutes = 30
+	})
+	if err := os.WriteFile(server.configFilePath, []byte("debug: true\nrequest-log: true\n"), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	server.verboseLogging.mu.Lock()
+	armed := server.verboseLogging.timer != nil
+	server.verboseLogging.mu.Unlock()
+	if !armed {
+		t.Fatal("expected the verbose logging timeout to be armed")
+	}
+
+	var published []events.Event
+	unsubscribe := events.Subscribe(func(ev events.Event) {
+		if ev.Type == events.TypeVerboseLoggingDisabled {
+			published = append(published, ev)
+		}
+	})
+	defer unsubscribe()
+
+	server.disableVerboseLogging()
+	if server.cfg.Debug || server.cfg.RequestLog {
+		t.Fatalf("expected verbose logging to be off, got debug=%v request-log=%v", server.cfg.Debug, server.cfg.RequestLog)
+	}
+	saved, err := proxyconfig.LoadConfig(server.configFilePath)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if saved.Debug || saved.RequestLog {
+		t.Fatal("expected the disabled logging to be persisted")
+	}
+	if len(published) != 1 || published[0].Data["request_log"] != true {
+		t.Fatalf("expected one audit event, got %+v", published)
+	}
+
+	server.disableVerboseLogging()
+	if len(published) != 1 {
+		t.Fatal("expected no audit event when logging is already off")
+	}
+}
+
+func TestKeyLifecycleCreateRotateRevoke(t *testing.T) {
+	t.Setenv("MANAGEMENT_PASSWORD", "mgmt-secret")
+	server := newTestServerWithConfig(t, func(cfg *proxyconfig.Config) {
+		cfg.Spend.Keys = map[string]float64{"test-key": 25}
+	})
+	if err := os.WriteFile(server.configFilePath, []byte("api-keys:\n  - test-key\n"), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer mgmt-secret")
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.engine.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := call(http.MethodPost, "/v1/management/keys", "")
+	if rr.Code != http.StatusCreated || !strings.Contains(rr.Body.String(), `"key":"sk-cc-`) {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	if len(server.cfg.APIKeys) != 2 {
+		t.Fatalf("created key not added: %v", server.cfg.APIKeys)
+	}
+
+	rr = call(http.MethodPost, "/v1/management/keys/rotate", `{"key":"test-key","grace_seconds":3600}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("rotate: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := server.cfg.APIKeyLifecycle.Expiring["test-key"]; !ok || len(server.cfg.APIKeys) != 3 {
+		t.Fatalf("rotation not recorded: %v %v", server.cfg.APIKeys, server.cfg.APIKeyLifecycle.Expiring)
+	}
+	if len(server.cfg.Spend.Keys) != 2 {
+		t.Fatalf("rotated key did not inherit its spend cap: %v", server.cfg.Spend.Keys)
+	}
+	if reason := server.apiKeys.Rejection("test-key"); reason != "" {
+		t.Fatalf("old key refused inside its grace window: %s", reason)
+	}
+	if rr = call(http.MethodPost, "/v1/management/keys/rotate", `{"key":"test-key"}`); rr.Code != http.StatusConflict {
+		t.Fatalf("second rotation: expected 409, got %d", rr.Code)
+	}
+
+	rr = call(http.MethodPost, "/v1/management/keys/revoke", `{"key":"test-key"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", rr.Code, rr.Body.String())
+	}
+	if reason := server.apiKeys.Rejection("test-key"); reason != "API key revoked" {
+		t.Fatalf("revoked key not refused: %q", reason)
+	}
+	if _, ok := server.cfg.Spend.Keys["test-key"]; ok || len(server.cfg.APIKeys) != 2 {
+		t.Fatalf("revoked key still configured: %v %v", server.cfg.APIKeys, server.cfg.Spend.Keys)
+	}
+	saved, err := os.ReadFile(server.configFilePath)
+	if err != nil || strings.Contains(string(saved), "test-key") {
+		t.Fatalf("revoked key still persisted: %v\n%s", err, saved)
+	}
+	if rr = call(http.MethodPost, "/v1/management/keys/revoke", `{"key":"test-key"}`); rr.Code != http.StatusNotFound {
+		t.Fatalf("second revoke: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestConfigHistoryRollback(t *testing.T) {
+	t.Setenv("MANAGEMENT_PASSWORD", "mgmt-secret")
+	server := newTestServer(t)
+	first := []byte("port: 8317\napi-keys:\n  - test-key\n")
+	second := []byte("port: 8318\napi-keys:\n  - test-key\n")
+	for _, content := range [][]byte{first, second} {
+		if err := os.WriteFile(server.configFilePath, content, 0o600); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+		server.recordConfigVersion()
+	}
+
+	call := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer mgmt-secret")
+		rr := httptest.NewRecorder()
+		server.engine.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := call(http.MethodGet, "/v1/management/config/history")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `-port: 8317\n+port: 8318`) {
+		t.Fatalf("history: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr = call(http.MethodPost, "/v1/management/config/rollback?version=9"); rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown version: expected 404, got %d", rr.Code)
+	}
+	if rr = call(http.MethodPost, "/v1/management/config/rollback?version=2"); rr.Code != http.StatusConflict {
+		t.Fatalf("current version: expected 409, got %d", rr.Code)
+	}
+	if rr = call(http.MethodPost, "/v1/management/config/rollback?version=1"); rr.Code != http.StatusOK {
+		t.Fatalf("rollback: %d %s", rr.Code, rr.Body.String())
+	}
+	data, err := os.ReadFile(server.configFilePath)
+	if err != nil || string(data) != string(first) {
+		t.Fatalf("config after rollback = %q, %v", data, err)
+	}
+}
+
+func TestStopDrainsInFlightRequests(t *testing.T) {
+	server := newTestServer(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	server.engine.GET("/drain-test", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() { _ = server.server.Serve(listener) }()
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, errGet := http.Get("http://" + listener.Addr().String() + "/drain-test")
+		if errGet != nil {
+			responses <- result{err: errGet}
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		var sb strings.Builder
+		_, errRead := io.Copy(&sb, resp.Body)
+		responses <- result{status: resp.StatusCode, body: sb.String(), err: errRead}
+	}()
+	<-started
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- server.Stop(context.Background()) }()
+	for !server.drainer.draining.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	server.engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/drain-test", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected requests to be turned away while draining, got %d", rec.Code)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned before the in-flight request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if res := <-responses; res.err != nil || res.status != http.StatusOK || res.body != "done" {
+		t.Fatalf("expected in-flight request to complete, got %+v", res)
+	}
+	if err = <-stopped; err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+}
+
+func TestCanaryStagesOnlyStagedSections(t *testing.T) {
+	server := newTestServerWithConfig(t, func(cfg *proxyconfig.Config) {
+		cfg.Canary = proxyconfig.CanaryConfig{Enabled: true, APIKeys: []string{"canary-key"}, SoakSeconds: 3600}
+	})
+	t.Cleanup(func() { server.canary.Cancel("test finished") })
+	promoted := make(chan *proxyconfig.Config, 1)
+	apply := func(cfg *proxyconfig.Config) { promoted <- cfg }
+
+	// A change outside the staged sections is applied right away.
+	keys := *server.cfg
+	keys.APIKeys = []string{"test-key", "new-key"}
+	if got := server.StageCanary(&keys, apply); got != &keys || server.canaryStage.Load() != nil {
+		t.Fatalf("unstaged change was held back: %+v", got)
+	}
+	server.oldConfigYaml, _ = yaml.Marshal(&keys)
+
+	// A staged section goes to the canary keys; the key change that comes with it does not wait.
+	staged := keys
+	staged.RequestValidation.MaxBodyMB = 1
+	staged.APIKeys = []string{"test-key"}
+	now := server.StageCanary(&staged, apply)
+	if server.canaryStage.Load() == nil {
+		t.Fatal("expected the request-validation change to be staged")
+	}
+	if now.RequestValidation.MaxBodyMB != 0 || len(now.APIKeys) != 1 {
+		t.Fatalf("applied now: max-body-mb=%d api-keys=%v", now.RequestValidation.MaxBodyMB, now.APIKeys)
+	}
+	server.oldConfigYaml, _ = yaml.Marshal(now)
+
+	if !server.canary.Promote("test") {
+		t.Fatal("expected a rollout in progress")
+	}
+	select {
+	case cfg := <-promoted:
+		if cfg.RequestValidation.MaxBodyMB != 1 {
+			t.Fatalf("promoted config lacks the staged section: %+v", cfg.RequestValidation)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("promotion did not apply the config")
+	}
+}
+
+func TestCanaryRollbackRestoresOnlyStagedSections(t *testing.T) {
+	server := newTestServerWithConfig(t, func(cfg *proxyconfig.Config) {
+		cfg.Canary = proxyconfig.CanaryConfig{Enabled: true, APIKeys: []string{"canary-key"}, SoakSeconds: 3600}
+	})
+	t.Cleanup(func() { server.canary.Cancel("test finished") })
+	load := func(content string) *proxyconfig.Config {
+		if err := os.WriteFile(server.configFilePath, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		var cfg proxyconfig.Config
+		if err := yaml.Unmarshal([]byte(content), &cfg); err != nil {
+			t.Fatal(err)
+		}
+		cfg.Canary = server.cfg.Canary
+		return &cfg
+	}
+
+	applied := load("api-keys:\n  - test-key\n  - old-key\nrequest-validation:\n  max-body-mb: 4\n")
+	server.recordConfigVersion()
+	server.oldConfigYaml, _ = yaml.Marshal(applied)
+
+	now := server.StageCanary(load("api-keys:\n  - test-key\n  - old-key\nrequest-validation:\n  max-body-mb: 1\n"), func(*proxyconfig.Config) {})
+	server.oldConfigYaml, _ = yaml.Marshal(now)
+	// A management write during the soak revokes old-key.
+	server.StageCanary(load("api-keys:\n  - test-key\nrequest-validation:\n  max-body-mb: 1\n"), func(*proxyconfig.Config) {})
+
+	if !server.canary.Rollback("test") {
+		t.Fatal("expected a rollout in progress")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(server.configFilePath)
+		if strings.Contains(string(data), "max-body-mb: 4") {
+			if strings.Contains(string(data), "old-key") {
+				t.Fatalf("rollback undid the management write:\n%s", data)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("staged section not restored:\n%s", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReadOnlyTokenNeverSeesClientKeys(t *testing.T) {
+	t.Setenv("MANAGEMENT_PASSWORD", "mgmt-secret")
+	const clientKey = "sk-client-readonly-0123456789"
+	server := newTestServerWithConfig(t, func(cfg *proxyconfig.Config) {
+		cfg.APIKeys = append(cfg.APIKeys, clientKey)
+		cfg.Spend.Keys = map[string]float64{clientKey: 25}
+		cfg.DeviceBinding.Store.Backend = "sqlite"
+		cfg.DeviceBinding.Store.Path = filepath.Join(t.TempDir(), "bindings.db")
+	})
+	if _, err := server.deviceStore.AddDeviceWithinLimit(clientKey, "laptop", "client_id", "203.0.113.7", false, 1); err != nil {
+		t.Fatalf("seed binding: %v", err)
+	}
+	if err := server.deviceStore.BanDevice(clientKey, device.DeviceBan{DeviceID: "laptop", Reason: "test"}); err != nil {
+		t.Fatalf("seed device ban: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/management/admin-tokens", strings.NewReader(`{"name":"viewer","scope":"read-only"}`))
+	req.Header.Set("Authorization", "Bearer mgmt-secret")
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	server.engine.ServeHTTP(rr, req)
+	var minted struct {
+		Token string `json:"token"`
+	}
+	if rr.Code != http.StatusCreated || json.Unmarshal(rr.Body.Bytes(), &minted) != nil {
+		t.Fatalf("mint token: %d %s", rr.Code, rr.Body.String())
+	}
+
+	const prefix = "/v1/management"
+	called := 0
+	for _, route := range server.engine.Routes() {
+		path, ok := strings.CutPrefix(route.Path, prefix)
+		if !ok || route.Method != http.MethodGet || strings.ContainsAny(path, ":*") || !admintokens.Allows(admintokens.ScopeRead, route.Method, path) {
+			continue
+		}
+		req = httptest.NewRequest(http.MethodGet, route.Path, nil)
+		req.Header.Set("Authorization", "Bearer "+minted.Token)
+		rr = httptest.NewRecorder()
+		server.engine.ServeHTTP(rr, req)
+		called++
+		if strings.Contains(rr.Body.String(), clientKey) {
+			t.Errorf("read-only token received the raw client key from GET %s: %s", route.Path, rr.Body.String())
+		}
+	}
+	if called == 0 {
+		t.Fatal("no read route was called")
+	}
+	for _, path := range []string{"/device-bindings", "/device-bindings/device-bans", "/spend", "/usage", "/companion/agents", "/events"} {
+		req = httptest.NewRequest(http.MethodGet, prefix+path, nil)
+		req.Header.Set("Authorization", "Bearer "+minted.Token)
+		rr = httptest.NewRecorder()
+		server.engine.ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected GET %s to be refused for a read-only token, got %d", path, rr.Code)
+		}
+	}
+}
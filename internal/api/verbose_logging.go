@@ -0,0 +1,99 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// verboseLoggingGuard switches debug and request logging off once they have
+// been on for the configured timeout.
+type verboseLoggingGuard struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	timeout time.Duration
+	since   time.Time
+}
+
+// scheduleVerboseLoggingTimeout arms or cancels the verbose logging timeout for
+// cfg. Reloads while logging stays on keep the original deadline.
+func (s *Server) scheduleVerboseLoggingTimeout(cfg *config.Config) {
+	g := &s.verboseLogging
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	timeout := time.Duration(cfg.VerboseLoggingTimeoutMinutes) * time.Minute
+	if !(cfg.Debug || cfg.RequestLog) || timeout <= 0 {
+		g.stopLocked()
+		return
+	}
+	if g.timer != nil && g.timeout == timeout {
+		return
+	}
+	if g.timer == nil {
+		g.since = time.Now()
+	}
+	g.stopLocked()
+	g.timeout = timeout
+	remaining := time.Until(g.since.Add(timeout))
+	g.timer = time.AfterFunc(remaining, s.disableVerboseLogging)
+}
+
+// stop cancels a pending timeout.
+func (g *verboseLoggingGuard) stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stopLocked()
+}
+
+func (g *verboseLoggingGuard) stopLocked() {
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+}
+
+// disableVerboseLogging switches debug and request logging off, persists the
+// change and records an audit entry.
+func (s *Server) disableVerboseLogging() {
+	g := &s.verboseLogging
+	g.mu.Lock()
+	g.timer = nil
+	since, timeout := g.since, g.timeout
+	g.mu.Unlock()
+
+	debug, requestLog, err := s.mgmt.DisableVerboseLogging()
+	if !debug && !requestLog {
+		return
+	}
+	if err != nil {
+		log.Errorf("verbose logging: failed to persist disabled logging: %v", err)
+	}
+	// Apply immediately rather than waiting for the config watcher to reload.
+	if requestLog && s.requestLogger != nil {
+		if s.loggerToggle != nil {
+			s.loggerToggle(false)
+		} else if toggler, ok := s.requestLogger.(interface{ SetEnabled(bool) }); ok {
+			toggler.SetEnabled(false)
+		}
+	}
+	if debug {
+		util.SetLogLevel(s.cfg)
+	}
+	log.WithFields(log.Fields{
+		"audit":       true,
+		"debug":       debug,
+		"request_log": requestLog,
+		"enabled_at":  since.Format(time.RFC3339),
+		"timeout":     timeout.String(),
+	}).Warn("verbose logging switched off after its timeout")
+	events.Publish(events.Event{
+		Type:   events.TypeVerboseLoggingDisabled,
+		Reason: "timeout",
+		Actor:  "system",
+		Data:   map[string]any{"debug": debug, "request_log": requestLog, "enabled_at": since, "timeout_minutes": int(timeout.Minutes())},
+	})
+}
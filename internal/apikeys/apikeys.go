@@ -0,0 +1,217 @@
+// Package apikeys manages the lifecycle of client API keys. It mints prefixed
+// random keys, tracks keys that a rotation replaced until their grace window
+// ends, and rejects revoked keys right away, before the config reload removes
+// them from the access providers.
+package apikeys
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+const (
+	// DefaultPrefix starts generated keys unless configured otherwise.
+	DefaultPrefix = "sk-cc-"
+	// DefaultRotationGrace is how long a rotated key keeps working by default.
+	DefaultRotationGrace = 24 * time.Hour
+
+	// randomBytes is the entropy of a generated key.
+	randomBytes = 24
+)
+
+// Key states reported by List
+const (
+	StateActive   = "active"
+	StateExpiring = "expiring"
+	StateExpired  = "expired"
+)
+
+// KeyInfo describes one configured client key.
+type KeyInfo struct {
+	Key       string     `json:"key"`
+	State     string     `json:"state"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Manager holds the lifecycle settings and the keys revoked since the last
+// config reload.
+type Manager struct {
+	mu            sync.RWMutex
+	prefix        string
+	rotationGrace time.Duration
+	expiring      map[string]time.Time
+	revoked       map[string]struct{}
+	now           func() time.Time
+}
+
+// New creates a manager from the configuration.
+func New(cfg *config.Config) *Manager {
+	m := &Manager{revoked: make(map[string]struct{}), now: time.Now}
+	m.Update(cfg)
+	return m
+}
+
+// Update applies a reloaded configuration. Revoked keys the configuration no
+// longer lists are forgotten, so a key added again later works again.
+func (m *Manager) Update(cfg *config.Config) {
+	if m == nil || cfg == nil {
+		return
+	}
+	lifecycle := cfg.APIKeyLifecycle
+	prefix := strings.TrimSpace(lifecycle.Prefix)
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	grace := time.Duration(lifecycle.RotationGrace) * time.Second
+	if grace <= 0 {
+		grace = DefaultRotationGrace
+	}
+	expiring := make(map[string]time.Time, len(lifecycle.Expiring))
+	for key, expiresAt := range lifecycle.Expiring {
+		expiring[key] = expiresAt
+	}
+	listed := make(map[string]struct{}, len(cfg.APIKeys))
+	for _, key := range cfg.APIKeys {
+		listed[key] = struct{}{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prefix = prefix
+	m.rotationGrace = grace
+	m.expiring = expiring
+	for key := range m.revoked {
+		if _, ok := listed[key]; !ok {
+			delete(m.revoked, key)
+		}
+	}
+}
+
+// RotationGrace returns the default grace window of a rotated key.
+func (m *Manager) RotationGrace() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.rotationGrace
+}
+
+// Generate returns a new random key with the configured prefix.
+func (m *Manager) Generate() (string, error) {
+	m.mu.RLock()
+	prefix := m.prefix
+	m.mu.RUnlock()
+	buf := make([]byte, randomBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate api key: %w", err)
+	}
+	return prefix + hex.EncodeToString(buf), nil
+}
+
+// Revoke rejects key immediately, until a config reload no longer lists it.
+func (m *Manager) Revoke(key string) {
+	m.mu.Lock()
+	m.revoked[key] = struct{}{}
+	delete(m.expiring, key)
+	m.mu.Unlock()
+}
+
+// Expire sets when key stops working, taking effect before the config reload.
+func (m *Manager) Expire(key string, expiresAt time.Time) {
+	m.mu.Lock()
+	m.expiring[key] = expiresAt
+	m.mu.Unlock()
+}
+
+// Rejection returns why key must be refused, or "" when it is usable.
+func (m *Manager) Rejection(key string) string {
+	if m == nil || key == "" {
+		return ""
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.revoked[key]; ok {
+		return "API key revoked"
+	}
+	if expiresAt, ok := m.expiring[key]; ok && !m.now().Before(expiresAt) {
+		return "API key expired"
+	}
+	return ""
+}
+
+// List describes the keys of cfg, sorted by key.
+func (m *Manager) List(cfg *config.Config) []KeyInfo {
+	now := m.now()
+	out := make([]KeyInfo, 0, len(cfg.APIKeys))
+	for _, key := range cfg.APIKeys {
+		info := KeyInfo{Key: key, State: StateActive}
+		if expiresAt, ok := cfg.APIKeyLifecycle.Expiring[key]; ok {
+			expiresAt := expiresAt
+			info.ExpiresAt = &expiresAt
+			info.State = StateExpiring
+			if !now.Before(expiresAt) {
+				info.State = StateExpired
+			}
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// Prune removes keys whose grace window ended, with their per-key settings,
+// from cfg and reports whether it changed anything. Callers persist the
+// configuration.
+func (m *Manager) Prune(cfg *config.Config) bool {
+	now := m.now()
+	changed := false
+	for key, expiresAt := range cfg.APIKeyLifecycle.Expiring {
+		if now.Before(expiresAt) {
+			continue
+		}
+		RemoveKey(cfg, key)
+		RemoveSettings(cfg, key)
+		changed = true
+	}
+	return changed
+}
+
+// RemoveKey deletes key from the client key list and the expiry map of cfg.
+func RemoveKey(cfg *config.Config, key string) bool {
+	found := false
+	kept := make([]string, 0, len(cfg.APIKeys))
+	for _, existing := range cfg.APIKeys {
+		if existing == key {
+			found = true
+			continue
+		}
+		kept = append(kept, existing)
+	}
+	cfg.APIKeys = kept
+	if _, ok := cfg.APIKeyLifecycle.Expiring[key]; ok {
+		delete(cfg.APIKeyLifecycle.Expiring, key)
+		found = true
+	}
+	if len(cfg.APIKeyLifecycle.Expiring) == 0 {
+		cfg.APIKeyLifecycle.Expiring = nil
+	}
+	return found
+}
+
+// Middleware refuses revoked and expired keys. It runs after authentication.
+func (m *Manager) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if reason := m.Rejection(c.GetString("apiKey")); reason != "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": reason})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,67 @@
+package apikeys
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	sdkconfig "github.com/router-for-me/CLIProxyAPI/v6/sdk/config"
+)
+
+func TestManagerExpiresRotatedKeysAndForgetsRevocations(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cfg := &config.Config{SDKConfig: sdkconfig.SDKConfig{APIKeys: []string{"old", "new", "gone"}}}
+	cfg.APIKeyLifecycle.Prefix = "acme-"
+	cfg.APIKeyLifecycle.Expiring = map[string]time.Time{"old": now.Add(time.Hour)}
+	m := New(cfg)
+	m.now = func() time.Time { return now }
+
+	key, err := m.Generate()
+	if err != nil || !strings.HasPrefix(key, "acme-") || len(key) != len("acme-")+2*randomBytes {
+		t.Fatalf("Generate = %q, %v", key, err)
+	}
+	if reason := m.Rejection("old"); reason != "" {
+		t.Fatalf("old key refused inside its grace window: %s", reason)
+	}
+	m.now = func() time.Time { return now.Add(time.Hour) }
+	if reason := m.Rejection("old"); reason != "API key expired" {
+		t.Fatalf("old key after grace window: %q", reason)
+	}
+	if list := m.List(cfg); list[2].Key != "old" || list[2].State != StateExpired {
+		t.Fatalf("List = %+v", list)
+	}
+	if !m.Prune(cfg) || len(cfg.APIKeys) != 2 || cfg.APIKeyLifecycle.Expiring != nil {
+		t.Fatalf("Prune left %v %v", cfg.APIKeys, cfg.APIKeyLifecycle.Expiring)
+	}
+
+	m.Revoke("gone")
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) { c.Set("apiKey", c.GetHeader("X-Key")) }, m.Middleware())
+	engine.GET("/", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	for key, want := range map[string]int{"gone": http.StatusUnauthorized, "new": http.StatusNoContent} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Key", key)
+		rr := httptest.NewRecorder()
+		engine.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Fatalf("key %s: status %d, want %d", key, rr.Code, want)
+		}
+	}
+
+	// A reload that still lists the key keeps it revoked; once it is gone from the
+	// config, adding it again makes it usable.
+	m.Update(cfg)
+	if m.Rejection("gone") == "" {
+		t.Fatal("revocation lost while the key is still configured")
+	}
+	RemoveKey(cfg, "gone")
+	m.Update(cfg)
+	if reason := m.Rejection("gone"); reason != "" {
+		t.Fatalf("revocation kept after the key was removed: %s", reason)
+	}
+}
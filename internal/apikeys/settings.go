@@ -0,0 +1,115 @@
+package apikeys
+
+import (
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+)
+
+// CopySettings gives key to every per-key setting of cfg that from has, so a
+// rotated key keeps its limits, caps, tier and groups.
+func CopySettings(cfg *config.Config, from, to string) {
+	if limit, ok := cfg.ClientLimits.Keys[from]; ok {
+		cfg.ClientLimits.Keys[to] = limit
+	}
+	if limit, ok := cfg.Spend.Keys[from]; ok {
+		cfg.Spend.Keys[to] = limit
+	}
+	if ceiling, ok := cfg.CostCeiling.Keys[from]; ok {
+		cfg.CostCeiling.Keys[to] = ceiling
+	}
+	if budget, ok := cfg.LatencyBudget.Keys[from]; ok {
+		cfg.LatencyBudget.Keys[to] = budget
+	}
+	if tier, ok := cfg.FairShare.KeyTiers[from]; ok {
+		cfg.FairShare.KeyTiers[to] = tier
+	}
+	if cidrs, ok := cfg.DeviceBinding.TrustedCIDRsByKey[from]; ok {
+		cfg.DeviceBinding.TrustedCIDRsByKey[to] = append([]string(nil), cidrs...)
+	}
+	if rule, ok := cfg.IPAccess.Keys[from]; ok {
+		cfg.IPAccess.Keys[to] = config.IPAccessRule{
+			Allow: append([]string(nil), rule.Allow...),
+			Deny:  append([]string(nil), rule.Deny...),
+		}
+	}
+	if features, ok := cfg.RequestFeatures.Keys[from]; ok {
+		cfg.RequestFeatures.Keys[to] = append([]string(nil), features...)
+	}
+	if contains(cfg.BYOK.Keys, from) {
+		cfg.BYOK.Keys = append(cfg.BYOK.Keys, to)
+	}
+	for i := range cfg.ClientLimits.Groups {
+		if contains(cfg.ClientLimits.Groups[i].APIKeys, from) {
+			cfg.ClientLimits.Groups[i].APIKeys = append(cfg.ClientLimits.Groups[i].APIKeys, to)
+		}
+	}
+	for i := range cfg.Branding.Groups {
+		if contains(cfg.Branding.Groups[i].APIKeys, from) {
+			cfg.Branding.Groups[i].APIKeys = append(cfg.Branding.Groups[i].APIKeys, to)
+		}
+	}
+}
+
+// RemoveSettings deletes key from every per-key setting of cfg.
+func RemoveSettings(cfg *config.Config, key string) {
+	delete(cfg.ClientLimits.Keys, key)
+	delete(cfg.Spend.Keys, key)
+	delete(cfg.CostCeiling.Keys, key)
+	delete(cfg.LatencyBudget.Keys, key)
+	delete(cfg.FairShare.KeyTiers, key)
+	delete(cfg.DeviceBinding.TrustedCIDRsByKey, key)
+	delete(cfg.IPAccess.Keys, key)
+	delete(cfg.RequestFeatures.Keys, key)
+	cfg.BYOK.Keys = without(cfg.BYOK.Keys, key)
+	for i := range cfg.ClientLimits.Groups {
+		cfg.ClientLimits.Groups[i].APIKeys = without(cfg.ClientLimits.Groups[i].APIKeys, key)
+	}
+	for i := range cfg.Branding.Groups {
+		cfg.Branding.Groups[i].APIKeys = without(cfg.Branding.Groups[i].APIKeys, key)
+	}
+}
+
+// CopyBinding carries the key-level policy and metadata of a device binding
+// over to a rotated key. Devices themselves register again with the new key.
+func CopyBinding(store device.Store, from, to string) error {
+	if store == nil {
+		return nil
+	}
+	binding, ok := store.Get(from)
+	if !ok {
+		return nil
+	}
+	if binding.Policy != nil {
+		if err := store.SetPolicy(to, binding.Policy); err != nil {
+			return err
+		}
+	}
+	if len(binding.Metadata) > 0 {
+		if _, err := store.SetMetadata(to, "", binding.Metadata, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(keys []string, key string) bool {
+	for _, existing := range keys {
+		if existing == key {
+			return true
+		}
+	}
+	return false
+}
+
+func without(keys []string, key string) []string {
+	if !contains(keys, key) {
+		return keys
+	}
+	out := make([]string, 0, len(keys)-1)
+	for _, existing := range keys {
+		if existing != key {
+			out = append(out, existing)
+		}
+	}
+	return out
+}
@@ -0,0 +1,445 @@
+// Package audit writes one structured JSONL record per proxied request to
+// rotating files. A record combines what the middleware observes (key, device,
+// client IP, status, latency, body sizes) with the token usage the upstream
+// reports, which arrives asynchronously through the usage plugin pipeline and
+// is joined on the request ID.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/logging"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/pii"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Redaction actions
+const (
+	RedactDrop = "drop" // The field is omitted
+	RedactMask = "mask" // Only the first and last characters are kept
+	RedactHash = "hash" // The field is replaced by a truncated SHA-256
+)
+
+// Redactable record fields
+var redactableFields = []string{"api_key", "device_id", "ip", "user_agent", "model", "path", "prompt_hash"}
+
+// Defaults applied to zero configuration values
+const (
+	defaultMaxSizeMB = 100
+	defaultGrace     = 2 * time.Second
+	hashLength       = 16
+)
+
+// promptFields are the request body fields hashed into the prompt hash, covering
+// the Claude, OpenAI chat, OpenAI responses and Gemini request formats.
+var promptFields = []string{"system", "messages", "instructions", "input", "prompt", "systemInstruction", "contents"}
+
+var written = metrics.Default().NewCounterVec(
+	"cliproxy_audit_records_total",
+	"Audit records by outcome (written, failed).",
+	"outcome",
+)
+
+// Record is one audit log line.
+type Record struct {
+	Time            time.Time `json:"time"`
+	RequestID       string    `json:"request_id"`
+	Method          string    `json:"method"`
+	Path            string    `json:"path,omitempty"`
+	Status          int       `json:"status"`
+	LatencyMs       int64     `json:"latency_ms"`
+	APIKey          string    `json:"api_key,omitempty"`
+	DeviceID        string    `json:"device_id,omitempty"`
+	IP              string    `json:"ip,omitempty"`
+	UserAgent       string    `json:"user_agent,omitempty"`
+	Model           string    `json:"model,omitempty"`
+	Provider        string    `json:"provider,omitempty"`
+	Stream          bool      `json:"stream,omitempty"`
+	InputTokens     int64     `json:"input_tokens"`
+	OutputTokens    int64     `json:"output_tokens"`
+	ReasoningTokens int64     `json:"reasoning_tokens,omitempty"`
+	CachedTokens    int64     `json:"cached_tokens,omitempty"`
+	UpstreamFailed  bool      `json:"upstream_failed,omitempty"`
+	PromptHash      string    `json:"prompt_hash,omitempty"`
+	RequestBytes    int       `json:"request_bytes"`
+	ResponseBytes   int       `json:"response_bytes"`
+}
+
+// field returns the redactable string field called name.
+func (r *Record) field(name string) *string {
+	switch name {
+	case "api_key":
+		return &r.APIKey
+	case "device_id":
+		return &r.DeviceID
+	case "ip":
+		return &r.IP
+	case "user_agent":
+		return &r.UserAgent
+	case "model":
+		return &r.Model
+	case "path":
+		return &r.Path
+	case "prompt_hash":
+		return &r.PromptHash
+	}
+	return nil
+}
+
+// pending is a request whose record waits for its response, its usage or both.
+type pending struct {
+	record   Record
+	finished bool
+	reported bool
+	timer    *time.Timer
+}
+
+// Logger writes audit records.
+type Logger struct {
+	defaultPath string
+
+	mu      sync.Mutex
+	enabled bool
+	path    string
+	out     io.WriteCloser
+	redact  map[string]string
+	grace   time.Duration
+	pending map[string]*pending
+}
+
+// New creates a logger from configuration. defaultPath is used when the
+// configuration sets no path.
+func New(cfg config.AuditConfig, defaultPath string) *Logger {
+	l := &Logger{defaultPath: defaultPath, pending: make(map[string]*pending)}
+	l.Update(cfg)
+	return l
+}
+
+// Update replaces the configuration, reopening the output when the file or its
+// rotation settings change. Requests in flight are written with the new settings.
+func (l *Logger) Update(cfg config.AuditConfig) {
+	redact := map[string]string{"api_key": RedactMask}
+	for field, action := range cfg.Redact {
+		field = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(field)), "-", "_")
+		action = strings.ToLower(strings.TrimSpace(action))
+		if !ValidRedaction(field, action) {
+			log.Warnf("audit: ignoring redaction %q for field %q", action, field)
+			continue
+		}
+		redact[field] = action
+	}
+	if action := redact["api_key"]; action == "keep" {
+		delete(redact, "api_key")
+	}
+	path := strings.TrimSpace(cfg.Path)
+	if path == "" {
+		path = l.defaultPath
+	}
+	grace := defaultGrace
+	if cfg.UsageGraceMs > 0 {
+		grace = time.Duration(cfg.UsageGraceMs) * time.Millisecond
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enabled = cfg.Enabled && path != ""
+	l.redact = redact
+	l.grace = grace
+	if l.out != nil {
+		_ = l.out.Close()
+		l.out = nil
+	}
+	l.path = path
+	if !l.enabled {
+		return
+	}
+	maxSize := cfg.MaxSizeMB
+	if maxSize <= 0 {
+		maxSize = defaultMaxSizeMB
+	}
+	l.out = &lumberjack.Logger{
+		Filename:   filepath.Clean(path),
+		MaxSize:    maxSize,
+		MaxAge:     max(cfg.RetentionDays, 0),
+		MaxBackups: max(cfg.MaxBackups, 0),
+		Compress:   cfg.Compress,
+	}
+}
+
+// ValidRedaction reports whether action can be applied to field. The api_key
+// field additionally accepts "keep" to log keys unmasked.
+func ValidRedaction(field, action string) bool {
+	known := false
+	for _, f := range redactableFields {
+		known = known || f == field
+	}
+	if !known {
+		return false
+	}
+	switch action {
+	case RedactDrop, RedactMask, RedactHash:
+		return true
+	case "keep":
+		return field == "api_key"
+	}
+	return false
+}
+
+// Enabled reports whether records are written.
+func (l *Logger) Enabled() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enabled
+}
+
+// Close writes the records still waiting for usage and closes the output.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, p := range l.pending {
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+		if p.finished {
+			l.writeLocked(p.record)
+		}
+		delete(l.pending, id)
+	}
+	if l.out == nil {
+		return nil
+	}
+	err := l.out.Close()
+	l.out = nil
+	return err
+}
+
+// Middleware records every request passing through it. It must run before
+// authentication so rejected requests are audited too; the key and device are
+// read once the rest of the chain has run.
+func (l *Logger) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.Enabled() {
+			c.Next()
+			return
+		}
+		requestID := logging.GetGinRequestID(c)
+		if requestID == "" {
+			requestID = logging.GenerateRequestID()
+			logging.SetGinRequestID(c, requestID)
+			c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), requestID))
+		}
+
+		started := time.Now()
+		record := Record{
+			Time:      started.UTC(),
+			RequestID: requestID,
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}
+		if c.Request.Body != nil {
+			body, err := io.ReadAll(c.Request.Body)
+			_ = c.Request.Body.Close()
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			if err == nil {
+				record.RequestBytes = len(body)
+				record.Model = strings.TrimSpace(gjson.GetBytes(body, "model").String())
+				record.Stream = gjson.GetBytes(body, "stream").Bool()
+				record.PromptHash = PromptHash(body)
+			}
+		}
+		if record.Model == "" {
+			// Gemini routes carry the model in the path: /models/{model}:{method}
+			model, method, _ := strings.Cut(strings.TrimPrefix(c.Param("action"), "/"), ":")
+			record.Model = model
+			record.Stream = record.Stream || strings.HasPrefix(method, "stream")
+		}
+		l.begin(requestID)
+
+		defer func() {
+			record.Status = c.Writer.Status()
+			record.LatencyMs = time.Since(started).Milliseconds()
+			record.APIKey = c.GetString("apiKey")
+			record.DeviceID = c.GetString(device.DeviceIDContextKey)
+			if size := c.Writer.Size(); size > 0 {
+				record.ResponseBytes = size
+			}
+			l.finish(requestID, record)
+		}()
+		c.Next()
+	}
+}
+
+// HandleUsage implements coreusage.Plugin and attaches reported token usage to
+// the record of the request it belongs to.
+func (l *Logger) HandleUsage(ctx context.Context, usage coreusage.Record) {
+	if l == nil {
+		return
+	}
+	requestID := logging.GetRequestID(ctx)
+	if requestID == "" {
+		if c, ok := ctx.Value("gin").(*gin.Context); ok && c != nil {
+			requestID = logging.GetGinRequestID(c)
+		}
+	}
+	if requestID == "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, ok := l.pending[requestID]
+	if !ok {
+		return
+	}
+	p.reported = true
+	p.record.InputTokens += usage.Detail.InputTokens
+	p.record.OutputTokens += usage.Detail.OutputTokens
+	p.record.ReasoningTokens += usage.Detail.ReasoningTokens
+	p.record.CachedTokens += usage.Detail.CachedTokens
+	p.record.UpstreamFailed = p.record.UpstreamFailed || usage.Failed
+	if usage.Model != "" {
+		p.record.Model = usage.Model
+	}
+	if usage.Provider != "" {
+		p.record.Provider = usage.Provider
+	}
+	if p.finished {
+		l.completeLocked(requestID, p)
+	}
+}
+
+// begin registers a request so usage reported while it runs is kept.
+func (l *Logger) begin(requestID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending[requestID] = &pending{}
+}
+
+// finish merges the response details into the pending record. The record is
+// written right away when usage was already reported, otherwise after the
+// grace period in which asynchronous usage may still arrive.
+func (l *Logger) finish(requestID string, record Record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, ok := l.pending[requestID]
+	if !ok {
+		return
+	}
+	reported := p.record
+	p.record = record
+	p.finished = true
+	if !p.reported {
+		p.timer = time.AfterFunc(l.grace, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if current, ok := l.pending[requestID]; ok && current == p {
+				l.completeLocked(requestID, p)
+			}
+		})
+		return
+	}
+	p.record.InputTokens = reported.InputTokens
+	p.record.OutputTokens = reported.OutputTokens
+	p.record.ReasoningTokens = reported.ReasoningTokens
+	p.record.CachedTokens = reported.CachedTokens
+	p.record.UpstreamFailed = reported.UpstreamFailed
+	if reported.Model != "" {
+		p.record.Model = reported.Model
+	}
+	p.record.Provider = reported.Provider
+	l.completeLocked(requestID, p)
+}
+
+func (l *Logger) completeLocked(requestID string, p *pending) {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	delete(l.pending, requestID)
+	l.writeLocked(p.record)
+}
+
+// writeLocked redacts and appends one record.
+func (l *Logger) writeLocked(record Record) {
+	if l.out == nil {
+		return
+	}
+	for field, action := range l.redact {
+		if value := record.field(field); value != nil && *value != "" {
+			*value = redactValue(*value, action)
+		}
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		written.Inc("failed")
+		return
+	}
+	if _, err = l.out.Write(append(pii.Bytes(line), '\n')); err != nil {
+		written.Inc("failed")
+		log.Errorf("audit: failed to write record %s: %v", record.RequestID, err)
+		return
+	}
+	written.Inc("written")
+}
+
+func redactValue(value, action string) string {
+	switch action {
+	case RedactDrop:
+		return ""
+	case RedactMask:
+		return device.MaskKey(value)
+	case RedactHash:
+		return hashString(value)
+	}
+	return value
+}
+
+func hashString(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:hashLength]
+}
+
+// PromptHash returns a truncated SHA-256 of the prompt in a request body, so
+// identical prompts can be correlated without logging their content. Fields
+// such as the model or sampling parameters do not affect the hash. It returns
+// "" when the body carries no prompt.
+func PromptHash(body []byte) string {
+	h := sha256.New()
+	found := false
+	for _, field := range promptFields {
+		if value := gjson.GetBytes(body, field); value.Exists() {
+			found = true
+			h.Write([]byte(field))
+			h.Write([]byte{0})
+			h.Write([]byte(value.Raw))
+			h.Write([]byte{0})
+		}
+	}
+	if !found {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))[:hashLength]
+}
@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/logging"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+func readRecords(t *testing.T, path string) []Record {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("decode %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func newEngine(l *Logger, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(l.Middleware())
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", "sk-test-1234567890")
+		c.Set(device.DeviceIDContextKey, "device-a")
+		c.Next()
+	})
+	engine.POST("/v1/messages", handler)
+	return engine
+}
+
+func TestMiddlewareJoinsUsageReportedDuringRequest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l := New(config.AuditConfig{Enabled: true, Redact: map[string]string{"ip": RedactHash, "user-agent": RedactDrop}}, path)
+	engine := newEngine(l, func(c *gin.Context) {
+		ctx := logging.WithRequestID(c.Request.Context(), logging.GetGinRequestID(c))
+		l.HandleUsage(ctx, coreusage.Record{
+			Provider: "claude",
+			Model:    "claude-sonnet-4",
+			Detail:   coreusage.Detail{InputTokens: 120, OutputTokens: 30},
+		})
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	body := `{"model":"sonnet","messages":[{"role":"user","content":"hello"}],"stream":true}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("User-Agent", "claude-cli/1.0")
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+	if err := l.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	records := readRecords(t, path)
+	if len(records) != 1 {
+		t.Fatalf("records = %d, want 1", len(records))
+	}
+	r := records[0]
+	if r.Status != http.StatusOK || r.Model != "claude-sonnet-4" || r.Provider != "claude" || !r.Stream {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if r.InputTokens != 120 || r.OutputTokens != 30 || r.RequestBytes != len(body) {
+		t.Fatalf("unexpected counts %+v", r)
+	}
+	if r.APIKey != device.MaskKey("sk-test-1234567890") || r.DeviceID != "device-a" {
+		t.Fatalf("api key or device not recorded: %+v", r)
+	}
+	if r.UserAgent != "" || r.IP == "" || r.IP == "192.0.2.1" || len(r.IP) != hashLength {
+		t.Fatalf("redaction not applied: %+v", r)
+	}
+	if r.PromptHash != PromptHash([]byte(body)) || r.PromptHash == "" {
+		t.Fatalf("prompt hash = %q", r.PromptHash)
+	}
+}
+
+func TestMiddlewareWritesWithoutUsageAfterGrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l := New(config.AuditConfig{Enabled: true, UsageGraceMs: 10}, path)
+	engine := newEngine(l, func(c *gin.Context) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "upstream"})
+	})
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m"}`)))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			if records := readRecords(t, path); len(records) == 1 {
+				if records[0].Status != http.StatusBadGateway || records[0].Model != "m" || records[0].InputTokens != 0 {
+					t.Fatalf("unexpected record %+v", records[0])
+				}
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("record was not written after the grace period")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = l.Close()
+}
+
+func TestPromptHashIgnoresNonPromptFields(t *testing.T) {
+	a := PromptHash([]byte(`{"model":"a","temperature":0.1,"messages":[{"role":"user","content":"hi"}]}`))
+	b := PromptHash([]byte(`{"model":"b","messages":[{"role":"user","content":"hi"}]}`))
+	c := PromptHash([]byte(`{"model":"a","messages":[{"role":"user","content":"bye"}]}`))
+	if a == "" || a != b || a == c {
+		t.Fatalf("hashes a=%q b=%q c=%q", a, b, c)
+	}
+	if PromptHash([]byte(`{"model":"a"}`)) != "" {
+		t.Fatal("expected no hash without a prompt")
+	}
+}
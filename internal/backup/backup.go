@@ -0,0 +1,280 @@
+// Package backup periodically copies the device binding store to a local
+// directory or an S3-compatible bucket, keeps a fixed number of backups and
+// restores the store from one of them on request.
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultInterval  = 24 * time.Hour
+	defaultRetention = 7
+	formatVersion    = 1
+
+	namePrefix = "store-"
+	nameExt    = ".yaml"
+	gzipExt    = ".gz"
+	timeLayout = "20060102T150405.000Z"
+)
+
+var backupRuns = metrics.Default().NewCounterVec(
+	"cliproxy_store_backups_total",
+	"Device binding store backups by result.",
+	"result",
+)
+
+// ErrRestoreUnsupported is returned when the store cannot be written in bulk.
+var ErrRestoreUnsupported = errors.New("device binding store does not support restoring bindings")
+
+// Document is the content of a backup file.
+type Document struct {
+	Version   int                             `yaml:"version"`
+	CreatedAt time.Time                       `yaml:"created-at"`
+	Bindings  map[string]device.DeviceBinding `yaml:"bindings"`
+}
+
+// Info describes a stored backup.
+type Info struct {
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	Size       int64     `json:"size"`
+	Compressed bool      `json:"compressed"`
+	// Bindings is the number of bindings saved; only set for a backup just taken.
+	Bindings int `json:"bindings,omitempty"`
+}
+
+// Manager takes, lists and restores backups of one store.
+type Manager struct {
+	store     device.Store
+	target    Target
+	interval  time.Duration
+	retention int
+	compress  bool
+	now       func() time.Time
+
+	// mu serializes backups and restores so a restore never races a prune.
+	mu sync.Mutex
+}
+
+// New creates a backup manager for store.
+func New(cfg config.BackupConfig, store device.Store) (*Manager, error) {
+	target, err := NewTarget(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithTarget(cfg, store, target), nil
+}
+
+// NewWithTarget creates a backup manager writing to target.
+func NewWithTarget(cfg config.BackupConfig, store device.Store, target Target) *Manager {
+	m := &Manager{
+		store:     store,
+		target:    target,
+		interval:  time.Duration(cfg.Interval) * time.Second,
+		retention: cfg.Retention,
+		compress:  cfg.Compress,
+		now:       time.Now,
+	}
+	if m.interval <= 0 {
+		m.interval = defaultInterval
+	}
+	if m.retention <= 0 {
+		m.retention = defaultRetention
+	}
+	return m
+}
+
+// Target returns where backups are stored.
+func (m *Manager) Target() string { return m.target.String() }
+
+// Backup saves the store and deletes backups beyond the retention count.
+func (m *Manager) Backup(ctx context.Context) (Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info, err := m.backupLocked(ctx, true)
+	if err != nil {
+		backupRuns.Inc("failure")
+		events.Publish(events.Event{Type: events.TypeBackupFailed, Actor: "system", Reason: err.Error(), Data: map[string]any{"target": m.target.String()}})
+		return Info{}, err
+	}
+	backupRuns.Inc("success")
+	return info, nil
+}
+
+// backupLocked saves the store, deleting old backups when prune is set.
+// Callers must hold m.mu.
+func (m *Manager) backupLocked(ctx context.Context, prune bool) (Info, error) {
+	now := m.now().UTC()
+	doc := Document{Version: formatVersion, CreatedAt: now, Bindings: m.store.GetAll()}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return Info{}, fmt.Errorf("backup: encode: %w", err)
+	}
+	name := namePrefix + now.Format(timeLayout) + nameExt
+	if m.compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err = zw.Write(data); err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			return Info{}, fmt.Errorf("backup: compress: %w", err)
+		}
+		data, name = buf.Bytes(), name+gzipExt
+	}
+	if err = m.target.Put(ctx, name, data); err != nil {
+		return Info{}, fmt.Errorf("backup: write %s to %s: %w", name, m.target, err)
+	}
+	if prune {
+		if err = m.pruneLocked(ctx); err != nil {
+			log.Warnf("backup: prune old backups: %v", err)
+		}
+	}
+	return Info{Name: name, CreatedAt: now, Size: int64(len(data)), Compressed: m.compress, Bindings: len(doc.Bindings)}, nil
+}
+
+// List returns the stored backups, newest first.
+func (m *Manager) List(ctx context.Context) ([]Info, error) {
+	objects, err := m.target.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("backup: list %s: %w", m.target, err)
+	}
+	out := make([]Info, 0, len(objects))
+	for _, obj := range objects {
+		createdAt, compressed, ok := parseName(obj.name)
+		if !ok {
+			continue
+		}
+		out = append(out, Info{Name: obj.name, CreatedAt: createdAt, Size: obj.size, Compressed: compressed})
+	}
+	slices.SortFunc(out, func(a, b Info) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return out, nil
+}
+
+// pruneLocked deletes the backups beyond the retention count. Callers must hold m.mu.
+func (m *Manager) pruneLocked(ctx context.Context) error {
+	backups, err := m.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, old := range backups[min(m.retention, len(backups)):] {
+		if err = m.target.Delete(ctx, old.Name); err != nil {
+			return fmt.Errorf("delete %s: %w", old.Name, err)
+		}
+	}
+	return nil
+}
+
+// Restore replaces every binding of the store with the content of the named
+// backup. The current state is backed up first, without pruning, so a restore
+// can be undone; that backup is returned with the number of bindings restored.
+func (m *Manager) Restore(ctx context.Context, name, actor string) (Info, int, error) {
+	if _, _, ok := parseName(name); !ok {
+		return Info{}, 0, ErrNotFound
+	}
+	writer, ok := m.store.(device.BindingWriter)
+	if !ok {
+		return Info{}, 0, ErrRestoreUnsupported
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := m.target.Get(ctx, name)
+	if err != nil {
+		return Info{}, 0, err
+	}
+	doc, err := decode(name, data)
+	if err != nil {
+		return Info{}, 0, err
+	}
+	undo, err := m.backupLocked(ctx, false)
+	if err != nil {
+		return Info{}, 0, fmt.Errorf("backup current state: %w", err)
+	}
+	if err = m.store.Clear(); err != nil {
+		return undo, 0, fmt.Errorf("backup: clear store: %w", err)
+	}
+	if len(doc.Bindings) > 0 {
+		if err = writer.PutBindings(doc.Bindings); err != nil {
+			return undo, 0, fmt.Errorf("backup: write bindings: %w", err)
+		}
+	}
+	events.Publish(events.Event{
+		Type:  events.TypeBackupRestored,
+		Actor: actor,
+		Data:  map[string]any{"name": name, "bindings": len(doc.Bindings), "undo": undo.Name},
+	})
+	log.Infof("backup: restored %d bindings from %s (previous state saved as %s)", len(doc.Bindings), name, undo.Name)
+	return undo, len(doc.Bindings), nil
+}
+
+// Run takes a backup every interval until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := m.Backup(ctx); err != nil {
+				log.Warn(err)
+			}
+		}
+	}
+}
+
+func decode(name string, data []byte) (Document, error) {
+	if strings.HasSuffix(name, gzipExt) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return Document{}, fmt.Errorf("backup: decompress %s: %w", name, err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return Document{}, fmt.Errorf("backup: decompress %s: %w", name, err)
+		}
+	}
+	var doc Document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Document{}, fmt.Errorf("backup: decode %s: %w", name, err)
+	}
+	if doc.Version != formatVersion {
+		return Document{}, fmt.Errorf("backup: %s has unsupported version %d", name, doc.Version)
+	}
+	return doc, nil
+}
+
+// parseName returns the creation time of a backup file name and whether it is
+// compressed, or false for names that are not backups.
+func parseName(name string) (time.Time, bool, bool) {
+	compressed := strings.HasSuffix(name, gzipExt)
+	stamp, ok := strings.CutPrefix(strings.TrimSuffix(name, gzipExt), namePrefix)
+	if !ok {
+		return time.Time{}, false, false
+	}
+	if stamp, ok = strings.CutSuffix(stamp, nameExt); !ok {
+		return time.Time{}, false, false
+	}
+	createdAt, err := time.Parse(timeLayout, stamp)
+	if err != nil {
+		return time.Time{}, false, false
+	}
+	return createdAt, compressed, true
+}
@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+)
+
+func TestBackupRetentionAndRestore(t *testing.T) {
+	store, err := device.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.AddDeviceWithinLimit("key-1", "dev-a", "ip", "10.0.0.1", false, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(config.BackupConfig{Dir: t.TempDir(), Retention: 2, Compress: true}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	first, err := m.Backup(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(first.Name, ".yaml.gz") || first.Bindings != 1 {
+		t.Fatalf("unexpected backup %+v", first)
+	}
+	for i := 0; i < 2; i++ {
+		now = now.Add(time.Hour)
+		if _, err = m.Backup(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := m.List(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || !list[0].CreatedAt.After(list[1].CreatedAt) {
+		t.Fatalf("expected the 2 newest backups, newest first, got %+v", list)
+	}
+	if _, _, err = m.Restore(t.Context(), first.Name, "admin"); err != ErrNotFound {
+		t.Fatalf("pruned backup: err = %v, want ErrNotFound", err)
+	}
+
+	if _, err = store.AddDeviceWithinLimit("key-2", "dev-b", "ip", "10.0.0.2", false, 10); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	undo, restored, err := m.Restore(t.Context(), list[0].Name, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != 1 {
+		t.Fatalf("restored = %d, want 1", restored)
+	}
+	if _, ok := store.Get("key-2"); ok {
+		t.Fatal("key-2 should be gone after restoring a backup taken before it was bound")
+	}
+	if _, ok := store.Get("key-1"); !ok {
+		t.Fatal("key-1 should be restored")
+	}
+
+	if _, restored, err = m.Restore(t.Context(), undo.Name, "admin"); err != nil || restored != 2 {
+		t.Fatalf("undo restore: restored=%d err=%v", restored, err)
+	}
+	if _, ok := store.Get("key-2"); !ok {
+		t.Fatal("undoing the restore should bring key-2 back")
+	}
+}
+
+func TestRestoreRejectsForeignNames(t *testing.T) {
+	store, err := device.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(config.BackupConfig{Dir: t.TempDir()}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"../config.yaml", "store-latest.yaml", "device-bindings.yaml"} {
+		if _, _, err = m.Restore(t.Context(), name, "admin"); err != ErrNotFound {
+			t.Errorf("%s: err = %v, want ErrNotFound", name, err)
+		}
+	}
+}
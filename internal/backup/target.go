@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+// DefaultDir is used when no backup directory or bucket is configured.
+const DefaultDir = "backups"
+
+// ErrNotFound is returned when a named backup does not exist.
+var ErrNotFound = errors.New("backup not found")
+
+// object is a stored backup file.
+type object struct {
+	name string
+	size int64
+}
+
+// Target stores backup files.
+type Target interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+	List(ctx context.Context) ([]object, error)
+	Delete(ctx context.Context, name string) error
+	// String describes the location in logs and reports.
+	String() string
+}
+
+// NewTarget returns the S3 bucket of cfg when one is set, or its local directory.
+func NewTarget(cfg config.BackupConfig) (Target, error) {
+	if strings.TrimSpace(cfg.S3.Bucket) != "" {
+		return newS3Target(cfg.S3)
+	}
+	dir := strings.TrimSpace(cfg.Dir)
+	if dir == "" {
+		dir = DefaultDir
+	}
+	return &dirTarget{dir: dir}, nil
+}
+
+// dirTarget keeps backups in a local directory.
+type dirTarget struct {
+	dir string
+}
+
+func (t *dirTarget) String() string { return t.dir }
+
+func (t *dirTarget) Put(_ context.Context, name string, data []byte) error {
+	if err := os.MkdirAll(t.dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(t.dir, ".backup-*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), filepath.Join(t.dir, name)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (t *dirTarget) Get(_ context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(t.dir, name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (t *dirTarget) List(_ context.Context) ([]object, error) {
+	entries, err := os.ReadDir(t.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make([]object, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, errInfo := entry.Info()
+		if errInfo != nil {
+			continue
+		}
+		out = append(out, object{name: entry.Name(), size: info.Size()})
+	}
+	return out, nil
+}
+
+func (t *dirTarget) Delete(_ context.Context, name string) error {
+	err := os.Remove(filepath.Join(t.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// s3Target keeps backups in an S3-compatible bucket.
+type s3Target struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newS3Target(cfg config.BackupS3Config) (*s3Target, error) {
+	endpoint := strings.TrimSpace(cfg.Endpoint)
+	if endpoint == "" {
+		return nil, fmt.Errorf("backup: s3 endpoint is required")
+	}
+	options := &minio.Options{
+		Creds:  credentials.NewStaticV4(strings.TrimSpace(cfg.AccessKey), strings.TrimSpace(cfg.SecretKey), ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	}
+	if cfg.PathStyle {
+		options.BucketLookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(endpoint, options)
+	if err != nil {
+		return nil, fmt.Errorf("backup: create s3 client: %w", err)
+	}
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &s3Target{client: client, bucket: strings.TrimSpace(cfg.Bucket), prefix: prefix}, nil
+}
+
+func (t *s3Target) String() string { return "s3://" + path.Join(t.bucket, t.prefix) }
+
+func (t *s3Target) Put(ctx context.Context, name string, data []byte) error {
+	_, err := t.client.PutObject(ctx, t.bucket, t.prefix+name, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	return err
+}
+
+func (t *s3Target) Get(ctx context.Context, name string) ([]byte, error) {
+	obj, err := t.client.GetObject(ctx, t.bucket, t.prefix+name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = obj.Close() }()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (t *s3Target) List(ctx context.Context) ([]object, error) {
+	var out []object
+	for info := range t.client.ListObjects(ctx, t.bucket, minio.ListObjectsOptions{Prefix: t.prefix}) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		name := strings.TrimPrefix(info.Key, t.prefix)
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		out = append(out, object{name: name, size: info.Size})
+	}
+	return out, nil
+}
+
+func (t *s3Target) Delete(ctx context.Context, name string) error {
+	return t.client.RemoveObject(ctx, t.bucket, t.prefix+name, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,243 @@
+// Package branding lets resellers present their own identity to end users:
+// per key group response headers and support contact details that are added
+// to error responses in place of the proxy's defaults.
+package branding
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+)
+
+// MetadataKey is the key metadata attribute naming the branding group of an API key.
+const MetadataKey = "reseller"
+
+// Group is the resolved branding of one key group.
+type Group struct {
+	Name         string
+	Headers      map[string]string
+	SupportName  string
+	SupportEmail string
+	SupportURL   string
+	Message      string
+}
+
+func (g *Group) support() map[string]string {
+	out := make(map[string]string, 4)
+	for k, v := range map[string]string{
+		"name":    g.SupportName,
+		"email":   g.SupportEmail,
+		"url":     g.SupportURL,
+		"message": g.Message,
+	} {
+		if v != "" {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Branding resolves API keys to branding groups.
+type Branding struct {
+	mu      sync.RWMutex
+	enabled bool
+	byKey   map[string]*Group
+	byName  map[string]*Group
+}
+
+// New creates branding from configuration.
+func New(cfg config.BrandingConfig) *Branding {
+	b := &Branding{}
+	b.Update(cfg)
+	return b
+}
+
+// Update replaces the configuration.
+func (b *Branding) Update(cfg config.BrandingConfig) {
+	byKey := make(map[string]*Group)
+	byName := make(map[string]*Group, len(cfg.Groups))
+	for _, gc := range cfg.Groups {
+		name := strings.TrimSpace(gc.Name)
+		g := &Group{
+			Name:         name,
+			Headers:      gc.Headers,
+			SupportName:  strings.TrimSpace(gc.SupportName),
+			SupportEmail: strings.TrimSpace(gc.SupportEmail),
+			SupportURL:   strings.TrimSpace(gc.SupportURL),
+			Message:      strings.TrimSpace(gc.Message),
+		}
+		if name != "" {
+			byName[strings.ToLower(name)] = g
+		}
+		for _, key := range gc.APIKeys {
+			if key = strings.TrimSpace(key); key != "" {
+				byKey[key] = g
+			}
+		}
+	}
+	b.mu.Lock()
+	b.enabled = cfg.Enabled
+	b.byKey = byKey
+	b.byName = byName
+	b.mu.Unlock()
+}
+
+// Resolve returns the branding group for an API key. Explicit key lists win
+// over the "reseller" attribute of the key metadata.
+func (b *Branding) Resolve(apiKey string, metadata map[string]string) *Group {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if !b.enabled || apiKey == "" {
+		return nil
+	}
+	if g, ok := b.byKey[apiKey]; ok {
+		return g
+	}
+	if name := strings.ToLower(strings.TrimSpace(metadata[MetadataKey])); name != "" {
+		return b.byName[name]
+	}
+	return nil
+}
+
+// Middleware adds the group's headers to every response and its support
+// details to JSON error responses. It must run after authentication; the group
+// is resolved when the response is written so later middleware can still
+// contribute key metadata.
+func (b *Branding) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		b.mu.RLock()
+		enabled := b.enabled
+		b.mu.RUnlock()
+		if !enabled || c.GetString("apiKey") == "" {
+			c.Next()
+			return
+		}
+		w := &brandedWriter{ResponseWriter: c.Writer, ctx: c, branding: b}
+		c.Writer = w
+		c.Next()
+		w.flush()
+	}
+}
+
+// brandedWriter applies branding when the response starts and holds back
+// error bodies so support details can be merged into them.
+type brandedWriter struct {
+	gin.ResponseWriter
+	ctx      *gin.Context
+	branding *Branding
+	group    *Group
+	resolved bool
+	status   int
+	errBody  *bytes.Buffer
+}
+
+func (w *brandedWriter) resolve() {
+	if w.resolved {
+		return
+	}
+	w.resolved = true
+	metadata, _ := w.ctx.Value(device.MetadataContextKey).(map[string]string)
+	w.group = w.branding.Resolve(w.ctx.GetString("apiKey"), metadata)
+	if w.group == nil {
+		return
+	}
+	for k, v := range w.group.Headers {
+		w.Header().Set(k, v)
+	}
+	if w.group.SupportEmail != "" {
+		w.Header().Set("X-Support-Contact", w.group.SupportEmail)
+	} else if w.group.SupportURL != "" {
+		w.Header().Set("X-Support-Contact", w.group.SupportURL)
+	}
+}
+
+func (w *brandedWriter) WriteHeader(code int) {
+	w.resolve()
+	if w.group != nil && code >= http.StatusBadRequest && w.errBody == nil {
+		w.status = code
+		w.errBody = &bytes.Buffer{}
+		return
+	}
+	if w.errBody != nil {
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *brandedWriter) Write(data []byte) (int, error) {
+	w.resolve()
+	if w.errBody == nil && w.group != nil && w.ResponseWriter.Status() >= http.StatusBadRequest && !w.ResponseWriter.Written() {
+		w.status = w.ResponseWriter.Status()
+		w.errBody = &bytes.Buffer{}
+	}
+	if w.errBody != nil {
+		return w.errBody.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *brandedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Status reports the held-back status for buffered error responses.
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *brandedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *brandedWriter) Status() int {
+	if w.errBody != nil {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+// Flush forwards flushes for streamed success responses only.
+func (w *brandedWriter) Flush() {
+	if w.errBody != nil {
+		return
+	}
+	w.ResponseWriter.Flush()
+}
+
+// flush writes a held-back error response with the support details merged in.
+func (w *brandedWriter) flush() {
+	if w.errBody == nil {
+		return
+	}
+	body := w.errBody.Bytes()
+	if w.group != nil && strings.Contains(w.Header().Get("Content-Type"), "json") {
+		body = withSupport(body, w.group)
+	}
+	w.errBody = nil
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(body)
+}
+
+// withSupport adds a "support" object to a JSON object body. Other bodies are returned unchanged.
+func withSupport(body []byte, g *Group) []byte {
+	support := g.support()
+	if len(support) == 0 {
+		return body
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return body
+	}
+	payload["support"] = support
+	out, err := json.Marshal(payload)
+	if err != nil {
+		return body
+	}
+	return out
+}
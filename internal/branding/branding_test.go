@@ -0,0 +1,87 @@
+package branding
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+)
+
+func newTestEngine(b *Branding) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("X-Test-Key"))
+		if reseller := c.GetHeader("X-Test-Reseller"); reseller != "" {
+			c.Set(device.MetadataContextKey, map[string]string{MetadataKey: reseller})
+		}
+		c.Next()
+	})
+	engine.Use(b.Middleware())
+	engine.GET("/ok", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) })
+	engine.GET("/fail", func(c *gin.Context) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "device_banned", "message": "banned"})
+	})
+	return engine
+}
+
+func doRequest(engine *gin.Engine, path, key, reseller string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-Test-Key", key)
+	if reseller != "" {
+		req.Header.Set("X-Test-Reseller", reseller)
+	}
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddlewareBrandsResponsesForKeyGroup(t *testing.T) {
+	b := New(config.BrandingConfig{
+		Enabled: true,
+		Groups: []config.BrandingGroup{{
+			Name:         "acme",
+			APIKeys:      []string{"k1"},
+			Headers:      map[string]string{"X-Powered-By": "Acme AI"},
+			SupportName:  "Acme Support",
+			SupportEmail: "support@acme.example",
+		}},
+	})
+	engine := newTestEngine(b)
+
+	rec := doRequest(engine, "/ok", "k1", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Powered-By") != "Acme AI" {
+		t.Fatalf("expected branded 200, got %d %q", rec.Code, rec.Header().Get("X-Powered-By"))
+	}
+	if got := rec.Header().Get("X-Support-Contact"); got != "support@acme.example" {
+		t.Fatalf("X-Support-Contact = %q", got)
+	}
+
+	// Metadata-assigned keys resolve by group name.
+	rec = doRequest(engine, "/fail", "k2", "ACME")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+	var body struct {
+		Error   string            `json:"error"`
+		Support map[string]string `json:"support"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v (%s)", err, rec.Body.String())
+	}
+	if body.Error != "device_banned" || body.Support["email"] != "support@acme.example" || body.Support["name"] != "Acme Support" {
+		t.Fatalf("unexpected error body: %s", rec.Body.String())
+	}
+
+	rec = doRequest(engine, "/fail", "k3", "")
+	if rec.Header().Get("X-Powered-By") != "" || json.Unmarshal(rec.Body.Bytes(), &map[string]any{}) != nil {
+		t.Fatalf("expected unbranded response for unknown key")
+	}
+	if got := rec.Body.String(); got != `{"error":"device_banned","message":"banned"}` {
+		t.Fatalf("unbranded body changed: %s", got)
+	}
+}
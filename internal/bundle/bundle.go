@@ -0,0 +1,266 @@
+// Package bundle exports and imports the per-key state of a proxy: device
+// bindings with their bans and policies, and the per-key settings and key
+// groups of the config file. A bundle moves that state between instances or
+// restores it after a loss, as versioned YAML or JSON.
+package bundle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the bundle format written by Export.
+const Version = 1
+
+// Encodings accepted by Encode
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Import modes
+const (
+	// ModeMerge adds the bundle to the current state; entries of the same key
+	// or group name are replaced.
+	ModeMerge = "merge"
+	// ModeReplace discards the current bindings and per-key settings first.
+	ModeReplace = "replace"
+)
+
+// ErrBindingsUnsupported is returned when importing bindings into a store that
+// cannot write complete bindings.
+var ErrBindingsUnsupported = errors.New("device binding store does not support importing bindings")
+
+// Bundle is the exported state. Fields use their config file and binding store
+// names, so a bundle reads like the files it was taken from.
+type Bundle struct {
+	Version    int                             `yaml:"version"`
+	ExportedAt time.Time                       `yaml:"exported-at"`
+	Bindings   map[string]device.DeviceBinding `yaml:"bindings,omitempty"`
+	Keys       Keys                            `yaml:"keys"`
+}
+
+// Keys are the client keys and their per-key settings.
+type Keys struct {
+	APIKeys         []string                       `yaml:"api-keys,omitempty"`
+	Expiring        map[string]time.Time           `yaml:"expiring,omitempty"`
+	ClientLimits    map[string]config.ClientLimit  `yaml:"client-limits,omitempty"`
+	Spend           map[string]float64             `yaml:"spend,omitempty"`
+	CostCeiling     map[string]float64             `yaml:"cost-ceiling,omitempty"`
+	LatencyBudget   map[string]int                 `yaml:"latency-budget,omitempty"`
+	Tiers           map[string]string              `yaml:"tiers,omitempty"`
+	TrustedCIDRs    map[string][]string            `yaml:"trusted-cidrs,omitempty"`
+	IPAccess        map[string]config.IPAccessRule `yaml:"ip-access,omitempty"`
+	RequestFeatures map[string][]string            `yaml:"request-features,omitempty"`
+	BYOK            []string                       `yaml:"byok,omitempty"`
+	Groups          Groups                         `yaml:"groups,omitempty"`
+}
+
+// Groups are the named key groups.
+type Groups struct {
+	ClientLimits []config.ClientLimitGroup `yaml:"client-limits,omitempty"`
+	Branding     []config.BrandingGroup    `yaml:"branding,omitempty"`
+}
+
+// Report summarises an import.
+type Report struct {
+	Mode     string `json:"mode"`
+	DryRun   bool   `json:"dry_run"`
+	Bindings int    `json:"bindings"`
+	Banned   int    `json:"banned"`
+	// APIKeysAdded counts keys the config did not list before.
+	APIKeysAdded int `json:"api_keys_added"`
+	Settings     int `json:"settings"`
+	Groups       int `json:"groups"`
+	// BindingsSkipped is set when the bundle holds bindings but device binding is disabled.
+	BindingsSkipped bool `json:"bindings_skipped,omitempty"`
+}
+
+// Export collects the state of cfg and store, which may be nil.
+func Export(cfg *config.Config, store device.Store, now time.Time) Bundle {
+	b := Bundle{
+		Version:    Version,
+		ExportedAt: now.UTC(),
+		Keys: Keys{
+			APIKeys:         slices.Clone(cfg.APIKeys),
+			Expiring:        cfg.APIKeyLifecycle.Expiring,
+			ClientLimits:    cfg.ClientLimits.Keys,
+			Spend:           cfg.Spend.Keys,
+			CostCeiling:     cfg.CostCeiling.Keys,
+			LatencyBudget:   cfg.LatencyBudget.Keys,
+			Tiers:           cfg.FairShare.KeyTiers,
+			TrustedCIDRs:    cfg.DeviceBinding.TrustedCIDRsByKey,
+			IPAccess:        cfg.IPAccess.Keys,
+			RequestFeatures: cfg.RequestFeatures.Keys,
+			BYOK:            slices.Clone(cfg.BYOK.Keys),
+			Groups: Groups{
+				ClientLimits: cfg.ClientLimits.Groups,
+				Branding:     cfg.Branding.Groups,
+			},
+		},
+	}
+	if store != nil {
+		b.Bindings = store.GetAll()
+	}
+	return b
+}
+
+// Encode renders b as YAML or as JSON with the same field names.
+func Encode(b Bundle, format string) ([]byte, error) {
+	raw, err := yaml.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatYAML:
+		return raw, nil
+	case FormatJSON, "":
+		// Binding and config types hide keys from their JSON form, so JSON is
+		// produced from the YAML document to keep every field.
+		var doc any
+		if err = yaml.Unmarshal(raw, &doc); err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(doc, "", "  ")
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// Parse reads a YAML or JSON bundle.
+func Parse(data []byte) (Bundle, error) {
+	var b Bundle
+	// YAML is a superset of JSON, so one decoder reads both encodings.
+	if err := yaml.Unmarshal(data, &b); err != nil {
+		return b, fmt.Errorf("parse bundle: %w", err)
+	}
+	switch {
+	case b.Version == 0:
+		return b, errors.New("parse bundle: version is missing")
+	case b.Version > Version:
+		return b, fmt.Errorf("parse bundle: version %d is newer than the supported version %d", b.Version, Version)
+	}
+	return b, nil
+}
+
+// Import applies b to cfg and store, which may be nil. cfg is modified in
+// place and the caller saves it; bindings are written to store unless dryRun.
+func Import(cfg *config.Config, store device.Store, b Bundle, mode string, dryRun bool) (Report, error) {
+	if mode == "" {
+		mode = ModeMerge
+	}
+	if mode != ModeMerge && mode != ModeReplace {
+		return Report{}, fmt.Errorf("mode must be %s or %s", ModeMerge, ModeReplace)
+	}
+	replace := mode == ModeReplace
+	report := Report{Mode: mode, DryRun: dryRun, Bindings: len(b.Bindings)}
+	for _, binding := range b.Bindings {
+		if binding.Banned {
+			report.Banned++
+		}
+	}
+
+	if len(b.Bindings) > 0 || replace {
+		writer, ok := store.(device.BindingWriter)
+		switch {
+		case store == nil:
+			report.BindingsSkipped = len(b.Bindings) > 0
+		case !ok:
+			return report, ErrBindingsUnsupported
+		case !dryRun:
+			if replace {
+				if err := store.Clear(); err != nil {
+					return report, fmt.Errorf("clear bindings: %w", err)
+				}
+			}
+			if len(b.Bindings) > 0 {
+				if err := writer.PutBindings(b.Bindings); err != nil {
+					return report, fmt.Errorf("write bindings: %w", err)
+				}
+			}
+		}
+	}
+
+	keys := b.Keys
+	if replace {
+		before := make(map[string]struct{}, len(cfg.APIKeys))
+		for _, key := range cfg.APIKeys {
+			before[key] = struct{}{}
+		}
+		for _, key := range keys.APIKeys {
+			if _, ok := before[key]; !ok {
+				report.APIKeysAdded++
+			}
+		}
+		cfg.APIKeys = slices.Clone(keys.APIKeys)
+		cfg.BYOK.Keys = slices.Clone(keys.BYOK)
+	} else {
+		cfg.APIKeys, report.APIKeysAdded = appendMissing(cfg.APIKeys, keys.APIKeys)
+		cfg.BYOK.Keys, _ = appendMissing(cfg.BYOK.Keys, keys.BYOK)
+	}
+	report.Settings += mergeMap(&cfg.APIKeyLifecycle.Expiring, keys.Expiring, replace)
+	report.Settings += mergeMap(&cfg.ClientLimits.Keys, keys.ClientLimits, replace)
+	report.Settings += mergeMap(&cfg.Spend.Keys, keys.Spend, replace)
+	report.Settings += mergeMap(&cfg.CostCeiling.Keys, keys.CostCeiling, replace)
+	report.Settings += mergeMap(&cfg.LatencyBudget.Keys, keys.LatencyBudget, replace)
+	report.Settings += mergeMap(&cfg.FairShare.KeyTiers, keys.Tiers, replace)
+	report.Settings += mergeMap(&cfg.DeviceBinding.TrustedCIDRsByKey, keys.TrustedCIDRs, replace)
+	report.Settings += mergeMap(&cfg.IPAccess.Keys, keys.IPAccess, replace)
+	report.Settings += mergeMap(&cfg.RequestFeatures.Keys, keys.RequestFeatures, replace)
+	report.Groups += mergeGroups(&cfg.ClientLimits.Groups, keys.Groups.ClientLimits, replace, func(g config.ClientLimitGroup) string { return g.Name })
+	report.Groups += mergeGroups(&cfg.Branding.Groups, keys.Groups.Branding, replace, func(g config.BrandingGroup) string { return g.Name })
+	return report, nil
+}
+
+// appendMissing appends the keys of add that list lacks and returns how many.
+func appendMissing(list, add []string) ([]string, int) {
+	added := 0
+	for _, key := range add {
+		if key != "" && !slices.Contains(list, key) {
+			list = append(list, key)
+			added++
+		}
+	}
+	return list, added
+}
+
+// mergeMap copies src into *dst, replacing *dst entirely when replace is set,
+// and returns the number of entries copied.
+func mergeMap[V any](dst *map[string]V, src map[string]V, replace bool) int {
+	if replace {
+		*dst = nil
+	}
+	if len(src) == 0 {
+		return 0
+	}
+	if *dst == nil {
+		*dst = make(map[string]V, len(src))
+	}
+	for key, value := range src {
+		(*dst)[key] = value
+	}
+	return len(src)
+}
+
+// mergeGroups adds src to *dst; a group of the same name is replaced in place.
+func mergeGroups[G any](dst *[]G, src []G, replace bool, name func(G) string) int {
+	if replace {
+		*dst = slices.Clone(src)
+		return len(src)
+	}
+	for _, group := range src {
+		i := slices.IndexFunc(*dst, func(existing G) bool { return name(existing) == name(group) })
+		if i >= 0 {
+			(*dst)[i] = group
+		} else {
+			*dst = append(*dst, group)
+		}
+	}
+	return len(src)
+}
@@ -0,0 +1,97 @@
+package bundle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src, err := device.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if _, err = src.AddDeviceWithinLimit("sk-a", "dev-1", "desktop", "10.0.0.1", false, 10); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err = src.Ban("sk-a", "sharing", time.Hour, "10.0.0.1"); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+	if err = src.SetPolicy("sk-a", &device.Policy{MaxDevices: 3}); err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+	srcCfg := &config.Config{}
+	srcCfg.APIKeys = []string{"sk-a", "sk-b"}
+	srcCfg.ClientLimits.Keys = map[string]config.ClientLimit{"sk-a": {RequestsPerMinute: 10}}
+	srcCfg.ClientLimits.Groups = []config.ClientLimitGroup{{Name: "team", APIKeys: []string{"sk-a", "sk-b"}}}
+
+	for _, format := range []string{FormatJSON, FormatYAML} {
+		data, errEncode := Encode(Export(srcCfg, src, time.Now()), format)
+		if errEncode != nil {
+			t.Fatalf("Encode %s: %v", format, errEncode)
+		}
+		b, errParse := Parse(data)
+		if errParse != nil {
+			t.Fatalf("Parse %s: %v\n%s", format, errParse, data)
+		}
+
+		dst, _ := device.NewFileStore(t.TempDir())
+		dstCfg := &config.Config{}
+		dstCfg.APIKeys = []string{"sk-b", "sk-local"}
+		dstCfg.ClientLimits.Groups = []config.ClientLimitGroup{{Name: "team", APIKeys: []string{"sk-local"}}, {Name: "other"}}
+		report, errImport := Import(dstCfg, dst, b, ModeMerge, false)
+		if errImport != nil {
+			t.Fatalf("Import %s: %v", format, errImport)
+		}
+		if report.Bindings != 1 || report.Banned != 1 || report.APIKeysAdded != 1 || report.Groups != 1 {
+			t.Fatalf("%s report = %+v", format, report)
+		}
+		if mismatches, _ := device.VerifyMigration(src, dst); len(mismatches) > 0 {
+			t.Fatalf("%s bindings differ: %v", format, mismatches)
+		}
+		if got := len(dstCfg.APIKeys); got != 3 {
+			t.Fatalf("%s api keys = %v", format, dstCfg.APIKeys)
+		}
+		if dstCfg.ClientLimits.Keys["sk-a"].RequestsPerMinute != 10 {
+			t.Fatalf("%s client limits = %v", format, dstCfg.ClientLimits.Keys)
+		}
+		groups := dstCfg.ClientLimits.Groups
+		if len(groups) != 2 || len(groups[0].APIKeys) != 2 || groups[1].Name != "other" {
+			t.Fatalf("%s groups = %+v", format, groups)
+		}
+	}
+}
+
+func TestImportReplaceAndDryRun(t *testing.T) {
+	store, _ := device.NewFileStore(t.TempDir())
+	_, _ = store.AddDeviceWithinLimit("sk-old", "dev-1", "desktop", "10.0.0.1", false, 10)
+	cfg := &config.Config{}
+	cfg.APIKeys = []string{"sk-old"}
+	cfg.Spend.Keys = map[string]float64{"sk-old": 5}
+	b := Bundle{Version: Version, Keys: Keys{APIKeys: []string{"sk-new"}}}
+
+	if _, err := Import(cfg, store, b, ModeReplace, true); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if _, ok := store.Get("sk-old"); !ok {
+		t.Fatal("dry run cleared the store")
+	}
+
+	if _, err := Import(cfg, store, b, ModeReplace, false); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if len(store.GetAll()) != 0 || len(cfg.APIKeys) != 1 || cfg.APIKeys[0] != "sk-new" || cfg.Spend.Keys != nil {
+		t.Fatalf("replace left state: bindings=%d keys=%v spend=%v", len(store.GetAll()), cfg.APIKeys, cfg.Spend.Keys)
+	}
+}
+
+func TestParseRejectsUnknownVersions(t *testing.T) {
+	if _, err := Parse([]byte(`{"keys":{}}`)); err == nil {
+		t.Fatal("bundle without version accepted")
+	}
+	if _, err := Parse([]byte("version: 99\n")); err == nil {
+		t.Fatal("newer bundle version accepted")
+	}
+}
@@ -0,0 +1,105 @@
+// Package byok implements bring-your-own-key passthrough. Client keys in BYOK
+// mode send their own upstream provider key in a header; the middleware moves it
+// out of the request into the gin context, where the auth manager picks it up
+// and uses it instead of the shared credential.
+package byok
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	coreauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+)
+
+// DefaultHeader carries the client's upstream key when none is configured.
+const DefaultHeader = "X-Upstream-Api-Key"
+
+var requests = metrics.Default().NewCounterVec(
+	"cliproxy_byok_requests_total",
+	"Requests from bring-your-own-key clients, by outcome (forwarded or missing_key).",
+	"outcome",
+)
+
+// BYOK decides which client keys supply their own upstream key.
+type BYOK struct {
+	mu      sync.RWMutex
+	enabled bool
+	header  string
+	keys    map[string]struct{}
+}
+
+// New creates BYOK passthrough from configuration.
+func New(cfg config.BYOKConfig) *BYOK {
+	b := &BYOK{}
+	b.Update(cfg)
+	return b
+}
+
+// Update replaces the configuration.
+func (b *BYOK) Update(cfg config.BYOKConfig) {
+	header := strings.TrimSpace(cfg.Header)
+	if header == "" {
+		header = DefaultHeader
+	}
+	keys := make(map[string]struct{}, len(cfg.Keys))
+	for _, key := range cfg.Keys {
+		if key = strings.TrimSpace(key); key != "" {
+			keys[key] = struct{}{}
+		}
+	}
+	b.mu.Lock()
+	b.enabled = cfg.Enabled
+	b.header = header
+	b.keys = keys
+	b.mu.Unlock()
+}
+
+// IsBYOK reports whether a client key is in bring-your-own-key mode.
+func (b *BYOK) IsBYOK(apiKey string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.keys[apiKey]
+	return b.enabled && ok
+}
+
+func (b *BYOK) state() (bool, string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.enabled, b.header
+}
+
+// Middleware strips the upstream key header from every request and, for keys in
+// BYOK mode, hands it to the auth manager. BYOK keys without the header are rejected.
+func (b *BYOK) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		enabled, header := b.state()
+		if !enabled {
+			c.Next()
+			return
+		}
+		upstreamKey := strings.TrimSpace(c.GetHeader(header))
+		c.Request.Header.Del(header)
+		if !b.IsBYOK(c.GetString("apiKey")) {
+			c.Next()
+			return
+		}
+		if scheme, value, found := strings.Cut(upstreamKey, " "); found && strings.EqualFold(scheme, "bearer") {
+			upstreamKey = strings.TrimSpace(value)
+		}
+		if upstreamKey == "" {
+			requests.Inc("missing_key")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error":   "upstream_key_required",
+				"message": "This API key is in bring-your-own-key mode; send your provider API key in the " + header + " header",
+			})
+			return
+		}
+		requests.Inc("forwarded")
+		c.Set(coreauth.BYOKContextKey, upstreamKey)
+		c.Next()
+	}
+}
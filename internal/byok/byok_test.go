@@ -0,0 +1,44 @@
+package byok
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	coreauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+)
+
+func TestMiddlewareForwardsAndStripsUpstreamKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	b := New(config.BYOKConfig{Enabled: true, Keys: []string{"byok-key"}})
+	engine := gin.New()
+	engine.POST("/v1/messages", func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("Authorization"))
+		c.Next()
+	}, b.Middleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(coreauth.BYOKContextKey)+"|"+c.GetHeader(DefaultHeader))
+	})
+
+	call := func(apiKey, upstream string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.Header.Set("Authorization", apiKey)
+		if upstream != "" {
+			req.Header.Set(DefaultHeader, upstream)
+		}
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := call("byok-key", "Bearer sk-own"); rec.Code != http.StatusOK || rec.Body.String() != "sk-own|" {
+		t.Fatalf("expected client key to be forwarded and stripped, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := call("byok-key", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without upstream key, got %d", rec.Code)
+	}
+	if rec := call("shared-key", "sk-own"); rec.Code != http.StatusOK || rec.Body.String() != "|" {
+		t.Fatalf("expected header to be ignored for shared keys, got %d %q", rec.Code, rec.Body.String())
+	}
+}
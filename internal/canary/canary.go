@@ -0,0 +1,326 @@
+// Package canary stages hot-reloaded configurations on a set of canary keys.
+// While a rollout soaks, requests of those keys are marked so the server runs
+// them against the new configuration; their 5xx rate is compared with the rate
+// of every other key, and a rising rate rolls the configuration back before it
+// reaches all traffic.
+package canary
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults applied when the configuration leaves a field unset
+const (
+	DefaultSoak                 = 10 * time.Minute
+	DefaultMinRequests          = 20
+	DefaultMaxErrorRateIncrease = 0.05
+)
+
+// Rollout outcomes
+const (
+	OutcomePromoted   = "promoted"
+	OutcomeRolledBack = "rolled_back"
+	OutcomeReplaced   = "replaced"
+)
+
+// contextKey marks a request of a canary key during a rollout.
+const contextKey = "canary"
+
+var (
+	rollouts = metrics.Default().NewCounterVec(
+		"cliproxy_canary_rollouts_total",
+		"Canary config rollouts, by outcome.",
+		"outcome",
+	)
+	requests = metrics.Default().NewCounterVec(
+		"cliproxy_canary_requests_total",
+		"Requests during canary rollouts, by group (canary or baseline) and result (ok or error).",
+		"group", "result",
+	)
+)
+
+// Counts are the requests and 5xx errors of one group during a rollout.
+type Counts struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+}
+
+// Rate is the error rate, or 0 without requests.
+func (c Counts) Rate() float64 {
+	if c.Requests == 0 {
+		return 0
+	}
+	return float64(c.Errors) / float64(c.Requests)
+}
+
+// Status describes the rollout in progress, if any.
+type Status struct {
+	Enabled   bool       `json:"enabled"`
+	Keys      int        `json:"canary_keys"`
+	Active    bool       `json:"active"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	PromoteAt *time.Time `json:"promote_at,omitempty"`
+	Canary    Counts     `json:"canary"`
+	Baseline  Counts     `json:"baseline"`
+	// LastOutcome and LastReason describe how the previous rollout ended.
+	LastOutcome string `json:"last_outcome,omitempty"`
+	LastReason  string `json:"last_reason,omitempty"`
+}
+
+type rollout struct {
+	startedAt time.Time
+	promoteAt time.Time
+	timer     *time.Timer
+	canary    Counts
+	baseline  Counts
+	promote   func()
+	rollback  func()
+}
+
+// Controller runs at most one rollout at a time.
+type Controller struct {
+	mu          sync.Mutex
+	enabled     bool
+	keys        map[string]struct{}
+	soak        time.Duration
+	minRequests int64
+	maxIncrease float64
+
+	active      *rollout
+	lastOutcome string
+	lastReason  string
+	now         func() time.Time
+}
+
+// New creates a controller from configuration.
+func New(cfg config.CanaryConfig) *Controller {
+	c := &Controller{now: time.Now}
+	c.Update(cfg)
+	return c
+}
+
+// Update replaces the configuration. A rollout in progress keeps running with
+// the new thresholds.
+func (c *Controller) Update(cfg config.CanaryConfig) {
+	keys := make(map[string]struct{}, len(cfg.APIKeys))
+	for _, key := range cfg.APIKeys {
+		if key = strings.TrimSpace(key); key != "" {
+			keys[key] = struct{}{}
+		}
+	}
+	soak := time.Duration(cfg.SoakSeconds) * time.Second
+	if soak <= 0 {
+		soak = DefaultSoak
+	}
+	minRequests := int64(cfg.MinRequests)
+	if minRequests <= 0 {
+		minRequests = DefaultMinRequests
+	}
+	maxIncrease := cfg.MaxErrorRateIncrease
+	if maxIncrease <= 0 {
+		maxIncrease = DefaultMaxErrorRateIncrease
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enabled = cfg.Enabled
+	c.keys = keys
+	c.soak = soak
+	c.minRequests = minRequests
+	c.maxIncrease = maxIncrease
+}
+
+// Enabled reports whether reloads should be staged, which needs canary keys.
+func (c *Controller) Enabled() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enabled && len(c.keys) > 0
+}
+
+// Start begins a rollout. promote runs when the soak period ends and rollback
+// when the canary error rate rises; each runs on its own goroutine at most
+// once. A rollout already in progress is abandoned without either callback.
+func (c *Controller) Start(promote, rollback func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active != nil {
+		c.endLocked(OutcomeReplaced, "superseded by a newer config reload", "system")
+	}
+	now := c.now()
+	r := &rollout{startedAt: now, promoteAt: now.Add(c.soak), promote: promote, rollback: rollback}
+	r.timer = time.AfterFunc(c.soak, func() { c.finish(r, OutcomePromoted, "soak period passed", "system") })
+	c.active = r
+	log.Infof("canary: staged config on %d canary keys, promoting at %s", len(c.keys), r.promoteAt.Format(time.RFC3339))
+}
+
+// Cancel abandons the rollout in progress without promoting or rolling back.
+func (c *Controller) Cancel(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active != nil {
+		c.endLocked(OutcomeReplaced, reason, "system")
+	}
+}
+
+// Promote ends the rollout in progress early by promoting it. It reports
+// whether a rollout was in progress.
+func (c *Controller) Promote(actor string) bool {
+	c.mu.Lock()
+	r := c.active
+	c.mu.Unlock()
+	return r != nil && c.finish(r, OutcomePromoted, "promoted manually", actor)
+}
+
+// Rollback ends the rollout in progress early by rolling it back. It reports
+// whether a rollout was in progress.
+func (c *Controller) Rollback(actor string) bool {
+	c.mu.Lock()
+	r := c.active
+	c.mu.Unlock()
+	return r != nil && c.finish(r, OutcomeRolledBack, "rolled back manually", actor)
+}
+
+// Status returns the rollout in progress.
+func (c *Controller) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := Status{
+		Enabled:     c.enabled,
+		Keys:        len(c.keys),
+		LastOutcome: c.lastOutcome,
+		LastReason:  c.lastReason,
+	}
+	if r := c.active; r != nil {
+		startedAt, promoteAt := r.startedAt, r.promoteAt
+		status.Active = true
+		status.StartedAt = &startedAt
+		status.PromoteAt = &promoteAt
+		status.Canary = r.canary
+		status.Baseline = r.baseline
+	}
+	return status
+}
+
+// Middleware marks requests of canary keys while a rollout is in progress and
+// counts the outcome of every request towards the rollout. It must run after
+// authentication so the client key is known.
+func (c *Controller) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		c.mu.Lock()
+		r := c.active
+		_, isCanary := c.keys[ctx.GetString("apiKey")]
+		c.mu.Unlock()
+		if r == nil {
+			ctx.Next()
+			return
+		}
+		if isCanary {
+			ctx.Set(contextKey, true)
+		}
+		ctx.Next()
+		c.record(r, isCanary, ctx.Writer.Status() >= http.StatusInternalServerError)
+	}
+}
+
+// Active reports whether the request should run against the staged configuration.
+func Active(ctx *gin.Context) bool {
+	return ctx.GetBool(contextKey)
+}
+
+// record counts one request and rolls back when the canary error rate has
+// risen too far above the baseline.
+func (c *Controller) record(r *rollout, isCanary, failed bool) {
+	group, result := "baseline", "ok"
+	if isCanary {
+		group = "canary"
+	}
+	if failed {
+		result = "error"
+	}
+	requests.Inc(group, result)
+
+	c.mu.Lock()
+	if c.active != r {
+		c.mu.Unlock()
+		return
+	}
+	counts := &r.baseline
+	if isCanary {
+		counts = &r.canary
+	}
+	counts.Requests++
+	if failed {
+		counts.Errors++
+	}
+	regressed := isCanary && failed && r.canary.Requests >= c.minRequests &&
+		r.canary.Rate() > r.baseline.Rate()+c.maxIncrease
+	canaryRate, baselineRate := r.canary.Rate(), r.baseline.Rate()
+	c.mu.Unlock()
+
+	if regressed {
+		c.finish(r, OutcomeRolledBack,
+			fmt.Sprintf("canary error rate %.1f%% exceeds baseline %.1f%%", canaryRate*100, baselineRate*100), "system")
+	}
+}
+
+// finish ends r with outcome unless it already ended, and runs its callback.
+func (c *Controller) finish(r *rollout, outcome, reason, actor string) bool {
+	c.mu.Lock()
+	if c.active != r {
+		c.mu.Unlock()
+		return false
+	}
+	c.endLocked(outcome, reason, actor)
+	c.mu.Unlock()
+
+	switch outcome {
+	case OutcomePromoted:
+		if r.promote != nil {
+			go r.promote()
+		}
+	case OutcomeRolledBack:
+		if r.rollback != nil {
+			go r.rollback()
+		}
+	}
+	return true
+}
+
+// endLocked clears the active rollout. Callers must hold c.mu.
+func (c *Controller) endLocked(outcome, reason, actor string) {
+	r := c.active
+	r.timer.Stop()
+	c.active = nil
+	c.lastOutcome, c.lastReason = outcome, reason
+	rollouts.Inc(outcome)
+
+	data := map[string]any{
+		"canary_requests":   r.canary.Requests,
+		"canary_errors":     r.canary.Errors,
+		"baseline_requests": r.baseline.Requests,
+		"baseline_errors":   r.baseline.Errors,
+	}
+	switch outcome {
+	case OutcomePromoted:
+		log.Infof("canary: config promoted to all traffic (%s)", reason)
+		events.Publish(events.Event{Type: events.TypeCanaryPromoted, Reason: reason, Actor: actor, Data: data})
+	case OutcomeRolledBack:
+		log.Warnf("canary: config rolled back (%s)", reason)
+		events.Publish(events.Event{Type: events.TypeCanaryRolledBack, Reason: reason, Actor: actor, Data: data})
+	default:
+		log.Infof("canary: rollout abandoned (%s)", reason)
+	}
+}
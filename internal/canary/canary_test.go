@@ -0,0 +1,110 @@
+package canary
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func newEngine(c *Controller, status map[string]int, seen map[string]bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(ctx *gin.Context) {
+		ctx.Set("apiKey", ctx.GetHeader("X-Key"))
+		ctx.Next()
+	})
+	engine.Use(c.Middleware())
+	engine.GET("/", func(ctx *gin.Context) {
+		key := ctx.GetString("apiKey")
+		seen[key] = Active(ctx)
+		ctx.Status(status[key])
+	})
+	return engine
+}
+
+func send(engine *gin.Engine, key string) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Key", key)
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestRollsBackWhenCanaryErrorsRise(t *testing.T) {
+	c := New(config.CanaryConfig{Enabled: true, APIKeys: []string{"canary"}, MinRequests: 5, SoakSeconds: 3600})
+	status := map[string]int{"canary": http.StatusBadGateway, "other": http.StatusOK}
+	seen := map[string]bool{}
+	engine := newEngine(c, status, seen)
+
+	send(engine, "canary")
+	if seen["canary"] {
+		t.Fatal("request marked as canary without a rollout")
+	}
+
+	rolledBack := make(chan struct{})
+	c.Start(func() { t.Error("promoted a failing rollout") }, func() { close(rolledBack) })
+	for i := 0; i < 10; i++ {
+		send(engine, "other")
+	}
+	for i := 0; i < 4; i++ {
+		send(engine, "canary")
+	}
+	if !seen["canary"] || seen["other"] {
+		t.Fatalf("canary marks = %v", seen)
+	}
+	if !c.Status().Active {
+		t.Fatal("rolled back before min-requests canary requests")
+	}
+	send(engine, "canary")
+
+	select {
+	case <-rolledBack:
+	case <-time.After(time.Second):
+		t.Fatal("rollback not called")
+	}
+	got := c.Status()
+	if got.Active || got.LastOutcome != OutcomeRolledBack {
+		t.Fatalf("status = %+v", got)
+	}
+}
+
+func TestHealthyRolloutPromotes(t *testing.T) {
+	c := New(config.CanaryConfig{Enabled: true, APIKeys: []string{"canary"}, MinRequests: 2, SoakSeconds: 3600})
+	status := map[string]int{"canary": http.StatusOK, "other": http.StatusBadGateway}
+	engine := newEngine(c, status, map[string]bool{})
+
+	promoted := make(chan struct{})
+	c.Start(func() { close(promoted) }, func() { t.Error("rolled back a healthy rollout") })
+	for i := 0; i < 5; i++ {
+		send(engine, "canary")
+		send(engine, "other")
+	}
+	if got := c.Status(); got.Canary.Requests != 5 || got.Baseline.Errors != 5 {
+		t.Fatalf("counts = %+v / %+v", got.Canary, got.Baseline)
+	}
+	if !c.Promote("test") {
+		t.Fatal("no rollout to promote")
+	}
+	select {
+	case <-promoted:
+	case <-time.After(time.Second):
+		t.Fatal("promote not called")
+	}
+	if c.Promote("test") || c.Rollback("test") {
+		t.Fatal("ended rollout can be ended again")
+	}
+}
+
+func TestStartReplacesRolloutWithoutCallbacks(t *testing.T) {
+	c := New(config.CanaryConfig{Enabled: true, APIKeys: []string{"canary"}, SoakSeconds: 3600})
+	c.Start(func() { t.Error("replaced rollout promoted") }, func() { t.Error("replaced rollout rolled back") })
+	promoted := make(chan struct{})
+	c.Start(func() { close(promoted) }, nil)
+	if got := c.Status().LastOutcome; got != OutcomeReplaced {
+		t.Fatalf("last outcome = %q", got)
+	}
+	c.Promote("test")
+	<-promoted
+}
@@ -0,0 +1,127 @@
+// Package cmd contains CLI helpers. This file implements copying device
+// bindings, bans and key usage between device binding store backends.
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	log "github.com/sirupsen/logrus"
+)
+
+// MigrateStoreCommand is the positional command name selecting DoMigrateStore.
+const MigrateStoreCommand = "migrate-store"
+
+// DoMigrateStore copies all device binding data between two store backends,
+// e.g. `migrate-store -from yaml -to sqlite`. Each side defaults to the store
+// settings in cfg when it uses the configured backend. After the copy the
+// target is verified against the source. It returns an error when the
+// migration failed or the target does not match, so callers can exit non-zero.
+func DoMigrateStore(cfg *config.Config, args []string) error {
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+	fs := flag.NewFlagSet(MigrateStoreCommand, flag.ContinueOnError)
+	from := fs.String("from", "", "Source backend: yaml, sqlite or postgres")
+	to := fs.String("to", "", "Target backend: yaml, sqlite or postgres")
+	fromPath := fs.String("from-path", "", "Source SQLite database file")
+	toPath := fs.String("to-path", "", "Target SQLite database file")
+	fromDSN := fs.String("from-dsn", "", "Source PostgreSQL connection string")
+	toDSN := fs.String("to-dsn", "", "Target PostgreSQL connection string")
+	dir := fs.String("dir", ".", "Directory holding device-bindings.yaml and the default SQLite database")
+	dryRun := fs.Bool("dry-run", false, "Report what would be copied without writing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*from) == "" || strings.TrimSpace(*to) == "" {
+		fs.Usage()
+		return fmt.Errorf("migrate-store: -from and -to are required")
+	}
+
+	srcCfg := migrateStoreConfig(cfg, *from, *fromPath, *fromDSN, *dir)
+	dstCfg := migrateStoreConfig(cfg, *to, *toPath, *toDSN, *dir)
+	if storeLocation(srcCfg) == storeLocation(dstCfg) {
+		return fmt.Errorf("migrate-store: source and target are the same store")
+	}
+	src, err := device.OpenStore(srcCfg)
+	if err != nil {
+		return fmt.Errorf("migrate-store: open source: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+	dst, err := device.OpenStore(dstCfg)
+	if err != nil {
+		return fmt.Errorf("migrate-store: open target: %w", err)
+	}
+	defer func() { _ = dst.Close() }()
+
+	report, err := device.Migrate(src, dst, *dryRun)
+	if err != nil {
+		return fmt.Errorf("migrate-store: %w", err)
+	}
+	action := "Copied"
+	if *dryRun {
+		action = "Would copy"
+	}
+	fmt.Printf("%s %d bindings (%d devices, %d banned keys, %d ban history entries) and %d of %d key usage rows from %s to %s\n",
+		action, report.Bindings, report.Devices, report.Banned, report.History, report.UsageCopied, report.UsageRows, srcCfg.Backend, dstCfg.Backend)
+	if *dryRun {
+		return nil
+	}
+	if len(report.Mismatches) > 0 {
+		for _, mismatch := range report.Mismatches {
+			log.Errorf("migrate-store: %s", mismatch)
+		}
+		return fmt.Errorf("migrate-store: verification found %d mismatches", len(report.Mismatches))
+	}
+	fmt.Println("Verification passed. Set device-binding.store.backend to", dstCfg.Backend, "and restart.")
+	return nil
+}
+
+// migrateStoreConfig builds the store configuration of one side of a migration.
+// Path and DSN fall back to the configured store when the backend matches it.
+func migrateStoreConfig(cfg *config.Config, backend, path, dsn, dir string) device.StoreConfig {
+	backend = strings.ToLower(strings.TrimSpace(backend))
+	if backend == "postgresql" {
+		backend = device.BackendPostgres
+	}
+	configured := cfg.DeviceBinding.Store
+	configuredBackend := strings.ToLower(strings.TrimSpace(configured.Backend))
+	if configuredBackend == "" {
+		configuredBackend = device.BackendYAML
+	}
+	storeCfg := device.StoreConfig{
+		Backend: backend,
+		Dir:     dir,
+		Path:    strings.TrimSpace(path),
+		Postgres: device.PostgresConfig{
+			DSN:             strings.TrimSpace(dsn),
+			MaxOpenConns:    configured.MaxOpenConns,
+			MaxIdleConns:    configured.MaxIdleConns,
+			ConnMaxLifetime: time.Duration(configured.ConnMaxLifetime) * time.Second,
+		},
+	}
+	if backend == configuredBackend || (backend == device.BackendPostgres && configuredBackend == "postgresql") {
+		if storeCfg.Path == "" {
+			storeCfg.Path = configured.Path
+		}
+		if storeCfg.Postgres.DSN == "" {
+			storeCfg.Postgres.DSN = configured.DSN
+		}
+	}
+	return storeCfg
+}
+
+// storeLocation identifies the data a store configuration points at.
+func storeLocation(cfg device.StoreConfig) string {
+	switch cfg.Backend {
+	case device.BackendSQLite:
+		return cfg.Backend + ":" + cfg.Path
+	case device.BackendPostgres:
+		return cfg.Backend + ":" + cfg.Postgres.DSN
+	}
+	return cfg.Backend + ":" + cfg.Dir
+}
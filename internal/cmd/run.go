@@ -32,6 +32,9 @@ func StartService(cfg *config.Config, configPath string, localPassword string) {
 
 	ctxSignal, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
+	// Restore default signal handling once shutdown starts, so a second
+	// signal exits immediately instead of waiting for the drain.
+	context.AfterFunc(ctxSignal, cancel)
 
 	runCtx := ctxSignal
 	if localPassword != "" {
@@ -0,0 +1,502 @@
+// Package companion serves the local agent protocol of desktop companion
+// (tray) apps: a small authenticated WebSocket through which a helper running
+// next to the user's tools registers the device with device binding, sends
+// heartbeats and receives ban, device and quota notifications to display.
+// It makes device binding workable for users who never see proxy responses.
+package companion
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/limits"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHeartbeatInterval   = 30 * time.Second
+	defaultQuotaWarningPercent = 80
+
+	helloTimeout    = 10 * time.Second
+	writeTimeout    = 10 * time.Second
+	maxMessageBytes = 16 << 10
+	sendBuffer      = 16
+	// maxAgentsPerKey bounds the connections one API key may hold open.
+	maxAgentsPerKey = 16
+	maxLabelLength  = 128
+)
+
+var agentConnections = metrics.Default().NewCounterVec(
+	"cliproxy_companion_connections_total",
+	"Companion agent connections by outcome.",
+	"outcome",
+)
+
+// settings are the normalized configuration.
+type settings struct {
+	enabled      bool
+	heartbeat    time.Duration
+	warnFraction float64
+}
+
+func settingsFrom(cfg config.CompanionConfig) settings {
+	s := settings{
+		enabled:      cfg.Enabled,
+		heartbeat:    time.Duration(cfg.HeartbeatInterval) * time.Second,
+		warnFraction: float64(cfg.QuotaWarningPercent) / 100,
+	}
+	if s.heartbeat <= 0 {
+		s.heartbeat = defaultHeartbeatInterval
+	}
+	if cfg.QuotaWarningPercent <= 0 || cfg.QuotaWarningPercent > 100 {
+		s.warnFraction = defaultQuotaWarningPercent / 100.0
+	}
+	return s
+}
+
+// AgentInfo describes a connected agent.
+type AgentInfo struct {
+	APIKey       string    `json:"api_key"`
+	DeviceID     string    `json:"device_id,omitempty"`
+	DeviceStatus string    `json:"device_status"`
+	Name         string    `json:"name,omitempty"`
+	Platform     string    `json:"platform,omitempty"`
+	Version      string    `json:"version,omitempty"`
+	IP           string    `json:"ip"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// Hub accepts agent connections and fans notifications out to them.
+type Hub struct {
+	devices *device.Middleware
+	store   device.Store
+	limiter *limits.Limiter
+
+	mu          sync.RWMutex
+	settings    settings
+	agents      map[*agent]struct{}
+	upgrader    websocket.Upgrader
+	unsubscribe func()
+}
+
+// New creates a hub. devices, store and limiter may be nil; the agent then
+// gets no registration, standing or quota information respectively.
+func New(cfg config.CompanionConfig, devices *device.Middleware, store device.Store, limiter *limits.Limiter) *Hub {
+	h := &Hub{
+		devices:  devices,
+		store:    store,
+		limiter:  limiter,
+		settings: settingsFrom(cfg),
+		agents:   make(map[*agent]struct{}),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			// Agents are native apps authenticated by API key, not browser pages.
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+	}
+	h.unsubscribe = events.Subscribe(h.notify)
+	return h
+}
+
+// Update applies a reloaded configuration. Disabling the protocol closes the
+// open connections.
+func (h *Hub) Update(cfg config.CompanionConfig) {
+	h.mu.Lock()
+	h.settings = settingsFrom(cfg)
+	var closing []*agent
+	if !cfg.Enabled {
+		for a := range h.agents {
+			closing = append(closing, a)
+		}
+	}
+	h.mu.Unlock()
+	for _, a := range closing {
+		a.close()
+	}
+}
+
+// Close closes every connection and stops receiving events.
+func (h *Hub) Close() {
+	h.unsubscribe()
+	h.mu.Lock()
+	closing := make([]*agent, 0, len(h.agents))
+	for a := range h.agents {
+		closing = append(closing, a)
+	}
+	h.mu.Unlock()
+	for _, a := range closing {
+		a.close()
+	}
+}
+
+// Agents lists the connected agents.
+func (h *Hub) Agents() []AgentInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]AgentInfo, 0, len(h.agents))
+	for a := range h.agents {
+		out = append(out, a.info())
+	}
+	return out
+}
+
+func (h *Hub) current() settings {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.settings
+}
+
+// Handler upgrades authenticated requests to agent connections. It answers
+// 404 while the protocol is disabled.
+// GET /v0/device/agent
+func (h *Hub) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := h.current()
+		if !cfg.enabled {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "message": "companion agents are disabled"})
+			return
+		}
+		apiKey := c.GetString("apiKey")
+		if apiKey == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized", "message": "A valid API key is required"})
+			return
+		}
+		if h.count(apiKey) >= maxAgentsPerKey {
+			agentConnections.Inc("rejected_too_many")
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too_many_agents", "message": "Too many companion agents are connected with this API key"})
+			return
+		}
+		conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.Debugf("companion: upgrade failed: %v", err)
+			return
+		}
+		conn.SetReadLimit(maxMessageBytes)
+		h.serve(conn, apiKey, c.ClientIP(), cfg)
+	}
+}
+
+func (h *Hub) count(apiKey string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n := 0
+	for a := range h.agents {
+		if a.apiKey == apiKey {
+			n++
+		}
+	}
+	return n
+}
+
+// serve runs one connection: the hello handshake, then heartbeats until the
+// agent leaves or falls silent.
+func (h *Hub) serve(conn *websocket.Conn, apiKey, ip string, cfg settings) {
+	defer func() { _ = conn.Close() }()
+
+	var hello Hello
+	_ = conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	if err := conn.ReadJSON(&hello); err != nil || hello.Type != MessageHello {
+		agentConnections.Inc("rejected_protocol")
+		writeDirect(conn, Error{Type: MessageError, Code: "hello_required", Message: "The first message must be a hello", Fatal: true})
+		return
+	}
+
+	a := &agent{
+		hub:         h,
+		conn:        conn,
+		apiKey:      apiKey,
+		ip:          ip,
+		name:        truncate(hello.Name),
+		platform:    truncate(hello.Platform),
+		version:     truncate(hello.Version),
+		connectedAt: time.Now(),
+		send:        make(chan []byte, sendBuffer),
+		done:        make(chan struct{}),
+	}
+	registered, err := h.devices.IdentifyCompanion(apiKey, hello.DeviceID, hello.DeviceToken, ip)
+	switch {
+	case errors.Is(err, device.ErrCompanionBanned), errors.Is(err, device.ErrCompanionDeviceBanned):
+		// Stay connected so the agent can show the ban and hear about an unban.
+		a.deviceID, a.status = registered.ID, StatusUnregistered
+	case err != nil:
+		agentConnections.Inc("rejected_registration")
+		writeDirect(conn, registrationError(err))
+		return
+	default:
+		a.deviceID, a.status = registered.ID, registered.Status
+	}
+	a.lastSeen = a.connectedAt
+
+	h.mu.Lock()
+	h.agents[a] = struct{}{}
+	h.mu.Unlock()
+	agentConnections.Inc("accepted")
+	defer func() {
+		h.mu.Lock()
+		delete(h.agents, a)
+		h.mu.Unlock()
+		a.close()
+	}()
+	go a.writeLoop()
+
+	a.enqueue(Welcome{
+		Type:              MessageWelcome,
+		DeviceID:          a.deviceID,
+		DeviceToken:       registered.Token,
+		Registered:        registered.New,
+		HeartbeatInterval: int(cfg.heartbeat / time.Second),
+		State:             a.state(),
+	})
+	a.checkQuota()
+	a.readLoop()
+}
+
+func registrationError(err error) Error {
+	e := Error{Type: MessageError, Fatal: true}
+	switch {
+	case errors.Is(err, device.ErrCompanionToken):
+		e.Code, e.Message = "invalid_device_token", "The device token is missing or invalid for this API key. Remove the device in the admin panel to register it again."
+	case errors.Is(err, device.ErrCompanionDeviceLimit):
+		e.Code, e.Message = "device_limit_exceeded", "This API key is already bound to the maximum number of devices. Contact admin to remove an existing device."
+	case errors.Is(err, device.ErrCompanionAttestation):
+		e.Code, e.Message = "attestation_required", "This API key only accepts attested devices."
+	default:
+		log.Errorf("companion: registration failed: %v", err)
+		e.Code, e.Message = "internal_error", "Failed to register device"
+	}
+	return e
+}
+
+// notify forwards events about an agent's key to the agent.
+func (h *Hub) notify(ev events.Event) {
+	if ev.APIKey == "" {
+		return
+	}
+	n, ok := notificationFor(ev)
+	if !ok {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for a := range h.agents {
+		if a.apiKey != ev.APIKey {
+			continue
+		}
+		// Device events about another device of the key are not this user's business,
+		// unless the ban targets the agent's address.
+		if ev.DeviceID != "" && ev.DeviceID != a.deviceID {
+			continue
+		}
+		if ev.DeviceID == "" && ev.IP != "" && ev.IP != a.ip && ev.Type == events.TypeDeviceBanned {
+			continue
+		}
+		a.enqueue(n)
+	}
+}
+
+// writeDirect writes a message before the write loop runs.
+func writeDirect(conn *websocket.Conn, msg any) {
+	_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_ = conn.WriteJSON(msg)
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, ""), time.Now().Add(writeTimeout))
+}
+
+func truncate(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > maxLabelLength {
+		return s[:maxLabelLength]
+	}
+	return s
+}
+
+// agent is one connected companion app.
+type agent struct {
+	hub         *Hub
+	conn        *websocket.Conn
+	apiKey      string
+	ip          string
+	name        string
+	platform    string
+	version     string
+	connectedAt time.Time
+
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu         sync.Mutex
+	deviceID   string
+	status     string
+	lastSeen   time.Time
+	quotaLevel int
+}
+
+func (a *agent) info() AgentInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return AgentInfo{
+		APIKey:       a.apiKey,
+		DeviceID:     a.deviceID,
+		DeviceStatus: a.status,
+		Name:         a.name,
+		Platform:     a.platform,
+		Version:      a.version,
+		IP:           a.ip,
+		ConnectedAt:  a.connectedAt,
+		LastSeen:     a.lastSeen,
+	}
+}
+
+func (a *agent) close() {
+	a.closeOnce.Do(func() {
+		close(a.done)
+		_ = a.conn.Close()
+	})
+}
+
+// enqueue queues a message, dropping it when the agent does not keep up.
+func (a *agent) enqueue(msg any) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	select {
+	case a.send <- data:
+	case <-a.done:
+	default:
+		log.Debugf("companion: dropped message for slow agent of key %s", device.MaskKey(a.apiKey))
+	}
+}
+
+func (a *agent) writeLoop() {
+	for {
+		select {
+		case <-a.done:
+			return
+		case data := <-a.send:
+			_ = a.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := a.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				a.close()
+				return
+			}
+		}
+	}
+}
+
+func (a *agent) readLoop() {
+	for {
+		heartbeat := a.hub.current().heartbeat
+		_ = a.conn.SetReadDeadline(time.Now().Add(3 * heartbeat))
+		var msg struct {
+			Type string `json:"type"`
+		}
+		if err := a.conn.ReadJSON(&msg); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				a.enqueue(Error{Type: MessageError, Code: "invalid_message", Message: "Messages must be JSON objects"})
+				continue
+			}
+			return
+		}
+		switch msg.Type {
+		case MessageHeartbeat:
+			a.heartbeat()
+		default:
+			a.enqueue(Error{Type: MessageError, Code: "unknown_type", Message: "Unknown message type " + truncate(msg.Type)})
+		}
+	}
+}
+
+func (a *agent) heartbeat() {
+	a.mu.Lock()
+	a.lastSeen = time.Now()
+	deviceID, status := a.deviceID, a.status
+	a.mu.Unlock()
+	if status != StatusUnregistered {
+		if err := a.hub.devices.CompanionHeartbeat(a.apiKey, deviceID, a.ip); err != nil {
+			log.Warnf("companion: heartbeat of device %s for key %s: %v", deviceID, device.MaskKey(a.apiKey), err)
+		}
+	}
+	a.enqueue(HeartbeatAck{Type: MessageHeartbeatAck, State: a.state()})
+	a.checkQuota()
+}
+
+// state reads the current standing of the agent's key and device.
+func (a *agent) state() State {
+	a.mu.Lock()
+	deviceID, status := a.deviceID, a.status
+	a.mu.Unlock()
+	st := State{DeviceID: deviceID, DeviceStatus: status}
+	if store := a.hub.store; store != nil {
+		now := time.Now()
+		binding, _ := store.Get(a.apiKey)
+		if binding.Banned && !binding.BanExpired(now) {
+			st.Banned, st.BanReason = true, binding.BanReason
+			if !binding.BanExpiresAt.IsZero() {
+				expiresAt := binding.BanExpiresAt
+				st.BanExpiresAt = &expiresAt
+			}
+		}
+		if _, banned := binding.MatchDeviceBan(deviceID, a.ip, now); banned {
+			st.DeviceBanned = true
+		}
+		if status != device.CompanionUnbound && status != StatusUnregistered {
+			switch idx := binding.FindDevice(deviceID); {
+			case idx < 0:
+				st.DeviceStatus = StatusUnregistered
+			case binding.Devices[idx].Pending:
+				st.DeviceStatus = device.CompanionPending
+			default:
+				st.DeviceStatus = device.CompanionActive
+			}
+			a.mu.Lock()
+			a.status = st.DeviceStatus
+			a.mu.Unlock()
+		}
+	}
+	if a.hub.limiter != nil {
+		if q, ok := a.hub.limiter.Quota(a.apiKey); ok {
+			st.Quota = &q
+		}
+	}
+	return st
+}
+
+// checkQuota notifies the agent once per level when the key's daily quota
+// usage crosses the warning threshold or runs out. The level resets with the quota.
+func (a *agent) checkQuota() {
+	if a.hub.limiter == nil {
+		return
+	}
+	q, ok := a.hub.limiter.Quota(a.apiKey)
+	if !ok {
+		return
+	}
+	used := q.UsedFraction()
+	level := 0
+	switch {
+	case used >= 1:
+		level = 2
+	case used >= a.hub.current().warnFraction:
+		level = 1
+	}
+	a.mu.Lock()
+	previous := a.quotaLevel
+	a.quotaLevel = level
+	a.mu.Unlock()
+	if level > previous {
+		a.enqueue(quotaNotification(q, level == 2, time.Now()))
+	}
+}
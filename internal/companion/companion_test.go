@@ -0,0 +1,134 @@
+package companion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, device.Store) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store, err := device.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := device.NewMiddleware(store, device.Config{Enabled: true, MaxDevices: 1, TokenSecret: "secret"})
+	hub := New(config.CompanionConfig{Enabled: true}, mw, store, nil)
+	t.Cleanup(hub.Close)
+
+	engine := gin.New()
+	engine.GET("/v0/device/agent", func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("X-Test-Key"))
+		c.Next()
+	}, hub.Handler())
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv, store
+}
+
+func dial(t *testing.T, srv *httptest.Server, key string, hello Hello) (*websocket.Conn, map[string]any) {
+	t.Helper()
+	header := http.Header{"X-Test-Key": []string{key}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/v0/device/agent", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	hello.Type = MessageHello
+	if err = conn.WriteJSON(hello); err != nil {
+		t.Fatal(err)
+	}
+	return conn, read(t, conn)
+}
+
+func read(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg map[string]any
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestAgentRegistersAndReceivesBanNotifications(t *testing.T) {
+	srv, store := newTestServer(t)
+
+	conn, welcome := dial(t, srv, "key-1", Hello{DeviceID: "laptop", Name: "Tray"})
+	if welcome["type"] != MessageWelcome || welcome["registered"] != true || welcome["device_token"] == "" {
+		t.Fatalf("unexpected welcome %v", welcome)
+	}
+	if _, ok := store.Get("key-1"); !ok {
+		t.Fatal("the agent's device should be bound")
+	}
+
+	if err := conn.WriteJSON(map[string]string{"type": MessageHeartbeat}); err != nil {
+		t.Fatal(err)
+	}
+	if ack := read(t, conn); ack["type"] != MessageHeartbeatAck {
+		t.Fatalf("expected heartbeat_ack, got %v", ack)
+	}
+
+	events.Publish(events.Event{Type: events.TypeBan, APIKey: "key-2", Reason: "other key"})
+	events.Publish(events.Event{Type: events.TypeBan, APIKey: "key-1", Reason: "abuse"})
+	note := read(t, conn)
+	if note["type"] != MessageNotification || note["event"] != string(events.TypeBan) || note["message"] != "abuse" {
+		t.Fatalf("expected the ban of key-1 only, got %v", note)
+	}
+
+	// Reconnecting with the issued token identifies the same device
+	_, again := dial(t, srv, "key-1", Hello{DeviceToken: welcome["device_token"].(string)})
+	if again["type"] != MessageWelcome || again["registered"] != false || again["device_id"] != "laptop" {
+		t.Fatalf("unexpected welcome on reconnect %v", again)
+	}
+
+	// A known device without its token is refused
+	_, refused := dial(t, srv, "key-1", Hello{DeviceID: "laptop"})
+	if refused["type"] != MessageError || refused["code"] != "invalid_device_token" || refused["fatal"] != true {
+		t.Fatalf("expected invalid_device_token, got %v", refused)
+	}
+
+	// A second device exceeds the limit of one
+	_, limited := dial(t, srv, "key-1", Hello{DeviceID: "desktop"})
+	if limited["code"] != "device_limit_exceeded" {
+		t.Fatalf("expected device_limit_exceeded, got %v", limited)
+	}
+}
+
+func TestBannedDeviceIsNotIdentified(t *testing.T) {
+	srv, store := newTestServer(t)
+
+	_, welcome := dial(t, srv, "key-1", Hello{DeviceID: "laptop"})
+	token, _ := welcome["device_token"].(string)
+	if err := store.BanDevice("key-1", device.DeviceBan{DeviceID: "laptop", Reason: "stolen", BannedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, again := dial(t, srv, "key-1", Hello{DeviceToken: token})
+	state, _ := again["state"].(map[string]any)
+	if again["type"] != MessageWelcome || state["device_status"] != StatusUnregistered || state["device_banned"] != true {
+		t.Fatalf("expected the banned device to stay unregistered, got %v", again)
+	}
+}
+
+func TestDisabledHubAnswersNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hub := New(config.CompanionConfig{}, nil, nil, nil)
+	defer hub.Close()
+	engine := gin.New()
+	engine.GET("/v0/device/agent", hub.Handler())
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v0/device/agent", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,154 @@
+package companion
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/limits"
+)
+
+// Message types. Agents send hello first, then heartbeats; the proxy answers
+// hello with welcome and each heartbeat with heartbeat_ack, and pushes
+// notifications at any time. Errors with a fatal code are followed by close.
+const (
+	MessageHello        = "hello"
+	MessageHeartbeat    = "heartbeat"
+	MessageWelcome      = "welcome"
+	MessageHeartbeatAck = "heartbeat_ack"
+	MessageNotification = "notification"
+	MessageError        = "error"
+)
+
+// Notification levels, for the agent to pick an icon
+const (
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// Device statuses reported in State besides the device package ones
+const (
+	StatusUnregistered = "unregistered"
+)
+
+// Hello is the first message of an agent. DeviceToken proves a device that
+// registered before while signed device tokens are enabled; without tokens
+// DeviceID is the value the agent's user sends in the device ID header.
+type Hello struct {
+	Type        string `json:"type"`
+	DeviceID    string `json:"device_id,omitempty"`
+	DeviceToken string `json:"device_token,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Platform    string `json:"platform,omitempty"`
+	Version     string `json:"version,omitempty"`
+}
+
+// State is what the agent shows: the device and key standing and the daily quota.
+type State struct {
+	DeviceID     string        `json:"device_id,omitempty"`
+	DeviceStatus string        `json:"device_status"`
+	Banned       bool          `json:"banned"`
+	BanReason    string        `json:"ban_reason,omitempty"`
+	BanExpiresAt *time.Time    `json:"ban_expires_at,omitempty"`
+	DeviceBanned bool          `json:"device_banned,omitempty"`
+	Quota        *limits.Quota `json:"quota,omitempty"`
+}
+
+// Welcome answers hello. DeviceToken is only set when a token was issued to a
+// newly registered device; the agent stores it for later connections.
+type Welcome struct {
+	Type              string `json:"type"`
+	DeviceID          string `json:"device_id,omitempty"`
+	DeviceToken       string `json:"device_token,omitempty"`
+	Registered        bool   `json:"registered"`
+	HeartbeatInterval int    `json:"heartbeat_interval"`
+	State             State  `json:"state"`
+}
+
+// HeartbeatAck answers a heartbeat with the current state.
+type HeartbeatAck struct {
+	Type  string `json:"type"`
+	State State  `json:"state"`
+}
+
+// Notification is a message for the end user.
+type Notification struct {
+	Type    string    `json:"type"`
+	Event   string    `json:"event"`
+	Level   string    `json:"level"`
+	Title   string    `json:"title"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Error reports a protocol or registration problem. Fatal errors close the connection.
+type Error struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Fatal   bool   `json:"fatal"`
+}
+
+// Quota notification events
+const (
+	eventQuotaWarning   = "quota_warning"
+	eventQuotaExhausted = "quota_exhausted"
+)
+
+// notificationFor turns a domain event about the agent's key into a
+// notification, or returns false for events the end user need not see.
+func notificationFor(ev events.Event) (Notification, bool) {
+	n := Notification{Type: MessageNotification, Event: string(ev.Type), Level: LevelInfo, Message: ev.Reason, Time: ev.Time}
+	switch ev.Type {
+	case events.TypeBan:
+		n.Level, n.Title = LevelError, "Your API key has been banned"
+	case events.TypeUnban:
+		n.Title = "Your API key has been unbanned"
+	case events.TypeDeviceBanned:
+		n.Level, n.Title = LevelError, "This device has been blocked"
+	case events.TypeDeviceUnbanned:
+		n.Title = "This device has been unblocked"
+	case events.TypeDeviceApproved:
+		n.Title = "This device has been approved"
+	case events.TypeDevicePending:
+		n.Level, n.Title = LevelWarning, "This device is waiting for admin approval"
+	case events.TypeDeviceRejected:
+		n.Level, n.Title = LevelError, "This device could not be registered"
+	case events.TypeBoostGranted:
+		n.Title = "Your limits have been raised temporarily"
+		if multiplier, ok := ev.Data["multiplier"].(float64); ok {
+			n.Message = fmt.Sprintf("Limits are %gx until %v", multiplier, ev.Data["expires_at"])
+		}
+	case events.TypeBoostRevoked, events.TypeBoostExpired:
+		n.Title = "Your temporary limit boost has ended"
+	case events.TypeKeyRotated:
+		n.Level, n.Title = LevelWarning, "Your API key has been rotated"
+		if expiresAt, ok := ev.Data["expires_at"].(time.Time); ok {
+			n.Message = "The current key stops working at " + expiresAt.Format(time.RFC3339) + ". Ask your admin for the new key."
+		}
+	case events.TypeKeyRevoked:
+		n.Level, n.Title = LevelError, "Your API key has been revoked"
+	default:
+		return Notification{}, false
+	}
+	return n, true
+}
+
+// quotaNotification describes a quota threshold the key has crossed.
+func quotaNotification(q limits.Quota, exhausted bool, now time.Time) Notification {
+	n := Notification{Type: MessageNotification, Time: now}
+	percent := int(q.UsedFraction() * 100)
+	if exhausted {
+		n.Event, n.Level, n.Title = eventQuotaExhausted, LevelError, "Daily quota used up"
+		n.Message = "Requests are blocked until " + q.ResetAt.Format(time.RFC3339) + "."
+		if q.SoftQuota {
+			n.Level = LevelWarning
+			n.Message = "Further requests today are billed as overage."
+		}
+		return n
+	}
+	n.Event, n.Level, n.Title = eventQuotaWarning, LevelWarning, fmt.Sprintf("%d%% of the daily quota used", percent)
+	n.Message = "The quota resets at " + q.ResetAt.Format(time.RFC3339) + "."
+	return n
+}
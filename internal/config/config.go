@@ -11,6 +11,7 @@ import (
 	"os"
 	"strings"
 	"syscall"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/yaml.v3"
@@ -18,6 +19,9 @@ import (
 
 const DefaultPanelGitHubRepository = "https://github.com/router-for-me/Cli-Proxy-API-Management-Center"
 
+// DefaultShutdownDrainTimeout is the drain timeout, in seconds, used when shutdown-drain-timeout is unset.
+const DefaultShutdownDrainTimeout = 30
+
 // Config represents the application's configuration, loaded from a YAML file.
 type Config struct {
 	SDKConfig `yaml:",inline"`
@@ -30,6 +34,17 @@ type Config struct {
 	// TLS config controls HTTPS server settings.
 	TLS TLSConfig `yaml:"tls" json:"tls"`
 
+	// AdminListener serves the management API on its own address instead of the proxy listener.
+	AdminListener AdminListenerConfig `yaml:"admin-listener" json:"-"`
+
+	// ForwardProxy runs an HTTP CONNECT/SOCKS5 listener that intercepts traffic to upstream
+	// hosts such as api.anthropic.com for clients whose base URL cannot be changed.
+	ForwardProxy ForwardProxyConfig `yaml:"forward-proxy" json:"-"`
+
+	// ShutdownDrainTimeout is how long (in seconds) shutdown waits for in-flight requests and
+	// streams to finish before their connections are closed. Default: 30.
+	ShutdownDrainTimeout int `yaml:"shutdown-drain-timeout" json:"shutdown-drain-timeout"`
+
 	// RemoteManagement nests management-related options under 'remote-management'.
 	RemoteManagement RemoteManagement `yaml:"remote-management" json:"-"`
 
@@ -49,6 +64,13 @@ type Config struct {
 	// When exceeded, the oldest log files are deleted until within the limit. Set to 0 to disable.
 	LogsMaxTotalSizeMB int `yaml:"logs-max-total-size-mb" json:"logs-max-total-size-mb"`
 
+	// VerboseLoggingTimeoutMinutes switches debug and request-log off again after they have been
+	// enabled for this many minutes, so sensitive logging is not left on by accident. 0 disables.
+	VerboseLoggingTimeoutMinutes int `yaml:"verbose-logging-timeout-minutes" json:"verbose-logging-timeout-minutes"`
+
+	// ErrorBufferSize is the number of recent errors kept in memory for the management API. Default: 200.
+	ErrorBufferSize int `yaml:"error-buffer-size" json:"error-buffer-size"`
+
 	// UsageStatisticsEnabled toggles in-memory usage aggregation; when false, usage data is discarded.
 	UsageStatisticsEnabled bool `yaml:"usage-statistics-enabled" json:"usage-statistics-enabled"`
 
@@ -105,9 +127,143 @@ type Config struct {
 	// DeviceBinding configures device binding restrictions for API keys.
 	DeviceBinding DeviceBindingConfig `yaml:"device-binding" json:"device-binding"`
 
+	// Telegram configures the optional Telegram admin bot.
+	Telegram TelegramConfig `yaml:"telegram" json:"telegram"`
+
+	// Discord configures Discord webhook notifications and slash commands.
+	Discord DiscordConfig `yaml:"discord" json:"discord"`
+
+	// Webhooks configures signed JSON webhook notifications for proxy events.
+	Webhooks WebhooksConfig `yaml:"webhooks" json:"webhooks"`
+
+	// UsageWebhooks posts signed per-request usage (key, model, tokens, cost) to external billing systems.
+	UsageWebhooks UsageWebhooksConfig `yaml:"usage-webhooks" json:"usage-webhooks"`
+
+	// CostExport writes scheduled cost and usage exports in the FinOps FOCUS CSV format.
+	CostExport CostExportConfig `yaml:"cost-export" json:"cost-export"`
+
+	// Probe configures the synthetic end-to-end prober.
+	Probe ProbeConfig `yaml:"probe" json:"probe"`
+
+	// Metrics configures the Prometheus scrape endpoint.
+	Metrics MetricsConfig `yaml:"metrics" json:"metrics"`
+
+	// MetricsPush configures push-based metrics export.
+	MetricsPush MetricsPushConfig `yaml:"metrics-push" json:"metrics-push"`
+
+	// ClientLimits configures proxy-level request limits per client API key.
+	ClientLimits ClientLimitsConfig `yaml:"client-limits" json:"client-limits"`
+
+	// FairShare configures fair-share scheduling of client keys sharing one upstream credential.
+	FairShare FairShareConfig `yaml:"fair-share" json:"fair-share"`
+
+	// Branding configures reseller response headers and support details per key group.
+	Branding BrandingConfig `yaml:"branding" json:"branding"`
+
+	// ContentFilters inspects prompts and responses per key group and redacts or blocks matches.
+	ContentFilters ContentFiltersConfig `yaml:"content-filters" json:"content-filters"`
+
+	// ResponsePolicy requires completions in a language or without code per key group.
+	ResponsePolicy ResponsePolicyConfig `yaml:"response-policy" json:"response-policy"`
+
+	// Analytics stores usage events in an embedded SQLite database for ad-hoc queries.
+	Analytics AnalyticsConfig `yaml:"analytics" json:"analytics"`
+
+	// ContractChecks asserts the shape of upstream responses to catch silent regressions.
+	ContractChecks ContractChecksConfig `yaml:"contract-checks" json:"contract-checks"`
+
+	// Snapshot saves rate-limit counters and upstream health so restarts keep them.
+	Snapshot SnapshotConfig `yaml:"snapshot" json:"snapshot"`
+
+	// Backup periodically copies the device binding store to a directory or S3 bucket.
+	Backup BackupConfig `yaml:"backup" json:"backup"`
+
+	// Companion serves the WebSocket protocol of desktop companion agents.
+	Companion CompanionConfig `yaml:"companion" json:"companion"`
+
+	// CostCeiling caps the worst-case cost of a single request per client key.
+	CostCeiling CostCeilingConfig `yaml:"cost-ceiling" json:"cost-ceiling"`
+
+	// Trial issues ephemeral, rate-limited keys to unauthenticated users for public demos.
+	Trial TrialConfig `yaml:"trial" json:"trial"`
+
+	// BYOK lets selected client keys supply their own upstream provider key.
+	BYOK BYOKConfig `yaml:"byok" json:"byok"`
+
+	// Spend prices upstream usage per model and caps the monthly spend of each client key.
+	Spend SpendConfig `yaml:"spend" json:"spend"`
+
+	// LatencyBudget cancels requests that exceed a per-endpoint or per-key latency budget.
+	LatencyBudget LatencyBudgetConfig `yaml:"latency-budget" json:"latency-budget"`
+
+	// RequestFeatures lets clients request optional behaviours per call with X-CC-* headers.
+	RequestFeatures RequestFeaturesConfig `yaml:"request-features" json:"request-features"`
+
+	// IPAccess restricts individual API keys to allowed client networks.
+	IPAccess IPAccessConfig `yaml:"ip-access" json:"ip-access"`
+
+	// DegradedMode sets how request checks behave while the store backing them is unavailable.
+	DegradedMode DegradedModeConfig `yaml:"degraded-mode" json:"degraded-mode"`
+
+	// Audit writes one structured JSONL record per proxied request.
+	Audit AuditConfig `yaml:"audit" json:"audit"`
+
+	// PIIRedaction scrubs personal data from everything the logging and audit subsystems write.
+	PIIRedaction PIIRedactionConfig `yaml:"pii-redaction" json:"pii-redaction"`
+
+	// SelfTest configures the checks run at startup before the listeners open.
+	SelfTest SelfTestConfig `yaml:"self-test" json:"self-test"`
+
+	// APIKeyLifecycle configures minting, rotation and revocation of client API keys.
+	APIKeyLifecycle APIKeyLifecycleConfig `yaml:"api-key-lifecycle" json:"api-key-lifecycle"`
+
+	// Alerts evaluates alert rules over the proxy metrics and notifies the chat and webhook integrations.
+	Alerts AlertsConfig `yaml:"alerts" json:"alerts"`
+
+	// ConfigHistory keeps recently applied configurations for diffing and rollback.
+	ConfigHistory ConfigHistoryConfig `yaml:"config-history" json:"config-history"`
+
+	// AuthChain authenticates clients with an ordered chain of providers instead of api-keys alone.
+	AuthChain AuthChainConfig `yaml:"auth-chain" json:"auth-chain"`
+
+	// Canary applies reloaded configurations to a set of canary keys first and rolls back on rising errors.
+	Canary CanaryConfig `yaml:"canary" json:"canary"`
+
+	// Tracing exports request spans over OTLP/HTTP.
+	Tracing TracingConfig `yaml:"tracing" json:"tracing"`
+
+	// AccessLog writes one structured, redacted log line per HTTP request.
+	AccessLog AccessLogConfig `yaml:"access-log" json:"access-log"`
+
+	// RequestValidation rejects oversized and malformed request bodies before they reach an upstream.
+	RequestValidation RequestValidationConfig `yaml:"request-validation" json:"request-validation"`
+
+	// CustomRoutes maps extra paths onto the proxy's API endpoints for legacy clients.
+	CustomRoutes []CustomRoute `yaml:"custom-routes,omitempty" json:"custom-routes,omitempty"`
+
 	legacyMigrationPending bool `yaml:"-" json:"-"`
 }
 
+// DrainTimeout returns how long shutdown waits for in-flight requests to finish.
+func (c *Config) DrainTimeout() time.Duration {
+	if c == nil || c.ShutdownDrainTimeout <= 0 {
+		return DefaultShutdownDrainTimeout * time.Second
+	}
+	return time.Duration(c.ShutdownDrainTimeout) * time.Second
+}
+
+// SelfTestConfig configures the startup self-test.
+type SelfTestConfig struct {
+	// Disable skips the self-test. Default: false.
+	Disable bool `yaml:"disable" json:"disable"`
+	// SkipUpstream skips the authentication probe of configured upstream API keys. Default: false.
+	SkipUpstream bool `yaml:"skip-upstream" json:"skip-upstream"`
+	// WarnOnly reports failed checks without aborting startup. Default: false.
+	WarnOnly bool `yaml:"warn-only" json:"warn-only"`
+	// Timeout bounds each upstream probe in seconds. Default: 10.
+	Timeout int `yaml:"timeout" json:"timeout"`
+}
+
 // TLSConfig holds HTTPS server settings.
 type TLSConfig struct {
 	// Enable toggles HTTPS server mode.
@@ -116,6 +272,48 @@ type TLSConfig struct {
 	Cert string `yaml:"cert" json:"cert"`
 	// Key is the path to the TLS private key file.
 	Key string `yaml:"key" json:"key"`
+	// ClientAuth enables mutual TLS: "none" (default), "optional" (verify a client
+	// certificate when one is presented) or "require" (reject clients without one).
+	ClientAuth string `yaml:"client-auth,omitempty" json:"client-auth,omitempty"`
+	// ClientCA is the path of the PEM bundle of CAs that client certificates must chain to.
+	ClientCA string `yaml:"client-ca,omitempty" json:"client-ca,omitempty"`
+}
+
+// AdminListenerConfig configures a dedicated listener for the management API and
+// control panel. When enabled they are no longer served on the proxy listener.
+type AdminListenerConfig struct {
+	// Enabled toggles the dedicated admin listener. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Host is the interface to bind. Default: "127.0.0.1".
+	Host string `yaml:"host" json:"host"`
+	// Port is the TCP port to listen on.
+	Port int `yaml:"port" json:"port"`
+	// Socket is a Unix socket path; when set it is used instead of Host and Port.
+	Socket string `yaml:"socket,omitempty" json:"socket,omitempty"`
+	// TLS controls HTTPS on the admin listener independently of the proxy listener.
+	TLS TLSConfig `yaml:"tls" json:"tls"`
+}
+
+// ForwardProxyConfig configures the forward-proxy listener. Intercepted requests go
+// through the same authentication, device binding and accounting as direct requests.
+type ForwardProxyConfig struct {
+	// Enabled toggles the forward-proxy listener. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Host is the interface to bind. Default: "127.0.0.1".
+	Host string `yaml:"host" json:"host"`
+	// Port is the TCP port serving both HTTP CONNECT and SOCKS5.
+	Port int `yaml:"port" json:"port"`
+	// Hosts are the upstream hosts whose TLS traffic is intercepted. Default: ["api.anthropic.com"].
+	Hosts []string `yaml:"hosts,omitempty" json:"hosts,omitempty"`
+	// AllowedCIDRs lists client networks allowed to use the proxy. Default: loopback only.
+	AllowedCIDRs []string `yaml:"allowed-cidrs,omitempty" json:"allowed-cidrs,omitempty"`
+	// Passthrough tunnels connections to other hosts unchanged instead of refusing them.
+	Passthrough bool `yaml:"passthrough" json:"passthrough"`
+	// CACert and CAKey are the PEM files of the CA that signs interception certificates.
+	// They are generated on first start when both are missing.
+	// Default: "forward-proxy-ca.crt" and "forward-proxy-ca.key" in the auth directory.
+	CACert string `yaml:"ca-cert,omitempty" json:"ca-cert,omitempty"`
+	CAKey  string `yaml:"ca-key,omitempty" json:"-"`
 }
 
 // RemoteManagement holds management API configuration under 'remote-management'.
@@ -124,7 +322,7 @@ type RemoteManagement struct {
 	AllowRemote bool `yaml:"allow-remote"`
 	// SecretKey is the management key (plaintext or bcrypt hashed). YAML key intentionally 'secret-key'.
 	SecretKey string `yaml:"secret-key"`
-	// DisableControlPanel skips serving and syncing the bundled management UI when true.
+	// DisableControlPanel skips serving and syncing the bundled management UI and the /admin dashboard when true.
 	DisableControlPanel bool `yaml:"disable-control-panel"`
 	// PanelGitHubRepository overrides the GitHub repository used to fetch the management panel asset.
 	// Accepts either a repository URL (https://github.com/org/repo) or an API releases endpoint.
@@ -146,6 +344,105 @@ type RoutingConfig struct {
 	// Strategy selects the credential selection strategy.
 	// Supported values: "round-robin" (default), "fill-first".
 	Strategy string `yaml:"strategy,omitempty" json:"strategy,omitempty"`
+	// Models overrides the strategy and upstream endpoint weights per model name.
+	Models []ModelRoutingConfig `yaml:"models,omitempty" json:"models,omitempty"`
+	// HealthCheck takes upstream endpoints out of rotation after repeated 5xx responses or timeouts.
+	HealthCheck UpstreamHealthCheckConfig `yaml:"health-check" json:"health-check"`
+	// CircuitBreaker stops sending traffic to upstream endpoints with a high error rate or latency.
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit-breaker" json:"circuit-breaker"`
+	// Retry resends requests that failed with a transient upstream error to the same credential.
+	Retry UpstreamRetryConfig `yaml:"retry" json:"retry"`
+	// Sticky pins each client key or conversation to one upstream credential.
+	Sticky StickyRoutingConfig `yaml:"sticky" json:"sticky"`
+}
+
+// StickyRoutingConfig configures session affinity. A pinned credential keeps serving
+// its client until it is unhealthy, cooling down or out of rotation; the request then
+// fails over to another credential, which becomes the new pin.
+type StickyRoutingConfig struct {
+	// Enabled toggles session affinity. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Key is "api-key" (default) to pin each client API key, or "conversation" to pin each
+	// conversation ID, falling back to the client key when a request carries none.
+	Key string `yaml:"key,omitempty" json:"key,omitempty"`
+	// ConversationHeader carries the conversation ID. Default: "X-Conversation-ID". Without
+	// the header, prompt_cache_key and metadata.user_id of the request body are used.
+	ConversationHeader string `yaml:"conversation-header,omitempty" json:"conversation-header,omitempty"`
+	// TTL forgets pins idle for this many seconds. Default: 3600.
+	TTL int `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+}
+
+// ModelRoutingConfig is a per-model routing rule.
+type ModelRoutingConfig struct {
+	// Model is an exact model name or a prefix ending in "*". The first matching rule wins.
+	Model string `yaml:"model" json:"model"`
+	// Strategy is "round-robin", "fill-first" or "weighted". Default: the global strategy.
+	Strategy string `yaml:"strategy,omitempty" json:"strategy,omitempty"`
+	// Weights maps an upstream base URL or auth ID to its share of traffic for "weighted".
+	// Unlisted endpoints get weight 1; weight 0 removes an endpoint for this model.
+	Weights map[string]int `yaml:"weights,omitempty" json:"weights,omitempty"`
+}
+
+// UpstreamHealthCheckConfig configures upstream endpoint health tracking. Endpoints are
+// identified by base URL, so every credential pointing at a failing endpoint is skipped.
+type UpstreamHealthCheckConfig struct {
+	// Enabled toggles health tracking. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// FailureThreshold is how many consecutive 5xx responses or timeouts mark an endpoint
+	// unhealthy. Default: 3.
+	FailureThreshold int `yaml:"failure-threshold" json:"failure-threshold"`
+	// Cooldown is how many seconds an unhealthy endpoint is skipped before it gets live
+	// traffic again. Default: 30.
+	Cooldown int `yaml:"cooldown" json:"cooldown"`
+	// Interval, when positive, actively probes every endpoint with a base URL this often (seconds).
+	Interval int `yaml:"interval" json:"interval"`
+	// Path is requested on each base URL by active probes. Default: "/v1/models".
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// Timeout is the active probe timeout in seconds. Default: 5.
+	Timeout int `yaml:"timeout" json:"timeout"`
+}
+
+// CircuitBreakerConfig configures per-upstream circuit breakers. Endpoints are identified
+// by base URL like UpstreamHealthCheckConfig. An open circuit fails over to other upstreams
+// of the provider, or fails fast with 503 when none is left; after Cooldown it half-opens
+// and lets trial requests through to decide whether to close again.
+type CircuitBreakerConfig struct {
+	// Enabled toggles circuit breaking. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Window is the rolling window in seconds over which error rate and latency are measured. Default: 60.
+	Window int `yaml:"window" json:"window"`
+	// MinRequests is how many requests the window needs before the circuit can open. Default: 10.
+	MinRequests int `yaml:"min-requests" json:"min-requests"`
+	// ErrorRate opens the circuit when this fraction of requests fail with 5xx or network errors. Default: 0.5.
+	ErrorRate float64 `yaml:"error-rate" json:"error-rate"`
+	// SlowCallDuration marks requests slower than this many milliseconds as slow. Default: 0 (latency ignored).
+	SlowCallDuration int `yaml:"slow-call-duration" json:"slow-call-duration"`
+	// SlowCallRate opens the circuit when this fraction of requests are slow. Default: 0.5.
+	SlowCallRate float64 `yaml:"slow-call-rate" json:"slow-call-rate"`
+	// Cooldown is how many seconds an open circuit rejects traffic before half-opening. Default: 30.
+	Cooldown int `yaml:"cooldown" json:"cooldown"`
+	// HalfOpenRequests is how many trial requests must succeed to close a half-open circuit. Default: 1.
+	HalfOpenRequests int `yaml:"half-open-requests" json:"half-open-requests"`
+}
+
+// UpstreamRetryConfig configures retries of transient upstream failures (5xx and
+// connection errors) with jittered exponential backoff. A failed credential rests for
+// the backoff instead of the usual minute, and the request-retry loop resends the
+// request once every credential was tried, never once a stream has started. 429 is
+// left to the quota cooldown.
+type UpstreamRetryConfig struct {
+	// Enabled toggles upstream retries. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// MaxAttempts is the total number of attempts, including the first, within request-retry. Default: 3.
+	MaxAttempts int `yaml:"max-attempts" json:"max-attempts"`
+	// InitialBackoff is the backoff in milliseconds before the first retry. Default: 250.
+	InitialBackoff int `yaml:"initial-backoff" json:"initial-backoff"`
+	// MaxBackoff caps the backoff in milliseconds; a longer Retry-After hint is not waited for. Default: 5000.
+	MaxBackoff int `yaml:"max-backoff" json:"max-backoff"`
+	// Multiplier grows the backoff after every retry. Default: 2.
+	Multiplier float64 `yaml:"multiplier" json:"multiplier"`
+	// StatusCodes are the upstream status codes that are retried; 429 is ignored. Default: 500, 502, 503, 504.
+	StatusCodes []int `yaml:"status-codes,omitempty" json:"status-codes,omitempty"`
 }
 
 // DeviceBindingConfig configures device binding restrictions for API keys.
@@ -160,6 +457,180 @@ type DeviceBindingConfig struct {
 	// If requests come from different IPs within this time window, the key will be banned.
 	// Default: 60 seconds. Set to 0 to disable concurrent usage detection.
 	ConcurrentThreshold int `yaml:"concurrent-threshold" json:"concurrent-threshold"`
+	// BanDuration is how long (in seconds) an automatic concurrent-usage ban lasts.
+	// Default: 0, meaning bans are permanent until an admin unbans the key.
+	BanDuration int `yaml:"ban-duration" json:"ban-duration"`
+	// BanEscalation defines progressive actions per concurrent-usage strike. Entry i applies to
+	// strike i+1 and the last entry applies to all further strikes. When empty, every strike bans
+	// for BanDuration.
+	BanEscalation []BanEscalationStep `yaml:"ban-escalation,omitempty" json:"ban-escalation,omitempty"`
+	// StrikeResetAfter clears accumulated strikes after this many seconds without a violation.
+	// Default: 0, meaning strikes never reset automatically.
+	StrikeResetAfter int `yaml:"strike-reset-after" json:"strike-reset-after"`
+	// GracePeriod is how many seconds after a key is first seen IP changes are recorded but never
+	// trigger strikes or bans, so new users can set up several machines. Default: 0 (disabled).
+	GracePeriod int `yaml:"grace-period" json:"grace-period"`
+	// MaxStreamingDevices is how many devices of one key may have requests (including open
+	// streams) in flight at the same time; one more counts as concurrent usage immediately,
+	// whatever the IPs or timing. Default: 0 (disabled).
+	MaxStreamingDevices int `yaml:"max-streaming-devices" json:"max-streaming-devices"`
+	// BanHistoryRetentionDays drops ban history entries older than this many days whenever a
+	// key's ban state changes. Default: 0 (keep forever).
+	BanHistoryRetentionDays int `yaml:"ban-history-retention-days" json:"ban-history-retention-days"`
+	// StaleBindingTTLDays removes bindings of keys not seen for this many days. Banned keys and
+	// keys with metadata or a policy override are kept. Default: 0 (keep forever).
+	StaleBindingTTLDays int `yaml:"stale-binding-ttl-days" json:"stale-binding-ttl-days"`
+	// StaleBindingArchive is a JSONL file removed stale bindings are appended to before deletion.
+	StaleBindingArchive string `yaml:"stale-binding-archive,omitempty" json:"stale-binding-archive,omitempty"`
+	// GeoIPDatabase is the path of a MaxMind GeoIP2/GeoLite2 City database (.mmdb).
+	// When set, IP changes can be excused by geography via MinDistanceKm and MaxTravelSpeedKmh.
+	GeoIPDatabase string `yaml:"geoip-database,omitempty" json:"geoip-database,omitempty"`
+	// MinDistanceKm ignores IP changes between locations closer than this many kilometres.
+	// Default: 0 (disabled).
+	MinDistanceKm float64 `yaml:"min-distance-km" json:"min-distance-km"`
+	// MaxTravelSpeedKmh ignores IP changes whose implied travel speed is at most this many
+	// km/h ("impossible travel" detection). Default: 0 (disabled).
+	MaxTravelSpeedKmh float64 `yaml:"max-travel-speed-kmh" json:"max-travel-speed-kmh"`
+	// ASNDatabase is the path of a MaxMind GeoLite2 ASN database (.mmdb) used by ExemptASNs
+	// and carrier detection.
+	ASNDatabase string `yaml:"asn-database,omitempty" json:"asn-database,omitempty"`
+	// ExemptASNs lists autonomous system numbers (e.g. mobile carriers behind CGNAT) inside
+	// which IP changes never count as concurrent usage.
+	ExemptASNs []uint `yaml:"exempt-asns,omitempty" json:"exempt-asns,omitempty"`
+	// DetectCGNAT exempts IP changes inside the 100.64.0.0/10 carrier-grade NAT range and,
+	// with ASNDatabase, within one ASN operated by a mobile carrier. Default: false.
+	DetectCGNAT bool `yaml:"detect-cgnat" json:"detect-cgnat"`
+	// TrustedCIDRs lists networks inside which IP changes never count as concurrent usage,
+	// e.g. corporate NAT egress ranges.
+	TrustedCIDRs []string `yaml:"trusted-cidrs,omitempty" json:"trusted-cidrs,omitempty"`
+	// TrustedCIDRsByKey adds per-API-key trusted networks on top of TrustedCIDRs.
+	TrustedCIDRsByKey map[string][]string `yaml:"trusted-cidrs-by-key,omitempty" json:"-"`
+	// RequireApproval registers new devices as pending. Requests from pending devices are
+	// rejected until an admin approves them via the management API. Default: false.
+	RequireApproval bool `yaml:"require-approval" json:"require-approval"`
+	// DeviceTokenSecret enables signed device tokens when set. Devices obtain an HMAC-signed token
+	// from POST /v0/device/register and must send it on every request instead of a plain device ID.
+	DeviceTokenSecret string `yaml:"device-token-secret" json:"-"`
+	// DeviceTokenHeader is the header carrying the signed device token. Default: "X-Device-Token".
+	DeviceTokenHeader string `yaml:"device-token-header" json:"device-token-header"`
+	// ClientCertIdentity uses the SHA-256 fingerprint of a verified client certificate as the
+	// device ID when the client presents one (requires tls.client-auth). Default: false.
+	ClientCertIdentity bool `yaml:"client-cert-identity" json:"client-cert-identity"`
+	// TLSFingerprint pins client TLS fingerprints (JA3/JA4) to API keys as a device signal
+	// for clients that cannot send a device ID.
+	TLSFingerprint TLSFingerprintConfig `yaml:"tls-fingerprint" json:"tls-fingerprint"`
+	// Attestation verifies Apple App Attest and Google Play Integrity attestations presented
+	// at POST /v0/device/register and records the verdict on the device.
+	Attestation AttestationConfig `yaml:"attestation" json:"attestation"`
+	// Activity keeps a per-key timeline of requests (time, IP, device, endpoint) in memory
+	// for reviewing what led up to a ban.
+	Activity BindingActivityConfig `yaml:"activity" json:"activity"`
+	// Store selects the persistence backend for device bindings.
+	Store DeviceStoreConfig `yaml:"store" json:"store"`
+	// AnomalyScoring scores IP, user agent, device header, TLS fingerprint and request cadence
+	// changes together instead of banning on an IP change alone.
+	AnomalyScoring AnomalyScoringConfig `yaml:"anomaly-scoring" json:"anomaly-scoring"`
+}
+
+// AnomalyScoringConfig configures request pattern scoring for concurrent-usage detection.
+// Each signal that changed within concurrent-threshold adds its weight to the request's
+// score; exempt IP changes (trusted networks, carriers, plausible travel) never count.
+type AnomalyScoringConfig struct {
+	// Enabled replaces the ban on an IP change with scoring. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Weights maps signals (ip, user-agent, device-header, tls-fingerprint, cadence) to their
+	// score. Defaults: ip 50, user-agent 30, device-header 30, tls-fingerprint 40, cadence 20.
+	Weights map[string]int `yaml:"weights,omitempty" json:"weights,omitempty"`
+	// WarnScore logs the request and sets an X-Device-Warning header. Default: 50.
+	WarnScore int `yaml:"warn-score" json:"warn-score"`
+	// BanScore records a concurrent-usage strike, handled by ban-escalation. Default: 90.
+	BanScore int `yaml:"ban-score" json:"ban-score"`
+	// CadenceMaxPerMinute fires the cadence signal when one device sends more requests within a
+	// minute. Default: 0 (cadence not scored).
+	CadenceMaxPerMinute int `yaml:"cadence-max-per-minute" json:"cadence-max-per-minute"`
+}
+
+// BindingActivityConfig configures the in-memory activity timeline of API keys.
+type BindingActivityConfig struct {
+	// Enabled toggles activity recording. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// RetentionHours drops activity points older than this many hours. Default: 72.
+	RetentionHours int `yaml:"retention-hours" json:"retention-hours"`
+	// MaxPointsPerKey caps the points kept per key; the oldest are dropped first. Default: 5000.
+	MaxPointsPerKey int `yaml:"max-points-per-key" json:"max-points-per-key"`
+}
+
+// TLSFingerprintConfig configures TLS client fingerprint pinning.
+type TLSFingerprintConfig struct {
+	// Mode is "off" (default), "record" (pin on first use and log mismatches) or "enforce"
+	// (reject requests whose fingerprint is not pinned). A key pins at most one fingerprint
+	// per allowed device.
+	Mode string `yaml:"mode" json:"mode"`
+	// Algorithm is "ja4" (default) or "ja3".
+	Algorithm string `yaml:"algorithm,omitempty" json:"algorithm,omitempty"`
+	// Header reads the fingerprint from this request header instead of the TLS handshake, for
+	// deployments behind a TLS-terminating proxy. The proxy must overwrite client-supplied values.
+	Header string `yaml:"header,omitempty" json:"header,omitempty"`
+}
+
+// AttestationConfig configures mobile device attestation at token registration.
+type AttestationConfig struct {
+	// Required rejects devices without a trusted attestation for every key. Per-key
+	// policies can require attestation for selected keys only. Default: false.
+	Required bool `yaml:"required" json:"required"`
+	// ChallengeTTL is how many seconds an attestation challenge stays valid. Default: 300.
+	ChallengeTTL int `yaml:"challenge-ttl" json:"challenge-ttl"`
+	// AppleAppIDs lists the App Attest app IDs ("<team id>.<bundle id>") that are accepted.
+	AppleAppIDs []string `yaml:"apple-app-ids,omitempty" json:"apple-app-ids,omitempty"`
+	// AppleRootCA is the path of the Apple App Attestation root CA certificate (PEM).
+	AppleRootCA string `yaml:"apple-root-ca,omitempty" json:"apple-root-ca,omitempty"`
+	// AllowDevelopment also accepts keys from the App Attest development environment.
+	AllowDevelopment bool `yaml:"allow-development" json:"allow-development"`
+	// AndroidPackageNames lists the Play Integrity package names that are accepted.
+	AndroidPackageNames []string `yaml:"android-package-names,omitempty" json:"android-package-names,omitempty"`
+	// PlayDecryptionKey and PlayVerificationKey are the base64 response encryption keys from
+	// the Play Console, used to decrypt and verify integrity tokens locally.
+	PlayDecryptionKey   string `yaml:"play-decryption-key,omitempty" json:"-"`
+	PlayVerificationKey string `yaml:"play-verification-key,omitempty" json:"-"`
+}
+
+// DeviceStoreConfig selects where device bindings are persisted.
+type DeviceStoreConfig struct {
+	// Backend is "yaml" (default, device-bindings.yaml in the working directory), "sqlite" or "postgres".
+	Backend string `yaml:"backend" json:"backend"`
+	// Path is the SQLite database file. Default: "device-bindings.db" in the working directory.
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// DSN is the PostgreSQL connection string used by the "postgres" backend.
+	DSN string `yaml:"dsn,omitempty" json:"-"`
+	// MaxOpenConns caps open PostgreSQL connections. Default: 0 (unlimited).
+	MaxOpenConns int `yaml:"max-open-conns,omitempty" json:"max-open-conns,omitempty"`
+	// MaxIdleConns caps idle PostgreSQL connections kept in the pool. Default: 2.
+	MaxIdleConns int `yaml:"max-idle-conns,omitempty" json:"max-idle-conns,omitempty"`
+	// ConnMaxLifetime recycles PostgreSQL connections after this many seconds. Default: 0 (never).
+	ConnMaxLifetime int `yaml:"conn-max-lifetime,omitempty" json:"conn-max-lifetime,omitempty"`
+	// DualWrite mirrors every binding write to a second backend while reads stay on this one,
+	// so a storage migration can be verified under live traffic before cutting over.
+	DualWrite DeviceDualWriteConfig `yaml:"dual-write,omitempty" json:"dual-write,omitempty"`
+}
+
+// DeviceDualWriteConfig configures the store that receives mirrored binding writes.
+type DeviceDualWriteConfig struct {
+	// Backend is "yaml", "sqlite" or "postgres"; empty disables dual-write.
+	Backend string `yaml:"backend,omitempty" json:"backend,omitempty"`
+	// Path is the SQLite database file of the mirror.
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// DSN is the PostgreSQL connection string of the mirror.
+	DSN string `yaml:"dsn,omitempty" json:"-"`
+	// VerifyInterval compares both stores every this many seconds. Default: 300.
+	VerifyInterval int `yaml:"verify-interval,omitempty" json:"verify-interval,omitempty"`
+}
+
+// BanEscalationStep configures the action taken for a single strike.
+type BanEscalationStep struct {
+	// Action is "warn" (allow the request and log) or "ban".
+	Action string `yaml:"action" json:"action"`
+	// Duration is the ban length in seconds for "ban" actions; 0 bans permanently.
+	Duration int `yaml:"duration,omitempty" json:"duration,omitempty"`
 }
 
 // SetDefaults applies default values to DeviceBindingConfig.
@@ -173,6 +644,814 @@ func (c *DeviceBindingConfig) SetDefaults() {
 	if c.ConcurrentThreshold <= 0 {
 		c.ConcurrentThreshold = 60
 	}
+	if c.BanDuration < 0 {
+		c.BanDuration = 0
+	}
+}
+
+// TelegramConfig configures the optional Telegram bot that notifies admins of
+// device binding events and accepts management commands.
+type TelegramConfig struct {
+	// Enabled toggles the Telegram bot. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// BotToken is the token issued by @BotFather.
+	BotToken string `yaml:"bot-token" json:"-"`
+	// AllowedChatIDs lists the chats that receive notifications and may issue commands.
+	AllowedChatIDs []int64 `yaml:"allowed-chat-ids" json:"allowed-chat-ids"`
+	// Events lists the event types forwarded to the chats. Default: ban, unban.
+	Events []string `yaml:"events,omitempty" json:"events,omitempty"`
+}
+
+// DiscordConfig configures Discord webhook notifications and the slash-command
+// interactions endpoint.
+type DiscordConfig struct {
+	// Enabled toggles the Discord integration. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// PublicKey is the application's hex-encoded Ed25519 public key used to verify interactions.
+	// Leave empty to disable slash commands.
+	PublicKey string `yaml:"public-key" json:"public-key"`
+	// Channels lists per guild/channel notification and command settings.
+	Channels []DiscordChannel `yaml:"channels" json:"channels"`
+}
+
+// DiscordChannel configures notifications and commands for a single guild/channel.
+type DiscordChannel struct {
+	// GuildID restricts commands to a guild. Empty matches any guild.
+	GuildID string `yaml:"guild-id" json:"guild-id"`
+	// ChannelID restricts commands to a channel. Empty matches any channel in the guild.
+	ChannelID string `yaml:"channel-id" json:"channel-id"`
+	// WebhookURL receives event notifications. Leave empty to disable notifications for this entry.
+	WebhookURL string `yaml:"webhook-url" json:"-"`
+	// Events lists the event types forwarded to the webhook. Default: ban, unban.
+	Events []string `yaml:"events,omitempty" json:"events,omitempty"`
+	// AllowCommands accepts slash commands issued from this guild/channel.
+	AllowCommands bool `yaml:"allow-commands" json:"allow-commands"`
+}
+
+// ProbeConfig configures synthetic probe requests that exercise the full proxy
+// pipeline with a dedicated key that is excluded from usage accounting.
+type ProbeConfig struct {
+	// Enabled toggles the prober. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// APIKey is the dedicated client key used by probes. It must also be listed in api-keys.
+	APIKey string `yaml:"api-key" json:"-"`
+	// Model is the model requested by probes.
+	Model string `yaml:"model" json:"model"`
+	// Endpoint is "/v1/messages" (default) or "/v1/chat/completions".
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+	// Prompt is the tiny prompt sent by probes. Default: "Reply with OK.".
+	Prompt string `yaml:"prompt" json:"prompt"`
+	// Interval is the probe interval in seconds. Default: 60.
+	Interval int `yaml:"interval" json:"interval"`
+	// Timeout is the per-probe timeout in seconds. Default: 30.
+	Timeout int `yaml:"timeout" json:"timeout"`
+	// FailureThreshold is the number of consecutive failures before health checks report unhealthy. Default: 3.
+	FailureThreshold int `yaml:"failure-threshold" json:"failure-threshold"`
+}
+
+// MetricsConfig configures the Prometheus text-format scrape endpoint.
+type MetricsConfig struct {
+	// Enabled exposes the endpoint. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Path is the HTTP path of the endpoint. Default: "/metrics".
+	Path string `yaml:"path" json:"path"`
+	// BearerToken, when set, must be sent as "Authorization: Bearer <token>" to scrape.
+	BearerToken string `yaml:"bearer-token" json:"-"`
+}
+
+// MetricsPushConfig configures periodic push-based metrics export for
+// environments where the proxy cannot be scraped.
+type MetricsPushConfig struct {
+	// Enabled toggles metrics pushing. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Format selects the backend: "pushgateway" (default) or "otlp".
+	Format string `yaml:"format" json:"format"`
+	// URL is the Pushgateway base URL or the OTLP/HTTP metrics endpoint (e.g. http://collector:4318/v1/metrics).
+	URL string `yaml:"url" json:"url"`
+	// Interval is the push interval in seconds. Default: 30.
+	Interval int `yaml:"interval" json:"interval"`
+	// Job is the Pushgateway job name or OTLP service.name. Default: "cli-proxy-api".
+	Job string `yaml:"job" json:"job"`
+	// Labels are added as Pushgateway grouping labels or OTLP resource attributes.
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	// Headers are sent with every push request (e.g. authentication).
+	Headers map[string]string `yaml:"headers,omitempty" json:"-"`
+}
+
+// TracingConfig configures request tracing. Spans cover the HTTP handlers,
+// selected middleware and upstream calls, which receive a traceparent header.
+type TracingConfig struct {
+	// Enabled toggles tracing. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Endpoint is the OTLP/HTTP traces endpoint (e.g. http://collector:4318/v1/traces).
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+	// ServiceName is reported as the service.name resource attribute. Default: "cli-proxy-api".
+	ServiceName string `yaml:"service-name,omitempty" json:"service-name,omitempty"`
+	// SampleRatio is the fraction of new traces recorded, between 0 and 1. Incoming
+	// traceparent headers keep the caller's decision. Default: 1.
+	SampleRatio *float64 `yaml:"sample-ratio,omitempty" json:"sample-ratio,omitempty"`
+	// Headers are sent with every export request (e.g. authentication).
+	Headers map[string]string `yaml:"headers,omitempty" json:"-"`
+}
+
+// ClientLimitsConfig configures proxy-level request limits per client API key.
+type ClientLimitsConfig struct {
+	// Enabled toggles enforcement and the X-RateLimit-*/X-Quota-* response headers.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// RequestsPerMinute is the default per-key request rate limit; 0 means unlimited.
+	RequestsPerMinute int `yaml:"requests-per-minute" json:"requests-per-minute"`
+	// DailyRequests is the default per-key daily request quota; 0 means unlimited.
+	DailyRequests int `yaml:"daily-requests" json:"daily-requests"`
+	// QuotaMode is "hard" (reject once the daily quota is used up, default) or "soft"
+	// (keep serving and mark further requests as overage in usage reports).
+	QuotaMode string `yaml:"quota-mode" json:"quota-mode"`
+	// OverageMultiplier weights overage requests in usage cost reports. Default: 1.
+	OverageMultiplier float64 `yaml:"overage-multiplier" json:"overage-multiplier"`
+	// Algorithm enforces RequestsPerMinute with "fixed-window" (default) minute windows, a
+	// "token-bucket" that refills continuously and allows bursts up to the per-minute limit,
+	// a "sliding-window" log of the last 60 seconds, or "gcra", which spaces requests evenly
+	// and admits Burst at once. Groups and keys may choose their own.
+	Algorithm string `yaml:"algorithm,omitempty" json:"algorithm,omitempty"`
+	// Burst is the number of requests the gcra algorithm admits at once. Default: 1.
+	Burst int `yaml:"burst,omitempty" json:"burst,omitempty"`
+	// TokensPerMinute is the default per-key budget of upstream tokens (input plus output)
+	// per minute, enforced with a token bucket; 0 means unlimited.
+	TokensPerMinute int `yaml:"tokens-per-minute" json:"tokens-per-minute"`
+	// DailyOutputTokens is the default per-key daily quota of output tokens; 0 means unlimited.
+	// It is also enforced while streaming: a stream that uses up the quota is ended with a
+	// quota exhausted event instead of running to completion.
+	DailyOutputTokens int `yaml:"daily-output-tokens,omitempty" json:"daily-output-tokens,omitempty"`
+	// MaxConcurrent is the default number of requests (including open streams) one key may
+	// have in flight at once; one more is rejected with 429. Heartbeat requests are not
+	// counted. 0 means unlimited.
+	MaxConcurrent int `yaml:"max-concurrent,omitempty" json:"max-concurrent,omitempty"`
+	// Groups share limits between sets of API keys. Per-key overrides win over groups.
+	Groups []ClientLimitGroup `yaml:"groups,omitempty" json:"groups,omitempty"`
+	// Keys overrides the defaults for individual API keys.
+	Keys map[string]ClientLimit `yaml:"keys,omitempty" json:"-"`
+	// Classes changes how request classes ("new", "continuation", "heartbeat") count
+	// against the limits, so agent loops sending rapid tool results are not throttled
+	// like fresh prompts. Classes not listed count like "new".
+	Classes map[string]RequestClassLimit `yaml:"classes,omitempty" json:"classes,omitempty"`
+}
+
+// RequestClassLimit configures the limits of one request class.
+type RequestClassLimit struct {
+	// Exempt keeps requests of the class out of the key's requests-per-minute and daily
+	// quota. Tokens-per-minute still applies.
+	Exempt bool `yaml:"exempt" json:"exempt"`
+	// RequestsPerMinute caps exempt requests of the class per key; 0 means unlimited.
+	RequestsPerMinute int `yaml:"requests-per-minute,omitempty" json:"requests-per-minute,omitempty"`
+}
+
+// ClientLimit holds the limits for a single API key.
+type ClientLimit struct {
+	RequestsPerMinute int     `yaml:"requests-per-minute" json:"requests-per-minute"`
+	DailyRequests     int     `yaml:"daily-requests" json:"daily-requests"`
+	TokensPerMinute   int     `yaml:"tokens-per-minute,omitempty" json:"tokens-per-minute,omitempty"`
+	DailyOutputTokens int     `yaml:"daily-output-tokens,omitempty" json:"daily-output-tokens,omitempty"`
+	QuotaMode         string  `yaml:"quota-mode,omitempty" json:"quota-mode,omitempty"`
+	OverageMultiplier float64 `yaml:"overage-multiplier,omitempty" json:"overage-multiplier,omitempty"`
+	MaxConcurrent     int     `yaml:"max-concurrent,omitempty" json:"max-concurrent,omitempty"`
+	// Algorithm and Burst override the client-limits defaults.
+	Algorithm string `yaml:"algorithm,omitempty" json:"algorithm,omitempty"`
+	Burst     int    `yaml:"burst,omitempty" json:"burst,omitempty"`
+}
+
+// ClientLimitGroup applies one set of limits to each of its API keys. Every key
+// still has its own counters; the group only supplies the limit values.
+type ClientLimitGroup struct {
+	// Name identifies the group in reports.
+	Name string `yaml:"name" json:"name"`
+	// APIKeys lists the client API keys in the group.
+	APIKeys     []string `yaml:"api-keys" json:"-"`
+	ClientLimit `yaml:",inline" json:",inline"`
+}
+
+// FairShareConfig configures weighted fair scheduling of client keys that share an upstream credential.
+type FairShareConfig struct {
+	// Enabled toggles fair-share scheduling.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// MaxConcurrentPerUpstream caps in-flight requests per upstream credential; excess requests
+	// wait and are admitted in weighted fair order across client keys.
+	MaxConcurrentPerUpstream int `yaml:"max-concurrent-per-upstream" json:"max-concurrent-per-upstream"`
+	// MaxWait is how long (in seconds) a request may wait for a slot before failing. Default: 60.
+	MaxWait int `yaml:"max-wait" json:"max-wait"`
+	// Tiers maps plan tier names to scheduling weights. The "default" tier applies to keys
+	// without an assigned tier; weights default to 1.
+	Tiers map[string]int `yaml:"tiers,omitempty" json:"tiers,omitempty"`
+	// KeyTiers assigns client API keys to plan tiers. Keys not listed here fall back to the
+	// "tier" attribute of their device-binding metadata.
+	KeyTiers map[string]string `yaml:"key-tiers,omitempty" json:"-"`
+	// PriorityClasses lists request classes (e.g. "continuation", "heartbeat") that are
+	// granted a free slot ahead of queued requests of other classes.
+	PriorityClasses []string `yaml:"priority-classes,omitempty" json:"priority-classes,omitempty"`
+	// TierPriorities maps plan tiers to strict priority levels: queued requests of a higher
+	// level are always admitted first, and tier weights only order requests within a level.
+	// Tiers not listed have level 0.
+	TierPriorities map[string]int `yaml:"tier-priorities,omitempty" json:"tier-priorities,omitempty"`
+	// MaxQueue caps the requests waiting per upstream credential. When the queue is full a
+	// request takes the place of a lower priority waiter, or fails with 503 right away.
+	// Default: 0 (unbounded).
+	MaxQueue int `yaml:"max-queue,omitempty" json:"max-queue,omitempty"`
+	// TierMaxWait overrides MaxWait (seconds) for individual plan tiers.
+	TierMaxWait map[string]int `yaml:"tier-max-wait,omitempty" json:"tier-max-wait,omitempty"`
+}
+
+// WebhooksConfig configures outbound JSON webhooks for proxy events.
+type WebhooksConfig struct {
+	// Enabled toggles webhook delivery. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// MaxRetries is the number of retries with exponential backoff after a failed delivery.
+	// Default: 5. Set to -1 to disable retries.
+	MaxRetries int `yaml:"max-retries" json:"max-retries"`
+	// Endpoints lists the webhook receivers.
+	Endpoints []WebhookEndpoint `yaml:"endpoints" json:"endpoints"`
+}
+
+// WebhookEndpoint is a single webhook receiver.
+type WebhookEndpoint struct {
+	// URL receives POSTed JSON events.
+	URL string `yaml:"url" json:"url"`
+	// Secret signs payloads with HMAC-SHA256 in the X-Webhook-Signature header; empty disables signing.
+	Secret string `yaml:"secret" json:"-"`
+	// Events lists the event types delivered. Default: ban, unban, device_registered.
+	Events []string `yaml:"events,omitempty" json:"events,omitempty"`
+}
+
+// UsageWebhooksConfig configures usage webhooks for external billing and CRM systems.
+// Deliveries go through a persistent outbox, so they survive restarts and
+// endpoint outages and can be replayed.
+type UsageWebhooksConfig struct {
+	// Enabled toggles usage webhook delivery. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// BatchInterval groups completed requests into one delivery per interval in seconds.
+	// Default: 0, one delivery per request.
+	BatchInterval int `yaml:"batch-interval" json:"batch-interval"`
+	// MaxAttempts is how often a delivery is tried, with exponential backoff, before it is
+	// marked failed and left for replay. Default: 10.
+	MaxAttempts int `yaml:"max-attempts" json:"max-attempts"`
+	// Path is the SQLite outbox file. Default: usage-webhooks.db.
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// RetentionDays keeps delivered and failed deliveries for replay this many days. Default: 7.
+	RetentionDays int `yaml:"retention-days" json:"retention-days"`
+	// Endpoints lists the receivers; every delivery is sent to each of them.
+	Endpoints []UsageWebhookEndpoint `yaml:"endpoints" json:"endpoints"`
+}
+
+// CostExportConfig configures scheduled cost and usage exports in the FinOps
+// FOCUS format, one CSV row per charge period, key, team, provider and model.
+type CostExportConfig struct {
+	// Enabled toggles cost exports. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Schedule is "hourly" or "daily" (default); exports are written at the end of each
+	// period (UTC) and charge periods have the same granularity.
+	Schedule string `yaml:"schedule,omitempty" json:"schedule,omitempty"`
+	// Directory receives the CSV files. Default: cost-exports.
+	Directory string `yaml:"directory,omitempty" json:"directory,omitempty"`
+	// BillingAccountID fills the BillingAccountId column. Default: cliproxy.
+	BillingAccountID string `yaml:"billing-account-id,omitempty" json:"billing-account-id,omitempty"`
+	// TeamMetadataKey is the key metadata attribute naming a key's team. Default: team.
+	TeamMetadataKey string `yaml:"team-metadata-key,omitempty" json:"team-metadata-key,omitempty"`
+	// S3 uploads every export to an S3-compatible bucket as well.
+	S3 CostExportS3Config `yaml:"s3,omitempty" json:"s3,omitempty"`
+}
+
+// CostExportS3Config is the S3-compatible bucket cost exports are uploaded to.
+// Uploading is off while Bucket is empty.
+type CostExportS3Config struct {
+	Endpoint string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	Bucket   string `yaml:"bucket,omitempty" json:"bucket,omitempty"`
+	Region   string `yaml:"region,omitempty" json:"region,omitempty"`
+	// Prefix is prepended to object keys, which are partitioned as year=YYYY/month=MM/<file>.
+	Prefix    string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	AccessKey string `yaml:"access-key,omitempty" json:"-"`
+	SecretKey string `yaml:"secret-key,omitempty" json:"-"`
+	UseSSL    bool   `yaml:"use-ssl" json:"use-ssl"`
+	PathStyle bool   `yaml:"path-style,omitempty" json:"path-style,omitempty"`
+}
+
+// UsageWebhookEndpoint is a single usage webhook receiver.
+type UsageWebhookEndpoint struct {
+	// URL receives POSTed JSON usage batches.
+	URL string `yaml:"url" json:"url"`
+	// Secret signs payloads with HMAC-SHA256 in the X-Webhook-Signature header; empty disables signing.
+	Secret string `yaml:"secret" json:"-"`
+}
+
+// BYOKConfig configures bring-your-own-key passthrough. Requests from the listed
+// client keys are authenticated upstream with the provider key they send instead
+// of a shared credential, while device binding, logging and rate limiting still apply.
+type BYOKConfig struct {
+	// Enabled toggles BYOK passthrough. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Header carries the client's upstream provider key. Default: "X-Upstream-Api-Key".
+	Header string `yaml:"header,omitempty" json:"header,omitempty"`
+	// Keys lists the client API keys in BYOK mode; they must send the header.
+	Keys []string `yaml:"keys" json:"-"`
+}
+
+// SpendConfig configures usage pricing and monthly spend caps. Spend accrues from
+// the token usage reported by upstream responses in calendar months (UTC).
+type SpendConfig struct {
+	// Enabled toggles spend tracking and enforcement of caps. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// MonthlyCap is the default monthly spend cap in USD; 0 means no cap.
+	MonthlyCap float64 `yaml:"monthly-cap" json:"monthly-cap"`
+	// Keys overrides MonthlyCap for individual API keys.
+	Keys map[string]float64 `yaml:"keys,omitempty" json:"-"`
+	// Prices maps model names to USD per million tokens. A trailing "*" matches a
+	// prefix and "*" alone is the fallback price.
+	Prices map[string]ModelPrice `yaml:"prices,omitempty" json:"prices,omitempty"`
+}
+
+// ModelPrice is the price of a model in USD per million tokens.
+type ModelPrice struct {
+	Input  float64 `yaml:"input" json:"input"`
+	Output float64 `yaml:"output" json:"output"`
+}
+
+// LatencyBudgetConfig configures total latency budgets. A request exceeding its budget
+// has its upstream call cancelled and, unless the response already started, gets a 504.
+type LatencyBudgetConfig struct {
+	// Enabled toggles latency budgets. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// DefaultMs is the budget in milliseconds for requests without a more specific one; 0 means none.
+	DefaultMs int `yaml:"default-ms" json:"default-ms"`
+	// Endpoints maps request path prefixes (e.g. "/v1/messages") to budgets in milliseconds.
+	// The longest matching prefix wins.
+	Endpoints map[string]int `yaml:"endpoints,omitempty" json:"endpoints,omitempty"`
+	// Keys maps API keys to budgets in milliseconds, taking precedence over endpoint budgets.
+	Keys map[string]int `yaml:"keys,omitempty" json:"-"`
+}
+
+// RequestFeaturesConfig configures per-request feature negotiation. Clients send
+// X-CC-Cache: bypass, X-CC-Fallback: off or X-CC-Priority: low|high; a feature the key
+// is not permitted to use is rejected with 403.
+type RequestFeaturesConfig struct {
+	// Enabled toggles the X-CC-* headers; when false they are ignored. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Allowed lists the features every key may request: "cache-bypass", "fallback-off",
+	// "priority-low" and "priority-high". Default: all but "priority-high".
+	Allowed []string `yaml:"allowed,omitempty" json:"allowed,omitempty"`
+	// Keys replaces the allowed features of individual API keys.
+	Keys map[string][]string `yaml:"keys,omitempty" json:"-"`
+}
+
+// DegradedModeConfig sets how request checks behave while the store backing
+// them is unavailable. Each mode is "fail-open" (default: serve requests that
+// cannot be checked) or "fail-closed" (reject them with 503). Client rate limits
+// are counted in process and keep working without a store.
+type DegradedModeConfig struct {
+	// DeviceBinding applies when a key's binding can be read neither from the store nor
+	// from the fallback cache, or a new device cannot be saved.
+	DeviceBinding string `yaml:"device-binding,omitempty" json:"device-binding,omitempty"`
+	// Quotas applies to keys with a spend cap while this month's spend could not be loaded.
+	Quotas string `yaml:"quotas,omitempty" json:"quotas,omitempty"`
+	// FallbackCacheTTL is how long (seconds) the last binding read for a key is enforced
+	// while the store is unavailable. Default: 300.
+	FallbackCacheTTL int `yaml:"fallback-cache-ttl,omitempty" json:"fallback-cache-ttl,omitempty"`
+}
+
+// IPAccessConfig holds per-key client IP allow and deny lists.
+type IPAccessConfig struct {
+	// Keys maps API keys to their IP rules. Keys without rules accept any address.
+	Keys map[string]IPAccessRule `yaml:"keys,omitempty" json:"-"`
+}
+
+// IPAccessRule lists the client networks of one API key as CIDRs or single addresses.
+// Deny entries win; when Allow is non-empty every other address is rejected.
+type IPAccessRule struct {
+	Allow []string `yaml:"allow,omitempty" json:"allow,omitempty"`
+	Deny  []string `yaml:"deny,omitempty" json:"deny,omitempty"`
+}
+
+// AuditConfig configures request audit logging to rotating JSONL files.
+type AuditConfig struct {
+	// Enabled toggles audit logging. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Path is the audit file. Default: audit/audit.jsonl in the logs directory.
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// MaxSizeMB rotates the file once it reaches this size. Default: 100.
+	MaxSizeMB int `yaml:"max-size-mb" json:"max-size-mb"`
+	// RetentionDays deletes rotated files older than this many days; 0 keeps them.
+	RetentionDays int `yaml:"retention-days" json:"retention-days"`
+	// MaxBackups limits the number of rotated files kept; 0 keeps all.
+	MaxBackups int `yaml:"max-backups" json:"max-backups"`
+	// Compress gzips rotated files.
+	Compress bool `yaml:"compress" json:"compress"`
+	// UsageGraceMs is how long a finished request waits for its token usage
+	// before its record is written without it. Default: 2000.
+	UsageGraceMs int `yaml:"usage-grace-ms" json:"usage-grace-ms"`
+	// Redact maps record fields (api_key, device_id, ip, user_agent, model, path,
+	// prompt_hash) to drop, mask or hash. api_key is masked unless set to keep.
+	Redact map[string]string `yaml:"redact,omitempty" json:"redact,omitempty"`
+}
+
+// PIIRedactionConfig configures redaction of personal data in logs and audit records.
+type PIIRedactionConfig struct {
+	// Enabled toggles redaction. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Types lists the built-in detectors to use: "email", "phone" and "credit-card". Default: all.
+	Types []string `yaml:"types,omitempty" json:"types,omitempty"`
+	// Patterns adds custom regular expressions.
+	Patterns []PIIPattern `yaml:"patterns,omitempty" json:"patterns,omitempty"`
+}
+
+// PIIPattern is a custom redaction pattern.
+type PIIPattern struct {
+	// Name identifies the pattern in warnings.
+	Name string `yaml:"name" json:"name"`
+	// Pattern is a Go regular expression.
+	Pattern string `yaml:"pattern" json:"pattern"`
+	// Replacement replaces matches. Default: "[REDACTED]".
+	Replacement string `yaml:"replacement,omitempty" json:"replacement,omitempty"`
+}
+
+// AccessLogConfig configures the structured per-request access log.
+type AccessLogConfig struct {
+	// Enabled toggles the access log. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Format is "text" (fields on the application log, default) or "json" (one JSON object per line).
+	Format string `yaml:"format" json:"format"`
+	// Path is the JSON log file; empty writes to stdout. Ignored for the text format.
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// Verbosity is "basic", "standard" (default) or "headers", which adds masked request headers.
+	Verbosity string `yaml:"verbosity" json:"verbosity"`
+	// SampleRate is the fraction of successful requests logged, between 0 and 1; responses
+	// with status 400 or above are always logged. Default: 1.
+	SampleRate *float64 `yaml:"sample-rate,omitempty" json:"sample-rate,omitempty"`
+	// Fields, when set, limits records to these fields.
+	Fields []string `yaml:"fields,omitempty" json:"fields,omitempty"`
+	// ExcludeFields removes fields from records.
+	ExcludeFields []string `yaml:"exclude-fields,omitempty" json:"exclude-fields,omitempty"`
+	// SensitiveHeaders are masked in addition to Authorization, X-Api-Key and cookies.
+	SensitiveHeaders []string `yaml:"sensitive-headers,omitempty" json:"sensitive-headers,omitempty"`
+	// SkipPaths are path prefixes that are never logged (e.g. /healthz).
+	SkipPaths []string `yaml:"skip-paths,omitempty" json:"skip-paths,omitempty"`
+}
+
+// RequestValidationConfig configures request body checks on the API endpoints.
+type RequestValidationConfig struct {
+	// Enabled toggles the checks. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// MaxBodyMB rejects request bodies larger than this many MiB with 413. Default: 32.
+	MaxBodyMB int `yaml:"max-body-mb" json:"max-body-mb"`
+	// ValidateSchema checks JSON bodies against the structure of the Claude Messages, OpenAI
+	// Chat Completions, Completions and Responses APIs and rejects mismatches with 400.
+	ValidateSchema bool `yaml:"validate-schema" json:"validate-schema"`
+}
+
+// CustomRoute maps requests for an operator-defined path onto one of the proxy's API
+// endpoints, rewriting the method, headers and model on the way. The rewritten request
+// goes through the same authentication, device binding and accounting as direct calls.
+type CustomRoute struct {
+	// Path is the exact request path, or a prefix ending in "*" (e.g. "/legacy/*").
+	Path string `yaml:"path" json:"path"`
+	// Method restricts the route to one HTTP method. Default: any method.
+	Method string `yaml:"method,omitempty" json:"method,omitempty"`
+	// Target is the proxy path requests are sent to (e.g. "/v1/messages"). For a prefix
+	// Path ending in "*", a Target ending in "*" receives the rest of the request path.
+	Target string `yaml:"target" json:"target"`
+	// TargetMethod replaces the request method. Default: unchanged.
+	TargetMethod string `yaml:"target-method,omitempty" json:"target-method,omitempty"`
+	// Model forces the "model" field of JSON request bodies.
+	Model string `yaml:"model,omitempty" json:"model,omitempty"`
+	// SetHeaders adds or replaces request headers.
+	SetHeaders map[string]string `yaml:"set-headers,omitempty" json:"set-headers,omitempty"`
+	// RenameHeaders moves request header values to another header name (e.g. a legacy
+	// token header to Authorization).
+	RenameHeaders map[string]string `yaml:"rename-headers,omitempty" json:"rename-headers,omitempty"`
+	// RemoveHeaders drops request headers.
+	RemoveHeaders []string `yaml:"remove-headers,omitempty" json:"remove-headers,omitempty"`
+}
+
+// APIKeyLifecycleConfig configures the key management endpoints. Minted keys are
+// added to api-keys; a rotated key stays in api-keys until its grace window ends.
+type APIKeyLifecycleConfig struct {
+	// Prefix starts every generated key. Default: "sk-cc-".
+	Prefix string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	// RotationGrace is how long (in seconds) a rotated key keeps working. Default: 86400.
+	RotationGrace int `yaml:"rotation-grace" json:"rotation-grace"`
+	// Expiring maps keys replaced by a rotation to the time they stop working.
+	// It is maintained by the key management endpoints.
+	Expiring map[string]time.Time `yaml:"expiring,omitempty" json:"-"`
+}
+
+// AlertsConfig configures the built-in alert rules engine. Firing and resolved
+// alerts are published as alert_firing and alert_resolved events.
+type AlertsConfig struct {
+	// Enabled toggles rule evaluation. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Interval is the evaluation interval in seconds. Default: 30.
+	Interval int `yaml:"interval" json:"interval"`
+	// Rules are the alert rules to evaluate.
+	Rules []AlertRule `yaml:"rules,omitempty" json:"rules,omitempty"`
+}
+
+// AlertRule is a named condition over the proxy metrics,
+// e.g. rate(cliproxy_device_bans_total[10m]) * 60 > 5.
+type AlertRule struct {
+	Name string `yaml:"name" json:"name"`
+	// Expr compares a metric expression with a threshold.
+	Expr string `yaml:"expr" json:"expr"`
+	// For is how long (in seconds) the condition must hold before the alert fires. Default: 0.
+	For int `yaml:"for,omitempty" json:"for,omitempty"`
+	// Severity is included in notifications. Default: "warning".
+	Severity string `yaml:"severity,omitempty" json:"severity,omitempty"`
+	// Summary is a human-readable description included in notifications.
+	Summary string `yaml:"summary,omitempty" json:"summary,omitempty"`
+}
+
+// ConfigHistoryConfig configures the in-memory history of applied configurations
+// served by the management config history and rollback endpoints.
+type ConfigHistoryConfig struct {
+	// MaxVersions is how many applied configurations are kept. Default: 10.
+	MaxVersions int `yaml:"max-versions" json:"max-versions"`
+}
+
+// Authentication chain provider types
+const (
+	// AuthProviderAPIKeys accepts the keys listed in api-keys.
+	AuthProviderAPIKeys = "api-keys"
+	// AuthProviderDatabase looks keys up with a SQL query.
+	AuthProviderDatabase = "database"
+	// AuthProviderJWT accepts signed JSON Web Tokens as bearer tokens.
+	AuthProviderJWT = "jwt"
+	// AuthProviderMTLS accepts verified client certificates.
+	AuthProviderMTLS = "mtls"
+	// AuthProviderHTTP asks an external HTTP authorizer.
+	AuthProviderHTTP = "http"
+)
+
+// AuthChainConfig orders the providers that authenticate client requests. Each
+// provider is asked in turn until one accepts the request; the accepted
+// principal is the client key every per-key setting refers to. An empty chain
+// accepts the keys of api-keys only.
+type AuthChainConfig struct {
+	// CacheTTLSeconds is how long verdicts of the database, jwt and http providers
+	// are cached per credential. Default: 60; negative disables caching.
+	CacheTTLSeconds int `yaml:"cache-ttl-seconds" json:"cache-ttl-seconds"`
+	// NegativeCacheTTLSeconds is how long rejected credentials are cached. Default: 5.
+	NegativeCacheTTLSeconds int `yaml:"negative-cache-ttl-seconds" json:"negative-cache-ttl-seconds"`
+	// Providers are evaluated in order.
+	Providers []AuthChainProvider `yaml:"providers" json:"providers"`
+}
+
+// AuthChainProvider is one provider of the authentication chain.
+type AuthChainProvider struct {
+	// Name identifies the provider in logs and as the access provider of a request. Default: the type.
+	Name string `yaml:"name" json:"name"`
+	// Type is api-keys, database, jwt, mtls or http.
+	Type string `yaml:"type" json:"type"`
+	// CacheTTLSeconds overrides the chain cache TTL for this provider; 0 inherits, negative disables.
+	CacheTTLSeconds int `yaml:"cache-ttl-seconds,omitempty" json:"cache-ttl-seconds,omitempty"`
+	// Config holds the provider options; it may contain secrets.
+	Config map[string]any `yaml:"config,omitempty" json:"-"`
+}
+
+// CanaryConfig configures canary deployment of hot-reloaded configurations. While
+// a reload soaks, requests of the canary keys run the request middlewares built
+// from the new configuration and everyone else keeps the current one. The new
+// configuration is promoted to all traffic when the soak period ends, or the
+// config file is restored when the canary keys' error rate rises.
+type CanaryConfig struct {
+	// Enabled toggles canary deployment. Default: false (reloads apply at once).
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// APIKeys are the client keys that receive a new configuration first.
+	APIKeys []string `yaml:"api-keys" json:"-"`
+	// SoakSeconds is how long a new configuration runs on the canary keys before
+	// it is promoted. Default: 600.
+	SoakSeconds int `yaml:"soak-seconds" json:"soak-seconds"`
+	// MinRequests is how many canary requests are needed before the error rate
+	// is judged. Default: 20.
+	MinRequests int `yaml:"min-requests" json:"min-requests"`
+	// MaxErrorRateIncrease is how far, as a fraction, the canary error rate may
+	// exceed the rate of the other keys before rolling back. Default: 0.05.
+	MaxErrorRateIncrease float64 `yaml:"max-error-rate-increase" json:"max-error-rate-increase"`
+}
+
+// TrialConfig configures anonymous trial access. Trial keys are derived from the
+// client IP and a fingerprint, so a key only works from the client it was issued to.
+type TrialConfig struct {
+	// Enabled toggles issuing and accepting trial keys. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Secret signs trial keys. When empty a random secret is generated at startup and
+	// issued keys stop working after a restart.
+	Secret string `yaml:"secret,omitempty" json:"-"`
+	// KeyPrefix marks trial keys. Default: "trial-".
+	KeyPrefix string `yaml:"key-prefix,omitempty" json:"key-prefix,omitempty"`
+	// TTL is how long (in seconds) a trial key stays valid. Default: 3600.
+	TTL int `yaml:"ttl" json:"ttl"`
+	// RequestsPerMinute limits each trial key. Default: 2.
+	RequestsPerMinute int `yaml:"requests-per-minute" json:"requests-per-minute"`
+	// DailyRequests limits each trial key per day. Default: 20.
+	DailyRequests int `yaml:"daily-requests" json:"daily-requests"`
+	// MaxKeysPerIP caps the distinct trial keys issued to one IP per TTL window. Default: 3.
+	MaxKeysPerIP int `yaml:"max-keys-per-ip" json:"max-keys-per-ip"`
+	// FingerprintHeader is the request header combined with the client IP to scope a key.
+	// Default: "User-Agent".
+	FingerprintHeader string `yaml:"fingerprint-header,omitempty" json:"fingerprint-header,omitempty"`
+}
+
+// CostCeilingConfig caps the worst-case cost of one request, computed as the
+// requested maximum output tokens times the model's output price.
+type CostCeilingConfig struct {
+	// Enabled toggles the ceiling. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Mode is "reject" (default) to refuse requests over the ceiling or "clamp" to lower
+	// their max tokens to what the ceiling allows.
+	Mode string `yaml:"mode" json:"mode"`
+	// MaxCost is the default per-request ceiling in USD; 0 means no ceiling.
+	MaxCost float64 `yaml:"max-cost" json:"max-cost"`
+	// Keys overrides MaxCost for individual API keys.
+	Keys map[string]float64 `yaml:"keys,omitempty" json:"-"`
+	// OutputPrices maps model names to USD per million output tokens. A trailing "*"
+	// matches a prefix and "*" alone is the fallback price.
+	OutputPrices map[string]float64 `yaml:"output-prices,omitempty" json:"output-prices,omitempty"`
+}
+
+// SnapshotConfig configures periodic runtime state snapshots restored on boot.
+type SnapshotConfig struct {
+	// Enabled toggles saving and restoring snapshots. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Path is the snapshot file. Default: state-snapshot.json in the working directory.
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// Interval is how often (in seconds) a snapshot is written. Default: 60.
+	Interval int `yaml:"interval" json:"interval"`
+	// MaxAge ignores snapshots older than this many seconds on boot. Default: 86400.
+	MaxAge int `yaml:"max-age" json:"max-age"`
+}
+
+// CompanionConfig configures the local agent protocol of desktop companion
+// (tray) apps. An agent connects to /v0/device/agent with the client API key,
+// registers its device, sends heartbeats and receives ban, device and quota
+// notifications to show to the end user.
+type CompanionConfig struct {
+	// Enabled toggles the /v0/device/agent WebSocket endpoint. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// HeartbeatInterval is how often (in seconds) agents are asked to send a heartbeat.
+	// Connections silent for three intervals are closed. Default: 30.
+	HeartbeatInterval int `yaml:"heartbeat-interval" json:"heartbeat-interval"`
+	// QuotaWarningPercent sends a quota warning once a key has used this share of a
+	// daily quota. Default: 80.
+	QuotaWarningPercent int `yaml:"quota-warning-percent" json:"quota-warning-percent"`
+}
+
+// BackupConfig configures scheduled backups of the device binding store. Each
+// backup holds every binding with its devices, bans and policy, and can be
+// restored through the management API.
+type BackupConfig struct {
+	// Enabled toggles scheduled backups. Manual backups work regardless. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Interval is how often (in seconds) a backup is taken. Default: 86400.
+	Interval int `yaml:"interval" json:"interval"`
+	// Retention is the number of backups kept; older ones are deleted. Default: 7.
+	Retention int `yaml:"retention" json:"retention"`
+	// Compress gzips backups. Default: false.
+	Compress bool `yaml:"compress" json:"compress"`
+	// Dir is the local backup directory. Default: "backups" in the working directory.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty"`
+	// S3 stores backups in an S3-compatible bucket instead of Dir when Bucket is set.
+	S3 BackupS3Config `yaml:"s3,omitempty" json:"s3,omitempty"`
+}
+
+// BackupS3Config addresses an S3-compatible bucket.
+type BackupS3Config struct {
+	Endpoint  string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	Bucket    string `yaml:"bucket,omitempty" json:"bucket,omitempty"`
+	Region    string `yaml:"region,omitempty" json:"region,omitempty"`
+	Prefix    string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	AccessKey string `yaml:"access-key,omitempty" json:"-"`
+	SecretKey string `yaml:"secret-key,omitempty" json:"-"`
+	// UseSSL connects over HTTPS; PathStyle uses path-style bucket addressing.
+	UseSSL    bool `yaml:"use-ssl,omitempty" json:"use-ssl,omitempty"`
+	PathStyle bool `yaml:"path-style,omitempty" json:"path-style,omitempty"`
+}
+
+// ContractChecksConfig configures assertions on non-streaming upstream responses.
+type ContractChecksConfig struct {
+	// Enabled toggles contract checks. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Checks enables built-in assertions by name: "usage", "stop-reason" and "content".
+	Checks []string `yaml:"checks,omitempty" json:"checks,omitempty"`
+	// Rules adds custom assertions on JSON paths of the response.
+	Rules []ContractRule `yaml:"rules,omitempty" json:"rules,omitempty"`
+	// Failover retries the request on another credential when a response violates a check.
+	// When false, violations are only logged and counted. Default: false.
+	Failover bool `yaml:"failover" json:"failover"`
+}
+
+// ContractRule is a custom assertion on an upstream response.
+type ContractRule struct {
+	// Name labels violations in logs and metrics.
+	Name string `yaml:"name" json:"name"`
+	// Formats limits the rule to response formats (e.g. "claude", "openai"); empty means all.
+	Formats []string `yaml:"formats,omitempty" json:"formats,omitempty"`
+	// Paths are gjson paths; the rule passes when any of them satisfies Require.
+	Paths []string `yaml:"paths" json:"paths"`
+	// Require is "exists" (default) or "non-empty".
+	Require string `yaml:"require,omitempty" json:"require,omitempty"`
+}
+
+// AnalyticsConfig configures the embedded usage analytics database.
+type AnalyticsConfig struct {
+	// Enabled records usage events and exposes the management query endpoint. Default: false.
+	// Changes take effect after a restart.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Path is the SQLite database file. Default: analytics.db in the working directory.
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// RetentionDays deletes events older than this many days. Default: 0 (keep forever).
+	RetentionDays int `yaml:"retention-days" json:"retention-days"`
+}
+
+// BrandingConfig configures reseller branding for groups of client API keys.
+type BrandingConfig struct {
+	// Enabled toggles branding. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Groups lists the reseller key groups.
+	Groups []BrandingGroup `yaml:"groups" json:"groups"`
+}
+
+// BrandingGroup is the branding shown to end users of one reseller's keys.
+type BrandingGroup struct {
+	// Name identifies the group; keys whose device-binding metadata has reseller=<name> belong to it.
+	Name string `yaml:"name" json:"name"`
+	// APIKeys explicitly assigns client API keys to the group.
+	APIKeys []string `yaml:"api-keys,omitempty" json:"-"`
+	// Headers are added to every response for keys in the group.
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	// SupportName, SupportEmail, SupportURL and Message are added as a "support" object
+	// to JSON error responses.
+	SupportName  string `yaml:"support-name,omitempty" json:"support-name,omitempty"`
+	SupportEmail string `yaml:"support-email,omitempty" json:"support-email,omitempty"`
+	SupportURL   string `yaml:"support-url,omitempty" json:"support-url,omitempty"`
+	Message      string `yaml:"message,omitempty" json:"message,omitempty"`
+}
+
+// ContentFiltersConfig configures the prompt and response filter chain.
+type ContentFiltersConfig struct {
+	// Enabled toggles filtering. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Groups lists the filter groups. A group named "default" applies to keys that are not
+	// in any other group.
+	Groups []ContentFilterGroup `yaml:"groups" json:"groups"`
+}
+
+// ResponsePolicyConfig configures response language and format policies.
+type ResponsePolicyConfig struct {
+	// Enabled toggles policy enforcement. Default: false.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Policies lists the policies. A policy named "default" applies to keys that are not
+	// assigned to any other policy.
+	Policies []ResponsePolicy `yaml:"policies" json:"policies"`
+}
+
+// ResponsePolicy is the language and format requirement of one set of client keys. It is
+// enforced by an instruction injected into the system prompt and checked on the response.
+type ResponsePolicy struct {
+	// Name identifies the policy; keys whose device-binding metadata has response-policy=<name>
+	// follow it.
+	Name string `yaml:"name" json:"name"`
+	// APIKeys explicitly assigns client API keys to the policy.
+	APIKeys []string `yaml:"api-keys,omitempty" json:"-"`
+	// Language is the ISO 639-1 code completions must be written in, e.g. "en" or "vi".
+	Language string `yaml:"language,omitempty" json:"language,omitempty"`
+	// ForbidCode forbids code blocks and source code in completions.
+	ForbidCode bool `yaml:"forbid-code,omitempty" json:"forbid-code,omitempty"`
+	// Instruction replaces the generated system prompt instruction.
+	Instruction string `yaml:"instruction,omitempty" json:"instruction,omitempty"`
+	// Action is "log" (default) to record violations or "block" to reject the response.
+	Action string `yaml:"action,omitempty" json:"action,omitempty"`
+}
+
+// ContentFilterGroup is the filter chain applied to the requests of one set of client keys.
+type ContentFilterGroup struct {
+	// Name identifies the group; keys whose device-binding metadata has filter-group=<name> belong to it.
+	Name string `yaml:"name" json:"name"`
+	// APIKeys explicitly assigns client API keys to the group.
+	APIKeys []string `yaml:"api-keys,omitempty" json:"-"`
+	// Rules are applied in order.
+	Rules []ContentFilterRule `yaml:"rules" json:"rules"`
+}
+
+// ContentFilterRule matches text with exactly one of a regular expression, a word
+// blocklist or a registered Go filter plugin.
+type ContentFilterRule struct {
+	// Name identifies the rule in block messages and metrics.
+	Name string `yaml:"name" json:"name"`
+	// Pattern is a Go regular expression.
+	Pattern string `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+	// Words are matched case-insensitively on word boundaries.
+	Words []string `yaml:"words,omitempty" json:"words,omitempty"`
+	// Plugin names a filter registered with sdk/cliproxy/contentfilter.Register.
+	Plugin string `yaml:"plugin,omitempty" json:"plugin,omitempty"`
+	// Action is "redact" (default) or "block".
+	Action string `yaml:"action,omitempty" json:"action,omitempty"`
+	// Direction is "request", "response" or "both" (default).
+	Direction string `yaml:"direction,omitempty" json:"direction,omitempty"`
+	// Replacement replaces redacted matches. Default: "[REDACTED]".
+	Replacement string `yaml:"replacement,omitempty" json:"replacement,omitempty"`
 }
 
 // ModelNameMapping defines a model ID mapping for a specific channel.
@@ -272,6 +1551,10 @@ type ClaudeKey struct {
 	// APIKey is the authentication key for accessing Claude API services.
 	APIKey string `yaml:"api-key" json:"api-key"`
 
+	// APIKeyPool lists further keys sharing this entry's settings. Each key becomes its own
+	// credential, so requests rotate across the pool and a rate-limited key is parked alone.
+	APIKeyPool []string `yaml:"api-key-pool,omitempty" json:"api-key-pool,omitempty"`
+
 	// Prefix optionally namespaces models for this credential (e.g., "teamA/claude-sonnet-4").
 	Prefix string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
 
@@ -290,6 +1573,10 @@ type ClaudeKey struct {
 
 	// ExcludedModels lists model IDs that should be excluded for this provider.
 	ExcludedModels []string `yaml:"excluded-models,omitempty" json:"excluded-models,omitempty"`
+
+	// Peer marks BaseURL as another proxy instance. Requests then carry the
+	// request ID and hop count, and the peer's rate-limit headers are passed on.
+	Peer bool `yaml:"peer,omitempty" json:"peer,omitempty"`
 }
 
 // ClaudeModel describes a mapping between an alias and the actual upstream model name.
@@ -301,6 +1588,21 @@ type ClaudeModel struct {
 	Alias string `yaml:"alias" json:"alias"`
 }
 
+// HasKey reports whether key is the entry's API key or one of its pool keys.
+func (k ClaudeKey) HasKey(key string) bool { return hasPoolKey(k.APIKey, k.APIKeyPool, key) }
+
+// HasKey reports whether key is the entry's API key or one of its pool keys.
+func (k CodexKey) HasKey(key string) bool { return hasPoolKey(k.APIKey, k.APIKeyPool, key) }
+
+func hasPoolKey(primary string, pool []string, key string) bool {
+	for _, candidate := range append([]string{primary}, pool...) {
+		if strings.EqualFold(strings.TrimSpace(candidate), key) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m ClaudeModel) GetName() string  { return m.Name }
 func (m ClaudeModel) GetAlias() string { return m.Alias }
 
@@ -310,6 +1612,10 @@ type CodexKey struct {
 	// APIKey is the authentication key for accessing Codex API services.
 	APIKey string `yaml:"api-key" json:"api-key"`
 
+	// APIKeyPool lists further keys sharing this entry's settings. Each key becomes its own
+	// credential, so requests rotate across the pool and a rate-limited key is parked alone.
+	APIKeyPool []string `yaml:"api-key-pool,omitempty" json:"api-key-pool,omitempty"`
+
 	// Prefix optionally namespaces models for this credential (e.g., "teamA/gpt-5-codex").
 	Prefix string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
 
@@ -637,6 +1943,24 @@ func (cfg *Config) SanitizeCodexKeys() {
 	cfg.CodexKey = out
 }
 
+// PoolKeys returns the distinct non-empty keys of primary followed by pool.
+func PoolKeys(primary string, pool []string) []string {
+	out := make([]string, 0, 1+len(pool))
+	seen := make(map[string]struct{}, 1+len(pool))
+	for _, key := range append([]string{primary}, pool...) {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, key)
+	}
+	return out
+}
+
 // SanitizeClaudeKeys normalizes headers for Claude credentials.
 func (cfg *Config) SanitizeClaudeKeys() {
 	if cfg == nil || len(cfg.ClaudeKey) == 0 {
@@ -830,6 +2154,10 @@ func SaveConfigPreserveComments(configFile string, cfg *Config) error {
 	removeLegacyGenerativeLanguageKeys(original.Content[0])
 
 	pruneMappingToGeneratedKeys(original.Content[0], generated.Content[0], "oauth-excluded-models")
+	// Per-key maps drop entries for keys removed at runtime, e.g. revoked API keys.
+	for _, path := range perKeyMappingPaths {
+		pruneNestedMappingToGeneratedKeys(original.Content[0], generated.Content[0], path...)
+	}
 
 	// Merge generated into original in-place, preserving comments/order of existing nodes.
 	mergeMappingPreserve(original.Content[0], generated.Content[0])
@@ -918,6 +2246,65 @@ func SaveConfigPreserveCommentsUpdateNestedScalar(configFile string, path []stri
 	return err
 }
 
+// RestoreSectionsPreserveComments sets the top-level sections keys of
+// configFile to their content in baseline, removing those baseline lacks,
+// while preserving comments and every other section. It reports whether the
+// file changed.
+func RestoreSectionsPreserveComments(configFile string, baseline []byte, keys []string) (bool, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return false, err
+	}
+	var root, base yaml.Node
+	if err = yaml.Unmarshal(data, &root); err != nil {
+		return false, err
+	}
+	if err = yaml.Unmarshal(baseline, &base); err != nil {
+		return false, err
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return false, fmt.Errorf("expected root mapping node")
+	}
+	var baseRoot *yaml.Node
+	if base.Kind == yaml.DocumentNode && len(base.Content) > 0 {
+		baseRoot = base.Content[0]
+	}
+	node := root.Content[0]
+	changed := false
+	for _, key := range keys {
+		current := findMapKeyIndex(node, key)
+		previous := findMapKeyIndex(baseRoot, key)
+		switch {
+		case previous < 0 && current >= 0:
+			removeMapKey(node, key)
+			changed = true
+		case previous >= 0 && current < 0:
+			node.Content = append(node.Content, deepCopyNode(baseRoot.Content[previous]), deepCopyNode(baseRoot.Content[previous+1]))
+			changed = true
+		case previous >= 0 && !nodesStructurallyEqual(node.Content[current+1], baseRoot.Content[previous+1]):
+			node.Content[current+1] = deepCopyNode(baseRoot.Content[previous+1])
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err = enc.Encode(&root); err != nil {
+		_ = enc.Close()
+		return false, err
+	}
+	if err = enc.Close(); err != nil {
+		return false, err
+	}
+	if err = os.WriteFile(configFile, NormalizeCommentIndentation(buf.Bytes()), 0o644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // NormalizeCommentIndentation removes indentation from standalone YAML comment lines to keep them left aligned.
 func NormalizeCommentIndentation(data []byte) []byte {
 	lines := bytes.Split(data, []byte("\n"))
@@ -1343,6 +2730,36 @@ func pruneMappingToGeneratedKeys(dstRoot, srcRoot *yaml.Node, key string) {
 	pruneMissingMapKeys(dstVal, srcVal)
 }
 
+// perKeyMappingPaths lists the mappings keyed by client API key.
+var perKeyMappingPaths = [][]string{
+	{"api-key-lifecycle", "expiring"},
+	{"client-limits", "keys"},
+	{"spend", "keys"},
+	{"cost-ceiling", "keys"},
+	{"latency-budget", "keys"},
+	{"fair-share", "key-tiers"},
+	{"device-binding", "trusted-cidrs-by-key"},
+}
+
+// pruneNestedMappingToGeneratedKeys applies pruneMappingToGeneratedKeys to the
+// mapping at path, e.g. {"spend", "keys"}.
+func pruneNestedMappingToGeneratedKeys(dstRoot, srcRoot *yaml.Node, path ...string) {
+	if len(path) == 0 {
+		return
+	}
+	for _, key := range path[:len(path)-1] {
+		if dstRoot == nil || srcRoot == nil || dstRoot.Kind != yaml.MappingNode || srcRoot.Kind != yaml.MappingNode {
+			return
+		}
+		dstIdx, srcIdx := findMapKeyIndex(dstRoot, key), findMapKeyIndex(srcRoot, key)
+		if dstIdx < 0 || srcIdx < 0 || dstIdx+1 >= len(dstRoot.Content) || srcIdx+1 >= len(srcRoot.Content) {
+			return
+		}
+		dstRoot, srcRoot = dstRoot.Content[dstIdx+1], srcRoot.Content[srcIdx+1]
+	}
+	pruneMappingToGeneratedKeys(dstRoot, srcRoot, path[len(path)-1])
+}
+
 func pruneMissingMapKeys(dstMap, srcMap *yaml.Node) {
 	if dstMap == nil || srcMap == nil || dstMap.Kind != yaml.MappingNode || srcMap.Kind != yaml.MappingNode {
 		return
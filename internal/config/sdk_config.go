@@ -37,6 +37,19 @@ type StreamingConfig struct {
 	// to allow auth rotation / transient recovery.
 	// <= 0 disables bootstrap retries. Default is 0.
 	BootstrapRetries int `yaml:"bootstrap-retries,omitempty" json:"bootstrap-retries,omitempty"`
+
+	// WriteTimeoutSeconds bounds how long writing and flushing one chunk to the client may block.
+	// A client that stops reading for longer is treated as disconnected and the upstream request
+	// is cancelled. <= 0 disables the timeout. Default is 0.
+	WriteTimeoutSeconds int `yaml:"write-timeout-seconds,omitempty" json:"write-timeout-seconds,omitempty"`
+
+	// FlushIntervalMs coalesces chunks and flushes at most once per interval, trading latency for
+	// fewer writes on streams with many small events. <= 0 flushes after every chunk. Default is 0.
+	FlushIntervalMs int `yaml:"flush-interval-ms,omitempty" json:"flush-interval-ms,omitempty"`
+
+	// OverloadedRetries controls how many times a stream that failed with an upstream overloaded
+	// error before delivering any tokens is retried from scratch. Default is 2; < 0 disables.
+	OverloadedRetries int `yaml:"overloaded-retries,omitempty" json:"overloaded-retries,omitempty"`
 }
 
 // AccessConfig groups request authentication providers.
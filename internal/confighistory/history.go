@@ -0,0 +1,130 @@
+// Package confighistory keeps the last applied versions of the config file in
+// memory so administrators can inspect what a hot-reload changed and revert a
+// bad one through the management API.
+package confighistory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMaxVersions is how many applied configurations are kept by default.
+const DefaultMaxVersions = 10
+
+// Version describes one applied configuration.
+type Version struct {
+	Version   int       `json:"version"`
+	AppliedAt time.Time `json:"applied_at"`
+	SHA256    string    `json:"sha256"`
+	// Diff is a unified diff against the previous kept version; empty for the oldest.
+	Diff string `json:"diff,omitempty"`
+}
+
+type entry struct {
+	version   int
+	appliedAt time.Time
+	hash      string
+	content   []byte
+}
+
+// History is a bounded, in-memory list of applied configurations.
+type History struct {
+	mu      sync.RWMutex
+	max     int
+	next    int
+	entries []entry
+}
+
+// New creates a history that keeps up to maxVersions configurations.
+func New(maxVersions int) *History {
+	if maxVersions <= 0 {
+		maxVersions = DefaultMaxVersions
+	}
+	return &History{max: maxVersions, next: 1}
+}
+
+// SetMaxVersions changes how many versions are kept, dropping the oldest ones.
+func (h *History) SetMaxVersions(maxVersions int) {
+	if maxVersions <= 0 {
+		maxVersions = DefaultMaxVersions
+	}
+	h.mu.Lock()
+	h.max = maxVersions
+	h.trimLocked()
+	h.mu.Unlock()
+}
+
+// Record stores content as the newest applied version unless it is identical
+// to the current one. It reports the version number and whether it was added.
+func (h *History) Record(content []byte, appliedAt time.Time) (int, bool) {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n := len(h.entries); n > 0 && h.entries[n-1].hash == hash {
+		return h.entries[n-1].version, false
+	}
+	version := h.next
+	h.next++
+	h.entries = append(h.entries, entry{
+		version:   version,
+		appliedAt: appliedAt,
+		hash:      hash,
+		content:   append([]byte(nil), content...),
+	})
+	h.trimLocked()
+	return version, true
+}
+
+func (h *History) trimLocked() {
+	if drop := len(h.entries) - h.max; drop > 0 {
+		h.entries = append([]entry(nil), h.entries[drop:]...)
+	}
+}
+
+// Current returns the number of the newest version, or 0 when none is recorded.
+func (h *History) Current() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.entries) == 0 {
+		return 0
+	}
+	return h.entries[len(h.entries)-1].version
+}
+
+// List returns the kept versions, newest first, each with its diff against
+// the version applied before it.
+func (h *History) List() []Version {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]Version, 0, len(h.entries))
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		e := h.entries[i]
+		v := Version{Version: e.version, AppliedAt: e.appliedAt, SHA256: e.hash}
+		if i > 0 {
+			prev := h.entries[i-1]
+			v.Diff = Diff(versionName(prev.version), versionName(e.version), prev.content, e.content)
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// Get returns the content of a kept version.
+func (h *History) Get(version int) ([]byte, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, e := range h.entries {
+		if e.version == version {
+			return append([]byte(nil), e.content...), true
+		}
+	}
+	return nil, false
+}
+
+func versionName(version int) string {
+	return "version " + strconv.Itoa(version)
+}
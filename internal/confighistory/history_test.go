@@ -0,0 +1,55 @@
+package confighistory
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordSkipsUnchangedAndTrimsOldest(t *testing.T) {
+	h := New(2)
+	now := time.Unix(1_700_000_000, 0)
+	if v, added := h.Record([]byte("port: 1\n"), now); v != 1 || !added {
+		t.Fatalf("first record = %d, %v", v, added)
+	}
+	if v, added := h.Record([]byte("port: 1\n"), now); v != 1 || added {
+		t.Fatalf("unchanged record = %d, %v", v, added)
+	}
+	h.Record([]byte("port: 2\n"), now)
+	h.Record([]byte("port: 3\n"), now)
+
+	if _, ok := h.Get(1); ok {
+		t.Fatal("expected version 1 to be dropped")
+	}
+	versions := h.List()
+	if len(versions) != 2 || versions[0].Version != 3 || h.Current() != 3 {
+		t.Fatalf("versions = %+v", versions)
+	}
+	if versions[1].Diff != "" {
+		t.Fatalf("oldest kept version should have no diff, got %q", versions[1].Diff)
+	}
+	content, ok := h.Get(2)
+	if !ok || string(content) != "port: 2\n" {
+		t.Fatalf("Get(2) = %q, %v", content, ok)
+	}
+}
+
+func TestDiffShowsChangedLinesWithContext(t *testing.T) {
+	oldContent := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	newContent := "a\nb\nc\nd\nE\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	want := strings.Join([]string{
+		"--- version 1",
+		"+++ version 2",
+		"@@ -2,7 +2,7 @@",
+		" b", " c", " d", "-e", "+E", " f", " g", " h",
+		"@@ -10,3 +10,4 @@",
+		" j", " k", " l", "+m",
+		"",
+	}, "\n")
+	if got := Diff("version 1", "version 2", []byte(oldContent), []byte(newContent)); got != want {
+		t.Fatalf("diff:\n%s\nwant:\n%s", got, want)
+	}
+	if got := Diff("a", "b", []byte(oldContent), []byte(oldContent)); got != "" {
+		t.Fatalf("expected no diff for equal content, got %q", got)
+	}
+}
@@ -0,0 +1,479 @@
+// Package contentfilter runs per key group filter chains over the prompts sent
+// to the proxy and the responses it returns. Rules match text with regular
+// expressions, word blocklists or Go plugins registered through
+// sdk/cliproxy/contentfilter, and either redact the matches or block the
+// request, so compliance deployments can keep sensitive data away from
+// upstream models and out of generated output.
+package contentfilter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	sdkfilter "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/contentfilter"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// MetadataKey is the key metadata attribute naming the filter group of an API key.
+const MetadataKey = "filter-group"
+
+const (
+	defaultGroup       = "default"
+	defaultReplacement = "[REDACTED]"
+	actionBlock        = "block"
+	actionRedact       = "redact"
+)
+
+// skipFields are JSON fields that carry protocol values rather than content and are never filtered.
+var skipFields = map[string]bool{
+	"model": true, "role": true, "type": true, "id": true, "object": true, "event": true,
+	"signature": true, "tool_use_id": true, "tool_call_id": true, "stop_reason": true,
+	"finish_reason": true, "media_type": true, "mime_type": true, "mimeType": true, "data": true,
+}
+
+var matches = metrics.Default().NewCounterVec(
+	"cliproxy_content_filter_matches_total",
+	"Content filter rule matches, by group, rule, direction and action (redact or block).",
+	"group", "rule", "direction", "action",
+)
+
+type rule struct {
+	name        string
+	filter      sdkfilter.Filter
+	plugin      string
+	block       bool
+	request     bool
+	response    bool
+	replacement string
+}
+
+// inspect runs the rule's filter. Plugins are looked up on use so they may be
+// registered after the configuration is loaded.
+func (r *rule) inspect(ctx context.Context, direction sdkfilter.Direction, text string) []sdkfilter.Match {
+	filter := r.filter
+	if filter == nil {
+		var ok bool
+		if filter, ok = sdkfilter.Lookup(r.plugin); !ok {
+			log.Debugf("content-filters: rule %q references unregistered plugin %q", r.name, r.plugin)
+			return nil
+		}
+	}
+	return filter.Inspect(ctx, direction, text)
+}
+
+func (r *rule) applies(direction sdkfilter.Direction) bool {
+	if direction == sdkfilter.Request {
+		return r.request
+	}
+	return r.response
+}
+
+type group struct {
+	name     string
+	rules    []rule
+	request  bool
+	response bool
+}
+
+// Chain resolves API keys to filter groups and applies them.
+type Chain struct {
+	mu      sync.RWMutex
+	enabled bool
+	byKey   map[string]*group
+	byName  map[string]*group
+}
+
+// New creates a chain from configuration.
+func New(cfg config.ContentFiltersConfig) *Chain {
+	ch := &Chain{}
+	ch.Update(cfg)
+	return ch
+}
+
+// Update replaces the configuration. Invalid rules are logged and skipped.
+func (ch *Chain) Update(cfg config.ContentFiltersConfig) {
+	byKey := make(map[string]*group)
+	byName := make(map[string]*group, len(cfg.Groups))
+	for _, gc := range cfg.Groups {
+		name := strings.TrimSpace(gc.Name)
+		g := &group{name: name}
+		for _, rc := range gc.Rules {
+			r, err := compileRule(rc)
+			if err != nil {
+				log.Warnf("content-filters: group %q: ignoring rule %q: %v", name, rc.Name, err)
+				continue
+			}
+			g.rules = append(g.rules, r)
+			g.request = g.request || r.request
+			g.response = g.response || r.response
+		}
+		if name != "" {
+			byName[strings.ToLower(name)] = g
+		}
+		for _, key := range gc.APIKeys {
+			if key = strings.TrimSpace(key); key != "" {
+				byKey[key] = g
+			}
+		}
+	}
+	ch.mu.Lock()
+	ch.enabled = cfg.Enabled
+	ch.byKey = byKey
+	ch.byName = byName
+	ch.mu.Unlock()
+}
+
+func compileRule(rc config.ContentFilterRule) (rule, error) {
+	r := rule{name: strings.TrimSpace(rc.Name), replacement: rc.Replacement}
+	if r.replacement == "" {
+		r.replacement = defaultReplacement
+	}
+	switch strings.ToLower(strings.TrimSpace(rc.Action)) {
+	case "", actionRedact:
+	case actionBlock:
+		r.block = true
+	default:
+		return r, fmt.Errorf("unknown action %q", rc.Action)
+	}
+	switch strings.ToLower(strings.TrimSpace(rc.Direction)) {
+	case "", "both":
+		r.request, r.response = true, true
+	case string(sdkfilter.Request):
+		r.request = true
+	case string(sdkfilter.Response):
+		r.response = true
+	default:
+		return r, fmt.Errorf("unknown direction %q", rc.Direction)
+	}
+
+	pattern := strings.TrimSpace(rc.Pattern)
+	var words []string
+	for _, w := range rc.Words {
+		if w = strings.TrimSpace(w); w != "" {
+			words = append(words, regexp.QuoteMeta(w))
+		}
+	}
+	plugin := strings.TrimSpace(rc.Plugin)
+	set := 0
+	for _, present := range []bool{pattern != "", len(words) > 0, plugin != ""} {
+		if present {
+			set++
+		}
+	}
+	if set != 1 {
+		return r, errors.New("exactly one of pattern, words or plugin is required")
+	}
+	if len(words) > 0 {
+		pattern = `(?i)\b(?:` + strings.Join(words, "|") + `)\b`
+	}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return r, err
+		}
+		r.filter = regexpFilter{re}
+	}
+	r.plugin = plugin
+	if r.name == "" {
+		r.name = firstNonEmpty(plugin, rc.Pattern, "words")
+	}
+	return r, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+type regexpFilter struct{ re *regexp.Regexp }
+
+func (f regexpFilter) Inspect(_ context.Context, _ sdkfilter.Direction, text string) []sdkfilter.Match {
+	indexes := f.re.FindAllStringIndex(text, -1)
+	if len(indexes) == 0 {
+		return nil
+	}
+	out := make([]sdkfilter.Match, len(indexes))
+	for i, idx := range indexes {
+		out[i] = sdkfilter.Match{Start: idx[0], End: idx[1]}
+	}
+	return out
+}
+
+// resolve returns the filter group of an API key: an explicit key list wins over
+// the "filter-group" metadata attribute, and the "default" group covers the rest.
+func (ch *Chain) resolve(apiKey string, metadata map[string]string) *group {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	if !ch.enabled || apiKey == "" {
+		return nil
+	}
+	if g, ok := ch.byKey[apiKey]; ok {
+		return g
+	}
+	if name := strings.ToLower(strings.TrimSpace(metadata[MetadataKey])); name != "" {
+		if g, ok := ch.byName[name]; ok {
+			return g
+		}
+	}
+	return ch.byName[defaultGroup]
+}
+
+// Middleware filters the request body before it reaches the handler and the
+// response body as it is written. It must run after authentication and device
+// binding so the key's group can be resolved.
+func (ch *Chain) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		metadata, _ := c.Value(device.MetadataContextKey).(map[string]string)
+		g := ch.resolve(c.GetString("apiKey"), metadata)
+		if g == nil || len(g.rules) == 0 {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		if g.request && c.Request.Body != nil && c.Request.Method != http.MethodGet {
+			body, err := io.ReadAll(c.Request.Body)
+			_ = c.Request.Body.Close()
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"error":   "invalid_request",
+					"message": "Failed to read request body: " + err.Error(),
+				})
+				return
+			}
+			filtered, blocked := g.filterJSON(ctx, sdkfilter.Request, body)
+			if blocked != nil {
+				c.AbortWithStatusJSON(http.StatusForbidden, blockedBody(blocked, sdkfilter.Request))
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(filtered))
+			c.Request.ContentLength = int64(len(filtered))
+			c.Request.Header.Set("Content-Length", strconv.Itoa(len(filtered)))
+		}
+		if g.response {
+			c.Writer = &filterWriter{ResponseWriter: c.Writer, ctx: ctx, group: g}
+		}
+		c.Next()
+	}
+}
+
+func blockedBody(r *rule, direction sdkfilter.Direction) gin.H {
+	message := "Request blocked by content filter " + strconv.Quote(r.name)
+	if direction == sdkfilter.Response {
+		message = "Response blocked by content filter " + strconv.Quote(r.name)
+	}
+	return gin.H{"error": "content_blocked", "message": message, "rule": r.name}
+}
+
+// apply runs the group's rules for one direction over text. It returns the
+// redacted text, or the rule that blocks it.
+func (g *group) apply(ctx context.Context, direction sdkfilter.Direction, text string) (string, *rule) {
+	for i := range g.rules {
+		r := &g.rules[i]
+		if !r.applies(direction) {
+			continue
+		}
+		found := r.inspect(ctx, direction, text)
+		if len(found) == 0 {
+			continue
+		}
+		if r.block {
+			matches.Inc(g.name, r.name, string(direction), actionBlock)
+			return text, r
+		}
+		matches.Inc(g.name, r.name, string(direction), actionRedact)
+		text = redact(text, found, r.replacement)
+	}
+	return text, nil
+}
+
+// redact replaces the matched ranges of text, merging overlaps and ignoring invalid ranges.
+func redact(text string, found []sdkfilter.Match, replacement string) string {
+	sort.Slice(found, func(i, j int) bool { return found[i].Start < found[j].Start })
+	var sb strings.Builder
+	pos := 0
+	for _, m := range found {
+		if m.End > len(text) {
+			m.End = len(text)
+		}
+		if m.Start < pos {
+			m.Start = pos
+		}
+		if m.Start >= m.End {
+			continue
+		}
+		sb.WriteString(text[pos:m.Start])
+		sb.WriteString(replacement)
+		pos = m.End
+	}
+	sb.WriteString(text[pos:])
+	return sb.String()
+}
+
+// filterJSON applies the group to every content string of a JSON document.
+// Bodies that are not JSON are returned unchanged.
+func (g *group) filterJSON(ctx context.Context, direction sdkfilter.Direction, body []byte) ([]byte, *rule) {
+	if !gjson.ValidBytes(body) {
+		return body, nil
+	}
+	type edit struct{ path, value string }
+	var edits []edit
+	var blocked *rule
+	walkStrings(gjson.ParseBytes(body), "", func(path, value string) bool {
+		filtered, r := g.apply(ctx, direction, value)
+		if r != nil {
+			blocked = r
+			return false
+		}
+		if filtered != value {
+			edits = append(edits, edit{path, filtered})
+		}
+		return true
+	})
+	if blocked != nil {
+		return body, blocked
+	}
+	for _, e := range edits {
+		if updated, err := sjson.SetBytes(body, e.path, e.value); err == nil {
+			body = updated
+		}
+	}
+	return body, nil
+}
+
+// walkStrings calls visit with the sjson path of every string value outside
+// skipFields until visit returns false.
+func walkStrings(r gjson.Result, path string, visit func(path, value string) bool) bool {
+	switch {
+	case r.IsObject():
+		ok := true
+		r.ForEach(func(key, value gjson.Result) bool {
+			if skipFields[key.String()] && value.Type == gjson.String {
+				return true
+			}
+			ok = walkStrings(value, joinPath(path, escapePath(key.String())), visit)
+			return ok
+		})
+		return ok
+	case r.IsArray():
+		ok := true
+		i := 0
+		r.ForEach(func(_, value gjson.Result) bool {
+			ok = walkStrings(value, joinPath(path, strconv.Itoa(i)), visit)
+			i++
+			return ok
+		})
+		return ok
+	case r.Type == gjson.String:
+		return visit(path, r.Str)
+	}
+	return true
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+var pathEscaper = strings.NewReplacer(`\`, `\\`, ".", `\.`, "*", `\*`, "?", `\?`, "|", `\|`, "#", `\#`, "@", `\@`)
+
+func escapePath(key string) string { return pathEscaper.Replace(key) }
+
+// filterChunk filters a written response chunk: a whole JSON document, or
+// SSE/NDJSON lines whose payload is JSON. Other content passes unchanged.
+func (g *group) filterChunk(ctx context.Context, data []byte) ([]byte, *rule) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && gjson.ValidBytes(trimmed) {
+		return g.filterJSON(ctx, sdkfilter.Response, data)
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	changed := false
+	for i, line := range lines {
+		prefix, payload := []byte(nil), line
+		if rest, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			prefix, payload = line[:len(line)-len(rest)], rest
+		}
+		trimmed := bytes.TrimSpace(payload)
+		if len(trimmed) == 0 || trimmed[0] != '{' {
+			continue
+		}
+		filtered, blocked := g.filterJSON(ctx, sdkfilter.Response, payload)
+		if blocked != nil {
+			return data, blocked
+		}
+		if !bytes.Equal(filtered, payload) {
+			lines[i] = append(append([]byte(nil), prefix...), filtered...)
+			changed = true
+		}
+	}
+	if !changed {
+		return data, nil
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+// filterWriter filters response chunks as they are written. A blocked response
+// that has not started is replaced by a 403; a started stream is ended with an
+// SSE error event and the rest of it is discarded.
+type filterWriter struct {
+	gin.ResponseWriter
+	ctx     context.Context
+	group   *group
+	blocked bool
+}
+
+func (w *filterWriter) Write(data []byte) (int, error) {
+	if w.blocked {
+		return len(data), nil
+	}
+	out, blocked := w.group.filterChunk(w.ctx, data)
+	if blocked != nil {
+		w.blocked = true
+		payload, _ := json.Marshal(blockedBody(blocked, sdkfilter.Response))
+		if !w.ResponseWriter.Written() {
+			w.Header().Del("Content-Length")
+			w.Header().Set("Content-Type", "application/json")
+			w.ResponseWriter.WriteHeader(http.StatusForbidden)
+			_, err := w.ResponseWriter.Write(payload)
+			return len(data), err
+		}
+		_, err := w.ResponseWriter.Write([]byte("event: error\ndata: " + string(payload) + "\n\n"))
+		return len(data), err
+	}
+	if len(out) != len(data) && !w.ResponseWriter.Written() {
+		w.Header().Del("Content-Length")
+	}
+	if _, err := w.ResponseWriter.Write(out); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (w *filterWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *filterWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,103 @@
+package contentfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	sdkfilter "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/contentfilter"
+)
+
+func newTestEngine(ch *Chain, respond func(c *gin.Context, body string)) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("X-Test-Key"))
+		c.Next()
+	})
+	engine.Use(ch.Middleware())
+	engine.POST("/v1/messages", func(c *gin.Context) {
+		raw, _ := c.GetRawData()
+		respond(c, string(raw))
+	})
+	return engine
+}
+
+func post(engine *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("X-Test-Key", key)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRequestsAreRedactedOrBlockedPerGroup(t *testing.T) {
+	sdkfilter.Register("test-secrets", sdkfilter.FilterFunc(func(_ context.Context, _ sdkfilter.Direction, text string) []sdkfilter.Match {
+		if i := strings.Index(text, "hunter2"); i >= 0 {
+			return []sdkfilter.Match{{Start: i, End: i + len("hunter2")}}
+		}
+		return nil
+	}))
+	ch := New(config.ContentFiltersConfig{Enabled: true, Groups: []config.ContentFilterGroup{
+		{Name: "default", Rules: []config.ContentFilterRule{
+			{Name: "ssn", Pattern: `\b\d{3}-\d{2}-\d{4}\b`, Replacement: "[SSN]"},
+			{Name: "secrets", Plugin: "test-secrets"},
+		}},
+		{Name: "strict", APIKeys: []string{"strict-key"}, Rules: []config.ContentFilterRule{
+			{Name: "codenames", Words: []string{"Project-Falcon"}, Action: "block", Direction: "request"},
+		}},
+	}})
+	var seen string
+	engine := newTestEngine(ch, func(c *gin.Context, body string) {
+		seen = body
+		c.Status(http.StatusOK)
+	})
+
+	body := `{"model":"claude","messages":[{"role":"user","content":[{"type":"text","text":"ssn 123-45-6789, pw hunter2"}]}]}`
+	if rec := post(engine, "k1", body); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if want := `{"model":"claude","messages":[{"role":"user","content":[{"type":"text","text":"ssn [SSN], pw [REDACTED]"}]}]}`; seen != want {
+		t.Fatalf("unexpected filtered body %s", seen)
+	}
+
+	rec := post(engine, "strict-key", `{"model":"claude","messages":[{"role":"user","content":"status of project-falcon?"}]}`)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "content_blocked") {
+		t.Fatalf("expected the strict group to block, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = post(engine, "strict-key", `{"model":"claude","messages":[{"role":"user","content":"ssn 123-45-6789"}]}`); rec.Code != http.StatusOK || !strings.Contains(seen, "123-45-6789") {
+		t.Fatalf("expected only the strict group's rules to apply, got %d %s", rec.Code, seen)
+	}
+}
+
+func TestResponsesAreFilteredAsTheyAreWritten(t *testing.T) {
+	ch := New(config.ContentFiltersConfig{Enabled: true, Groups: []config.ContentFilterGroup{
+		{Name: "default", Rules: []config.ContentFilterRule{
+			{Name: "email", Pattern: `[\w.]+@example\.com`, Direction: "response"},
+			{Name: "forbidden", Words: []string{"classified"}, Action: "block", Direction: "response"},
+		}},
+	}})
+	engine := newTestEngine(ch, func(c *gin.Context, body string) {
+		c.Header("Content-Type", "text/event-stream")
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.Write([]byte("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"mail bob@example.com\"}}\n\n"))
+		if strings.Contains(body, "leak") {
+			_, _ = c.Writer.Write([]byte("data: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"the classified plan\"}}\n\n"))
+			_, _ = c.Writer.Write([]byte("data: {\"type\":\"message_stop\"}\n\n"))
+		}
+	})
+
+	rec := post(engine, "k1", `{}`)
+	if got := rec.Body.String(); strings.Contains(got, "bob@example.com") || !strings.Contains(got, `"text":"mail [REDACTED]"`) || !strings.HasPrefix(got, "event: content_block_delta\n") {
+		t.Fatalf("unexpected stream %q", got)
+	}
+	rec = post(engine, "k1", `{"leak":true}`)
+	got := rec.Body.String()
+	if strings.Contains(got, "classified") || strings.Contains(got, "message_stop") || !strings.Contains(got, "event: error\ndata: {") || !strings.Contains(got, "content_blocked") {
+		t.Fatalf("expected the stream to end with a content_blocked event, got %q", got)
+	}
+}
@@ -0,0 +1,248 @@
+// Package costceiling caps the worst-case cost of a single request per client
+// key. The worst case is the requested maximum output tokens times the model's
+// output price; requests that could exceed the ceiling are rejected or have
+// their max tokens clamped, so one runaway request cannot use up a day's budget.
+package costceiling
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Modes
+const (
+	ModeReject = "reject"
+	ModeClamp  = "clamp"
+)
+
+// maxTokenFields are the request fields carrying the output token limit, by API format.
+var maxTokenFields = []string{"max_tokens", "max_completion_tokens", "max_output_tokens", "generationConfig.maxOutputTokens"}
+
+var decisions = metrics.Default().NewCounterVec(
+	"cliproxy_cost_ceiling_decisions_total",
+	"Requests affected by the per-request cost ceiling, by action (rejected or clamped).",
+	"action",
+)
+
+// Ceiling enforces per-request cost ceilings.
+type Ceiling struct {
+	mu       sync.RWMutex
+	enabled  bool
+	clamp    bool
+	maxCost  float64
+	keys     map[string]float64
+	exact    map[string]float64
+	prefixes []pricePrefix
+	fallback float64
+}
+
+type pricePrefix struct {
+	prefix string
+	price  float64
+}
+
+// New creates a ceiling from configuration.
+func New(cfg config.CostCeilingConfig) *Ceiling {
+	c := &Ceiling{}
+	c.Update(cfg)
+	return c
+}
+
+// Update replaces the configuration.
+func (c *Ceiling) Update(cfg config.CostCeilingConfig) {
+	exact := make(map[string]float64)
+	var prefixes []pricePrefix
+	fallback := 0.0
+	for model, price := range cfg.OutputPrices {
+		model = strings.ToLower(strings.TrimSpace(model))
+		switch {
+		case model == "*":
+			fallback = price
+		case strings.HasSuffix(model, "*"):
+			prefixes = append(prefixes, pricePrefix{prefix: strings.TrimSuffix(model, "*"), price: price})
+		case model != "":
+			exact[model] = price
+		}
+	}
+	// Longest prefix wins.
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i].prefix) > len(prefixes[j].prefix) })
+
+	c.mu.Lock()
+	c.enabled = cfg.Enabled
+	c.clamp = strings.EqualFold(strings.TrimSpace(cfg.Mode), ModeClamp)
+	c.maxCost = cfg.MaxCost
+	c.keys = cfg.Keys
+	c.exact = exact
+	c.prefixes = prefixes
+	c.fallback = fallback
+	c.mu.Unlock()
+}
+
+// Price returns the output price in USD per million tokens for a model, or 0 when unknown.
+func (c *Ceiling) Price(model string) float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.priceLocked(model)
+}
+
+func (c *Ceiling) priceLocked(model string) float64 {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if price, ok := c.exact[model]; ok {
+		return price
+	}
+	for _, p := range c.prefixes {
+		if strings.HasPrefix(model, p.prefix) {
+			return p.price
+		}
+	}
+	return c.fallback
+}
+
+// MaxCost returns the per-request ceiling in USD applied to a key, whether it is
+// a per-key override ("key") or the default ("default"), and the mode.
+func (c *Ceiling) MaxCost(apiKey string) (float64, string, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	mode := ModeReject
+	if c.clamp {
+		mode = ModeClamp
+	}
+	if v, ok := c.keys[apiKey]; ok {
+		return v, "key", mode
+	}
+	return c.maxCost, "default", mode
+}
+
+// Enabled reports whether the ceiling is enforced.
+func (c *Ceiling) Enabled() bool {
+	enabled, _ := c.state()
+	return enabled
+}
+
+// MaxTokens returns the largest output token count the key may request for the model.
+// The second value is false when no ceiling applies.
+func (c *Ceiling) MaxTokens(apiKey, model string) (int64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.enabled {
+		return 0, false
+	}
+	ceiling := c.maxCost
+	if v, ok := c.keys[apiKey]; ok {
+		ceiling = v
+	}
+	price := c.priceLocked(model)
+	if ceiling <= 0 || price <= 0 {
+		return 0, false
+	}
+	return int64(math.Floor(ceiling / price * 1e6)), true
+}
+
+func (c *Ceiling) state() (enabled, clamp bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.enabled, c.clamp
+}
+
+// Middleware checks the requested max output tokens against the key's ceiling.
+func (c *Ceiling) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		apiKey := ctx.GetString("apiKey")
+		enabled, clamp := c.state()
+		if !enabled || apiKey == "" || ctx.Request.Method != http.MethodPost || ctx.Request.Body == nil {
+			ctx.Next()
+			return
+		}
+		body, err := io.ReadAll(ctx.Request.Body)
+		_ = ctx.Request.Body.Close()
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil || !gjson.ValidBytes(body) {
+			ctx.Next()
+			return
+		}
+
+		model := requestModel(ctx, body)
+		limit, ok := c.MaxTokens(apiKey, model)
+		if !ok {
+			ctx.Next()
+			return
+		}
+		field, requested := requestedMaxTokens(body)
+		if field != "" && requested <= limit && limit > 0 {
+			ctx.Next()
+			return
+		}
+		if limit <= 0 || !clamp {
+			if field == "" && limit > 0 {
+				// The worst case is unknown; only clamp mode bounds such requests.
+				ctx.Next()
+				return
+			}
+			decisions.Inc("rejected")
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":   "cost_ceiling_exceeded",
+				"message": "Requested max tokens exceed the per-request cost ceiling for this API key; request at most " + strconv.FormatInt(limit, 10) + " output tokens for " + model,
+			})
+			return
+		}
+
+		if field == "" {
+			field = defaultMaxTokensField(ctx.Request.URL.Path)
+		}
+		if updated, errSet := sjson.SetBytes(body, field, limit); errSet == nil {
+			body = updated
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+		ctx.Request.ContentLength = int64(len(body))
+		ctx.Request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		ctx.Header("X-Max-Tokens-Clamped", strconv.FormatInt(limit, 10))
+		decisions.Inc("clamped")
+		ctx.Next()
+	}
+}
+
+// requestModel reads the model from the body or, for Gemini routes, the URL action.
+func requestModel(ctx *gin.Context, body []byte) string {
+	if model := gjson.GetBytes(body, "model").String(); model != "" {
+		return model
+	}
+	action := strings.TrimPrefix(ctx.Param("action"), "/")
+	if name, _, found := strings.Cut(action, ":"); found {
+		return name
+	}
+	return action
+}
+
+// requestedMaxTokens returns the field holding the output token limit and its value.
+func requestedMaxTokens(body []byte) (string, int64) {
+	for _, field := range maxTokenFields {
+		if v := gjson.GetBytes(body, field); v.Exists() {
+			return field, v.Int()
+		}
+	}
+	return "", 0
+}
+
+// defaultMaxTokensField is the field set when clamping a request that has none.
+func defaultMaxTokensField(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/v1beta/"):
+		return "generationConfig.maxOutputTokens"
+	case strings.HasSuffix(path, "/responses"):
+		return "max_output_tokens"
+	default:
+		return "max_tokens"
+	}
+}
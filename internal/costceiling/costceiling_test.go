@@ -0,0 +1,84 @@
+package costceiling
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/tidwall/gjson"
+)
+
+func newTestEngine(c *Ceiling, seen *string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(ctx *gin.Context) {
+		ctx.Set("apiKey", ctx.GetHeader("X-Test-Key"))
+		ctx.Next()
+	})
+	engine.Use(c.Middleware())
+	handler := func(ctx *gin.Context) {
+		body, _ := io.ReadAll(ctx.Request.Body)
+		*seen = string(body)
+		ctx.Status(http.StatusOK)
+	}
+	engine.POST("/v1/messages", handler)
+	engine.POST("/v1beta/models/*action", handler)
+	return engine
+}
+
+func doRequest(engine *gin.Engine, path, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("X-Test-Key", key)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCeilingRejectsAndClamps(t *testing.T) {
+	cfg := config.CostCeilingConfig{
+		Enabled: true,
+		MaxCost: 0.75,
+		Keys:    map[string]float64{"big": 7.5},
+		OutputPrices: map[string]float64{
+			"claude-opus-*":  75,
+			"claude-*":       15,
+			"gemini-2.5-pro": 10,
+		},
+	}
+	c := New(cfg)
+	if got := c.Price("claude-opus-4-1"); got != 75 {
+		t.Fatalf("expected longest prefix price, got %v", got)
+	}
+	var seen string
+	engine := newTestEngine(c, &seen)
+
+	// 0.75 USD at 75 USD per million tokens allows 10000 output tokens.
+	if rec := doRequest(engine, "/v1/messages", "k1", `{"model":"claude-opus-4-1","max_tokens":10000}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected request within the ceiling to pass, got %d", rec.Code)
+	}
+	rec := doRequest(engine, "/v1/messages", "k1", `{"model":"claude-opus-4-1","max_tokens":10001}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "cost_ceiling_exceeded") {
+		t.Fatalf("expected rejection, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(engine, "/v1/messages", "big", `{"model":"claude-opus-4-1","max_tokens":100000}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected per-key ceiling to apply, got %d", rec.Code)
+	}
+
+	cfg.Mode = ModeClamp
+	c.Update(cfg)
+	rec = doRequest(engine, "/v1/messages", "k1", `{"model":"claude-opus-4-1","max_tokens":64000}`)
+	if rec.Code != http.StatusOK || gjson.Get(seen, "max_tokens").Int() != 10000 || rec.Header().Get("X-Max-Tokens-Clamped") != "10000" {
+		t.Fatalf("expected clamped max_tokens, got %d %s", rec.Code, seen)
+	}
+	rec = doRequest(engine, "/v1beta/models/gemini-2.5-pro:generateContent", "k1", `{"contents":[]}`)
+	if rec.Code != http.StatusOK || gjson.Get(seen, "generationConfig.maxOutputTokens").Int() != 75000 {
+		t.Fatalf("expected missing Gemini limit to be set, got %d %s", rec.Code, seen)
+	}
+	if rec = doRequest(engine, "/v1/messages", "k1", `{"model":"unpriced","max_tokens":64000}`); rec.Code != http.StatusOK || gjson.Get(seen, "max_tokens").Int() != 64000 {
+		t.Fatalf("expected unpriced model to pass unchanged, got %d %s", rec.Code, seen)
+	}
+}
@@ -0,0 +1,493 @@
+// Package costexport writes LLM usage and cost in the FinOps FOCUS format, so
+// finance teams can load it next to their cloud bills. Usage is aggregated per
+// charge period (hour or day), key, team, provider and model, written to CSV
+// at the end of every period and optionally uploaded to an S3-compatible
+// bucket. Each file holds the usage recorded since the previous export, so
+// files never overlap and can be loaded as they arrive.
+package costexport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// Export schedules
+const (
+	ScheduleHourly = "hourly"
+	ScheduleDaily  = "daily"
+)
+
+const (
+	defaultDirectory        = "cost-exports"
+	defaultBillingAccountID = "cliproxy"
+	defaultTeamMetadataKey  = "team"
+
+	providerName = "CLIProxyAPI"
+	currency     = "USD"
+	fileLayout   = "20060102T150405.000Z"
+)
+
+// Columns are the FOCUS columns of every export, in order.
+var Columns = []string{
+	"BillingAccountId", "BillingPeriodStart", "BillingPeriodEnd",
+	"ChargePeriodStart", "ChargePeriodEnd", "ChargeCategory", "ChargeDescription",
+	"BilledCost", "EffectiveCost", "ListCost", "BillingCurrency",
+	"ConsumedQuantity", "ConsumedUnit", "PricingQuantity", "PricingUnit",
+	"ProviderName", "PublisherName", "InvoiceIssuerName",
+	"ServiceName", "ServiceCategory",
+	"ResourceId", "ResourceName", "ResourceType",
+	"SkuId", "SubAccountId", "SubAccountName", "Tags",
+}
+
+var exports = metrics.Default().NewCounterVec(
+	"cliproxy_cost_exports_total",
+	"Cost export files by outcome (written, write_failed, uploaded, upload_failed).",
+	"outcome",
+)
+
+// Options supplies the data usage is enriched with.
+type Options struct {
+	// Cost prices a request in USD; nil reports a cost of 0.
+	Cost func(model string, inputTokens, outputTokens int64) float64
+	// Metadata returns the admin-defined metadata of a key; may be nil.
+	Metadata func(apiKey string) map[string]string
+}
+
+// Status describes the exporter for the management API.
+type Status struct {
+	Enabled        bool      `json:"enabled"`
+	Schedule       string    `json:"schedule"`
+	Directory      string    `json:"directory"`
+	Bucket         string    `json:"bucket,omitempty"`
+	PendingRows    int       `json:"pending_rows"`
+	PendingUploads []string  `json:"pending_uploads,omitempty"`
+	LastExport     time.Time `json:"last_export,omitempty"`
+	LastFile       string    `json:"last_file,omitempty"`
+	LastError      string    `json:"last_error,omitempty"`
+}
+
+type settings struct {
+	enabled   bool
+	schedule  string
+	period    time.Duration
+	directory string
+	accountID string
+	teamKey   string
+	s3        config.CostExportS3Config
+}
+
+type rowKey struct {
+	chargeStart time.Time
+	keyHash     string
+	maskedKey   string
+	team        string
+	provider    string
+	model       string
+}
+
+type row struct {
+	requests     int64
+	inputTokens  int64
+	outputTokens int64
+	cachedTokens int64
+	totalTokens  int64
+	cost         float64
+}
+
+// Exporter aggregates usage and writes it out on schedule.
+type Exporter struct {
+	opts Options
+	now  func() time.Time
+	wake chan struct{}
+
+	mu         sync.Mutex
+	settings   settings
+	rows       map[rowKey]*row
+	uploader   *uploader
+	uploads    []string
+	lastExport time.Time
+	lastFile   string
+	lastError  string
+
+	// runMu serialises exports.
+	runMu sync.Mutex
+}
+
+// New creates an exporter from configuration.
+func New(cfg config.CostExportConfig, opts Options) *Exporter {
+	e := &Exporter{opts: opts, now: time.Now, wake: make(chan struct{}, 1), rows: make(map[rowKey]*row)}
+	e.Update(cfg)
+	return e
+}
+
+func buildSettings(cfg config.CostExportConfig) settings {
+	applied := settings{
+		enabled:   cfg.Enabled,
+		schedule:  ScheduleDaily,
+		period:    24 * time.Hour,
+		directory: strings.TrimSpace(cfg.Directory),
+		accountID: strings.TrimSpace(cfg.BillingAccountID),
+		teamKey:   strings.TrimSpace(cfg.TeamMetadataKey),
+		s3:        cfg.S3,
+	}
+	if strings.EqualFold(strings.TrimSpace(cfg.Schedule), ScheduleHourly) {
+		applied.schedule, applied.period = ScheduleHourly, time.Hour
+	}
+	if applied.directory == "" {
+		applied.directory = defaultDirectory
+	}
+	if applied.accountID == "" {
+		applied.accountID = defaultBillingAccountID
+	}
+	if applied.teamKey == "" {
+		applied.teamKey = defaultTeamMetadataKey
+	}
+	return applied
+}
+
+// Update replaces the configuration. Aggregated usage is kept and goes out
+// with the next export.
+func (e *Exporter) Update(cfg config.CostExportConfig) {
+	if e == nil {
+		return
+	}
+	applied := buildSettings(cfg)
+	var up *uploader
+	if strings.TrimSpace(cfg.S3.Bucket) != "" {
+		var err error
+		if up, err = newUploader(cfg.S3); err != nil {
+			log.Errorf("cost-export: S3 uploads disabled: %v", err)
+		}
+	}
+	e.mu.Lock()
+	e.settings = applied
+	e.uploader = up
+	e.mu.Unlock()
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// HandleUsage implements coreusage.Plugin and adds a request to its charge period.
+func (e *Exporter) HandleUsage(_ context.Context, record coreusage.Record) {
+	if e == nil || record.APIKey == "" || usage.IsExcludedAPIKey(record.APIKey) {
+		return
+	}
+	e.mu.Lock()
+	applied := e.settings
+	e.mu.Unlock()
+	if !applied.enabled {
+		return
+	}
+	at := record.RequestedAt
+	if at.IsZero() {
+		at = e.now()
+	}
+	var team string
+	if e.opts.Metadata != nil {
+		team = strings.TrimSpace(e.opts.Metadata(record.APIKey)[applied.teamKey])
+	}
+	var cost float64
+	if e.opts.Cost != nil {
+		cost = e.opts.Cost(record.Model, record.Detail.InputTokens, record.Detail.OutputTokens)
+	}
+	sum := sha256.Sum256([]byte(record.APIKey))
+	key := rowKey{
+		chargeStart: at.UTC().Truncate(applied.period),
+		keyHash:     hex.EncodeToString(sum[:]),
+		maskedKey:   device.MaskKey(record.APIKey),
+		team:        team,
+		provider:    record.Provider,
+		model:       record.Model,
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r := e.rows[key]
+	if r == nil {
+		r = &row{}
+		e.rows[key] = r
+	}
+	r.requests++
+	r.inputTokens += record.Detail.InputTokens
+	r.outputTokens += record.Detail.OutputTokens
+	r.cachedTokens += record.Detail.CachedTokens
+	r.totalTokens += totalTokens(record.Detail)
+	r.cost += cost
+}
+
+func totalTokens(detail coreusage.Detail) int64 {
+	if detail.TotalTokens > 0 {
+		return detail.TotalTokens
+	}
+	return detail.InputTokens + detail.OutputTokens
+}
+
+// Export writes the usage aggregated since the previous export to a new CSV
+// file and uploads it, retrying earlier failed uploads too. It returns the
+// file path, or "" when there was nothing to export.
+func (e *Exporter) Export(ctx context.Context) (string, error) {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	e.mu.Lock()
+	applied := e.settings
+	rows := e.rows
+	e.rows = make(map[rowKey]*row)
+	e.mu.Unlock()
+
+	var path string
+	if len(rows) > 0 {
+		var err error
+		path, err = writeFile(applied, rows, e.now().UTC())
+		if err != nil {
+			exports.Inc("write_failed")
+			e.restore(rows)
+			e.fail(err)
+			return "", err
+		}
+		exports.Inc("written")
+		log.Infof("cost-export: wrote %d row(s) to %s", len(rows), path)
+	}
+
+	e.mu.Lock()
+	up := e.uploader
+	if path != "" {
+		e.lastExport, e.lastFile, e.lastError = e.now(), path, ""
+		if up != nil {
+			e.uploads = append(e.uploads, path)
+		}
+	}
+	pending := append([]string(nil), e.uploads...)
+	e.mu.Unlock()
+	if up == nil || len(pending) == 0 {
+		return path, nil
+	}
+
+	var failed []string
+	var firstErr error
+	for _, file := range pending {
+		if err := up.upload(ctx, file); err != nil {
+			exports.Inc("upload_failed")
+			log.Warnf("cost-export: failed to upload %s: %v", file, err)
+			failed = append(failed, file)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		exports.Inc("uploaded")
+	}
+	e.mu.Lock()
+	// Files written while uploading stay queued behind the ones that failed.
+	e.uploads = append(failed, e.uploads[len(pending):]...)
+	if firstErr != nil {
+		e.lastError = "upload: " + firstErr.Error()
+	}
+	e.mu.Unlock()
+	if firstErr != nil {
+		return path, fmt.Errorf("cost-export: upload: %w", firstErr)
+	}
+	return path, nil
+}
+
+// restore puts rows back after a failed write so they go out with the next export.
+func (e *Exporter) restore(rows map[rowKey]*row) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key, r := range rows {
+		current := e.rows[key]
+		if current == nil {
+			e.rows[key] = r
+			continue
+		}
+		current.requests += r.requests
+		current.inputTokens += r.inputTokens
+		current.outputTokens += r.outputTokens
+		current.cachedTokens += r.cachedTokens
+		current.totalTokens += r.totalTokens
+		current.cost += r.cost
+	}
+}
+
+func (e *Exporter) fail(err error) {
+	log.Errorf("cost-export: %v", err)
+	e.mu.Lock()
+	e.lastError = err.Error()
+	e.mu.Unlock()
+}
+
+// writeFile writes rows as a FOCUS CSV file named after the export time.
+func writeFile(applied settings, rows map[rowKey]*row, at time.Time) (string, error) {
+	if err := os.MkdirAll(applied.directory, 0o755); err != nil {
+		return "", fmt.Errorf("create %s: %w", applied.directory, err)
+	}
+	path := filepath.Join(applied.directory, "focus-"+at.Format(fileLayout)+".csv")
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", fmt.Errorf("create %s: %w", tmp, err)
+	}
+	w := csv.NewWriter(f)
+	errWrite := w.Write(Columns)
+	for _, record := range records(applied, rows) {
+		if errWrite != nil {
+			break
+		}
+		errWrite = w.Write(record)
+	}
+	w.Flush()
+	if errWrite == nil {
+		errWrite = w.Error()
+	}
+	if errClose := f.Close(); errWrite == nil {
+		errWrite = errClose
+	}
+	if errWrite != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("write %s: %w", path, errWrite)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("rename %s: %w", tmp, err)
+	}
+	return path, nil
+}
+
+// records renders aggregated usage as FOCUS records in a stable order.
+func records(applied settings, rows map[rowKey]*row) [][]string {
+	keys := make([]rowKey, 0, len(rows))
+	for key := range rows {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if !a.chargeStart.Equal(b.chargeStart) {
+			return a.chargeStart.Before(b.chargeStart)
+		}
+		if a.team != b.team {
+			return a.team < b.team
+		}
+		if a.keyHash != b.keyHash {
+			return a.keyHash < b.keyHash
+		}
+		if a.provider != b.provider {
+			return a.provider < b.provider
+		}
+		return a.model < b.model
+	})
+	out := make([][]string, 0, len(keys))
+	for _, key := range keys {
+		r := rows[key]
+		billingStart := time.Date(key.chargeStart.Year(), key.chargeStart.Month(), 1, 0, 0, 0, 0, time.UTC)
+		tags, _ := json.Marshal(map[string]string{
+			"team":          key.team,
+			"provider":      key.provider,
+			"model":         key.model,
+			"requests":      strconv.FormatInt(r.requests, 10),
+			"input_tokens":  strconv.FormatInt(r.inputTokens, 10),
+			"output_tokens": strconv.FormatInt(r.outputTokens, 10),
+			"cached_tokens": strconv.FormatInt(r.cachedTokens, 10),
+		})
+		cost := strconv.FormatFloat(r.cost, 'f', 6, 64)
+		tokens := strconv.FormatInt(r.totalTokens, 10)
+		out = append(out, []string{
+			applied.accountID,
+			billingStart.Format(time.RFC3339),
+			billingStart.AddDate(0, 1, 0).Format(time.RFC3339),
+			key.chargeStart.Format(time.RFC3339),
+			key.chargeStart.Add(applied.period).Format(time.RFC3339),
+			"Usage",
+			fmt.Sprintf("%d %s request(s) via %s", r.requests, key.model, key.provider),
+			cost, cost, cost, currency,
+			tokens, "Tokens", tokens, "Tokens",
+			providerName, key.provider, providerName,
+			"LLM Inference", "AI and Machine Learning",
+			key.keyHash, key.maskedKey, "API Key",
+			key.model, key.team, key.team, string(tags),
+		})
+	}
+	return out
+}
+
+// Run exports at the end of every period until ctx is done.
+func (e *Exporter) Run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	for {
+		e.mu.Lock()
+		applied := e.settings
+		e.mu.Unlock()
+		now := e.now().UTC()
+		timer := time.NewTimer(now.Truncate(applied.period).Add(applied.period).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-e.wake:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+		if !applied.enabled {
+			continue
+		}
+		if _, err := e.Export(ctx); err != nil {
+			log.Warnf("cost-export: %v", err)
+		}
+	}
+}
+
+// Close writes out the usage aggregated since the last export.
+func (e *Exporter) Close() error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	enabled := e.settings.enabled
+	e.mu.Unlock()
+	if !enabled {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	_, err := e.Export(ctx)
+	return err
+}
+
+// Status returns the exporter state.
+func (e *Exporter) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return Status{
+		Enabled:        e.settings.enabled,
+		Schedule:       e.settings.schedule,
+		Directory:      e.settings.directory,
+		Bucket:         strings.TrimSpace(e.settings.s3.Bucket),
+		PendingRows:    len(e.rows),
+		PendingUploads: append([]string(nil), e.uploads...),
+		LastExport:     e.lastExport,
+		LastFile:       e.lastFile,
+		LastError:      e.lastError,
+	}
+}
@@ -0,0 +1,90 @@
+package costexport
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+func TestExportWritesFOCUSRowsPerKeyTeamAndModel(t *testing.T) {
+	dir := t.TempDir()
+	e := New(config.CostExportConfig{Enabled: true, Schedule: ScheduleHourly, Directory: dir}, Options{
+		Cost: func(model string, in, out int64) float64 { return float64(in+out) / 1000 },
+		Metadata: func(apiKey string) map[string]string {
+			if apiKey == "sk-alice-0000000000" {
+				return map[string]string{"team": "research"}
+			}
+			return nil
+		},
+	})
+	at := time.Date(2026, 3, 4, 10, 15, 0, 0, time.UTC)
+	e.now = func() time.Time { return at.Add(time.Hour) }
+	for i := 0; i < 2; i++ {
+		e.HandleUsage(context.Background(), coreusage.Record{
+			APIKey: "sk-alice-0000000000", Provider: "claude", Model: "claude-sonnet-4", RequestedAt: at,
+			Detail: coreusage.Detail{InputTokens: 1000, OutputTokens: 500},
+		})
+	}
+	e.HandleUsage(context.Background(), coreusage.Record{
+		APIKey: "sk-bob-000000000000", Provider: "codex", Model: "gpt-5", RequestedAt: at,
+		Detail: coreusage.Detail{InputTokens: 200, OutputTokens: 100, TotalTokens: 300},
+	})
+
+	path, err := e.Export(context.Background())
+	if err != nil || path == "" {
+		t.Fatalf("Export() = %q, %v", path, err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open export: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("read export: %v", err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(Columns, ",") {
+		t.Fatalf("want a header and 2 rows, got %v", rows)
+	}
+	col := func(row []string, name string) string {
+		for i, column := range Columns {
+			if column == name {
+				return row[i]
+			}
+		}
+		t.Fatalf("unknown column %s", name)
+		return ""
+	}
+	// Rows are ordered by team, so the key without a team comes first.
+	bob, alice := rows[1], rows[2]
+	if col(alice, "SubAccountId") != "research" || col(alice, "SkuId") != "claude-sonnet-4" || col(alice, "ConsumedQuantity") != "3000" || col(alice, "BilledCost") != "3.000000" {
+		t.Fatalf("unexpected alice row: %v", alice)
+	}
+	if col(alice, "ChargePeriodStart") != "2026-03-04T10:00:00Z" || col(alice, "ChargePeriodEnd") != "2026-03-04T11:00:00Z" || col(alice, "BillingPeriodStart") != "2026-03-01T00:00:00Z" {
+		t.Fatalf("unexpected periods: %v", alice)
+	}
+	if strings.Contains(strings.Join(alice, ","), "sk-alice-0000000000") {
+		t.Fatalf("export must not contain the raw API key: %v", alice)
+	}
+	if col(bob, "SubAccountId") != "" || col(bob, "PublisherName") != "codex" || col(bob, "ConsumedQuantity") != "300" {
+		t.Fatalf("unexpected bob row: %v", bob)
+	}
+
+	if path, err = e.Export(context.Background()); err != nil || path != "" {
+		t.Fatalf("second Export() = %q, %v; want nothing to export", path, err)
+	}
+}
+
+func TestDisabledExporterIgnoresUsage(t *testing.T) {
+	e := New(config.CostExportConfig{Directory: t.TempDir()}, Options{})
+	e.HandleUsage(context.Background(), coreusage.Record{APIKey: "sk-x", Model: "m", Detail: coreusage.Detail{InputTokens: 1}})
+	if status := e.Status(); status.PendingRows != 0 || status.Schedule != ScheduleDaily {
+		t.Fatalf("Status() = %+v", status)
+	}
+}
@@ -0,0 +1,35 @@
+package costexport
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRoutes registers the cost export management routes on a group
+// that already has management authentication applied.
+func (e *Exporter) RegisterRoutes(group *gin.RouterGroup) {
+	group.GET("/cost-export", e.GetStatus)
+	group.POST("/cost-export/run", e.PostRun)
+}
+
+// GetStatus returns the schedule, pending usage and the last export
+// GET /v0/management/cost-export
+func (e *Exporter) GetStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, e.Status())
+}
+
+// PostRun exports the usage aggregated since the last export now
+// POST /v0/management/cost-export/run
+func (e *Exporter) PostRun(c *gin.Context) {
+	if !e.Status().Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "cost_export_disabled", "message": "cost-export is not enabled"})
+		return
+	}
+	file, err := e.Export(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "export_failed", "message": err.Error(), "file": file, "status": e.Status()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"file": file, "status": e.Status()})
+}
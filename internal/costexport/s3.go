@@ -0,0 +1,61 @@
+package costexport
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+const uploadTimeout = time.Minute
+
+// uploader puts export files into an S3-compatible bucket.
+type uploader struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newUploader(cfg config.CostExportS3Config) (*uploader, error) {
+	endpoint := strings.TrimSpace(cfg.Endpoint)
+	if endpoint == "" {
+		return nil, fmt.Errorf("s3.endpoint is required")
+	}
+	options := &minio.Options{
+		Creds:  credentials.NewStaticV4(strings.TrimSpace(cfg.AccessKey), strings.TrimSpace(cfg.SecretKey), ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	}
+	if cfg.PathStyle {
+		options.BucketLookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(endpoint, options)
+	if err != nil {
+		return nil, fmt.Errorf("create S3 client: %w", err)
+	}
+	return &uploader{client: client, bucket: strings.TrimSpace(cfg.Bucket), prefix: strings.Trim(cfg.Prefix, "/")}, nil
+}
+
+// objectKey partitions files by the year and month of their export time, which
+// is encoded in the file name.
+func (u *uploader) objectKey(file string) string {
+	name := filepath.Base(file)
+	partition := ""
+	if at, err := time.Parse(fileLayout, strings.TrimSuffix(strings.TrimPrefix(name, "focus-"), ".csv")); err == nil {
+		partition = at.Format("year=2006/month=01")
+	}
+	return path.Join(u.prefix, partition, name)
+}
+
+func (u *uploader) upload(ctx context.Context, file string) error {
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+	_, err := u.client.FPutObject(ctx, u.bucket, u.objectKey(file), file, minio.PutObjectOptions{ContentType: "text/csv"})
+	return err
+}
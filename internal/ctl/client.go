@@ -0,0 +1,110 @@
+// Package ctl implements cc-proxyctl, the command line client of the
+// management API, so admins can inspect bindings, ban and unban keys, manage
+// client keys, read usage and follow logs without hand-written curl calls.
+package ctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the management API of one proxy.
+type Client struct {
+	// BaseURL is the proxy address, e.g. http://127.0.0.1:8317.
+	BaseURL string
+	// Key is the management key or a scoped management token.
+	Key string
+	// Version is the management API version, "v1" or "v0".
+	Version string
+	HTTP    *http.Client
+}
+
+// APIError is a non-2xx response of the management API.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	switch {
+	case e.Message != "" && e.Code != "":
+		return fmt.Sprintf("%s: %s (HTTP %d)", e.Code, e.Message, e.Status)
+	case e.Code != "":
+		return fmt.Sprintf("%s (HTTP %d)", e.Code, e.Status)
+	}
+	return fmt.Sprintf("HTTP %d", e.Status)
+}
+
+// Do sends a request to path below the management prefix and decodes the JSON
+// response into out, which may be nil.
+func (c *Client) Do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	endpoint := strings.TrimRight(c.BaseURL, "/") + "/" + c.version() + "/management" + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Key)
+	}
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{Status: resp.StatusCode}
+		var payload struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(raw, &payload) == nil {
+			apiErr.Code, apiErr.Message = payload.Error, payload.Message
+		}
+		return apiErr
+	}
+	if out == nil || len(bytes.TrimSpace(raw)) == 0 {
+		return nil
+	}
+	if target, ok := out.(*json.RawMessage); ok {
+		*target = append((*target)[:0], raw...)
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
+
+func (c *Client) version() string {
+	if c.Version == "" {
+		return "v1"
+	}
+	return c.Version
+}
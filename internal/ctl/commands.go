@@ -0,0 +1,491 @@
+package ctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Environment variables read for the global flags
+const (
+	EnvServer = "CC_PROXY_URL"
+	EnvKey    = "CC_PROXY_MANAGEMENT_KEY"
+)
+
+const defaultServer = "http://127.0.0.1:8317"
+
+// options are the global flags shared by all commands.
+type options struct {
+	server  string
+	key     string
+	version string
+	output  string
+	timeout time.Duration
+}
+
+func (o *options) client() *Client {
+	return &Client{
+		BaseURL: o.server,
+		Key:     o.key,
+		Version: o.version,
+		HTTP:    &http.Client{Timeout: o.timeout},
+	}
+}
+
+// NewRootCommand builds the cc-proxyctl command tree writing to out.
+func NewRootCommand(out io.Writer) *cobra.Command {
+	opts := &options{}
+	root := &cobra.Command{
+		Use:           "cc-proxyctl",
+		Short:         "Administer a CLI Proxy API server through its management API",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			if opts.output != "table" && opts.output != "json" {
+				return fmt.Errorf("--output must be table or json")
+			}
+			if opts.key == "" {
+				return fmt.Errorf("a management key is required: pass --management-key or set %s", EnvKey)
+			}
+			return nil
+		},
+	}
+	root.SetOut(out)
+	root.SetErr(out)
+
+	server := os.Getenv(EnvServer)
+	if server == "" {
+		server = defaultServer
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&opts.server, "server", server, "proxy base URL (env "+EnvServer+")")
+	flags.StringVar(&opts.key, "management-key", os.Getenv(EnvKey), "management key or scoped management token (env "+EnvKey+")")
+	flags.StringVar(&opts.version, "api-version", "v1", "management API version")
+	flags.StringVarP(&opts.output, "output", "o", "table", "output format: table or json")
+	flags.DurationVar(&opts.timeout, "timeout", 30*time.Second, "request timeout")
+
+	root.AddCommand(
+		bindingsCommand(opts),
+		banCommand(opts),
+		unbanCommand(opts),
+		keysCommand(opts),
+		usageCommand(opts),
+		logsCommand(opts),
+	)
+	return root
+}
+
+// Execute runs cc-proxyctl with the process arguments and returns the exit code.
+func Execute() int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := ExecuteContext(ctx, os.Stdout, os.Args[1:]); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// printJSON writes v indented.
+func printJSON(w io.Writer, v any) error {
+	if raw, ok := v.(json.RawMessage); ok {
+		var decoded any
+		if err := json.Unmarshal(raw, &decoded); err == nil {
+			v = decoded
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func newTable(w io.Writer, header ...string) *tabwriter.Writer {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, strings.Join(header, "\t"))
+	return tw
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+type bindingEntry struct {
+	APIKey  string `json:"api_key"`
+	Binding struct {
+		Devices      []json.RawMessage `json:"devices"`
+		LastSeen     time.Time         `json:"last_seen"`
+		LastIP       string            `json:"last_ip"`
+		Banned       bool              `json:"banned"`
+		BanReason    string            `json:"ban_reason"`
+		BanExpiresAt time.Time         `json:"ban_expires_at"`
+		Strikes      int               `json:"strikes"`
+	} `json:"binding"`
+}
+
+func bindingsCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{Use: "bindings", Short: "List, inspect and reset device bindings"}
+
+	var (
+		banned      string
+		page, limit int
+		sortBy      string
+	)
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List device bindings",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			query := url.Values{"page": {strconv.Itoa(page)}, "limit": {strconv.Itoa(limit)}}
+			if banned != "" {
+				query.Set("banned", banned)
+			}
+			if sortBy != "" {
+				query.Set("sort", sortBy)
+			}
+			var raw json.RawMessage
+			if err := opts.client().Do(cmd.Context(), http.MethodGet, "/device-bindings", query, nil, &raw); err != nil {
+				return err
+			}
+			if opts.output == "json" {
+				return printJSON(cmd.OutOrStdout(), raw)
+			}
+			var resp struct {
+				Bindings []bindingEntry `json:"bindings"`
+				Total    int            `json:"total"`
+			}
+			if err := json.Unmarshal(raw, &resp); err != nil {
+				return err
+			}
+			tw := newTable(cmd.OutOrStdout(), "API KEY", "DEVICES", "STRIKES", "LAST SEEN", "LAST IP", "BANNED")
+			for _, entry := range resp.Bindings {
+				state := "no"
+				if entry.Binding.Banned {
+					state = "yes: " + entry.Binding.BanReason
+					if !entry.Binding.BanExpiresAt.IsZero() {
+						state += " (until " + formatTime(entry.Binding.BanExpiresAt) + ")"
+					}
+				}
+				_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\n", entry.APIKey, len(entry.Binding.Devices),
+					entry.Binding.Strikes, formatTime(entry.Binding.LastSeen), entry.Binding.LastIP, state)
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			_, err := fmt.Fprintf(cmd.OutOrStdout(), "\n%d of %d bindings (page %d)\n", len(resp.Bindings), resp.Total, page)
+			return err
+		},
+	}
+	list.Flags().StringVar(&banned, "banned", "", "filter by ban state (true or false)")
+	list.Flags().IntVar(&page, "page", 1, "page number")
+	list.Flags().IntVar(&limit, "limit", 50, "bindings per page")
+	list.Flags().StringVar(&sortBy, "sort", "", "sort by api_key, last_seen, first_seen, devices or strikes; prefix - for descending")
+
+	show := &cobra.Command{
+		Use:   "show <api-key>",
+		Short: "Show the binding of one API key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var raw json.RawMessage
+			if err := opts.client().Do(cmd.Context(), http.MethodGet, "/device-bindings", url.Values{"api-key": {args[0]}}, nil, &raw); err != nil {
+				return err
+			}
+			return printJSON(cmd.OutOrStdout(), raw)
+		},
+	}
+
+	var all bool
+	reset := &cobra.Command{
+		Use:   "reset [api-key]",
+		Short: "Remove the binding of an API key so its devices register again",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := url.Values{}
+			switch {
+			case len(args) == 1:
+				query.Set("api-key", args[0])
+			case !all:
+				return fmt.Errorf("pass an API key, or --all to reset every binding")
+			}
+			return runAndPrint(cmd, opts, http.MethodDelete, "/device-bindings", query, nil, "binding reset")
+		},
+	}
+	reset.Flags().BoolVar(&all, "all", false, "reset the bindings of every key")
+
+	cmd.AddCommand(list, show, reset)
+	return cmd
+}
+
+func banCommand(opts *options) *cobra.Command {
+	var (
+		reason   string
+		duration time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "ban <api-key>",
+		Short: "Ban an API key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			body := map[string]any{"reason": reason, "duration": int64(duration.Seconds())}
+			return runAndPrint(cmd, opts, http.MethodPost, "/device-bindings/ban", url.Values{"api-key": {args[0]}}, body, "banned "+args[0])
+		},
+	}
+	cmd.Flags().StringVar(&reason, "reason", "", "reason shown to the client")
+	cmd.Flags().DurationVar(&duration, "duration", 0, "ban length, e.g. 24h; 0 bans until unbanned")
+	return cmd
+}
+
+func unbanCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "unban <api-key>",
+		Short: "Lift the ban of an API key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runAndPrint(cmd, opts, http.MethodPost, "/device-bindings/unban", url.Values{"api-key": {args[0]}}, nil, "unbanned "+args[0])
+		},
+	}
+}
+
+// runAndPrint sends a request and prints the response as JSON, or done in table mode.
+func runAndPrint(cmd *cobra.Command, opts *options, method, path string, query url.Values, body any, done string) error {
+	var raw json.RawMessage
+	if err := opts.client().Do(cmd.Context(), method, path, query, body, &raw); err != nil {
+		return err
+	}
+	if opts.output == "json" {
+		return printJSON(cmd.OutOrStdout(), raw)
+	}
+	_, err := fmt.Fprintln(cmd.OutOrStdout(), done)
+	return err
+}
+
+type keyInfo struct {
+	Key       string     `json:"key"`
+	State     string     `json:"state"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func keysCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{Use: "keys", Short: "List, create, rotate and revoke client API keys"}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List client API keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			var raw json.RawMessage
+			if err := opts.client().Do(cmd.Context(), http.MethodGet, "/keys", nil, nil, &raw); err != nil {
+				return err
+			}
+			if opts.output == "json" {
+				return printJSON(cmd.OutOrStdout(), raw)
+			}
+			var resp struct {
+				Keys []keyInfo `json:"keys"`
+			}
+			if err := json.Unmarshal(raw, &resp); err != nil {
+				return err
+			}
+			tw := newTable(cmd.OutOrStdout(), "KEY", "STATE", "EXPIRES")
+			for _, key := range resp.Keys {
+				expires := "-"
+				if key.ExpiresAt != nil {
+					expires = formatTime(*key.ExpiresAt)
+				}
+				_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", key.Key, key.State, expires)
+			}
+			return tw.Flush()
+		},
+	}
+
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "Mint a new client API key",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			var key keyInfo
+			if err := opts.client().Do(cmd.Context(), http.MethodPost, "/keys", nil, nil, &key); err != nil {
+				return err
+			}
+			if opts.output == "json" {
+				return printJSON(cmd.OutOrStdout(), key)
+			}
+			_, err := fmt.Fprintln(cmd.OutOrStdout(), key.Key)
+			return err
+		},
+	}
+
+	var grace time.Duration
+	rotate := &cobra.Command{
+		Use:   "rotate <api-key>",
+		Short: "Mint a successor for a key; the old key keeps working for the grace window",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			body := map[string]any{"key": args[0]}
+			if grace > 0 {
+				body["grace_seconds"] = int64(grace.Seconds())
+			}
+			var resp struct {
+				Key        keyInfo `json:"key"`
+				RotatedKey keyInfo `json:"rotated_key"`
+			}
+			if err := opts.client().Do(cmd.Context(), http.MethodPost, "/keys/rotate", nil, body, &resp); err != nil {
+				return err
+			}
+			if opts.output == "json" {
+				return printJSON(cmd.OutOrStdout(), resp)
+			}
+			expires := "-"
+			if resp.RotatedKey.ExpiresAt != nil {
+				expires = formatTime(*resp.RotatedKey.ExpiresAt)
+			}
+			_, err := fmt.Fprintf(cmd.OutOrStdout(), "%s\nold key expires at %s\n", resp.Key.Key, expires)
+			return err
+		},
+	}
+	rotate.Flags().DurationVar(&grace, "grace", 0, "how long the old key keeps working (default: the server's rotation-grace)")
+
+	revoke := &cobra.Command{
+		Use:   "revoke <api-key>",
+		Short: "Revoke a client API key immediately",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runAndPrint(cmd, opts, http.MethodPost, "/keys/revoke", nil, map[string]string{"key": args[0]}, "revoked "+args[0])
+		},
+	}
+
+	cmd.AddCommand(list, create, rotate, revoke)
+	return cmd
+}
+
+type keyUsage struct {
+	APIKey       string `json:"api_key"`
+	Requests     int64  `json:"requests"`
+	InputTokens  int64  `json:"input_tokens"`
+	OutputTokens int64  `json:"output_tokens"`
+}
+
+func usageCommand(opts *options) *cobra.Command {
+	var apiKey, model, from, to string
+	cmd := &cobra.Command{
+		Use:   "usage",
+		Short: "Show requests and tokens per API key",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			query := url.Values{}
+			for name, value := range map[string]string{"api-key": apiKey, "model": model, "from": from, "to": to} {
+				if value != "" {
+					query.Set(name, value)
+				}
+			}
+			var raw json.RawMessage
+			if err := opts.client().Do(cmd.Context(), http.MethodGet, "/usage", query, nil, &raw); err != nil {
+				return err
+			}
+			if opts.output == "json" {
+				return printJSON(cmd.OutOrStdout(), raw)
+			}
+			var resp struct {
+				Daily *struct {
+					From   string              `json:"from"`
+					To     string              `json:"to"`
+					Totals map[string]keyUsage `json:"totals"`
+				} `json:"daily"`
+			}
+			if err := json.Unmarshal(raw, &resp); err != nil {
+				return err
+			}
+			if resp.Daily == nil {
+				return fmt.Errorf("per-key usage is not recorded by this server")
+			}
+			totals := make([]keyUsage, 0, len(resp.Daily.Totals))
+			for _, total := range resp.Daily.Totals {
+				totals = append(totals, total)
+			}
+			sort.Slice(totals, func(i, j int) bool { return totals[i].Requests > totals[j].Requests })
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Usage from %s to %s\n\n", resp.Daily.From, resp.Daily.To)
+			tw := newTable(cmd.OutOrStdout(), "API KEY", "REQUESTS", "INPUT TOKENS", "OUTPUT TOKENS")
+			for _, total := range totals {
+				_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", total.APIKey, total.Requests, total.InputTokens, total.OutputTokens)
+			}
+			return tw.Flush()
+		},
+	}
+	cmd.Flags().StringVar(&apiKey, "api-key", "", "only this API key")
+	cmd.Flags().StringVar(&model, "model", "", "only this model")
+	cmd.Flags().StringVar(&from, "from", "", "first day, YYYY-MM-DD (default: 30 days ago)")
+	cmd.Flags().StringVar(&to, "to", "", "last day, YYYY-MM-DD (default: today)")
+	return cmd
+}
+
+func logsCommand(opts *options) *cobra.Command {
+	var (
+		follow   bool
+		limit    int
+		interval time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Print the server log, optionally following new lines",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			client := opts.client()
+			var after int64
+			for {
+				query := url.Values{}
+				if after > 0 {
+					query.Set("after", strconv.FormatInt(after, 10))
+				} else if limit > 0 {
+					query.Set("limit", strconv.Itoa(limit))
+				}
+				var resp struct {
+					Lines  []string `json:"lines"`
+					Latest int64    `json:"latest-timestamp"`
+				}
+				if err := client.Do(cmd.Context(), http.MethodGet, "/logs", query, nil, &resp); err != nil {
+					return err
+				}
+				for _, line := range resp.Lines {
+					_, _ = fmt.Fprintln(cmd.OutOrStdout(), line)
+				}
+				if resp.Latest > after {
+					after = resp.Latest
+				}
+				if !follow {
+					return nil
+				}
+				select {
+				case <-cmd.Context().Done():
+					return nil
+				case <-time.After(interval):
+				}
+			}
+		},
+	}
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "keep polling for new lines")
+	cmd.Flags().IntVarP(&limit, "lines", "n", 100, "lines to print before following")
+	cmd.Flags().DurationVar(&interval, "interval", 2*time.Second, "poll interval with --follow")
+	return cmd
+}
+
+// ExecuteContext runs cc-proxyctl with args and ctx, for tests and embedding.
+func ExecuteContext(ctx context.Context, out io.Writer, args []string) error {
+	root := NewRootCommand(out)
+	root.SetArgs(args)
+	return root.ExecuteContext(ctx)
+}
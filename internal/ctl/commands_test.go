@@ -0,0 +1,117 @@
+package ctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	var calls []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/management/device-bindings", func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.RequestURI())
+		if r.Method == http.MethodDelete {
+			_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+			return
+		}
+		_, _ = w.Write([]byte(`{"bindings":[{"api_key":"sk-a","binding":{"devices":[{},{}],"strikes":1,"last_ip":"10.0.0.1","banned":true,"ban_reason":"sharing"}}],"total":1}`))
+	})
+	mux.HandleFunc("/v1/management/device-bindings/ban", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		calls = append(calls, r.Method+" "+r.URL.RequestURI()+" "+body["reason"].(string))
+		_, _ = w.Write([]byte(`{"status":"banned"}`))
+	})
+	mux.HandleFunc("/v1/management/keys", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid management key"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"key":"sk-new","state":"active"}`))
+	})
+	mux.HandleFunc("/v1/management/usage", func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.RequestURI())
+		_, _ = w.Write([]byte(`{"daily":{"from":"2026-10-01","to":"2026-10-17","totals":{"sk-a":{"api_key":"sk-a","requests":3,"input_tokens":30,"output_tokens":12},"sk-b":{"api_key":"sk-b","requests":9,"input_tokens":90,"output_tokens":40}}}}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func run(t *testing.T, srv *httptest.Server, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := ExecuteContext(context.Background(), &out, append([]string{"--server", srv.URL, "--management-key", "secret"}, args...))
+	return out.String(), err
+}
+
+func TestBindingsListTable(t *testing.T) {
+	srv, calls := newTestServer(t)
+	out, err := run(t, srv, "bindings", "list", "--banned", "true")
+	if err != nil {
+		t.Fatalf("bindings list: %v", err)
+	}
+	if !strings.Contains(out, "sk-a") || !strings.Contains(out, "yes: sharing") || !strings.Contains(out, "1 of 1 bindings") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	if got := (*calls)[0]; !strings.Contains(got, "banned=true") {
+		t.Fatalf("filter not sent: %s", got)
+	}
+}
+
+func TestBanAndResetSendRequests(t *testing.T) {
+	srv, calls := newTestServer(t)
+	if _, err := run(t, srv, "ban", "sk-a", "--reason", "abuse", "--duration", "1h"); err != nil {
+		t.Fatalf("ban: %v", err)
+	}
+	if _, err := run(t, srv, "bindings", "reset"); err == nil {
+		t.Fatal("reset without a key or --all should fail")
+	}
+	if _, err := run(t, srv, "bindings", "reset", "sk-a"); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	want := []string{
+		"POST /v1/management/device-bindings/ban?api-key=sk-a abuse",
+		"DELETE /v1/management/device-bindings?api-key=sk-a",
+	}
+	if strings.Join(*calls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("calls = %q, want %q", *calls, want)
+	}
+}
+
+func TestUsageSortsByRequests(t *testing.T) {
+	srv, calls := newTestServer(t)
+	out, err := run(t, srv, "usage", "--from", "2026-10-01")
+	if err != nil {
+		t.Fatalf("usage: %v", err)
+	}
+	if strings.Index(out, "sk-b") > strings.Index(out, "sk-a") {
+		t.Fatalf("keys not sorted by requests:\n%s", out)
+	}
+	if !strings.Contains((*calls)[0], "from=2026-10-01") {
+		t.Fatalf("from not sent: %s", (*calls)[0])
+	}
+}
+
+func TestClientSurfacesAPIErrors(t *testing.T) {
+	srv, _ := newTestServer(t)
+	client := &Client{BaseURL: srv.URL, Key: "wrong"}
+	err := client.Do(context.Background(), http.MethodPost, "/keys", nil, nil, nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized || apiErr.Code != "invalid management key" {
+		t.Fatalf("err = %v", err)
+	}
+
+	out, err := run(t, srv, "keys", "create")
+	if err != nil || strings.TrimSpace(out) != "sk-new" {
+		t.Fatalf("keys create = %q, %v", out, err)
+	}
+}
@@ -0,0 +1,203 @@
+// Package customroutes maps operator-defined request paths onto the proxy's
+// API endpoints so legacy internal clients can use the proxy unchanged.
+// Requests are rewritten before routing, so they pass through the same
+// middleware chain as requests sent to the target path directly.
+package customroutes
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// managementSegment follows the /vN version segment of every management API mount.
+const managementSegment = "/management"
+
+type rule struct {
+	path         string
+	prefix       bool
+	method       string
+	target       string
+	targetPrefix bool
+	targetMethod string
+	model        string
+	setHeaders   map[string]string
+	rename       map[string]string
+	remove       []string
+}
+
+// Router rewrites requests matching the configured custom routes.
+type Router struct {
+	rules atomic.Pointer[[]rule]
+}
+
+// New creates a router from configuration.
+func New(routes []config.CustomRoute) *Router {
+	r := &Router{}
+	r.Update(routes)
+	return r
+}
+
+// Update replaces the routes. Invalid routes are logged and skipped.
+func (r *Router) Update(routes []config.CustomRoute) {
+	rules := make([]rule, 0, len(routes))
+	for _, rc := range routes {
+		path, target := strings.TrimSpace(rc.Path), strings.TrimSpace(rc.Target)
+		if !strings.HasPrefix(path, "/") || !strings.HasPrefix(target, "/") {
+			log.Warnf("custom-routes: ignoring route %q -> %q: path and target must start with /", rc.Path, rc.Target)
+			continue
+		}
+		if prefix, isPrefix := strings.CutSuffix(path, "*"); isPrefix && overlapsManagement(prefix) || !isPrefix && isManagementPath(path) {
+			log.Warnf("custom-routes: ignoring route %q: management paths cannot be remapped", rc.Path)
+			continue
+		}
+		ru := rule{
+			path:         path,
+			method:       strings.ToUpper(strings.TrimSpace(rc.Method)),
+			target:       target,
+			targetMethod: strings.ToUpper(strings.TrimSpace(rc.TargetMethod)),
+			model:        strings.TrimSpace(rc.Model),
+			setHeaders:   rc.SetHeaders,
+			rename:       rc.RenameHeaders,
+			remove:       rc.RemoveHeaders,
+		}
+		if strings.HasSuffix(path, "*") {
+			ru.path, ru.prefix = strings.TrimSuffix(path, "*"), true
+			if strings.HasSuffix(target, "*") {
+				ru.target, ru.targetPrefix = strings.TrimSuffix(target, "*"), true
+			}
+		}
+		rules = append(rules, ru)
+	}
+	r.rules.Store(&rules)
+}
+
+// match returns the first rule matching the request, if any.
+func (r *Router) match(req *http.Request) *rule {
+	rules := r.rules.Load()
+	if rules == nil {
+		return nil
+	}
+	if isManagementPath(path.Clean(req.URL.Path)) {
+		return nil
+	}
+	for i := range *rules {
+		ru := &(*rules)[i]
+		if ru.method != "" && ru.method != req.Method {
+			continue
+		}
+		if ru.prefix && strings.HasPrefix(req.URL.Path, ru.path) || !ru.prefix && req.URL.Path == ru.path {
+			return ru
+		}
+	}
+	return nil
+}
+
+// isManagementPath reports whether p is on a management API mount, /vN/management.
+func isManagementPath(p string) bool {
+	version, rest, ok := cutVersion(p)
+	if !ok || version == "" {
+		return false
+	}
+	rest, found := strings.CutPrefix(rest, managementSegment)
+	return found && (rest == "" || strings.HasPrefix(rest, "/"))
+}
+
+// overlapsManagement reports whether a prefix rule could match a management
+// path, either because the prefix is inside a management mount or because it
+// can be extended into one, like "/", "/v", "/v1" or "/v1/man".
+func overlapsManagement(prefix string) bool {
+	if isManagementPath(prefix) || strings.HasPrefix("/v", prefix) {
+		return true
+	}
+	version, rest, ok := cutVersion(prefix)
+	if !ok || version == "" {
+		return false
+	}
+	return strings.HasPrefix(managementSegment, rest)
+}
+
+// cutVersion splits "/v<digits><rest>" into its digits and rest.
+func cutVersion(p string) (version, rest string, ok bool) {
+	rest, ok = strings.CutPrefix(p, "/v")
+	if !ok {
+		return "", "", false
+	}
+	n := 0
+	for n < len(rest) && rest[n] >= '0' && rest[n] <= '9' {
+		n++
+	}
+	return rest[:n], rest[n:], true
+}
+
+// Wrap returns a handler that rewrites matching requests before passing them to next.
+func (r *Router) Wrap(next http.Handler) http.Handler {
+	if r == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ru := r.match(req); ru != nil {
+			if err := ru.rewrite(req); err != nil {
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// rewrite applies the rule to the request in place.
+func (ru *rule) rewrite(req *http.Request) error {
+	original := req.URL.Path
+	target := ru.target
+	if ru.targetPrefix {
+		target += strings.TrimPrefix(original, ru.path)
+	}
+	req.URL.Path, req.URL.RawPath = target, ""
+	req.RequestURI = req.URL.RequestURI()
+	if ru.targetMethod != "" {
+		req.Method = ru.targetMethod
+	}
+
+	for from, to := range ru.rename {
+		if values := req.Header.Values(from); len(values) > 0 {
+			req.Header.Del(from)
+			for _, v := range values {
+				req.Header.Add(to, v)
+			}
+		}
+	}
+	for _, name := range ru.remove {
+		req.Header.Del(name)
+	}
+	for name, value := range ru.setHeaders {
+		req.Header.Set(name, value)
+	}
+
+	if ru.model != "" && req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return err
+		}
+		if parsed := gjson.ParseBytes(body); parsed.IsObject() {
+			if updated, errSet := sjson.SetBytes(body, "model", ru.model); errSet == nil {
+				body = updated
+			}
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	log.Debugf("custom-routes: %s %s -> %s", req.Method, original, target)
+	return nil
+}
@@ -0,0 +1,96 @@
+package customroutes
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+type seenRequest struct {
+	method, uri, body string
+	header            http.Header
+}
+
+func serve(r *Router, req *http.Request) seenRequest {
+	var seen seenRequest
+	handler := r.Wrap(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		seen = seenRequest{method: req.Method, uri: req.RequestURI, body: string(body), header: req.Header}
+		if req.ContentLength != int64(len(body)) {
+			seen.body = "content length mismatch"
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	return seen
+}
+
+func TestCustomRoutesRewriteRequests(t *testing.T) {
+	r := New([]config.CustomRoute{
+		{
+			Path:          "/api/chat",
+			Method:        "post",
+			Target:        "/v1/messages",
+			Model:         "claude-sonnet-4-5",
+			SetHeaders:    map[string]string{"Anthropic-Version": "2023-06-01"},
+			RenameHeaders: map[string]string{"X-Legacy-Token": "X-Api-Key"},
+			RemoveHeaders: []string{"X-Legacy-Client"},
+		},
+		{Path: "/legacy/*", Target: "/v1/*", TargetMethod: "POST"},
+		{Path: "/v0/management/config", Target: "/v1/models"},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chat?beta=true", strings.NewReader(`{"model":"old","messages":[]}`))
+	req.Header.Set("X-Legacy-Token", "sk-123")
+	req.Header.Set("X-Legacy-Client", "intranet")
+	seen := serve(r, req)
+	if seen.uri != "/v1/messages?beta=true" || seen.body != `{"model":"claude-sonnet-4-5","messages":[]}` {
+		t.Fatalf("unexpected rewrite %+v", seen)
+	}
+	if seen.header.Get("X-Api-Key") != "sk-123" || seen.header.Get("X-Legacy-Token") != "" ||
+		seen.header.Get("X-Legacy-Client") != "" || seen.header.Get("Anthropic-Version") != "2023-06-01" {
+		t.Fatalf("unexpected headers %v", seen.header)
+	}
+
+	if seen = serve(r, httptest.NewRequest(http.MethodGet, "/api/chat", nil)); seen.uri != "/api/chat" {
+		t.Fatalf("expected the method filter to skip GET, got %+v", seen)
+	}
+	if seen = serve(r, httptest.NewRequest(http.MethodGet, "/legacy/chat/completions", nil)); seen.uri != "/v1/chat/completions" || seen.method != http.MethodPost {
+		t.Fatalf("unexpected prefix rewrite %+v", seen)
+	}
+	if seen = serve(r, httptest.NewRequest(http.MethodGet, "/v0/management/config", nil)); seen.uri != "/v0/management/config" {
+		t.Fatalf("expected management paths to be left alone, got %+v", seen)
+	}
+
+	r.Update(nil)
+	if seen = serve(r, httptest.NewRequest(http.MethodPost, "/api/chat", nil)); seen.uri != "/api/chat" {
+		t.Fatalf("expected routes to be removed on update, got %+v", seen)
+	}
+}
+
+func TestCustomRoutesNeverTouchManagementMounts(t *testing.T) {
+	r := New([]config.CustomRoute{
+		{Path: "/v1/management/config", Target: "/v1/models"},
+		{Path: "/v1/*", Target: "/v2/*"},
+		{Path: "/v*", Target: "/x"},
+		{Path: "/*", Target: "/x"},
+		{Path: "/v1/man*", Target: "/x"},
+		{Path: "/v1/chat/*", Target: "/v1/messages"},
+	})
+	if rules := r.rules.Load(); len(*rules) != 1 || (*rules)[0].path != "/v1/chat/" {
+		t.Fatalf("expected only the non-management rule to load, got %+v", *rules)
+	}
+
+	r.rules.Store(&[]rule{{path: "/", prefix: true, target: "/x"}})
+	for _, p := range []string{"/v1/management/config", "/v0/management", "/v12/management/keys"} {
+		if seen := serve(r, httptest.NewRequest(http.MethodGet, p, nil)); seen.uri != p {
+			t.Errorf("%s: expected management path to be left alone, got %+v", p, seen)
+		}
+	}
+	if seen := serve(r, httptest.NewRequest(http.MethodGet, "/v1/managementx", nil)); seen.uri != "/x" {
+		t.Fatalf("expected non-management path to be rewritten, got %+v", seen)
+	}
+}
@@ -0,0 +1,125 @@
+// Package degraded tracks stores the proxy depends on for request checks, so
+// health checks can report when it runs degraded instead of store errors only
+// showing up in the logs. Each check decides for itself, from its configured
+// Mode, whether to serve or reject requests it cannot verify.
+package degraded
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Modes of a check whose store is unavailable
+const (
+	// FailOpen serves requests that cannot be checked.
+	FailOpen = "fail-open"
+	// FailClosed rejects requests that cannot be checked with 503.
+	FailClosed = "fail-closed"
+)
+
+// Components reported by the proxy
+const (
+	ComponentDeviceStore = "device-store"
+	ComponentKeyUsage    = "key-usage"
+	ComponentSpend       = "spend"
+)
+
+var degradedGauge = metrics.Default().NewGaugeVec(
+	"cliproxy_degraded",
+	"1 while a store the proxy depends on is unavailable, by component.",
+	"component",
+)
+
+// ParseMode returns FailClosed for "fail-closed" and FailOpen otherwise.
+func ParseMode(mode string) string {
+	if strings.EqualFold(strings.TrimSpace(mode), FailClosed) {
+		return FailClosed
+	}
+	return FailOpen
+}
+
+// State is the health of one component.
+type State struct {
+	Component string    `json:"component"`
+	Degraded  bool      `json:"degraded"`
+	Since     time.Time `json:"since,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	// Failures counts errors since the component last recovered.
+	Failures int64 `json:"failures,omitempty"`
+}
+
+// Tracker records the health of components.
+type Tracker struct {
+	mu     sync.Mutex
+	states map[string]*State
+	now    func() time.Time
+}
+
+var defaultTracker = New()
+
+// Default returns the process-wide tracker.
+func Default() *Tracker { return defaultTracker }
+
+// New creates a tracker.
+func New() *Tracker {
+	return &Tracker{states: make(map[string]*State), now: time.Now}
+}
+
+// Report records the outcome of a store operation: a nil error marks the
+// component healthy again, any other error marks it degraded.
+func (t *Tracker) Report(component string, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.states[component]
+	if err == nil {
+		if state != nil && state.Degraded {
+			log.Infof("degraded: %s recovered after %d failure(s)", component, state.Failures)
+			state.Degraded, state.Failures, state.LastError, state.Since = false, 0, "", t.now()
+			degradedGauge.Set(0, component)
+		}
+		return
+	}
+	if state == nil {
+		state = &State{Component: component}
+		t.states[component] = state
+	}
+	if !state.Degraded {
+		log.Warnf("degraded: %s unavailable: %v", component, err)
+		state.Degraded, state.Since = true, t.now()
+		degradedGauge.Set(1, component)
+	}
+	state.Failures++
+	state.LastError = err.Error()
+}
+
+// Degraded reports whether any component is degraded.
+func (t *Tracker) Degraded() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, state := range t.states {
+		if state.Degraded {
+			return true
+		}
+	}
+	return false
+}
+
+// States returns the components that have reported errors, by name.
+func (t *Tracker) States() []State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]State, 0, len(t.states))
+	for _, state := range t.states {
+		out = append(out, *state)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Component < out[j].Component })
+	return out
+}
@@ -0,0 +1,32 @@
+package degraded
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReportMarksComponentsDegradedUntilRecovery(t *testing.T) {
+	tracker := New()
+	tracker.Report(ComponentDeviceStore, nil)
+	if tracker.Degraded() || len(tracker.States()) != 0 {
+		t.Fatalf("healthy reports should not register a degraded component: %+v", tracker.States())
+	}
+
+	tracker.Report(ComponentDeviceStore, errors.New("dial tcp: connection refused"))
+	tracker.Report(ComponentDeviceStore, errors.New("dial tcp: i/o timeout"))
+	states := tracker.States()
+	if !tracker.Degraded() || len(states) != 1 || states[0].Failures != 2 || states[0].LastError != "dial tcp: i/o timeout" {
+		t.Fatalf("States() = %+v", states)
+	}
+
+	tracker.Report(ComponentDeviceStore, nil)
+	if tracker.Degraded() || tracker.States()[0].Failures != 0 {
+		t.Fatalf("recovery should clear the degraded flag: %+v", tracker.States())
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	if ParseMode(" Fail-Closed ") != FailClosed || ParseMode("") != FailOpen || ParseMode("bogus") != FailOpen {
+		t.Fatalf("unexpected ParseMode results")
+	}
+}
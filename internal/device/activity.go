@@ -0,0 +1,308 @@
+package device
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultActivityRetention   = 72 * time.Hour
+	defaultActivityMaxPoints   = 5000
+	defaultActivitySweep       = 10 * time.Minute
+	defaultActivityBeforeBan   = 15 * time.Minute
+	defaultActivityAfterBan    = 5 * time.Minute
+	defaultActivityQueryWindow = time.Hour
+	// minActivityCompaction is the interned string count below which the table is never rebuilt
+	minActivityCompaction = 1024
+)
+
+// ActivityConfig configures an ActivityLog
+type ActivityConfig struct {
+	// Retention is how long points are kept. Default: 72 hours.
+	Retention time.Duration
+	// MaxPointsPerKey caps the points kept per key, dropping the oldest. Default: 5000.
+	MaxPointsPerKey int
+	// Interval is how often expired points are swept. Default: 10 minutes.
+	Interval time.Duration
+}
+
+// ActivityPoint is one request of an API key
+type ActivityPoint struct {
+	At       time.Time `json:"at"`
+	IP       string    `json:"ip"`
+	DeviceID string    `json:"device_id"`
+	Endpoint string    `json:"endpoint"`
+}
+
+// activityPoint is the stored form of an ActivityPoint: IPs, device IDs and
+// endpoints repeat heavily, so they are interned and a point takes 20 bytes.
+type activityPoint struct {
+	at                   int64 // unix milliseconds
+	ip, device, endpoint uint32
+}
+
+// ActivityLog is an in-memory time series of the requests of every API key,
+// kept for reviewing the activity that led up to a ban. Points of a key are
+// appended in time order, so range queries are a binary search.
+type ActivityLog struct {
+	cfg ActivityConfig
+	now func() time.Time
+
+	mu      sync.Mutex
+	series  map[string][]activityPoint
+	strings []string
+	index   map[string]uint32
+}
+
+// NewActivityLog creates an empty activity log, applying defaults.
+func NewActivityLog(cfg ActivityConfig) *ActivityLog {
+	if cfg.Retention <= 0 {
+		cfg.Retention = defaultActivityRetention
+	}
+	if cfg.MaxPointsPerKey <= 0 {
+		cfg.MaxPointsPerKey = defaultActivityMaxPoints
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultActivitySweep
+	}
+	return &ActivityLog{
+		cfg:    cfg,
+		now:    time.Now,
+		series: make(map[string][]activityPoint),
+		index:  make(map[string]uint32),
+	}
+}
+
+// intern returns the table index of s. Callers must hold l.mu.
+func (l *ActivityLog) intern(s string) uint32 {
+	if i, ok := l.index[s]; ok {
+		return i
+	}
+	i := uint32(len(l.strings))
+	l.strings = append(l.strings, s)
+	l.index[s] = i
+	return i
+}
+
+// Record appends a request of deviceID from ip to endpoint to the key's timeline.
+func (l *ActivityLog) Record(apiKey, deviceID, ip, endpoint string) {
+	if l == nil || apiKey == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	points := append(l.series[apiKey], activityPoint{
+		at:       l.now().UnixMilli(),
+		ip:       l.intern(ip),
+		device:   l.intern(deviceID),
+		endpoint: l.intern(endpoint),
+	})
+	// Trim in batches of an eighth of the cap so appends stay amortised O(1).
+	limit := l.cfg.MaxPointsPerKey
+	if len(points) >= limit+max(limit/8, 1) {
+		points = points[:copy(points, points[len(points)-limit:])]
+	}
+	l.series[apiKey] = points
+}
+
+// Query returns the points of apiKey recorded in [from, to], oldest first.
+func (l *ActivityLog) Query(apiKey string, from, to time.Time) []ActivityPoint {
+	out := []ActivityPoint{}
+	if l == nil {
+		return out
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	points := l.series[apiKey]
+	lo, hi := from.UnixMilli(), to.UnixMilli()
+	start := sort.Search(len(points), func(i int) bool { return points[i].at >= lo })
+	for _, p := range points[start:] {
+		if p.at > hi {
+			break
+		}
+		out = append(out, ActivityPoint{
+			At:       time.UnixMilli(p.at).UTC(),
+			IP:       l.strings[p.ip],
+			DeviceID: l.strings[p.device],
+			Endpoint: l.strings[p.endpoint],
+		})
+	}
+	return out
+}
+
+// Sweep drops points older than the retention and returns how many were dropped.
+// The string table is rebuilt once most of it is no longer referenced.
+func (l *ActivityLog) Sweep() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff := l.now().Add(-l.cfg.Retention).UnixMilli()
+	dropped, live := 0, 0
+	for key, points := range l.series {
+		keep := sort.Search(len(points), func(i int) bool { return points[i].at >= cutoff })
+		dropped += keep
+		if keep == len(points) {
+			delete(l.series, key)
+			continue
+		}
+		if keep > 0 {
+			points = points[:copy(points, points[keep:])]
+			l.series[key] = points
+		}
+		live += len(points)
+	}
+	if len(l.strings) > minActivityCompaction && len(l.strings) > 2*live {
+		l.compact()
+	}
+	return dropped
+}
+
+// compact rebuilds the string table from the points still stored. Callers must hold l.mu.
+func (l *ActivityLog) compact() {
+	old := l.strings
+	l.strings, l.index = nil, make(map[string]uint32)
+	for _, points := range l.series {
+		for i := range points {
+			points[i].ip = l.intern(old[points[i].ip])
+			points[i].device = l.intern(old[points[i].device])
+			points[i].endpoint = l.intern(old[points[i].endpoint])
+		}
+	}
+}
+
+// Run sweeps expired points every interval until ctx is cancelled.
+func (l *ActivityLog) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if dropped := l.Sweep(); dropped > 0 {
+				log.Debugf("device-binding: dropped %d expired activity point(s)", dropped)
+			}
+		}
+	}
+}
+
+// SetActivityLog enables the activity timeline endpoint.
+func (h *Handler) SetActivityLog(activity *ActivityLog) {
+	h.activity = activity
+}
+
+// GetActivity returns the request timeline of an API key, by default around its most recent ban
+// GET /v0/management/device-bindings/activity?api-key=xxx[&ban=0&before=900&after=300]
+// GET /v0/management/device-bindings/activity?api-key=xxx&from=RFC3339&to=RFC3339
+// ban indexes the key's ban history newest first; before/after are seconds around the ban.
+func (h *Handler) GetActivity(c *gin.Context) {
+	if h.activity == nil {
+		c.JSON(404, gin.H{
+			"error":   "not_enabled",
+			"message": "Activity recording is disabled; enable device-binding.activity",
+		})
+		return
+	}
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	if apiKey == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+
+	body := gin.H{"api_key": apiKey}
+	var from, to time.Time
+	if c.Query("from") != "" || c.Query("to") != "" {
+		var ok bool
+		if from, to, ok = activityRange(c); !ok {
+			return
+		}
+	} else {
+		binding, exists := h.store.Get(apiKey)
+		index, err := strconv.Atoi(c.DefaultQuery("ban", "0"))
+		if !exists || err != nil || index < 0 || index >= len(binding.BanHistory) {
+			c.JSON(404, gin.H{
+				"error":   "not_found",
+				"message": "No such ban in the history of this API key; pass from/to for an arbitrary range",
+			})
+			return
+		}
+		ban := binding.BanHistory[len(binding.BanHistory)-1-index]
+		before, ok := activitySeconds(c, "before", defaultActivityBeforeBan)
+		if !ok {
+			return
+		}
+		after, ok := activitySeconds(c, "after", defaultActivityAfterBan)
+		if !ok {
+			return
+		}
+		from, to = ban.BannedAt.Add(-before), ban.BannedAt.Add(after)
+		body["ban"] = ban
+	}
+
+	points := h.activity.Query(apiKey, from, to)
+	ips, devices := map[string]int{}, map[string]int{}
+	for _, p := range points {
+		ips[p.IP]++
+		devices[p.DeviceID]++
+	}
+	body["from"], body["to"] = from.UTC(), to.UTC()
+	body["points"] = points
+	body["ips"], body["devices"] = ips, devices
+	c.JSON(200, body)
+}
+
+// activityRange parses the from/to query parameters, defaulting to the last hour.
+func activityRange(c *gin.Context) (time.Time, time.Time, bool) {
+	to, from := time.Now(), time.Time{}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		raw := strings.TrimSpace(c.Query(p.name))
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error":   "invalid_parameter",
+				"message": p.name + " must be an RFC3339 timestamp",
+			})
+			return time.Time{}, time.Time{}, false
+		}
+		*p.dst = t
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultActivityQueryWindow)
+	}
+	return from, to, true
+}
+
+// activitySeconds parses a non-negative number of seconds from the query.
+func activitySeconds(c *gin.Context, name string, fallback time.Duration) (time.Duration, bool) {
+	raw := strings.TrimSpace(c.Query(name))
+	if raw == "" {
+		return fallback, true
+	}
+	seconds, err := strconv.Atoi(raw)
+	if err != nil || seconds < 0 {
+		c.JSON(400, gin.H{
+			"error":   "invalid_parameter",
+			"message": name + " must be a non-negative number of seconds",
+		})
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
@@ -0,0 +1,79 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestActivityLogTrimsSweepsAndCompacts(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	activity := NewActivityLog(ActivityConfig{Retention: time.Hour, MaxPointsPerKey: 8})
+	activity.now = func() time.Time { return now }
+	for i := 0; i < 20; i++ {
+		activity.Record("key-a", "laptop", fmt.Sprintf("10.0.0.%d", i), "/v1/messages")
+		now = now.Add(time.Second)
+	}
+	points := activity.Query("key-a", time.Time{}, now)
+	if len(points) < 8 || len(points) > 9 || points[len(points)-1].IP != "10.0.0.19" {
+		t.Fatalf("expected the newest points to be kept, got %+v", points)
+	}
+	if got := activity.Query("key-a", now.Add(-3*time.Second), now); len(got) != 3 || got[0].IP != "10.0.0.17" {
+		t.Fatalf("unexpected range query %+v", got)
+	}
+
+	for i := 0; i < minActivityCompaction; i++ {
+		activity.Record("key-b", fmt.Sprintf("device-%d", i), "10.0.1.1", "/v1/messages")
+	}
+	now = now.Add(90 * time.Minute)
+	activity.Record("key-c", "phone", "10.0.2.1", "/v1/chat/completions")
+	if dropped := activity.Sweep(); dropped == 0 {
+		t.Fatal("expected expired points to be dropped")
+	}
+	if len(activity.series) != 1 || len(activity.strings) != 3 {
+		t.Fatalf("expected only key-c and its strings to remain, got %d series, %d strings", len(activity.series), len(activity.strings))
+	}
+	if got := activity.Query("key-c", time.Time{}, now); len(got) != 1 || got[0].DeviceID != "phone" || got[0].Endpoint != "/v1/chat/completions" {
+		t.Fatalf("expected compaction to keep points intact, got %+v", got)
+	}
+}
+
+func TestActivityTimelineAroundBan(t *testing.T) {
+	activity := NewActivityLog(ActivityConfig{})
+	engine, store := newTestEngine(t, Config{Activity: activity})
+	handler := NewHandler(store)
+	handler.SetActivityLog(activity)
+	handler.RegisterRoutes(engine.Group("/"))
+	const key = "key-123456789"
+
+	if rec := doRequest(engine, key, "laptop", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected first request to pass, got %d", rec.Code)
+	}
+	if rec := doRequest(engine, key, "laptop", "10.9.9.9"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected concurrent usage to ban the key, got %d", rec.Code)
+	}
+	doRequest(engine, key, "laptop", "10.9.9.9")
+
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device-bindings/activity?api-key="+key, nil))
+	var body struct {
+		Ban    *BanRecord      `json:"ban"`
+		Points []ActivityPoint `json:"points"`
+		IPs    map[string]int  `json:"ips"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	if body.Ban == nil || len(body.Points) != 3 || body.Points[0].IP != "10.0.0.1" || body.IPs["10.9.9.9"] != 2 {
+		t.Fatalf("expected the timeline to include the rejected requests, got %+v", body)
+	}
+
+	rec = httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device-bindings/activity?api-key="+key+"&ban=1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a ban that does not exist, got %d", rec.Code)
+	}
+}
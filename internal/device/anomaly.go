@@ -0,0 +1,193 @@
+package device
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Anomaly signals scored for a request of a known device
+const (
+	SignalIP           = "ip"
+	SignalUserAgent    = "user-agent"
+	SignalDeviceHeader = "device-header"
+	SignalTLS          = "tls-fingerprint"
+	SignalCadence      = "cadence"
+)
+
+// Default anomaly scoring weights and thresholds. An IP change alone warns;
+// it takes a second signal such as a new TLS fingerprint to ban.
+const (
+	defaultWeightIP           = 50
+	defaultWeightUserAgent    = 30
+	defaultWeightDeviceHeader = 30
+	defaultWeightTLS          = 40
+	defaultWeightCadence      = 20
+	defaultWarnScore          = 50
+	defaultBanScore           = 90
+	// maxCadenceSamples bounds the request times kept per device for the cadence signal.
+	maxCadenceSamples = 600
+	// anomalyProfileTTL drops profiles of devices idle for this long.
+	anomalyProfileTTL = 24 * time.Hour
+)
+
+// AnomalyConfig configures request pattern scoring. Instead of banning on an IP
+// mismatch alone, each signal that changed within the concurrent-usage window
+// adds its weight to a score compared against WarnScore and BanScore.
+type AnomalyConfig struct {
+	Enabled bool
+	// Weights maps signal names to their score; signals not listed use the defaults.
+	Weights map[string]int
+	// WarnScore warns the client and logs; BanScore records a strike as concurrent usage.
+	WarnScore int
+	BanScore  int
+	// CadenceMaxPerMinute fires the cadence signal when one device sends more requests than
+	// this within a minute. Zero disables the cadence signal.
+	CadenceMaxPerMinute int
+}
+
+// AnomalyResult is the score of one request and the signals that contributed.
+type AnomalyResult struct {
+	Score   int
+	Signals []string
+}
+
+// requestTraits are the observable properties of one request of a device.
+type requestTraits struct {
+	userAgent    string
+	deviceHeader string
+	tls          string
+	at           time.Time
+}
+
+// deviceProfile is what was last seen from one device of a key.
+type deviceProfile struct {
+	userAgent    string
+	deviceHeader string
+	tls          string
+	lastSeen     time.Time
+	recent       []time.Time
+}
+
+// anomalyScorer keeps in-memory request profiles per device and scores new
+// requests against them.
+type anomalyScorer struct {
+	mu       sync.Mutex
+	cfg      AnomalyConfig
+	weights  map[string]int
+	profiles map[string]*deviceProfile // api key + "\x00" + device ID -> profile
+	swept    time.Time
+}
+
+func newAnomalyScorer(cfg AnomalyConfig) *anomalyScorer {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.WarnScore <= 0 {
+		cfg.WarnScore = defaultWarnScore
+	}
+	if cfg.BanScore <= 0 {
+		cfg.BanScore = defaultBanScore
+	}
+	weights := map[string]int{
+		SignalIP:           defaultWeightIP,
+		SignalUserAgent:    defaultWeightUserAgent,
+		SignalDeviceHeader: defaultWeightDeviceHeader,
+		SignalTLS:          defaultWeightTLS,
+		SignalCadence:      defaultWeightCadence,
+	}
+	for signal, weight := range cfg.Weights {
+		signal = strings.ToLower(strings.TrimSpace(signal))
+		if _, known := weights[signal]; known && weight >= 0 {
+			weights[signal] = weight
+		}
+	}
+	return &anomalyScorer{cfg: cfg, weights: weights, profiles: make(map[string]*deviceProfile)}
+}
+
+// score records the traits of a request and returns the score of what changed
+// since the device's previous request within window. ipChanged is decided by
+// the caller, after trusted network, carrier and travel exemptions.
+func (a *anomalyScorer) score(apiKey, deviceID string, traits requestTraits, ipChanged bool, window time.Duration) AnomalyResult {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sweepLocked(traits.at)
+
+	id := apiKey + "\x00" + deviceID
+	profile := a.profiles[id]
+	if profile == nil {
+		profile = &deviceProfile{}
+		a.profiles[id] = profile
+	}
+	var result AnomalyResult
+	add := func(signal string) {
+		if weight := a.weights[signal]; weight > 0 {
+			result.Score += weight
+			result.Signals = append(result.Signals, signal)
+		}
+	}
+	recent := !profile.lastSeen.IsZero() && traits.at.Sub(profile.lastSeen) < window
+	if ipChanged {
+		add(SignalIP)
+	}
+	if recent {
+		if changed(profile.userAgent, traits.userAgent) {
+			add(SignalUserAgent)
+		}
+		if changed(profile.deviceHeader, traits.deviceHeader) {
+			add(SignalDeviceHeader)
+		}
+		if changed(profile.tls, traits.tls) {
+			add(SignalTLS)
+		}
+	}
+
+	cutoff := traits.at.Add(-time.Minute)
+	kept := profile.recent[:0]
+	for _, at := range profile.recent {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	profile.recent = append(kept, traits.at)
+	if len(profile.recent) > maxCadenceSamples {
+		profile.recent = profile.recent[len(profile.recent)-maxCadenceSamples:]
+	}
+	if a.cfg.CadenceMaxPerMinute > 0 && len(profile.recent) > a.cfg.CadenceMaxPerMinute {
+		add(SignalCadence)
+	}
+
+	profile.userAgent = keepSeen(profile.userAgent, traits.userAgent)
+	profile.deviceHeader = keepSeen(profile.deviceHeader, traits.deviceHeader)
+	profile.tls = keepSeen(profile.tls, traits.tls)
+	profile.lastSeen = traits.at
+	sort.Strings(result.Signals)
+	return result
+}
+
+// sweepLocked drops idle profiles at most once per TTL. Callers must hold a.mu.
+func (a *anomalyScorer) sweepLocked(now time.Time) {
+	if now.Sub(a.swept) < anomalyProfileTTL {
+		return
+	}
+	a.swept = now
+	for id, profile := range a.profiles {
+		if now.Sub(profile.lastSeen) > anomalyProfileTTL {
+			delete(a.profiles, id)
+		}
+	}
+}
+
+// changed reports whether a trait differs from the one seen before. Traits
+// that are missing on either side are not evidence of anything.
+func changed(previous, current string) bool {
+	return previous != "" && current != "" && previous != current
+}
+
+func keepSeen(previous, current string) string {
+	if current == "" {
+		return previous
+	}
+	return current
+}
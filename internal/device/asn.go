@@ -0,0 +1,102 @@
+package device
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// cgnatNetwork is the RFC 6598 shared address space used by carrier-grade NAT
+var cgnatNetwork = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+// carrierKeywords identify mobile carriers by their ASN organisation name
+var carrierKeywords = []string{"mobile", "wireless", "cellular", "lte", "5g", "telecom", "telekom", "telefonica", "vodafone", "t-mobile", "verizon", "viettel", "vinaphone"}
+
+// ASNInfo is the autonomous system an IP address belongs to
+type ASNInfo struct {
+	Number       uint
+	Organization string
+}
+
+// ASNResolver resolves IP addresses to autonomous systems
+type ASNResolver interface {
+	LookupASN(ip string) (ASNInfo, bool)
+	Close() error
+}
+
+// ASNDatabase looks up autonomous systems in a MaxMind GeoLite2 ASN database
+type ASNDatabase struct {
+	reader *geoip2.Reader
+}
+
+// OpenASN opens a MaxMind ASN database (.mmdb)
+func OpenASN(path string) (*ASNDatabase, error) {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open asn database: %w", err)
+	}
+	return &ASNDatabase{reader: reader}, nil
+}
+
+// LookupASN returns the autonomous system of ip
+func (d *ASNDatabase) LookupASN(ip string) (ASNInfo, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ASNInfo{}, false
+	}
+	record, err := d.reader.ASN(parsed)
+	if err != nil || record.AutonomousSystemNumber == 0 {
+		return ASNInfo{}, false
+	}
+	return ASNInfo{Number: record.AutonomousSystemNumber, Organization: record.AutonomousSystemOrganization}, true
+}
+
+// Close releases the database
+func (d *ASNDatabase) Close() error {
+	return d.reader.Close()
+}
+
+// isCarrierOrganization reports whether an ASN organisation name looks like a mobile carrier
+func isCarrierOrganization(org string) bool {
+	org = strings.ToLower(org)
+	for _, keyword := range carrierKeywords {
+		if strings.Contains(org, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// carrierIPChange reports whether an IP change is explained by mobile carrier
+// IP churn: both addresses belong to exempt ASNs, or, with DetectCGNAT, both sit
+// in the RFC 6598 CGNAT range or in the same ASN operated by a mobile carrier.
+func (m *Middleware) carrierIPChange(previousIP, currentIP string) (bool, string) {
+	if m.config.DetectCGNAT {
+		prev, cur := net.ParseIP(previousIP), net.ParseIP(currentIP)
+		if prev != nil && cur != nil && cgnatNetwork.Contains(prev) && cgnatNetwork.Contains(cur) {
+			return true, "cgnat range"
+		}
+	}
+	if m.asn == nil {
+		return false, ""
+	}
+	from, ok := m.asn.LookupASN(previousIP)
+	if !ok {
+		return false, ""
+	}
+	to, ok := m.asn.LookupASN(currentIP)
+	if !ok {
+		return false, ""
+	}
+	_, fromExempt := m.exemptASNs[from.Number]
+	_, toExempt := m.exemptASNs[to.Number]
+	if fromExempt && toExempt {
+		return true, fmt.Sprintf("exempt AS%d -> AS%d", from.Number, to.Number)
+	}
+	if m.config.DetectCGNAT && from.Number == to.Number && isCarrierOrganization(from.Organization) {
+		return true, fmt.Sprintf("carrier AS%d (%s)", from.Number, from.Organization)
+	}
+	return false, ""
+}
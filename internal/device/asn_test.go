@@ -0,0 +1,51 @@
+package device
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type fakeASNResolver map[string]ASNInfo
+
+func (f fakeASNResolver) LookupASN(ip string) (ASNInfo, bool) {
+	info, ok := f[ip]
+	return info, ok
+}
+
+func (f fakeASNResolver) Close() error { return nil }
+
+func TestMiddlewareExemptsCarrierIPChurn(t *testing.T) {
+	engine, store := newLookupTestEngine(t, Config{ConcurrentThreshold: time.Minute, ExemptASNs: []uint{7552}, DetectCGNAT: true}, func(mw *Middleware) {
+		mw.asn = fakeASNResolver{
+			"203.0.113.1":  {Number: 7552, Organization: "Viettel Group"},
+			"203.0.113.2":  {Number: 7552, Organization: "Viettel Group"},
+			"198.51.100.1": {Number: 64500, Organization: "Example Mobile Networks"},
+			"198.51.100.2": {Number: 64500, Organization: "Example Mobile Networks"},
+			"192.0.2.1":    {Number: 64501, Organization: "Example Hosting"},
+		}
+	})
+	// Exempt ASN, CGNAT range and same carrier ASN are all tolerated.
+	for i, ips := range [][2]string{
+		{"203.0.113.1", "203.0.113.2"},
+		{"100.64.0.1", "100.127.255.1"},
+		{"198.51.100.1", "198.51.100.2"},
+	} {
+		key := fmt.Sprintf("key-carrier-%d", i)
+		for _, ip := range ips {
+			if rec := doRequest(engine, key, "dev-a", ip); rec.Code != http.StatusOK {
+				t.Fatalf("expected request from %s to pass, got %d", ip, rec.Code)
+			}
+		}
+		if binding, _ := store.Get(key); binding.Strikes != 0 {
+			t.Fatalf("expected no strikes for carrier IP churn %v, got %d", ips, binding.Strikes)
+		}
+	}
+
+	key := "key-123456789"
+	doRequest(engine, key, "dev-a", "198.51.100.1")
+	if rec := doRequest(engine, key, "dev-a", "192.0.2.1"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected change to a non-carrier ASN to be rejected, got %d", rec.Code)
+	}
+}
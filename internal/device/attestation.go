@@ -0,0 +1,603 @@
+package device
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Attestation platforms and verdicts
+const (
+	AttestationPlatformIOS     = "ios"
+	AttestationPlatformAndroid = "android"
+
+	AttestationTrusted   = "trusted"
+	AttestationUntrusted = "untrusted"
+)
+
+const (
+	defaultChallengeTTL     = 5 * time.Minute
+	maxPendingChallenges    = 10000
+	appAttestFormat         = "apple-appattest"
+	playRecognized          = "PLAY_RECOGNIZED"
+	playDeviceIntegrity     = "MEETS_DEVICE_INTEGRITY"
+	playStrongIntegrity     = "MEETS_STRONG_INTEGRITY"
+	appAttestProduction     = "production"
+	appAttestDevelopment    = "development"
+	maxAttestationClockSkew = time.Minute
+)
+
+// oidAppleNonce is the App Attest credential certificate extension holding the nonce
+var oidAppleNonce = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 2}
+
+// Attestation is the verdict of a platform attestation recorded for a device
+type Attestation struct {
+	Platform string `yaml:"platform" json:"platform"` // "ios" or "android"
+	Verdict  string `yaml:"verdict" json:"verdict"`   // "trusted" or "untrusted"
+	// Labels are the platform signals behind the verdict, e.g. the App Attest
+	// environment or Play Integrity device recognition verdicts.
+	Labels []string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	// AppID is the attested bundle ID (iOS) or package name (Android).
+	AppID string `yaml:"app_id,omitempty" json:"app_id,omitempty"`
+	// KeyID is the App Attest key identifier (base64).
+	KeyID      string    `yaml:"key_id,omitempty" json:"key_id,omitempty"`
+	VerifiedAt time.Time `yaml:"verified_at" json:"verified_at"`
+}
+
+// Trusted reports whether the attestation vouches for a genuine app on a genuine device
+func (a *Attestation) Trusted() bool {
+	return a != nil && a.Verdict == AttestationTrusted
+}
+
+func (a *Attestation) clone() *Attestation {
+	if a == nil {
+		return nil
+	}
+	out := *a
+	out.Labels = slices.Clone(a.Labels)
+	return &out
+}
+
+// SetAttestation records the attestation of a device. It reports whether the device was found.
+func (d *DeviceBindings) SetAttestation(apiKey, deviceID string, attestation *Attestation) bool {
+	binding, exists := d.Bindings[apiKey]
+	if !exists {
+		return false
+	}
+	idx := binding.FindDevice(deviceID)
+	if idx < 0 {
+		return false
+	}
+	binding.Devices[idx].Attestation = attestation.clone()
+	d.Bindings[apiKey] = binding
+	return true
+}
+
+// SetAttestation records the attestation of a device and persists
+func (s *FileStore) SetAttestation(apiKey, deviceID string, attestation *Attestation) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.bindings.SetAttestation(apiKey, deviceID, attestation) {
+		return false, nil
+	}
+	return true, s.save()
+}
+
+// AttestationConfig configures verification of mobile device attestations
+type AttestationConfig struct {
+	// Required rejects devices without a trusted attestation for every key
+	Required bool
+	// ChallengeTTL is how long an issued challenge can be used; zero means five minutes
+	ChallengeTTL time.Duration
+	// AppleAppIDs are the accepted App Attest app IDs ("<team id>.<bundle id>")
+	AppleAppIDs []string
+	// AppleRootCA is the path of the Apple App Attestation root CA certificate (PEM)
+	AppleRootCA string
+	// AllowDevelopment accepts keys from the App Attest development environment
+	AllowDevelopment bool
+	// AndroidPackageNames are the accepted Play Integrity package names
+	AndroidPackageNames []string
+	// PlayDecryptionKey is the base64 AES-256 key that decrypts Play Integrity tokens
+	PlayDecryptionKey string
+	// PlayVerificationKey is the base64 DER EC public key that verifies Play Integrity tokens
+	PlayVerificationKey string
+}
+
+// attestationRequest is the attestation a client sends with POST /v0/device/register
+type attestationRequest struct {
+	Platform  string `json:"platform"`
+	Challenge string `json:"challenge"`
+	// KeyID and AttestationObject are the base64 App Attest key ID and attestation object
+	// produced by DCAppAttestService.attestKey with clientDataHash = SHA256(challenge).
+	KeyID             string `json:"key_id"`
+	AttestationObject string `json:"attestation_object"`
+	// IntegrityToken is the Play Integrity token requested with the challenge as nonce.
+	IntegrityToken string `json:"integrity_token"`
+}
+
+// attestationVerifier issues single-use challenges and verifies attestations against them
+type attestationVerifier struct {
+	ttl              time.Duration
+	appleRoots       *x509.CertPool
+	appleAppIDs      []string
+	allowDevelopment bool
+	androidPackages  []string
+	playDecryption   []byte
+	playVerification *ecdsa.PublicKey
+	now              func() time.Time
+
+	mu         sync.Mutex
+	challenges map[string]pendingChallenge
+}
+
+type pendingChallenge struct {
+	apiKey  string
+	expires time.Time
+}
+
+// newAttestationVerifier prepares the configured platforms, logging and skipping
+// the ones whose keys or certificates cannot be loaded.
+func newAttestationVerifier(cfg AttestationConfig) *attestationVerifier {
+	v := &attestationVerifier{
+		ttl:              cfg.ChallengeTTL,
+		appleAppIDs:      cfg.AppleAppIDs,
+		allowDevelopment: cfg.AllowDevelopment,
+		androidPackages:  cfg.AndroidPackageNames,
+		now:              time.Now,
+		challenges:       make(map[string]pendingChallenge),
+	}
+	if v.ttl <= 0 {
+		v.ttl = defaultChallengeTTL
+	}
+	if path := strings.TrimSpace(cfg.AppleRootCA); path != "" {
+		if pool, err := loadCertPool(path); err != nil {
+			log.Warnf("device-binding: App Attest disabled: %v", err)
+		} else {
+			v.appleRoots = pool
+		}
+	}
+	if cfg.PlayDecryptionKey != "" || cfg.PlayVerificationKey != "" {
+		decryption, verification, err := parsePlayKeys(cfg.PlayDecryptionKey, cfg.PlayVerificationKey)
+		if err != nil {
+			log.Warnf("device-binding: Play Integrity disabled: %v", err)
+		} else {
+			v.playDecryption, v.playVerification = decryption, verification
+		}
+	}
+	return v
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read root CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificate in %s", path)
+	}
+	return pool, nil
+}
+
+func parsePlayKeys(decryptionKey, verificationKey string) ([]byte, *ecdsa.PublicKey, error) {
+	decryption, err := base64.StdEncoding.DecodeString(strings.TrimSpace(decryptionKey))
+	if err != nil || len(decryption) != 32 {
+		return nil, nil, errors.New("decryption key must be a base64 AES-256 key")
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(verificationKey))
+	if err != nil {
+		return nil, nil, errors.New("verification key must be base64")
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse verification key: %w", err)
+	}
+	ecKey, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, nil, errors.New("verification key is not an EC public key")
+	}
+	return decryption, ecKey, nil
+}
+
+// issueChallenge returns a new single-use challenge bound to the API key
+func (v *attestationVerifier) issueChallenge(apiKey string) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	challenge := base64.RawURLEncoding.EncodeToString(buf)
+	now := v.now()
+	expires := now.Add(v.ttl)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.challenges) >= maxPendingChallenges {
+		for key, pending := range v.challenges {
+			if now.After(pending.expires) {
+				delete(v.challenges, key)
+			}
+		}
+		if len(v.challenges) >= maxPendingChallenges {
+			return "", time.Time{}, errors.New("too many pending attestation challenges")
+		}
+	}
+	v.challenges[challenge] = pendingChallenge{apiKey: apiKey, expires: expires}
+	return challenge, expires, nil
+}
+
+// consumeChallenge invalidates a challenge and reports whether it was issued to the API key and is unexpired
+func (v *attestationVerifier) consumeChallenge(apiKey, challenge string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	pending, ok := v.challenges[challenge]
+	if !ok {
+		return false
+	}
+	delete(v.challenges, challenge)
+	return pending.apiKey == apiKey && !v.now().After(pending.expires)
+}
+
+// verify checks an attestation for the API key and returns its verdict. An
+// attestation that fails verification is an error; a valid one can still be untrusted.
+func (v *attestationVerifier) verify(apiKey string, req *attestationRequest) (*Attestation, error) {
+	if req.Challenge == "" || !v.consumeChallenge(apiKey, req.Challenge) {
+		return nil, errors.New("unknown or expired challenge")
+	}
+	switch strings.ToLower(strings.TrimSpace(req.Platform)) {
+	case AttestationPlatformIOS:
+		return v.verifyAppAttest(req)
+	case AttestationPlatformAndroid:
+		return v.verifyPlayIntegrity(req)
+	default:
+		return nil, errors.New("platform must be ios or android")
+	}
+}
+
+// verifyAppAttest validates an App Attest attestation object as described in
+// Apple's "Validating apps that connect to your server".
+func (v *attestationVerifier) verifyAppAttest(req *attestationRequest) (*Attestation, error) {
+	if v.appleRoots == nil || len(v.appleAppIDs) == 0 {
+		return nil, errors.New("App Attest is not configured")
+	}
+	raw, err := base64.StdEncoding.DecodeString(req.AttestationObject)
+	if err != nil {
+		return nil, errors.New("attestation_object must be base64")
+	}
+	keyID, err := base64.StdEncoding.DecodeString(req.KeyID)
+	if err != nil || len(keyID) != sha256.Size {
+		return nil, errors.New("key_id must be a base64 SHA-256 key identifier")
+	}
+	decoded, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, err
+	}
+	object, _ := decoded.(map[string]any)
+	if format, _ := object["fmt"].(string); format != appAttestFormat {
+		return nil, errors.New("attestation object is not in apple-appattest format")
+	}
+	authData, _ := object["authData"].([]byte)
+	statement, _ := object["attStmt"].(map[string]any)
+	chain, _ := statement["x5c"].([]any)
+	if len(authData) < 55 || len(chain) == 0 {
+		return nil, errors.New("attestation object is incomplete")
+	}
+
+	certs := make([]*x509.Certificate, 0, len(chain))
+	for _, item := range chain {
+		der, _ := item.([]byte)
+		cert, errParse := x509.ParseCertificate(der)
+		if errParse != nil {
+			return nil, fmt.Errorf("parse certificate: %w", errParse)
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	credential := certs[0]
+	if _, err = credential.Verify(x509.VerifyOptions{
+		Roots:         v.appleRoots,
+		Intermediates: intermediates,
+		CurrentTime:   v.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("certificate chain: %w", err)
+	}
+
+	clientDataHash := sha256.Sum256([]byte(req.Challenge))
+	nonce := sha256.Sum256(append(slices.Clip(authData), clientDataHash[:]...))
+	if !bytes.Equal(appAttestNonce(credential), nonce[:]) {
+		return nil, errors.New("nonce mismatch")
+	}
+	pub, ok := credential.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("credential key is not an EC key")
+	}
+	point, err := pub.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(point.Bytes()); !bytes.Equal(sum[:], keyID) {
+		return nil, errors.New("key_id does not match the credential certificate")
+	}
+
+	appID := ""
+	for _, candidate := range v.appleAppIDs {
+		if sum := sha256.Sum256([]byte(candidate)); bytes.Equal(sum[:], authData[:32]) {
+			appID = candidate
+			break
+		}
+	}
+	if appID == "" {
+		return nil, errors.New("app ID is not accepted")
+	}
+	if binary.BigEndian.Uint32(authData[33:37]) != 0 {
+		return nil, errors.New("sign counter must be zero")
+	}
+	var environment string
+	switch aaguid := authData[37:53]; {
+	case bytes.Equal(aaguid, append([]byte("appattest"), make([]byte, 7)...)):
+		environment = appAttestProduction
+	case bytes.Equal(aaguid, []byte("appattestdevelop")):
+		if !v.allowDevelopment {
+			return nil, errors.New("development App Attest keys are not accepted")
+		}
+		environment = appAttestDevelopment
+	default:
+		return nil, errors.New("unknown App Attest environment")
+	}
+	credentialLength := int(binary.BigEndian.Uint16(authData[53:55]))
+	if len(authData) < 55+credentialLength || !bytes.Equal(authData[55:55+credentialLength], keyID) {
+		return nil, errors.New("credential ID does not match key_id")
+	}
+
+	return &Attestation{
+		Platform:   AttestationPlatformIOS,
+		Verdict:    AttestationTrusted,
+		Labels:     []string{environment},
+		AppID:      appID,
+		KeyID:      req.KeyID,
+		VerifiedAt: v.now(),
+	}, nil
+}
+
+// appAttestNonce extracts the nonce from the credential certificate extension
+func appAttestNonce(cert *x509.Certificate) []byte {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAppleNonce) {
+			continue
+		}
+		var value struct {
+			Nonce []byte `asn1:"tag:1,explicit"`
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &value); err == nil {
+			return value.Nonce
+		}
+	}
+	return nil
+}
+
+// playIntegrityPayload is the part of a decoded Play Integrity verdict the proxy evaluates
+type playIntegrityPayload struct {
+	RequestDetails struct {
+		RequestPackageName string `json:"requestPackageName"`
+		Nonce              string `json:"nonce"`
+		TimestampMillis    string `json:"timestampMillis"`
+	} `json:"requestDetails"`
+	AppIntegrity struct {
+		AppRecognitionVerdict string `json:"appRecognitionVerdict"`
+	} `json:"appIntegrity"`
+	DeviceIntegrity struct {
+		DeviceRecognitionVerdict []string `json:"deviceRecognitionVerdict"`
+	} `json:"deviceIntegrity"`
+}
+
+// verifyPlayIntegrity decrypts and verifies a Play Integrity token locally with
+// the response encryption keys from the Play Console.
+func (v *attestationVerifier) verifyPlayIntegrity(req *attestationRequest) (*Attestation, error) {
+	if v.playDecryption == nil || len(v.androidPackages) == 0 {
+		return nil, errors.New("Play Integrity is not configured")
+	}
+	jws, err := decryptJWE(v.playDecryption, strings.TrimSpace(req.IntegrityToken))
+	if err != nil {
+		return nil, err
+	}
+	payload, err := verifyES256(v.playVerification, string(jws))
+	if err != nil {
+		return nil, err
+	}
+	var verdict playIntegrityPayload
+	if err = json.Unmarshal(payload, &verdict); err != nil {
+		return nil, fmt.Errorf("decode verdict: %w", err)
+	}
+	details := verdict.RequestDetails
+	if !slices.Contains(v.androidPackages, details.RequestPackageName) {
+		return nil, errors.New("package name is not accepted")
+	}
+	if strings.TrimRight(details.Nonce, "=") != req.Challenge {
+		return nil, errors.New("nonce mismatch")
+	}
+	millis, err := strconv.ParseInt(details.TimestampMillis, 10, 64)
+	if err != nil {
+		return nil, errors.New("verdict has no valid timestamp")
+	}
+	now := v.now()
+	issued := time.UnixMilli(millis)
+	if issued.After(now.Add(maxAttestationClockSkew)) || now.Sub(issued) > v.ttl {
+		return nil, errors.New("verdict is stale")
+	}
+
+	labels := append([]string{verdict.AppIntegrity.AppRecognitionVerdict}, verdict.DeviceIntegrity.DeviceRecognitionVerdict...)
+	trusted := verdict.AppIntegrity.AppRecognitionVerdict == playRecognized &&
+		(slices.Contains(labels, playDeviceIntegrity) || slices.Contains(labels, playStrongIntegrity))
+	result := &Attestation{
+		Platform:   AttestationPlatformAndroid,
+		Verdict:    AttestationUntrusted,
+		Labels:     labels,
+		AppID:      details.RequestPackageName,
+		VerifiedAt: now,
+	}
+	if trusted {
+		result.Verdict = AttestationTrusted
+	}
+	return result, nil
+}
+
+// decryptJWE decrypts a compact JWE using A256KW key wrapping and A256GCM content encryption
+func decryptJWE(key []byte, token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, errors.New("integrity token is not a JWE")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Enc string `json:"enc"`
+	}
+	if err := decodeJOSEHeader(parts[0], &header); err != nil || header.Alg != "A256KW" || header.Enc != "A256GCM" {
+		return nil, errors.New("integrity token must use A256KW and A256GCM")
+	}
+	segments := make([][]byte, 4)
+	for i := range segments {
+		raw, err := base64.RawURLEncoding.DecodeString(parts[i+1])
+		if err != nil {
+			return nil, errors.New("integrity token is malformed")
+		}
+		segments[i] = raw
+	}
+	cek, err := aesKeyUnwrap(key, segments[0])
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(segments[1]))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, segments[1], append(segments[2], segments[3]...), []byte(parts[0]))
+	if err != nil {
+		return nil, errors.New("integrity token decryption failed")
+	}
+	return plaintext, nil
+}
+
+// aesKeyUnwrap implements the RFC 3394 AES key unwrap
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, errors.New("wrapped key has an invalid length")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	a := binary.BigEndian.Uint64(wrapped[:8])
+	r := slices.Clone(wrapped[8:])
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			binary.BigEndian.PutUint64(buf[:8], a^uint64(n*j+i))
+			copy(buf[8:], r[(i-1)*8:i*8])
+			block.Decrypt(buf, buf)
+			a = binary.BigEndian.Uint64(buf[:8])
+			copy(r[(i-1)*8:i*8], buf[8:])
+		}
+	}
+	if a != 0xA6A6A6A6A6A6A6A6 {
+		return nil, errors.New("key unwrap failed")
+	}
+	return r, nil
+}
+
+// verifyES256 verifies a compact JWS signed with ES256 and returns its payload
+func verifyES256(key *ecdsa.PublicKey, token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("integrity verdict is not a JWS")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJOSEHeader(parts[0], &header); err != nil || header.Alg != "ES256" {
+		return nil, errors.New("integrity verdict must be signed with ES256")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return nil, errors.New("integrity verdict signature is malformed")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, errors.New("integrity verdict signature is invalid")
+	}
+	return base64.RawURLEncoding.DecodeString(parts[1])
+}
+
+func decodeJOSEHeader(segment string, out any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// AttestationChallenge issues a single-use challenge for attesting a device at registration
+// POST /v0/device/attestation/challenge
+func (m *Middleware) AttestationChallenge(c *gin.Context) {
+	apiKey := c.GetString("apiKey")
+	if apiKey == "" {
+		c.JSON(401, gin.H{
+			"error":   "unauthorized",
+			"message": "A valid API key is required",
+		})
+		return
+	}
+	challenge, expires, err := m.attestation.issueChallenge(apiKey)
+	if err != nil {
+		log.Warnf("device-binding: failed to issue attestation challenge for key %s: %v", MaskKey(apiKey), err)
+		c.JSON(503, gin.H{
+			"error":   "challenge_unavailable",
+			"message": "Attestation challenges are temporarily unavailable, retry later",
+		})
+		return
+	}
+	c.JSON(201, gin.H{
+		"challenge":  challenge,
+		"expires_at": expires,
+	})
+}
+
+// abortUnattested rejects a request from a device without a trusted attestation
+// when the key's policy requires one.
+func abortUnattested(c *gin.Context, apiKey, deviceID string) {
+	log.Warnf("device-binding: rejected unattested device of key %s: %s", MaskKey(apiKey), deviceID)
+	bindingDecisions.Inc(decisionUnattested)
+	c.AbortWithStatusJSON(403, gin.H{
+		"error":     "attestation_required",
+		"message":   "This API key only accepts attested devices. Register this device via POST /v0/device/register with an App Attest or Play Integrity attestation.",
+		"device_id": deviceID,
+	})
+}
@@ -0,0 +1,399 @@
+package device
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// playSigner produces Play Integrity tokens the way Google encrypts and signs them
+type playSigner struct {
+	decryptionKey []byte
+	signingKey    *ecdsa.PrivateKey
+}
+
+func newPlaySigner(t *testing.T) *playSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryption := make([]byte, 32)
+	_, _ = rand.Read(decryption)
+	return &playSigner{decryptionKey: decryption, signingKey: key}
+}
+
+func (p *playSigner) config(t *testing.T) AttestationConfig {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&p.signingKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return AttestationConfig{
+		AndroidPackageNames: []string{"com.example.app"},
+		PlayDecryptionKey:   base64.StdEncoding.EncodeToString(p.decryptionKey),
+		PlayVerificationKey: base64.StdEncoding.EncodeToString(der),
+	}
+}
+
+func (p *playSigner) token(t *testing.T, nonce string, deviceVerdicts ...string) string {
+	t.Helper()
+	payload, _ := json.Marshal(map[string]any{
+		"requestDetails": map[string]any{
+			"requestPackageName": "com.example.app",
+			"nonce":              nonce,
+			"timestampMillis":    fmt.Sprint(time.Now().UnixMilli()),
+		},
+		"appIntegrity":    map[string]any{"appRecognitionVerdict": "PLAY_RECOGNIZED"},
+		"deviceIntegrity": map[string]any{"deviceRecognitionVerdict": deviceVerdicts},
+	})
+	signingInput := b64url([]byte(`{"alg":"ES256"}`)) + "." + b64url(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, p.signingKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	jws := signingInput + "." + b64url(sig)
+
+	header := b64url([]byte(`{"alg":"A256KW","enc":"A256GCM"}`))
+	cek := make([]byte, 32)
+	iv := make([]byte, 12)
+	_, _ = rand.Read(cek)
+	_, _ = rand.Read(iv)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	sealed := gcm.Seal(nil, iv, []byte(jws), []byte(header))
+	tagStart := len(sealed) - gcm.Overhead()
+	return strings.Join([]string{header, b64url(aesKeyWrap(t, p.decryptionKey, cek)), b64url(iv), b64url(sealed[:tagStart]), b64url(sealed[tagStart:])}, ".")
+}
+
+// aesKeyWrap implements the RFC 3394 AES key wrap
+func aesKeyWrap(t *testing.T, kek, plain []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(plain) / 8
+	a := uint64(0xA6A6A6A6A6A6A6A6)
+	r := append([]byte(nil), plain...)
+	buf := make([]byte, 16)
+	for j := 0; j <= 5; j++ {
+		for i := 1; i <= n; i++ {
+			binary.BigEndian.PutUint64(buf[:8], a)
+			copy(buf[8:], r[(i-1)*8:i*8])
+			block.Encrypt(buf, buf)
+			a = binary.BigEndian.Uint64(buf[:8]) ^ uint64(n*j+i)
+			copy(r[(i-1)*8:i*8], buf[8:])
+		}
+	}
+	return append(binary.BigEndian.AppendUint64(nil, a), r...)
+}
+
+func b64url(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestRegisterDeviceRequiresTrustedPlayIntegrity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	play := newPlaySigner(t)
+	attestation := play.config(t)
+	attestation.Required = true
+	mw := NewMiddleware(store, Config{Enabled: true, MaxDevices: 3, TokenSecret: "secret", Attestation: attestation})
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("X-Test-Key"))
+		c.Next()
+	})
+	engine.POST("/v0/device/register", mw.RegisterDevice)
+	engine.POST("/v0/device/attestation/challenge", mw.AttestationChallenge)
+	engine.GET("/", mw.Handler(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	post := func(path, body string) (int, map[string]any) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("X-Test-Key", "key-1")
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		var resp map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+	challenge := func() string {
+		code, resp := post("/v0/device/attestation/challenge", "")
+		if code != http.StatusCreated {
+			t.Fatalf("challenge: expected 201, got %d", code)
+		}
+		return resp["challenge"].(string)
+	}
+	register := func(deviceID, nonce, token string) (int, map[string]any) {
+		return post("/v0/device/register", fmt.Sprintf(`{"device_id":%q,"attestation":{"platform":"android","challenge":%q,"integrity_token":%q}}`, deviceID, nonce, token))
+	}
+
+	if code, resp := post("/v0/device/register", `{"device_id":"plain"}`); code != http.StatusForbidden || resp["error"] != "attestation_required" {
+		t.Fatalf("expected unattested registration to be rejected, got %d %v", code, resp)
+	}
+
+	nonce := challenge()
+	code, resp := register("phone", nonce, play.token(t, nonce, "MEETS_DEVICE_INTEGRITY"))
+	if code != http.StatusCreated || resp["attestation"].(map[string]any)["verdict"] != AttestationTrusted {
+		t.Fatalf("expected attested registration, got %d %v", code, resp)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Test-Key", "key-1")
+	req.Header.Set(defaultTokenHeader, resp["device_token"].(string))
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected attested device to be accepted, got %d", rec.Code)
+	}
+	binding, _ := store.Get("key-1")
+	if dev := binding.Devices[0]; !dev.Attestation.Trusted() || dev.Attestation.AppID != "com.example.app" {
+		t.Fatalf("expected stored attestation, got %+v", dev.Attestation)
+	}
+
+	if code, resp = register("tablet", nonce, play.token(t, nonce, "MEETS_DEVICE_INTEGRITY")); code != http.StatusForbidden || resp["error"] != "attestation_failed" {
+		t.Fatalf("expected a reused challenge to be rejected, got %d %v", code, resp)
+	}
+	nonce = challenge()
+	if code, resp = register("emulator", nonce, play.token(t, nonce, "MEETS_BASIC_INTEGRITY")); code != http.StatusForbidden || resp["error"] != "attestation_required" {
+		t.Fatalf("expected an untrusted verdict to be rejected, got %d %v", code, resp)
+	}
+	nonce = challenge()
+	forged := newPlaySigner(t)
+	forged.decryptionKey = play.decryptionKey
+	if code, resp = register("forged", nonce, forged.token(t, nonce, "MEETS_DEVICE_INTEGRITY")); code != http.StatusForbidden || resp["error"] != "attestation_failed" {
+		t.Fatalf("expected a token with a foreign signature to be rejected, got %d %v", code, resp)
+	}
+}
+
+// failingAttestationStore loses every attestation write
+type failingAttestationStore struct {
+	Store
+}
+
+func (failingAttestationStore) SetAttestation(string, string, *Attestation) (bool, error) {
+	return false, errors.New("disk full")
+}
+
+func TestRegisterDeviceRollsBackWhenAttestationIsNotStored(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	play := newPlaySigner(t)
+	attestation := play.config(t)
+	attestation.Required = true
+	mw := NewMiddleware(failingAttestationStore{Store: store}, Config{Enabled: true, MaxDevices: 3, TokenSecret: "secret", Attestation: attestation})
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", "key-1")
+		c.Next()
+	})
+	engine.POST("/v0/device/register", mw.RegisterDevice)
+	engine.POST("/v0/device/attestation/challenge", mw.AttestationChallenge)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec
+	}
+	var challenge struct {
+		Challenge string `json:"challenge"`
+	}
+	_ = json.Unmarshal(post("/v0/device/attestation/challenge", "").Body.Bytes(), &challenge)
+	body := fmt.Sprintf(`{"device_id":"phone","attestation":{"platform":"android","challenge":%q,"integrity_token":%q}}`, challenge.Challenge, play.token(t, challenge.Challenge, "MEETS_DEVICE_INTEGRITY"))
+	if rec := post("/v0/device/register", body); rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "device_token") {
+		t.Fatalf("expected a 500 without a token, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if binding, _ := store.Get("key-1"); len(binding.Devices) != 0 {
+		t.Fatalf("expected the device to be rolled back, got %+v", binding.Devices)
+	}
+}
+
+func TestPolicyRequireAttestationRejectsHeaderDevices(t *testing.T) {
+	engine, store := newTestEngine(t, Config{MaxDevices: 2})
+	if rec := doRequest(engine, "key-attest", "laptop", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected device to be accepted before the policy, got %d", rec.Code)
+	}
+	if err := store.SetPolicy("key-attest", &Policy{RequireAttestation: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dev := range []string{"laptop", "desktop"} {
+		if rec := doRequest(engine, "key-attest", dev, "10.0.0.1"); rec.Code != http.StatusForbidden {
+			t.Fatalf("expected unattested %s to be rejected, got %d", dev, rec.Code)
+		}
+	}
+	if rec := doRequest(engine, "other-key", "laptop", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected keys without the policy to be unaffected, got %d", rec.Code)
+	}
+}
+
+// appAttestFixture issues App Attest attestation objects from a test root CA
+type appAttestFixture struct {
+	root     *x509.Certificate
+	rootKey  *ecdsa.PrivateKey
+	rootPath string
+}
+
+func newAppAttestFixture(t *testing.T) *appAttestFixture {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test App Attestation Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := x509.ParseCertificate(der)
+	path := filepath.Join(t.TempDir(), "root.pem")
+	if err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return &appAttestFixture{root: root, rootKey: key, rootPath: path}
+}
+
+// attest returns the base64 key ID and attestation object for the app ID and challenge
+func (f *appAttestFixture) attest(t *testing.T, appID, aaguid, challenge string) (string, string) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	point, _ := key.PublicKey.ECDH()
+	keyID := sha256.Sum256(point.Bytes())
+
+	rpIDHash := sha256.Sum256([]byte(appID))
+	authData := append(rpIDHash[:], 0x40, 0, 0, 0, 0)
+	authData = append(authData, []byte(aaguid)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(keyID)))
+	authData = append(authData, keyID[:]...)
+
+	clientDataHash := sha256.Sum256([]byte(challenge))
+	nonce := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	extension, _ := asn1.Marshal(struct {
+		Nonce []byte `asn1:"tag:1,explicit"`
+	}{nonce[:]})
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		Subject:         pkix.Name{CommonName: "credential"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidAppleNonce, Value: extension}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, f.root, &key.PublicKey, f.rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var object []byte
+	object = cborHead(object, 5, 3)
+	object = cborText(object, "fmt")
+	object = cborText(object, appAttestFormat)
+	object = cborText(object, "attStmt")
+	object = cborHead(object, 5, 2)
+	object = cborText(object, "x5c")
+	object = cborHead(object, 4, 1)
+	object = cborBytes(object, der)
+	object = cborText(object, "receipt")
+	object = cborBytes(object, []byte("receipt"))
+	object = cborText(object, "authData")
+	object = cborBytes(object, authData)
+	return base64.StdEncoding.EncodeToString(keyID[:]), base64.StdEncoding.EncodeToString(object)
+}
+
+func cborHead(out []byte, major byte, n int) []byte {
+	switch {
+	case n < 24:
+		return append(out, major<<5|byte(n))
+	case n < 256:
+		return append(out, major<<5|24, byte(n))
+	default:
+		return binary.BigEndian.AppendUint16(append(out, major<<5|25), uint16(n))
+	}
+}
+
+func cborText(out []byte, s string) []byte {
+	return append(cborHead(out, 3, len(s)), s...)
+}
+
+func cborBytes(out, b []byte) []byte {
+	return append(cborHead(out, 2, len(b)), b...)
+}
+
+func TestVerifyAppAttest(t *testing.T) {
+	fixture := newAppAttestFixture(t)
+	const appID = "ABCDE12345.com.example.app"
+	production := "appattest\x00\x00\x00\x00\x00\x00\x00"
+	verifier := newAttestationVerifier(AttestationConfig{AppleAppIDs: []string{appID}, AppleRootCA: fixture.rootPath})
+
+	attest := func(apiKey, appID, aaguid string) (*Attestation, error) {
+		challenge, _, err := verifier.issueChallenge("key-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyID, object := fixture.attest(t, appID, aaguid, challenge)
+		return verifier.verify(apiKey, &attestationRequest{Platform: "ios", Challenge: challenge, KeyID: keyID, AttestationObject: object})
+	}
+
+	got, err := attest("key-1", appID, production)
+	if err != nil || !got.Trusted() || got.AppID != appID || got.Labels[0] != appAttestProduction {
+		t.Fatalf("expected a trusted production attestation, got %+v, %v", got, err)
+	}
+	if _, err = attest("key-2", appID, production); err == nil {
+		t.Fatal("expected a challenge issued to another key to be rejected")
+	}
+	if _, err = attest("key-1", "OTHER.com.example.app", production); err == nil {
+		t.Fatal("expected an unknown app ID to be rejected")
+	}
+	if _, err = attest("key-1", appID, "appattestdevelop"); err == nil {
+		t.Fatal("expected development keys to be rejected by default")
+	}
+	verifier.allowDevelopment = true
+	if got, err = attest("key-1", appID, "appattestdevelop"); err != nil || got.Labels[0] != appAttestDevelopment {
+		t.Fatalf("expected a development attestation, got %+v, %v", got, err)
+	}
+
+	untrusted := newAppAttestFixture(t)
+	challenge, _, _ := verifier.issueChallenge("key-1")
+	keyID, object := untrusted.attest(t, appID, production, challenge)
+	if _, err = verifier.verify("key-1", &attestationRequest{Platform: "ios", Challenge: challenge, KeyID: keyID, AttestationObject: object}); err == nil {
+		t.Fatal("expected a certificate from another root to be rejected")
+	}
+}
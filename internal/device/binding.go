@@ -1,19 +1,162 @@
 package device
 
 import (
+	"slices"
 	"time"
 )
 
-// DeviceBinding represents a binding between an API key and a device
-type DeviceBinding struct {
+// Device represents a single device registered to an API key
+type Device struct {
 	DeviceID  string    `yaml:"device_id" json:"device_id"`
-	Type      string    `yaml:"type" json:"type"` // "ip" or "client_id"
+	Type      string    `yaml:"type" json:"type"` // "ip", "client_id", "token" or "client_cert"
+	FirstSeen time.Time `yaml:"first_seen" json:"first_seen"`
+	LastSeen  time.Time `yaml:"last_seen" json:"last_seen"`
+	LastIP    string    `yaml:"last_ip" json:"last_ip"` // Track last IP for concurrent detection
+	// Pending marks a device awaiting admin approval; requests from it are rejected.
+	Pending bool `yaml:"pending,omitempty" json:"pending"`
+	// Metadata holds admin-defined attributes such as a hostname or owner.
+	Metadata map[string]string `yaml:"metadata,omitempty" json:"metadata,omitempty"`
+	// Attestation is the App Attest or Play Integrity verdict recorded at registration.
+	Attestation *Attestation `yaml:"attestation,omitempty" json:"attestation,omitempty"`
+}
+
+// DeviceBinding represents the set of devices bound to an API key
+type DeviceBinding struct {
+	Devices   []Device  `yaml:"devices" json:"devices"`
 	FirstSeen time.Time `yaml:"first_seen" json:"first_seen"`
 	LastSeen  time.Time `yaml:"last_seen" json:"last_seen"`
-	LastIP    string    `yaml:"last_ip" json:"last_ip"`       // Track last IP for concurrent detection
+	LastIP    string    `yaml:"last_ip" json:"last_ip"`       // Last IP seen for any device of this key
 	Banned    bool      `yaml:"banned" json:"banned"`         // Ban flag
 	BanReason string    `yaml:"ban_reason" json:"ban_reason"` // Reason for ban
 	BannedAt  time.Time `yaml:"banned_at" json:"banned_at"`   // When banned
+	// BanExpiresAt is when a temporary ban lifts automatically; zero means permanent.
+	BanExpiresAt time.Time `yaml:"ban_expires_at,omitempty" json:"ban_expires_at,omitempty"`
+	// Strikes counts concurrent-usage violations used for ban escalation.
+	Strikes      int       `yaml:"strikes,omitempty" json:"strikes"`
+	LastStrikeAt time.Time `yaml:"last_strike_at,omitempty" json:"last_strike_at,omitempty"`
+	// Metadata holds admin-defined attributes for the API key (customer ID, CRM link, notes, tier).
+	Metadata map[string]string `yaml:"metadata,omitempty" json:"metadata,omitempty"`
+	// Policy overrides global device binding settings for this key
+	Policy *Policy `yaml:"policy,omitempty" json:"policy,omitempty"`
+	// TLSFingerprints are the client TLS fingerprints (JA3 or JA4) pinned to this key, at most one per allowed device
+	TLSFingerprints []string `yaml:"tls_fingerprints,omitempty" json:"tls_fingerprints,omitempty"`
+	// BanHistory is the append-only record of bans for this key, oldest first.
+	// Unbanning closes the latest entry instead of discarding it.
+	BanHistory []BanRecord `yaml:"ban_history,omitempty" json:"-"`
+	// DeviceBans block single devices or client IPs of the key without banning the key itself.
+	DeviceBans []DeviceBan `yaml:"device_bans,omitempty" json:"device_bans,omitempty"`
+
+	// DeviceID and Type are the legacy single-device fields. They are only read
+	// when loading old binding files and are migrated into Devices on load.
+	DeviceID string `yaml:"device_id,omitempty" json:"-"`
+	Type     string `yaml:"type,omitempty" json:"-"`
+}
+
+// maxBanHistory bounds the ban history kept per API key
+const maxBanHistory = 100
+
+// BanRecord is one entry of an API key's ban history
+type BanRecord struct {
+	BannedAt time.Time `yaml:"banned_at" json:"banned_at"`
+	Reason   string    `yaml:"reason" json:"reason"`
+	// IPs are the addresses that triggered the ban, e.g. the previous and current IP of a concurrent-usage detection.
+	IPs       []string  `yaml:"ips,omitempty" json:"ips,omitempty"`
+	ExpiresAt time.Time `yaml:"expires_at,omitempty" json:"expires_at,omitempty"`
+	// LiftedAt and LiftedBy record when and by whom the ban ended ("system" for expired temporary bans).
+	LiftedAt time.Time `yaml:"lifted_at,omitempty" json:"lifted_at,omitempty"`
+	LiftedBy string    `yaml:"lifted_by,omitempty" json:"lifted_by,omitempty"`
+}
+
+// BanExpired reports whether a temporary ban has run out at the given time
+func (b DeviceBinding) BanExpired(now time.Time) bool {
+	return b.Banned && !b.BanExpiresAt.IsZero() && !now.Before(b.BanExpiresAt)
+}
+
+// FindDevice returns the index of a device in the binding, or -1 if not found
+func (b DeviceBinding) FindDevice(deviceID string) int {
+	for i := range b.Devices {
+		if b.Devices[i].DeviceID == deviceID {
+			return i
+		}
+	}
+	return -1
+}
+
+// clone returns a copy of the binding that does not share the Devices slice or metadata maps
+func (b DeviceBinding) clone() DeviceBinding {
+	if b.Devices != nil {
+		devices := make([]Device, len(b.Devices))
+		copy(devices, b.Devices)
+		for i := range devices {
+			devices[i].Metadata = cloneMetadata(devices[i].Metadata)
+			devices[i].Attestation = devices[i].Attestation.clone()
+		}
+		b.Devices = devices
+	}
+	b.Metadata = cloneMetadata(b.Metadata)
+	b.Policy = b.Policy.clone()
+	b.TLSFingerprints = slices.Clone(b.TLSFingerprints)
+	b.DeviceBans = slices.Clone(b.DeviceBans)
+	if b.BanHistory != nil {
+		history := make([]BanRecord, len(b.BanHistory))
+		copy(history, b.BanHistory)
+		for i := range history {
+			history[i].IPs = append([]string(nil), history[i].IPs...)
+		}
+		b.BanHistory = history
+	}
+	return b
+}
+
+func cloneMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	out := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		out[k] = v
+	}
+	return out
+}
+
+// applyMetadata replaces or merges metadata. When merging, empty values delete keys.
+func applyMetadata(current, update map[string]string, merge bool) map[string]string {
+	if !merge {
+		current = nil
+	}
+	out := cloneMetadata(current)
+	if out == nil {
+		out = make(map[string]string, len(update))
+	}
+	for k, v := range update {
+		if merge && v == "" {
+			delete(out, k)
+			continue
+		}
+		out[k] = v
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// migrateLegacy moves legacy single-device fields into the Devices list
+func (b *DeviceBinding) migrateLegacy() {
+	if b.DeviceID == "" {
+		return
+	}
+	if b.FindDevice(b.DeviceID) < 0 {
+		b.Devices = append(b.Devices, Device{
+			DeviceID:  b.DeviceID,
+			Type:      b.Type,
+			FirstSeen: b.FirstSeen,
+			LastSeen:  b.LastSeen,
+			LastIP:    b.LastIP,
+		})
+	}
+	b.DeviceID = ""
+	b.Type = ""
 }
 
 // DeviceBindings holds all device bindings
@@ -28,68 +171,272 @@ func NewDeviceBindings() *DeviceBindings {
 	}
 }
 
+// normalize migrates legacy entries after loading from disk
+func (d *DeviceBindings) normalize() {
+	if d.Bindings == nil {
+		d.Bindings = make(map[string]DeviceBinding)
+		return
+	}
+	for apiKey, binding := range d.Bindings {
+		binding.migrateLegacy()
+		d.Bindings[apiKey] = binding
+	}
+}
+
 // Get returns the binding for an API key, if exists
 func (d *DeviceBindings) Get(apiKey string) (DeviceBinding, bool) {
 	if d.Bindings == nil {
 		return DeviceBinding{}, false
 	}
 	binding, exists := d.Bindings[apiKey]
-	return binding, exists
+	return binding.clone(), exists
 }
 
-// Set creates or updates a binding for an API key
-func (d *DeviceBindings) Set(apiKey, deviceID, deviceType string) {
+// AddDevice registers a device for an API key, creating the binding if needed.
+// Adding a device that is already registered only refreshes its timestamps.
+func (d *DeviceBindings) AddDevice(apiKey, deviceID, deviceType, currentIP string) {
 	if d.Bindings == nil {
 		d.Bindings = make(map[string]DeviceBinding)
 	}
 	now := time.Now()
-	d.Bindings[apiKey] = DeviceBinding{
-		DeviceID:  deviceID,
-		Type:      deviceType,
-		FirstSeen: now,
-		LastSeen:  now,
-		LastIP:    deviceID, // Initially same as deviceID if using IP
+	binding, exists := d.Bindings[apiKey]
+	if !exists {
+		binding.FirstSeen = now
 	}
+	binding.LastSeen = now
+	binding.LastIP = currentIP
+
+	if idx := binding.FindDevice(deviceID); idx >= 0 {
+		binding.Devices[idx].LastSeen = now
+		binding.Devices[idx].LastIP = currentIP
+	} else {
+		binding.Devices = append(binding.Devices, Device{
+			DeviceID:  deviceID,
+			Type:      deviceType,
+			FirstSeen: now,
+			LastSeen:  now,
+			LastIP:    currentIP,
+		})
+	}
+	d.Bindings[apiKey] = binding
 }
 
-// UpdateLastSeen updates the last_seen timestamp and IP for an API key
-func (d *DeviceBindings) UpdateLastSeen(apiKey string, currentIP string) {
-	if d.Bindings == nil {
+// AddPendingDevice registers a device awaiting admin approval. Devices that are
+// already registered keep their current approval state.
+func (d *DeviceBindings) AddPendingDevice(apiKey, deviceID, deviceType, currentIP string) {
+	existing := false
+	if binding, ok := d.Bindings[apiKey]; ok {
+		existing = binding.FindDevice(deviceID) >= 0
+	}
+	d.AddDevice(apiKey, deviceID, deviceType, currentIP)
+	if existing {
 		return
 	}
-	if binding, exists := d.Bindings[apiKey]; exists {
-		binding.LastSeen = time.Now()
-		binding.LastIP = currentIP
+	binding := d.Bindings[apiKey]
+	if idx := binding.FindDevice(deviceID); idx >= 0 {
+		binding.Devices[idx].Pending = true
 		d.Bindings[apiKey] = binding
 	}
 }
 
-// Ban marks an API key as banned
-func (d *DeviceBindings) Ban(apiKey, reason string) {
+// AddDeviceWithinLimit registers a device like AddDevice, or AddPendingDevice
+// when pending is set, unless it is new and the key already holds limit
+// devices. It reports whether the device was added or refreshed.
+func (d *DeviceBindings) AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP string, pending bool, limit int) bool {
+	if binding := d.Bindings[apiKey]; binding.FindDevice(deviceID) < 0 && len(binding.Devices) >= limit {
+		return false
+	}
+	if pending {
+		d.AddPendingDevice(apiKey, deviceID, deviceType, currentIP)
+	} else {
+		d.AddDevice(apiKey, deviceID, deviceType, currentIP)
+	}
+	return true
+}
+
+// ApproveDevice clears the pending flag of a device. It reports whether the
+// device exists and was pending.
+func (d *DeviceBindings) ApproveDevice(apiKey, deviceID string) bool {
+	if d.Bindings == nil {
+		return false
+	}
+	binding, exists := d.Bindings[apiKey]
+	if !exists {
+		return false
+	}
+	idx := binding.FindDevice(deviceID)
+	if idx < 0 || !binding.Devices[idx].Pending {
+		return false
+	}
+	binding.Devices[idx].Pending = false
+	d.Bindings[apiKey] = binding
+	return true
+}
+
+// SetMetadata replaces or merges the metadata of an API key, or of one of its
+// devices when deviceID is set. Key metadata creates the binding if needed;
+// device metadata requires the device to exist. It reports whether the target was found.
+func (d *DeviceBindings) SetMetadata(apiKey, deviceID string, metadata map[string]string, merge bool) bool {
+	if d.Bindings == nil {
+		d.Bindings = make(map[string]DeviceBinding)
+	}
+	binding, exists := d.Bindings[apiKey]
+	if deviceID == "" {
+		binding.Metadata = applyMetadata(binding.Metadata, metadata, merge)
+		d.Bindings[apiKey] = binding
+		return true
+	}
+	if !exists {
+		return false
+	}
+	idx := binding.FindDevice(deviceID)
+	if idx < 0 {
+		return false
+	}
+	binding.Devices[idx].Metadata = applyMetadata(binding.Devices[idx].Metadata, metadata, merge)
+	d.Bindings[apiKey] = binding
+	return true
+}
+
+// RemoveDevice removes a single device from an API key's binding
+func (d *DeviceBindings) RemoveDevice(apiKey, deviceID string) bool {
+	if d.Bindings == nil {
+		return false
+	}
+	binding, exists := d.Bindings[apiKey]
+	if !exists {
+		return false
+	}
+	idx := binding.FindDevice(deviceID)
+	if idx < 0 {
+		return false
+	}
+	devices := make([]Device, 0, len(binding.Devices)-1)
+	devices = append(devices, binding.Devices[:idx]...)
+	devices = append(devices, binding.Devices[idx+1:]...)
+	binding.Devices = devices
+	d.Bindings[apiKey] = binding
+	return true
+}
+
+// UpdateLastSeen updates the last_seen timestamp and IP for a device of an API key
+func (d *DeviceBindings) UpdateLastSeen(apiKey, deviceID, currentIP string) {
 	if d.Bindings == nil {
 		return
 	}
 	if binding, exists := d.Bindings[apiKey]; exists {
-		binding.Banned = true
-		binding.BanReason = reason
-		binding.BannedAt = time.Now()
+		now := time.Now()
+		binding.LastSeen = now
+		binding.LastIP = currentIP
+		if idx := binding.FindDevice(deviceID); idx >= 0 {
+			binding.Devices[idx].LastSeen = now
+			binding.Devices[idx].LastIP = currentIP
+		}
 		d.Bindings[apiKey] = binding
 	}
 }
 
-// Unban removes ban from an API key
-func (d *DeviceBindings) Unban(apiKey string) {
+// Ban marks an API key as banned and appends to its ban history. A positive
+// duration makes the ban temporary; ips are the addresses that triggered it.
+func (d *DeviceBindings) Ban(apiKey, reason string, duration time.Duration, ips ...string) {
+	if d.Bindings == nil {
+		d.Bindings = make(map[string]DeviceBinding)
+	}
+	// A key without a binding yet (e.g. a leaked key banned proactively) gets an empty one.
+	binding := d.Bindings[apiKey]
+	now := time.Now()
+	binding.Banned = true
+	binding.BanReason = reason
+	binding.BannedAt = now
+	binding.BanExpiresAt = time.Time{}
+	if duration > 0 {
+		binding.BanExpiresAt = now.Add(duration)
+	}
+	binding.BanHistory = append(binding.BanHistory, BanRecord{
+		BannedAt:  now,
+		Reason:    reason,
+		IPs:       append([]string(nil), ips...),
+		ExpiresAt: binding.BanExpiresAt,
+	})
+	if over := len(binding.BanHistory) - maxBanHistory; over > 0 {
+		binding.BanHistory = append([]BanRecord(nil), binding.BanHistory[over:]...)
+	}
+	d.Bindings[apiKey] = binding
+}
+
+// AddStrike records a violation and returns the new strike count. Strikes older
+// than resetAfter are forgotten before counting; zero resetAfter never forgets.
+func (d *DeviceBindings) AddStrike(apiKey string, resetAfter time.Duration) int {
+	if d.Bindings == nil {
+		return 0
+	}
+	binding, exists := d.Bindings[apiKey]
+	if !exists {
+		return 0
+	}
+	now := time.Now()
+	if resetAfter > 0 && !binding.LastStrikeAt.IsZero() && now.Sub(binding.LastStrikeAt) > resetAfter {
+		binding.Strikes = 0
+	}
+	binding.Strikes++
+	binding.LastStrikeAt = now
+	d.Bindings[apiKey] = binding
+	return binding.Strikes
+}
+
+// Unban removes ban from an API key and records actor as the one who lifted it
+func (d *DeviceBindings) Unban(apiKey, actor string) {
 	if d.Bindings == nil {
 		return
 	}
 	if binding, exists := d.Bindings[apiKey]; exists {
+		if n := len(binding.BanHistory); binding.Banned && n > 0 && binding.BanHistory[n-1].LiftedAt.IsZero() {
+			history := append([]BanRecord(nil), binding.BanHistory...)
+			history[n-1].LiftedAt = time.Now()
+			history[n-1].LiftedBy = actor
+			binding.BanHistory = history
+		}
 		binding.Banned = false
 		binding.BanReason = ""
 		binding.BannedAt = time.Time{}
+		binding.BanExpiresAt = time.Time{}
 		d.Bindings[apiKey] = binding
 	}
 }
 
+// PruneHistory drops ban history entries that ended (or started, if still
+// open) before cutoff. It reports whether anything was removed.
+func (d *DeviceBindings) PruneHistory(apiKey string, cutoff time.Time) bool {
+	binding, exists := d.Bindings[apiKey]
+	if !exists || len(binding.BanHistory) == 0 {
+		return false
+	}
+	kept := make([]BanRecord, 0, len(binding.BanHistory))
+	for _, record := range binding.BanHistory {
+		end := record.LiftedAt
+		if end.IsZero() {
+			end = record.BannedAt
+			if binding.Banned {
+				kept = append(kept, record)
+				continue
+			}
+		}
+		if end.Before(cutoff) {
+			continue
+		}
+		kept = append(kept, record)
+	}
+	if len(kept) == len(binding.BanHistory) {
+		return false
+	}
+	if len(kept) == 0 {
+		kept = nil
+	}
+	binding.BanHistory = kept
+	d.Bindings[apiKey] = binding
+	return true
+}
+
 // Delete removes the binding for an API key
 func (d *DeviceBindings) Delete(apiKey string) bool {
 	if d.Bindings == nil {
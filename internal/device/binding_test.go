@@ -0,0 +1,158 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDeviceBindingsAddAndRemoveDevice(t *testing.T) {
+	d := NewDeviceBindings()
+	d.AddDevice("key", "dev-a", "client_id", "10.0.0.1")
+	d.AddDevice("key", "dev-b", "client_id", "10.0.0.2")
+	d.AddDevice("key", "dev-a", "client_id", "10.0.0.3")
+
+	binding, ok := d.Get("key")
+	if !ok {
+		t.Fatal("expected binding to exist")
+	}
+	if len(binding.Devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(binding.Devices))
+	}
+	if got := binding.Devices[binding.FindDevice("dev-a")].LastIP; got != "10.0.0.3" {
+		t.Fatalf("expected dev-a last ip to be refreshed, got %q", got)
+	}
+
+	if !d.RemoveDevice("key", "dev-a") {
+		t.Fatal("expected dev-a to be removed")
+	}
+	if d.RemoveDevice("key", "dev-a") {
+		t.Fatal("expected second removal to report false")
+	}
+	binding, _ = d.Get("key")
+	if len(binding.Devices) != 1 || binding.Devices[0].DeviceID != "dev-b" {
+		t.Fatalf("unexpected devices after removal: %+v", binding.Devices)
+	}
+}
+
+func TestDeviceBindingsGetReturnsCopy(t *testing.T) {
+	d := NewDeviceBindings()
+	d.AddDevice("key", "dev-a", "client_id", "10.0.0.1")
+
+	binding, _ := d.Get("key")
+	binding.Devices[0].DeviceID = "mutated"
+
+	again, _ := d.Get("key")
+	if again.Devices[0].DeviceID != "dev-a" {
+		t.Fatalf("expected stored binding to be unaffected, got %q", again.Devices[0].DeviceID)
+	}
+}
+
+func TestDeviceBindingsMigratesLegacyLayout(t *testing.T) {
+	legacy := []byte(`bindings:
+  key:
+    device_id: 203.0.113.7
+    type: ip
+    last_ip: 203.0.113.7
+    banned: true
+    ban_reason: test
+`)
+	var d DeviceBindings
+	if err := yaml.Unmarshal(legacy, &d); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	d.normalize()
+
+	binding, ok := d.Get("key")
+	if !ok {
+		t.Fatal("expected migrated binding")
+	}
+	if len(binding.Devices) != 1 || binding.Devices[0].DeviceID != "203.0.113.7" || binding.Devices[0].Type != "ip" {
+		t.Fatalf("unexpected migrated devices: %+v", binding.Devices)
+	}
+	if binding.DeviceID != "" || binding.Type != "" {
+		t.Fatal("expected legacy fields to be cleared")
+	}
+	if !binding.Banned || binding.BanReason != "test" {
+		t.Fatal("expected ban state to be preserved")
+	}
+}
+
+func TestDeviceBindingsTemporaryBan(t *testing.T) {
+	d := NewDeviceBindings()
+	d.AddDevice("key", "dev-a", "client_id", "10.0.0.1")
+	d.Ban("key", "concurrent", time.Minute)
+
+	binding, _ := d.Get("key")
+	if binding.BanExpiresAt.IsZero() {
+		t.Fatal("expected temporary ban to have an expiry")
+	}
+	if binding.BanExpired(time.Now()) {
+		t.Fatal("expected ban to still be active")
+	}
+	if !binding.BanExpired(time.Now().Add(2 * time.Minute)) {
+		t.Fatal("expected ban to be expired after its duration")
+	}
+
+	d.Ban("key", "manual", 0)
+	binding, _ = d.Get("key")
+	if !binding.BanExpiresAt.IsZero() || binding.BanExpired(time.Now().Add(24*time.Hour)) {
+		t.Fatal("expected permanent ban to never expire")
+	}
+}
+
+func TestDeviceBindingsBanHistorySurvivesUnban(t *testing.T) {
+	d := NewDeviceBindings()
+	d.AddDevice("key", "dev-a", "client_id", "10.0.0.1")
+	d.Ban("key", "concurrent", time.Hour, "10.0.0.1", "10.0.0.2")
+	d.Unban("key", "admin")
+	d.Ban("key", "manual", 0)
+
+	binding, _ := d.Get("key")
+	if len(binding.BanHistory) != 2 {
+		t.Fatalf("expected 2 history entries, got %+v", binding.BanHistory)
+	}
+	first := binding.BanHistory[0]
+	if first.Reason != "concurrent" || len(first.IPs) != 2 || first.LiftedBy != "admin" || first.LiftedAt.IsZero() || first.ExpiresAt.IsZero() {
+		t.Fatalf("unexpected first entry: %+v", first)
+	}
+	if second := binding.BanHistory[1]; second.Reason != "manual" || !second.LiftedAt.IsZero() {
+		t.Fatalf("unexpected open entry: %+v", second)
+	}
+
+	// Pruning drops ended entries but keeps the active ban.
+	if !d.PruneHistory("key", time.Now().Add(time.Minute)) {
+		t.Fatal("expected ended entry to be pruned")
+	}
+	binding, _ = d.Get("key")
+	if len(binding.BanHistory) != 1 || binding.BanHistory[0].Reason != "manual" {
+		t.Fatalf("unexpected history after prune: %+v", binding.BanHistory)
+	}
+}
+
+func TestSetMetadataReplaceAndMerge(t *testing.T) {
+	d := NewDeviceBindings()
+	if !d.SetMetadata("key", "", map[string]string{"customer": "acme", "notes": "vip"}, false) {
+		t.Fatal("expected key metadata to create the binding")
+	}
+	d.SetMetadata("key", "", map[string]string{"notes": "", "tier": "pro"}, true)
+	binding, _ := d.Get("key")
+	if len(binding.Metadata) != 2 || binding.Metadata["customer"] != "acme" || binding.Metadata["tier"] != "pro" {
+		t.Fatalf("unexpected merged metadata: %v", binding.Metadata)
+	}
+
+	if d.SetMetadata("key", "missing", map[string]string{"a": "b"}, false) {
+		t.Fatal("expected unknown device to be reported")
+	}
+	d.AddDevice("key", "dev-a", "client_id", "10.0.0.1")
+	d.SetMetadata("key", "dev-a", map[string]string{"host": "ci-1"}, false)
+
+	binding, _ = d.Get("key")
+	binding.Metadata["customer"] = "changed"
+	binding.Devices[0].Metadata["host"] = "changed"
+	again, _ := d.Get("key")
+	if again.Metadata["customer"] != "acme" || again.Devices[0].Metadata["host"] != "ci-1" {
+		t.Fatal("Get must return metadata copies")
+	}
+}
@@ -0,0 +1,131 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+)
+
+const maxCBORDepth = 16
+
+// decodeCBOR decodes the subset of CBOR (RFC 8949) used by App Attest
+// attestation objects: integers, byte and text strings, arrays, maps, tags and
+// simple values. Map keys become strings; indefinite lengths are rejected.
+func decodeCBOR(data []byte) (any, error) {
+	d := cborDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, errors.New("cbor: trailing data")
+	}
+	return v, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, errors.New("cbor: unexpected end of data")
+	}
+	b := d.data[d.pos]
+	d.pos++
+	major, info := b>>5, b&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(d.data)-d.pos < n {
+			return 0, 0, errors.New("cbor: unexpected end of data")
+		}
+		var arg uint64
+		for _, c := range d.data[d.pos : d.pos+n] {
+			arg = arg<<8 | uint64(c)
+		}
+		d.pos += n
+		return major, arg, nil
+	default:
+		return 0, 0, errors.New("cbor: indefinite lengths are not supported")
+	}
+}
+
+// length checks that n items of at least one byte each can still follow
+func (d *cborDecoder) length(n uint64) (int, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return 0, errors.New("cbor: length exceeds data")
+	}
+	return int(n), nil
+}
+
+func (d *cborDecoder) value(depth int) (any, error) {
+	if depth > maxCBORDepth {
+		return nil, errors.New("cbor: nesting too deep")
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		return arg, nil
+	case 1:
+		return -1 - int64(arg), nil
+	case 2, 3:
+		n, errLen := d.length(arg)
+		if errLen != nil {
+			return nil, errLen
+		}
+		raw := d.data[d.pos : d.pos+n]
+		d.pos += n
+		if major == 3 {
+			return string(raw), nil
+		}
+		return raw, nil
+	case 4:
+		n, errLen := d.length(arg)
+		if errLen != nil {
+			return nil, errLen
+		}
+		items := make([]any, 0, n)
+		for i := 0; i < n; i++ {
+			item, errItem := d.value(depth + 1)
+			if errItem != nil {
+				return nil, errItem
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		n, errLen := d.length(arg)
+		if errLen != nil {
+			return nil, errLen
+		}
+		m := make(map[string]any, n)
+		for i := 0; i < n; i++ {
+			key, errKey := d.value(depth + 1)
+			if errKey != nil {
+				return nil, errKey
+			}
+			val, errVal := d.value(depth + 1)
+			if errVal != nil {
+				return nil, errVal
+			}
+			m[fmt.Sprint(key)] = val
+		}
+		return m, nil
+	case 6:
+		return d.value(depth + 1)
+	default:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		}
+		return nil, nil
+	}
+}
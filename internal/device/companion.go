@@ -0,0 +1,150 @@
+package device
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// Companion registration errors
+var (
+	// ErrCompanionBanned rejects registrations for a banned API key.
+	ErrCompanionBanned = errors.New("api key is banned")
+	// ErrCompanionDeviceBanned rejects a device or IP banned for the API key.
+	ErrCompanionDeviceBanned = errors.New("device is banned")
+	// ErrCompanionDeviceLimit rejects a new device once the key holds its maximum.
+	ErrCompanionDeviceLimit = errors.New("device limit reached")
+	// ErrCompanionAttestation rejects devices of keys that require attested devices,
+	// which companion agents cannot provide.
+	ErrCompanionAttestation = errors.New("trusted attestation required")
+	// ErrCompanionToken rejects a device token that does not verify, or a known
+	// device ID presented without its token while tokens are enabled.
+	ErrCompanionToken = errors.New("invalid device token")
+)
+
+// Companion device statuses
+const (
+	CompanionActive  = "active"
+	CompanionPending = "pending"
+	// CompanionUnbound is reported while device binding is disabled.
+	CompanionUnbound = "unbound"
+)
+
+// CompanionDevice is the device a companion agent speaks for.
+type CompanionDevice struct {
+	ID     string
+	Status string
+	// Token is the signed device token, set when one was issued to a new device.
+	Token string
+	// New is set when the device was registered by this call.
+	New bool
+}
+
+// IdentifyCompanion resolves the device of a desktop companion agent for
+// apiKey, registering it like POST /v0/device/register when it is not bound
+// yet. With signed device tokens a known device must present its token; without
+// them deviceID is the identifier the client sends in the device ID header, and
+// a new one is generated when empty.
+func (m *Middleware) IdentifyCompanion(apiKey, deviceID, token, currentIP string) (CompanionDevice, error) {
+	deviceID = strings.TrimSpace(deviceID)
+	if m == nil || !m.config.Enabled {
+		return CompanionDevice{ID: deviceID, Status: CompanionUnbound}, nil
+	}
+	if strings.HasPrefix(deviceID, certDevicePrefix) {
+		return CompanionDevice{}, ErrCompanionToken
+	}
+	deviceType := "client_id"
+	if m.signer != nil {
+		deviceType = "token"
+		if token = strings.TrimSpace(token); token != "" {
+			id, ok := m.signer.Verify(apiKey, token)
+			if !ok {
+				return CompanionDevice{}, ErrCompanionToken
+			}
+			deviceID = id
+		}
+	}
+	if deviceID == "" {
+		deviceID = newDeviceID()
+	}
+	if len(deviceID) > maxDeviceIDLength {
+		return CompanionDevice{}, ErrCompanionToken
+	}
+
+	binding, _ := m.store.Get(apiKey)
+	if binding.Banned && !binding.BanExpired(time.Now()) {
+		return CompanionDevice{ID: deviceID}, ErrCompanionBanned
+	}
+	if ban, banned := binding.MatchDeviceBan(deviceID, currentIP, time.Now()); banned {
+		log.Warnf("device-binding: rejected banned companion device of key %s (device=%s, ip=%s), reason: %s",
+			MaskKey(apiKey), deviceID, currentIP, ban.Reason)
+		bindingDecisions.Inc(decisionDeviceBanned)
+		return CompanionDevice{ID: deviceID}, ErrCompanionDeviceBanned
+	}
+	if idx := binding.FindDevice(deviceID); idx >= 0 {
+		if m.signer != nil && token == "" {
+			return CompanionDevice{}, ErrCompanionToken
+		}
+		status := CompanionActive
+		if binding.Devices[idx].Pending {
+			status = CompanionPending
+		}
+		if err := m.store.UpdateLastSeen(apiKey, deviceID, currentIP); err != nil {
+			log.Warnf("device-binding: failed to refresh companion device %s for key %s: %v", deviceID, MaskKey(apiKey), err)
+		}
+		return CompanionDevice{ID: deviceID, Status: status}, nil
+	}
+
+	policy := m.effectivePolicy(binding.Policy)
+	if len(binding.Devices) >= policy.MaxDevices {
+		return CompanionDevice{ID: deviceID}, companionDeviceLimit(apiKey, deviceID, currentIP)
+	}
+	if policy.RequireAttestation {
+		bindingDecisions.Inc(decisionUnattested)
+		events.Publish(events.Event{Type: events.TypeDeviceRejected, APIKey: apiKey, DeviceID: deviceID, IP: currentIP, Actor: "system", Reason: "trusted attestation required"})
+		return CompanionDevice{ID: deviceID}, ErrCompanionAttestation
+	}
+
+	status := CompanionActive
+	if m.config.RequireApproval {
+		status = CompanionPending
+	}
+	added, err := m.store.AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP, m.config.RequireApproval, policy.MaxDevices)
+	if err != nil {
+		return CompanionDevice{}, err
+	}
+	if !added {
+		return CompanionDevice{ID: deviceID}, companionDeviceLimit(apiKey, deviceID, currentIP)
+	}
+	log.Infof("device-binding: companion agent registered device %s for key %s (%s)", deviceID, MaskKey(apiKey), status)
+	registrations.Inc(status)
+	eventType := events.TypeDeviceRegistered
+	if status == CompanionPending {
+		eventType = events.TypeDevicePending
+	}
+	events.Publish(events.Event{Type: eventType, APIKey: apiKey, DeviceID: deviceID, IP: currentIP, Actor: "system", Data: map[string]any{"via": "companion"}})
+
+	registered := CompanionDevice{ID: deviceID, Status: status, New: true}
+	if m.signer != nil {
+		registered.Token = m.signer.Sign(apiKey, deviceID)
+	}
+	return registered, nil
+}
+
+// companionDeviceLimit records a registration rejected for lack of free device slots
+func companionDeviceLimit(apiKey, deviceID, currentIP string) error {
+	bindingDecisions.Inc(decisionDeviceLimit)
+	events.Publish(events.Event{Type: events.TypeDeviceRejected, APIKey: apiKey, DeviceID: deviceID, IP: currentIP, Actor: "system", Reason: "device limit reached"})
+	return ErrCompanionDeviceLimit
+}
+
+// CompanionHeartbeat refreshes the last-seen time of a companion agent's device.
+func (m *Middleware) CompanionHeartbeat(apiKey, deviceID, currentIP string) error {
+	if m == nil || !m.config.Enabled || deviceID == "" {
+		return nil
+	}
+	return m.store.UpdateLastSeen(apiKey, deviceID, currentIP)
+}
@@ -0,0 +1,142 @@
+package device
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeviceBan blocks one device or one client IP of an API key while the key
+// stays usable from its other devices. Exactly one of DeviceID and IP is set.
+type DeviceBan struct {
+	DeviceID string    `yaml:"device_id,omitempty" json:"device_id,omitempty"`
+	IP       string    `yaml:"ip,omitempty" json:"ip,omitempty"`
+	Reason   string    `yaml:"reason" json:"reason"`
+	BannedAt time.Time `yaml:"banned_at" json:"banned_at"`
+	// ExpiresAt is when a temporary ban lifts automatically; zero means permanent.
+	ExpiresAt time.Time `yaml:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+// Expired reports whether a temporary device ban has run out at the given time
+func (b DeviceBan) Expired(now time.Time) bool {
+	return !b.ExpiresAt.IsZero() && !now.Before(b.ExpiresAt)
+}
+
+// sameTarget reports whether the ban blocks exactly this device or IP target
+func (b DeviceBan) sameTarget(deviceID, ip string) bool {
+	return b.DeviceID == deviceID && b.IP == ip
+}
+
+// ActiveDeviceBans returns the device bans of the binding that have not expired
+func (b DeviceBinding) ActiveDeviceBans(now time.Time) []DeviceBan {
+	var active []DeviceBan
+	for _, ban := range b.DeviceBans {
+		if !ban.Expired(now) {
+			active = append(active, ban)
+		}
+	}
+	return active
+}
+
+// MatchDeviceBan returns the active ban blocking deviceID or ip, if any
+func (b DeviceBinding) MatchDeviceBan(deviceID, ip string, now time.Time) (DeviceBan, bool) {
+	for _, ban := range b.DeviceBans {
+		if ban.Expired(now) {
+			continue
+		}
+		if (ban.DeviceID != "" && ban.DeviceID == deviceID) || (ban.IP != "" && ban.IP == ip) {
+			return ban, true
+		}
+	}
+	return DeviceBan{}, false
+}
+
+// BanDevice adds a device or IP ban to an API key, replacing an existing ban
+// of the same target and dropping expired ones. A key without a binding yet
+// gets an empty one, as with key bans.
+func (d *DeviceBindings) BanDevice(apiKey string, ban DeviceBan) {
+	if d.Bindings == nil {
+		d.Bindings = make(map[string]DeviceBinding)
+	}
+	binding := d.Bindings[apiKey]
+	now := time.Now()
+	bans := make([]DeviceBan, 0, len(binding.DeviceBans)+1)
+	for _, existing := range binding.DeviceBans {
+		if !existing.Expired(now) && !existing.sameTarget(ban.DeviceID, ban.IP) {
+			bans = append(bans, existing)
+		}
+	}
+	binding.DeviceBans = append(bans, ban)
+	d.Bindings[apiKey] = binding
+}
+
+// UnbanDevice lifts the ban of a device or IP of an API key. It reports
+// whether an active ban was removed.
+func (d *DeviceBindings) UnbanDevice(apiKey, deviceID, ip string) bool {
+	binding, exists := d.Bindings[apiKey]
+	if !exists {
+		return false
+	}
+	now := time.Now()
+	removed := false
+	var bans []DeviceBan
+	for _, ban := range binding.DeviceBans {
+		if ban.sameTarget(deviceID, ip) {
+			removed = removed || !ban.Expired(now)
+			continue
+		}
+		if !ban.Expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	if !removed {
+		return false
+	}
+	binding.DeviceBans = bans
+	d.Bindings[apiKey] = binding
+	return true
+}
+
+// BanDevice bans a device or IP of an API key and persists
+func (s *FileStore) BanDevice(apiKey string, ban DeviceBan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bindings.BanDevice(apiKey, ban)
+	return s.save()
+}
+
+// UnbanDevice lifts the ban of a device or IP of an API key and persists
+func (s *FileStore) UnbanDevice(apiKey, deviceID, ip string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.bindings.UnbanDevice(apiKey, deviceID, ip) {
+		return false, nil
+	}
+	return true, s.save()
+}
+
+// checkDeviceBan rejects requests from a banned device or IP of the key. It
+// returns false when the request was rejected.
+func checkDeviceBan(c *gin.Context, apiKey, deviceID, currentIP string, binding DeviceBinding) bool {
+	ban, banned := binding.MatchDeviceBan(deviceID, currentIP, time.Now())
+	if !banned {
+		return true
+	}
+	log.Warnf("device-binding: rejected banned device of key %s (device=%s, ip=%s), reason: %s",
+		MaskKey(apiKey), deviceID, currentIP, ban.Reason)
+	bindingDecisions.Inc(decisionDeviceBanned)
+	body := gin.H{
+		"error":   "device_banned",
+		"message": "This device has been banned for this API key: " + ban.Reason,
+	}
+	if !ban.ExpiresAt.IsZero() {
+		body["ban_expires_at"] = ban.ExpiresAt
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(ban.ExpiresAt).Seconds())+1))
+	}
+	c.AbortWithStatusJSON(403, body)
+	return false
+}
@@ -0,0 +1,471 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// dualWriteStripes is the number of key-striped locks serialising writes so a
+// binding is mirrored in the order it was written
+const dualWriteStripes = 64
+
+// maxReportedMismatches caps the mismatches kept in a dual-write status
+const maxReportedMismatches = 50
+
+// ErrStoresDiverged is returned by Cutover when the stores differ and the cutover was not forced
+var ErrStoresDiverged = errors.New("device binding stores have diverged")
+
+var (
+	dualWriteFailures = metrics.Default().NewCounterVec(
+		"cliproxy_device_store_dual_write_failures_total",
+		"Binding writes that reached the primary store but could not be mirrored to the secondary, by operation.",
+		"op",
+	)
+	storeDivergence = metrics.Default().NewGaugeVec(
+		"cliproxy_device_store_divergence",
+		"Differences between the primary and secondary binding stores found by the last dual-write verification.",
+	)
+)
+
+// DualWriteStatus describes a dual-write migration
+type DualWriteStatus struct {
+	// Primary serves reads and is written first; Secondary receives the mirrored writes
+	Primary   string `json:"primary"`
+	Secondary string `json:"secondary"`
+	// CutoverAt is when the roles were last swapped
+	CutoverAt    time.Time `json:"cutover_at,omitempty"`
+	LastVerified time.Time `json:"last_verified,omitempty"`
+	// Divergence is the number of differences found by the last verification
+	Divergence int `json:"divergence"`
+	// Mismatches describes the first differences found by the last verification
+	Mismatches     []string `json:"mismatches,omitempty"`
+	MirrorFailures int64    `json:"mirror_failures"`
+}
+
+type dualRoles struct {
+	primary, secondary         Store
+	primaryName, secondaryName string
+}
+
+// DualStore writes bindings to two backends during a storage migration. Reads
+// come from the primary, the store being migrated from. After each successful
+// primary write the resulting binding is copied to the secondary, so both
+// converge even for writes that depend on earlier state. Secondary failures are
+// logged and counted but never returned: the primary stays authoritative until
+// Cutover swaps the roles.
+type DualStore struct {
+	roles   atomic.Pointer[dualRoles]
+	stripes [dualWriteStripes]sync.Mutex
+
+	failures atomic.Int64
+
+	mu        sync.Mutex
+	cutoverAt time.Time
+	verified  time.Time
+	diverged  []string
+}
+
+// NewDualStore mirrors the writes of primary to secondary. Both stores must
+// support writing complete bindings so the roles can be swapped.
+func NewDualStore(primary, secondary Store, primaryName, secondaryName string) (*DualStore, error) {
+	for _, s := range []Store{primary, secondary} {
+		if _, ok := s.(BindingWriter); !ok {
+			return nil, fmt.Errorf("dual-write: store does not support writing bindings")
+		}
+	}
+	d := &DualStore{}
+	d.roles.Store(&dualRoles{primary: primary, secondary: secondary, primaryName: primaryName, secondaryName: secondaryName})
+	return d, nil
+}
+
+func (d *DualStore) keyLock(apiKey string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(apiKey))
+	return &d.stripes[h.Sum32()%dualWriteStripes]
+}
+
+func (d *DualStore) lockAll() {
+	for i := range d.stripes {
+		d.stripes[i].Lock()
+	}
+}
+
+func (d *DualStore) unlockAll() {
+	for i := range d.stripes {
+		d.stripes[i].Unlock()
+	}
+}
+
+// write applies a change to the primary and mirrors the resulting binding
+func (d *DualStore) write(op, apiKey string, apply func(Store) error) error {
+	lock := d.keyLock(apiKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	r := d.roles.Load()
+	if err := apply(r.primary); err != nil {
+		return err
+	}
+	d.mirror(op, r, apiKey)
+	return nil
+}
+
+// mirror copies the primary's binding of apiKey to the secondary; the caller holds the key lock
+func (d *DualStore) mirror(op string, r *dualRoles, apiKey string) {
+	var err error
+	if binding, ok := r.primary.Get(apiKey); ok {
+		err = r.secondary.(BindingWriter).PutBindings(map[string]DeviceBinding{apiKey: binding})
+	} else {
+		_, err = r.secondary.Delete(apiKey)
+	}
+	if err != nil {
+		d.mirrorFailed(op, r, apiKey, err)
+	}
+}
+
+func (d *DualStore) mirrorFailed(op string, r *dualRoles, apiKey string, err error) {
+	d.failures.Add(1)
+	dualWriteFailures.Inc(op)
+	if apiKey == "" {
+		log.Warnf("device-binding: dual-write %s to %s store failed: %v", op, r.secondaryName, err)
+		return
+	}
+	log.Warnf("device-binding: dual-write %s to %s store failed for key %s: %v", op, r.secondaryName, MaskKey(apiKey), err)
+}
+
+// Get returns the binding for an API key from the primary
+func (d *DualStore) Get(apiKey string) (DeviceBinding, bool) {
+	return d.roles.Load().primary.Get(apiKey)
+}
+
+// Lookup returns the binding for an API key and the read error of the primary, if any
+func (d *DualStore) Lookup(apiKey string) (DeviceBinding, bool, error) {
+	return LookupBinding(d.roles.Load().primary, apiKey)
+}
+
+// GetAll returns a copy of all bindings in the primary
+func (d *DualStore) GetAll() map[string]DeviceBinding {
+	return d.roles.Load().primary.GetAll()
+}
+
+// AddDeviceWithinLimit registers a device within the device limit of the primary in both stores
+func (d *DualStore) AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP string, pending bool, limit int) (added bool, err error) {
+	err = d.write("add_device", apiKey, func(s Store) (e error) {
+		added, e = s.AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP, pending, limit)
+		return
+	})
+	return added, err
+}
+
+// Approve marks a pending device as approved in both stores
+func (d *DualStore) Approve(apiKey, deviceID string) (approved bool, err error) {
+	err = d.write("approve", apiKey, func(s Store) (e error) { approved, e = s.Approve(apiKey, deviceID); return })
+	return approved, err
+}
+
+// SetMetadata replaces or merges metadata in both stores
+func (d *DualStore) SetMetadata(apiKey, deviceID string, metadata map[string]string, merge bool) (found bool, err error) {
+	err = d.write("set_metadata", apiKey, func(s Store) (e error) { found, e = s.SetMetadata(apiKey, deviceID, metadata, merge); return })
+	return found, err
+}
+
+// SetAttestation records the attestation verdict of a device in both stores
+func (d *DualStore) SetAttestation(apiKey, deviceID string, attestation *Attestation) (found bool, err error) {
+	err = d.write("set_attestation", apiKey, func(s Store) (e error) { found, e = s.SetAttestation(apiKey, deviceID, attestation); return })
+	return found, err
+}
+
+// SetPolicy sets or clears the per-key policy override in both stores
+func (d *DualStore) SetPolicy(apiKey string, policy *Policy) error {
+	return d.write("set_policy", apiKey, func(s Store) error { return s.SetPolicy(apiKey, policy) })
+}
+
+// PinTLSFingerprint pins a client TLS fingerprint in both stores
+func (d *DualStore) PinTLSFingerprint(apiKey, fingerprint string, limit int) (pinned bool, err error) {
+	err = d.write("pin_tls_fingerprint", apiKey, func(s Store) (e error) { pinned, e = s.PinTLSFingerprint(apiKey, fingerprint, limit); return })
+	return pinned, err
+}
+
+// ClearTLSFingerprints removes all pinned TLS fingerprints of an API key in both stores
+func (d *DualStore) ClearTLSFingerprints(apiKey string) (cleared bool, err error) {
+	err = d.write("clear_tls_fingerprints", apiKey, func(s Store) (e error) { cleared, e = s.ClearTLSFingerprints(apiKey); return })
+	return cleared, err
+}
+
+// RemoveDevice removes a single device from an API key in both stores
+func (d *DualStore) RemoveDevice(apiKey, deviceID string) (removed bool, err error) {
+	err = d.write("remove_device", apiKey, func(s Store) (e error) { removed, e = s.RemoveDevice(apiKey, deviceID); return })
+	return removed, err
+}
+
+// UpdateLastSeen updates the last_seen timestamp and IP of a device in both stores
+func (d *DualStore) UpdateLastSeen(apiKey, deviceID, currentIP string) error {
+	return d.write("update_last_seen", apiKey, func(s Store) error { return s.UpdateLastSeen(apiKey, deviceID, currentIP) })
+}
+
+// Ban marks an API key as banned in both stores
+func (d *DualStore) Ban(apiKey, reason string, duration time.Duration, ips ...string) error {
+	return d.write("ban", apiKey, func(s Store) error { return s.Ban(apiKey, reason, duration, ips...) })
+}
+
+// BanDevice bans a single device or client IP of an API key in both stores
+func (d *DualStore) BanDevice(apiKey string, ban DeviceBan) error {
+	return d.write("ban_device", apiKey, func(s Store) error { return s.BanDevice(apiKey, ban) })
+}
+
+// UnbanDevice lifts the ban of a device or IP of an API key in both stores
+func (d *DualStore) UnbanDevice(apiKey, deviceID, ip string) (unbanned bool, err error) {
+	err = d.write("unban_device", apiKey, func(s Store) (e error) { unbanned, e = s.UnbanDevice(apiKey, deviceID, ip); return })
+	return unbanned, err
+}
+
+// AddStrike records a concurrent-usage violation in both stores and returns the strike count
+func (d *DualStore) AddStrike(apiKey string, resetAfter time.Duration) (strikes int, err error) {
+	err = d.write("add_strike", apiKey, func(s Store) (e error) { strikes, e = s.AddStrike(apiKey, resetAfter); return })
+	return strikes, err
+}
+
+// Unban removes the ban from an API key in both stores
+func (d *DualStore) Unban(apiKey, actor string) error {
+	return d.write("unban", apiKey, func(s Store) error { return s.Unban(apiKey, actor) })
+}
+
+// Delete removes a binding from both stores
+func (d *DualStore) Delete(apiKey string) (deleted bool, err error) {
+	err = d.write("delete", apiKey, func(s Store) (e error) { deleted, e = s.Delete(apiKey); return })
+	return deleted, err
+}
+
+// Clear removes all bindings from both stores
+func (d *DualStore) Clear() error {
+	d.lockAll()
+	defer d.unlockAll()
+
+	r := d.roles.Load()
+	if err := r.primary.Clear(); err != nil {
+		return err
+	}
+	if err := r.secondary.Clear(); err != nil {
+		d.mirrorFailed("clear", r, "", err)
+	}
+	return nil
+}
+
+// PutBindings creates or replaces bindings in both stores
+func (d *DualStore) PutBindings(bindings map[string]DeviceBinding) error {
+	d.lockAll()
+	defer d.unlockAll()
+
+	r := d.roles.Load()
+	if err := r.primary.(BindingWriter).PutBindings(bindings); err != nil {
+		return err
+	}
+	if err := r.secondary.(BindingWriter).PutBindings(bindings); err != nil {
+		d.mirrorFailed("put_bindings", r, "", err)
+	}
+	return nil
+}
+
+// AddKeyUsage adds usage counters to both stores
+func (d *DualStore) AddKeyUsage(entries []KeyUsage) error {
+	r := d.roles.Load()
+	primary, ok := r.primary.(KeyUsageStore)
+	if !ok {
+		return fmt.Errorf("%s store does not support key usage", r.primaryName)
+	}
+	if err := primary.AddKeyUsage(entries); err != nil {
+		return err
+	}
+	if secondary, ok := r.secondary.(KeyUsageStore); ok {
+		if err := secondary.AddKeyUsage(entries); err != nil {
+			d.mirrorFailed("key_usage", r, "", err)
+		}
+	}
+	return nil
+}
+
+// KeyUsage returns the usage rows of the primary matching filter
+func (d *DualStore) KeyUsage(filter KeyUsageFilter) ([]KeyUsage, error) {
+	r := d.roles.Load()
+	primary, ok := r.primary.(KeyUsageStore)
+	if !ok {
+		return nil, fmt.Errorf("%s store does not support key usage", r.primaryName)
+	}
+	return primary.KeyUsage(filter)
+}
+
+// Close closes both stores
+func (d *DualStore) Close() error {
+	r := d.roles.Load()
+	return errors.Join(r.primary.Close(), r.secondary.Close())
+}
+
+// Backfill copies bindings and key usage the secondary lacks from the primary
+// and removes bindings only the secondary holds. Keys are copied one lock
+// stripe at a time, so live writes are only held up briefly.
+func (d *DualStore) Backfill() (MigrateReport, error) {
+	var report MigrateReport
+	r := d.roles.Load()
+	keys := slices.Collect(maps.Keys(r.primary.GetAll()))
+	for apiKey := range r.secondary.GetAll() {
+		if _, ok := r.primary.Get(apiKey); !ok {
+			keys = append(keys, apiKey)
+		}
+	}
+	byStripe := make(map[*sync.Mutex][]string)
+	for _, apiKey := range keys {
+		lock := d.keyLock(apiKey)
+		byStripe[lock] = append(byStripe[lock], apiKey)
+	}
+	for lock, stripeKeys := range byStripe {
+		lock.Lock()
+		r = d.roles.Load()
+		batch := make(map[string]DeviceBinding, len(stripeKeys))
+		var err error
+		for _, apiKey := range stripeKeys {
+			if binding, ok := r.primary.Get(apiKey); ok {
+				batch[apiKey] = binding
+				report.Bindings++
+				report.Devices += len(binding.Devices)
+				report.History += len(binding.BanHistory)
+				if binding.Banned {
+					report.Banned++
+				}
+			} else if _, err = r.secondary.Delete(apiKey); err != nil {
+				break
+			}
+		}
+		if err == nil && len(batch) > 0 {
+			err = r.secondary.(BindingWriter).PutBindings(batch)
+		}
+		lock.Unlock()
+		if err != nil {
+			return report, fmt.Errorf("backfill %s store: %w", r.secondaryName, err)
+		}
+	}
+
+	srcUsage, srcOK := r.primary.(KeyUsageStore)
+	dstUsage, dstOK := r.secondary.(KeyUsageStore)
+	if srcOK && dstOK {
+		rows, err := srcUsage.KeyUsage(KeyUsageFilter{})
+		if err != nil {
+			return report, fmt.Errorf("read %s key usage: %w", r.primaryName, err)
+		}
+		existing, err := dstUsage.KeyUsage(KeyUsageFilter{})
+		if err != nil {
+			return report, fmt.Errorf("read %s key usage: %w", r.secondaryName, err)
+		}
+		report.UsageRows = len(rows)
+		if missing := usageDelta(rows, existing); len(missing) > 0 {
+			if err = dstUsage.AddKeyUsage(missing); err != nil {
+				return report, fmt.Errorf("backfill %s key usage: %w", r.secondaryName, err)
+			}
+			report.UsageCopied = len(missing)
+		}
+	}
+	return report, nil
+}
+
+// Verify compares both stores, updates the divergence metric and returns the status
+func (d *DualStore) Verify() (DualWriteStatus, error) {
+	r := d.roles.Load()
+	mismatches, err := divergence(r)
+	if err != nil {
+		return d.Status(), err
+	}
+	d.recordVerification(mismatches)
+	return d.Status(), nil
+}
+
+func divergence(r *dualRoles) ([]string, error) {
+	mismatches, err := VerifyMigration(r.primary, r.secondary)
+	if err != nil {
+		return nil, err
+	}
+	primary := r.primary.GetAll()
+	for _, apiKey := range slices.Sorted(maps.Keys(r.secondary.GetAll())) {
+		if _, ok := primary[apiKey]; !ok {
+			mismatches = append(mismatches, fmt.Sprintf("binding %s only in target", MaskKey(apiKey)))
+		}
+	}
+	return mismatches, nil
+}
+
+func (d *DualStore) recordVerification(mismatches []string) {
+	storeDivergence.Set(float64(len(mismatches)))
+	d.mu.Lock()
+	d.verified = time.Now().UTC()
+	d.diverged = mismatches
+	d.mu.Unlock()
+}
+
+// Cutover swaps the stores so reads come from the former secondary and writes
+// are mirrored to the former primary, keeping it usable for a rollback. Writes
+// are paused while both stores are compared; unless force is set the cutover is
+// refused with ErrStoresDiverged when they differ.
+func (d *DualStore) Cutover(force bool) (DualWriteStatus, error) {
+	d.lockAll()
+	defer d.unlockAll()
+
+	r := d.roles.Load()
+	mismatches, err := divergence(r)
+	if err != nil {
+		return d.Status(), err
+	}
+	d.recordVerification(mismatches)
+	if len(mismatches) > 0 && !force {
+		return d.Status(), fmt.Errorf("%w: %d differences", ErrStoresDiverged, len(mismatches))
+	}
+	d.roles.Store(&dualRoles{primary: r.secondary, secondary: r.primary, primaryName: r.secondaryName, secondaryName: r.primaryName})
+	d.mu.Lock()
+	d.cutoverAt = time.Now().UTC()
+	d.mu.Unlock()
+	log.Infof("device-binding: cut over from %s store to %s store", r.primaryName, r.secondaryName)
+	return d.Status(), nil
+}
+
+// Status reports the roles, the last verification and mirror failures
+func (d *DualStore) Status() DualWriteStatus {
+	r := d.roles.Load()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := DualWriteStatus{
+		Primary:        r.primaryName,
+		Secondary:      r.secondaryName,
+		CutoverAt:      d.cutoverAt,
+		LastVerified:   d.verified,
+		Divergence:     len(d.diverged),
+		MirrorFailures: d.failures.Load(),
+	}
+	status.Mismatches = slices.Clone(d.diverged[:min(len(d.diverged), maxReportedMismatches)])
+	return status
+}
+
+// Run verifies both stores every interval until ctx is done
+func (d *DualStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		status, err := d.Verify()
+		if err != nil {
+			log.Warnf("device-binding: dual-write verification failed: %v", err)
+		} else if status.Divergence > 0 {
+			log.Warnf("device-binding: %s and %s stores differ in %d places", status.Primary, status.Secondary, status.Divergence)
+		}
+	}
+}
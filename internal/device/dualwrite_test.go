@@ -0,0 +1,91 @@
+package device
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openDualStore(t *testing.T) (*DualStore, *FileStore, Store) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := OpenStore(StoreConfig{
+		Backend:   BackendYAML,
+		Dir:       dir,
+		DualWrite: &StoreConfig{Backend: BackendSQLite, Path: filepath.Join(dir, "bindings.db")},
+	})
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	dual, ok := store.(*DualStore)
+	if !ok {
+		t.Fatalf("OpenStore returned %T, want *DualStore", store)
+	}
+	r := dual.roles.Load()
+	return dual, r.primary.(*FileStore), r.secondary
+}
+
+func TestDualStoreMirrorsWritesAndCutsOver(t *testing.T) {
+	dual, primary, secondary := openDualStore(t)
+
+	// A binding written before dual-write started only exists in the primary.
+	if _, err := primary.AddDeviceWithinLimit("sk-legacy", "old-laptop", "client_id", "192.0.2.1", false, 10); err != nil {
+		t.Fatalf("AddDeviceWithinLimit: %v", err)
+	}
+	if _, err := dual.AddDeviceWithinLimit("sk-live", "laptop", "client_id", "203.0.113.7", false, 10); err != nil {
+		t.Fatalf("AddDeviceWithinLimit: %v", err)
+	}
+	if err := dual.Ban("sk-live", "shared", time.Hour, "203.0.113.7"); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+	if got, ok := secondary.Get("sk-live"); !ok || !got.Banned || len(got.Devices) != 1 {
+		t.Fatalf("secondary binding = %+v, %v; want mirrored ban", got, ok)
+	}
+
+	status, err := dual.Verify()
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if status.Divergence != 1 {
+		t.Fatalf("Divergence = %d, want 1 (legacy binding): %v", status.Divergence, status.Mismatches)
+	}
+	if _, err = dual.Cutover(false); !errors.Is(err, ErrStoresDiverged) {
+		t.Fatalf("Cutover() error = %v, want ErrStoresDiverged", err)
+	}
+
+	report, err := dual.Backfill()
+	if err != nil {
+		t.Fatalf("Backfill: %v", err)
+	}
+	if report.Bindings != 2 {
+		t.Fatalf("backfilled %d bindings, want 2", report.Bindings)
+	}
+	status, err = dual.Cutover(false)
+	if err != nil {
+		t.Fatalf("Cutover: %v", err)
+	}
+	if status.Primary != BackendSQLite || status.Secondary != BackendYAML {
+		t.Fatalf("roles after cutover = %s/%s", status.Primary, status.Secondary)
+	}
+
+	// Writes now land in SQLite first and are mirrored back to the YAML store.
+	if _, err = dual.Delete("sk-legacy"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := primary.Get("sk-legacy"); ok {
+		t.Fatalf("delete was not mirrored to the former primary")
+	}
+	if _, ok := dual.Get("sk-live"); !ok {
+		t.Fatalf("reads should be served by the new primary")
+	}
+}
+
+func TestOpenStoreRejectsDualWriteToSameStore(t *testing.T) {
+	dir := t.TempDir()
+	_, err := OpenStore(StoreConfig{Backend: BackendYAML, Dir: dir, DualWrite: &StoreConfig{Backend: "", Dir: dir}})
+	if err == nil {
+		t.Fatalf("OpenStore should refuse mirroring a store to itself")
+	}
+}
@@ -0,0 +1,99 @@
+package device
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/degraded"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultFallbackTTL = 5 * time.Minute
+	// maxFallbackEntries bounds the fallback cache; keys beyond it are not cached.
+	maxFallbackEntries = 10000
+)
+
+type fallbackEntry struct {
+	binding DeviceBinding
+	exists  bool
+	readAt  time.Time
+}
+
+// bindingCache keeps the last binding read for each key, so bans and device
+// limits stay enforced for a while when the store becomes unavailable.
+type bindingCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]fallbackEntry
+}
+
+func newBindingCache(ttl time.Duration) *bindingCache {
+	if ttl <= 0 {
+		ttl = defaultFallbackTTL
+	}
+	return &bindingCache{ttl: ttl, entries: make(map[string]fallbackEntry)}
+}
+
+func (b *bindingCache) put(apiKey string, binding DeviceBinding, exists bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.entries[apiKey]; !ok && len(b.entries) >= maxFallbackEntries {
+		for key, entry := range b.entries {
+			if now.Sub(entry.readAt) > b.ttl {
+				delete(b.entries, key)
+			}
+		}
+		if len(b.entries) >= maxFallbackEntries {
+			return
+		}
+	}
+	b.entries[apiKey] = fallbackEntry{binding: binding, exists: exists, readAt: now}
+}
+
+func (b *bindingCache) get(apiKey string, now time.Time) (DeviceBinding, bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.entries[apiKey]
+	if !ok || now.Sub(entry.readAt) > b.ttl {
+		return DeviceBinding{}, false, false
+	}
+	return entry.binding, entry.exists, true
+}
+
+// lookupBinding reads the binding of a key, falling back to the last binding
+// read when the store fails. available is false when neither worked.
+func (m *Middleware) lookupBinding(apiKey string) (binding DeviceBinding, exists, available bool) {
+	now := time.Now()
+	binding, exists, err := LookupBinding(m.store, apiKey)
+	degraded.Default().Report(degraded.ComponentDeviceStore, err)
+	if err == nil {
+		m.fallback.put(apiKey, binding, exists, now)
+		return binding, exists, true
+	}
+	log.Warnf("device-binding: store unavailable for key %s: %v", MaskKey(apiKey), err)
+	if binding, exists, ok := m.fallback.get(apiKey, now); ok {
+		bindingDecisions.Inc(decisionFallback)
+		return binding, exists, true
+	}
+	return DeviceBinding{}, false, false
+}
+
+// storeFailed handles a request the store could not check or record. In
+// fail-closed mode it answers 503 and returns true; in fail-open mode the
+// request goes on.
+func (m *Middleware) storeFailed(c *gin.Context, apiKey string) bool {
+	if !m.config.FailClosed {
+		return false
+	}
+	bindingDecisions.Inc(decisionUnavailable)
+	log.Warnf("device-binding: rejected key %s, store unavailable (fail-closed)", MaskKey(apiKey))
+	c.Header("Retry-After", "5")
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"error":   "device_store_unavailable",
+		"message": "Device binding is temporarily unavailable. Please retry shortly.",
+	})
+	return true
+}
@@ -0,0 +1,102 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+const earthRadiusKm = 6371.0
+
+// GeoLocation is the approximate position of an IP address
+type GeoLocation struct {
+	Latitude  float64
+	Longitude float64
+	Country   string
+	City      string
+}
+
+// GeoLocator resolves IP addresses to locations
+type GeoLocator interface {
+	Locate(ip string) (GeoLocation, bool)
+	Close() error
+}
+
+// GeoIPLocator looks up locations in a MaxMind GeoIP2/GeoLite2 City database
+type GeoIPLocator struct {
+	reader *geoip2.Reader
+}
+
+// OpenGeoIP opens a MaxMind City database (.mmdb)
+func OpenGeoIP(path string) (*GeoIPLocator, error) {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open geoip database: %w", err)
+	}
+	return &GeoIPLocator{reader: reader}, nil
+}
+
+// Locate returns the location of ip. It reports false for unparsable or
+// private addresses and for records without coordinates.
+func (g *GeoIPLocator) Locate(ip string) (GeoLocation, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsPrivate() || parsed.IsLoopback() {
+		return GeoLocation{}, false
+	}
+	record, err := g.reader.City(parsed)
+	if err != nil || (record.Location.Latitude == 0 && record.Location.Longitude == 0) {
+		return GeoLocation{}, false
+	}
+	return GeoLocation{
+		Latitude:  record.Location.Latitude,
+		Longitude: record.Location.Longitude,
+		Country:   record.Country.IsoCode,
+		City:      record.City.Names["en"],
+	}, true
+}
+
+// Close releases the database
+func (g *GeoIPLocator) Close() error {
+	return g.reader.Close()
+}
+
+// DistanceKm returns the great-circle distance between two locations
+func DistanceKm(a, b GeoLocation) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(b.Latitude - a.Latitude)
+	dLon := toRad(b.Longitude - a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.Latitude))*math.Cos(toRad(b.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// plausibleTravel reports whether an IP change is explained by the user moving:
+// the two locations are closer than MinDistanceKm, or covering the distance in
+// elapsed does not exceed MaxTravelSpeedKmh. Without a locator, thresholds or
+// known locations for both IPs the change is not considered plausible, so
+// detection behaves as if GeoIP were not configured.
+func (m *Middleware) plausibleTravel(previousIP, currentIP string, elapsed time.Duration) (bool, float64) {
+	if m.geo == nil || (m.config.MinDistanceKm <= 0 && m.config.MaxTravelSpeedKmh <= 0) {
+		return false, 0
+	}
+	from, ok := m.geo.Locate(previousIP)
+	if !ok {
+		return false, 0
+	}
+	to, ok := m.geo.Locate(currentIP)
+	if !ok {
+		return false, 0
+	}
+	distance := DistanceKm(from, to)
+	if m.config.MinDistanceKm > 0 && distance < m.config.MinDistanceKm {
+		return true, distance
+	}
+	if m.config.MaxTravelSpeedKmh > 0 {
+		hours := math.Max(elapsed.Hours(), time.Second.Hours())
+		return distance/hours <= m.config.MaxTravelSpeedKmh, distance
+	}
+	return false, distance
+}
@@ -0,0 +1,79 @@
+package device
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fakeLocator map[string]GeoLocation
+
+func (f fakeLocator) Locate(ip string) (GeoLocation, bool) {
+	loc, ok := f[ip]
+	return loc, ok
+}
+
+func (f fakeLocator) Close() error { return nil }
+
+// newLookupTestEngine is newTestEngine with a hook to replace the middleware's lookup databases
+func newLookupTestEngine(t *testing.T, cfg Config, setup func(*Middleware)) (*gin.Engine, *FileStore) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	cfg.Enabled = true
+	mw := NewMiddleware(store, cfg)
+	setup(mw)
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("X-Test-Key"))
+		c.Next()
+	})
+	engine.Use(mw.Handler())
+	engine.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return engine, store
+}
+
+var (
+	hanoiA = GeoLocation{Latitude: 21.0285, Longitude: 105.8542, City: "Hanoi"}
+	hanoiB = GeoLocation{Latitude: 21.0367, Longitude: 105.8342, City: "Hanoi"}
+	paris  = GeoLocation{Latitude: 48.8566, Longitude: 2.3522, City: "Paris"}
+)
+
+func TestDistanceKm(t *testing.T) {
+	if d := DistanceKm(hanoiA, paris); math.Abs(d-9200) > 100 {
+		t.Fatalf("Hanoi-Paris distance = %.0fkm, want about 9200km", d)
+	}
+	if d := DistanceKm(hanoiA, hanoiA); d != 0 {
+		t.Fatalf("distance to self = %f", d)
+	}
+}
+
+func TestMiddlewareExcusesNearbyIPChangesButBansImpossibleTravel(t *testing.T) {
+	engine, store := newLookupTestEngine(t, Config{ConcurrentThreshold: time.Minute, MinDistanceKm: 50, MaxTravelSpeedKmh: 1000}, func(mw *Middleware) {
+		mw.geo = fakeLocator{"10.0.0.1": hanoiA, "10.0.0.2": hanoiB, "10.0.0.3": paris}
+	})
+	key := "key-123456789"
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		if rec := doRequest(engine, key, "dev-a", ip); rec.Code != http.StatusOK {
+			t.Fatalf("expected same-city request from %s to pass, got %d", ip, rec.Code)
+		}
+	}
+	if binding, _ := store.Get(key); binding.Strikes != 0 {
+		t.Fatalf("expected no strikes for same-city IP change, got %d", binding.Strikes)
+	}
+
+	if rec := doRequest(engine, key, "dev-a", "10.0.0.3"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected impossible travel to be rejected, got %d", rec.Code)
+	}
+	if binding, _ := store.Get(key); !binding.Banned {
+		t.Fatal("expected key to be banned after impossible travel")
+	}
+}
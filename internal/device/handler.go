@@ -1,25 +1,31 @@
 package device
 
 import (
+	"net"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
 	log "github.com/sirupsen/logrus"
 )
 
 // Handler handles management API requests for device bindings
 type Handler struct {
-	store *Store
+	store    Store
+	activity *ActivityLog
 }
 
 // NewHandler creates a new Handler
-func NewHandler(store *Store) *Handler {
+func NewHandler(store Store) *Handler {
 	return &Handler{store: store}
 }
 
-// GetBindings returns all device bindings or a specific one
+// GetBindings returns all device bindings, a filtered page of them, or a specific one
 // GET /v0/management/device-bindings
 // GET /v0/management/device-bindings?api-key=xxx
+// GET /v0/management/device-bindings?page=1&limit=50&banned=true&type=ip&last_seen_before=RFC3339&sort=-last_seen
 func (h *Handler) GetBindings(c *gin.Context) {
 	apiKey := strings.TrimSpace(c.Query("api-key"))
 
@@ -40,6 +46,11 @@ func (h *Handler) GetBindings(c *gin.Context) {
 		return
 	}
 
+	if isListRequest(c) {
+		h.listBindings(c)
+		return
+	}
+
 	// Get all bindings
 	bindings := h.store.GetAll()
 	c.JSON(200, gin.H{
@@ -96,6 +107,80 @@ func (h *Handler) DeleteBinding(c *gin.Context) {
 	})
 }
 
+// banRequest is the body of a manual ban
+type banRequest struct {
+	Reason string `json:"reason"`
+	// Duration is the ban length in seconds; 0 or omitted bans permanently
+	Duration int `json:"duration"`
+}
+
+// BanKey bans an API key manually, e.g. to block a leaked key before it is abused.
+// Keys without a binding yet are banned too.
+// POST /v0/management/device-bindings/ban?api-key=xxx  {"reason": "...", "duration": 3600}
+func (h *Handler) BanKey(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+
+	if apiKey == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+
+	var req banRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{
+				"error":   "invalid_body",
+				"message": "Invalid ban request: " + err.Error(),
+			})
+			return
+		}
+	}
+	if req.Duration < 0 {
+		c.JSON(400, gin.H{
+			"error":   "invalid_body",
+			"message": "duration must not be negative",
+		})
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		reason = "banned by admin"
+	}
+
+	if binding, exists := h.store.Get(apiKey); exists && binding.Banned && !binding.BanExpired(time.Now()) {
+		c.JSON(400, gin.H{
+			"error":   "already_banned",
+			"message": "This API key is already banned: " + binding.BanReason,
+		})
+		return
+	}
+
+	duration := time.Duration(req.Duration) * time.Second
+	if err := h.store.Ban(apiKey, reason, duration); err != nil {
+		log.Errorf("device-binding: failed to ban key %s: %v", MaskKey(apiKey), err)
+		c.JSON(500, gin.H{
+			"error":   "internal_error",
+			"message": "Failed to ban API key",
+		})
+		return
+	}
+
+	log.Infof("device-binding: banned key %s by admin, reason: %s", MaskKey(apiKey), reason)
+	events.Publish(events.Event{Type: events.TypeBan, APIKey: apiKey, Reason: reason, Actor: "admin"})
+	body := gin.H{
+		"message": "API key banned successfully",
+		"api_key": apiKey,
+		"reason":  reason,
+	}
+	if binding, exists := h.store.Get(apiKey); exists && !binding.BanExpiresAt.IsZero() {
+		body["ban_expires_at"] = binding.BanExpiresAt
+	}
+	c.JSON(200, body)
+}
+
 // UnbanKey removes ban from an API key
 // POST /v0/management/device-bindings/unban?api-key=xxx
 func (h *Handler) UnbanKey(c *gin.Context) {
@@ -126,7 +211,7 @@ func (h *Handler) UnbanKey(c *gin.Context) {
 		return
 	}
 
-	if err := h.store.Unban(apiKey); err != nil {
+	if err := h.store.Unban(apiKey, "admin"); err != nil {
 		log.Errorf("device-binding: failed to unban key %s: %v", MaskKey(apiKey), err)
 		c.JSON(500, gin.H{
 			"error":   "internal_error",
@@ -136,16 +221,564 @@ func (h *Handler) UnbanKey(c *gin.Context) {
 	}
 
 	log.Infof("device-binding: unbanned key %s by admin", MaskKey(apiKey))
+	events.Publish(events.Event{Type: events.TypeUnban, APIKey: apiKey, Reason: binding.BanReason, Actor: "admin"})
 	c.JSON(200, gin.H{
 		"message": "API key unbanned successfully",
 		"api_key": apiKey,
 	})
 }
 
+// GetHistory returns the ban history of an API key, newest first
+// GET /v0/management/device-bindings/history?api-key=xxx
+func (h *Handler) GetHistory(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	if apiKey == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+
+	binding, exists := h.store.Get(apiKey)
+	if !exists {
+		c.JSON(404, gin.H{
+			"error":   "not_found",
+			"message": "No device binding found for this API key",
+		})
+		return
+	}
+
+	history := make([]BanRecord, 0, len(binding.BanHistory))
+	for i := len(binding.BanHistory) - 1; i >= 0; i-- {
+		history = append(history, binding.BanHistory[i])
+	}
+	c.JSON(200, gin.H{
+		"api_key": apiKey,
+		"banned":  binding.Banned,
+		"history": history,
+	})
+}
+
+// GetDevices lists the devices bound to an API key
+// GET /v0/management/device-bindings/devices?api-key=xxx
+func (h *Handler) GetDevices(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+
+	if apiKey == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+
+	binding, exists := h.store.Get(apiKey)
+	if !exists {
+		c.JSON(404, gin.H{
+			"error":   "not_found",
+			"message": "No device binding found for this API key",
+		})
+		return
+	}
+
+	devices := binding.Devices
+	if devices == nil {
+		devices = []Device{}
+	}
+	c.JSON(200, gin.H{
+		"api_key": apiKey,
+		"devices": devices,
+	})
+}
+
+// DeleteDevice removes a single device from an API key
+// DELETE /v0/management/device-bindings/devices?api-key=xxx&device-id=yyy
+func (h *Handler) DeleteDevice(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	deviceID := strings.TrimSpace(c.Query("device-id"))
+
+	if apiKey == "" || deviceID == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key and device-id parameters are required",
+		})
+		return
+	}
+
+	removed, err := h.store.RemoveDevice(apiKey, deviceID)
+	if err != nil {
+		log.Errorf("device-binding: failed to remove device %s for key %s: %v", deviceID, MaskKey(apiKey), err)
+		c.JSON(500, gin.H{
+			"error":   "internal_error",
+			"message": "Failed to remove device",
+		})
+		return
+	}
+	if !removed {
+		c.JSON(404, gin.H{
+			"error":   "not_found",
+			"message": "Device not found for this API key",
+		})
+		return
+	}
+
+	log.Infof("device-binding: removed device %s from key %s by admin", deviceID, MaskKey(apiKey))
+	c.JSON(200, gin.H{
+		"message":   "Device removed successfully",
+		"api_key":   apiKey,
+		"device_id": deviceID,
+	})
+}
+
+// ApproveDevice approves a pending device for an API key
+// POST /v0/management/device-bindings/approve?api-key=xxx&device-id=yyy
+func (h *Handler) ApproveDevice(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	deviceID := strings.TrimSpace(c.Query("device-id"))
+
+	if apiKey == "" || deviceID == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key and device-id parameters are required",
+		})
+		return
+	}
+
+	approved, err := h.store.Approve(apiKey, deviceID)
+	if err != nil {
+		log.Errorf("device-binding: failed to approve device %s for key %s: %v", deviceID, MaskKey(apiKey), err)
+		c.JSON(500, gin.H{
+			"error":   "internal_error",
+			"message": "Failed to approve device",
+		})
+		return
+	}
+	if !approved {
+		c.JSON(404, gin.H{
+			"error":   "not_found",
+			"message": "No pending device found for this API key",
+		})
+		return
+	}
+
+	log.Infof("device-binding: approved device %s for key %s by admin", deviceID, MaskKey(apiKey))
+	events.Publish(events.Event{Type: events.TypeDeviceApproved, APIKey: apiKey, DeviceID: deviceID, Actor: "admin"})
+	c.JSON(200, gin.H{
+		"message":   "Device approved successfully",
+		"api_key":   apiKey,
+		"device_id": deviceID,
+	})
+}
+
+// GetMetadata returns the metadata of an API key or one of its devices
+// GET /v0/management/device-bindings/metadata?api-key=xxx[&device-id=yyy]
+func (h *Handler) GetMetadata(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	deviceID := strings.TrimSpace(c.Query("device-id"))
+
+	if apiKey == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+
+	binding, exists := h.store.Get(apiKey)
+	if !exists {
+		c.JSON(404, gin.H{
+			"error":   "not_found",
+			"message": "No device binding found for this API key",
+		})
+		return
+	}
+
+	metadata := binding.Metadata
+	if deviceID != "" {
+		idx := binding.FindDevice(deviceID)
+		if idx < 0 {
+			c.JSON(404, gin.H{
+				"error":   "not_found",
+				"message": "Device not found for this API key",
+			})
+			return
+		}
+		metadata = binding.Devices[idx].Metadata
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	c.JSON(200, gin.H{
+		"api_key":   apiKey,
+		"device_id": deviceID,
+		"metadata":  metadata,
+	})
+}
+
+// PutMetadata replaces the metadata of an API key or one of its devices
+// PUT /v0/management/device-bindings/metadata?api-key=xxx[&device-id=yyy]  {"metadata": {...}}
+func (h *Handler) PutMetadata(c *gin.Context) {
+	h.updateMetadata(c, false)
+}
+
+// PatchMetadata merges metadata into an API key or one of its devices; empty values remove keys
+// PATCH /v0/management/device-bindings/metadata?api-key=xxx[&device-id=yyy]  {"metadata": {...}}
+func (h *Handler) PatchMetadata(c *gin.Context) {
+	h.updateMetadata(c, true)
+}
+
+// DeleteMetadata clears the metadata of an API key or one of its devices
+// DELETE /v0/management/device-bindings/metadata?api-key=xxx[&device-id=yyy]
+func (h *Handler) DeleteMetadata(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	deviceID := strings.TrimSpace(c.Query("device-id"))
+	if apiKey == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+	if _, exists := h.store.Get(apiKey); !exists {
+		c.JSON(404, gin.H{
+			"error":   "not_found",
+			"message": "No device binding found for this API key",
+		})
+		return
+	}
+	h.writeMetadata(c, apiKey, deviceID, nil, false)
+}
+
+func (h *Handler) updateMetadata(c *gin.Context, merge bool) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	deviceID := strings.TrimSpace(c.Query("device-id"))
+	if apiKey == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+
+	var body struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Metadata == nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid_body",
+			"message": "Body must be a JSON object with a metadata map of string values",
+		})
+		return
+	}
+	h.writeMetadata(c, apiKey, deviceID, body.Metadata, merge)
+}
+
+func (h *Handler) writeMetadata(c *gin.Context, apiKey, deviceID string, metadata map[string]string, merge bool) {
+	found, err := h.store.SetMetadata(apiKey, deviceID, metadata, merge)
+	if err != nil {
+		log.Errorf("device-binding: failed to update metadata for key %s: %v", MaskKey(apiKey), err)
+		c.JSON(500, gin.H{
+			"error":   "internal_error",
+			"message": "Failed to update metadata",
+		})
+		return
+	}
+	if !found {
+		c.JSON(404, gin.H{
+			"error":   "not_found",
+			"message": "Device not found for this API key",
+		})
+		return
+	}
+	h.GetMetadata(c)
+}
+
+// GetPolicy returns the policy overrides of an API key
+// GET /v0/management/device-bindings/policy?api-key=xxx
+func (h *Handler) GetPolicy(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	if apiKey == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+
+	policy := Policy{}
+	if binding, exists := h.store.Get(apiKey); exists && binding.Policy != nil {
+		policy = *binding.Policy
+	}
+	c.JSON(200, gin.H{
+		"api_key": apiKey,
+		"policy":  policy,
+	})
+}
+
+// PutPolicy replaces the policy overrides of an API key
+// PUT /v0/management/device-bindings/policy?api-key=xxx
+// Body: {"max_devices": 5, "concurrent_threshold": -1, "ban_duration": 3600, "concurrent_action": "warn", "tls_fingerprint": "enforce", "require_attestation": true}
+func (h *Handler) PutPolicy(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	if apiKey == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+
+	var policy Policy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid_body",
+			"message": "Invalid policy: " + err.Error(),
+		})
+		return
+	}
+	if policy.MaxDevices < 0 || (policy.BanDuration != nil && *policy.BanDuration < 0) || !ValidConcurrentAction(policy.ConcurrentAction) || !ValidTLSFingerprintMode(policy.TLSFingerprint) {
+		c.JSON(400, gin.H{
+			"error":   "invalid_body",
+			"message": "max_devices and ban_duration must not be negative; concurrent_action must be one of warn, ban, ignore or empty; tls_fingerprint must be one of off, record, enforce or empty",
+		})
+		return
+	}
+
+	h.writePolicy(c, apiKey, &policy)
+}
+
+// DeletePolicy removes all policy overrides of an API key
+// DELETE /v0/management/device-bindings/policy?api-key=xxx
+func (h *Handler) DeletePolicy(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	if apiKey == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+	if _, exists := h.store.Get(apiKey); !exists {
+		c.JSON(404, gin.H{
+			"error":   "not_found",
+			"message": "No device binding found for this API key",
+		})
+		return
+	}
+	h.writePolicy(c, apiKey, nil)
+}
+
+func (h *Handler) writePolicy(c *gin.Context, apiKey string, policy *Policy) {
+	if err := h.store.SetPolicy(apiKey, policy); err != nil {
+		log.Errorf("device-binding: failed to update policy for key %s: %v", MaskKey(apiKey), err)
+		c.JSON(500, gin.H{
+			"error":   "internal_error",
+			"message": "Failed to update policy",
+		})
+		return
+	}
+	log.Infof("device-binding: updated policy for key %s by admin", MaskKey(apiKey))
+	h.GetPolicy(c)
+}
+
+// DeleteTLSFingerprints removes the pinned TLS fingerprints of an API key so the
+// next connections pin new ones, e.g. after the client was upgraded
+// DELETE /v0/management/device-bindings/tls-fingerprints?api-key=xxx
+func (h *Handler) DeleteTLSFingerprints(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	if apiKey == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+	cleared, err := h.store.ClearTLSFingerprints(apiKey)
+	if err != nil {
+		log.Errorf("device-binding: failed to clear tls fingerprints for key %s: %v", MaskKey(apiKey), err)
+		c.JSON(500, gin.H{
+			"error":   "internal_error",
+			"message": "Failed to clear TLS fingerprints",
+		})
+		return
+	}
+	if !cleared {
+		c.JSON(404, gin.H{
+			"error":   "not_found",
+			"message": "No TLS fingerprints are pinned to this API key",
+		})
+		return
+	}
+	log.Infof("device-binding: cleared tls fingerprints for key %s by admin", MaskKey(apiKey))
+	c.JSON(200, gin.H{"status": "ok", "api_key": apiKey})
+}
+
+// deviceBanTarget reads the device-id or ip query parameter of a device ban
+// request; exactly one of them must be given.
+func deviceBanTarget(c *gin.Context) (apiKey, deviceID, ip string, ok bool) {
+	apiKey = strings.TrimSpace(c.Query("api-key"))
+	deviceID = strings.TrimSpace(c.Query("device-id"))
+	ip = strings.TrimSpace(c.Query("ip"))
+	if apiKey == "" || (deviceID == "") == (ip == "") {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key and exactly one of device-id or ip parameters are required",
+		})
+		return "", "", "", false
+	}
+	if ip != "" && net.ParseIP(ip) == nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid_parameter",
+			"message": "ip is not a valid IP address",
+		})
+		return "", "", "", false
+	}
+	return apiKey, deviceID, ip, true
+}
+
+// GetDeviceBans lists the active device and IP bans of an API key, or of all keys
+// GET /v0/management/device-bindings/device-bans[?api-key=xxx]
+func (h *Handler) GetDeviceBans(c *gin.Context) {
+	type keyedBan struct {
+		APIKey string `json:"api_key"`
+		DeviceBan
+	}
+	now := time.Now()
+	bans := []keyedBan{}
+	if apiKey := strings.TrimSpace(c.Query("api-key")); apiKey != "" {
+		binding, exists := h.store.Get(apiKey)
+		if !exists {
+			c.JSON(404, gin.H{
+				"error":   "not_found",
+				"message": "No device binding found for this API key",
+			})
+			return
+		}
+		for _, ban := range binding.ActiveDeviceBans(now) {
+			bans = append(bans, keyedBan{APIKey: apiKey, DeviceBan: ban})
+		}
+	} else {
+		for key, binding := range h.store.GetAll() {
+			for _, ban := range binding.ActiveDeviceBans(now) {
+				bans = append(bans, keyedBan{APIKey: key, DeviceBan: ban})
+			}
+		}
+		sort.Slice(bans, func(i, j int) bool { return bans[i].BannedAt.After(bans[j].BannedAt) })
+	}
+	c.JSON(200, gin.H{"device_bans": bans})
+}
+
+// BanDevice bans a single device or client IP of an API key; the key keeps
+// working from its other devices
+// POST /v0/management/device-bindings/ban-device?api-key=xxx&device-id=yyy  {"reason": "...", "duration": 3600}
+// POST /v0/management/device-bindings/ban-device?api-key=xxx&ip=1.2.3.4
+func (h *Handler) BanDevice(c *gin.Context) {
+	apiKey, deviceID, ip, ok := deviceBanTarget(c)
+	if !ok {
+		return
+	}
+	var req banRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{
+				"error":   "invalid_body",
+				"message": "Invalid ban request: " + err.Error(),
+			})
+			return
+		}
+	}
+	if req.Duration < 0 {
+		c.JSON(400, gin.H{
+			"error":   "invalid_body",
+			"message": "duration must not be negative",
+		})
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		reason = "banned by admin"
+	}
+
+	ban := DeviceBan{DeviceID: deviceID, IP: ip, Reason: reason, BannedAt: time.Now()}
+	if req.Duration > 0 {
+		ban.ExpiresAt = ban.BannedAt.Add(time.Duration(req.Duration) * time.Second)
+	}
+	if err := h.store.BanDevice(apiKey, ban); err != nil {
+		log.Errorf("device-binding: failed to ban device for key %s: %v", MaskKey(apiKey), err)
+		c.JSON(500, gin.H{
+			"error":   "internal_error",
+			"message": "Failed to ban device",
+		})
+		return
+	}
+
+	log.Infof("device-binding: banned device of key %s by admin (device=%s, ip=%s), reason: %s", MaskKey(apiKey), deviceID, ip, reason)
+	events.Publish(events.Event{Type: events.TypeDeviceBanned, APIKey: apiKey, DeviceID: deviceID, IP: ip, Reason: reason, Actor: "admin"})
+	c.JSON(200, gin.H{
+		"message": "Device banned successfully",
+		"api_key": apiKey,
+		"ban":     ban,
+	})
+}
+
+// UnbanDevice lifts the ban of a device or client IP of an API key
+// POST /v0/management/device-bindings/unban-device?api-key=xxx&device-id=yyy
+// POST /v0/management/device-bindings/unban-device?api-key=xxx&ip=1.2.3.4
+func (h *Handler) UnbanDevice(c *gin.Context) {
+	apiKey, deviceID, ip, ok := deviceBanTarget(c)
+	if !ok {
+		return
+	}
+	lifted, err := h.store.UnbanDevice(apiKey, deviceID, ip)
+	if err != nil {
+		log.Errorf("device-binding: failed to unban device for key %s: %v", MaskKey(apiKey), err)
+		c.JSON(500, gin.H{
+			"error":   "internal_error",
+			"message": "Failed to unban device",
+		})
+		return
+	}
+	if !lifted {
+		c.JSON(404, gin.H{
+			"error":   "not_found",
+			"message": "No active ban found for this device of the API key",
+		})
+		return
+	}
+
+	log.Infof("device-binding: unbanned device of key %s by admin (device=%s, ip=%s)", MaskKey(apiKey), deviceID, ip)
+	events.Publish(events.Event{Type: events.TypeDeviceUnbanned, APIKey: apiKey, DeviceID: deviceID, IP: ip, Actor: "admin"})
+	c.JSON(200, gin.H{
+		"message":   "Device unbanned successfully",
+		"api_key":   apiKey,
+		"device_id": deviceID,
+		"ip":        ip,
+	})
+}
+
 // RegisterRoutes registers device binding routes on a router group
 // The group should already have management authentication middleware applied
 func (h *Handler) RegisterRoutes(group *gin.RouterGroup) {
 	group.GET("/device-bindings", h.GetBindings)
 	group.DELETE("/device-bindings", h.DeleteBinding)
+	group.POST("/device-bindings/ban", h.BanKey)
 	group.POST("/device-bindings/unban", h.UnbanKey)
+	group.GET("/device-bindings/history", h.GetHistory)
+	group.GET("/device-bindings/activity", h.GetActivity)
+	group.GET("/device-bindings/device-bans", h.GetDeviceBans)
+	group.POST("/device-bindings/ban-device", h.BanDevice)
+	group.POST("/device-bindings/unban-device", h.UnbanDevice)
+	group.POST("/device-bindings/approve", h.ApproveDevice)
+	group.GET("/device-bindings/devices", h.GetDevices)
+	group.DELETE("/device-bindings/devices", h.DeleteDevice)
+	group.GET("/device-bindings/metadata", h.GetMetadata)
+	group.PUT("/device-bindings/metadata", h.PutMetadata)
+	group.PATCH("/device-bindings/metadata", h.PatchMetadata)
+	group.DELETE("/device-bindings/metadata", h.DeleteMetadata)
+	group.GET("/device-bindings/policy", h.GetPolicy)
+	group.PUT("/device-bindings/policy", h.PutPolicy)
+	group.DELETE("/device-bindings/policy", h.DeletePolicy)
+	group.DELETE("/device-bindings/tls-fingerprints", h.DeleteTLSFingerprints)
+	group.GET("/search", h.Search)
 }
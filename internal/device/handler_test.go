@@ -0,0 +1,101 @@
+package device
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBanKeyBansUnboundKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	engine := gin.New()
+	NewHandler(store).RegisterRoutes(engine.Group("/"))
+	ban := func(query, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/device-bindings/ban"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := ban("", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without api-key, got %d", rec.Code)
+	}
+	if rec := ban("?api-key=sk-leaked", `{"duration":-1}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for negative duration, got %d", rec.Code)
+	}
+	rec := ban("?api-key=sk-leaked", `{"reason":"leaked on GitHub","duration":3600}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "ban_expires_at") {
+		t.Fatalf("unexpected ban response %d %s", rec.Code, rec.Body.String())
+	}
+	binding, exists := store.Get("sk-leaked")
+	if !exists || !binding.Banned || binding.BanReason != "leaked on GitHub" || len(binding.BanHistory) != 1 {
+		t.Fatalf("expected unbound key to be banned, got %+v", binding)
+	}
+	if rec = ban("?api-key=sk-leaked", ""); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "already_banned") {
+		t.Fatalf("expected already_banned, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDeviceBanBlocksOnlyTheBannedDevice(t *testing.T) {
+	engine, store := newTestEngine(t, Config{MaxDevices: 3})
+	NewHandler(store).RegisterRoutes(engine.Group("/"))
+	manage := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	const key = "key-123456789"
+	for _, dev := range []string{"laptop", "shared"} {
+		if rec := doRequest(engine, key, dev, "10.0.0.1"); rec.Code != http.StatusOK {
+			t.Fatalf("expected %s to be accepted, got %d", dev, rec.Code)
+		}
+	}
+
+	if rec := manage(http.MethodPost, "/device-bindings/ban-device?api-key="+key); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a target, got %d", rec.Code)
+	}
+	if rec := manage(http.MethodPost, "/device-bindings/ban-device?api-key="+key+"&ip=not-an-ip"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid ip, got %d", rec.Code)
+	}
+	if rec := manage(http.MethodPost, "/device-bindings/ban-device?api-key="+key+"&device-id=shared"); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected ban response %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := manage(http.MethodPost, "/device-bindings/ban-device?api-key="+key+"&ip=10.0.0.9"); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected ip ban response %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := doRequest(engine, key, "shared", "10.0.0.1"); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "device_banned") {
+		t.Fatalf("expected banned device to be rejected, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(engine, key, "laptop", "10.0.0.9"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected banned IP to be rejected, got %d", rec.Code)
+	}
+	if rec := doRequest(engine, key, "laptop", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected legitimate device to keep working, got %d", rec.Code)
+	}
+	if binding, _ := store.Get(key); binding.Banned {
+		t.Fatal("expected the key itself to stay unbanned")
+	}
+
+	rec := manage(http.MethodGet, "/device-bindings/device-bans")
+	if rec.Code != http.StatusOK || strings.Count(rec.Body.String(), `"api_key"`) != 2 {
+		t.Fatalf("unexpected device ban list %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = manage(http.MethodPost, "/device-bindings/unban-device?api-key="+key+"&device-id=shared"); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected unban response %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = manage(http.MethodPost, "/device-bindings/unban-device?api-key="+key+"&device-id=shared"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a device without ban, got %d", rec.Code)
+	}
+	if rec = doRequest(engine, key, "shared", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected unbanned device to be accepted, got %d", rec.Code)
+	}
+}
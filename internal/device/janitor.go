@@ -0,0 +1,147 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultJanitorInterval is how often the janitor scans for stale bindings
+const defaultJanitorInterval = time.Hour
+
+// JanitorConfig configures stale binding cleanup
+type JanitorConfig struct {
+	// TTL is how long a binding may go unseen before it is removed
+	TTL time.Duration
+	// ArchivePath, when set, is a JSONL file each removed binding is appended to
+	ArchivePath string
+	// Interval is how often bindings are scanned. Default: 1 hour.
+	Interval time.Duration
+}
+
+// Janitor removes bindings of API keys that have not been seen for longer than
+// the TTL. Banned keys and keys with admin-defined metadata or a policy override
+// are kept, since removing them would silently lift a ban or lose admin data.
+type Janitor struct {
+	store Store
+	cfg   JanitorConfig
+	now   func() time.Time
+}
+
+// archivedBinding is one line of the archive file
+type archivedBinding struct {
+	APIKey     string        `json:"api_key"`
+	ArchivedAt time.Time     `json:"archived_at"`
+	Binding    DeviceBinding `json:"binding"`
+}
+
+// NewJanitor creates a janitor, or returns nil when the TTL is not positive.
+func NewJanitor(store Store, cfg JanitorConfig) *Janitor {
+	if store == nil || cfg.TTL <= 0 {
+		return nil
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultJanitorInterval
+	}
+	return &Janitor{store: store, cfg: cfg, now: time.Now}
+}
+
+// Run sweeps stale bindings every interval until ctx is cancelled.
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if removed, err := j.Sweep(); err != nil {
+			log.Warnf("device-binding: stale binding cleanup failed: %v", err)
+		} else if removed > 0 {
+			log.Infof("device-binding: removed %d stale binding(s)", removed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep removes every stale binding once and returns how many were removed.
+func (j *Janitor) Sweep() (int, error) {
+	cutoff := j.now().Add(-j.cfg.TTL)
+	removed := 0
+	for apiKey, binding := range j.store.GetAll() {
+		if !stale(binding, cutoff) {
+			continue
+		}
+		// Re-read so a request that arrived during the scan keeps its binding.
+		current, exists := j.store.Get(apiKey)
+		if !exists || !stale(current, cutoff) {
+			continue
+		}
+		if j.cfg.ArchivePath != "" {
+			if err := j.archive(apiKey, current); err != nil {
+				return removed, fmt.Errorf("archive %s: %w", MaskKey(apiKey), err)
+			}
+		}
+		ok, err := j.store.Delete(apiKey)
+		if err != nil {
+			return removed, fmt.Errorf("delete %s: %w", MaskKey(apiKey), err)
+		}
+		if ok {
+			removed++
+			staleBindingsRemoved.Inc(j.action())
+		}
+	}
+	return removed, nil
+}
+
+func (j *Janitor) action() string {
+	if j.cfg.ArchivePath != "" {
+		return "archived"
+	}
+	return "deleted"
+}
+
+// archive appends a binding to the archive file
+func (j *Janitor) archive(apiKey string, binding DeviceBinding) error {
+	line, err := json.Marshal(archivedBinding{APIKey: apiKey, ArchivedAt: j.now().UTC(), Binding: binding})
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(j.cfg.ArchivePath); dir != "." {
+		if err = os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(j.cfg.ArchivePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stale reports whether a binding was last active before cutoff and may be removed.
+// Keys that were never seen fall back to their first-seen time.
+func stale(binding DeviceBinding, cutoff time.Time) bool {
+	if binding.Banned || len(binding.Metadata) > 0 || binding.Policy != nil {
+		return false
+	}
+	lastActive := binding.LastSeen
+	for _, dev := range binding.Devices {
+		if dev.LastSeen.After(lastActive) {
+			lastActive = dev.LastSeen
+		}
+	}
+	if lastActive.IsZero() {
+		lastActive = binding.FirstSeen
+	}
+	return !lastActive.IsZero() && lastActive.Before(cutoff)
+}
@@ -0,0 +1,67 @@
+package device
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJanitorRemovesStaleBindings(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	_, _ = store.AddDeviceWithinLimit("key-stale", "203.0.113.1", "ip", "203.0.113.1", false, 10)
+	_, _ = store.AddDeviceWithinLimit("key-banned", "203.0.113.2", "ip", "203.0.113.2", false, 10)
+	_ = store.Ban("key-banned", "abuse", 0)
+	_, _ = store.AddDeviceWithinLimit("key-tagged", "203.0.113.3", "ip", "203.0.113.3", false, 10)
+	_, _ = store.SetMetadata("key-tagged", "", map[string]string{"customer": "acme"}, false)
+
+	archive := filepath.Join(dir, "archive", "stale.jsonl")
+	janitor := NewJanitor(store, JanitorConfig{TTL: 24 * time.Hour, ArchivePath: archive})
+	if removed, errSweep := janitor.Sweep(); errSweep != nil || removed != 0 {
+		t.Fatalf("expected fresh bindings to be kept, removed %d: %v", removed, errSweep)
+	}
+
+	janitor.now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+	removed, err := janitor.Sweep()
+	if err != nil || removed != 1 {
+		t.Fatalf("expected one stale binding to be removed, got %d: %v", removed, err)
+	}
+	if _, exists := store.Get("key-stale"); exists {
+		t.Fatal("expected stale binding to be deleted")
+	}
+	for _, key := range []string{"key-banned", "key-tagged"} {
+		if _, exists := store.Get(key); !exists {
+			t.Fatalf("expected %s to be kept", key)
+		}
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("expected an archived binding")
+	}
+	var entry archivedBinding
+	if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.APIKey != "key-stale" || len(entry.Binding.Devices) != 1 {
+		t.Fatalf("unexpected archive entry %+v: %v", entry, err)
+	}
+}
+
+func TestNewJanitorDisabled(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	if NewJanitor(store, JanitorConfig{}) != nil {
+		t.Fatal("expected no janitor without a TTL")
+	}
+}
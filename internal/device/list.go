@@ -0,0 +1,199 @@
+package device
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 1000
+)
+
+// listParams are the query parameters that switch GET /device-bindings to a paginated listing
+var listParams = []string{"page", "limit", "banned", "type", "last_seen_before", "last_seen_after", "sort"}
+
+// ListOptions filters, sorts and paginates device bindings
+type ListOptions struct {
+	// Page is 1-based; Limit is the page size
+	Page  int
+	Limit int
+	// Banned filters by ban state when non-nil
+	Banned *bool
+	// Type keeps keys with at least one device of this type ("ip" or "client_id")
+	Type string
+	// LastSeenBefore and LastSeenAfter filter by the key's last activity when non-zero
+	LastSeenBefore time.Time
+	LastSeenAfter  time.Time
+	// Sort is "api_key" (default), "last_seen", "first_seen", "devices" or "strikes";
+	// a leading "-" sorts descending
+	Sort string
+}
+
+// BindingEntry is one API key in a binding listing
+type BindingEntry struct {
+	APIKey  string        `json:"api_key"`
+	Binding DeviceBinding `json:"binding"`
+}
+
+// bindingSorters order entries ascending by the named field
+var bindingSorters = map[string]func(a, b BindingEntry) bool{
+	"api_key":    func(a, b BindingEntry) bool { return a.APIKey < b.APIKey },
+	"last_seen":  func(a, b BindingEntry) bool { return a.Binding.LastSeen.Before(b.Binding.LastSeen) },
+	"first_seen": func(a, b BindingEntry) bool { return a.Binding.FirstSeen.Before(b.Binding.FirstSeen) },
+	"devices":    func(a, b BindingEntry) bool { return len(a.Binding.Devices) < len(b.Binding.Devices) },
+	"strikes":    func(a, b BindingEntry) bool { return a.Binding.Strikes < b.Binding.Strikes },
+}
+
+// List returns one page of bindings matching opts and the total number of matches
+func List(store Store, opts ListOptions) ([]BindingEntry, int) {
+	entries := make([]BindingEntry, 0)
+	for apiKey, binding := range store.GetAll() {
+		if opts.matches(binding) {
+			entries = append(entries, BindingEntry{APIKey: apiKey, Binding: binding})
+		}
+	}
+
+	field, desc := strings.CutPrefix(opts.Sort, "-")
+	less, ok := bindingSorters[field]
+	if !ok {
+		less = bindingSorters["api_key"]
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if desc {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		// Ties fall back to the key so pages are stable
+		return entries[i].APIKey < entries[j].APIKey
+	})
+
+	total := len(entries)
+	page, limit := opts.Page, opts.Limit
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	start := (page - 1) * limit
+	if start >= total {
+		return []BindingEntry{}, total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+	return entries[start:end], total
+}
+
+func (o ListOptions) matches(binding DeviceBinding) bool {
+	if o.Banned != nil && binding.Banned != *o.Banned {
+		return false
+	}
+	if !o.LastSeenBefore.IsZero() && !binding.LastSeen.Before(o.LastSeenBefore) {
+		return false
+	}
+	if !o.LastSeenAfter.IsZero() && !binding.LastSeen.After(o.LastSeenAfter) {
+		return false
+	}
+	if o.Type != "" {
+		for _, dev := range binding.Devices {
+			if dev.Type == o.Type {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// isListRequest reports whether the request asks for a paginated listing
+func isListRequest(c *gin.Context) bool {
+	for _, name := range listParams {
+		if _, ok := c.GetQuery(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseListOptions reads ListOptions from the query string, returning an error message on invalid input
+func parseListOptions(c *gin.Context) (ListOptions, string) {
+	opts := ListOptions{Page: 1, Limit: defaultListLimit}
+	for name, target := range map[string]*int{"page": &opts.Page, "limit": &opts.Limit} {
+		raw := strings.TrimSpace(c.Query(name))
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 {
+			return opts, name + " must be a positive integer"
+		}
+		*target = value
+	}
+	if opts.Limit > maxListLimit {
+		opts.Limit = maxListLimit
+	}
+	if raw := strings.TrimSpace(c.Query("banned")); raw != "" {
+		banned, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, "banned must be true or false"
+		}
+		opts.Banned = &banned
+	}
+	if raw := strings.TrimSpace(c.Query("type")); raw != "" {
+		if raw != "ip" && raw != "client_id" {
+			return opts, "type must be ip or client_id"
+		}
+		opts.Type = raw
+	}
+	for name, target := range map[string]*time.Time{"last_seen_before": &opts.LastSeenBefore, "last_seen_after": &opts.LastSeenAfter} {
+		raw := strings.TrimSpace(c.Query(name))
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return opts, name + " must be an RFC3339 timestamp"
+		}
+		*target = parsed
+	}
+	if raw := strings.TrimSpace(c.Query("sort")); raw != "" {
+		if _, ok := bindingSorters[strings.TrimPrefix(raw, "-")]; !ok {
+			return opts, "sort must be one of api_key, last_seen, first_seen, devices, strikes (prefix with - for descending)"
+		}
+		opts.Sort = raw
+	}
+	return opts, ""
+}
+
+// listBindings serves the paginated form of GET /device-bindings
+func (h *Handler) listBindings(c *gin.Context) {
+	opts, msg := parseListOptions(c)
+	if msg != "" {
+		c.JSON(400, gin.H{
+			"error":   "invalid_parameter",
+			"message": msg,
+		})
+		return
+	}
+	entries, total := List(h.store, opts)
+	c.JSON(200, gin.H{
+		"bindings": entries,
+		"page":     opts.Page,
+		"limit":    opts.Limit,
+		"total":    total,
+		"pages":    (total + opts.Limit - 1) / opts.Limit,
+	})
+}
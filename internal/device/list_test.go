@@ -0,0 +1,73 @@
+package device
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestListFiltersSortsAndPaginates(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	_, _ = store.AddDeviceWithinLimit("key-a", "203.0.113.1", "ip", "203.0.113.1", false, 10)
+	_, _ = store.AddDeviceWithinLimit("key-b", "laptop", "client_id", "203.0.113.2", false, 10)
+	_, _ = store.AddDeviceWithinLimit("key-c", "203.0.113.3", "ip", "203.0.113.3", false, 10)
+	_ = store.Ban("key-c", "abuse", 0)
+
+	entries, total := List(store, ListOptions{Type: "ip", Sort: "-last_seen"})
+	if total != 2 || entries[0].APIKey != "key-c" || entries[1].APIKey != "key-a" {
+		t.Fatalf("unexpected ip listing %+v (total %d)", entries, total)
+	}
+
+	banned := false
+	entries, total = List(store, ListOptions{Banned: &banned, Page: 2, Limit: 1})
+	if total != 2 || len(entries) != 1 || entries[0].APIKey != "key-b" {
+		t.Fatalf("unexpected second page %+v (total %d)", entries, total)
+	}
+
+	if entries, total = List(store, ListOptions{LastSeenBefore: time.Now().Add(-time.Hour)}); total != 0 || len(entries) != 0 {
+		t.Fatalf("expected no stale keys, got %+v", entries)
+	}
+}
+
+func TestGetBindingsPaginatedResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	for _, key := range []string{"key-a", "key-b", "key-c"} {
+		_, _ = store.AddDeviceWithinLimit(key, key+"-device", "client_id", "203.0.113.1", false, 10)
+	}
+	engine := gin.New()
+	NewHandler(store).RegisterRoutes(engine.Group("/"))
+
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device-bindings?limit=2&sort=-api_key", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Bindings []BindingEntry `json:"bindings"`
+		Total    int            `json:"total"`
+		Pages    int            `json:"pages"`
+	}
+	if err = json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Total != 3 || body.Pages != 2 || len(body.Bindings) != 2 || body.Bindings[0].APIKey != "key-c" {
+		t.Fatalf("unexpected page %+v", body)
+	}
+
+	rec = httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device-bindings?sort=nope", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid sort, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,102 @@
+package device
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+)
+
+// Decision labels for device binding outcomes
+const (
+	decisionAllowed       = "allowed"
+	decisionRegistered    = "registered"
+	decisionBanned        = "rejected_banned"
+	decisionDeviceBanned  = "rejected_device_banned"
+	decisionDeviceLimit   = "rejected_device_limit"
+	decisionPending       = "rejected_pending"
+	decisionUnattested    = "rejected_unattested"
+	decisionConcurrentBan = "rejected_concurrent"
+	// decisionFallback counts checks made against a cached binding while the store is down;
+	// decisionDegraded requests served unchecked and decisionUnavailable rejected with 503.
+	decisionFallback    = "fallback_cache"
+	decisionDegraded    = "allowed_degraded"
+	decisionUnavailable = "rejected_store_unavailable"
+)
+
+var (
+	bindingDecisions = metrics.Default().NewCounterVec(
+		"cliproxy_device_binding_decisions_total",
+		"Device binding decisions for API requests by outcome.",
+		"decision",
+	)
+	bansIssued = metrics.Default().NewCounterVec(
+		"cliproxy_device_bans_total",
+		"API key bans issued, by ban kind (temporary or permanent).",
+		"kind",
+	)
+	concurrentDetections = metrics.Default().NewCounterVec(
+		"cliproxy_device_concurrent_detections_total",
+		"Concurrent-usage detections by resulting action (warn, score_warn, ban, grace, geo or carrier).",
+		"action",
+	)
+	anomalyScores = metrics.Default().NewHistogramVec(
+		"cliproxy_device_anomaly_score",
+		"Anomaly scores of requests with at least one suspicious signal.",
+		[]float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 120, 150},
+	)
+	tlsFingerprintDecisions = metrics.Default().NewCounterVec(
+		"cliproxy_device_tls_fingerprint_decisions_total",
+		"TLS fingerprint pinning outcomes (pinned, mismatch_recorded or rejected).",
+		"decision",
+	)
+	registrations = metrics.Default().NewCounterVec(
+		"cliproxy_device_registrations_total",
+		"New device registrations by state (active or pending).",
+		"state",
+	)
+	staleBindingsRemoved = metrics.Default().NewCounterVec(
+		"cliproxy_device_stale_bindings_removed_total",
+		"Bindings removed by the stale binding janitor, by action (deleted or archived).",
+		"action",
+	)
+	activeDevices = metrics.Default().NewGaugeVec(
+		"cliproxy_device_active_devices",
+		"Approved devices bound to each API key (keys are masked).",
+		"api_key",
+	)
+
+	// metricsStore is the store the active devices gauge is computed from.
+	metricsStore atomic.Pointer[Store]
+)
+
+func init() {
+	metrics.Default().OnGather(refreshActiveDevices)
+}
+
+// refreshActiveDevices rebuilds the per-key active devices gauge from the store
+func refreshActiveDevices() {
+	store := metricsStore.Load()
+	if store == nil {
+		return
+	}
+	activeDevices.Reset()
+	for apiKey, binding := range (*store).GetAll() {
+		count := 0
+		for _, dev := range binding.Devices {
+			if !dev.Pending {
+				count++
+			}
+		}
+		activeDevices.Set(float64(count), MaskKey(apiKey))
+	}
+}
+
+// recordBan counts a ban issued against an existing binding
+func recordBan(duration time.Duration) {
+	kind := "permanent"
+	if duration > 0 {
+		kind = "temporary"
+	}
+	bansIssued.Inc(kind)
+}
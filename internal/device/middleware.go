@@ -1,13 +1,28 @@
 package device
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/degraded"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
 	log "github.com/sirupsen/logrus"
 )
 
+// MetadataContextKey is the gin context key holding the API key's metadata map
+const MetadataContextKey = "apiKeyMetadata"
+
+// DeviceIDContextKey is the gin context key holding the requesting device's ID
+const DeviceIDContextKey = "deviceID"
+
 // Default threshold for concurrent usage detection (60 seconds)
 const defaultConcurrentThreshold = 60 * time.Second
 
@@ -17,16 +32,95 @@ type Config struct {
 	MaxDevices          int
 	HeaderName          string
 	ConcurrentThreshold time.Duration // Time threshold for detecting concurrent usage from different IPs
+	BanDuration         time.Duration // How long automatic bans last; zero means permanent
+	// Escalation maps strike counts to actions: entry i applies to strike i+1 and
+	// the last entry applies to all further strikes. Empty means every strike bans for BanDuration.
+	Escalation []EscalationStep
+	// StrikeResetAfter clears accumulated strikes after this long without a new violation; zero never resets.
+	StrikeResetAfter time.Duration
+	// GracePeriod is how long after a key is first seen concurrent usage is only logged, never
+	// punished, so new users can set up their machines. Zero disables the grace window.
+	GracePeriod time.Duration
+	// TrustedCIDRs are networks (e.g. corporate NAT egress ranges) inside which IP changes
+	// never count as concurrent usage.
+	TrustedCIDRs []string
+	// TrustedCIDRsByKey adds per-API-key trusted networks on top of TrustedCIDRs.
+	TrustedCIDRsByKey map[string][]string
+	// RequireApproval registers new devices as pending; they are rejected until an admin approves them.
+	RequireApproval bool
+	// TokenSecret enables signed device tokens: devices register via RegisterDevice and must present
+	// the issued token in TokenHeader instead of a self-declared device ID.
+	TokenSecret string
+	TokenHeader string
+	// GeoIPDatabase is the path of a MaxMind GeoIP2/GeoLite2 City database used to
+	// tell plausible moves from concurrent usage in different places.
+	GeoIPDatabase string
+	// MinDistanceKm ignores IP changes between locations closer than this (e.g. Wi-Fi to LTE
+	// in the same city). Zero disables the distance check.
+	MinDistanceKm float64
+	// MaxTravelSpeedKmh ignores IP changes whose implied travel speed is at most this
+	// ("impossible travel" detection). Zero disables the speed check.
+	MaxTravelSpeedKmh float64
+	// ASNDatabase is the path of a MaxMind GeoLite2 ASN database used for carrier exemptions.
+	ASNDatabase string
+	// ExemptASNs lists autonomous systems (e.g. mobile carriers) inside which IP changes
+	// never count as concurrent usage. Requires ASNDatabase.
+	ExemptASNs []uint
+	// DetectCGNAT exempts IP changes inside the RFC 6598 carrier-grade NAT range and, with
+	// ASNDatabase, within one ASN whose organisation looks like a mobile carrier.
+	DetectCGNAT bool
+	// MaxStreamingDevices is how many devices of one key may have requests (including open
+	// streams) in flight at the same time. A request that exceeds it counts as concurrent
+	// usage right away, whatever the IP or LastSeen timing. Zero disables the check.
+	MaxStreamingDevices int
+	// ClientCertIdentity identifies devices by the fingerprint of their verified TLS client
+	// certificate. Clients without one fall back to the token, header or IP identity.
+	ClientCertIdentity bool
+	// TLSFingerprintMode is the global TLS fingerprint pinning mode: off (default), record or enforce.
+	TLSFingerprintMode string
+	// TLSFingerprint returns the client TLS fingerprint of a request, or "" when unknown.
+	TLSFingerprint func(*http.Request) string
+	// Attestation configures App Attest and Play Integrity verification at token registration.
+	Attestation AttestationConfig
+	// Activity, when set, records every request of a known device ID for forensic review.
+	Activity *ActivityLog
+	// FailClosed rejects requests with 503 while the store cannot be read or a new device
+	// cannot be saved; by default they are served. Bindings read within FallbackTTL are
+	// enforced from a local cache in both modes.
+	FailClosed bool
+	// FallbackTTL is how long the last binding read for a key stands in for the store
+	// while it is unavailable. Default: 5 minutes.
+	FallbackTTL time.Duration
+	// Anomaly scores IP, user agent, device header, TLS fingerprint and cadence changes
+	// together instead of banning on an IP change alone.
+	Anomaly AnomalyConfig
+}
+
+// EscalationStep is the action taken for a given concurrent-usage strike
+type EscalationStep struct {
+	Warn     bool          // Only warn, do not ban
+	Duration time.Duration // Ban duration when not warning; zero means permanent
 }
 
 // Middleware checks device bindings for API requests
 type Middleware struct {
-	store  *Store
+	store  Store
 	config Config
+
+	trusted      []*net.IPNet
+	trustedByKey map[string][]*net.IPNet
+	signer       *TokenSigner
+	geo          GeoLocator
+	asn          ASNResolver
+	exemptASNs   map[uint]struct{}
+	streams      *streamRegistry
+	attestation  *attestationVerifier
+	fallback     *bindingCache
+	anomaly      *anomalyScorer
 }
 
 // NewMiddleware creates a new device binding middleware
-func NewMiddleware(store *Store, config Config) *Middleware {
+func NewMiddleware(store Store, config Config) *Middleware {
 	// Apply defaults
 	if config.MaxDevices <= 0 {
 		config.MaxDevices = 1
@@ -37,11 +131,76 @@ func NewMiddleware(store *Store, config Config) *Middleware {
 	if config.ConcurrentThreshold <= 0 {
 		config.ConcurrentThreshold = defaultConcurrentThreshold
 	}
+	if config.TokenHeader == "" {
+		config.TokenHeader = defaultTokenHeader
+	}
+	if !ValidTLSFingerprintMode(config.TLSFingerprintMode) || config.TLSFingerprintMode == "" {
+		config.TLSFingerprintMode = TLSFingerprintOff
+	}
+
+	trustedByKey := make(map[string][]*net.IPNet, len(config.TrustedCIDRsByKey))
+	for key, cidrs := range config.TrustedCIDRsByKey {
+		trustedByKey[key] = ParseCIDRs(cidrs)
+	}
+
+	var geo GeoLocator
+	if path := strings.TrimSpace(config.GeoIPDatabase); path != "" {
+		locator, err := OpenGeoIP(path)
+		if err != nil {
+			log.Warnf("device-binding: geoip disabled: %v", err)
+		} else {
+			geo = locator
+		}
+	}
+
+	var asn ASNResolver
+	if path := strings.TrimSpace(config.ASNDatabase); path != "" {
+		db, err := OpenASN(path)
+		if err != nil {
+			log.Warnf("device-binding: asn exemptions disabled: %v", err)
+		} else {
+			asn = db
+		}
+	}
+	exemptASNs := make(map[uint]struct{}, len(config.ExemptASNs))
+	for _, number := range config.ExemptASNs {
+		exemptASNs[number] = struct{}{}
+	}
+
+	metricsStore.Store(&store)
 
 	return &Middleware{
-		store:  store,
-		config: config,
+		store:        store,
+		config:       config,
+		trusted:      ParseCIDRs(config.TrustedCIDRs),
+		trustedByKey: trustedByKey,
+		signer:       NewTokenSigner(config.TokenSecret),
+		geo:          geo,
+		asn:          asn,
+		exemptASNs:   exemptASNs,
+		streams:      newStreamRegistry(),
+		attestation:  newAttestationVerifier(config.Attestation),
+		fallback:     newBindingCache(config.FallbackTTL),
+		anomaly:      newAnomalyScorer(config.Anomaly),
+	}
+}
+
+// Close releases the GeoIP and ASN databases, if any
+func (m *Middleware) Close() error {
+	var errs []error
+	if m.geo != nil {
+		errs = append(errs, m.geo.Close())
+	}
+	if m.asn != nil {
+		errs = append(errs, m.asn.Close())
 	}
+	return errors.Join(errs...)
+}
+
+// trustedIPChange reports whether an IP change for the key stays inside a trusted network
+func (m *Middleware) trustedIPChange(apiKey, previousIP, currentIP string) bool {
+	return sameTrustedNetwork(m.trusted, previousIP, currentIP) ||
+		sameTrustedNetwork(m.trustedByKey[apiKey], previousIP, currentIP)
 }
 
 // Handler returns the Gin middleware handler
@@ -65,27 +224,16 @@ func (m *Middleware) Handler() gin.HandlerFunc {
 		// Extract device ID
 		deviceID, deviceType := m.extractDeviceID(c)
 
-		// Log all headers for debugging
-		var headers []string
-		for key, values := range c.Request.Header {
-			for _, v := range values {
-				// Mask Authorization header value
-				if strings.EqualFold(key, "Authorization") {
-					v = "***masked***"
-				}
-				headers = append(headers, key+"="+v)
-			}
+		log.Debugf("device-binding: request from key=%s, device_id=%s, type=%s, client_ip=%s",
+			MaskKey(apiKey), deviceID, deviceType, c.ClientIP())
+
+		if m.signer != nil && deviceID == "" {
+			c.AbortWithStatusJSON(401, gin.H{
+				"error":   "device_token_required",
+				"message": "A valid device token is required in the " + m.config.TokenHeader + " header. Register this device via POST /v0/device/register.",
+			})
+			return
 		}
-		log.Debugf("device-binding: incoming request - key=%s, device_id=%s, type=%s, client_ip=%s, remote_addr=%s, method=%s, path=%s, headers=[%s]",
-			MaskKey(apiKey),
-			deviceID,
-			deviceType,
-			c.ClientIP(),
-			c.Request.RemoteAddr,
-			c.Request.Method,
-			c.Request.URL.Path,
-			strings.Join(headers, ", "),
-		)
 
 		if deviceID == "" {
 			// Shouldn't happen, but fallback to allowing
@@ -94,68 +242,413 @@ func (m *Middleware) Handler() gin.HandlerFunc {
 			return
 		}
 
+		c.Set(DeviceIDContextKey, deviceID)
+
 		// Check existing binding
-		binding, exists := m.store.Get(apiKey)
+		binding, exists, available := m.lookupBinding(apiKey)
 		currentIP := c.ClientIP()
+		endpoint := c.FullPath()
+		if endpoint == "" {
+			endpoint = c.Request.URL.Path
+		}
+		// Rejected requests are recorded too: they are often the most telling part of a timeline.
+		m.config.Activity.Record(apiKey, deviceID, currentIP, endpoint)
+		if !available {
+			if m.storeFailed(c, apiKey) {
+				return
+			}
+			bindingDecisions.Inc(decisionDegraded)
+			c.Next()
+			return
+		}
+		if len(binding.Metadata) > 0 {
+			// Expose key metadata to routing and policy decisions further down the chain
+			c.Set(MetadataContextKey, binding.Metadata)
+		}
+
+		// Certificate devices register on first use: POST /v0/device/register cannot issue a
+		// token for a fingerprint the client does not present.
+		if m.signer != nil && deviceType != "client_cert" && (!exists || binding.FindDevice(deviceID) < 0) {
+			// Token verified but the device was removed by an admin
+			c.AbortWithStatusJSON(403, gin.H{
+				"error":   "device_not_registered",
+				"message": "This device is no longer registered. Register it again via POST /v0/device/register.",
+			})
+			return
+		}
 
 		if !exists {
-			// First use: auto-register device
-			if err := m.store.Save(apiKey, deviceID, deviceType); err != nil {
+			policy := m.effectivePolicy(nil)
+			if policy.RequireAttestation {
+				abortUnattested(c, apiKey, deviceID)
+				return
+			}
+			if m.config.RequireApproval {
+				m.registerPending(c, apiKey, deviceID, deviceType, currentIP, policy.MaxDevices)
+				return
+			}
+			// First use: auto-register device. Another device may be registering
+			// the key at the same time, so the store enforces the limit.
+			if added, err := m.store.AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP, false, policy.MaxDevices); err != nil {
 				log.Errorf("device-binding: failed to save binding for key %s: %v", MaskKey(apiKey), err)
-				// Allow request to proceed even if save failed
+				degraded.Default().Report(degraded.ComponentDeviceStore, err)
+				if m.storeFailed(c, apiKey) {
+					return
+				}
+			} else if !added {
+				abortDeviceLimit(c, apiKey, deviceID, deviceType, currentIP, policy.MaxDevices)
+				return
 			} else {
 				log.Infof("device-binding: new device registered for key %s: %s (%s)",
 					MaskKey(apiKey), deviceID, deviceType)
+				registrations.Inc("active")
+				events.Publish(events.Event{Type: events.TypeDeviceRegistered, APIKey: apiKey, DeviceID: deviceID, IP: currentIP, Actor: "system"})
+				binding, _ = m.store.Get(apiKey)
+				m.fallback.put(apiKey, binding, true, time.Now())
+				m.checkTLSFingerprint(c, apiKey, binding, m.effectivePolicy(nil))
 			}
+			bindingDecisions.Inc(decisionRegistered)
+			_, release := m.streams.open(apiKey, deviceID)
+			defer release()
 			c.Next()
 			return
 		}
 
+		// Lift temporary bans that have expired
+		if binding.BanExpired(time.Now()) {
+			if err := m.store.Unban(apiKey, "system"); err != nil {
+				log.Errorf("device-binding: failed to lift expired ban for key %s: %v", MaskKey(apiKey), err)
+			} else {
+				log.Infof("device-binding: temporary ban expired for key %s", MaskKey(apiKey))
+				events.Publish(events.Event{Type: events.TypeUnban, APIKey: apiKey, Reason: binding.BanReason, Actor: "system"})
+				binding.Banned = false
+			}
+		}
+
 		// Check if banned
 		if binding.Banned {
 			log.Warnf("device-binding: rejected banned key %s, reason: %s",
 				MaskKey(apiKey), binding.BanReason)
-			c.AbortWithStatusJSON(403, gin.H{
+			bindingDecisions.Inc(decisionBanned)
+			body := gin.H{
 				"error":   "api_key_banned",
 				"message": "This API key has been banned: " + binding.BanReason,
-			})
+			}
+			if !binding.BanExpiresAt.IsZero() {
+				body["ban_expires_at"] = binding.BanExpiresAt
+				c.Header("Retry-After", strconv.Itoa(int(time.Until(binding.BanExpiresAt).Seconds())+1))
+			}
+			c.AbortWithStatusJSON(403, body)
+			return
+		}
+		if !checkDeviceBan(c, apiKey, deviceID, currentIP, binding) {
 			return
 		}
 
-		// Check for concurrent usage from different IPs
-		timeSinceLastSeen := time.Since(binding.LastSeen)
-		if binding.LastIP != "" && binding.LastIP != currentIP && timeSinceLastSeen < m.config.ConcurrentThreshold {
-			// Different IP within short time = suspicious concurrent usage
-			reason := "Concurrent usage detected: different IP within " + timeSinceLastSeen.String()
-			log.Warnf("device-binding: BANNED key %s - %s (last_ip=%s, current_ip=%s, last_seen=%s ago)",
-				MaskKey(apiKey), reason, binding.LastIP, currentIP, timeSinceLastSeen)
-
-			if err := m.store.Ban(apiKey, reason); err != nil {
-				log.Errorf("device-binding: failed to ban key %s: %v", MaskKey(apiKey), err)
+		policy := m.effectivePolicy(binding.Policy)
+		if !m.checkTLSFingerprint(c, apiKey, binding, policy) {
+			return
+		}
+		idx := binding.FindDevice(deviceID)
+		if idx < 0 {
+			// Unknown device: register it if the key still has free slots
+			if len(binding.Devices) >= policy.MaxDevices {
+				abortDeviceLimit(c, apiKey, deviceID, deviceType, currentIP, policy.MaxDevices)
+				return
+			}
+			if policy.RequireAttestation {
+				abortUnattested(c, apiKey, deviceID)
+				return
 			}
+			if m.config.RequireApproval {
+				m.registerPending(c, apiKey, deviceID, deviceType, currentIP, policy.MaxDevices)
+				return
+			}
+			// The limit is checked again by the store as part of the write, so
+			// concurrent first requests of new devices cannot exceed it.
+			if added, err := m.store.AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP, false, policy.MaxDevices); err != nil {
+				log.Errorf("device-binding: failed to save device for key %s: %v", MaskKey(apiKey), err)
+				degraded.Default().Report(degraded.ComponentDeviceStore, err)
+				if m.storeFailed(c, apiKey) {
+					return
+				}
+			} else if !added {
+				abortDeviceLimit(c, apiKey, deviceID, deviceType, currentIP, policy.MaxDevices)
+				return
+			} else {
+				log.Infof("device-binding: new device registered for key %s: %s (%s), %d/%d devices",
+					MaskKey(apiKey), deviceID, deviceType, len(binding.Devices)+1, policy.MaxDevices)
+				if updated, ok := m.store.Get(apiKey); ok {
+					m.fallback.put(apiKey, updated, true, time.Now())
+				}
+				registrations.Inc("active")
+				events.Publish(events.Event{Type: events.TypeDeviceRegistered, APIKey: apiKey, DeviceID: deviceID, IP: currentIP, Actor: "system"})
+			}
+			bindingDecisions.Inc(decisionRegistered)
+			_, release := m.streams.open(apiKey, deviceID)
+			defer release()
+			c.Next()
+			return
+		}
 
-			c.AbortWithStatusJSON(403, gin.H{
-				"error":   "concurrent_usage_detected",
-				"message": "Suspicious concurrent usage detected. API key has been banned. Contact admin to unban.",
-			})
+		dev := binding.Devices[idx]
+		if dev.Pending {
+			abortPending(c, deviceID)
 			return
 		}
+		if policy.RequireAttestation && !dev.Attestation.Trusted() {
+			abortUnattested(c, apiKey, deviceID)
+			return
+		}
+
+		// Check for concurrent usage of the same device from different IPs
+		ipChanged := false
+		timeSinceLastSeen := time.Since(dev.LastSeen)
+		if policy.DetectConcurrent && dev.LastIP != "" && dev.LastIP != currentIP && timeSinceLastSeen < policy.ConcurrentThreshold &&
+			!m.trustedIPChange(apiKey, dev.LastIP, currentIP) {
+			if carrier, why := m.carrierIPChange(dev.LastIP, currentIP); carrier {
+				concurrentDetections.Inc("carrier")
+				log.Debugf("device-binding: IP change for key %s is carrier IP churn (device=%s, last_ip=%s, current_ip=%s, %s)",
+					MaskKey(apiKey), dev.DeviceID, dev.LastIP, currentIP, why)
+			} else if plausible, distance := m.plausibleTravel(dev.LastIP, currentIP, timeSinceLastSeen); plausible {
+				concurrentDetections.Inc("geo")
+				log.Debugf("device-binding: IP change for key %s is plausible travel (device=%s, last_ip=%s, current_ip=%s, distance=%.0fkm, elapsed=%s)",
+					MaskKey(apiKey), dev.DeviceID, dev.LastIP, currentIP, distance, timeSinceLastSeen)
+			} else if m.inGracePeriod(binding) {
+				concurrentDetections.Inc("grace")
+				log.Infof("device-binding: ignoring concurrent usage for key %s during grace period (device=%s, last_ip=%s, current_ip=%s, first_seen=%s)",
+					MaskKey(apiKey), dev.DeviceID, dev.LastIP, currentIP, binding.FirstSeen.Format(time.RFC3339))
+			} else if m.anomaly != nil {
+				// Scored together with the other signals below
+				ipChanged = true
+			} else if m.handleConcurrentUsage(c, apiKey, policy, dev, currentIP, "Concurrent usage detected: different IP within "+timeSinceLastSeen.String(), nil) {
+				// Different IP within short time = suspicious concurrent usage
+				return
+			}
+		}
+		if policy.DetectConcurrent && m.anomaly != nil && m.scoreRequest(c, apiKey, binding, policy, dev, currentIP, ipChanged) {
+			return
+		}
+
+		// Check for overlapping requests from other devices of the key
+		others, release := m.streams.open(apiKey, deviceID)
+		defer release()
+		if policy.DetectConcurrent && m.config.MaxStreamingDevices > 0 && len(others)+1 > m.config.MaxStreamingDevices {
+			if m.inGracePeriod(binding) {
+				concurrentDetections.Inc("grace")
+				log.Infof("device-binding: ignoring overlapping requests for key %s during grace period (device=%s, other_devices=%s)",
+					MaskKey(apiKey), deviceID, strings.Join(others, ","))
+			} else if m.handleConcurrentUsage(c, apiKey, policy, dev, currentIP,
+				"Concurrent usage detected: overlapping requests from devices "+strings.Join(append([]string{deviceID}, others...), ", "),
+				map[string]any{"overlapping_devices": others}) {
+				release()
+				return
+			}
+		}
 
 		// Update last seen with current IP (allow IP changes over time)
-		if err := m.store.UpdateLastSeen(apiKey, currentIP); err != nil {
+		if err := m.store.UpdateLastSeen(apiKey, deviceID, currentIP); err != nil {
 			log.Warnf("device-binding: failed to update last_seen for key %s: %v", MaskKey(apiKey), err)
 		}
 
+		bindingDecisions.Inc(decisionAllowed)
 		c.Next()
 	}
 }
 
+// scoreRequest scores a request of a known device against the device's recent
+// requests, warning or recording a strike once the score reaches the configured
+// thresholds. It returns true when the request was rejected.
+func (m *Middleware) scoreRequest(c *gin.Context, apiKey string, binding DeviceBinding, policy EffectivePolicy, dev Device, currentIP string, ipChanged bool) bool {
+	traits := requestTraits{
+		userAgent:    c.GetHeader("User-Agent"),
+		deviceHeader: strings.TrimSpace(c.GetHeader(m.config.HeaderName)),
+		at:           time.Now(),
+	}
+	if m.config.TLSFingerprint != nil {
+		traits.tls = m.config.TLSFingerprint(c.Request)
+	}
+	result := m.anomaly.score(apiKey, dev.DeviceID, traits, ipChanged, policy.ConcurrentThreshold)
+	if result.Score == 0 {
+		return false
+	}
+	anomalyScores.Observe(float64(result.Score))
+	signals := strings.Join(result.Signals, ",")
+	switch {
+	case result.Score < m.anomaly.cfg.WarnScore:
+		return false
+	case m.inGracePeriod(binding):
+		concurrentDetections.Inc("grace")
+		log.Infof("device-binding: ignoring suspicious requests for key %s during grace period (device=%s, score=%d, signals=%s)",
+			MaskKey(apiKey), dev.DeviceID, result.Score, signals)
+		return false
+	case result.Score < m.anomaly.cfg.BanScore:
+		concurrentDetections.Inc("score_warn")
+		log.Warnf("device-binding: WARNING key %s - suspicious request pattern (device=%s, score=%d, signals=%s, last_ip=%s, current_ip=%s)",
+			MaskKey(apiKey), dev.DeviceID, result.Score, signals, dev.LastIP, currentIP)
+		c.Header("X-Device-Warning", fmt.Sprintf("suspicious activity detected (score %d: %s)", result.Score, signals))
+		return false
+	}
+	return m.handleConcurrentUsage(c, apiKey, policy, dev, currentIP,
+		fmt.Sprintf("Suspicious request pattern detected: score %d (%s)", result.Score, signals),
+		map[string]any{"anomaly_score": result.Score, "anomaly_signals": result.Signals})
+}
+
+// inGracePeriod reports whether the key was first seen recently enough that
+// concurrent usage should only be recorded
+func (m *Middleware) inGracePeriod(binding DeviceBinding) bool {
+	return m.config.GracePeriod > 0 && !binding.FirstSeen.IsZero() && time.Since(binding.FirstSeen) < m.config.GracePeriod
+}
+
+// registerPending stores a new device awaiting approval and rejects the request
+func (m *Middleware) registerPending(c *gin.Context, apiKey, deviceID, deviceType, currentIP string, maxDevices int) {
+	if added, err := m.store.AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP, true, maxDevices); err != nil {
+		log.Errorf("device-binding: failed to save pending device for key %s: %v", MaskKey(apiKey), err)
+	} else if !added {
+		abortDeviceLimit(c, apiKey, deviceID, deviceType, currentIP, maxDevices)
+		return
+	} else {
+		log.Infof("device-binding: new device awaiting approval for key %s: %s (%s)",
+			MaskKey(apiKey), deviceID, deviceType)
+		registrations.Inc("pending")
+		events.Publish(events.Event{Type: events.TypeDevicePending, APIKey: apiKey, DeviceID: deviceID, IP: currentIP, Actor: "system"})
+	}
+	abortPending(c, deviceID)
+}
+
+// abortDeviceLimit rejects a new device of a key without free device slots
+func abortDeviceLimit(c *gin.Context, apiKey, deviceID, deviceType, currentIP string, maxDevices int) {
+	log.Warnf("device-binding: rejected new device for key %s: %s (%s), limit of %d devices reached",
+		MaskKey(apiKey), deviceID, deviceType, maxDevices)
+	bindingDecisions.Inc(decisionDeviceLimit)
+	events.Publish(events.Event{Type: events.TypeDeviceRejected, APIKey: apiKey, DeviceID: deviceID, IP: currentIP, Actor: "system", Reason: "device limit reached"})
+	c.AbortWithStatusJSON(403, gin.H{
+		"error":       "device_limit_exceeded",
+		"message":     fmt.Sprintf("This API key is already bound to the maximum of %d device(s). Contact admin to remove an existing device.", maxDevices),
+		"max_devices": maxDevices,
+	})
+}
+
+// abortPending rejects a request from a device that has not been approved yet
+func abortPending(c *gin.Context, deviceID string) {
+	bindingDecisions.Inc(decisionPending)
+	c.AbortWithStatusJSON(403, gin.H{
+		"error":     "device_pending_approval",
+		"message":   "This device is awaiting admin approval. Contact admin to approve it.",
+		"device_id": deviceID,
+	})
+}
+
+// handleConcurrentUsage records a strike for a concurrent-usage detection and
+// applies the matching escalation step. Extra is added to the ban event data.
+// It returns true when the request was rejected.
+func (m *Middleware) handleConcurrentUsage(c *gin.Context, apiKey string, policy EffectivePolicy, dev Device, currentIP, reason string, extra map[string]any) bool {
+	strikes, err := m.store.AddStrike(apiKey, m.config.StrikeResetAfter)
+	if err != nil {
+		log.Errorf("device-binding: failed to record strike for key %s: %v", MaskKey(apiKey), err)
+	}
+	step := m.escalationStep(policy, strikes)
+
+	if step.Warn {
+		concurrentDetections.Inc("warn")
+		log.Warnf("device-binding: WARNING key %s - %s (strike %d, device=%s, last_ip=%s, current_ip=%s)",
+			MaskKey(apiKey), reason, strikes, dev.DeviceID, dev.LastIP, currentIP)
+		c.Header("X-Device-Warning", fmt.Sprintf("concurrent usage detected (strike %d); further violations will ban this key", strikes))
+		return false
+	}
+
+	concurrentDetections.Inc("ban")
+	bindingDecisions.Inc(decisionConcurrentBan)
+	log.Warnf("device-binding: BANNED key %s - %s (strike %d, duration=%s, device=%s, last_ip=%s, current_ip=%s, last_seen=%s ago)",
+		MaskKey(apiKey), reason, strikes, step.Duration, dev.DeviceID, dev.LastIP, currentIP, time.Since(dev.LastSeen).Round(time.Second))
+
+	if err = m.store.Ban(apiKey, reason, step.Duration, dev.LastIP, currentIP); err != nil {
+		log.Errorf("device-binding: failed to ban key %s: %v", MaskKey(apiKey), err)
+	} else {
+		data := map[string]any{"previous_ip": dev.LastIP, "strikes": strikes, "duration_seconds": int(step.Duration.Seconds())}
+		for k, v := range extra {
+			data[k] = v
+		}
+		events.Publish(events.Event{
+			Type:     events.TypeBan,
+			APIKey:   apiKey,
+			DeviceID: dev.DeviceID,
+			IP:       currentIP,
+			Reason:   reason,
+			Actor:    "system",
+			Data:     data,
+		})
+	}
+
+	message := "Suspicious concurrent usage detected. API key has been banned. Contact admin to unban."
+	if step.Duration > 0 {
+		message = "Suspicious concurrent usage detected. API key has been temporarily banned for " + step.Duration.String() + "."
+	}
+	c.AbortWithStatusJSON(403, gin.H{
+		"error":   "concurrent_usage_detected",
+		"message": message,
+		"strikes": strikes,
+	})
+	return true
+}
+
+// escalationStep returns the action for the given strike count. A per-key
+// concurrent action takes precedence; without a configured escalation policy
+// every strike bans for the effective ban duration.
+func (m *Middleware) escalationStep(policy EffectivePolicy, strikes int) EscalationStep {
+	switch policy.ConcurrentAction {
+	case ConcurrentActionWarn:
+		return EscalationStep{Warn: true}
+	case ConcurrentActionBan:
+		return EscalationStep{Duration: policy.BanDuration}
+	}
+	if len(m.config.Escalation) == 0 {
+		return EscalationStep{Duration: policy.BanDuration}
+	}
+	if strikes < 1 {
+		strikes = 1
+	}
+	if strikes > len(m.config.Escalation) {
+		strikes = len(m.config.Escalation)
+	}
+	return m.config.Escalation[strikes-1]
+}
+
+// ClientCertFingerprint returns "cert:" and the hex SHA-256 of the client certificate
+// of r, or "" when the connection carries no certificate verified against the client CAs.
+func ClientCertFingerprint(r *http.Request) string {
+	if r == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+	return certDevicePrefix + hex.EncodeToString(sum[:])
+}
+
+// certDevicePrefix starts the IDs of certificate devices. Clients cannot claim
+// such an ID themselves.
+const certDevicePrefix = "cert:"
+
 // extractDeviceID extracts device identifier from request
 func (m *Middleware) extractDeviceID(c *gin.Context) (deviceID string, deviceType string) {
+	// A verified client certificate cannot be copied like a header, so it wins over everything else
+	if m.config.ClientCertIdentity {
+		if id := ClientCertFingerprint(c.Request); id != "" {
+			return id, "client_cert"
+		}
+	}
+
+	// Signed tokens replace self-declared identifiers entirely
+	if m.signer != nil {
+		if id, ok := m.signer.Verify(c.GetString("apiKey"), c.GetHeader(m.config.TokenHeader)); ok {
+			return id, "token"
+		}
+		return "", ""
+	}
+
 	// Priority 1: Client-generated device ID from header
 	if id := c.GetHeader(m.config.HeaderName); id != "" {
 		trimmed := strings.TrimSpace(id)
-		if trimmed != "" {
+		if trimmed != "" && !strings.HasPrefix(trimmed, certDevicePrefix) {
 			return trimmed, "client_id"
 		}
 	}
@@ -0,0 +1,478 @@
+package device
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+)
+
+func newTestEngine(t *testing.T, cfg Config) (*gin.Engine, *FileStore) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	cfg.Enabled = true
+	mw := NewMiddleware(store, cfg)
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("X-Test-Key"))
+		c.Next()
+	})
+	engine.Use(mw.Handler())
+	engine.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return engine, store
+}
+
+func doRequest(engine *gin.Engine, apiKey, deviceID, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Test-Key", apiKey)
+	if deviceID != "" {
+		req.Header.Set("X-Device-ID", deviceID)
+	}
+	req.RemoteAddr = ip + ":1234"
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddlewareRejectsDevicesBeyondLimit(t *testing.T) {
+	engine, store := newTestEngine(t, Config{MaxDevices: 2})
+
+	for _, dev := range []string{"dev-a", "dev-b"} {
+		if rec := doRequest(engine, "key-123456789", dev, "10.0.0.1"); rec.Code != http.StatusOK {
+			t.Fatalf("expected %s to be accepted, got %d", dev, rec.Code)
+		}
+	}
+	if rec := doRequest(engine, "key-123456789", "dev-c", "10.0.0.1"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected third device to be rejected, got %d", rec.Code)
+	}
+	binding, _ := store.Get("key-123456789")
+	if len(binding.Devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(binding.Devices))
+	}
+}
+
+func TestMiddlewareEscalatesStrikes(t *testing.T) {
+	engine, store := newTestEngine(t, Config{
+		ConcurrentThreshold: time.Minute,
+		Escalation: []EscalationStep{
+			{Warn: true},
+			{Duration: 15 * time.Minute},
+		},
+	})
+	key := "key-123456789"
+
+	doRequest(engine, key, "dev-a", "10.0.0.1")
+	rec := doRequest(engine, key, "dev-a", "10.0.0.2")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Device-Warning") == "" {
+		t.Fatalf("expected first strike to warn, got %d", rec.Code)
+	}
+
+	rec = doRequest(engine, key, "dev-a", "10.0.0.3")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected second strike to ban, got %d", rec.Code)
+	}
+	binding, _ := store.Get(key)
+	if !binding.Banned || binding.Strikes != 2 || binding.BanExpiresAt.IsZero() {
+		t.Fatalf("expected temporary ban after 2 strikes, got %+v", binding)
+	}
+}
+
+func TestMiddlewareIgnoresIPChangesInsideTrustedNetwork(t *testing.T) {
+	engine, store := newTestEngine(t, Config{
+		MaxDevices:          2,
+		ConcurrentThreshold: time.Minute,
+		TrustedCIDRs:        []string{"198.51.100.0/24"},
+		TrustedCIDRsByKey:   map[string][]string{"key-123456789": {"203.0.113.0/28"}},
+	})
+	key := "key-123456789"
+
+	for _, ip := range []string{"198.51.100.10", "198.51.100.20"} {
+		if rec := doRequest(engine, key, "dev-a", ip); rec.Code != http.StatusOK {
+			t.Fatalf("expected request from %s to pass, got %d", ip, rec.Code)
+		}
+	}
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2"} {
+		if rec := doRequest(engine, key, "dev-b", ip); rec.Code != http.StatusOK {
+			t.Fatalf("expected request from %s to pass, got %d", ip, rec.Code)
+		}
+	}
+	if binding, _ := store.Get(key); binding.Strikes != 0 {
+		t.Fatalf("expected no strikes for trusted IP changes, got %d", binding.Strikes)
+	}
+
+	// Leaving the trusted network is still concurrent usage.
+	if rec := doRequest(engine, key, "dev-a", "192.0.2.1"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected untrusted IP change to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestMiddlewareRequiresApprovalForNewDevices(t *testing.T) {
+	engine, store := newTestEngine(t, Config{RequireApproval: true})
+	key := "key-123456789"
+
+	for i := 0; i < 2; i++ {
+		if rec := doRequest(engine, key, "dev-a", "10.0.0.1"); rec.Code != http.StatusForbidden {
+			t.Fatalf("expected pending device to be rejected, got %d", rec.Code)
+		}
+	}
+	binding, _ := store.Get(key)
+	if len(binding.Devices) != 1 || !binding.Devices[0].Pending {
+		t.Fatalf("expected one pending device, got %+v", binding.Devices)
+	}
+
+	if approved, err := store.Approve(key, "dev-a"); err != nil || !approved {
+		t.Fatalf("approve: approved=%v err=%v", approved, err)
+	}
+	if rec := doRequest(engine, key, "dev-a", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected approved device to pass, got %d", rec.Code)
+	}
+}
+
+func TestMiddlewareRecordsDecisionMetrics(t *testing.T) {
+	engine, _ := newTestEngine(t, Config{MaxDevices: 1})
+	key := "key-metrics-1234"
+
+	before := counterValue(t, "cliproxy_device_binding_decisions_total", "decision", decisionDeviceLimit)
+	doRequest(engine, key, "dev-a", "10.0.0.1")
+	doRequest(engine, key, "dev-b", "10.0.0.1")
+	if got := counterValue(t, "cliproxy_device_binding_decisions_total", "decision", decisionDeviceLimit); got != before+1 {
+		t.Fatalf("expected device limit rejection to be counted, got %v -> %v", before, got)
+	}
+	if got := counterValue(t, "cliproxy_device_active_devices", "api_key", MaskKey(key)); got != 1 {
+		t.Fatalf("expected 1 active device, got %v", got)
+	}
+}
+
+func counterValue(t *testing.T, name, label, value string) float64 {
+	t.Helper()
+	for _, family := range metrics.Default().Gather() {
+		if family.Name != name {
+			continue
+		}
+		for _, sample := range family.Samples {
+			if sample.Labels[label] == value {
+				return sample.Value
+			}
+		}
+	}
+	return 0
+}
+
+func TestMiddlewareAppliesPerKeyPolicy(t *testing.T) {
+	engine, store := newTestEngine(t, Config{MaxDevices: 1, ConcurrentThreshold: time.Minute})
+	key := "key-ci-runners"
+	if err := store.SetPolicy(key, &Policy{MaxDevices: 3, ConcurrentAction: ConcurrentActionIgnore}); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+
+	for _, dev := range []string{"runner-1", "runner-2", "runner-3"} {
+		if rec := doRequest(engine, key, dev, "10.0.0.1"); rec.Code != http.StatusOK {
+			t.Fatalf("expected %s to be accepted, got %d", dev, rec.Code)
+		}
+	}
+	if rec := doRequest(engine, key, "runner-1", "10.0.0.2"); rec.Code != http.StatusOK {
+		t.Fatalf("expected concurrent usage to be ignored, got %d", rec.Code)
+	}
+	if rec := doRequest(engine, key, "runner-4", "10.0.0.1"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected fourth device to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestMiddlewareGracePeriodRecordsButNeverBans(t *testing.T) {
+	engine, store := newTestEngine(t, Config{ConcurrentThreshold: time.Minute, GracePeriod: 24 * time.Hour})
+	key := "key-123456789"
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if rec := doRequest(engine, key, "dev-a", ip); rec.Code != http.StatusOK {
+			t.Fatalf("expected request from %s to pass during grace period, got %d", ip, rec.Code)
+		}
+	}
+	binding, _ := store.Get(key)
+	if binding.Banned || binding.Strikes != 0 || binding.Devices[0].LastIP != "10.0.0.3" {
+		t.Fatalf("expected IP change to be recorded without strikes, got %+v", binding)
+	}
+}
+
+func TestMiddlewarePinsAndEnforcesTLSFingerprint(t *testing.T) {
+	engine, store := newTestEngine(t, Config{
+		TLSFingerprintMode: TLSFingerprintEnforce,
+		TLSFingerprint:     func(r *http.Request) string { return r.Header.Get("X-Test-Fingerprint") },
+	})
+	key := "key-123456789"
+	request := func(fingerprint string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Test-Key", key)
+		req.Header.Set("X-Device-ID", "dev-a")
+		req.Header.Set("X-Test-Fingerprint", fingerprint)
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := request("t13d1516h2_aaa_bbb"); code != http.StatusOK {
+		t.Fatalf("expected first request to pin its fingerprint, got %d", code)
+	}
+	if binding, _ := store.Get(key); !binding.HasTLSFingerprint("t13d1516h2_aaa_bbb") {
+		t.Fatalf("expected fingerprint to be pinned, got %v", binding.TLSFingerprints)
+	}
+	if code := request("t13d1516h2_aaa_bbb"); code != http.StatusOK {
+		t.Fatalf("expected pinned fingerprint to pass, got %d", code)
+	}
+	if code := request("t12d0909h1_ccc_ddd"); code != http.StatusForbidden {
+		t.Fatalf("expected other fingerprint to be rejected, got %d", code)
+	}
+
+	if cleared, err := store.ClearTLSFingerprints(key); err != nil || !cleared {
+		t.Fatalf("clear fingerprints: %v, %v", cleared, err)
+	}
+	if code := request("t12d0909h1_ccc_ddd"); code != http.StatusOK {
+		t.Fatalf("expected new fingerprint to be pinned after reset, got %d", code)
+	}
+}
+
+func TestMiddlewareFlagsOverlappingStreamsFromDifferentDevices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	mw := NewMiddleware(store, Config{Enabled: true, MaxDevices: 2, MaxStreamingDevices: 1})
+	streaming := make(chan struct{})
+	finish := make(chan struct{})
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("X-Test-Key"))
+		c.Next()
+	})
+	engine.Use(mw.Handler())
+	engine.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	engine.GET("/stream", func(c *gin.Context) {
+		close(streaming)
+		<-finish
+		c.Status(http.StatusOK)
+	})
+	key := "key-123456789"
+
+	// Sequential use of both devices, even seconds apart, is fine.
+	for _, dev := range []string{"dev-a", "dev-b", "dev-a", "dev-b"} {
+		if rec := doRequest(engine, key, dev, "10.0.0.1"); rec.Code != http.StatusOK {
+			t.Fatalf("expected sequential request from %s to pass, got %d", dev, rec.Code)
+		}
+	}
+
+	done := make(chan int)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+		req.Header.Set("X-Test-Key", key)
+		req.Header.Set("X-Device-ID", "dev-a")
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		done <- rec.Code
+	}()
+	<-streaming
+	if active := mw.ActiveStreams(key); active["dev-a"] != 1 {
+		t.Fatalf("active streams = %v", active)
+	}
+
+	// Same IP, so only the stream registry can tell the devices overlap.
+	if rec := doRequest(engine, key, "dev-b", "10.0.0.1"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected overlapping request from dev-b to be rejected, got %d", rec.Code)
+	}
+	binding, _ := store.Get(key)
+	if !binding.Banned {
+		t.Fatalf("expected key to be banned, got %+v", binding)
+	}
+	close(finish)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("stream finished with %d", code)
+	}
+	if active := mw.ActiveStreams(key); len(active) != 0 {
+		t.Fatalf("expected no active streams after completion, got %v", active)
+	}
+}
+
+func TestMiddlewareUsesVerifiedClientCertificateAsDeviceID(t *testing.T) {
+	engine, store := newTestEngine(t, Config{MaxDevices: 1, ClientCertIdentity: true})
+	cert := &x509.Certificate{Raw: []byte("client certificate")}
+
+	send := func(deviceID string, verified bool) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Test-Key", "key-123456789")
+		req.Header.Set("X-Device-ID", deviceID)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		if verified {
+			req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// The spoofable header changes, the certificate does not: still one device.
+	for _, header := range []string{"dev-a", "dev-b"} {
+		if code := send(header, true); code != http.StatusOK {
+			t.Fatalf("header %s: status %d", header, code)
+		}
+	}
+	binding, _ := store.Get("key-123456789")
+	if len(binding.Devices) != 1 || binding.Devices[0].DeviceID != ClientCertFingerprint(&http.Request{TLS: &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}}) {
+		t.Fatalf("devices = %+v", binding.Devices)
+	}
+	// Unverified certificates are ignored, so the header identifies a second device.
+	if code := send("dev-c", false); code != http.StatusForbidden {
+		t.Fatalf("unverified certificate: status %d, want the header device to be rejected", code)
+	}
+}
+
+func TestClientCertificateDevicesWorkWithDeviceTokens(t *testing.T) {
+	engine, store := newTestEngine(t, Config{MaxDevices: 1, ClientCertIdentity: true, TokenSecret: "secret"})
+	cert := &x509.Certificate{Raw: []byte("client certificate")}
+	withCert := func(req *http.Request) *http.Request {
+		req.Header.Set("X-Test-Key", "key-123456789")
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return req
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, withCert(httptest.NewRequest(http.MethodGet, "/", nil)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d with a verified certificate: status %d %s", i, rec.Code, rec.Body.String())
+		}
+	}
+	binding, _ := store.Get("key-123456789")
+	if len(binding.Devices) != 1 || binding.Devices[0].DeviceID != ClientCertFingerprint(withCert(httptest.NewRequest(http.MethodGet, "/", nil))) {
+		t.Fatalf("devices = %+v", binding.Devices)
+	}
+}
+
+func TestRegisterDeviceRefusesCertificateIDs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := NewMiddleware(store, Config{Enabled: true, MaxDevices: 2, ClientCertIdentity: true, TokenSecret: "secret"})
+	engine := gin.New()
+	engine.POST("/v0/device/register", func(c *gin.Context) { c.Set("apiKey", "key-1") }, mw.RegisterDevice)
+
+	req := httptest.NewRequest(http.MethodPost, "/v0/device/register", strings.NewReader(`{"device_id":"cert:abc"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a claimed certificate ID to be refused, got %d", rec.Code)
+	}
+}
+
+// unavailableStore wraps a FileStore whose reads and saves fail while down is set.
+type unavailableStore struct {
+	*FileStore
+	down bool
+}
+
+func (s *unavailableStore) Lookup(apiKey string) (DeviceBinding, bool, error) {
+	if s.down {
+		return DeviceBinding{}, false, errors.New("connection refused")
+	}
+	binding, exists := s.FileStore.Get(apiKey)
+	return binding, exists, nil
+}
+
+func (s *unavailableStore) AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP string, pending bool, limit int) (bool, error) {
+	if s.down {
+		return false, errors.New("connection refused")
+	}
+	return s.FileStore.AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP, pending, limit)
+}
+
+func TestMiddlewareDegradedModeUsesFallbackCacheThenFailMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fileStore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	for _, failClosed := range []bool{false, true} {
+		store := &unavailableStore{FileStore: fileStore}
+		mw := NewMiddleware(store, Config{Enabled: true, MaxDevices: 1, FailClosed: failClosed})
+		engine := gin.New()
+		engine.Use(func(c *gin.Context) {
+			c.Set("apiKey", c.GetHeader("X-Test-Key"))
+			c.Next()
+		})
+		engine.Use(mw.Handler())
+		engine.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		if rec := doRequest(engine, "key-cached-00001", "dev-a", "10.0.0.1"); rec.Code != http.StatusOK {
+			t.Fatalf("failClosed=%v: first device: %d", failClosed, rec.Code)
+		}
+		store.down = true
+		if rec := doRequest(engine, "key-cached-00001", "dev-b", "10.0.0.1"); rec.Code != http.StatusForbidden {
+			t.Fatalf("failClosed=%v: the cached binding should still enforce the device limit, got %d", failClosed, rec.Code)
+		}
+		want := http.StatusOK
+		if failClosed {
+			want = http.StatusServiceUnavailable
+		}
+		if rec := doRequest(engine, "key-uncached-001", "dev-a", "10.0.0.1"); rec.Code != want {
+			t.Fatalf("failClosed=%v: unknown key while the store is down: %d, want %d", failClosed, rec.Code, want)
+		}
+		if err = fileStore.Clear(); err != nil {
+			t.Fatalf("clear: %v", err)
+		}
+	}
+}
+
+func TestMiddlewareScoresSignalsInsteadOfBanningOnIP(t *testing.T) {
+	engine, store := newTestEngine(t, Config{
+		ConcurrentThreshold: time.Minute,
+		Anomaly:             AnomalyConfig{Enabled: true, BanScore: 80},
+	})
+	key := "key-123456789"
+	request := func(ip, userAgent string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Test-Key", key)
+		req.Header.Set("X-Device-ID", "dev-a")
+		req.Header.Set("User-Agent", userAgent)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec
+	}
+
+	request("10.0.0.1", "cli/1.0")
+	request("10.0.0.1", "cli/1.0")
+	// An IP change alone only warns
+	rec := request("10.0.0.2", "cli/1.0")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Device-Warning") == "" {
+		t.Fatalf("expected an IP change alone to warn, got %d", rec.Code)
+	}
+	if binding, _ := store.Get(key); binding.Banned {
+		t.Fatal("expected the key not to be banned on an IP change alone")
+	}
+	// A different IP and client at once crosses the ban score
+	rec = request("10.0.0.3", "other/2.0")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected IP and user agent changes to ban, got %d", rec.Code)
+	}
+	if binding, _ := store.Get(key); !binding.Banned {
+		t.Fatal("expected the key to be banned")
+	}
+}
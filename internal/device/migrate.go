@@ -0,0 +1,229 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// migrateTimeout bounds the transaction that writes all migrated bindings
+const migrateTimeout = 5 * time.Minute
+
+// BindingWriter is implemented by stores that can write complete bindings, which
+// copying data between backends requires
+type BindingWriter interface {
+	// PutBindings creates or replaces the given bindings
+	PutBindings(bindings map[string]DeviceBinding) error
+}
+
+// MigrateReport summarises a store migration
+type MigrateReport struct {
+	Bindings  int `json:"bindings"`
+	Devices   int `json:"devices"`
+	Banned    int `json:"banned"`
+	History   int `json:"ban_history"`
+	UsageRows int `json:"usage_rows"`
+	// UsageCopied is the number of usage rows the target lacked or held lower counters for
+	UsageCopied int `json:"usage_copied"`
+	// Mismatches lists differences found when verifying the target; empty on success
+	Mismatches []string `json:"mismatches,omitempty"`
+}
+
+// Migrate copies every binding (devices, bans, ban history, strikes, metadata,
+// policies and pinned TLS fingerprints) and the daily key usage from src to dst,
+// then verifies dst against src. Bindings already in dst are replaced; usage is
+// only raised to the source counters, so running a migration twice is safe.
+// With dryRun nothing is written and the report describes what would be copied.
+func Migrate(src, dst Store, dryRun bool) (MigrateReport, error) {
+	var report MigrateReport
+	writer, ok := dst.(BindingWriter)
+	if !ok {
+		return report, fmt.Errorf("target store does not support writing bindings")
+	}
+	bindings := src.GetAll()
+	for _, binding := range bindings {
+		report.Bindings++
+		report.Devices += len(binding.Devices)
+		report.History += len(binding.BanHistory)
+		if binding.Banned {
+			report.Banned++
+		}
+	}
+
+	var missing []KeyUsage
+	srcUsage, srcHasUsage := src.(KeyUsageStore)
+	dstUsage, dstHasUsage := dst.(KeyUsageStore)
+	if srcHasUsage {
+		rows, err := srcUsage.KeyUsage(KeyUsageFilter{})
+		if err != nil {
+			return report, fmt.Errorf("read source key usage: %w", err)
+		}
+		report.UsageRows = len(rows)
+		if len(rows) > 0 && !dstHasUsage {
+			return report, fmt.Errorf("target store does not support key usage")
+		}
+		if dstHasUsage {
+			existing, err := dstUsage.KeyUsage(KeyUsageFilter{})
+			if err != nil {
+				return report, fmt.Errorf("read target key usage: %w", err)
+			}
+			missing = usageDelta(rows, existing)
+			report.UsageCopied = len(missing)
+		}
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	if err := writer.PutBindings(bindings); err != nil {
+		return report, fmt.Errorf("write bindings: %w", err)
+	}
+	if len(missing) > 0 {
+		if err := dstUsage.AddKeyUsage(missing); err != nil {
+			return report, fmt.Errorf("write key usage: %w", err)
+		}
+	}
+	mismatches, err := VerifyMigration(src, dst)
+	report.Mismatches = mismatches
+	return report, err
+}
+
+// usageDelta returns the counters to add to target so each source row is matched
+func usageDelta(source, target []KeyUsage) []KeyUsage {
+	current := make(map[keyUsageID]KeyUsage, len(target))
+	for _, row := range target {
+		current[keyUsageID{row.Day, row.APIKey, row.Model}] = row
+	}
+	var delta []KeyUsage
+	for _, row := range source {
+		have := current[keyUsageID{row.Day, row.APIKey, row.Model}]
+		add := KeyUsage{
+			Day:          row.Day,
+			APIKey:       row.APIKey,
+			Model:        row.Model,
+			Requests:     max(row.Requests-have.Requests, 0),
+			InputTokens:  max(row.InputTokens-have.InputTokens, 0),
+			OutputTokens: max(row.OutputTokens-have.OutputTokens, 0),
+		}
+		if add.Requests > 0 || add.InputTokens > 0 || add.OutputTokens > 0 {
+			delta = append(delta, add)
+		}
+	}
+	return delta
+}
+
+// VerifyMigration compares every source binding and usage row with the target
+// and describes each difference. Timestamps are compared at second precision
+// since backends store them with different resolutions.
+func VerifyMigration(src, dst Store) ([]string, error) {
+	var mismatches []string
+	target := dst.GetAll()
+	source := src.GetAll()
+	keys := slices.Sorted(maps.Keys(source))
+	for _, apiKey := range keys {
+		got, ok := target[apiKey]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("binding %s missing in target", MaskKey(apiKey)))
+			continue
+		}
+		if want, have := bindingDigest(source[apiKey]), bindingDigest(got); want != have {
+			mismatches = append(mismatches, fmt.Sprintf("binding %s differs: source %s, target %s", MaskKey(apiKey), want, have))
+		}
+	}
+
+	srcUsage, ok := src.(KeyUsageStore)
+	if !ok {
+		return mismatches, nil
+	}
+	dstUsage, ok := dst.(KeyUsageStore)
+	if !ok {
+		return mismatches, nil
+	}
+	rows, err := srcUsage.KeyUsage(KeyUsageFilter{})
+	if err != nil {
+		return mismatches, fmt.Errorf("read source key usage: %w", err)
+	}
+	existing, err := dstUsage.KeyUsage(KeyUsageFilter{})
+	if err != nil {
+		return mismatches, fmt.Errorf("read target key usage: %w", err)
+	}
+	for _, row := range usageDelta(rows, existing) {
+		mismatches = append(mismatches, fmt.Sprintf("usage %s %s %s short by %d requests, %d input and %d output tokens",
+			row.Day, MaskKey(row.APIKey), row.Model, row.Requests, row.InputTokens, row.OutputTokens))
+	}
+	return mismatches, nil
+}
+
+// bindingDigest renders the persisted state of a binding in a comparable form
+func bindingDigest(b DeviceBinding) string {
+	second := func(t time.Time) int64 { return t.Unix() }
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "seen=%d/%d ip=%s banned=%t reason=%q banned_at=%d expires=%d strikes=%d/%d",
+		second(b.FirstSeen), second(b.LastSeen), b.LastIP, b.Banned, b.BanReason,
+		second(b.BannedAt), second(b.BanExpiresAt), b.Strikes, second(b.LastStrikeAt))
+	fmt.Fprintf(&sb, " metadata=%s", digestMap(b.Metadata))
+	if p := b.Policy; p != nil {
+		banDuration := "inherit"
+		if p.BanDuration != nil {
+			banDuration = fmt.Sprint(*p.BanDuration)
+		}
+		fmt.Fprintf(&sb, " policy=%d/%d/%s/%s/%s", p.MaxDevices, p.ConcurrentThreshold, banDuration, p.ConcurrentAction, p.TLSFingerprint)
+	}
+	fmt.Fprintf(&sb, " tls=%v history=%d", b.TLSFingerprints, len(b.BanHistory))
+	for _, ban := range b.DeviceBans {
+		fmt.Fprintf(&sb, " device_ban=%s/%s/%q/%d/%d", ban.DeviceID, ban.IP, ban.Reason, second(ban.BannedAt), second(ban.ExpiresAt))
+	}
+	devices := slices.Clone(b.Devices)
+	sort.Slice(devices, func(i, j int) bool { return devices[i].DeviceID < devices[j].DeviceID })
+	for _, d := range devices {
+		fmt.Fprintf(&sb, " device=%s/%s/%t/%s/%d/%d%s", d.DeviceID, d.Type, d.Pending, d.LastIP,
+			second(d.FirstSeen), second(d.LastSeen), digestMap(d.Metadata))
+		if a := d.Attestation; a != nil {
+			fmt.Fprintf(&sb, "/attested=%s/%s/%v/%s/%d", a.Platform, a.Verdict, a.Labels, a.AppID, second(a.VerifiedAt))
+		}
+	}
+	return sb.String()
+}
+
+func digestMap(m map[string]string) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		fmt.Fprintf(&sb, "%s=%q,", k, m[k])
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// PutBindings creates or replaces bindings and persists
+func (s *FileStore) PutBindings(bindings map[string]DeviceBinding) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for apiKey, binding := range bindings {
+		s.bindings.Bindings[apiKey] = binding.clone()
+	}
+	return s.save()
+}
+
+// PutBindings creates or replaces bindings in one transaction
+func (s *sqlStore) PutBindings(bindings map[string]DeviceBinding) error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for apiKey, binding := range bindings {
+		if err = s.write(ctx, tx, apiKey, binding); err != nil {
+			return fmt.Errorf("%s store: write binding: %w", s.dialect.name, err)
+		}
+	}
+	return tx.Commit()
+}
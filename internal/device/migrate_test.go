@@ -0,0 +1,74 @@
+package device
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrateCopiesFileStoreToSQLite(t *testing.T) {
+	src, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if _, err = src.AddDeviceWithinLimit("sk-test-key", "laptop", "client_id", "203.0.113.7", false, 10); err != nil {
+		t.Fatalf("AddDeviceWithinLimit: %v", err)
+	}
+	if _, err = src.SetMetadata("sk-test-key", "laptop", map[string]string{"owner": "ops"}, false); err != nil {
+		t.Fatalf("SetMetadata: %v", err)
+	}
+	banDuration := 60
+	if err = src.SetPolicy("sk-test-key", &Policy{MaxDevices: 2, BanDuration: &banDuration}); err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+	if _, err = src.PinTLSFingerprint("sk-test-key", "t13d1516h2_aaa_bbb", 2); err != nil {
+		t.Fatalf("PinTLSFingerprint: %v", err)
+	}
+	if _, err = src.AddDeviceWithinLimit("sk-banned", "desktop", "ip", "198.51.100.1", false, 10); err != nil {
+		t.Fatalf("AddDeviceWithinLimit: %v", err)
+	}
+	if err = src.Ban("sk-banned", "shared", time.Hour, "198.51.100.1"); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+	if err = src.AddKeyUsage([]KeyUsage{{Day: "2026-01-02", APIKey: "sk-test-key", Model: "m", Requests: 3, InputTokens: 100, OutputTokens: 20}}); err != nil {
+		t.Fatalf("AddKeyUsage: %v", err)
+	}
+
+	dst, err := NewSQLiteStore(filepath.Join(t.TempDir(), "bindings.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer func() { _ = dst.Close() }()
+
+	report, err := Migrate(src, dst, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if report.Bindings != 2 || report.Banned != 1 || report.UsageCopied != 1 || len(dst.GetAll()) != 0 {
+		t.Fatalf("dry run report %+v wrote %d bindings", report, len(dst.GetAll()))
+	}
+
+	for run := 0; run < 2; run++ {
+		if report, err = Migrate(src, dst, false); err != nil {
+			t.Fatalf("Migrate run %d: %v", run, err)
+		}
+		if len(report.Mismatches) != 0 {
+			t.Fatalf("run %d mismatches: %v", run, report.Mismatches)
+		}
+	}
+	if report.UsageCopied != 0 {
+		t.Fatalf("second run copied %d usage rows again", report.UsageCopied)
+	}
+	rows, err := dst.(KeyUsageStore).KeyUsage(KeyUsageFilter{})
+	if err != nil || len(rows) != 1 || rows[0].Requests != 3 || rows[0].InputTokens != 100 {
+		t.Fatalf("target usage = %+v, %v", rows, err)
+	}
+	banned, _ := dst.Get("sk-banned")
+	if !banned.Banned || len(banned.BanHistory) != 1 || banned.BanExpiresAt.IsZero() {
+		t.Fatalf("ban not migrated: %+v", banned)
+	}
+	bound, _ := dst.Get("sk-test-key")
+	if !bound.HasTLSFingerprint("t13d1516h2_aaa_bbb") || bound.Policy == nil || *bound.Policy.BanDuration != 60 || bound.Devices[0].Metadata["owner"] != "ops" {
+		t.Fatalf("binding not migrated: %+v", bound)
+	}
+}
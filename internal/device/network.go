@@ -0,0 +1,55 @@
+package device
+
+import (
+	"net"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ParseCIDRs parses CIDR blocks, accepting bare IPs as single-host networks.
+// Invalid entries are logged and skipped.
+func ParseCIDRs(entries []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				log.Warnf("device-binding: ignoring invalid IP %q", entry)
+				continue
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Warnf("device-binding: ignoring invalid CIDR %q: %v", entry, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// sameTrustedNetwork reports whether both IPs fall inside one of the networks.
+func sameTrustedNetwork(networks []*net.IPNet, a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ipA) && network.Contains(ipB) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package device
+
+import (
+	"time"
+)
+
+// Concurrent-usage actions that a per-key policy can force
+const (
+	ConcurrentActionEscalate = ""       // Follow the global escalation policy
+	ConcurrentActionWarn     = "warn"   // Only warn, never ban
+	ConcurrentActionBan      = "ban"    // Ban on every detection for the effective ban duration
+	ConcurrentActionIgnore   = "ignore" // Do not act on concurrent usage at all
+)
+
+// Policy holds per-API-key overrides of the global device binding settings.
+// Zero values inherit the global setting.
+type Policy struct {
+	// MaxDevices overrides the device limit for the key
+	MaxDevices int `yaml:"max_devices,omitempty" json:"max_devices,omitempty"`
+	// ConcurrentThreshold overrides the concurrent-usage window in seconds; negative disables detection
+	ConcurrentThreshold int `yaml:"concurrent_threshold,omitempty" json:"concurrent_threshold,omitempty"`
+	// BanDuration overrides the automatic ban length in seconds; 0 bans permanently
+	BanDuration *int `yaml:"ban_duration,omitempty" json:"ban_duration,omitempty"`
+	// ConcurrentAction overrides what happens on a concurrent-usage detection
+	ConcurrentAction string `yaml:"concurrent_action,omitempty" json:"concurrent_action,omitempty"`
+	// TLSFingerprint overrides the TLS fingerprint pinning mode (off, record or enforce)
+	TLSFingerprint string `yaml:"tls_fingerprint,omitempty" json:"tls_fingerprint,omitempty"`
+	// RequireAttestation only accepts devices with a trusted App Attest or Play Integrity verdict
+	RequireAttestation bool `yaml:"require_attestation,omitempty" json:"require_attestation,omitempty"`
+}
+
+// IsZero reports whether the policy overrides nothing
+func (p Policy) IsZero() bool {
+	return p.MaxDevices == 0 && p.ConcurrentThreshold == 0 && p.BanDuration == nil && p.ConcurrentAction == "" && p.TLSFingerprint == "" && !p.RequireAttestation
+}
+
+// ValidConcurrentAction reports whether the action is a known concurrent-usage action
+func ValidConcurrentAction(action string) bool {
+	switch action {
+	case ConcurrentActionEscalate, ConcurrentActionWarn, ConcurrentActionBan, ConcurrentActionIgnore:
+		return true
+	}
+	return false
+}
+
+func (p *Policy) clone() *Policy {
+	if p == nil {
+		return nil
+	}
+	out := *p
+	if p.BanDuration != nil {
+		d := *p.BanDuration
+		out.BanDuration = &d
+	}
+	return &out
+}
+
+// SetPolicy replaces the policy overrides of an API key, creating the binding if needed.
+// A nil or empty policy removes all overrides.
+func (d *DeviceBindings) SetPolicy(apiKey string, policy *Policy) {
+	if d.Bindings == nil {
+		d.Bindings = make(map[string]DeviceBinding)
+	}
+	binding := d.Bindings[apiKey]
+	if policy == nil || policy.IsZero() {
+		binding.Policy = nil
+	} else {
+		binding.Policy = policy.clone()
+	}
+	d.Bindings[apiKey] = binding
+}
+
+// SetPolicy replaces the policy overrides of an API key and persists
+func (s *FileStore) SetPolicy(apiKey string, policy *Policy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bindings.SetPolicy(apiKey, policy)
+	return s.save()
+}
+
+// EffectivePolicy is the device binding policy applied to one API key after overrides
+type EffectivePolicy struct {
+	MaxDevices          int           `json:"max_devices"`
+	ConcurrentThreshold time.Duration `json:"-"`
+	DetectConcurrent    bool          `json:"detect_concurrent"`
+	ConcurrentAction    string        `json:"concurrent_action"`
+	// BanDuration is the forced ban length when ConcurrentAction is "ban"
+	BanDuration time.Duration `json:"-"`
+	// TLSFingerprint is the TLS fingerprint pinning mode
+	TLSFingerprint string `json:"tls_fingerprint"`
+	// RequireAttestation rejects devices without a trusted attestation
+	RequireAttestation bool `json:"require_attestation"`
+}
+
+// PolicyFor returns the policy applied to an API key together with the key's
+// own overrides, or nil overrides when it has none.
+func (m *Middleware) PolicyFor(apiKey string) (EffectivePolicy, *Policy) {
+	if m == nil {
+		return EffectivePolicy{}, nil
+	}
+	binding, _ := m.store.Get(apiKey)
+	return m.effectivePolicy(binding.Policy), binding.Policy
+}
+
+// effectivePolicy merges a key's overrides over the global configuration
+func (m *Middleware) effectivePolicy(policy *Policy) EffectivePolicy {
+	eff := EffectivePolicy{
+		MaxDevices:          m.config.MaxDevices,
+		ConcurrentThreshold: m.config.ConcurrentThreshold,
+		DetectConcurrent:    true,
+		BanDuration:         m.config.BanDuration,
+		TLSFingerprint:      m.config.TLSFingerprintMode,
+		RequireAttestation:  m.config.Attestation.Required,
+	}
+	if policy == nil {
+		return eff
+	}
+	if policy.MaxDevices > 0 {
+		eff.MaxDevices = policy.MaxDevices
+	}
+	if policy.ConcurrentThreshold > 0 {
+		eff.ConcurrentThreshold = time.Duration(policy.ConcurrentThreshold) * time.Second
+	} else if policy.ConcurrentThreshold < 0 {
+		eff.DetectConcurrent = false
+	}
+	if policy.BanDuration != nil {
+		eff.BanDuration = time.Duration(*policy.BanDuration) * time.Second
+	}
+	eff.ConcurrentAction = policy.ConcurrentAction
+	eff.RequireAttestation = eff.RequireAttestation || policy.RequireAttestation
+	if policy.TLSFingerprint != "" {
+		eff.TLSFingerprint = policy.TLSFingerprint
+	}
+	if eff.ConcurrentAction == ConcurrentActionIgnore {
+		eff.DetectConcurrent = false
+	}
+	return eff
+}
@@ -0,0 +1,67 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+var postgresDialect = sqlDialect{
+	name:            "postgres",
+	timeType:        "TIMESTAMPTZ",
+	numbered:        true,
+	lockClause:      " FOR UPDATE",
+	migrationLock:   "SELECT pg_advisory_lock(7412030)",
+	migrationUnlock: "SELECT pg_advisory_unlock(7412030)",
+}
+
+// PostgresConfig configures the PostgreSQL store and its connection pool
+type PostgresConfig struct {
+	DSN string
+	// MaxOpenConns caps open connections; 0 means unlimited
+	MaxOpenConns int
+	// MaxIdleConns caps idle connections kept in the pool
+	MaxIdleConns int
+	// ConnMaxLifetime recycles connections after this duration; 0 keeps them forever
+	ConnMaxLifetime time.Duration
+}
+
+// NewPostgresStore connects to PostgreSQL through pgx and applies pending
+// schema migrations. Multiple proxy nodes may share one database: writes
+// lock the affected binding row for the duration of the transaction.
+func NewPostgresStore(cfg PostgresConfig) (Store, error) {
+	dsn := strings.TrimSpace(cfg.DSN)
+	if dsn == "" {
+		return nil, fmt.Errorf("postgres store: DSN is required")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("postgres store: open database connection: %w", err)
+	}
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlQueryTimeout)
+	defer cancel()
+	if err = db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("postgres store: ping database: %w", err)
+	}
+	store, err := newSQLStore(db, postgresDialect)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return store, nil
+}
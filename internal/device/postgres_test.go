@@ -0,0 +1,21 @@
+package device
+
+import "testing"
+
+func TestPostgresDialectNumbersPlaceholders(t *testing.T) {
+	s := &sqlStore{dialect: postgresDialect}
+	got := s.q("UPDATE device_bindings SET last_seen = ?, last_ip = ? WHERE api_key = ?")
+	want := "UPDATE device_bindings SET last_seen = $1, last_ip = $2 WHERE api_key = $3"
+	if got != want {
+		t.Fatalf("q() = %q, want %q", got, want)
+	}
+	if sqlite := (&sqlStore{dialect: sqliteDialect}).q("a = ?"); sqlite != "a = ?" {
+		t.Fatalf("sqlite placeholders should be unchanged, got %q", sqlite)
+	}
+}
+
+func TestOpenStoreRequiresPostgresDSN(t *testing.T) {
+	if _, err := OpenStore(StoreConfig{Backend: BackendPostgres}); err == nil {
+		t.Fatal("expected error without DSN")
+	}
+}
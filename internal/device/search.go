@@ -0,0 +1,117 @@
+package device
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultSearchLimit = 100
+
+// SearchMatch is a single search hit within the device bindings
+type SearchMatch struct {
+	APIKey   string `json:"api_key"`
+	DeviceID string `json:"device_id,omitempty"`
+	Field    string `json:"field"`
+	Value    string `json:"value"`
+	Match    string `json:"match"` // "exact", "prefix" or "substring"
+}
+
+// Search finds bindings whose key, device IDs, IPs, ban reason or metadata
+// match the query case-insensitively. Exact and prefix matches rank first.
+func Search(store Store, query string, limit int) []SearchMatch {
+	needle := strings.ToLower(strings.TrimSpace(query))
+	if needle == "" {
+		return nil
+	}
+
+	var matches []SearchMatch
+	add := func(apiKey, deviceID, field, value string) {
+		lower := strings.ToLower(value)
+		kind := ""
+		switch {
+		case lower == needle:
+			kind = "exact"
+		case strings.HasPrefix(lower, needle):
+			kind = "prefix"
+		case strings.Contains(lower, needle):
+			kind = "substring"
+		default:
+			return
+		}
+		matches = append(matches, SearchMatch{APIKey: apiKey, DeviceID: deviceID, Field: field, Value: value, Match: kind})
+	}
+
+	for apiKey, binding := range store.GetAll() {
+		add(apiKey, "", "api_key", apiKey)
+		add(apiKey, "", "last_ip", binding.LastIP)
+		add(apiKey, "", "ban_reason", binding.BanReason)
+		for k, v := range binding.Metadata {
+			add(apiKey, "", "metadata."+k, v)
+		}
+		for _, dev := range binding.Devices {
+			add(apiKey, dev.DeviceID, "device_id", dev.DeviceID)
+			if dev.LastIP != binding.LastIP {
+				add(apiKey, dev.DeviceID, "last_ip", dev.LastIP)
+			}
+			for k, v := range dev.Metadata {
+				add(apiKey, dev.DeviceID, "metadata."+k, v)
+			}
+		}
+	}
+
+	rank := map[string]int{"exact": 0, "prefix": 1, "substring": 2}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if rank[a.Match] != rank[b.Match] {
+			return rank[a.Match] < rank[b.Match]
+		}
+		if a.APIKey != b.APIKey {
+			return a.APIKey < b.APIKey
+		}
+		if a.DeviceID != b.DeviceID {
+			return a.DeviceID < b.DeviceID
+		}
+		return a.Field < b.Field
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// Search searches device bindings by key, device ID, IP, ban reason and metadata
+// GET /v0/management/search?q=203.0.113.7[&limit=100]
+func (h *Handler) Search(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(400, gin.H{
+			"error":   "missing_parameter",
+			"message": "q parameter is required",
+		})
+		return
+	}
+	limit := defaultSearchLimit
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			c.JSON(400, gin.H{
+				"error":   "invalid_parameter",
+				"message": "limit must be a positive integer",
+			})
+			return
+		}
+		limit = parsed
+	}
+
+	results := Search(h.store, query, limit)
+	if results == nil {
+		results = []SearchMatch{}
+	}
+	c.JSON(200, gin.H{
+		"query":   query,
+		"results": results,
+	})
+}
@@ -0,0 +1,25 @@
+package device
+
+import "testing"
+
+func TestStoreSearchRanksPrefixBeforeSubstring(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	_, _ = store.AddDeviceWithinLimit("key-alpha", "laptop-1", "client_id", "203.0.113.7", false, 10)
+	_, _ = store.AddDeviceWithinLimit("key-beta", "ci-runner", "client_id", "198.51.100.203", false, 10)
+	_, _ = store.SetMetadata("key-beta", "", map[string]string{"customer": "Acme 203 Ltd"}, false)
+
+	results := Search(store, "203", 0)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 matches, got %+v", results)
+	}
+	if results[0].APIKey != "key-alpha" || results[0].Field != "last_ip" || results[0].Match != "prefix" {
+		t.Fatalf("expected IP prefix match first, got %+v", results[0])
+	}
+
+	if got := Search(store, "LAPTOP-1", 0); len(got) != 1 || got[0].DeviceID != "laptop-1" || got[0].Match != "exact" {
+		t.Fatalf("expected case-insensitive exact device match, got %+v", got)
+	}
+}
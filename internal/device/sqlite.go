@@ -0,0 +1,42 @@
+package device
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteFileName = "device-bindings.db"
+
+var sqliteDialect = sqlDialect{name: "sqlite", timeType: "TIMESTAMP"}
+
+// NewSQLiteStore opens (creating if needed) a SQLite database at path and
+// applies pending schema migrations. The database runs in WAL mode so
+// readers never block the writer, and write transactions take the lock up
+// front to avoid upgrade deadlocks between concurrent writers.
+func NewSQLiteStore(path string) (Store, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("sqlite store: create directory: %w", err)
+		}
+	}
+	params := url.Values{}
+	params.Set("_journal_mode", "WAL")
+	params.Set("_busy_timeout", "5000")
+	params.Set("_synchronous", "NORMAL")
+	params.Set("_txlock", "immediate")
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("sqlite store: open database: %w", err)
+	}
+	store, err := newSQLStore(db, sqliteDialect)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return store, nil
+}
@@ -0,0 +1,95 @@
+package device
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStorePersistsBindings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bindings.db")
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+
+	if _, err = store.AddDeviceWithinLimit("sk-test-key", "laptop", "client_id", "203.0.113.7", false, 10); err != nil {
+		t.Fatalf("AddDeviceWithinLimit: %v", err)
+	}
+	if _, err = store.AddDeviceWithinLimit("sk-test-key", "phone", "client_id", "203.0.113.8", true, 10); err != nil {
+		t.Fatalf("AddDeviceWithinLimit pending: %v", err)
+	}
+	if _, err = store.SetMetadata("sk-test-key", "", map[string]string{"tier": "gold"}, false); err != nil {
+		t.Fatalf("SetMetadata: %v", err)
+	}
+	maxDevices := 3
+	if err = store.SetPolicy("sk-test-key", &Policy{MaxDevices: maxDevices}); err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+	if err = store.UpdateLastSeen("sk-test-key", "laptop", "198.51.100.1"); err != nil {
+		t.Fatalf("UpdateLastSeen: %v", err)
+	}
+	if strikes, errStrike := store.AddStrike("sk-test-key", 0); errStrike != nil || strikes != 1 {
+		t.Fatalf("AddStrike = %d, %v", strikes, errStrike)
+	}
+	if err = store.Ban("sk-test-key", "shared", time.Hour, "203.0.113.7", "198.51.100.1"); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+	if err = store.BanDevice("sk-test-key", DeviceBan{DeviceID: "phone", Reason: "stolen", BannedAt: time.Now()}); err != nil {
+		t.Fatalf("BanDevice: %v", err)
+	}
+	if found, errAttest := store.SetAttestation("sk-test-key", "phone", &Attestation{Platform: AttestationPlatformAndroid, Verdict: AttestationTrusted, VerifiedAt: time.Now()}); errAttest != nil || !found {
+		t.Fatalf("SetAttestation = %v, %v", found, errAttest)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Reopening must not re-run migrations or lose data.
+	store, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	binding, ok := store.Get("sk-test-key")
+	if !ok {
+		t.Fatal("expected binding after reopen")
+	}
+	if len(binding.Devices) != 2 || binding.Devices[0].DeviceID != "laptop" || !binding.Devices[1].Pending || !binding.Devices[1].Attestation.Trusted() {
+		t.Fatalf("unexpected devices: %+v", binding.Devices)
+	}
+	if binding.LastIP != "198.51.100.1" || binding.Devices[0].LastIP != "198.51.100.1" {
+		t.Fatalf("expected last IP to be updated, got %q / %q", binding.LastIP, binding.Devices[0].LastIP)
+	}
+	if !binding.Banned || binding.BanReason != "shared" || binding.BanExpiresAt.IsZero() || binding.Strikes != 1 {
+		t.Fatalf("unexpected ban state: %+v", binding)
+	}
+	if len(binding.BanHistory) != 1 || len(binding.BanHistory[0].IPs) != 2 {
+		t.Fatalf("unexpected ban history: %+v", binding.BanHistory)
+	}
+	if len(binding.DeviceBans) != 1 || binding.DeviceBans[0].DeviceID != "phone" || binding.DeviceBans[0].Reason != "stolen" {
+		t.Fatalf("unexpected device bans: %+v", binding.DeviceBans)
+	}
+	if binding.Metadata["tier"] != "gold" || binding.Policy == nil || binding.Policy.MaxDevices != maxDevices {
+		t.Fatalf("unexpected metadata or policy: %+v %+v", binding.Metadata, binding.Policy)
+	}
+
+	if approved, errApprove := store.Approve("sk-test-key", "phone"); errApprove != nil || !approved {
+		t.Fatalf("Approve = %v, %v", approved, errApprove)
+	}
+	if removed, errRemove := store.RemoveDevice("sk-test-key", "laptop"); errRemove != nil || !removed {
+		t.Fatalf("RemoveDevice = %v, %v", removed, errRemove)
+	}
+	all := store.GetAll()
+	if got := all["sk-test-key"].Devices; len(got) != 1 || got[0].DeviceID != "phone" || got[0].Pending {
+		t.Fatalf("unexpected devices after approve/remove: %+v", got)
+	}
+
+	if deleted, errDelete := store.Delete("sk-test-key"); errDelete != nil || !deleted {
+		t.Fatalf("Delete = %v, %v", deleted, errDelete)
+	}
+	if _, ok = store.Get("sk-test-key"); ok {
+		t.Fatal("expected binding to be deleted")
+	}
+}
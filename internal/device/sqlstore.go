@@ -0,0 +1,638 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const sqlQueryTimeout = 10 * time.Second
+
+// sqlDialect captures the differences between the SQL backends.
+type sqlDialect struct {
+	name string
+	// timeType is the column type used for timestamps.
+	timeType string
+	// numbered placeholders ($1, $2, ...) instead of '?'.
+	numbered bool
+	// lockClause is appended to row reads inside write transactions.
+	lockClause string
+	// migrationLock and migrationUnlock serialize migrations across nodes sharing a database.
+	migrationLock   string
+	migrationUnlock string
+}
+
+// sqlMigrations are applied in order; the index+1 is the schema version.
+var sqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS device_bindings (
+		api_key TEXT PRIMARY KEY,
+		first_seen {time} NOT NULL,
+		last_seen {time} NOT NULL,
+		last_ip TEXT NOT NULL DEFAULT '',
+		banned BOOLEAN NOT NULL DEFAULT FALSE,
+		ban_reason TEXT NOT NULL DEFAULT '',
+		banned_at {time},
+		ban_expires_at {time},
+		strikes INTEGER NOT NULL DEFAULT 0,
+		last_strike_at {time},
+		metadata TEXT,
+		policy TEXT
+	);
+	CREATE TABLE IF NOT EXISTS device_binding_devices (
+		api_key TEXT NOT NULL,
+		device_id TEXT NOT NULL,
+		type TEXT NOT NULL DEFAULT '',
+		first_seen {time} NOT NULL,
+		last_seen {time} NOT NULL,
+		last_ip TEXT NOT NULL DEFAULT '',
+		pending BOOLEAN NOT NULL DEFAULT FALSE,
+		metadata TEXT,
+		PRIMARY KEY (api_key, device_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_device_binding_devices_device_id ON device_binding_devices (device_id);
+	CREATE INDEX IF NOT EXISTS idx_device_bindings_banned ON device_bindings (banned)`,
+	// v2: append-only ban history per key
+	`ALTER TABLE device_bindings ADD COLUMN ban_history TEXT`,
+	// v3: daily token usage per key and model
+	`CREATE TABLE IF NOT EXISTS key_usage_daily (
+		day TEXT NOT NULL,
+		api_key TEXT NOT NULL,
+		model TEXT NOT NULL,
+		requests BIGINT NOT NULL DEFAULT 0,
+		input_tokens BIGINT NOT NULL DEFAULT 0,
+		output_tokens BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, api_key, model)
+	);
+	CREATE INDEX IF NOT EXISTS idx_key_usage_daily_api_key ON key_usage_daily (api_key, day)`,
+	// v4: pinned client TLS fingerprints per key
+	`ALTER TABLE device_bindings ADD COLUMN tls_fingerprints TEXT`,
+	// v5: per-device and per-IP bans per key
+	`ALTER TABLE device_bindings ADD COLUMN device_bans TEXT`,
+	// v6: attestation verdict per device
+	`ALTER TABLE device_binding_devices ADD COLUMN attestation TEXT`,
+}
+
+// sqlStore implements Store on top of database/sql. Binding rules stay in
+// DeviceBindings: writes load the affected key inside a transaction, apply
+// the same mutation the file store uses, and write the key back.
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+
+	historyRetention time.Duration
+}
+
+func newSQLStore(db *sql.DB, dialect sqlDialect) (*sqlStore, error) {
+	s := &sqlStore{db: db, dialect: dialect}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// q rewrites '?' placeholders for dialects that use numbered placeholders.
+func (s *sqlStore) q(query string) string {
+	if !s.dialect.numbered {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func (s *sqlStore) migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlQueryTimeout)
+	defer cancel()
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("%s store: acquire connection: %w", s.dialect.name, err)
+	}
+	defer func() { _ = conn.Close() }()
+	if s.dialect.migrationLock != "" {
+		if _, err = conn.ExecContext(ctx, s.dialect.migrationLock); err != nil {
+			return fmt.Errorf("%s store: lock migrations: %w", s.dialect.name, err)
+		}
+		defer func() { _, _ = conn.ExecContext(context.Background(), s.dialect.migrationUnlock) }()
+	}
+
+	if _, err = conn.ExecContext(ctx, strings.ReplaceAll(`CREATE TABLE IF NOT EXISTS device_schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at {time} NOT NULL
+	)`, "{time}", s.dialect.timeType)); err != nil {
+		return fmt.Errorf("%s store: create migrations table: %w", s.dialect.name, err)
+	}
+	var current int
+	if err = conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM device_schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("%s store: read schema version: %w", s.dialect.name, err)
+	}
+	for i := current; i < len(sqlMigrations); i++ {
+		version := i + 1
+		tx, errBegin := conn.BeginTx(ctx, nil)
+		if errBegin != nil {
+			return errBegin
+		}
+		for _, stmt := range strings.Split(sqlMigrations[i], ";") {
+			if strings.TrimSpace(stmt) == "" {
+				continue
+			}
+			if _, err = tx.ExecContext(ctx, strings.ReplaceAll(stmt, "{time}", s.dialect.timeType)); err != nil {
+				_ = tx.Rollback()
+				return fmt.Errorf("%s store: migration %d: %w", s.dialect.name, version, err)
+			}
+		}
+		if _, err = tx.ExecContext(ctx, s.q("INSERT INTO device_schema_migrations (version, applied_at) VALUES (?, ?)"), version, time.Now().UTC()); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("%s store: record migration %d: %w", s.dialect.name, version, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return fmt.Errorf("%s store: commit migration %d: %w", s.dialect.name, version, err)
+		}
+		log.Infof("device-binding: applied %s schema migration %d", s.dialect.name, version)
+	}
+	return nil
+}
+
+// queryer is satisfied by *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+const bindingColumns = "api_key, first_seen, last_seen, last_ip, banned, ban_reason, banned_at, ban_expires_at, strikes, last_strike_at, metadata, policy, ban_history, tls_fingerprints, device_bans"
+const deviceColumns = "api_key, device_id, type, first_seen, last_seen, last_ip, pending, metadata, attestation"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBinding(row rowScanner) (string, DeviceBinding, error) {
+	var (
+		apiKey                             string
+		b                                  DeviceBinding
+		bannedAt, banExpiresAt, lastStrike sql.NullTime
+		metadata, policy, history          sql.NullString
+		fingerprints, deviceBans           sql.NullString
+	)
+	if err := row.Scan(&apiKey, &b.FirstSeen, &b.LastSeen, &b.LastIP, &b.Banned, &b.BanReason,
+		&bannedAt, &banExpiresAt, &b.Strikes, &lastStrike, &metadata, &policy, &history, &fingerprints, &deviceBans); err != nil {
+		return "", DeviceBinding{}, err
+	}
+	b.BannedAt = bannedAt.Time
+	b.BanExpiresAt = banExpiresAt.Time
+	b.LastStrikeAt = lastStrike.Time
+	if err := decodeJSONColumn(metadata, &b.Metadata); err != nil {
+		return "", DeviceBinding{}, err
+	}
+	if err := decodeJSONColumn(policy, &b.Policy); err != nil {
+		return "", DeviceBinding{}, err
+	}
+	if err := decodeJSONColumn(history, &b.BanHistory); err != nil {
+		return "", DeviceBinding{}, err
+	}
+	if err := decodeJSONColumn(fingerprints, &b.TLSFingerprints); err != nil {
+		return "", DeviceBinding{}, err
+	}
+	if err := decodeJSONColumn(deviceBans, &b.DeviceBans); err != nil {
+		return "", DeviceBinding{}, err
+	}
+	return apiKey, b, nil
+}
+
+func scanDevice(row rowScanner) (string, Device, error) {
+	var (
+		apiKey      string
+		d           Device
+		metadata    sql.NullString
+		attestation sql.NullString
+	)
+	if err := row.Scan(&apiKey, &d.DeviceID, &d.Type, &d.FirstSeen, &d.LastSeen, &d.LastIP, &d.Pending, &metadata, &attestation); err != nil {
+		return "", Device{}, err
+	}
+	if err := decodeJSONColumn(metadata, &d.Metadata); err != nil {
+		return "", Device{}, err
+	}
+	if err := decodeJSONColumn(attestation, &d.Attestation); err != nil {
+		return "", Device{}, err
+	}
+	return apiKey, d, nil
+}
+
+func decodeJSONColumn(value sql.NullString, out any) error {
+	if !value.Valid || value.String == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(value.String), out)
+}
+
+func encodeJSONColumn(value any, empty bool) (any, error) {
+	if empty {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// load reads one binding and its devices. lock takes a row lock where supported.
+func (s *sqlStore) load(ctx context.Context, q queryer, apiKey string, lock bool) (DeviceBinding, bool, error) {
+	query := "SELECT " + bindingColumns + " FROM device_bindings WHERE api_key = ?"
+	if lock {
+		query += s.dialect.lockClause
+	}
+	_, binding, err := scanBinding(q.QueryRowContext(ctx, s.q(query), apiKey))
+	if errors.Is(err, sql.ErrNoRows) {
+		return DeviceBinding{}, false, nil
+	}
+	if err != nil {
+		return DeviceBinding{}, false, err
+	}
+	rows, err := q.QueryContext(ctx, s.q("SELECT "+deviceColumns+" FROM device_binding_devices WHERE api_key = ? ORDER BY first_seen, device_id"), apiKey)
+	if err != nil {
+		return DeviceBinding{}, false, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		_, dev, errScan := scanDevice(rows)
+		if errScan != nil {
+			return DeviceBinding{}, false, errScan
+		}
+		binding.Devices = append(binding.Devices, dev)
+	}
+	return binding, true, rows.Err()
+}
+
+// write replaces the stored binding of apiKey with binding.
+func (s *sqlStore) write(ctx context.Context, q queryer, apiKey string, binding DeviceBinding) error {
+	metadata, err := encodeJSONColumn(binding.Metadata, len(binding.Metadata) == 0)
+	if err != nil {
+		return err
+	}
+	policy, err := encodeJSONColumn(binding.Policy, binding.Policy == nil)
+	if err != nil {
+		return err
+	}
+	history, err := encodeJSONColumn(binding.BanHistory, len(binding.BanHistory) == 0)
+	if err != nil {
+		return err
+	}
+	fingerprints, err := encodeJSONColumn(binding.TLSFingerprints, len(binding.TLSFingerprints) == 0)
+	if err != nil {
+		return err
+	}
+	deviceBans, err := encodeJSONColumn(binding.DeviceBans, len(binding.DeviceBans) == 0)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, s.q(`INSERT INTO device_bindings (`+bindingColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (api_key) DO UPDATE SET
+			first_seen = excluded.first_seen,
+			last_seen = excluded.last_seen,
+			last_ip = excluded.last_ip,
+			banned = excluded.banned,
+			ban_reason = excluded.ban_reason,
+			banned_at = excluded.banned_at,
+			ban_expires_at = excluded.ban_expires_at,
+			strikes = excluded.strikes,
+			last_strike_at = excluded.last_strike_at,
+			metadata = excluded.metadata,
+			policy = excluded.policy,
+			ban_history = excluded.ban_history,
+			tls_fingerprints = excluded.tls_fingerprints,
+			device_bans = excluded.device_bans`),
+		apiKey, binding.FirstSeen.UTC(), binding.LastSeen.UTC(), binding.LastIP, binding.Banned, binding.BanReason,
+		nullTime(binding.BannedAt), nullTime(binding.BanExpiresAt), binding.Strikes, nullTime(binding.LastStrikeAt), metadata, policy, history, fingerprints, deviceBans)
+	if err != nil {
+		return err
+	}
+	if _, err = q.ExecContext(ctx, s.q("DELETE FROM device_binding_devices WHERE api_key = ?"), apiKey); err != nil {
+		return err
+	}
+	for _, dev := range binding.Devices {
+		devMetadata, errEncode := encodeJSONColumn(dev.Metadata, len(dev.Metadata) == 0)
+		if errEncode != nil {
+			return errEncode
+		}
+		attestation, errEncode := encodeJSONColumn(dev.Attestation, dev.Attestation == nil)
+		if errEncode != nil {
+			return errEncode
+		}
+		if _, err = q.ExecContext(ctx, s.q("INSERT INTO device_binding_devices ("+deviceColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+			apiKey, dev.DeviceID, dev.Type, dev.FirstSeen.UTC(), dev.LastSeen.UTC(), dev.LastIP, dev.Pending, devMetadata, attestation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) remove(ctx context.Context, q queryer, apiKey string) error {
+	if _, err := q.ExecContext(ctx, s.q("DELETE FROM device_binding_devices WHERE api_key = ?"), apiKey); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx, s.q("DELETE FROM device_bindings WHERE api_key = ?"), apiKey)
+	return err
+}
+
+// mutate applies fn to the binding of apiKey inside a transaction and writes
+// the result back when fn reports a change.
+func (s *sqlStore) mutate(apiKey string, fn func(d *DeviceBindings) bool) (bool, error) {
+	return s.mutateBinding(apiKey, false, fn)
+}
+
+// mutateBinding is mutate; with create it first inserts a missing binding row
+// so the row lock also serializes writers racing to create the binding. The
+// insert is rolled back with the rest of the transaction when fn changes nothing.
+func (s *sqlStore) mutateBinding(apiKey string, create bool, fn func(d *DeviceBindings) bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlQueryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if create {
+		now := time.Now().UTC()
+		if _, err = tx.ExecContext(ctx, s.q(`INSERT INTO device_bindings (api_key, first_seen, last_seen, last_ip)
+			VALUES (?, ?, ?, ?) ON CONFLICT (api_key) DO NOTHING`), apiKey, now, now, ""); err != nil {
+			return false, err
+		}
+	}
+	binding, exists, err := s.load(ctx, tx, apiKey, true)
+	if err != nil {
+		return false, err
+	}
+	bindings := NewDeviceBindings()
+	if exists {
+		bindings.Bindings[apiKey] = binding
+	}
+	if !fn(bindings) {
+		return false, nil
+	}
+	if updated, ok := bindings.Bindings[apiKey]; ok {
+		err = s.write(ctx, tx, apiKey, updated)
+	} else if exists {
+		err = s.remove(ctx, tx, apiKey)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Get returns the binding for an API key
+func (s *sqlStore) Get(apiKey string) (DeviceBinding, bool) {
+	binding, exists, err := s.Lookup(apiKey)
+	if err != nil {
+		log.Warnf("device-binding: failed to load binding for %s: %v", MaskKey(apiKey), err)
+		return DeviceBinding{}, false
+	}
+	return binding, exists
+}
+
+// Lookup returns the binding for an API key and the database error, if any
+func (s *sqlStore) Lookup(apiKey string) (DeviceBinding, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlQueryTimeout)
+	defer cancel()
+	return s.load(ctx, s.db, apiKey, false)
+}
+
+// GetAll returns a copy of all bindings
+func (s *sqlStore) GetAll() map[string]DeviceBinding {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlQueryTimeout)
+	defer cancel()
+	result := make(map[string]DeviceBinding)
+	if err := s.loadAll(ctx, result); err != nil {
+		log.Warnf("device-binding: failed to load bindings: %v", err)
+	}
+	return result
+}
+
+func (s *sqlStore) loadAll(ctx context.Context, result map[string]DeviceBinding) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+bindingColumns+" FROM device_bindings")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		apiKey, binding, errScan := scanBinding(rows)
+		if errScan != nil {
+			_ = rows.Close()
+			return errScan
+		}
+		result[apiKey] = binding
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.db.QueryContext(ctx, "SELECT "+deviceColumns+" FROM device_binding_devices ORDER BY api_key, first_seen, device_id")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		apiKey, dev, errScan := scanDevice(rows)
+		if errScan != nil {
+			return errScan
+		}
+		if binding, ok := result[apiKey]; ok {
+			binding.Devices = append(binding.Devices, dev)
+			result[apiKey] = binding
+		}
+	}
+	return rows.Err()
+}
+
+// AddDeviceWithinLimit registers a device unless the key already holds limit devices
+func (s *sqlStore) AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP string, pending bool, limit int) (bool, error) {
+	return s.mutateBinding(apiKey, true, func(d *DeviceBindings) bool {
+		return d.AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP, pending, limit)
+	})
+}
+
+// Approve marks a pending device as approved
+func (s *sqlStore) Approve(apiKey, deviceID string) (bool, error) {
+	return s.mutate(apiKey, func(d *DeviceBindings) bool {
+		return d.ApproveDevice(apiKey, deviceID)
+	})
+}
+
+// SetMetadata replaces or merges metadata for an API key or one of its devices
+func (s *sqlStore) SetMetadata(apiKey, deviceID string, metadata map[string]string, merge bool) (bool, error) {
+	return s.mutate(apiKey, func(d *DeviceBindings) bool {
+		return d.SetMetadata(apiKey, deviceID, metadata, merge)
+	})
+}
+
+// SetAttestation records the attestation verdict of a device
+func (s *sqlStore) SetAttestation(apiKey, deviceID string, attestation *Attestation) (bool, error) {
+	return s.mutate(apiKey, func(d *DeviceBindings) bool {
+		return d.SetAttestation(apiKey, deviceID, attestation)
+	})
+}
+
+// SetPolicy replaces the policy overrides of an API key
+func (s *sqlStore) SetPolicy(apiKey string, policy *Policy) error {
+	_, err := s.mutate(apiKey, func(d *DeviceBindings) bool {
+		d.SetPolicy(apiKey, policy)
+		return true
+	})
+	return err
+}
+
+// PinTLSFingerprint pins a client TLS fingerprint to an API key
+func (s *sqlStore) PinTLSFingerprint(apiKey, fingerprint string, limit int) (bool, error) {
+	return s.mutate(apiKey, func(d *DeviceBindings) bool {
+		return d.PinTLSFingerprint(apiKey, fingerprint, limit)
+	})
+}
+
+// ClearTLSFingerprints removes the pinned TLS fingerprints of an API key
+func (s *sqlStore) ClearTLSFingerprints(apiKey string) (bool, error) {
+	return s.mutate(apiKey, func(d *DeviceBindings) bool {
+		return d.ClearTLSFingerprints(apiKey)
+	})
+}
+
+// RemoveDevice removes a single device from an API key
+func (s *sqlStore) RemoveDevice(apiKey, deviceID string) (bool, error) {
+	return s.mutate(apiKey, func(d *DeviceBindings) bool {
+		return d.RemoveDevice(apiKey, deviceID)
+	})
+}
+
+// UpdateLastSeen updates the last_seen timestamp of a device. It runs on every
+// request, so it updates the two rows in place instead of rewriting the binding.
+func (s *sqlStore) UpdateLastSeen(apiKey, deviceID, currentIP string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlQueryTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err = tx.ExecContext(ctx, s.q("UPDATE device_bindings SET last_seen = ?, last_ip = ? WHERE api_key = ?"), now, currentIP, apiKey); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, s.q("UPDATE device_binding_devices SET last_seen = ?, last_ip = ? WHERE api_key = ? AND device_id = ?"), now, currentIP, apiKey, deviceID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Ban marks an API key as banned. A positive duration makes the ban temporary.
+func (s *sqlStore) Ban(apiKey, reason string, duration time.Duration, ips ...string) error {
+	_, err := s.mutate(apiKey, func(d *DeviceBindings) bool {
+		d.Ban(apiKey, reason, duration, ips...)
+		pruneHistory(d, apiKey, s.historyRetention)
+		return true
+	})
+	if err == nil {
+		recordBan(duration)
+	}
+	return err
+}
+
+// BanDevice bans a device or IP of an API key
+func (s *sqlStore) BanDevice(apiKey string, ban DeviceBan) error {
+	_, err := s.mutate(apiKey, func(d *DeviceBindings) bool {
+		d.BanDevice(apiKey, ban)
+		return true
+	})
+	return err
+}
+
+// UnbanDevice lifts the ban of a device or IP of an API key
+func (s *sqlStore) UnbanDevice(apiKey, deviceID, ip string) (bool, error) {
+	return s.mutate(apiKey, func(d *DeviceBindings) bool {
+		return d.UnbanDevice(apiKey, deviceID, ip)
+	})
+}
+
+// AddStrike records a concurrent-usage violation and returns the strike count
+func (s *sqlStore) AddStrike(apiKey string, resetAfter time.Duration) (int, error) {
+	strikes := 0
+	_, err := s.mutate(apiKey, func(d *DeviceBindings) bool {
+		strikes = d.AddStrike(apiKey, resetAfter)
+		return strikes > 0
+	})
+	return strikes, err
+}
+
+// Unban removes ban from an API key
+func (s *sqlStore) Unban(apiKey, actor string) error {
+	_, err := s.mutate(apiKey, func(d *DeviceBindings) bool {
+		if _, exists := d.Bindings[apiKey]; !exists {
+			return false
+		}
+		d.Unban(apiKey, actor)
+		pruneHistory(d, apiKey, s.historyRetention)
+		return true
+	})
+	return err
+}
+
+// Delete removes a binding
+func (s *sqlStore) Delete(apiKey string) (bool, error) {
+	return s.mutate(apiKey, func(d *DeviceBindings) bool {
+		return d.Delete(apiKey)
+	})
+}
+
+// Clear removes all bindings
+func (s *sqlStore) Clear() error {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlQueryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err = tx.ExecContext(ctx, "DELETE FROM device_binding_devices"); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM device_bindings"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) setHistoryRetention(retention time.Duration) {
+	s.historyRetention = retention
+}
+
+// Close releases the database connection
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
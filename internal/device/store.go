@@ -1,9 +1,12 @@
 package device
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -11,22 +14,208 @@ import (
 
 const (
 	bindingsFileName = "device-bindings.yaml"
-	fileHeader       = "# Auto-generated by CLIProxyAPI - DO NOT EDIT MANUALLY\n# Device bindings: maps API key -> device identifier\n\n"
+	fileHeader       = "# Auto-generated by CLIProxyAPI - DO NOT EDIT MANUALLY\n# Device bindings: maps API key -> device identifiers\n\n"
 )
 
-// Store manages persistence of device bindings
-type Store struct {
+// Store persists device bindings. Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the binding for an API key
+	Get(apiKey string) (DeviceBinding, bool)
+	// GetAll returns a copy of all bindings
+	GetAll() map[string]DeviceBinding
+	// AddDeviceWithinLimit registers a device, pending approval if pending is set,
+	// unless the key already holds limit devices. The check and the write are one
+	// atomic update; it reports whether the device is registered.
+	AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP string, pending bool, limit int) (bool, error)
+	// Approve marks a pending device as approved
+	Approve(apiKey, deviceID string) (bool, error)
+	// SetMetadata replaces or merges metadata for an API key or one of its devices
+	SetMetadata(apiKey, deviceID string, metadata map[string]string, merge bool) (bool, error)
+	// SetAttestation records the attestation verdict of a device
+	SetAttestation(apiKey, deviceID string, attestation *Attestation) (bool, error)
+	// SetPolicy sets or clears the per-key policy override
+	SetPolicy(apiKey string, policy *Policy) error
+	// PinTLSFingerprint pins a client TLS fingerprint unless the key already holds limit fingerprints
+	PinTLSFingerprint(apiKey, fingerprint string, limit int) (bool, error)
+	// ClearTLSFingerprints removes all pinned TLS fingerprints of an API key
+	ClearTLSFingerprints(apiKey string) (bool, error)
+	// RemoveDevice removes a single device from an API key
+	RemoveDevice(apiKey, deviceID string) (bool, error)
+	// UpdateLastSeen updates the last_seen timestamp and IP of a device
+	UpdateLastSeen(apiKey, deviceID, currentIP string) error
+	// Ban marks an API key as banned and records it in the ban history. A positive
+	// duration makes the ban temporary; ips are the addresses that triggered it.
+	Ban(apiKey, reason string, duration time.Duration, ips ...string) error
+	// BanDevice bans a single device or client IP of an API key, leaving its other devices usable
+	BanDevice(apiKey string, ban DeviceBan) error
+	// UnbanDevice lifts the ban of a device or IP of an API key
+	UnbanDevice(apiKey, deviceID, ip string) (bool, error)
+	// AddStrike records a concurrent-usage violation and returns the strike count
+	AddStrike(apiKey string, resetAfter time.Duration) (int, error)
+	// Unban removes ban from an API key, recording actor in the ban history
+	Unban(apiKey, actor string) error
+	// Delete removes a binding
+	Delete(apiKey string) (bool, error)
+	// Clear removes all bindings
+	Clear() error
+	// Close releases resources held by the backend
+	Close() error
+}
+
+// BindingLookup is implemented by stores whose reads can fail, so callers can
+// tell a key without a binding from a store that could not be read
+type BindingLookup interface {
+	Lookup(apiKey string) (DeviceBinding, bool, error)
+}
+
+// LookupBinding returns the binding of an API key and the read error of the
+// store, if any. Stores that cannot fail to read always return a nil error.
+func LookupBinding(store Store, apiKey string) (DeviceBinding, bool, error) {
+	if lookup, ok := store.(BindingLookup); ok {
+		return lookup.Lookup(apiKey)
+	}
+	binding, exists := store.Get(apiKey)
+	return binding, exists, nil
+}
+
+// Store backends
+const (
+	BackendYAML     = "yaml"
+	BackendSQLite   = "sqlite"
+	BackendPostgres = "postgres"
+)
+
+// StoreConfig selects and configures the persistence backend
+type StoreConfig struct {
+	// Backend is "yaml" (default), "sqlite" or "postgres"
+	Backend string
+	// Dir is the directory holding the YAML file and the default SQLite database
+	Dir string
+	// Path overrides the SQLite database file path
+	Path string
+	// Postgres configures the PostgreSQL backend
+	Postgres PostgresConfig
+	// HistoryRetention drops ban history entries older than this whenever a key's
+	// ban state changes; zero keeps history forever
+	HistoryRetention time.Duration
+	// DualWrite, when set, opens a second store that receives a copy of every
+	// write while reads stay on this one; OpenStore then returns a *DualStore
+	DualWrite *StoreConfig
+}
+
+// historyRetainer is implemented by stores that prune ban history
+type historyRetainer interface {
+	setHistoryRetention(retention time.Duration)
+}
+
+// OpenStore creates the store selected by cfg
+func OpenStore(cfg StoreConfig) (Store, error) {
+	var (
+		store Store
+		err   error
+	)
+	switch strings.ToLower(strings.TrimSpace(cfg.Backend)) {
+	case "", BackendYAML:
+		var fileStore *FileStore
+		if fileStore, err = NewFileStore(cfg.Dir); err == nil {
+			store = fileStore
+		}
+	case BackendSQLite:
+		path := strings.TrimSpace(cfg.Path)
+		if path == "" {
+			path = filepath.Join(cfg.Dir, sqliteFileName)
+		}
+		store, err = NewSQLiteStore(path)
+	case BackendPostgres, "postgresql":
+		store, err = NewPostgresStore(cfg.Postgres)
+	default:
+		return nil, fmt.Errorf("unsupported device binding store backend %q", cfg.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if retainer, ok := store.(historyRetainer); ok {
+		retainer.setHistoryRetention(cfg.HistoryRetention)
+	}
+	if cfg.DualWrite == nil {
+		return store, nil
+	}
+	mirrorCfg := *cfg.DualWrite
+	mirrorCfg.DualWrite = nil
+	mirrorCfg.HistoryRetention = cfg.HistoryRetention
+	if storeLocation(cfg) == storeLocation(mirrorCfg) {
+		_ = store.Close()
+		return nil, fmt.Errorf("dual-write store is the same as the primary store")
+	}
+	mirror, err := OpenStore(mirrorCfg)
+	if err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("open dual-write store: %w", err)
+	}
+	dual, err := NewDualStore(store, mirror, backendName(cfg.Backend), backendName(mirrorCfg.Backend))
+	if err != nil {
+		_ = store.Close()
+		_ = mirror.Close()
+		return nil, err
+	}
+	return dual, nil
+}
+
+// storeLocation identifies the data a store configuration points at
+func storeLocation(cfg StoreConfig) string {
+	switch backend := backendName(cfg.Backend); backend {
+	case BackendSQLite:
+		path := strings.TrimSpace(cfg.Path)
+		if path == "" {
+			path = filepath.Join(cfg.Dir, sqliteFileName)
+		}
+		return backend + ":" + filepath.Clean(path)
+	case BackendPostgres:
+		return backend + ":" + cfg.Postgres.DSN
+	default:
+		return backend + ":" + filepath.Clean(cfg.Dir)
+	}
+}
+
+// backendName normalises a configured backend name
+func backendName(backend string) string {
+	switch name := strings.ToLower(strings.TrimSpace(backend)); name {
+	case "":
+		return BackendYAML
+	case "postgresql":
+		return BackendPostgres
+	default:
+		return name
+	}
+}
+
+// pruneHistory applies the retention period to a key's ban history
+func pruneHistory(d *DeviceBindings, apiKey string, retention time.Duration) {
+	if retention > 0 {
+		d.PruneHistory(apiKey, time.Now().Add(-retention))
+	}
+}
+
+// FileStore persists device bindings to a YAML file in the auth directory
+type FileStore struct {
 	mu       sync.RWMutex
 	filePath string
 	bindings *DeviceBindings
+
+	historyRetention time.Duration
+
+	// usageMu guards keyUsage, persisted separately in key-usage.yaml
+	usageMu  sync.Mutex
+	keyUsage map[keyUsageID]KeyUsage
 }
 
-// NewStore creates a new Store instance
-func NewStore(authDir string) (*Store, error) {
+// NewFileStore creates a YAML file backed store
+func NewFileStore(authDir string) (*FileStore, error) {
 	filePath := filepath.Join(authDir, bindingsFileName)
-	store := &Store{
+	store := &FileStore{
 		filePath: filePath,
 		bindings: NewDeviceBindings(),
+		keyUsage: make(map[keyUsageID]KeyUsage),
 	}
 
 	// Load existing bindings if file exists
@@ -34,12 +223,15 @@ func NewStore(authDir string) (*Store, error) {
 		log.Warnf("device-binding: failed to load bindings from %s: %v", filePath, err)
 		// Continue with empty bindings
 	}
+	if err := store.loadKeyUsage(); err != nil {
+		log.Warnf("device-binding: failed to load key usage: %v", err)
+	}
 
 	return store, nil
 }
 
 // load reads bindings from YAML file
-func (s *Store) load() error {
+func (s *FileStore) load() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -58,15 +250,13 @@ func (s *Store) load() error {
 		return nil
 	}
 
-	if bindings.Bindings == nil {
-		bindings.Bindings = make(map[string]DeviceBinding)
-	}
+	bindings.normalize()
 	s.bindings = &bindings
 	return nil
 }
 
 // save writes bindings to YAML file
-func (s *Store) save() error {
+func (s *FileStore) save() error {
 	// Ensure directory exists
 	dir := filepath.Dir(s.filePath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -83,50 +273,110 @@ func (s *Store) save() error {
 }
 
 // Get returns the binding for an API key
-func (s *Store) Get(apiKey string) (DeviceBinding, bool) {
+func (s *FileStore) Get(apiKey string) (DeviceBinding, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.bindings.Get(apiKey)
 }
 
-// Save creates a new binding for an API key and persists to disk
-func (s *Store) Save(apiKey, deviceID, deviceType string) error {
+// AddDeviceWithinLimit registers a device unless the key already holds limit devices and persists
+func (s *FileStore) AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP string, pending bool, limit int) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.bindings.Set(apiKey, deviceID, deviceType)
-	return s.save()
+	if !s.bindings.AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP, pending, limit) {
+		return false, nil
+	}
+	return true, s.save()
 }
 
-// UpdateLastSeen updates the last_seen timestamp and persists
-func (s *Store) UpdateLastSeen(apiKey string, currentIP string) error {
+// Approve marks a pending device as approved and persists
+func (s *FileStore) Approve(apiKey, deviceID string) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.bindings.UpdateLastSeen(apiKey, currentIP)
-	return s.save()
+	approved := s.bindings.ApproveDevice(apiKey, deviceID)
+	if approved {
+		if err := s.save(); err != nil {
+			return false, err
+		}
+	}
+	return approved, nil
+}
+
+// SetMetadata replaces or merges metadata for an API key or one of its devices and persists
+func (s *FileStore) SetMetadata(apiKey, deviceID string, metadata map[string]string, merge bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := s.bindings.SetMetadata(apiKey, deviceID, metadata, merge)
+	if found {
+		if err := s.save(); err != nil {
+			return false, err
+		}
+	}
+	return found, nil
 }
 
-// Ban marks an API key as banned and persists
-func (s *Store) Ban(apiKey, reason string) error {
+// RemoveDevice removes a single device from an API key and persists
+func (s *FileStore) RemoveDevice(apiKey, deviceID string) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.bindings.Ban(apiKey, reason)
+	removed := s.bindings.RemoveDevice(apiKey, deviceID)
+	if removed {
+		if err := s.save(); err != nil {
+			return false, err
+		}
+	}
+	return removed, nil
+}
+
+// UpdateLastSeen updates the last_seen timestamp of a device and persists
+func (s *FileStore) UpdateLastSeen(apiKey, deviceID, currentIP string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bindings.UpdateLastSeen(apiKey, deviceID, currentIP)
 	return s.save()
 }
 
+// Ban marks an API key as banned, creating its binding if needed, and persists.
+// A positive duration makes the ban temporary.
+func (s *FileStore) Ban(apiKey, reason string, duration time.Duration, ips ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bindings.Ban(apiKey, reason, duration, ips...)
+	pruneHistory(s.bindings, apiKey, s.historyRetention)
+	if err := s.save(); err != nil {
+		return err
+	}
+	recordBan(duration)
+	return nil
+}
+
+// AddStrike records a concurrent-usage violation and persists, returning the strike count
+func (s *FileStore) AddStrike(apiKey string, resetAfter time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	strikes := s.bindings.AddStrike(apiKey, resetAfter)
+	return strikes, s.save()
+}
+
 // Unban removes ban from an API key and persists
-func (s *Store) Unban(apiKey string) error {
+func (s *FileStore) Unban(apiKey, actor string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.bindings.Unban(apiKey)
+	s.bindings.Unban(apiKey, actor)
+	pruneHistory(s.bindings, apiKey, s.historyRetention)
 	return s.save()
 }
 
 // Delete removes a binding and persists
-func (s *Store) Delete(apiKey string) (bool, error) {
+func (s *FileStore) Delete(apiKey string) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -140,7 +390,7 @@ func (s *Store) Delete(apiKey string) (bool, error) {
 }
 
 // Clear removes all bindings and persists
-func (s *Store) Clear() error {
+func (s *FileStore) Clear() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -149,17 +399,28 @@ func (s *Store) Clear() error {
 }
 
 // GetAll returns a copy of all bindings
-func (s *Store) GetAll() map[string]DeviceBinding {
+func (s *FileStore) GetAll() map[string]DeviceBinding {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	result := make(map[string]DeviceBinding, len(s.bindings.Bindings))
 	for k, v := range s.bindings.Bindings {
-		result[k] = v
+		result[k] = v.clone()
 	}
 	return result
 }
 
+func (s *FileStore) setHistoryRetention(retention time.Duration) {
+	s.mu.Lock()
+	s.historyRetention = retention
+	s.mu.Unlock()
+}
+
+// Close is a no-op; every change is already written to disk
+func (s *FileStore) Close() error {
+	return nil
+}
+
 // MaskKey masks an API key for logging (shows first 4 and last 4 chars)
 func MaskKey(key string) string {
 	if len(key) <= 8 {
@@ -0,0 +1,66 @@
+package device
+
+import (
+	"sort"
+	"sync"
+)
+
+// streamRegistry tracks the requests each device of a key currently has in
+// flight, including open streaming responses. Two devices of one key holding
+// connections at the same moment is direct evidence of sharing, independent
+// of how recently either device was last seen.
+type streamRegistry struct {
+	mu     sync.Mutex
+	active map[string]map[string]int // api key -> device ID -> open requests
+}
+
+func newStreamRegistry() *streamRegistry {
+	return &streamRegistry{active: make(map[string]map[string]int)}
+}
+
+// open registers a request of deviceID and returns the other devices of the
+// key that have requests in flight, plus a func releasing the registration.
+func (r *streamRegistry) open(apiKey, deviceID string) (others []string, release func()) {
+	r.mu.Lock()
+	devices := r.active[apiKey]
+	if devices == nil {
+		devices = make(map[string]int)
+		r.active[apiKey] = devices
+	}
+	for id := range devices {
+		if id != deviceID {
+			others = append(others, id)
+		}
+	}
+	devices[deviceID]++
+	r.mu.Unlock()
+	sort.Strings(others)
+
+	var once sync.Once
+	return others, func() { once.Do(func() { r.close(apiKey, deviceID) }) }
+}
+
+func (r *streamRegistry) close(apiKey, deviceID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	devices := r.active[apiKey]
+	if devices[deviceID] <= 1 {
+		delete(devices, deviceID)
+	} else {
+		devices[deviceID]--
+	}
+	if len(devices) == 0 {
+		delete(r.active, apiKey)
+	}
+}
+
+// ActiveStreams returns the number of in-flight requests per device of apiKey.
+func (m *Middleware) ActiveStreams(apiKey string) map[string]int {
+	m.streams.mu.Lock()
+	defer m.streams.mu.Unlock()
+	out := make(map[string]int, len(m.streams.active[apiKey]))
+	for id, count := range m.streams.active[apiKey] {
+		out[id] = count
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+package device
+
+import (
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// TLS fingerprint pinning modes
+const (
+	TLSFingerprintOff     = "off"     // Fingerprints are ignored
+	TLSFingerprintRecord  = "record"  // Fingerprints are pinned and mismatches logged
+	TLSFingerprintEnforce = "enforce" // Requests with an unpinned fingerprint are rejected
+)
+
+// ValidTLSFingerprintMode reports whether mode is a known pinning mode. Empty
+// inherits the global mode.
+func ValidTLSFingerprintMode(mode string) bool {
+	switch mode {
+	case "", TLSFingerprintOff, TLSFingerprintRecord, TLSFingerprintEnforce:
+		return true
+	}
+	return false
+}
+
+// HasTLSFingerprint reports whether fingerprint is pinned to the binding
+func (b DeviceBinding) HasTLSFingerprint(fingerprint string) bool {
+	return slices.Contains(b.TLSFingerprints, fingerprint)
+}
+
+// PinTLSFingerprint pins a TLS fingerprint to an API key unless the key already
+// holds limit fingerprints. It reports whether the fingerprint was added.
+func (d *DeviceBindings) PinTLSFingerprint(apiKey, fingerprint string, limit int) bool {
+	binding, exists := d.Bindings[apiKey]
+	if !exists || fingerprint == "" || binding.HasTLSFingerprint(fingerprint) || len(binding.TLSFingerprints) >= limit {
+		return false
+	}
+	binding.TLSFingerprints = append(append([]string(nil), binding.TLSFingerprints...), fingerprint)
+	d.Bindings[apiKey] = binding
+	return true
+}
+
+// ClearTLSFingerprints removes all pinned fingerprints of an API key so the
+// next connections pin new ones. It reports whether any were removed.
+func (d *DeviceBindings) ClearTLSFingerprints(apiKey string) bool {
+	binding, exists := d.Bindings[apiKey]
+	if !exists || len(binding.TLSFingerprints) == 0 {
+		return false
+	}
+	binding.TLSFingerprints = nil
+	d.Bindings[apiKey] = binding
+	return true
+}
+
+// PinTLSFingerprint pins a TLS fingerprint to an API key and persists
+func (s *FileStore) PinTLSFingerprint(apiKey, fingerprint string, limit int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.bindings.PinTLSFingerprint(apiKey, fingerprint, limit) {
+		return false, nil
+	}
+	return true, s.save()
+}
+
+// ClearTLSFingerprints removes the pinned fingerprints of an API key and persists
+func (s *FileStore) ClearTLSFingerprints(apiKey string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.bindings.ClearTLSFingerprints(apiKey) {
+		return false, nil
+	}
+	return true, s.save()
+}
+
+// checkTLSFingerprint pins the client's TLS fingerprint while the key has free
+// slots (one per allowed device) and reports a mismatch otherwise. It returns
+// false when the request was rejected.
+func (m *Middleware) checkTLSFingerprint(c *gin.Context, apiKey string, binding DeviceBinding, policy EffectivePolicy) bool {
+	if policy.TLSFingerprint == TLSFingerprintOff || m.config.TLSFingerprint == nil {
+		return true
+	}
+	fingerprint := m.config.TLSFingerprint(c.Request)
+	if fingerprint == "" || binding.HasTLSFingerprint(fingerprint) {
+		return true
+	}
+	if len(binding.TLSFingerprints) < policy.MaxDevices {
+		pinned, err := m.store.PinTLSFingerprint(apiKey, fingerprint, policy.MaxDevices)
+		if err != nil {
+			log.Errorf("device-binding: failed to pin tls fingerprint for key %s: %v", MaskKey(apiKey), err)
+		} else if pinned {
+			log.Infof("device-binding: pinned tls fingerprint %s for key %s", fingerprint, MaskKey(apiKey))
+			tlsFingerprintDecisions.Inc("pinned")
+		}
+		return true
+	}
+
+	log.Warnf("device-binding: tls fingerprint %s of key %s does not match its %d pinned fingerprint(s), mode=%s",
+		fingerprint, MaskKey(apiKey), len(binding.TLSFingerprints), policy.TLSFingerprint)
+	events.Publish(events.Event{
+		Type:   events.TypeTLSFingerprintMismatch,
+		APIKey: apiKey,
+		IP:     c.ClientIP(),
+		Actor:  "system",
+		Reason: policy.TLSFingerprint,
+		Data:   map[string]any{"fingerprint": fingerprint, "pinned": binding.TLSFingerprints},
+	})
+	if policy.TLSFingerprint != TLSFingerprintEnforce {
+		tlsFingerprintDecisions.Inc("mismatch_recorded")
+		return true
+	}
+	tlsFingerprintDecisions.Inc("rejected")
+	c.AbortWithStatusJSON(403, gin.H{
+		"error":   "tls_fingerprint_mismatch",
+		"message": "This client does not match the TLS fingerprint pinned to this API key. Contact admin to reset the pinned fingerprints.",
+	})
+	return false
+}
@@ -0,0 +1,252 @@
+package device
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	tokenVersion       = "v1"
+	defaultTokenHeader = "X-Device-Token"
+	maxDeviceIDLength  = 128
+)
+
+// TokenSigner issues and verifies HMAC-SHA256 signed device tokens. A token is
+// bound to the API key it was issued for, so it cannot be replayed with another key.
+type TokenSigner struct {
+	secret []byte
+}
+
+// NewTokenSigner creates a signer; it returns nil for an empty secret
+func NewTokenSigner(secret string) *TokenSigner {
+	if secret == "" {
+		return nil
+	}
+	return &TokenSigner{secret: []byte(secret)}
+}
+
+// Sign returns the token "v1.<base64url device id>.<base64url signature>"
+func (s *TokenSigner) Sign(apiKey, deviceID string) string {
+	encodedID := base64.RawURLEncoding.EncodeToString([]byte(deviceID))
+	return tokenVersion + "." + encodedID + "." + base64.RawURLEncoding.EncodeToString(s.mac(apiKey, encodedID))
+}
+
+// Verify checks a token for the API key and returns the device ID it carries
+func (s *TokenSigner) Verify(apiKey, token string) (string, bool) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 || parts[0] != tokenVersion {
+		return "", false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, s.mac(apiKey, parts[1])) {
+		return "", false
+	}
+	deviceID, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(deviceID) == 0 {
+		return "", false
+	}
+	return string(deviceID), true
+}
+
+func (s *TokenSigner) mac(apiKey, encodedID string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(tokenVersion))
+	h.Write([]byte{0})
+	h.Write([]byte(apiKey))
+	h.Write([]byte{0})
+	h.Write([]byte(encodedID))
+	return h.Sum(nil)
+}
+
+// TokensEnabled reports whether requests must carry signed device tokens
+func (m *Middleware) TokensEnabled() bool {
+	return m != nil && m.config.Enabled && m.signer != nil
+}
+
+// RegisterDevice registers a new device for the calling API key and issues its signed token.
+// Existing devices are never re-issued a token; an admin must remove the device first.
+// Mobile clients can attest the device with a challenge from AttestationChallenge; the
+// verdict is stored on the device and an attestation that fails verification is rejected.
+// POST /v0/device/register  {"device_id": "optional-client-chosen-id"}
+// POST /v0/device/register  {"attestation": {"platform": "android", "challenge": "...", "integrity_token": "..."}}
+func (m *Middleware) RegisterDevice(c *gin.Context) {
+	apiKey := c.GetString("apiKey")
+	if apiKey == "" {
+		c.JSON(401, gin.H{
+			"error":   "unauthorized",
+			"message": "A valid API key is required",
+		})
+		return
+	}
+
+	var body struct {
+		DeviceID    string              `json:"device_id"`
+		Attestation *attestationRequest `json:"attestation"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(400, gin.H{
+				"error":   "invalid_body",
+				"message": "Body must be a JSON object with an optional device_id and attestation",
+			})
+			return
+		}
+	}
+	deviceID := strings.TrimSpace(body.DeviceID)
+	if strings.HasPrefix(deviceID, certDevicePrefix) {
+		c.JSON(400, gin.H{
+			"error":   "invalid_body",
+			"message": "device_id must not start with " + certDevicePrefix,
+		})
+		return
+	}
+	deviceType := "token"
+	// A verified client certificate identifies the device, as it does in Handler
+	if m.config.ClientCertIdentity {
+		if id := ClientCertFingerprint(c.Request); id != "" {
+			deviceID, deviceType = id, "client_cert"
+		}
+	}
+	if deviceID == "" {
+		deviceID = newDeviceID()
+	}
+	if len(deviceID) > maxDeviceIDLength {
+		c.JSON(400, gin.H{
+			"error":   "invalid_body",
+			"message": "device_id is too long",
+		})
+		return
+	}
+
+	currentIP := c.ClientIP()
+	binding, exists := m.store.Get(apiKey)
+	if exists && binding.Banned && !binding.BanExpired(time.Now()) {
+		c.JSON(403, gin.H{
+			"error":   "api_key_banned",
+			"message": "This API key has been banned: " + binding.BanReason,
+		})
+		return
+	}
+	if binding.FindDevice(deviceID) >= 0 {
+		c.JSON(409, gin.H{
+			"error":   "device_already_registered",
+			"message": "This device is already registered. Contact admin to remove it before registering again.",
+		})
+		return
+	}
+	policy := m.effectivePolicy(binding.Policy)
+	if len(binding.Devices) >= policy.MaxDevices {
+		rejectTokenDeviceLimit(c, apiKey, deviceID, currentIP, policy.MaxDevices)
+		return
+	}
+
+	var attestation *Attestation
+	if body.Attestation != nil {
+		var errVerify error
+		attestation, errVerify = m.attestation.verify(apiKey, body.Attestation)
+		if errVerify != nil {
+			log.Warnf("device-binding: rejected attestation for key %s (device=%s): %v", MaskKey(apiKey), deviceID, errVerify)
+			events.Publish(events.Event{Type: events.TypeDeviceRejected, APIKey: apiKey, DeviceID: deviceID, IP: currentIP, Actor: "system", Reason: "attestation failed: " + errVerify.Error()})
+			c.JSON(403, gin.H{
+				"error":   "attestation_failed",
+				"message": "Device attestation could not be verified: " + errVerify.Error(),
+			})
+			return
+		}
+	}
+	if policy.RequireAttestation && !attestation.Trusted() {
+		bindingDecisions.Inc(decisionUnattested)
+		events.Publish(events.Event{Type: events.TypeDeviceRejected, APIKey: apiKey, DeviceID: deviceID, IP: currentIP, Actor: "system", Reason: "trusted attestation required"})
+		reject := gin.H{
+			"error":   "attestation_required",
+			"message": "This API key only accepts attested devices. Request a challenge via POST /v0/device/attestation/challenge and register with an App Attest or Play Integrity attestation.",
+		}
+		if attestation != nil {
+			reject["attestation"] = attestation
+		}
+		c.JSON(403, reject)
+		return
+	}
+
+	status := "active"
+	if m.config.RequireApproval {
+		status = "pending"
+	}
+	// The snapshot above may be stale: the store checks the limit again as
+	// part of the write, so concurrent registrations cannot exceed it.
+	added, err := m.store.AddDeviceWithinLimit(apiKey, deviceID, deviceType, currentIP, m.config.RequireApproval, policy.MaxDevices)
+	if err != nil {
+		log.Errorf("device-binding: failed to register device for key %s: %v", MaskKey(apiKey), err)
+		c.JSON(500, gin.H{
+			"error":   "internal_error",
+			"message": "Failed to register device",
+		})
+		return
+	}
+	if !added {
+		rejectTokenDeviceLimit(c, apiKey, deviceID, currentIP, policy.MaxDevices)
+		return
+	}
+	if attestation != nil {
+		if _, err = m.store.SetAttestation(apiKey, deviceID, attestation); err != nil {
+			// A device without its verdict would pass as unattested forever, so
+			// undo the registration and let the client retry.
+			log.Errorf("device-binding: failed to store attestation of device %s for key %s: %v", deviceID, MaskKey(apiKey), err)
+			if _, errRemove := m.store.RemoveDevice(apiKey, deviceID); errRemove != nil {
+				log.Errorf("device-binding: failed to roll back device %s for key %s: %v", deviceID, MaskKey(apiKey), errRemove)
+			}
+			c.JSON(500, gin.H{
+				"error":   "internal_error",
+				"message": "Failed to register device",
+			})
+			return
+		}
+	}
+
+	log.Infof("device-binding: issued device token for key %s: %s (%s)", MaskKey(apiKey), deviceID, status)
+	registrations.Inc(status)
+	eventType := events.TypeDeviceRegistered
+	if status == "pending" {
+		eventType = events.TypeDevicePending
+	}
+	events.Publish(events.Event{Type: eventType, APIKey: apiKey, DeviceID: deviceID, IP: currentIP, Actor: "system"})
+
+	response := gin.H{
+		"device_id":    deviceID,
+		"device_token": m.signer.Sign(apiKey, deviceID),
+		"header":       m.config.TokenHeader,
+		"status":       status,
+	}
+	if attestation != nil {
+		response["attestation"] = attestation
+	}
+	c.JSON(201, response)
+}
+
+// rejectTokenDeviceLimit answers a registration for a key without free device slots
+func rejectTokenDeviceLimit(c *gin.Context, apiKey, deviceID, currentIP string, maxDevices int) {
+	bindingDecisions.Inc(decisionDeviceLimit)
+	events.Publish(events.Event{Type: events.TypeDeviceRejected, APIKey: apiKey, DeviceID: deviceID, IP: currentIP, Actor: "system", Reason: "device limit reached"})
+	c.JSON(403, gin.H{
+		"error":       "device_limit_exceeded",
+		"message":     "This API key is already bound to the maximum number of devices. Contact admin to remove an existing device.",
+		"max_devices": maxDevices,
+	})
+}
+
+func newDeviceID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return hex.EncodeToString([]byte(time.Now().String()))[:32]
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,180 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTokenSignerRejectsTamperingAndOtherKeys(t *testing.T) {
+	signer := NewTokenSigner("secret")
+	token := signer.Sign("key-a", "laptop")
+
+	if id, ok := signer.Verify("key-a", token); !ok || id != "laptop" {
+		t.Fatalf("expected valid token, got %q %v", id, ok)
+	}
+	if _, ok := signer.Verify("key-b", token); ok {
+		t.Fatal("token must not verify for another API key")
+	}
+	forged := signer.Sign("key-a", "other")
+	parts := strings.Split(token, ".")
+	forgedParts := strings.Split(forged, ".")
+	if _, ok := signer.Verify("key-a", parts[0]+"."+forgedParts[1]+"."+parts[2]); ok {
+		t.Fatal("token with swapped device ID must not verify")
+	}
+}
+
+func TestMiddlewareRequiresRegisteredDeviceToken(t *testing.T) {
+	engine, _ := newTestEngine(t, Config{MaxDevices: 1, TokenSecret: "secret"})
+	key := "key-123456789"
+
+	// Plain device IDs are no longer trusted
+	if rec := doRequest(engine, key, "dev-a", "10.0.0.1"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rec.Code)
+	}
+
+	// A validly signed token for a device that is not (or no longer) registered is refused
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Test-Key", key)
+	req.Header.Set("X-Device-Token", NewTokenSigner("secret").Sign(key, "removed-device"))
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for unregistered device, got %d", rec.Code)
+	}
+}
+
+func TestRegisterDeviceIssuesUsableToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	mw := NewMiddleware(store, Config{Enabled: true, MaxDevices: 1, TokenSecret: "secret"})
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("X-Test-Key"))
+		c.Next()
+	})
+	engine.POST("/v0/device/register", mw.RegisterDevice)
+	engine.GET("/", mw.Handler(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	register := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v0/device/register", strings.NewReader(body))
+		req.Header.Set("X-Test-Key", "key-1")
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := register(`{"device_id":"laptop"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		DeviceToken string `json:"device_token"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+
+	if again := register(`{"device_id":"laptop"}`); again.Code != http.StatusConflict {
+		t.Fatalf("expected re-registration to be refused, got %d", again.Code)
+	}
+	if second := register(`{"device_id":"desktop"}`); second.Code != http.StatusForbidden {
+		t.Fatalf("expected device limit to apply, got %d", second.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Test-Key", "key-1")
+	req.Header.Set("X-Device-Token", resp.DeviceToken)
+	ok := httptest.NewRecorder()
+	engine.ServeHTTP(ok, req)
+	if ok.Code != http.StatusOK {
+		t.Fatalf("expected request with token to pass, got %d", ok.Code)
+	}
+}
+
+// snapshotBarrierStore holds every binding read until waiting reads have
+// happened, so all registrations decide on the same stale snapshot.
+type snapshotBarrierStore struct {
+	Store
+	mu      sync.Mutex
+	waiting int
+	ready   chan struct{}
+}
+
+func (s *snapshotBarrierStore) Get(apiKey string) (DeviceBinding, bool) {
+	binding, exists := s.Store.Get(apiKey)
+	s.mu.Lock()
+	if s.waiting--; s.waiting == 0 {
+		close(s.ready)
+	}
+	s.mu.Unlock()
+	<-s.ready
+	return binding, exists
+}
+
+func TestRegisterDeviceLimitHoldsUnderConcurrentRegistrations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fileStore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	sqliteStore, err := NewSQLiteStore(filepath.Join(t.TempDir(), "bindings.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer func() { _ = sqliteStore.Close() }()
+
+	for name, store := range map[string]Store{"file": fileStore, "sqlite": sqliteStore} {
+		t.Run(name, func(t *testing.T) {
+			const attempts = 8
+			racing := &snapshotBarrierStore{Store: store, waiting: attempts, ready: make(chan struct{})}
+			mw := NewMiddleware(racing, Config{Enabled: true, MaxDevices: 2, TokenSecret: "secret"})
+			engine := gin.New()
+			engine.Use(func(c *gin.Context) {
+				c.Set("apiKey", "key-1")
+				c.Next()
+			})
+			engine.POST("/v0/device/register", mw.RegisterDevice)
+
+			codes := make(chan int, attempts)
+			var wg sync.WaitGroup
+			for i := 0; i < attempts; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					req := httptest.NewRequest(http.MethodPost, "/v0/device/register", strings.NewReader(fmt.Sprintf(`{"device_id":"dev-%d"}`, i)))
+					req.Header.Set("Content-Type", "application/json")
+					rec := httptest.NewRecorder()
+					engine.ServeHTTP(rec, req)
+					codes <- rec.Code
+				}(i)
+			}
+			wg.Wait()
+			close(codes)
+
+			created := 0
+			for code := range codes {
+				switch code {
+				case http.StatusCreated:
+					created++
+				case http.StatusForbidden:
+				default:
+					t.Fatalf("unexpected status %d", code)
+				}
+			}
+			binding, _ := store.Get("key-1")
+			if created != 2 || len(binding.Devices) != 2 {
+				t.Fatalf("expected exactly 2 registered devices, got %d created and %d stored", created, len(binding.Devices))
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	keyUsageFileName   = "key-usage.yaml"
+	keyUsageFileHeader = "# Auto-generated by CLIProxyAPI - DO NOT EDIT MANUALLY\n# Daily token usage per API key and model\n\n"
+)
+
+// KeyUsage is the token usage of one API key and model on one UTC day
+type KeyUsage struct {
+	// Day is the UTC date formatted as YYYY-MM-DD
+	Day          string `yaml:"day" json:"day"`
+	APIKey       string `yaml:"api-key" json:"api_key"`
+	Model        string `yaml:"model" json:"model"`
+	Requests     int64  `yaml:"requests" json:"requests"`
+	InputTokens  int64  `yaml:"input-tokens" json:"input_tokens"`
+	OutputTokens int64  `yaml:"output-tokens" json:"output_tokens"`
+}
+
+// Add accumulates the counters of other
+func (u *KeyUsage) Add(other KeyUsage) {
+	u.Requests += other.Requests
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+}
+
+// KeyUsageFilter restricts a usage query. Days are inclusive YYYY-MM-DD bounds;
+// empty fields match everything.
+type KeyUsageFilter struct {
+	From   string
+	To     string
+	APIKey string
+	Model  string
+}
+
+// Matches reports whether the row passes the filter
+func (f KeyUsageFilter) Matches(u KeyUsage) bool {
+	return (f.From == "" || u.Day >= f.From) &&
+		(f.To == "" || u.Day <= f.To) &&
+		(f.APIKey == "" || u.APIKey == f.APIKey) &&
+		(f.Model == "" || u.Model == f.Model)
+}
+
+// KeyUsageStore is implemented by stores that persist daily token usage per key and model
+type KeyUsageStore interface {
+	// AddKeyUsage adds the counters of each entry to its day, key and model
+	AddKeyUsage(entries []KeyUsage) error
+	// KeyUsage returns the rows matching filter ordered by day, key and model
+	KeyUsage(filter KeyUsageFilter) ([]KeyUsage, error)
+}
+
+// SortKeyUsage orders rows by day, API key and model
+func SortKeyUsage(rows []KeyUsage) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.APIKey != b.APIKey {
+			return a.APIKey < b.APIKey
+		}
+		return a.Model < b.Model
+	})
+}
+
+type keyUsageID struct {
+	day, apiKey, model string
+}
+
+// loadKeyUsage reads the usage file next to the bindings file
+func (s *FileStore) loadKeyUsage() error {
+	data, err := os.ReadFile(s.keyUsagePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var rows []KeyUsage
+	if err = yaml.Unmarshal(data, &rows); err != nil {
+		return fmt.Errorf("invalid YAML in %s: %w", s.keyUsagePath(), err)
+	}
+	for _, row := range rows {
+		id := keyUsageID{row.Day, row.APIKey, row.Model}
+		existing := s.keyUsage[id]
+		existing.Day, existing.APIKey, existing.Model = row.Day, row.APIKey, row.Model
+		existing.Add(row)
+		s.keyUsage[id] = existing
+	}
+	return nil
+}
+
+func (s *FileStore) keyUsagePath() string {
+	return filepath.Join(filepath.Dir(s.filePath), keyUsageFileName)
+}
+
+// AddKeyUsage accumulates usage and persists the usage file
+func (s *FileStore) AddKeyUsage(entries []KeyUsage) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	for _, entry := range entries {
+		id := keyUsageID{entry.Day, entry.APIKey, entry.Model}
+		row, ok := s.keyUsage[id]
+		if !ok {
+			row = KeyUsage{Day: entry.Day, APIKey: entry.APIKey, Model: entry.Model}
+		}
+		row.Add(entry)
+		s.keyUsage[id] = row
+	}
+	rows := make([]KeyUsage, 0, len(s.keyUsage))
+	for _, row := range s.keyUsage {
+		rows = append(rows, row)
+	}
+	SortKeyUsage(rows)
+	data, err := yaml.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.keyUsagePath()), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.keyUsagePath(), []byte(keyUsageFileHeader+string(data)), 0644)
+}
+
+// KeyUsage returns the usage rows matching filter
+func (s *FileStore) KeyUsage(filter KeyUsageFilter) ([]KeyUsage, error) {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	rows := make([]KeyUsage, 0)
+	for _, row := range s.keyUsage {
+		if filter.Matches(row) {
+			rows = append(rows, row)
+		}
+	}
+	SortKeyUsage(rows)
+	return rows, nil
+}
+
+// AddKeyUsage upserts usage rows in one transaction
+func (s *sqlStore) AddKeyUsage(entries []KeyUsage) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sqlQueryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	query := s.q(`INSERT INTO key_usage_daily (day, api_key, model, requests, input_tokens, output_tokens)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (day, api_key, model) DO UPDATE SET
+			requests = key_usage_daily.requests + excluded.requests,
+			input_tokens = key_usage_daily.input_tokens + excluded.input_tokens,
+			output_tokens = key_usage_daily.output_tokens + excluded.output_tokens`)
+	for _, e := range entries {
+		if _, err = tx.ExecContext(ctx, query, e.Day, e.APIKey, e.Model, e.Requests, e.InputTokens, e.OutputTokens); err != nil {
+			return fmt.Errorf("%s store: record key usage: %w", s.dialect.name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// KeyUsage returns the usage rows matching filter
+func (s *sqlStore) KeyUsage(filter KeyUsageFilter) ([]KeyUsage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlQueryTimeout)
+	defer cancel()
+
+	query := "SELECT day, api_key, model, requests, input_tokens, output_tokens FROM key_usage_daily WHERE 1=1"
+	var args []any
+	for _, cond := range []struct{ clause, value string }{
+		{" AND day >= ?", filter.From},
+		{" AND day <= ?", filter.To},
+		{" AND api_key = ?", filter.APIKey},
+		{" AND model = ?", filter.Model},
+	} {
+		if cond.value != "" {
+			query += cond.clause
+			args = append(args, cond.value)
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, s.q(query+" ORDER BY day, api_key, model"), args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s store: query key usage: %w", s.dialect.name, err)
+	}
+	defer func() { _ = rows.Close() }()
+	result := make([]KeyUsage, 0)
+	for rows.Next() {
+		var u KeyUsage
+		if err = rows.Scan(&u.Day, &u.APIKey, &u.Model, &u.Requests, &u.InputTokens, &u.OutputTokens); err != nil {
+			return nil, err
+		}
+		result = append(result, u)
+	}
+	return result, rows.Err()
+}
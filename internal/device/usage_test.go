@@ -0,0 +1,55 @@
+package device
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStoresAccumulateKeyUsage(t *testing.T) {
+	dir := t.TempDir()
+	open := map[string]func() (Store, error){
+		BackendYAML:   func() (Store, error) { return NewFileStore(dir) },
+		BackendSQLite: func() (Store, error) { return NewSQLiteStore(filepath.Join(dir, "usage.db")) },
+	}
+	for backend, openStore := range open {
+		store, err := openStore()
+		if err != nil {
+			t.Fatalf("%s: open: %v", backend, err)
+		}
+		usageStore, ok := store.(KeyUsageStore)
+		if !ok {
+			t.Fatalf("%s: store does not record key usage", backend)
+		}
+		batch := []KeyUsage{
+			{Day: "2025-01-01", APIKey: "k1", Model: "m1", Requests: 1, InputTokens: 100, OutputTokens: 10},
+			{Day: "2025-01-02", APIKey: "k1", Model: "m1", Requests: 1, InputTokens: 50, OutputTokens: 5},
+			{Day: "2025-01-02", APIKey: "k2", Model: "m2", Requests: 1, InputTokens: 7, OutputTokens: 1},
+		}
+		if err = usageStore.AddKeyUsage(batch); err != nil {
+			t.Fatalf("%s: AddKeyUsage: %v", backend, err)
+		}
+		if err = usageStore.AddKeyUsage(batch[:1]); err != nil {
+			t.Fatalf("%s: AddKeyUsage: %v", backend, err)
+		}
+		if err = store.Close(); err != nil {
+			t.Fatalf("%s: Close: %v", backend, err)
+		}
+
+		// Reopen to check persistence.
+		if store, err = openStore(); err != nil {
+			t.Fatalf("%s: reopen: %v", backend, err)
+		}
+		rows, err := store.(KeyUsageStore).KeyUsage(KeyUsageFilter{From: "2025-01-01", To: "2025-01-01"})
+		if err != nil {
+			t.Fatalf("%s: KeyUsage: %v", backend, err)
+		}
+		if len(rows) != 1 || rows[0].Requests != 2 || rows[0].InputTokens != 200 || rows[0].OutputTokens != 20 {
+			t.Fatalf("%s: unexpected rows %+v", backend, rows)
+		}
+		rows, _ = store.(KeyUsageStore).KeyUsage(KeyUsageFilter{APIKey: "k2"})
+		if len(rows) != 1 || rows[0].Model != "m2" {
+			t.Fatalf("%s: expected a single k2 row, got %+v", backend, rows)
+		}
+		_ = store.Close()
+	}
+}
@@ -0,0 +1,257 @@
+// Package errorlog keeps a bounded in-memory ring buffer of recent proxy and
+// upstream errors so administrators can triage failures without grepping logs.
+package errorlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultCapacity is the number of errors retained when no size is configured.
+	DefaultCapacity = 200
+
+	// SourceProxy marks errors produced by the proxy itself (auth, device binding, limits).
+	SourceProxy = "proxy"
+	// SourceUpstream marks errors returned by or while calling an upstream provider.
+	SourceUpstream = "upstream"
+
+	// ContextSourceKey and ContextMessageKey are gin context keys that handlers
+	// set to classify an error response.
+	ContextSourceKey  = "API_ERROR_SOURCE"
+	ContextMessageKey = "API_ERROR_MESSAGE"
+
+	maxMessageLength = 512
+	maxCapturedBody  = 2048
+)
+
+// Entry is a single sanitized error record.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	Status   int       `json:"status"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	APIKey   string    `json:"api_key,omitempty"` // masked
+	ClientIP string    `json:"client_ip,omitempty"`
+	Message  string    `json:"message"`
+}
+
+// Filter selects entries from the buffer. Zero values match everything.
+type Filter struct {
+	Source    string
+	Status    int
+	MinStatus int
+	Path      string
+	Since     time.Time
+	Limit     int
+}
+
+// Ring is a fixed-size buffer of the most recent errors.
+type Ring struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// NewRing creates a ring buffer holding up to capacity entries.
+func NewRing(capacity int) *Ring {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Ring{entries: make([]Entry, capacity)}
+}
+
+// Resize changes the capacity, keeping the most recent entries.
+func (r *Ring) Resize(capacity int) {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if capacity == len(r.entries) {
+		return
+	}
+	current := r.snapshotLocked()
+	if len(current) > capacity {
+		current = current[len(current)-capacity:]
+	}
+	r.entries = make([]Entry, capacity)
+	copy(r.entries, current)
+	r.next = len(current) % capacity
+	r.full = len(current) == capacity
+}
+
+// Add records an entry, evicting the oldest when full.
+func (r *Ring) Add(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Message = Sanitize(e.Message)
+	r.mu.Lock()
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+// snapshotLocked returns entries oldest first; callers must hold r.mu.
+func (r *Ring) snapshotLocked() []Entry {
+	if !r.full {
+		return append([]Entry(nil), r.entries[:r.next]...)
+	}
+	out := make([]Entry, 0, len(r.entries))
+	out = append(out, r.entries[r.next:]...)
+	return append(out, r.entries[:r.next]...)
+}
+
+// Recent returns matching entries, newest first.
+func (r *Ring) Recent(f Filter) []Entry {
+	r.mu.Lock()
+	all := r.snapshotLocked()
+	r.mu.Unlock()
+
+	out := make([]Entry, 0, len(all))
+	for i := len(all) - 1; i >= 0; i-- {
+		e := all[i]
+		if f.Source != "" && !strings.EqualFold(e.Source, f.Source) {
+			continue
+		}
+		if f.Status != 0 && e.Status != f.Status {
+			continue
+		}
+		if f.MinStatus != 0 && e.Status < f.MinStatus {
+			continue
+		}
+		if f.Path != "" && !strings.Contains(e.Path, f.Path) {
+			continue
+		}
+		if !f.Since.IsZero() && e.Time.Before(f.Since) {
+			continue
+		}
+		out = append(out, e)
+		if f.Limit > 0 && len(out) >= f.Limit {
+			break
+		}
+	}
+	return out
+}
+
+var secretPattern = regexp.MustCompile(`(?i)(bearer\s+|api[_-]?key["'=:\s]+|sk-)[A-Za-z0-9._~+/=-]{6,}`)
+
+// Sanitize masks credential-like substrings and truncates long messages.
+func Sanitize(msg string) string {
+	msg = strings.TrimSpace(msg)
+	msg = secretPattern.ReplaceAllStringFunc(msg, func(match string) string {
+		sub := secretPattern.FindStringSubmatch(match)
+		return sub[1] + "***"
+	})
+	if len(msg) > maxMessageLength {
+		msg = msg[:maxMessageLength] + "..."
+	}
+	return msg
+}
+
+var defaultRing = NewRing(DefaultCapacity)
+
+// Default returns the process-wide error buffer.
+func Default() *Ring { return defaultRing }
+
+// captureWriter keeps the beginning of error response bodies.
+type captureWriter struct {
+	gin.ResponseWriter
+	body []byte
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	if w.Status() >= 400 && len(w.body) < maxCapturedBody {
+		remaining := maxCapturedBody - len(w.body)
+		if remaining > len(data) {
+			remaining = len(data)
+		}
+		w.body = append(w.body, data[:remaining]...)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Middleware records every response with status >= 400 into the ring.
+// maskKey is applied to the authenticated API key before it is stored.
+func Middleware(ring *Ring, maskKey func(string) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writer := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		status := c.Writer.Status()
+		if status < 400 {
+			return
+		}
+		source := SourceProxy
+		if v, ok := c.Get(ContextSourceKey); ok {
+			if s, isString := v.(string); isString && s != "" {
+				source = s
+			}
+		}
+		message := c.GetString(ContextMessageKey)
+		if message == "" {
+			message = messageFromBody(writer.body)
+		}
+		if message == "" && len(c.Errors) > 0 {
+			message = c.Errors.String()
+		}
+		apiKey := c.GetString("apiKey")
+		if apiKey != "" && maskKey != nil {
+			apiKey = maskKey(apiKey)
+		}
+		ring.Add(Entry{
+			Source:   source,
+			Status:   status,
+			Method:   c.Request.Method,
+			Path:     c.Request.URL.Path,
+			APIKey:   apiKey,
+			ClientIP: c.ClientIP(),
+			Message:  message,
+		})
+	}
+}
+
+// messageFromBody extracts a human-readable message from a JSON error body.
+func messageFromBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return string(body)
+	}
+	if msg, ok := payload["message"].(string); ok && msg != "" {
+		return msg
+	}
+	switch e := payload["error"].(type) {
+	case string:
+		return e
+	case map[string]any:
+		if msg, ok := e["message"].(string); ok {
+			return msg
+		}
+	}
+	return string(body)
+}
@@ -0,0 +1,39 @@
+package errorlog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRingKeepsMostRecentAndFilters(t *testing.T) {
+	r := NewRing(3)
+	r.Add(Entry{Source: SourceProxy, Status: 401, Path: "/v1/messages"})
+	r.Add(Entry{Source: SourceUpstream, Status: 429, Path: "/v1/messages"})
+	r.Add(Entry{Source: SourceUpstream, Status: 500, Path: "/v1/chat/completions"})
+	r.Add(Entry{Source: SourceProxy, Status: 403, Path: "/v1/messages"})
+
+	all := r.Recent(Filter{})
+	if len(all) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(all))
+	}
+	if all[0].Status != 403 || all[2].Status != 429 {
+		t.Fatalf("expected newest first with oldest evicted, got %+v", all)
+	}
+
+	upstream := r.Recent(Filter{Source: SourceUpstream, MinStatus: 500})
+	if len(upstream) != 1 || upstream[0].Status != 500 {
+		t.Fatalf("unexpected filtered entries: %+v", upstream)
+	}
+
+	r.Resize(2)
+	if got := r.Recent(Filter{}); len(got) != 2 || got[0].Status != 403 {
+		t.Fatalf("unexpected entries after resize: %+v", got)
+	}
+}
+
+func TestSanitizeMasksSecrets(t *testing.T) {
+	got := Sanitize("upstream rejected Bearer abcdef123456 for key sk-ant-api03-XYZXYZXYZ")
+	if strings.Contains(got, "abcdef123456") || strings.Contains(got, "XYZ") || !strings.Contains(got, "sk-***") {
+		t.Fatalf("expected secrets to be masked, got %q", got)
+	}
+}
@@ -0,0 +1,150 @@
+// Package events provides a lightweight in-process event bus used to fan out
+// proxy domain events (bans, device registrations, alerts) to notification
+// integrations such as chat bots and webhooks.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Type identifies the kind of event being published.
+type Type string
+
+const (
+	// TypeBan is published when an API key is banned.
+	TypeBan Type = "ban"
+	// TypeUnban is published when a ban is lifted from an API key.
+	TypeUnban Type = "unban"
+	// TypeDeviceBanned is published when a single device or client IP of an API key is banned.
+	TypeDeviceBanned Type = "device_banned"
+	// TypeDeviceUnbanned is published when the ban of a device or client IP of an API key is lifted.
+	TypeDeviceUnbanned Type = "device_unbanned"
+	// TypeDeviceRegistered is published when a new device is bound to an API key.
+	TypeDeviceRegistered Type = "device_registered"
+	// TypeDeviceRejected is published when a device is rejected because the key reached its device limit.
+	TypeDeviceRejected Type = "device_rejected"
+	// TypeDevicePending is published when a new device is waiting for admin approval.
+	TypeDevicePending Type = "device_pending"
+	// TypeDeviceApproved is published when an admin approves a pending device.
+	TypeDeviceApproved Type = "device_approved"
+	// TypeTLSFingerprintMismatch is published when a client's TLS fingerprint is not pinned to its API key.
+	TypeTLSFingerprintMismatch Type = "tls_fingerprint_mismatch"
+	// TypeBoostGranted is published when an admin grants a key a temporary limit boost.
+	TypeBoostGranted Type = "boost_granted"
+	// TypeBoostRevoked is published when an admin ends a boost early.
+	TypeBoostRevoked Type = "boost_revoked"
+	// TypeBoostExpired is published when a boost reverts automatically.
+	TypeBoostExpired Type = "boost_expired"
+	// TypeVerboseLoggingDisabled is published when debug or request logging is switched off after its timeout.
+	TypeVerboseLoggingDisabled Type = "verbose_logging_disabled"
+	// TypeKeyCreated is published when an admin mints a client API key.
+	TypeKeyCreated Type = "key_created"
+	// TypeKeyRotated is published when an admin rotates a client API key; the old key expires after the grace window.
+	TypeKeyRotated Type = "key_rotated"
+	// TypeKeyRevoked is published when an admin revokes a client API key.
+	TypeKeyRevoked Type = "key_revoked"
+	// TypeAlertFiring is published when an alert rule's condition has held for its hold duration.
+	TypeAlertFiring Type = "alert_firing"
+	// TypeAlertResolved is published when a firing alert rule's condition no longer holds.
+	TypeAlertResolved Type = "alert_resolved"
+	// TypeManagementRecovered is published when management access is regained with a recovery code or break-glass token.
+	TypeManagementRecovered Type = "management_recovered"
+	// TypeManagementRecoveryFailed is published when a management recovery attempt is rejected.
+	TypeManagementRecoveryFailed Type = "management_recovery_failed"
+	// TypeAdminTokenCreated is published when a scoped management token is minted.
+	TypeAdminTokenCreated Type = "admin_token_created"
+	// TypeAdminTokenRevoked is published when a scoped management token is revoked.
+	TypeAdminTokenRevoked Type = "admin_token_revoked"
+	// TypeCanaryPromoted is published when a canary config rollout is promoted to all traffic.
+	TypeCanaryPromoted Type = "canary_promoted"
+	// TypeCanaryRolledBack is published when a canary config rollout is rolled back.
+	TypeCanaryRolledBack Type = "canary_rolled_back"
+	// TypeBackupFailed is published when a scheduled or manual store backup fails.
+	TypeBackupFailed Type = "backup_failed"
+	// TypeBackupRestored is published when the device binding store is restored from a backup.
+	TypeBackupRestored Type = "backup_restored"
+	// TypeUpstreamFailover is published when a request moves on to another upstream
+	// credential after the previous one failed.
+	TypeUpstreamFailover Type = "upstream_failover"
+	// TypeRateLimited is published when a client request is rejected by a rate limit,
+	// quota or concurrency limit of its API key.
+	TypeRateLimited Type = "rate_limited"
+)
+
+// Event describes a single domain event.
+type Event struct {
+	Type     Type           `json:"type"`
+	Time     time.Time      `json:"time"`
+	APIKey   string         `json:"api_key,omitempty"`
+	DeviceID string         `json:"device_id,omitempty"`
+	IP       string         `json:"ip,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+	Actor    string         `json:"actor,omitempty"` // "system" for automatic actions, otherwise the admin source
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+// Handler consumes published events. Handlers are invoked synchronously on the
+// publishing goroutine and must not block; slow consumers should queue work.
+type Handler func(Event)
+
+// Bus fans out published events to registered subscribers.
+type Bus struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   map[int]Handler
+}
+
+// NewBus creates an empty event bus.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]Handler)}
+}
+
+// Subscribe registers a handler and returns a function that removes it.
+func (b *Bus) Subscribe(handler Handler) (unsubscribe func()) {
+	if handler == nil {
+		return func() {}
+	}
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = handler
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// Publish delivers an event to every subscriber. A zero Time is set to now.
+func (b *Bus) Publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.mu.RLock()
+	handlers := make([]Handler, 0, len(b.subs))
+	for _, h := range b.subs {
+		handlers = append(handlers, h)
+	}
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(ev)
+	}
+}
+
+var defaultBus = NewBus()
+
+// Default returns the process-wide event bus.
+func Default() *Bus { return defaultBus }
+
+// Publish delivers an event on the process-wide bus.
+func Publish(ev Event) { defaultBus.Publish(ev) }
+
+// Subscribe registers a handler on the process-wide bus.
+func Subscribe(handler Handler) (unsubscribe func()) { return defaultBus.Subscribe(handler) }
@@ -0,0 +1,467 @@
+// Package forwardproxy implements an HTTP CONNECT and SOCKS5 forward proxy
+// that intercepts TLS traffic for configured upstream hosts (by default
+// api.anthropic.com) and serves it with the proxy's own HTTP handler. Clients
+// whose base URL cannot be changed then get the same authentication, device
+// binding and accounting as clients talking to the proxy directly.
+//
+// Interception terminates TLS with certificates issued by a local CA that the
+// clients must trust. Connections to other hosts are tunnelled unchanged when
+// passthrough is enabled and refused otherwise.
+package forwardproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultHosts are intercepted when no hosts are configured.
+var DefaultHosts = []string{"api.anthropic.com"}
+
+// dialTimeout bounds connecting to passthrough targets.
+const dialTimeout = 10 * time.Second
+
+// SOCKS5 protocol constants (RFC 1928)
+const (
+	socksVersion      = 0x05
+	socksNoAuth       = 0x00
+	socksNoAcceptable = 0xff
+	socksCmdConnect   = 0x01
+	socksAddrIPv4     = 0x01
+	socksAddrDomain   = 0x03
+	socksAddrIPv6     = 0x04
+
+	socksSucceeded         = 0x00
+	socksNotAllowed        = 0x02
+	socksHostUnreachable   = 0x04
+	socksCmdNotSupported   = 0x07
+	socksAddrNotSupported  = 0x08
+	socksReplyHeaderLength = 10
+)
+
+var connections = metrics.Default().NewCounterVec(
+	"cliproxy_forward_proxy_connections_total",
+	"Forward proxy connections by protocol and outcome (intercepted, tunneled, rejected or denied).",
+	"protocol", "outcome",
+)
+
+// Config configures the forward proxy.
+type Config struct {
+	// Hosts are the upstream hosts whose TLS traffic is intercepted.
+	Hosts []string
+	// AllowedNetworks restricts which clients may use the proxy. Empty allows loopback only.
+	AllowedNetworks []*net.IPNet
+	// Passthrough tunnels connections to other hosts instead of refusing them.
+	Passthrough bool
+	// CA issues the interception certificates.
+	CA tls.Certificate
+}
+
+// Proxy is a forward proxy listener.
+type Proxy struct {
+	cfg   Config
+	hosts map[string]struct{}
+
+	intercepted *http.Server
+	conns       *connListener
+
+	certMu sync.Mutex
+	certs  map[string]*tls.Certificate
+
+	mu       sync.Mutex
+	listener net.Listener
+	active   map[net.Conn]struct{}
+}
+
+// New creates a forward proxy serving intercepted requests with handler.
+func New(cfg Config, handler http.Handler) *Proxy {
+	hosts := make(map[string]struct{})
+	for _, host := range cfg.Hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts[host] = struct{}{}
+		}
+	}
+	if len(hosts) == 0 {
+		for _, host := range DefaultHosts {
+			hosts[host] = struct{}{}
+		}
+	}
+	return &Proxy{
+		cfg:         cfg,
+		hosts:       hosts,
+		intercepted: &http.Server{Handler: handler},
+		conns:       newConnListener(),
+		certs:       make(map[string]*tls.Certificate),
+		active:      make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts proxy connections on l until it is closed.
+func (p *Proxy) Serve(l net.Listener) error {
+	p.mu.Lock()
+	p.listener = l
+	p.mu.Unlock()
+	go func() {
+		if err := p.intercepted.Serve(p.conns); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("forward proxy: intercepted server stopped: %v", err)
+		}
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return http.ErrServerClosed
+			}
+			return err
+		}
+		go p.handle(conn)
+	}
+}
+
+// Shutdown stops accepting connections, closes tunnels and gracefully stops
+// the intercepted HTTP server.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if p.listener != nil {
+		_ = p.listener.Close()
+	}
+	for conn := range p.active {
+		_ = conn.Close()
+	}
+	p.mu.Unlock()
+	return p.intercepted.Shutdown(ctx)
+}
+
+func (p *Proxy) track(conn net.Conn, add bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if add {
+		p.active[conn] = struct{}{}
+	} else {
+		delete(p.active, conn)
+	}
+}
+
+// allowed reports whether the client at addr may use the proxy.
+func (p *Proxy) allowed(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	if len(p.cfg.AllowedNetworks) == 0 {
+		return tcp.IP.IsLoopback()
+	}
+	for _, network := range p.cfg.AllowedNetworks {
+		if network.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// intercepts reports whether traffic to host is served by the proxy.
+func (p *Proxy) intercepts(host string) bool {
+	_, ok := p.hosts[strings.ToLower(host)]
+	return ok
+}
+
+func (p *Proxy) handle(conn net.Conn) {
+	if !p.allowed(conn.RemoteAddr()) {
+		connections.Inc("any", "denied")
+		log.Warnf("forward proxy: refused client %s outside allowed networks", conn.RemoteAddr())
+		_ = conn.Close()
+		return
+	}
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	buffered := &bufferedConn{Conn: conn, reader: reader}
+	if first[0] == socksVersion {
+		p.handleSOCKS(buffered)
+		return
+	}
+	p.handleConnect(buffered)
+}
+
+// handleConnect serves one HTTP CONNECT request.
+func (p *Proxy) handleConnect(conn *bufferedConn) {
+	_ = conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	req, err := http.ReadRequest(conn.reader)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	if req.Method != http.MethodConnect {
+		connections.Inc("http", "rejected")
+		writeHTTPStatus(conn, http.StatusMethodNotAllowed, "only CONNECT is supported")
+		_ = conn.Close()
+		return
+	}
+	host, port, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host, port = req.Host, "443"
+	}
+	if !p.intercepts(host) && !p.cfg.Passthrough {
+		connections.Inc("http", "rejected")
+		writeHTTPStatus(conn, http.StatusForbidden, "destination not allowed")
+		_ = conn.Close()
+		return
+	}
+	if p.intercepts(host) {
+		writeHTTPStatus(conn, http.StatusOK, "")
+		p.intercept(conn, host, "http")
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), dialTimeout)
+	if err != nil {
+		connections.Inc("http", "rejected")
+		writeHTTPStatus(conn, http.StatusBadGateway, err.Error())
+		_ = conn.Close()
+		return
+	}
+	writeHTTPStatus(conn, http.StatusOK, "")
+	p.tunnel(conn, upstream, "http")
+}
+
+// handleSOCKS serves one SOCKS5 CONNECT request without authentication.
+func (p *Proxy) handleSOCKS(conn *bufferedConn) {
+	_ = conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	host, port, err := p.socksHandshake(conn)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		log.Debugf("forward proxy: socks handshake with %s failed: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	if p.intercepts(host) {
+		writeSOCKSReply(conn, socksSucceeded)
+		p.intercept(conn, host, "socks5")
+		return
+	}
+	if !p.cfg.Passthrough {
+		connections.Inc("socks5", "rejected")
+		writeSOCKSReply(conn, socksNotAllowed)
+		_ = conn.Close()
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), dialTimeout)
+	if err != nil {
+		connections.Inc("socks5", "rejected")
+		writeSOCKSReply(conn, socksHostUnreachable)
+		_ = conn.Close()
+		return
+	}
+	writeSOCKSReply(conn, socksSucceeded)
+	p.tunnel(conn, upstream, "socks5")
+}
+
+// socksHandshake negotiates the method and reads the CONNECT target. Clients
+// must send host names (socks5h) for interception to recognise them.
+func (p *Proxy) socksHandshake(conn *bufferedConn) (string, string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn.reader, header); err != nil {
+		return "", "", err
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn.reader, methods); err != nil {
+		return "", "", err
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", "", err
+	}
+	if method == socksNoAcceptable {
+		return "", "", errors.New("client offers no supported authentication method")
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn.reader, request); err != nil {
+		return "", "", err
+	}
+	if request[0] != socksVersion || request[1] != socksCmdConnect {
+		writeSOCKSReply(conn, socksCmdNotSupported)
+		return "", "", fmt.Errorf("unsupported command %d", request[1])
+	}
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		size := net.IPv4len
+		if request[3] == socksAddrIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn.reader, ip); err != nil {
+			return "", "", err
+		}
+		host = net.IP(ip).String()
+	case socksAddrDomain:
+		length, err := conn.reader.ReadByte()
+		if err != nil {
+			return "", "", err
+		}
+		name := make([]byte, length)
+		if _, err = io.ReadFull(conn.reader, name); err != nil {
+			return "", "", err
+		}
+		host = string(name)
+	default:
+		writeSOCKSReply(conn, socksAddrNotSupported)
+		return "", "", fmt.Errorf("unsupported address type %d", request[3])
+	}
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(conn.reader, portBytes); err != nil {
+		return "", "", err
+	}
+	return host, strconv.Itoa(int(binary.BigEndian.Uint16(portBytes))), nil
+}
+
+// intercept terminates TLS for host and hands the connection to the
+// intercepted HTTP server.
+func (p *Proxy) intercept(conn net.Conn, host, protocol string) {
+	tlsConn := tls.Server(conn, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = host
+			}
+			return p.certificate(name)
+		},
+	})
+	_ = tlsConn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		log.Debugf("forward proxy: tls handshake with %s for %s failed: %v", conn.RemoteAddr(), host, err)
+		connections.Inc(protocol, "rejected")
+		_ = conn.Close()
+		return
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+	connections.Inc(protocol, "intercepted")
+	if !p.conns.push(tlsConn) {
+		_ = tlsConn.Close()
+	}
+}
+
+// certificate returns a cached interception certificate for host.
+func (p *Proxy) certificate(host string) (*tls.Certificate, error) {
+	host = strings.ToLower(host)
+	p.certMu.Lock()
+	defer p.certMu.Unlock()
+	if cert, ok := p.certs[host]; ok && time.Until(cert.Leaf.NotAfter) > time.Hour {
+		return cert, nil
+	}
+	cert, err := issueLeaf(p.cfg.CA, host)
+	if err != nil {
+		return nil, err
+	}
+	p.certs[host] = cert
+	return cert, nil
+}
+
+// tunnel copies bytes between the client and upstream until either side closes.
+func (p *Proxy) tunnel(client, upstream net.Conn, protocol string) {
+	connections.Inc(protocol, "tunneled")
+	p.track(client, true)
+	p.track(upstream, true)
+	defer func() {
+		p.track(client, false)
+		p.track(upstream, false)
+		_ = client.Close()
+		_ = upstream.Close()
+	}()
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(upstream, client)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(client, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func writeHTTPStatus(conn net.Conn, status int, message string) {
+	if status == http.StatusOK {
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		return
+	}
+	_, _ = fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		status, http.StatusText(status), len(message), message)
+}
+
+func writeSOCKSReply(conn net.Conn, code byte) {
+	reply := make([]byte, socksReplyHeaderLength)
+	reply[0], reply[1], reply[3] = socksVersion, code, socksAddrIPv4
+	_, _ = conn.Write(reply)
+}
+
+// bufferedConn is a connection whose first bytes were read into reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// connListener is a net.Listener fed with already accepted connections.
+type connListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnListener() *connListener {
+	return &connListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *connListener) push(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.closed:
+		return false
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4zero}
+}
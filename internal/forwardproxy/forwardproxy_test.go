@@ -0,0 +1,71 @@
+package forwardproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+// startProxy serves a proxy on loopback and returns its address and the CA
+// that signs intercepted connections.
+func startProxy(t *testing.T, cfg Config) (string, *x509.Certificate) {
+	t.Helper()
+	dir := t.TempDir()
+	ca, err := LoadOrCreateCA(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"))
+	if err != nil {
+		t.Fatalf("create ca: %v", err)
+	}
+	cfg.CA = ca
+	proxy := New(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "intercepted "+r.Host+r.URL.Path+" "+r.Header.Get("X-Api-Key"))
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() { _ = proxy.Serve(listener) }()
+	t.Cleanup(func() { _ = proxy.Shutdown(context.Background()) })
+	return listener.Addr().String(), ca.Leaf
+}
+
+func proxyClient(scheme, addr string, ca *x509.Certificate) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(&url.URL{Scheme: scheme, Host: addr}),
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}
+}
+
+func TestInterceptsConfiguredHostOverConnectAndSOCKS(t *testing.T) {
+	addr, ca := startProxy(t, Config{})
+
+	for _, scheme := range []string{"http", "socks5h"} {
+		req, _ := http.NewRequest(http.MethodPost, "https://api.anthropic.com/v1/messages", nil)
+		req.Header.Set("X-Api-Key", "client-key")
+		resp, err := proxyClient(scheme, addr, ca).Do(req)
+		if err != nil {
+			t.Fatalf("%s: request through proxy: %v", scheme, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != "intercepted api.anthropic.com/v1/messages client-key" {
+			t.Fatalf("%s: unexpected body %q", scheme, body)
+		}
+	}
+}
+
+func TestRefusesOtherHostsWithoutPassthrough(t *testing.T) {
+	addr, ca := startProxy(t, Config{})
+	for _, scheme := range []string{"http", "socks5h"} {
+		if _, err := proxyClient(scheme, addr, ca).Get("https://example.com/"); err == nil {
+			t.Fatalf("%s: expected a host that is not intercepted to be refused", scheme)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Package ipacl enforces per-key client IP allow and deny lists. Rules are
+// checked right after authentication, so a key used from a network it is not
+// allowed on is rejected before device binding registers anything.
+package ipacl
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Rejection reasons reported by Check
+const (
+	ReasonDenied     = "denied"
+	ReasonNotAllowed = "not_allowed"
+)
+
+var rejections = metrics.Default().NewCounterVec(
+	"cliproxy_key_ip_rejections_total",
+	"Requests rejected by per-key IP rules, by reason (denied or not_allowed).",
+	"reason",
+)
+
+type rule struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+	// restricted is set when the key has an allow list, even one whose entries
+	// were all invalid, so a typo fails closed instead of opening the key up.
+	restricted bool
+}
+
+// Lists holds the compiled IP rules of every key.
+type Lists struct {
+	mu   sync.RWMutex
+	keys map[string]rule
+}
+
+// New compiles the rules of cfg. Invalid entries are logged and skipped.
+func New(cfg config.IPAccessConfig) *Lists {
+	l := &Lists{}
+	l.Update(cfg)
+	return l
+}
+
+// Update replaces the rules.
+func (l *Lists) Update(cfg config.IPAccessConfig) {
+	keys := make(map[string]rule, len(cfg.Keys))
+	for key, r := range cfg.Keys {
+		allow, errAllow := ParsePrefixes(r.Allow)
+		if errAllow != nil {
+			log.Warnf("ip-access: key %s: allow: %v", device.MaskKey(key), errAllow)
+		}
+		deny, errDeny := ParsePrefixes(r.Deny)
+		if errDeny != nil {
+			log.Warnf("ip-access: key %s: deny: %v", device.MaskKey(key), errDeny)
+		}
+		restricted := hasEntries(r.Allow)
+		if restricted || len(deny) > 0 {
+			keys[key] = rule{allow: allow, deny: deny, restricted: restricted}
+		}
+	}
+	l.mu.Lock()
+	l.keys = keys
+	l.mu.Unlock()
+}
+
+// Check reports whether apiKey may be used from ip, and the rejection reason if not.
+func (l *Lists) Check(apiKey, ip string) (bool, string) {
+	if l == nil || apiKey == "" {
+		return true, ""
+	}
+	l.mu.RLock()
+	r, ok := l.keys[apiKey]
+	l.mu.RUnlock()
+	if !ok {
+		return true, ""
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		// An unparseable client address cannot match an allow list.
+		if r.restricted {
+			return false, ReasonNotAllowed
+		}
+		return true, ""
+	}
+	addr = addr.Unmap()
+	if containsAddr(r.deny, addr) {
+		return false, ReasonDenied
+	}
+	if r.restricted && !containsAddr(r.allow, addr) {
+		return false, ReasonNotAllowed
+	}
+	return true, ""
+}
+
+// Middleware answers 403 for requests whose client IP the key's rules reject.
+// It must run after authentication so the API key is known.
+func (l *Lists) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := c.GetString("apiKey")
+		ip := c.ClientIP()
+		allowed, reason := l.Check(apiKey, ip)
+		if allowed {
+			c.Next()
+			return
+		}
+		rejections.Inc(reason)
+		log.Warnf("ip-access: rejected key %s from %s (%s)", device.MaskKey(apiKey), ip, reason)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":   "ip_not_allowed",
+			"message": "This API key may not be used from your IP address",
+		})
+	}
+}
+
+// ParsePrefixes parses CIDRs and single addresses; single addresses become
+// host prefixes. Invalid entries are reported together and left out.
+func ParsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	var invalid []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				invalid = append(invalid, entry)
+				continue
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			invalid = append(invalid, entry)
+			continue
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	if len(invalid) > 0 {
+		return prefixes, fmt.Errorf("invalid CIDR or IP address: %s", strings.Join(invalid, ", "))
+	}
+	return prefixes, nil
+}
+
+func hasEntries(entries []string) bool {
+	for _, entry := range entries {
+		if strings.TrimSpace(entry) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeEntries validates entries and returns them in canonical form.
+func NormalizeEntries(entries []string) ([]string, error) {
+	prefixes, err := ParsePrefixes(entries)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if prefix.IsSingleIP() {
+			out = append(out, prefix.Addr().String())
+		} else {
+			out = append(out, prefix.String())
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,73 @@
+package ipacl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func TestCheckAppliesDenyBeforeAllow(t *testing.T) {
+	l := New(config.IPAccessConfig{Keys: map[string]config.IPAccessRule{
+		"office":  {Allow: []string{"10.0.0.0/8", "203.0.113.7"}, Deny: []string{"10.66.0.0/16"}},
+		"blocked": {Deny: []string{"198.51.100.0/24"}},
+		"typo":    {Allow: []string{"10.0.0.0/33"}},
+	}})
+	cases := []struct {
+		key, ip string
+		allowed bool
+		reason  string
+	}{
+		{"office", "10.1.2.3", true, ""},
+		{"office", "203.0.113.7", true, ""},
+		{"office", "::ffff:10.1.2.3", true, ""},
+		{"office", "10.66.1.1", false, ReasonDenied},
+		{"office", "192.0.2.1", false, ReasonNotAllowed},
+		{"blocked", "198.51.100.9", false, ReasonDenied},
+		{"blocked", "192.0.2.1", true, ""},
+		{"typo", "10.1.2.3", false, ReasonNotAllowed},
+		{"unlisted", "192.0.2.1", true, ""},
+	}
+	for _, tc := range cases {
+		allowed, reason := l.Check(tc.key, tc.ip)
+		if allowed != tc.allowed || reason != tc.reason {
+			t.Errorf("Check(%s, %s) = %v, %q; want %v, %q", tc.key, tc.ip, allowed, reason, tc.allowed, tc.reason)
+		}
+	}
+}
+
+func TestMiddlewareRejectsDisallowedIPWith403(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := New(config.IPAccessConfig{Keys: map[string]config.IPAccessRule{"k": {Allow: []string{"10.0.0.0/8"}}}})
+	reached := false
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) { c.Set("apiKey", "k") }, l.Middleware())
+	engine.GET("/v1/models", func(c *gin.Context) { reached = true })
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	req.RemoteAddr = "192.0.2.1:5000"
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || reached {
+		t.Fatalf("status %d, reached %v; want 403 before the handler", rec.Code, reached)
+	}
+
+	req.RemoteAddr = "10.0.0.5:5000"
+	rec = httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !reached {
+		t.Fatalf("status %d, reached %v; want allowed", rec.Code, reached)
+	}
+}
+
+func TestNormalizeEntries(t *testing.T) {
+	got, err := NormalizeEntries([]string{" 10.1.2.3/8 ", "2001:db8::1", ""})
+	if err != nil || len(got) != 2 || got[0] != "10.0.0.0/8" || got[1] != "2001:db8::1" {
+		t.Fatalf("NormalizeEntries() = %v, %v", got, err)
+	}
+	if _, err = NormalizeEntries([]string{"not-an-ip"}); err == nil {
+		t.Fatalf("expected an error for an invalid entry")
+	}
+}
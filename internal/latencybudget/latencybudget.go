@@ -0,0 +1,200 @@
+// Package latencybudget bounds the total time a proxied request may take. The
+// request context carries the budget as a deadline, so the upstream call is
+// cancelled as soon as it is exceeded; the client then gets a structured 504
+// instead of waiting for slow upstream timeouts.
+package latencybudget
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+)
+
+// Budget sources reported by Resolve
+const (
+	SourceKey      = "key"
+	SourceEndpoint = "endpoint"
+	SourceDefault  = "default"
+)
+
+var exceeded = metrics.Default().NewCounterVec(
+	"cliproxy_latency_budget_exceeded_total",
+	"Requests cancelled because they exceeded their latency budget, by budget source.",
+	"source",
+)
+
+type endpointBudget struct {
+	prefix string
+	budget time.Duration
+}
+
+// Budgets resolves and enforces latency budgets.
+type Budgets struct {
+	mu        sync.RWMutex
+	enabled   bool
+	fallback  time.Duration
+	endpoints []endpointBudget
+	keys      map[string]time.Duration
+}
+
+// New creates budgets from configuration.
+func New(cfg config.LatencyBudgetConfig) *Budgets {
+	b := &Budgets{}
+	b.Update(cfg)
+	return b
+}
+
+// Update replaces the configuration. Requests in flight keep their budget.
+func (b *Budgets) Update(cfg config.LatencyBudgetConfig) {
+	endpoints := make([]endpointBudget, 0, len(cfg.Endpoints))
+	for prefix, ms := range cfg.Endpoints {
+		if prefix = strings.TrimSpace(prefix); prefix != "" && ms > 0 {
+			endpoints = append(endpoints, endpointBudget{prefix: prefix, budget: time.Duration(ms) * time.Millisecond})
+		}
+	}
+	// Longest prefix wins.
+	sort.Slice(endpoints, func(i, j int) bool { return len(endpoints[i].prefix) > len(endpoints[j].prefix) })
+	keys := make(map[string]time.Duration, len(cfg.Keys))
+	for key, ms := range cfg.Keys {
+		if ms > 0 {
+			keys[key] = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	b.mu.Lock()
+	b.enabled = cfg.Enabled
+	b.fallback = time.Duration(max(cfg.DefaultMs, 0)) * time.Millisecond
+	b.endpoints = endpoints
+	b.keys = keys
+	b.mu.Unlock()
+}
+
+// Resolve returns the budget of a request to path by apiKey and where it comes
+// from. A zero budget means the request is unbounded.
+func (b *Budgets) Resolve(path, apiKey string) (time.Duration, string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if !b.enabled {
+		return 0, ""
+	}
+	if budget, ok := b.keys[apiKey]; ok && apiKey != "" {
+		return budget, SourceKey
+	}
+	for _, e := range b.endpoints {
+		if matchesPrefix(path, e.prefix) {
+			return e.budget, SourceEndpoint
+		}
+	}
+	if b.fallback > 0 {
+		return b.fallback, SourceDefault
+	}
+	return 0, ""
+}
+
+// matchesPrefix reports whether path is prefix or lies below it, so "/v1" does
+// not match "/v1beta".
+func matchesPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// Middleware runs the rest of the chain under the request's budget. It must
+// run after authentication so per-key budgets apply.
+func (b *Budgets) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		budget, source := b.Resolve(c.Request.URL.Path, c.GetString("apiKey"))
+		if budget <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), budget)
+		defer cancel()
+		original := c.Request
+		c.Request = original.WithContext(ctx)
+		writer := &budgetWriter{ResponseWriter: c.Writer, ctx: ctx}
+		c.Writer = writer
+		started := time.Now()
+
+		c.Next()
+
+		c.Request = original
+		c.Writer = writer.ResponseWriter
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return
+		}
+		exceeded.Inc(source)
+		if c.Writer.Written() {
+			// The response had started; cancelling upstream already ended it.
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{
+			"error":      "latency_budget_exceeded",
+			"message":    "The request did not complete within its latency budget of " + budget.String(),
+			"budget_ms":  budget.Milliseconds(),
+			"elapsed_ms": time.Since(started).Milliseconds(),
+		})
+	}
+}
+
+// budgetWriter drops the response a handler writes after the budget expired
+// (typically a generic cancellation error) so the middleware can answer 504.
+// Responses started before the deadline pass through unchanged.
+type budgetWriter struct {
+	gin.ResponseWriter
+	ctx context.Context
+}
+
+// held reports whether writes are dropped.
+func (w *budgetWriter) held() bool {
+	return !w.ResponseWriter.Written() && w.ctx.Err() != nil
+}
+
+func (w *budgetWriter) WriteHeader(code int) {
+	if w.held() {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *budgetWriter) WriteHeaderNow() {
+	if w.held() {
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *budgetWriter) Write(data []byte) (int, error) {
+	if w.held() {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *budgetWriter) WriteString(s string) (int, error) {
+	if w.held() {
+		return len(s), nil
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *budgetWriter) Flush() {
+	if w.held() {
+		return
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *budgetWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
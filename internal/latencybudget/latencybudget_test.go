@@ -0,0 +1,63 @@
+package latencybudget
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func TestResolvePrefersKeyThenLongestEndpoint(t *testing.T) {
+	b := New(config.LatencyBudgetConfig{
+		Enabled:   true,
+		DefaultMs: 1000,
+		Endpoints: map[string]int{"/v1": 2000, "/v1/messages": 3000},
+		Keys:      map[string]int{"vip": 4000},
+	})
+	cases := []struct {
+		path, key string
+		want      time.Duration
+		source    string
+	}{
+		{"/v1/messages", "vip", 4 * time.Second, SourceKey},
+		{"/v1/messages/count_tokens", "k", 3 * time.Second, SourceEndpoint},
+		{"/v1/chat/completions", "k", 2 * time.Second, SourceEndpoint},
+		{"/v1beta/models", "k", time.Second, SourceDefault},
+	}
+	for _, tc := range cases {
+		got, source := b.Resolve(tc.path, tc.key)
+		if got != tc.want || source != tc.source {
+			t.Errorf("Resolve(%q, %q) = %v %s, want %v %s", tc.path, tc.key, got, source, tc.want, tc.source)
+		}
+	}
+}
+
+func TestMiddlewareCancelsUpstreamAndReturns504(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	b := New(config.LatencyBudgetConfig{Enabled: true, DefaultMs: 20})
+	engine := gin.New()
+	engine.Use(b.Middleware())
+	cancelled := false
+	engine.POST("/v1/messages", func(c *gin.Context) {
+		select {
+		case <-c.Request.Context().Done():
+			cancelled = true
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "context canceled"})
+		case <-time.After(time.Second):
+			c.JSON(http.StatusOK, gin.H{"ok": true})
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	if !cancelled {
+		t.Fatal("expected the handler context to be cancelled at the budget")
+	}
+	if rec.Code != http.StatusGatewayTimeout || !strings.Contains(rec.Body.String(), "latency_budget_exceeded") {
+		t.Fatalf("expected structured 504, got %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,131 @@
+package limits
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Algorithms enforcing requests per minute
+const (
+	// AlgorithmFixedWindow counts requests per calendar minute.
+	AlgorithmFixedWindow = "fixed-window"
+	// AlgorithmTokenBucket refills continuously and allows bursts up to the per-minute limit.
+	AlgorithmTokenBucket = "token-bucket"
+	// AlgorithmSlidingWindow counts the requests of the last 60 seconds.
+	AlgorithmSlidingWindow = "sliding-window"
+	// AlgorithmGCRA spaces requests evenly over the minute, allowing Burst at once.
+	AlgorithmGCRA = "gcra"
+)
+
+// normalizeAlgorithm returns the canonical algorithm name, or "" for an empty
+// or unknown one so the limit inherits the default.
+func normalizeAlgorithm(name string) string {
+	switch name = strings.ToLower(strings.TrimSpace(name)); name {
+	case "":
+		return ""
+	case AlgorithmFixedWindow, AlgorithmTokenBucket, AlgorithmSlidingWindow, AlgorithmGCRA:
+		return name
+	default:
+		log.Warnf("client-limits: unknown algorithm %q, using the default", name)
+		return ""
+	}
+}
+
+// emission is the GCRA interval between evenly spaced requests.
+func emission(limit Limit) time.Duration {
+	return time.Minute / time.Duration(limit.RequestsPerMinute)
+}
+
+// burst is the number of requests GCRA admits at once. Default: 1.
+func burst(limit Limit) int {
+	if limit.Burst > 0 {
+		return limit.Burst
+	}
+	return 1
+}
+
+// rateRetryAt returns when the key may send its next request under
+// limit.RequestsPerMinute; a time not after now admits the request. The fixed
+// window must already be rolled over to now.
+func (c *counter) rateRetryAt(limit Limit, now time.Time) time.Time {
+	rpm := float64(limit.RequestsPerMinute)
+	switch limit.Algorithm {
+	case AlgorithmTokenBucket:
+		c.requests.refill(rpm, now)
+		return c.requests.wait(1, rpm, now)
+	case AlgorithmSlidingWindow:
+		c.trimWindow(now)
+		if len(c.window) >= limit.RequestsPerMinute {
+			return c.window[len(c.window)-limit.RequestsPerMinute].Add(time.Minute)
+		}
+	case AlgorithmGCRA:
+		tolerance := emission(limit) * time.Duration(burst(limit)-1)
+		if allowAt := c.gcraTAT(now).Add(-tolerance); now.Before(allowAt) {
+			return allowAt
+		}
+	default:
+		if c.minuteCount >= limit.RequestsPerMinute {
+			return c.minuteStart.Add(time.Minute)
+		}
+	}
+	return now
+}
+
+// recordRate charges an admitted request to the algorithm state.
+func (c *counter) recordRate(limit Limit, now time.Time) {
+	switch limit.Algorithm {
+	case AlgorithmTokenBucket:
+		c.requests.level--
+	case AlgorithmSlidingWindow:
+		c.window = append(c.window, now)
+	case AlgorithmGCRA:
+		c.tat = c.gcraTAT(now).Add(emission(limit))
+	}
+}
+
+// rateState returns the requests left right now and when the full limit is
+// available again.
+func (c *counter) rateState(limit Limit, now time.Time) (int, time.Time) {
+	rpm := float64(limit.RequestsPerMinute)
+	switch limit.Algorithm {
+	case AlgorithmTokenBucket:
+		return c.requests.whole(), c.requests.wait(rpm, rpm, now)
+	case AlgorithmSlidingWindow:
+		if len(c.window) == 0 {
+			return limit.RequestsPerMinute, now
+		}
+		return remaining(limit.RequestsPerMinute, len(c.window)), c.window[len(c.window)-1].Add(time.Minute)
+	case AlgorithmGCRA:
+		interval, size := emission(limit), burst(limit)
+		tat := c.gcraTAT(now)
+		slack := now.Add(interval * time.Duration(size-1)).Sub(tat)
+		if slack < 0 {
+			return 0, tat
+		}
+		return min(int(slack/interval)+1, size), tat
+	default:
+		return remaining(limit.RequestsPerMinute, c.minuteCount), c.minuteStart.Add(time.Minute)
+	}
+}
+
+// trimWindow drops sliding window entries older than a minute.
+func (c *counter) trimWindow(now time.Time) {
+	cutoff := now.Add(-time.Minute)
+	drop := 0
+	for drop < len(c.window) && !c.window[drop].After(cutoff) {
+		drop++
+	}
+	if drop > 0 {
+		c.window = append(c.window[:0], c.window[drop:]...)
+	}
+}
+
+// gcraTAT returns the theoretical arrival time, never earlier than now.
+func (c *counter) gcraTAT(now time.Time) time.Time {
+	if c.tat.Before(now) {
+		return now
+	}
+	return c.tat
+}
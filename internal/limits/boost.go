@@ -0,0 +1,197 @@
+package limits
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+)
+
+// PriorityTierContextKey is the gin context key holding the fair-share tier
+// granted to a request by an active boost.
+const PriorityTierContextKey = "priorityTier"
+
+const maxAuditEntries = 500
+
+// Boost actions recorded in the audit log
+const (
+	BoostGranted = "granted"
+	BoostRevoked = "revoked"
+	BoostExpired = "expired"
+)
+
+// Boost temporarily raises the limits of a client key and optionally moves it
+// to another fair-share tier. It reverts automatically at ExpiresAt.
+type Boost struct {
+	APIKey     string    `json:"api_key"`
+	Multiplier float64   `json:"multiplier"`
+	Tier       string    `json:"tier,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	GrantedAt  time.Time `json:"granted_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// AuditEntry records a change to a key's boost.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Actor  string    `json:"actor,omitempty"`
+	Boost  Boost     `json:"boost"`
+}
+
+// Grant boosts a key for duration, replacing any active boost. The multiplier
+// scales its per-minute and daily limits; unlimited values stay unlimited.
+func (l *Limiter) Grant(apiKey string, multiplier float64, duration time.Duration, tier, reason, actor string) (Boost, error) {
+	if apiKey == "" {
+		return Boost{}, errors.New("api key is required")
+	}
+	if multiplier < 1 {
+		return Boost{}, errors.New("multiplier must be at least 1")
+	}
+	if duration <= 0 {
+		return Boost{}, errors.New("duration must be positive")
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	expired := l.expireBoosts(now)
+	boost := Boost{
+		APIKey:     apiKey,
+		Multiplier: multiplier,
+		Tier:       tier,
+		Reason:     reason,
+		Actor:      actor,
+		GrantedAt:  now,
+		ExpiresAt:  now.Add(duration),
+	}
+	l.boosts[apiKey] = boost
+	l.recordAudit(AuditEntry{Time: now, Action: BoostGranted, Actor: actor, Boost: boost})
+	l.mu.Unlock()
+
+	publishBoostEvents(expired)
+	events.Publish(boostEvent(events.TypeBoostGranted, boost, actor, now))
+	return boost, nil
+}
+
+// Revoke ends a key's boost early. It reports whether a boost was active.
+func (l *Limiter) Revoke(apiKey, actor string) bool {
+	l.mu.Lock()
+	now := l.now()
+	expired := l.expireBoosts(now)
+	boost, ok := l.boosts[apiKey]
+	if ok {
+		delete(l.boosts, apiKey)
+		l.recordAudit(AuditEntry{Time: now, Action: BoostRevoked, Actor: actor, Boost: boost})
+	}
+	l.mu.Unlock()
+
+	publishBoostEvents(expired)
+	if ok {
+		events.Publish(boostEvent(events.TypeBoostRevoked, boost, actor, now))
+	}
+	return ok
+}
+
+// Boosts returns all active boosts.
+func (l *Limiter) Boosts() []Boost {
+	l.mu.Lock()
+	expired := l.expireBoosts(l.now())
+	out := make([]Boost, 0, len(l.boosts))
+	for _, b := range l.boosts {
+		out = append(out, b)
+	}
+	l.mu.Unlock()
+
+	publishBoostEvents(expired)
+	return out
+}
+
+// Audit returns boost audit entries, newest first, up to limit (0 for all).
+func (l *Limiter) Audit(limit int) []AuditEntry {
+	l.mu.Lock()
+	expired := l.expireBoosts(l.now())
+	n := len(l.audit)
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	out := make([]AuditEntry, 0, n)
+	for i := len(l.audit) - 1; i >= 0 && len(out) < n; i-- {
+		out = append(out, l.audit[i])
+	}
+	l.mu.Unlock()
+
+	publishBoostEvents(expired)
+	return out
+}
+
+// boostTier returns the fair-share tier of an active boost for the key.
+func (l *Limiter) boostTier(apiKey string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.boosts[apiKey]; ok && l.now().Before(b.ExpiresAt) {
+		return b.Tier
+	}
+	return ""
+}
+
+// expireBoosts removes boosts that have run out and audits them. Callers must
+// hold l.mu and publish the returned boosts after unlocking.
+func (l *Limiter) expireBoosts(now time.Time) []Boost {
+	var expired []Boost
+	for key, b := range l.boosts {
+		if now.Before(b.ExpiresAt) {
+			continue
+		}
+		delete(l.boosts, key)
+		l.recordAudit(AuditEntry{Time: b.ExpiresAt, Action: BoostExpired, Actor: "system", Boost: b})
+		expired = append(expired, b)
+	}
+	return expired
+}
+
+func (l *Limiter) recordAudit(entry AuditEntry) {
+	l.audit = append(l.audit, entry)
+	if over := len(l.audit) - maxAuditEntries; over > 0 {
+		l.audit = append(l.audit[:0:0], l.audit[over:]...)
+	}
+}
+
+// applyBoost scales a limit by the boost multiplier, rounding up.
+func applyBoost(limit Limit, b Boost) Limit {
+	scale := func(v int) int {
+		if v <= 0 {
+			return v
+		}
+		return int(math.Ceil(float64(v) * b.Multiplier))
+	}
+	limit.RequestsPerMinute = scale(limit.RequestsPerMinute)
+	limit.DailyRequests = scale(limit.DailyRequests)
+	limit.TokensPerMinute = scale(limit.TokensPerMinute)
+	limit.DailyOutputTokens = scale(limit.DailyOutputTokens)
+	limit.MaxConcurrent = scale(limit.MaxConcurrent)
+	limit.Burst = scale(limit.Burst)
+	return limit
+}
+
+func publishBoostEvents(expired []Boost) {
+	for _, b := range expired {
+		events.Publish(boostEvent(events.TypeBoostExpired, b, "system", b.ExpiresAt))
+	}
+}
+
+func boostEvent(typ events.Type, b Boost, actor string, at time.Time) events.Event {
+	return events.Event{
+		Type:   typ,
+		Time:   at,
+		APIKey: b.APIKey,
+		Reason: b.Reason,
+		Actor:  actor,
+		Data: map[string]any{
+			"multiplier": b.Multiplier,
+			"tier":       b.Tier,
+			"expires_at": b.ExpiresAt,
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package limits
+
+import (
+	"context"
+	"math"
+	"time"
+
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+// bucket is a token bucket holding up to capacity tokens and refilled
+// continuously at capacity tokens per minute. Its level may go negative when
+// usage is charged after the fact, which blocks the key until it is repaid.
+type bucket struct {
+	level   float64
+	updated time.Time
+}
+
+// refill adds the tokens accrued since the last update. A new bucket starts full.
+func (b *bucket) refill(capacity float64, now time.Time) {
+	if b.updated.IsZero() {
+		b.level, b.updated = capacity, now
+		return
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.level += capacity * elapsed.Minutes()
+		b.updated = now
+	}
+	if b.level > capacity {
+		b.level = capacity
+	}
+}
+
+// wait returns how long until the bucket holds n tokens.
+func (b *bucket) wait(n, capacity float64, now time.Time) time.Time {
+	if b.level >= n || capacity <= 0 {
+		return now
+	}
+	return now.Add(time.Duration((n - b.level) / capacity * float64(time.Minute)))
+}
+
+// whole returns the bucket level rounded down, never below zero.
+func (b *bucket) whole() int {
+	if b.level <= 0 {
+		return 0
+	}
+	return int(math.Floor(b.level))
+}
+
+// ConsumeTokens charges upstream token usage against the key's tokens-per-minute bucket.
+func (l *Limiter) ConsumeTokens(apiKey string, tokens int64) {
+	if apiKey == "" || tokens <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.cfg.Enabled {
+		return
+	}
+	limit := l.limitFor(apiKey)
+	if limit.TokensPerMinute <= 0 {
+		return
+	}
+	c, ok := l.counters[apiKey]
+	if !ok {
+		c = &counter{}
+		l.counters[apiKey] = c
+	}
+	c.tokens.refill(float64(limit.TokensPerMinute), l.now())
+	c.tokens.level -= float64(tokens)
+}
+
+// UsagePlugin charges reported upstream token usage against tokens-per-minute limits.
+type UsagePlugin struct {
+	limiter *Limiter
+}
+
+// NewUsagePlugin creates a usage plugin feeding limiter.
+func NewUsagePlugin(limiter *Limiter) *UsagePlugin {
+	return &UsagePlugin{limiter: limiter}
+}
+
+// HandleUsage implements coreusage.Plugin.
+func (p *UsagePlugin) HandleUsage(_ context.Context, record coreusage.Record) {
+	if p == nil || p.limiter == nil {
+		return
+	}
+	tokens := record.Detail.TotalTokens
+	if tokens <= 0 {
+		tokens = record.Detail.InputTokens + record.Detail.OutputTokens
+	}
+	p.limiter.ConsumeTokens(record.APIKey, tokens)
+	p.limiter.ConsumeOutputTokens(record.APIKey, record.Detail.OutputTokens)
+}
@@ -0,0 +1,89 @@
+package limits
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/requestclass"
+)
+
+// InFlight is the number of requests a key has in flight and its cap.
+type InFlight struct {
+	Active int `json:"active"`
+	Limit  int `json:"limit"`
+}
+
+// Acquire takes one of the key's concurrent request slots. It returns a
+// release function, which is nil when the slot was not granted, and the cap
+// (0 when concurrency is not limited for the key).
+func (l *Limiter) Acquire(apiKey string) (release func(), limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.cfg.Enabled {
+		return func() {}, 0
+	}
+	limit = l.limitFor(apiKey).MaxConcurrent
+	if limit <= 0 {
+		return func() {}, 0
+	}
+	c := l.counterFor(apiKey)
+	if c.inFlight >= limit {
+		return nil, limit
+	}
+	c.inFlight++
+	released := false
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if !released && c.inFlight > 0 {
+			c.inFlight--
+		}
+		released = true
+	}, limit
+}
+
+// InFlightRequests returns the keys with requests in flight.
+func (l *Limiter) InFlightRequests() map[string]InFlight {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[string]InFlight)
+	for key, c := range l.counters {
+		if c.inFlight > 0 {
+			out[key] = InFlight{Active: c.inFlight, Limit: l.limitFor(key).MaxConcurrent}
+		}
+	}
+	return out
+}
+
+// ConcurrencyMiddleware caps the requests of the authenticated key in flight at
+// once, answering 429 when every slot is taken. A slot is held until the
+// handler returns, so a stream keeps it until it ends. Heartbeat requests are
+// not counted. It runs before Middleware so rejected requests do not use up
+// the key's request rate.
+func (l *Limiter) ConcurrencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := c.GetString("apiKey")
+		if apiKey == "" || requestclass.FromContext(c) == requestclass.Heartbeat {
+			c.Next()
+			return
+		}
+		release, limit := l.Acquire(apiKey)
+		if release == nil {
+			c.Header("X-Concurrency-Limit", strconv.Itoa(limit))
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":   "concurrency_limit_exceeded",
+				"message": "Too many concurrent requests for this API key (limit " + strconv.Itoa(limit) + ")",
+			})
+			events.Publish(events.Event{Type: events.TypeRateLimited, APIKey: apiKey, IP: c.ClientIP(), Actor: "system", Reason: "concurrency limit reached", Data: map[string]any{"limit": "concurrency_limit_exceeded", "path": c.Request.URL.Path}})
+			return
+		}
+		defer release()
+		if limit > 0 {
+			c.Header("X-Concurrency-Limit", strconv.Itoa(limit))
+		}
+		c.Next()
+	}
+}
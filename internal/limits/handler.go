@@ -0,0 +1,129 @@
+package limits
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultBoostMultiplier = 2.0
+
+// Handler serves the management API for temporary limit boosts.
+type Handler struct {
+	limiter *Limiter
+}
+
+// NewHandler creates a management handler for the limiter.
+func NewHandler(limiter *Limiter) *Handler {
+	return &Handler{limiter: limiter}
+}
+
+// RegisterRoutes registers the boost management routes.
+func (h *Handler) RegisterRoutes(group *gin.RouterGroup) {
+	group.GET("/client-limits/boosts", h.ListBoosts)
+	group.POST("/client-limits/boosts", h.GrantBoost)
+	group.DELETE("/client-limits/boosts", h.RevokeBoost)
+	group.GET("/client-limits/boosts/audit", h.BoostAudit)
+	group.GET("/client-limits/in-flight", h.ListInFlight)
+}
+
+type grantBoostRequest struct {
+	APIKey     string  `json:"api_key"`
+	Multiplier float64 `json:"multiplier"`
+	// Duration is a Go duration string such as "2h" or "90m".
+	Duration string `json:"duration"`
+	Tier     string `json:"tier"`
+	Reason   string `json:"reason"`
+}
+
+// ListBoosts returns all active boosts
+// GET /v0/management/client-limits/boosts
+func (h *Handler) ListBoosts(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"boosts": h.limiter.Boosts()})
+}
+
+// ListInFlight returns the requests each key has in flight and its concurrency cap
+// GET /v0/management/client-limits/in-flight
+func (h *Handler) ListInFlight(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": h.limiter.InFlightRequests()})
+}
+
+// GrantBoost grants a key a temporary limit boost that reverts automatically
+// POST /v0/management/client-limits/boosts
+// {"api_key": "...", "multiplier": 2, "duration": "2h", "tier": "premium", "reason": "customer demo"}
+func (h *Handler) GrantBoost(c *gin.Context) {
+	var body grantBoostRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_body",
+			"message": "request body must be a JSON object",
+		})
+		return
+	}
+	duration, err := time.ParseDuration(strings.TrimSpace(body.Duration))
+	if err != nil || duration <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_parameter",
+			"message": "duration must be a positive duration such as \"2h\"",
+		})
+		return
+	}
+	if body.Multiplier == 0 {
+		body.Multiplier = defaultBoostMultiplier
+	}
+	apiKey := strings.TrimSpace(body.APIKey)
+	boost, err := h.limiter.Grant(apiKey, body.Multiplier, duration, strings.ToLower(strings.TrimSpace(body.Tier)), strings.TrimSpace(body.Reason), "admin")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_parameter",
+			"message": err.Error(),
+		})
+		return
+	}
+	log.Infof("client-limits: boosted %s by %gx until %s", util.HideAPIKey(apiKey), boost.Multiplier, boost.ExpiresAt.Format(time.RFC3339))
+	c.JSON(http.StatusCreated, gin.H{"boost": boost})
+}
+
+// RevokeBoost ends a key's boost early
+// DELETE /v0/management/client-limits/boosts?api-key=xxx
+func (h *Handler) RevokeBoost(c *gin.Context) {
+	apiKey := strings.TrimSpace(c.Query("api-key"))
+	if apiKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "missing_parameter",
+			"message": "api-key parameter is required",
+		})
+		return
+	}
+	if !h.limiter.Revoke(apiKey, "admin") {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "not_found",
+			"message": "No active boost for this API key",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
+
+// BoostAudit returns the boost audit log, newest first
+// GET /v0/management/client-limits/boosts/audit[?limit=100]
+func (h *Handler) BoostAudit(c *gin.Context) {
+	limit := 0
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_parameter",
+				"message": "limit must be a positive integer",
+			})
+			return
+		}
+		limit = parsed
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": h.limiter.Audit(limit)})
+}
@@ -0,0 +1,545 @@
+// Package limits enforces proxy-level request limits per client API key and
+// reports them through standard rate-limit and quota response headers so
+// client SDKs can throttle themselves.
+package limits
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/requestclass"
+	"github.com/router-for-me/CLIProxyAPI/v6/sdk/api/handlers"
+)
+
+// OverageContextKey is the gin context key holding the cost multiplier (float64)
+// of a request served beyond a soft daily quota.
+const OverageContextKey = "quotaOverageMultiplier"
+
+const defaultOverageMultiplier = 1.0
+
+// Rejection reasons reported in Status.Reason
+const (
+	ReasonRate   = "rate"
+	ReasonTokens = "tokens"
+	ReasonQuota  = "quota"
+	// ReasonOutputTokens rejects a request because the key's daily output token quota is used up.
+	ReasonOutputTokens = "output_tokens"
+)
+
+// Limit describes the limits applied to a single client key. Zero values are unlimited.
+type Limit struct {
+	RequestsPerMinute int
+	DailyRequests     int
+	// TokensPerMinute caps upstream tokens (input plus output) per minute.
+	TokensPerMinute int
+	// DailyOutputTokens caps output tokens per day, also enforced mid-stream.
+	DailyOutputTokens int
+	// SoftQuota serves requests beyond DailyRequests and marks them as overage instead of rejecting them.
+	SoftQuota bool
+	// OverageMultiplier weights overage requests in cost reports.
+	OverageMultiplier float64
+	// MaxConcurrent caps the requests of the key in flight at once.
+	MaxConcurrent int
+	// Algorithm enforces RequestsPerMinute; empty uses the limiter default.
+	Algorithm string
+	// Burst is the number of requests the GCRA algorithm admits at once. Default: 1.
+	Burst int
+}
+
+// Config holds the limiter configuration.
+type Config struct {
+	Enabled bool
+	// TokenBucket enforces RequestsPerMinute with a token bucket instead of fixed minute
+	// windows for limits that do not name an algorithm.
+	TokenBucket bool
+	Default     Limit
+	// Groups holds the limits of named key groups; KeyGroups maps keys to their group.
+	Groups    map[string]Limit
+	KeyGroups map[string]string
+	Keys      map[string]Limit
+	// Classes changes how request classes count; classes not listed count normally.
+	Classes map[string]ClassLimit
+}
+
+// ClassLimit configures one request class.
+type ClassLimit struct {
+	// Exempt keeps the class out of the key's request rate and daily quota.
+	Exempt bool
+	// RequestsPerMinute caps exempt requests of the class per key; 0 means unlimited.
+	RequestsPerMinute int
+}
+
+// ConfigFromProxy converts the proxy configuration section into a limiter configuration.
+func ConfigFromProxy(cfg config.ClientLimitsConfig) Config {
+	defaults := Limit{
+		RequestsPerMinute: cfg.RequestsPerMinute,
+		DailyRequests:     cfg.DailyRequests,
+		TokensPerMinute:   cfg.TokensPerMinute,
+		DailyOutputTokens: cfg.DailyOutputTokens,
+		SoftQuota:         strings.EqualFold(strings.TrimSpace(cfg.QuotaMode), "soft"),
+		OverageMultiplier: cfg.OverageMultiplier,
+		MaxConcurrent:     cfg.MaxConcurrent,
+		Algorithm:         normalizeAlgorithm(cfg.Algorithm),
+		Burst:             cfg.Burst,
+	}
+	if defaults.OverageMultiplier <= 0 {
+		defaults.OverageMultiplier = defaultOverageMultiplier
+	}
+	out := Config{
+		Enabled:     cfg.Enabled,
+		TokenBucket: strings.EqualFold(strings.TrimSpace(cfg.Algorithm), AlgorithmTokenBucket),
+		Default:     defaults,
+		Groups:      make(map[string]Limit, len(cfg.Groups)),
+		KeyGroups:   make(map[string]string),
+		Keys:        make(map[string]Limit, len(cfg.Keys)),
+		Classes:     make(map[string]ClassLimit, len(cfg.Classes)),
+	}
+	for _, group := range cfg.Groups {
+		name := strings.TrimSpace(group.Name)
+		if name == "" {
+			continue
+		}
+		out.Groups[name] = limitFromConfig(group.ClientLimit, defaults)
+		for _, key := range group.APIKeys {
+			if _, assigned := out.KeyGroups[key]; !assigned {
+				out.KeyGroups[key] = name
+			}
+		}
+	}
+	for key, l := range cfg.Keys {
+		out.Keys[key] = limitFromConfig(l, defaults)
+	}
+	for class, l := range cfg.Classes {
+		out.Classes[strings.ToLower(strings.TrimSpace(class))] = ClassLimit{Exempt: l.Exempt, RequestsPerMinute: l.RequestsPerMinute}
+	}
+	return out
+}
+
+// limitFromConfig converts a per-key or group limit, inheriting the quota mode,
+// overage multiplier, algorithm and burst from defaults when unset.
+func limitFromConfig(l config.ClientLimit, defaults Limit) Limit {
+	limit := Limit{
+		RequestsPerMinute: l.RequestsPerMinute,
+		DailyRequests:     l.DailyRequests,
+		TokensPerMinute:   l.TokensPerMinute,
+		DailyOutputTokens: l.DailyOutputTokens,
+		SoftQuota:         defaults.SoftQuota,
+		OverageMultiplier: defaults.OverageMultiplier,
+		MaxConcurrent:     l.MaxConcurrent,
+		Algorithm:         defaults.Algorithm,
+		Burst:             defaults.Burst,
+	}
+	if algorithm := normalizeAlgorithm(l.Algorithm); algorithm != "" {
+		limit.Algorithm = algorithm
+	}
+	if l.Burst > 0 {
+		limit.Burst = l.Burst
+	}
+	if mode := strings.TrimSpace(l.QuotaMode); mode != "" {
+		limit.SoftQuota = strings.EqualFold(mode, "soft")
+	}
+	if l.OverageMultiplier > 0 {
+		limit.OverageMultiplier = l.OverageMultiplier
+	}
+	return limit
+}
+
+// Status is the outcome of a limit check for one request.
+type Status struct {
+	Limit           Limit
+	Allowed         bool
+	RateRemaining   int
+	RateReset       time.Time
+	QuotaRemaining  int
+	QuotaReset      time.Time
+	TokensRemaining int
+	TokensReset     time.Time
+	// OutputRemaining is what is left of the daily output token quota; OutputReset is when it resets.
+	OutputRemaining int64
+	OutputReset     time.Time
+	// Overage is set when the request exceeds a soft quota and is served as overage.
+	Overage bool
+	// Reason names the exhausted limit of a rejected request; RetryAt is when it frees up.
+	Reason  string
+	RetryAt time.Time
+}
+
+type counter struct {
+	minuteStart time.Time
+	minuteCount int
+	dayStart    time.Time
+	dayCount    int
+	// requests is the token-bucket request rate state; tokens is the tokens-per-minute budget.
+	requests bucket
+	tokens   bucket
+	// window holds the admission times of the last minute for the sliding-window algorithm.
+	window []time.Time
+	// tat is the theoretical arrival time of the next request under GCRA.
+	tat time.Time
+	// outputDay and outputUsed count output tokens against the daily output quota.
+	outputDay  time.Time
+	outputUsed int64
+	// outputReserved is the output of streams in flight that no usage record has charged yet.
+	outputReserved int64
+	// classes counts exempt request classes with their own per-minute cap.
+	classes map[string]*classWindow
+	// inFlight counts admitted requests that have not finished yet.
+	inFlight int
+}
+
+// classWindow counts the requests of one exempt class in fixed minute windows.
+type classWindow struct {
+	minuteStart time.Time
+	count       int
+}
+
+// Limiter tracks per-key request counts with the configured rate algorithms.
+type Limiter struct {
+	mu       sync.Mutex
+	cfg      Config
+	counters map[string]*counter
+	// boosts holds temporary per-key limit boosts; audit records their history.
+	boosts map[string]Boost
+	audit  []AuditEntry
+	now    func() time.Time
+}
+
+// New creates a limiter.
+func New(cfg Config) *Limiter {
+	return &Limiter{cfg: cfg, counters: make(map[string]*counter), boosts: make(map[string]Boost), now: time.Now}
+}
+
+// Update replaces the configuration, keeping current counters and boosts.
+func (l *Limiter) Update(cfg Config) {
+	l.mu.Lock()
+	l.cfg = cfg
+	l.mu.Unlock()
+}
+
+// Limit sources reported by Effective
+const (
+	SourceKey     = "key"
+	SourceGroup   = "group"
+	SourceDefault = "default"
+)
+
+// Effective returns the limit applied to a key, whether it comes from a per-key
+// override or the defaults, and the active boost already folded into it, if any.
+func (l *Limiter) Effective(apiKey string) (Limit, string, *Boost) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	source := SourceDefault
+	if _, ok := l.cfg.Keys[apiKey]; ok {
+		source = SourceKey
+	} else if _, ok = l.cfg.Groups[l.cfg.KeyGroups[apiKey]]; ok {
+		source = SourceGroup
+	}
+	var boost *Boost
+	if b, ok := l.boosts[apiKey]; ok && l.now().Before(b.ExpiresAt) {
+		boost = &b
+	}
+	return l.limitFor(apiKey), source, boost
+}
+
+// Enabled reports whether limiting is on.
+func (l *Limiter) Enabled() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg.Enabled
+}
+
+// limitFor returns the limit for a key including any active boost, with the
+// algorithm resolved. Callers must hold l.mu.
+func (l *Limiter) limitFor(apiKey string) Limit {
+	limit, ok := l.cfg.Keys[apiKey]
+	if !ok {
+		limit, ok = l.cfg.Groups[l.cfg.KeyGroups[apiKey]]
+	}
+	if !ok {
+		limit = l.cfg.Default
+	}
+	if b, boosted := l.boosts[apiKey]; boosted {
+		limit = applyBoost(limit, b)
+	}
+	if limit.Algorithm == "" {
+		limit.Algorithm = AlgorithmFixedWindow
+		if l.cfg.TokenBucket {
+			limit.Algorithm = AlgorithmTokenBucket
+		}
+	}
+	return limit
+}
+
+// Allow checks and, when allowed, records a request for the key.
+// The second return value is false when limiting is disabled.
+func (l *Limiter) Allow(apiKey string) (Status, bool) {
+	return l.AllowClass(apiKey, requestclass.New)
+}
+
+// AllowClass is Allow for a request of the given class. Exempt classes skip
+// the key's request rate and daily quota and are checked against their own
+// per-minute cap instead.
+func (l *Limiter) AllowClass(apiKey, class string) (Status, bool) {
+	l.mu.Lock()
+	now := l.now()
+	expired := l.expireBoosts(now)
+	var status Status
+	var enabled bool
+	if classLimit, ok := l.cfg.Classes[class]; ok && classLimit.Exempt {
+		status, enabled = l.allowExemptLocked(apiKey, class, classLimit, now)
+	} else {
+		status, enabled = l.allowLocked(apiKey, now)
+	}
+	l.mu.Unlock()
+
+	publishBoostEvents(expired)
+	return status, enabled
+}
+
+// counterFor returns the counter of a key, creating it. Callers must hold l.mu.
+func (l *Limiter) counterFor(apiKey string) *counter {
+	c, ok := l.counters[apiKey]
+	if !ok {
+		c = &counter{}
+		l.counters[apiKey] = c
+	}
+	return c
+}
+
+// allowExemptLocked checks a request of an exempt class: only the class's own
+// per-minute cap and the key's token budget apply. Callers must hold l.mu.
+func (l *Limiter) allowExemptLocked(apiKey, class string, classLimit ClassLimit, now time.Time) (Status, bool) {
+	if !l.cfg.Enabled {
+		return Status{}, false
+	}
+	keyLimit := l.limitFor(apiKey)
+	limit := Limit{RequestsPerMinute: classLimit.RequestsPerMinute, TokensPerMinute: keyLimit.TokensPerMinute, DailyOutputTokens: keyLimit.DailyOutputTokens}
+	if limit.RequestsPerMinute <= 0 && limit.TokensPerMinute <= 0 && limit.DailyOutputTokens <= 0 {
+		return Status{}, false
+	}
+	c := l.counterFor(apiKey)
+	minuteStart := now.Truncate(time.Minute)
+	status := Status{Limit: limit, Allowed: true, RateReset: minuteStart.Add(time.Minute)}
+	reject := func(reason string, retryAt time.Time) {
+		if status.Allowed {
+			status.Allowed, status.Reason, status.RetryAt = false, reason, retryAt
+		}
+	}
+	var window *classWindow
+	if limit.RequestsPerMinute > 0 {
+		if c.classes == nil {
+			c.classes = make(map[string]*classWindow)
+		}
+		if window = c.classes[class]; window == nil {
+			window = &classWindow{}
+			c.classes[class] = window
+		}
+		if !window.minuteStart.Equal(minuteStart) {
+			window.minuteStart, window.count = minuteStart, 0
+		}
+		if window.count >= limit.RequestsPerMinute {
+			reject(ReasonRate, status.RateReset)
+		}
+	}
+	if limit.TokensPerMinute > 0 {
+		tpm := float64(limit.TokensPerMinute)
+		c.tokens.refill(tpm, now)
+		if c.tokens.level <= 0 {
+			reject(ReasonTokens, c.tokens.wait(1, tpm, now))
+		}
+		status.TokensRemaining = c.tokens.whole()
+		status.TokensReset = c.tokens.wait(tpm, tpm, now)
+	}
+	l.checkOutputLocked(c, limit, now, &status, reject)
+	if window != nil {
+		if status.Allowed {
+			window.count++
+		}
+		status.RateRemaining = remaining(limit.RequestsPerMinute, window.count)
+	}
+	return status, true
+}
+
+func (l *Limiter) allowLocked(apiKey string, now time.Time) (Status, bool) {
+	if !l.cfg.Enabled {
+		return Status{}, false
+	}
+	limit := l.limitFor(apiKey)
+	if limit.RequestsPerMinute <= 0 && limit.DailyRequests <= 0 && limit.TokensPerMinute <= 0 && limit.DailyOutputTokens <= 0 {
+		return Status{}, false
+	}
+
+	minuteStart := now.Truncate(time.Minute)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	c := l.counterFor(apiKey)
+	if !c.minuteStart.Equal(minuteStart) {
+		c.minuteStart, c.minuteCount = minuteStart, 0
+	}
+	if !c.dayStart.Equal(dayStart) {
+		c.dayStart, c.dayCount = dayStart, 0
+	}
+
+	status := Status{
+		Limit:      limit,
+		Allowed:    true,
+		RateReset:  minuteStart.Add(time.Minute),
+		QuotaReset: dayStart.AddDate(0, 0, 1),
+	}
+	reject := func(reason string, retryAt time.Time) {
+		if status.Allowed {
+			status.Allowed, status.Reason, status.RetryAt = false, reason, retryAt
+		}
+	}
+	if limit.RequestsPerMinute > 0 {
+		if retryAt := c.rateRetryAt(limit, now); retryAt.After(now) {
+			reject(ReasonRate, retryAt)
+		}
+	}
+	tpm := float64(limit.TokensPerMinute)
+	if limit.TokensPerMinute > 0 {
+		c.tokens.refill(tpm, now)
+		if c.tokens.level <= 0 {
+			reject(ReasonTokens, c.tokens.wait(1, tpm, now))
+		}
+		status.TokensRemaining = c.tokens.whole()
+		status.TokensReset = c.tokens.wait(tpm, tpm, now)
+	}
+	l.checkOutputLocked(c, limit, now, &status, reject)
+	if limit.DailyRequests > 0 && c.dayCount >= limit.DailyRequests {
+		if limit.SoftQuota {
+			status.Overage = true
+		} else {
+			reject(ReasonQuota, status.QuotaReset)
+		}
+	}
+	if status.Allowed {
+		c.minuteCount++
+		c.dayCount++
+		if limit.RequestsPerMinute > 0 {
+			c.recordRate(limit, now)
+		}
+	}
+	if limit.RequestsPerMinute > 0 {
+		status.RateRemaining, status.RateReset = c.rateState(limit, now)
+	}
+	status.QuotaRemaining = remaining(limit.DailyRequests, c.dayCount)
+	return status, true
+}
+
+func remaining(limit, used int) int {
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+// Middleware enforces limits for the authenticated client key and sets
+// X-RateLimit-* and X-Quota-* headers on every response. The request class
+// set by requestclass.Middleware selects exemptions.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := c.GetString("apiKey")
+		if apiKey == "" {
+			c.Next()
+			return
+		}
+		if tier := l.boostTier(apiKey); tier != "" {
+			c.Set(PriorityTierContextKey, tier)
+		}
+		status, enabled := l.AllowClass(apiKey, requestclass.FromContext(c))
+		if !enabled {
+			c.Next()
+			return
+		}
+
+		now := l.now()
+		setHeaders(c, status, now)
+		if status.Allowed {
+			if status.Overage {
+				c.Header("X-Quota-Overage", "true")
+				c.Set(OverageContextKey, status.Limit.OverageMultiplier)
+			}
+			if status.Limit.DailyOutputTokens > 0 {
+				c.Set(handlers.StreamOutputBudgetContextKey, &OutputBudget{limiter: l, apiKey: apiKey})
+			}
+			c.Next()
+			return
+		}
+
+		errCode, message := "rate_limit_exceeded", "Request rate limit exceeded for this API key"
+		switch status.Reason {
+		case ReasonTokens:
+			errCode, message = "token_rate_limit_exceeded", "Token rate limit exceeded for this API key"
+		case ReasonQuota:
+			errCode, message = "quota_exceeded", "Daily request quota exceeded for this API key"
+		case ReasonOutputTokens:
+			errCode, message = "output_quota_exceeded", "Daily output token quota exceeded for this API key"
+		}
+		c.Header("Retry-After", strconv.Itoa(secondsUntil(status.RetryAt, now)))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error":   errCode,
+			"message": message,
+		})
+		events.Publish(events.Event{Type: events.TypeRateLimited, APIKey: apiKey, IP: c.ClientIP(), Actor: "system", Reason: message, Data: map[string]any{"limit": errCode, "path": c.Request.URL.Path}})
+	}
+}
+
+func setHeaders(c *gin.Context, status Status, now time.Time) {
+	if status.Limit.RequestsPerMinute > 0 {
+		c.Header("X-RateLimit-Limit", strconv.Itoa(status.Limit.RequestsPerMinute))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(status.RateRemaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(secondsUntil(status.RateReset, now)))
+		if status.Limit.Algorithm != "" {
+			c.Header("X-RateLimit-Algorithm", status.Limit.Algorithm)
+			c.Header("X-RateLimit-Policy", ratePolicy(status.Limit))
+		}
+	}
+	if status.Limit.TokensPerMinute > 0 {
+		c.Header("X-RateLimit-Limit-Tokens", strconv.Itoa(status.Limit.TokensPerMinute))
+		c.Header("X-RateLimit-Remaining-Tokens", strconv.Itoa(status.TokensRemaining))
+		c.Header("X-RateLimit-Reset-Tokens", strconv.Itoa(secondsUntil(status.TokensReset, now)))
+	}
+	if status.Limit.DailyRequests > 0 {
+		c.Header("X-Quota-Limit", strconv.Itoa(status.Limit.DailyRequests))
+		c.Header("X-Quota-Remaining", strconv.Itoa(status.QuotaRemaining))
+		c.Header("X-Quota-Reset", strconv.Itoa(secondsUntil(status.QuotaReset, now)))
+	}
+	if status.Limit.DailyOutputTokens > 0 {
+		c.Header("X-Quota-Limit-Output-Tokens", strconv.Itoa(status.Limit.DailyOutputTokens))
+		c.Header("X-Quota-Remaining-Output-Tokens", strconv.FormatInt(status.OutputRemaining, 10))
+		c.Header("X-Quota-Reset-Output-Tokens", strconv.Itoa(secondsUntil(status.OutputReset, now)))
+	}
+}
+
+// ratePolicy describes the request rate limit in the RateLimit-Policy format,
+// e.g. "60;w=60;policy=gcra;burst=5".
+func ratePolicy(limit Limit) string {
+	policy := strconv.Itoa(limit.RequestsPerMinute) + ";w=60;policy=" + limit.Algorithm
+	switch limit.Algorithm {
+	case AlgorithmGCRA:
+		policy += ";burst=" + strconv.Itoa(burst(limit))
+	case AlgorithmTokenBucket:
+		policy += ";burst=" + strconv.Itoa(limit.RequestsPerMinute)
+	}
+	return policy
+}
+
+// secondsUntil returns whole seconds until t, rounded up and never below one.
+func secondsUntil(t, now time.Time) int {
+	d := t.Sub(now)
+	secs := int(d / time.Second)
+	if d%time.Second > 0 {
+		secs++
+	}
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
@@ -0,0 +1,416 @@
+package limits
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/requestclass"
+	"github.com/router-for-me/CLIProxyAPI/v6/sdk/api/handlers"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+func newTestEngine(l *Limiter) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("X-Test-Key"))
+		c.Next()
+	})
+	engine.Use(l.Middleware())
+	engine.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return engine
+}
+
+func doRequest(engine *gin.Engine, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Test-Key", key)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddlewareSetsHeadersAndRejectsOverLimit(t *testing.T) {
+	l := New(Config{
+		Enabled: true,
+		Default: Limit{RequestsPerMinute: 2, DailyRequests: 10},
+	})
+	l.now = func() time.Time { return time.Date(2025, 1, 1, 12, 0, 30, 0, time.UTC) }
+	engine := newTestEngine(l)
+
+	rec := doRequest(engine, "k1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "1" {
+		t.Fatalf("X-RateLimit-Remaining = %q, want 1", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Reset"); got != "30" {
+		t.Fatalf("X-RateLimit-Reset = %q, want 30", got)
+	}
+	if got := rec.Header().Get("X-Quota-Remaining"); got != "9" {
+		t.Fatalf("X-Quota-Remaining = %q, want 9", got)
+	}
+
+	doRequest(engine, "k1")
+	rec = doRequest(engine, "k1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Fatalf("Retry-After = %q, want 30", got)
+	}
+	if got := rec.Header().Get("X-Quota-Remaining"); got != "8" {
+		t.Fatalf("rejected request must not consume quota, X-Quota-Remaining = %q", got)
+	}
+}
+
+func TestPerKeyLimitOverridesDefault(t *testing.T) {
+	l := New(Config{
+		Enabled: true,
+		Default: Limit{RequestsPerMinute: 1},
+		Keys:    map[string]Limit{"vip": {}},
+	})
+	engine := newTestEngine(l)
+
+	for i := 0; i < 3; i++ {
+		rec := doRequest(engine, "vip")
+		if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("expected unlimited key to pass without headers, got %d", rec.Code)
+		}
+	}
+}
+
+func TestSoftQuotaServesOverage(t *testing.T) {
+	l := New(Config{
+		Enabled: true,
+		Default: Limit{DailyRequests: 1, SoftQuota: true, OverageMultiplier: 2},
+	})
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", "k1")
+		c.Next()
+	})
+	engine.Use(l.Middleware())
+	var multiplier any
+	engine.GET("/", func(c *gin.Context) {
+		multiplier, _ = c.Get(OverageContextKey)
+		c.Status(http.StatusOK)
+	})
+
+	doRequest(engine, "k1")
+	if multiplier != nil {
+		t.Fatalf("request within quota must not be overage, got %v", multiplier)
+	}
+	rec := doRequest(engine, "k1")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Quota-Overage") != "true" {
+		t.Fatalf("expected overage request to be served and marked, got %d %v", rec.Code, rec.Header())
+	}
+	if multiplier != 2.0 {
+		t.Fatalf("expected multiplier 2 in context, got %v", multiplier)
+	}
+}
+
+func TestBoostRaisesLimitAndRevertsAutomatically(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := New(Config{
+		Enabled: true,
+		Default: Limit{RequestsPerMinute: 1},
+	})
+	l.now = func() time.Time { return now }
+	engine := newTestEngine(l)
+
+	if _, err := l.Grant("k1", 2, 2*time.Hour, "premium", "demo", "admin"); err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if rec := doRequest(engine, "k1"); rec.Code != http.StatusOK {
+			t.Fatalf("boosted request %d: expected 200, got %d", i+1, rec.Code)
+		}
+	}
+	if rec := doRequest(engine, "k1"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 beyond boosted limit, got %d", rec.Code)
+	}
+
+	now = now.Add(2*time.Hour + time.Second)
+	if rec := doRequest(engine, "k1"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Fatalf("expected limit to revert to 1, got %d with limit %q", rec.Code, rec.Header().Get("X-RateLimit-Limit"))
+	}
+	if len(l.Boosts()) != 0 {
+		t.Fatal("expected boost to be removed after expiry")
+	}
+	audit := l.Audit(0)
+	if len(audit) != 2 || audit[0].Action != BoostExpired || audit[1].Action != BoostGranted {
+		t.Fatalf("unexpected audit log: %+v", audit)
+	}
+}
+
+func TestTokenBucketRefillsContinuously(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := New(Config{Enabled: true, TokenBucket: true, Default: Limit{RequestsPerMinute: 2}})
+	l.now = func() time.Time { return now }
+	engine := newTestEngine(l)
+
+	doRequest(engine, "k1")
+	doRequest(engine, "k1")
+	rec := doRequest(engine, "k1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Fatalf("Retry-After = %q, want 30", got)
+	}
+
+	now = now.Add(30 * time.Second)
+	if rec = doRequest(engine, "k1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected a refilled token after 30s, got %d", rec.Code)
+	}
+	if rec = doRequest(engine, "k1"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+}
+
+func TestSlidingWindowSpansMinuteBoundaries(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 50, 0, time.UTC)
+	l := New(Config{Enabled: true, Default: Limit{RequestsPerMinute: 2, Algorithm: AlgorithmSlidingWindow}})
+	l.now = func() time.Time { return now }
+	engine := newTestEngine(l)
+
+	doRequest(engine, "k1")
+	doRequest(engine, "k1")
+	now = now.Add(15 * time.Second)
+	rec := doRequest(engine, "k1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 in the next calendar minute, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "45" {
+		t.Fatalf("Retry-After = %q, want 45", got)
+	}
+	now = now.Add(45 * time.Second)
+	if rec = doRequest(engine, "k1"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != "1" {
+		t.Fatalf("expected 200 with 1 remaining once the window slid, got %d %q", rec.Code, rec.Header().Get("X-RateLimit-Remaining"))
+	}
+}
+
+func TestGCRAAlgorithmPerGroup(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := New(ConfigFromProxy(config.ClientLimitsConfig{
+		Enabled:           true,
+		RequestsPerMinute: 60,
+		Groups: []config.ClientLimitGroup{{
+			Name:        "agents",
+			APIKeys:     []string{"agent"},
+			ClientLimit: config.ClientLimit{RequestsPerMinute: 60, Algorithm: "GCRA", Burst: 2},
+		}},
+	}))
+	l.now = func() time.Time { return now }
+	engine := newTestEngine(l)
+
+	rec := doRequest(engine, "agent")
+	if rec.Header().Get("X-RateLimit-Algorithm") != AlgorithmGCRA || rec.Header().Get("X-RateLimit-Policy") != "60;w=60;policy=gcra;burst=2" {
+		t.Fatalf("unexpected algorithm headers %q %q", rec.Header().Get("X-RateLimit-Algorithm"), rec.Header().Get("X-RateLimit-Policy"))
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "1" {
+		t.Fatalf("X-RateLimit-Remaining = %q, want 1", got)
+	}
+	if rec = doRequest(engine, "agent"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the burst to admit a second request, got %d", rec.Code)
+	}
+	rec = doRequest(engine, "agent")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After 1, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	now = now.Add(time.Second)
+	if rec = doRequest(engine, "agent"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 one emission interval later, got %d", rec.Code)
+	}
+
+	if rec = doRequest(engine, "other"); rec.Header().Get("X-RateLimit-Algorithm") != AlgorithmFixedWindow {
+		t.Fatalf("keys outside the group should use the default algorithm, got %q", rec.Header().Get("X-RateLimit-Algorithm"))
+	}
+}
+
+func TestTokensPerMinuteBlocksUntilUsageRepaid(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := New(Config{Enabled: true, Default: Limit{TokensPerMinute: 1000}})
+	l.now = func() time.Time { return now }
+	engine := newTestEngine(l)
+
+	if rec := doRequest(engine, "k1"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining-Tokens") != "1000" {
+		t.Fatalf("expected 200 with a full token budget, got %d %q", rec.Code, rec.Header().Get("X-RateLimit-Remaining-Tokens"))
+	}
+	l.ConsumeTokens("k1", 1500)
+	rec := doRequest(engine, "k1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 while in token debt, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "31" {
+		t.Fatalf("Retry-After = %q, want 31", got)
+	}
+	now = now.Add(31 * time.Second)
+	if rec = doRequest(engine, "k1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 once the debt is repaid, got %d", rec.Code)
+	}
+}
+
+func TestGroupLimitAppliesToMemberKeys(t *testing.T) {
+	l := New(Config{
+		Enabled:   true,
+		Default:   Limit{RequestsPerMinute: 1},
+		Groups:    map[string]Limit{"team": {RequestsPerMinute: 3}},
+		KeyGroups: map[string]string{"member": "team"},
+		Keys:      map[string]Limit{"vip": {RequestsPerMinute: 5}},
+	})
+	for key, want := range map[string]string{"member": SourceGroup, "vip": SourceKey, "other": SourceDefault} {
+		if _, source, _ := l.Effective(key); source != want {
+			t.Fatalf("Effective(%q) source = %q, want %q", key, source, want)
+		}
+	}
+	if limit, _, _ := l.Effective("member"); limit.RequestsPerMinute != 3 {
+		t.Fatalf("expected group limit of 3, got %d", limit.RequestsPerMinute)
+	}
+}
+
+func TestExemptRequestClassUsesItsOwnCap(t *testing.T) {
+	l := New(ConfigFromProxy(config.ClientLimitsConfig{
+		Enabled:           true,
+		RequestsPerMinute: 1,
+		DailyRequests:     1,
+		Classes:           map[string]config.RequestClassLimit{"Continuation": {Exempt: true, RequestsPerMinute: 2}},
+	}))
+	l.now = func() time.Time { return time.Date(2025, 1, 1, 12, 0, 30, 0, time.UTC) }
+
+	if status, _ := l.AllowClass("k1", requestclass.New); !status.Allowed {
+		t.Fatalf("first prompt rejected: %+v", status)
+	}
+	if status, _ := l.AllowClass("k1", requestclass.New); status.Allowed {
+		t.Fatal("second prompt allowed beyond the key's limit")
+	}
+	for i := 0; i < 2; i++ {
+		if status, _ := l.AllowClass("k1", requestclass.Continuation); !status.Allowed || status.RateRemaining != 1-i {
+			t.Fatalf("continuation %d: %+v", i, status)
+		}
+	}
+	status, _ := l.AllowClass("k1", requestclass.Continuation)
+	if status.Allowed || status.Reason != ReasonRate {
+		t.Fatalf("continuation beyond its class cap: %+v", status)
+	}
+	if status, _ = l.AllowClass("k1", requestclass.Heartbeat); status.Allowed {
+		t.Fatal("heartbeat without a class entry should count like a prompt")
+	}
+}
+
+func TestDailyOutputTokensEndStreamsAndBlockAdmission(t *testing.T) {
+	l := New(ConfigFromProxy(config.ClientLimitsConfig{Enabled: true, DailyOutputTokens: 10}))
+	var budget handlers.StreamOutputBudget
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", "k1")
+		c.Next()
+	})
+	engine.Use(l.Middleware())
+	engine.GET("/", func(c *gin.Context) {
+		value, _ := c.Get(handlers.StreamOutputBudgetContextKey)
+		budget, _ = value.(handlers.StreamOutputBudget)
+		c.Status(http.StatusOK)
+	})
+
+	if rec := doRequest(engine, "k1"); rec.Code != http.StatusOK || rec.Header().Get("X-Quota-Remaining-Output-Tokens") != "10" {
+		t.Fatalf("expected admission with 10 output tokens left, got %d %v", rec.Code, rec.Header())
+	}
+	if budget == nil {
+		t.Fatal("expected a stream output budget in the context")
+	}
+	first := budget
+	if rec := doRequest(engine, "k1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected a second stream to be admitted, got %d", rec.Code)
+	}
+	second := budget
+
+	text := []byte(`data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"0123456789abcdef"}}`)
+	if first.Consume(text) || second.Consume(text) {
+		t.Fatal("expected 4 estimated tokens per stream to stay within the quota")
+	}
+	// Both streams draw on one reservation: 8 of 10 tokens are taken.
+	if !second.Consume(text) {
+		t.Fatal("expected a chunk beyond the shared reservation to be refused before it is written")
+	}
+	if first.Consume([]byte(`data: {"type":"message_delta","usage":{"output_tokens":6}}`)) {
+		t.Fatal("expected reported usage that exactly fills the quota to pass")
+	}
+	if rec := doRequest(engine, "k1"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected reserved output to block admission, got %d", rec.Code)
+	}
+
+	first.Release()
+	second.Release()
+	NewUsagePlugin(l).HandleUsage(context.Background(), coreusage.Record{APIKey: "k1", Detail: coreusage.Detail{OutputTokens: 10}})
+	rec := doRequest(engine, "k1")
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "output_quota_exceeded") {
+		t.Fatalf("expected output quota rejection, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestConcurrencyMiddlewareCapsInFlightRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := New(ConfigFromProxy(config.ClientLimitsConfig{
+		Enabled:       true,
+		MaxConcurrent: 1,
+		Keys:          map[string]config.ClientLimit{"team": {MaxConcurrent: 2}},
+	}))
+	entered, unblock := make(chan struct{}), make(chan struct{})
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("X-Test-Key"))
+		c.Next()
+	})
+	engine.Use(l.ConcurrencyMiddleware())
+	engine.GET("/", func(c *gin.Context) {
+		if c.Query("hold") != "" {
+			entered <- struct{}{}
+			<-unblock
+		}
+		c.Status(http.StatusOK)
+	})
+	hold := func(key string) chan int {
+		done := make(chan int, 1)
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "/?hold=1", nil)
+			req.Header.Set("X-Test-Key", key)
+			rec := httptest.NewRecorder()
+			engine.ServeHTTP(rec, req)
+			done <- rec.Code
+		}()
+		<-entered
+		return done
+	}
+
+	first := hold("solo")
+	rec := doRequest(engine, "solo")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("X-Concurrency-Limit") != "1" {
+		t.Fatalf("second concurrent request: status %d, limit header %q; want 429 with limit 1", rec.Code, rec.Header().Get("X-Concurrency-Limit"))
+	}
+	second := hold("team")
+	if rec = doRequest(engine, "team"); rec.Code != http.StatusOK {
+		t.Fatalf("per-key cap of 2: status %d, want 200", rec.Code)
+	}
+	if got := l.InFlightRequests(); got["solo"].Active != 1 || got["team"].Active != 1 || got["team"].Limit != 2 {
+		t.Fatalf("InFlightRequests() = %+v", got)
+	}
+
+	close(unblock)
+	if code := <-first; code != http.StatusOK {
+		t.Fatalf("held request finished with %d", code)
+	}
+	<-second
+	if rec = doRequest(engine, "solo"); rec.Code != http.StatusOK {
+		t.Fatalf("slot should be released after the request ends, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,188 @@
+package limits
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// charsPerToken approximates how many characters of generated text make one token
+// when estimating output from stream chunks.
+const charsPerToken = 4
+
+// streamTextPaths are the fields carrying generated text in stream chunks of the
+// client-facing APIs (Claude, OpenAI chat, OpenAI responses, Gemini).
+var streamTextPaths = []string{
+	"delta.text",
+	"delta.thinking",
+	"delta.partial_json",
+	"choices.#.delta.content",
+	"choices.#.delta.reasoning_content",
+	"choices.#.delta.tool_calls.#.function.arguments",
+	"delta",
+	"candidates.#.content.parts.#.text",
+}
+
+// streamUsagePaths are the fields reporting the output tokens generated so far.
+var streamUsagePaths = []string{
+	"usage.output_tokens",
+	"usage.completion_tokens",
+	"response.usage.output_tokens",
+	"usageMetadata.candidatesTokenCount",
+}
+
+// rollOutputLocked resets the daily output counter at local midnight. Callers must hold l.mu.
+func rollOutputLocked(c *counter, now time.Time) time.Time {
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !c.outputDay.Equal(dayStart) {
+		c.outputDay, c.outputUsed = dayStart, 0
+	}
+	return dayStart
+}
+
+// checkOutputLocked rejects a request once the key's daily output quota is used up
+// and reports what is left of it. Callers must hold l.mu.
+func (l *Limiter) checkOutputLocked(c *counter, limit Limit, now time.Time, status *Status, reject func(string, time.Time)) {
+	if limit.DailyOutputTokens <= 0 {
+		return
+	}
+	dayStart := rollOutputLocked(c, now)
+	status.OutputReset = dayStart.AddDate(0, 0, 1)
+	status.OutputRemaining = int64(limit.DailyOutputTokens) - c.outputUsed - c.outputReserved
+	if status.OutputRemaining <= 0 {
+		status.OutputRemaining = 0
+		reject(ReasonOutputTokens, status.OutputReset)
+	}
+}
+
+// ConsumeOutputTokens charges reported output tokens against the key's daily output quota.
+func (l *Limiter) ConsumeOutputTokens(apiKey string, tokens int64) {
+	if apiKey == "" || tokens <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.cfg.Enabled || l.limitFor(apiKey).DailyOutputTokens <= 0 {
+		return
+	}
+	c := l.counterFor(apiKey)
+	rollOutputLocked(c, l.now())
+	c.outputUsed += tokens
+}
+
+// reserveOutput sets tokens of a stream aside against the key's daily output
+// quota, so concurrent streams of the key share one budget. It reports false,
+// reserving nothing, when the quota cannot cover them.
+func (l *Limiter) reserveOutput(apiKey string, tokens int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit := l.limitFor(apiKey)
+	if !l.cfg.Enabled || limit.DailyOutputTokens <= 0 {
+		return true
+	}
+	c := l.counterFor(apiKey)
+	rollOutputLocked(c, l.now())
+	if c.outputUsed+c.outputReserved+tokens > int64(limit.DailyOutputTokens) {
+		return false
+	}
+	c.outputReserved += tokens
+	return true
+}
+
+// releaseOutput drops a finished stream's reservation; its usage record charges the actual count.
+func (l *Limiter) releaseOutput(apiKey string, tokens int64) {
+	if tokens <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.counters[apiKey]; ok {
+		c.outputReserved = max(c.outputReserved-tokens, 0)
+	}
+}
+
+// OutputBudget enforces a key's daily output quota on one streaming response.
+// Output is estimated from the chunks, or taken from usage the upstream reports
+// mid-stream, and reserved in the limiter before each chunk is written; the
+// final usage record then charges the quota with the actual count.
+type OutputBudget struct {
+	limiter  *Limiter
+	apiKey   string
+	chars    int64
+	reported int64
+	reserved int64
+}
+
+// Consume implements handlers.StreamOutputBudget.
+func (b *OutputBudget) Consume(chunk []byte) bool {
+	if b == nil || b.limiter == nil {
+		return false
+	}
+	chars, reported := scanStreamChunk(chunk)
+	streamed := streamedTokens(b.chars+chars, max(b.reported, reported))
+	if !b.limiter.reserveOutput(b.apiKey, max(streamed-b.reserved, 0)) {
+		return true
+	}
+	b.chars += chars
+	b.reported = max(b.reported, reported)
+	b.reserved = max(b.reserved, streamed)
+	return false
+}
+
+// Release implements handlers.StreamOutputBudget.
+func (b *OutputBudget) Release() {
+	if b == nil || b.limiter == nil {
+		return
+	}
+	b.limiter.releaseOutput(b.apiKey, b.reserved)
+	b.reserved = 0
+}
+
+// streamedTokens estimates output tokens from generated characters unless the
+// upstream reported a higher count.
+func streamedTokens(chars, reported int64) int64 {
+	estimated := (chars + charsPerToken - 1) / charsPerToken
+	if reported > estimated {
+		return reported
+	}
+	return estimated
+}
+
+// scanStreamChunk returns the generated text length and the reported output tokens
+// found in the SSE or JSON payloads of a chunk.
+func scanStreamChunk(chunk []byte) (chars, reported int64) {
+	for _, line := range bytes.Split(chunk, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			line = bytes.TrimSpace(data)
+		}
+		if len(line) == 0 || line[0] != '{' || !gjson.ValidBytes(line) {
+			continue
+		}
+		for _, path := range streamTextPaths {
+			chars += textLength(gjson.GetBytes(line, path))
+		}
+		for _, path := range streamUsagePaths {
+			if n := gjson.GetBytes(line, path).Int(); n > reported {
+				reported = n
+			}
+		}
+	}
+	return chars, reported
+}
+
+// textLength sums the lengths of the strings in a result, flattening arrays.
+func textLength(r gjson.Result) int64 {
+	switch {
+	case r.Type == gjson.String:
+		return int64(len(r.Str))
+	case r.IsArray():
+		var n int64
+		for _, item := range r.Array() {
+			n += textLength(item)
+		}
+		return n
+	}
+	return 0
+}
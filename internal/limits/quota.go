@@ -0,0 +1,55 @@
+package limits
+
+import "time"
+
+// Quota is how much of its daily quotas a key has used today.
+type Quota struct {
+	DailyRequests     int       `json:"daily_requests,omitempty"`
+	RequestsUsed      int       `json:"requests_used"`
+	DailyOutputTokens int       `json:"daily_output_tokens,omitempty"`
+	OutputTokensUsed  int64     `json:"output_tokens_used"`
+	ResetAt           time.Time `json:"reset_at"`
+	SoftQuota         bool      `json:"soft_quota,omitempty"`
+}
+
+// UsedFraction returns the larger used share of the daily request and output
+// token quotas; 1 or more means a quota is used up.
+func (q Quota) UsedFraction() float64 {
+	var used float64
+	if q.DailyRequests > 0 {
+		used = float64(q.RequestsUsed) / float64(q.DailyRequests)
+	}
+	if q.DailyOutputTokens > 0 {
+		used = max(used, float64(q.OutputTokensUsed)/float64(q.DailyOutputTokens))
+	}
+	return used
+}
+
+// Quota reports the daily quota usage of a key without counting a request.
+// The second return value is false when limiting is off or the key has no
+// daily request or output token quota.
+func (l *Limiter) Quota(apiKey string) (Quota, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit := l.limitFor(apiKey)
+	if !l.cfg.Enabled || (limit.DailyRequests <= 0 && limit.DailyOutputTokens <= 0) {
+		return Quota{}, false
+	}
+	now := l.now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	q := Quota{
+		DailyRequests:     max(limit.DailyRequests, 0),
+		DailyOutputTokens: max(limit.DailyOutputTokens, 0),
+		ResetAt:           dayStart.AddDate(0, 0, 1),
+		SoftQuota:         limit.SoftQuota,
+	}
+	if c, ok := l.counters[apiKey]; ok {
+		if c.dayStart.Equal(dayStart) {
+			q.RequestsUsed = c.dayCount
+		}
+		if c.outputDay.Equal(dayStart) {
+			q.OutputTokensUsed = c.outputUsed
+		}
+	}
+	return q, true
+}
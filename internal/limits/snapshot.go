@@ -0,0 +1,83 @@
+package limits
+
+import "time"
+
+// CounterState is the persisted form of a key's request windows.
+type CounterState struct {
+	MinuteStart time.Time `json:"minute_start"`
+	MinuteCount int       `json:"minute_count"`
+	DayStart    time.Time `json:"day_start"`
+	DayCount    int       `json:"day_count"`
+	// Window and TAT carry the sliding-window and GCRA request rate state.
+	Window []time.Time `json:"window,omitempty"`
+	TAT    time.Time   `json:"tat,omitempty"`
+}
+
+// State is the limiter's runtime state carried across restarts.
+type State struct {
+	Counters map[string]CounterState `json:"counters,omitempty"`
+	Boosts   []Boost                 `json:"boosts,omitempty"`
+	Audit    []AuditEntry            `json:"audit,omitempty"`
+}
+
+// Snapshot returns the current counters, active boosts and boost audit log.
+// Counters whose daily window has already rolled over are omitted.
+func (l *Limiter) Snapshot() State {
+	l.mu.Lock()
+	now := l.now()
+	expired := l.expireBoosts(now)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	state := State{Counters: make(map[string]CounterState, len(l.counters))}
+	for key, c := range l.counters {
+		if !c.dayStart.Equal(dayStart) {
+			continue
+		}
+		state.Counters[key] = CounterState{
+			MinuteStart: c.minuteStart,
+			MinuteCount: c.minuteCount,
+			DayStart:    c.dayStart,
+			DayCount:    c.dayCount,
+			Window:      append([]time.Time(nil), c.window...),
+			TAT:         c.tat,
+		}
+	}
+	for _, b := range l.boosts {
+		state.Boosts = append(state.Boosts, b)
+	}
+	state.Audit = append([]AuditEntry(nil), l.audit...)
+	l.mu.Unlock()
+
+	publishBoostEvents(expired)
+	return state
+}
+
+// Restore loads state captured by Snapshot. Windows that have rolled over
+// reset on the next request as usual; boosts that ran out while the proxy was
+// down are dropped. Keys already counted since startup keep their counters.
+func (l *Limiter) Restore(state State) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for key, cs := range state.Counters {
+		if _, exists := l.counters[key]; exists {
+			continue
+		}
+		l.counters[key] = &counter{
+			minuteStart: cs.MinuteStart,
+			minuteCount: cs.MinuteCount,
+			dayStart:    cs.DayStart,
+			dayCount:    cs.DayCount,
+			window:      cs.Window,
+			tat:         cs.TAT,
+		}
+	}
+	for _, b := range state.Boosts {
+		if _, exists := l.boosts[b.APIKey]; exists || !now.Before(b.ExpiresAt) {
+			continue
+		}
+		l.boosts[b.APIKey] = b
+	}
+	if len(l.audit) == 0 {
+		l.audit = append([]AuditEntry(nil), state.Audit...)
+	}
+}
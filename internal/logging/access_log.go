@@ -0,0 +1,271 @@
+package logging
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/pii"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Access log formats
+const (
+	AccessLogFormatText = "text"
+	AccessLogFormatJSON = "json"
+)
+
+// Access log verbosity levels; each includes the fields of the previous one.
+const (
+	// AccessLogBasic logs method, path, status, latency and request ID.
+	AccessLogBasic = "basic"
+	// AccessLogStandard adds client, key, device, route, sizes and errors.
+	AccessLogStandard = "standard"
+	// AccessLogHeaders adds the request headers, with sensitive values masked.
+	AccessLogHeaders = "headers"
+)
+
+// alwaysMaskedHeaders are masked regardless of configuration.
+var alwaysMaskedHeaders = []string{"Authorization", "Proxy-Authorization", "X-Api-Key", "X-Goog-Api-Key", "Cookie", "Set-Cookie"}
+
+// accessLogSettings is the applied form of config.AccessLogConfig.
+type accessLogSettings struct {
+	enabled    bool
+	format     string
+	verbosity  int
+	sampleRate float64
+	allow      map[string]struct{}
+	deny       map[string]struct{}
+	sensitive  map[string]struct{}
+	skipPaths  []string
+}
+
+var verbosityLevels = map[string]int{AccessLogBasic: 0, AccessLogStandard: 1, AccessLogHeaders: 2}
+
+// AccessLogger writes one structured line per HTTP request. It replaces ad-hoc
+// header dumps with a single, redacted record whose verbosity, sampling and
+// fields are configurable.
+type AccessLogger struct {
+	mu       sync.RWMutex
+	settings accessLogSettings
+	path     string
+	out      io.Writer
+	closer   io.Closer
+}
+
+// NewAccessLogger creates an access logger from configuration.
+func NewAccessLogger(cfg config.AccessLogConfig) *AccessLogger {
+	l := &AccessLogger{}
+	l.Update(cfg)
+	return l
+}
+
+// Update applies a reloaded configuration.
+func (l *AccessLogger) Update(cfg config.AccessLogConfig) {
+	settings := accessLogSettings{
+		enabled:    cfg.Enabled,
+		format:     strings.ToLower(strings.TrimSpace(cfg.Format)),
+		sampleRate: 1,
+		allow:      fieldSet(cfg.Fields),
+		deny:       fieldSet(cfg.ExcludeFields),
+		sensitive:  make(map[string]struct{}),
+	}
+	if settings.format != AccessLogFormatJSON {
+		settings.format = AccessLogFormatText
+	}
+	verbosity := strings.ToLower(strings.TrimSpace(cfg.Verbosity))
+	level, ok := verbosityLevels[verbosity]
+	if !ok {
+		if verbosity != "" {
+			log.Warnf("access-log: unknown verbosity %q, using %s", cfg.Verbosity, AccessLogStandard)
+		}
+		level = verbosityLevels[AccessLogStandard]
+	}
+	settings.verbosity = level
+	if cfg.SampleRate != nil {
+		settings.sampleRate = *cfg.SampleRate
+	}
+	for _, header := range append(append([]string(nil), alwaysMaskedHeaders...), cfg.SensitiveHeaders...) {
+		if header = strings.TrimSpace(header); header != "" {
+			settings.sensitive[http.CanonicalHeaderKey(header)] = struct{}{}
+		}
+	}
+	for _, path := range cfg.SkipPaths {
+		if path = strings.TrimSpace(path); path != "" {
+			settings.skipPaths = append(settings.skipPaths, path)
+		}
+	}
+	path := strings.TrimSpace(cfg.Path)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settings = settings
+	if !settings.enabled || settings.format != AccessLogFormatJSON {
+		l.closeLocked()
+		return
+	}
+	if l.out != nil && path == l.path {
+		return
+	}
+	l.closeLocked()
+	l.path = path
+	if path == "" {
+		l.out = os.Stdout
+		return
+	}
+	writer := &lumberjack.Logger{Filename: path, MaxSize: 100, Compress: true}
+	l.out, l.closer = writer, writer
+}
+
+func (l *AccessLogger) closeLocked() {
+	if l.closer != nil {
+		if err := l.closer.Close(); err != nil {
+			log.Warnf("access-log: failed to close %s: %v", l.path, err)
+		}
+	}
+	l.out, l.closer, l.path = nil, nil, ""
+}
+
+// Close releases the log file, if any.
+func (l *AccessLogger) Close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.closeLocked()
+	l.mu.Unlock()
+}
+
+func fieldSet(fields []string) map[string]struct{} {
+	if len(fields) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(fields))
+	for _, field := range fields {
+		if field = strings.ToLower(strings.TrimSpace(field)); field != "" {
+			set[field] = struct{}{}
+		}
+	}
+	return set
+}
+
+// Middleware logs each request once it has been served. Responses with status
+// 400 or above are always logged; the others are sampled.
+func (l *AccessLogger) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		l.mu.RLock()
+		settings, out := l.settings, l.out
+		l.mu.RUnlock()
+		if !settings.enabled || shouldSkipGinRequestLogging(c) {
+			return
+		}
+		path := c.Request.URL.Path
+		for _, prefix := range settings.skipPaths {
+			if strings.HasPrefix(path, prefix) {
+				return
+			}
+		}
+		status := c.Writer.Status()
+		if status < http.StatusBadRequest && settings.sampleRate < 1 && rand.Float64() >= settings.sampleRate {
+			return
+		}
+
+		fields := map[string]any{
+			"method":     c.Request.Method,
+			"path":       path,
+			"status":     status,
+			"latency_ms": time.Since(start).Milliseconds(),
+		}
+		if requestID := GetGinRequestID(c); requestID != "" {
+			fields["request_id"] = requestID
+		}
+		if settings.verbosity >= verbosityLevels[AccessLogStandard] {
+			fields["route"] = c.FullPath()
+			fields["client_ip"] = c.ClientIP()
+			fields["user_agent"] = c.Request.UserAgent()
+			fields["request_bytes"] = c.Request.ContentLength
+			fields["response_bytes"] = c.Writer.Size()
+			if query := util.MaskSensitiveQuery(c.Request.URL.RawQuery); query != "" {
+				fields["query"] = query
+			}
+			if apiKey := c.GetString("apiKey"); apiKey != "" {
+				fields["api_key"] = util.HideAPIKey(apiKey)
+			}
+			if deviceID := c.GetString("deviceID"); deviceID != "" {
+				fields["device_id"] = deviceID
+			}
+			if errs := c.Errors.ByType(gin.ErrorTypePrivate).String(); errs != "" {
+				fields["error"] = errs
+			}
+		}
+		if settings.verbosity >= verbosityLevels[AccessLogHeaders] {
+			fields["headers"] = maskHeaders(c.Request.Header, settings.sensitive)
+		}
+		for name := range fields {
+			_, allowed := settings.allow[name]
+			_, denied := settings.deny[name]
+			if (settings.allow != nil && !allowed) || denied {
+				delete(fields, name)
+			}
+		}
+
+		if settings.format == AccessLogFormatJSON && out != nil {
+			fields["time"] = start.UTC().Format(time.RFC3339Nano)
+			line, err := json.Marshal(fields)
+			if err != nil {
+				return
+			}
+			l.mu.Lock()
+			_, _ = out.Write(append(pii.Bytes(line), '\n'))
+			l.mu.Unlock()
+			return
+		}
+		entry := log.WithFields(fields)
+		switch {
+		case status >= http.StatusInternalServerError:
+			entry.Error("request")
+		case status >= http.StatusBadRequest:
+			entry.Warn("request")
+		default:
+			entry.Info("request")
+		}
+	}
+}
+
+// maskHeaders flattens headers into name -> value, masking sensitive ones:
+// the configured names plus anything util.MaskSensitiveHeaderValue recognises.
+func maskHeaders(header http.Header, sensitive map[string]struct{}) map[string]string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make(map[string]string, len(names))
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		if _, ok := sensitive[http.CanonicalHeaderKey(name)]; ok {
+			if strings.Contains(strings.ToLower(name), "authorization") {
+				value = util.MaskAuthorizationHeader(value)
+			} else {
+				value = util.HideAPIKey(value)
+			}
+		} else {
+			value = util.MaskSensitiveHeaderValue(name, value)
+		}
+		out[name] = value
+	}
+	return out
+}
@@ -42,10 +42,15 @@ func GinLogrusLogger() gin.HandlerFunc {
 		path := c.Request.URL.Path
 		raw := util.MaskSensitiveQuery(c.Request.URL.RawQuery)
 
-		// Only generate request ID for AI API paths
+		// Only generate request ID for AI API paths. An ID sent by a client or a
+		// downstream peer proxy is adopted so one ID follows the request through the chain.
 		var requestID string
 		if isAIAPIPath(path) {
-			requestID = GenerateRequestID()
+			requestID = c.GetHeader(RequestIDHeader)
+			if !ValidRequestID(requestID) {
+				requestID = GenerateRequestID()
+			}
+			c.Header(RequestIDHeader, requestID)
 			SetGinRequestID(c, requestID)
 			ctx := WithRequestID(c.Request.Context(), requestID)
 			c.Request = c.Request.WithContext(ctx)
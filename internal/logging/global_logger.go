@@ -11,6 +11,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/pii"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/natefinch/lumberjack.v2"
@@ -39,7 +40,7 @@ func (m *LogFormatter) Format(entry *log.Entry) ([]byte, error) {
 	}
 
 	timestamp := entry.Time.Format("2006-01-02 15:04:05")
-	message := strings.TrimRight(entry.Message, "\r\n")
+	message := pii.String(strings.TrimRight(entry.Message, "\r\n"))
 
 	reqID := "--------"
 	if id, ok := entry.Data["request_id"].(string); ok && id != "" {
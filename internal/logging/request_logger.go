@@ -23,6 +23,7 @@ import (
 
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/buildinfo"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/interfaces"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/pii"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 )
 
@@ -197,6 +198,7 @@ func (l *FileRequestLogger) logRequest(url, method string, requestHeaders map[st
 	if !l.enabled && !force {
 		return nil
 	}
+	url, body, apiRequest, apiResponse = pii.String(url), pii.Bytes(body), pii.Bytes(apiRequest), pii.Bytes(apiResponse)
 
 	// Ensure logs directory exists
 	if errEnsure := l.ensureLogsDir(); errEnsure != nil {
@@ -227,6 +229,7 @@ func (l *FileRequestLogger) logRequest(url, method string, requestHeaders map[st
 		// If decompression fails, continue with original response and annotate the log output.
 		responseToWrite = response
 	}
+	responseToWrite = pii.Bytes(responseToWrite)
 
 	logFile, errOpen := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if errOpen != nil {
@@ -283,6 +286,7 @@ func (l *FileRequestLogger) LogStreamingRequest(url, method string, headers map[
 	if !l.enabled {
 		return &NoOpStreamingLogWriter{}, nil
 	}
+	url, body = pii.String(url), pii.Bytes(body)
 
 	// Ensure logs directory exists
 	if err := l.ensureLogsDir(); err != nil {
@@ -627,7 +631,7 @@ func writeAPIErrorResponses(w io.Writer, apiResponseErrors []*interfaces.ErrorMe
 			return errWrite
 		}
 		if apiResponseErrors[i].Error != nil {
-			if _, errWrite := io.WriteString(w, apiResponseErrors[i].Error.Error()); errWrite != nil {
+			if _, errWrite := io.WriteString(w, pii.String(apiResponseErrors[i].Error.Error())); errWrite != nil {
 				return errWrite
 			}
 		}
@@ -985,7 +989,9 @@ func (w *FileStreamingLogWriter) WriteChunkAsync(chunk []byte) {
 		return
 	}
 
-	// Make a copy of the chunk to avoid data races
+	// Make a copy of the chunk to avoid data races; personal data is redacted before it is spooled.
+	// A match split across chunks is not seen.
+	chunk = pii.Bytes(chunk)
 	chunkCopy := make([]byte, len(chunk))
 	copy(chunkCopy, chunk)
 
@@ -1034,7 +1040,7 @@ func (w *FileStreamingLogWriter) WriteAPIRequest(apiRequest []byte) error {
 	if len(apiRequest) == 0 {
 		return nil
 	}
-	w.apiRequest = bytes.Clone(apiRequest)
+	w.apiRequest = bytes.Clone(pii.Bytes(apiRequest))
 	return nil
 }
 
@@ -1049,7 +1055,7 @@ func (w *FileStreamingLogWriter) WriteAPIResponse(apiResponse []byte) error {
 	if len(apiResponse) == 0 {
 		return nil
 	}
-	w.apiResponse = bytes.Clone(apiResponse)
+	w.apiResponse = bytes.Clone(pii.Bytes(apiResponse))
 	return nil
 }
 
@@ -23,6 +23,23 @@ func GenerateRequestID() string {
 	return hex.EncodeToString(b)
 }
 
+// RequestIDHeader carries a request ID between clients, this proxy and peer proxies.
+const RequestIDHeader = "X-Request-ID"
+
+// ValidRequestID reports whether an inbound request ID may be adopted as the local
+// one: at most 64 letters, digits, '-' or '_'.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
 // WithRequestID returns a new context with the request ID attached.
 func WithRequestID(ctx context.Context, requestID string) context.Context {
 	return context.WithValue(ctx, requestIDKey{}, requestID)
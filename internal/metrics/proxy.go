@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// HTTPRequests counts handled HTTP requests by method, route and status code.
+	HTTPRequests = defaultRegistry.NewCounterVec(
+		"cliproxy_http_requests_total",
+		"Total HTTP requests handled by the proxy.",
+		"method", "route", "status",
+	)
+	// HTTPRequestDuration observes end-to-end request latency in seconds.
+	HTTPRequestDuration = defaultRegistry.NewHistogramVec(
+		"cliproxy_http_request_duration_seconds",
+		"End-to-end HTTP request latency in seconds.",
+		nil,
+		"method", "route",
+	)
+)
+
+// Handler serves the registry in the Prometheus text exposition format. When
+// bearerToken is set, requests must present it in the Authorization header.
+func Handler(registry *Registry, bearerToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if bearerToken != "" && c.GetHeader("Authorization") != "Bearer "+bearerToken {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		_ = registry.WriteText(c.Writer)
+	}
+}
+
+// Middleware records request counts and latency. Routes are labelled by their
+// registered pattern to keep label cardinality bounded.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		HTTPRequests.Inc(method, route, strconv.Itoa(c.Writer.Status()))
+		HTTPRequestDuration.Observe(time.Since(start).Seconds(), method, route)
+	}
+}
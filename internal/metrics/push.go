@@ -0,0 +1,294 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// PushFormatPushgateway pushes the text exposition format to a Prometheus Pushgateway.
+	PushFormatPushgateway = "pushgateway"
+	// PushFormatOTLP pushes OTLP/HTTP JSON to an OpenTelemetry collector.
+	PushFormatOTLP = "otlp"
+
+	defaultPushInterval = 30 * time.Second
+	defaultPushJob      = "cli-proxy-api"
+	pushTimeout         = 10 * time.Second
+)
+
+// Pusher periodically exports a registry to a push-based backend.
+type Pusher struct {
+	registry *Registry
+	format   string
+	endpoint string
+	job      string
+	labels   map[string]string
+	headers  map[string]string
+	interval time.Duration
+	client   *http.Client
+	start    time.Time
+}
+
+// NewPusher creates a pusher from configuration. It returns nil when pushing is disabled.
+func NewPusher(registry *Registry, cfg config.MetricsPushConfig) *Pusher {
+	if !cfg.Enabled {
+		return nil
+	}
+	endpoint := strings.TrimRight(strings.TrimSpace(cfg.URL), "/")
+	if endpoint == "" {
+		log.Warn("metrics-push: enabled but url is empty, skipping")
+		return nil
+	}
+	format := strings.ToLower(strings.TrimSpace(cfg.Format))
+	switch format {
+	case "", PushFormatPushgateway:
+		format = PushFormatPushgateway
+	case PushFormatOTLP:
+	default:
+		log.Warnf("metrics-push: unsupported format %q, skipping", cfg.Format)
+		return nil
+	}
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultPushInterval
+	}
+	job := strings.TrimSpace(cfg.Job)
+	if job == "" {
+		job = defaultPushJob
+	}
+	return &Pusher{
+		registry: registry,
+		format:   format,
+		endpoint: endpoint,
+		job:      job,
+		labels:   cfg.Labels,
+		headers:  cfg.Headers,
+		interval: interval,
+		client:   &http.Client{Timeout: pushTimeout},
+		start:    time.Now(),
+	}
+}
+
+// Run pushes metrics on every interval until ctx is done, with a final push on exit.
+func (p *Pusher) Run(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	log.Infof("metrics-push: pushing %s metrics to %s every %s", p.format, p.endpoint, p.interval)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			finalCtx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+			if err := p.Push(finalCtx); err != nil {
+				log.Debugf("metrics-push: final push failed: %v", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := p.Push(ctx); err != nil {
+				log.Warnf("metrics-push: push failed: %v", err)
+			}
+		}
+	}
+}
+
+// Push exports the current registry state once.
+func (p *Pusher) Push(ctx context.Context) error {
+	families := p.registry.Gather()
+	var (
+		method      string
+		target      string
+		contentType string
+		body        bytes.Buffer
+	)
+	switch p.format {
+	case PushFormatOTLP:
+		payload, err := json.Marshal(buildOTLP(families, p.job, p.labels, p.start, time.Now()))
+		if err != nil {
+			return err
+		}
+		body.Write(payload)
+		method, target, contentType = http.MethodPost, p.endpoint, "application/json"
+	default:
+		if err := WriteText(&body, families, nil); err != nil {
+			return err
+		}
+		method, target, contentType = http.MethodPut, p.pushgatewayURL(), "text/plain; version=0.0.4"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			log.Debugf("metrics-push: failed to close response body: %v", errClose)
+		}
+	}()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned status %d: %s", target, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// pushgatewayURL builds /metrics/job/<job>/<label>/<value>... grouping key path.
+func (p *Pusher) pushgatewayURL() string {
+	var sb strings.Builder
+	sb.WriteString(p.endpoint)
+	sb.WriteString("/metrics/job/")
+	sb.WriteString(url.PathEscape(p.job))
+	keys := make([]string, 0, len(p.labels))
+	for k := range p.labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sb.WriteString("/" + url.PathEscape(k) + "/" + url.PathEscape(p.labels[k]))
+	}
+	return sb.String()
+}
+
+// OTLP/HTTP JSON payload types (subset of opentelemetry-proto metrics).
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          *float64        `json:"asDouble,omitempty"`
+	Count             string          `json:"count,omitempty"`
+	Sum               *float64        `json:"sum,omitempty"`
+	BucketCounts      []string        `json:"bucketCounts,omitempty"`
+	ExplicitBounds    []float64       `json:"explicitBounds,omitempty"`
+}
+
+type otlpMetric struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Sum         *struct {
+		DataPoints             []otlpDataPoint `json:"dataPoints"`
+		AggregationTemporality int             `json:"aggregationTemporality"`
+		IsMonotonic            bool            `json:"isMonotonic"`
+	} `json:"sum,omitempty"`
+	Gauge *struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	} `json:"gauge,omitempty"`
+	Histogram *struct {
+		DataPoints             []otlpDataPoint `json:"dataPoints"`
+		AggregationTemporality int             `json:"aggregationTemporality"`
+	} `json:"histogram,omitempty"`
+}
+
+const otlpCumulative = 2
+
+func otlpAttributes(labels map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]otlpAttribute, 0, len(keys))
+	for _, k := range keys {
+		var a otlpAttribute
+		a.Key = k
+		a.Value.StringValue = labels[k]
+		attrs = append(attrs, a)
+	}
+	return attrs
+}
+
+func buildOTLP(families []Family, job string, resourceLabels map[string]string, start, now time.Time) map[string]any {
+	startNano := strconv.FormatInt(start.UnixNano(), 10)
+	nowNano := strconv.FormatInt(now.UnixNano(), 10)
+
+	metrics := make([]otlpMetric, 0, len(families))
+	for _, f := range families {
+		m := otlpMetric{Name: f.Name, Description: f.Help}
+		points := make([]otlpDataPoint, 0, len(f.Samples))
+		for _, s := range f.Samples {
+			dp := otlpDataPoint{
+				Attributes:        otlpAttributes(s.Labels),
+				StartTimeUnixNano: startNano,
+				TimeUnixNano:      nowNano,
+			}
+			if f.Kind == KindHistogram {
+				sum := s.Sum
+				dp.Count = strconv.FormatUint(s.Count, 10)
+				dp.Sum = &sum
+				dp.ExplicitBounds = s.Bounds
+				dp.BucketCounts = make([]string, len(s.BucketCounts))
+				for i, c := range s.BucketCounts {
+					dp.BucketCounts[i] = strconv.FormatUint(c, 10)
+				}
+			} else {
+				value := s.Value
+				dp.AsDouble = &value
+			}
+			points = append(points, dp)
+		}
+		switch f.Kind {
+		case KindCounter:
+			m.Sum = &struct {
+				DataPoints             []otlpDataPoint `json:"dataPoints"`
+				AggregationTemporality int             `json:"aggregationTemporality"`
+				IsMonotonic            bool            `json:"isMonotonic"`
+			}{DataPoints: points, AggregationTemporality: otlpCumulative, IsMonotonic: true}
+		case KindGauge:
+			m.Gauge = &struct {
+				DataPoints []otlpDataPoint `json:"dataPoints"`
+			}{DataPoints: points}
+		case KindHistogram:
+			m.Histogram = &struct {
+				DataPoints             []otlpDataPoint `json:"dataPoints"`
+				AggregationTemporality int             `json:"aggregationTemporality"`
+			}{DataPoints: points, AggregationTemporality: otlpCumulative}
+		}
+		metrics = append(metrics, m)
+	}
+
+	resource := map[string]string{"service.name": job}
+	for k, v := range resourceLabels {
+		resource[k] = v
+	}
+	return map[string]any{
+		"resourceMetrics": []any{
+			map[string]any{
+				"resource": map[string]any{"attributes": otlpAttributes(resource)},
+				"scopeMetrics": []any{
+					map[string]any{
+						"scope":   map[string]any{"name": "cli-proxy-api"},
+						"metrics": metrics,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,382 @@
+// Package metrics provides a small, dependency-free metrics registry with
+// counters, gauges and histograms, Prometheus text exposition, and push-based
+// exporters for environments where scraping is not possible.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Kind identifies the type of a metric family.
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// DefaultBuckets are latency buckets in seconds suitable for proxied LLM requests.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+const labelSeparator = "\xff"
+
+// Sample is a single labelled value of a metric family.
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+	// Histogram fields; BucketCounts are per-bucket (non-cumulative) and have
+	// len(Bounds)+1 entries, the last one being the +Inf bucket.
+	Bounds       []float64
+	BucketCounts []uint64
+	Count        uint64
+	Sum          float64
+}
+
+// Family is a point-in-time snapshot of a metric and all of its samples.
+type Family struct {
+	Name    string
+	Help    string
+	Kind    Kind
+	Samples []Sample
+}
+
+type collector interface {
+	gather() Family
+}
+
+// Registry holds metric families.
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]collector
+	hooks      []func()
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.collectors[name]; exists {
+		panic("metrics: duplicate registration of " + name)
+	}
+	r.collectors[name] = c
+}
+
+// OnGather registers a hook that runs before every Gather, used to refresh
+// gauges that are computed from a snapshot of external state.
+func (r *Registry) OnGather(hook func()) {
+	r.mu.Lock()
+	r.hooks = append(r.hooks, hook)
+	r.mu.Unlock()
+}
+
+// Gather returns snapshots of all families sorted by name.
+func (r *Registry) Gather() []Family {
+	r.mu.RLock()
+	hooks := append([]func(){}, r.hooks...)
+	r.mu.RUnlock()
+	for _, hook := range hooks {
+		hook()
+	}
+
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	families := make([]Family, 0, len(names))
+	for _, name := range names {
+		families = append(families, r.collectors[name].gather())
+	}
+	r.mu.RUnlock()
+	return families
+}
+
+// WriteText writes all families in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	return WriteText(w, r.Gather(), nil)
+}
+
+// WriteText renders families in the Prometheus text exposition format. Extra
+// labels are appended to every sample.
+func WriteText(w io.Writer, families []Family, extra map[string]string) error {
+	var sb strings.Builder
+	for _, f := range families {
+		fmt.Fprintf(&sb, "# HELP %s %s\n", f.Name, escapeHelp(f.Help))
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", f.Name, f.Kind)
+		for _, s := range f.Samples {
+			labels := mergeLabels(s.Labels, extra)
+			if f.Kind != KindHistogram {
+				fmt.Fprintf(&sb, "%s%s %s\n", f.Name, formatLabels(labels, "", ""), formatFloat(s.Value))
+				continue
+			}
+			var cumulative uint64
+			for i, bound := range s.Bounds {
+				cumulative += s.BucketCounts[i]
+				fmt.Fprintf(&sb, "%s_bucket%s %d\n", f.Name, formatLabels(labels, "le", formatFloat(bound)), cumulative)
+			}
+			fmt.Fprintf(&sb, "%s_bucket%s %d\n", f.Name, formatLabels(labels, "le", "+Inf"), s.Count)
+			fmt.Fprintf(&sb, "%s_sum%s %s\n", f.Name, formatLabels(labels, "", ""), formatFloat(s.Sum))
+			fmt.Fprintf(&sb, "%s_count%s %d\n", f.Name, formatLabels(labels, "", ""), s.Count)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func mergeLabels(labels, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return labels
+	}
+	merged := make(map[string]string, len(labels)+len(extra))
+	for k, v := range extra {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
+
+func formatLabels(labels map[string]string, extraName, extraValue string) string {
+	if len(labels) == 0 && extraName == "" {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		parts = append(parts, k+`="`+escapeLabel(labels[k])+`"`)
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+// vec stores labelled values for a single family.
+type vec[T any] struct {
+	name       string
+	help       string
+	labelNames []string
+	mu         sync.Mutex
+	values     map[string]*T
+	labelSets  map[string][]string
+	newValue   func() *T
+}
+
+func newVec[T any](name, help string, labelNames []string, newValue func() *T) *vec[T] {
+	return &vec[T]{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		values:     make(map[string]*T),
+		labelSets:  make(map[string][]string),
+		newValue:   newValue,
+	}
+}
+
+func (v *vec[T]) get(labelValues []string) *T {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, labelSeparator)
+	if val, ok := v.values[key]; ok {
+		return val
+	}
+	val := v.newValue()
+	v.values[key] = val
+	v.labelSets[key] = append([]string(nil), labelValues...)
+	return val
+}
+
+func (v *vec[T]) labels(key string) map[string]string {
+	values := v.labelSets[key]
+	labels := make(map[string]string, len(values))
+	for i, name := range v.labelNames {
+		labels[name] = values[i]
+	}
+	return labels
+}
+
+func (v *vec[T]) sortedKeys() []string {
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a monotonically increasing counter partitioned by labels.
+type CounterVec struct {
+	v *vec[float64]
+}
+
+// NewCounterVec registers a counter family.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{v: newVec(name, help, labelNames, func() *float64 { return new(float64) })}
+	r.register(name, c)
+	return c
+}
+
+// Add increases the counter for the given label values. Negative deltas are ignored.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.v.mu.Lock()
+	*c.v.get(labelValues) += delta
+	c.v.mu.Unlock()
+}
+
+// Inc increases the counter for the given label values by one.
+func (c *CounterVec) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+func (c *CounterVec) gather() Family {
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+	f := Family{Name: c.v.name, Help: c.v.help, Kind: KindCounter}
+	for _, key := range c.v.sortedKeys() {
+		f.Samples = append(f.Samples, Sample{Labels: c.v.labels(key), Value: *c.v.values[key]})
+	}
+	return f
+}
+
+// GaugeVec is a value that can go up and down, partitioned by labels.
+type GaugeVec struct {
+	v *vec[float64]
+}
+
+// NewGaugeVec registers a gauge family.
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{v: newVec(name, help, labelNames, func() *float64 { return new(float64) })}
+	r.register(name, g)
+	return g
+}
+
+// Set sets the gauge for the given label values.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.v.mu.Lock()
+	*g.v.get(labelValues) = value
+	g.v.mu.Unlock()
+}
+
+// Add adjusts the gauge for the given label values by delta.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.v.mu.Lock()
+	*g.v.get(labelValues) += delta
+	g.v.mu.Unlock()
+}
+
+// Reset removes all labelled values, used when a gauge is rebuilt from a full snapshot.
+func (g *GaugeVec) Reset() {
+	g.v.mu.Lock()
+	g.v.values = make(map[string]*float64)
+	g.v.labelSets = make(map[string][]string)
+	g.v.mu.Unlock()
+}
+
+func (g *GaugeVec) gather() Family {
+	g.v.mu.Lock()
+	defer g.v.mu.Unlock()
+	f := Family{Name: g.v.name, Help: g.v.help, Kind: KindGauge}
+	for _, key := range g.v.sortedKeys() {
+		f.Samples = append(f.Samples, Sample{Labels: g.v.labels(key), Value: *g.v.values[key]})
+	}
+	return f
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec samples observations into buckets, partitioned by labels.
+type HistogramVec struct {
+	v      *vec[histogramValue]
+	bounds []float64
+}
+
+// NewHistogramVec registers a histogram family. Nil buckets use DefaultBuckets.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	h := &HistogramVec{bounds: bounds}
+	h.v = newVec(name, help, labelNames, func() *histogramValue {
+		return &histogramValue{counts: make([]uint64, len(bounds)+1)}
+	})
+	r.register(name, h)
+	return h
+}
+
+// Observe records a single observation for the given label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	idx := sort.SearchFloat64s(h.bounds, value)
+	h.v.mu.Lock()
+	hv := h.v.get(labelValues)
+	hv.counts[idx]++
+	hv.count++
+	hv.sum += value
+	h.v.mu.Unlock()
+}
+
+func (h *HistogramVec) gather() Family {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	f := Family{Name: h.v.name, Help: h.v.help, Kind: KindHistogram}
+	for _, key := range h.v.sortedKeys() {
+		hv := h.v.values[key]
+		f.Samples = append(f.Samples, Sample{
+			Labels:       h.v.labels(key),
+			Bounds:       h.bounds,
+			BucketCounts: append([]uint64(nil), hv.counts...),
+			Count:        hv.count,
+			Sum:          hv.sum,
+		})
+	}
+	return f
+}
+
+var defaultRegistry = NewRegistry()
+
+// Default returns the process-wide registry.
+func Default() *Registry { return defaultRegistry }
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTextRendersAllKinds(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_requests_total", "Requests.", "status")
+	g := r.NewGaugeVec("test_active", "Active.")
+	h := r.NewHistogramVec("test_latency_seconds", "Latency.", []float64{1, 5})
+
+	c.Inc("200")
+	c.Add(2, "200")
+	g.Set(3)
+	h.Observe(0.5)
+	h.Observe(3)
+	h.Observe(10)
+
+	var sb strings.Builder
+	if err := r.WriteText(&sb); err != nil {
+		t.Fatalf("write text: %v", err)
+	}
+	out := sb.String()
+	for _, want := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{status="200"} 3`,
+		"test_active 3",
+		`test_latency_seconds_bucket{le="1"} 1`,
+		`test_latency_seconds_bucket{le="5"} 2`,
+		`test_latency_seconds_bucket{le="+Inf"} 3`,
+		"test_latency_seconds_sum 13.5",
+		"test_latency_seconds_count 3",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q\n%s", want, out)
+		}
+	}
+}
+
+func TestBuildOTLPUsesPerBucketCounts(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("test_latency_seconds", "Latency.", []float64{1}, "route")
+	h.Observe(0.5, "/v1/messages")
+	h.Observe(2, "/v1/messages")
+
+	payload := buildOTLP(r.Gather(), "job", map[string]string{"env": "test"}, time.Unix(0, 0), time.Unix(1, 0))
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	out := string(raw)
+	for _, want := range []string{`"bucketCounts":["1","1"]`, `"explicitBounds":[1]`, `"stringValue":"job"`, `"count":"2"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected payload to contain %s\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package notify contains helpers shared by the chat integrations that relay
+// proxy events to administrators and accept management commands from them.
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// Commander executes management commands on behalf of a chat integration.
+type Commander struct {
+	store device.Store
+	actor string
+}
+
+// NewCommander creates a Commander that records actor as the source of actions.
+func NewCommander(store device.Store, actor string) *Commander {
+	return &Commander{store: store, actor: actor}
+}
+
+// Ban bans an API key and returns a reply for the chat.
+func (c *Commander) Ban(apiKey, reason string) string {
+	if c.store == nil {
+		return "Device binding store is not available."
+	}
+	binding, exists := c.store.Get(apiKey)
+	if !exists {
+		return "No device binding found for this API key."
+	}
+	if binding.Banned {
+		return "This API key is already banned: " + binding.BanReason
+	}
+	if err := c.store.Ban(apiKey, reason, 0); err != nil {
+		log.Errorf("%s: failed to ban key %s: %v", c.actor, device.MaskKey(apiKey), err)
+		return "Failed to ban API key."
+	}
+	log.Infof("device-binding: banned key %s via %s", device.MaskKey(apiKey), c.actor)
+	events.Publish(events.Event{Type: events.TypeBan, APIKey: apiKey, Reason: reason, Actor: c.actor})
+	return "API key " + device.MaskKey(apiKey) + " banned."
+}
+
+// Unban lifts the ban from an API key and returns a reply for the chat.
+func (c *Commander) Unban(apiKey string) string {
+	if c.store == nil {
+		return "Device binding store is not available."
+	}
+	binding, exists := c.store.Get(apiKey)
+	if !exists {
+		return "No device binding found for this API key."
+	}
+	if !binding.Banned {
+		return "This API key is not banned."
+	}
+	if err := c.store.Unban(apiKey, c.actor); err != nil {
+		log.Errorf("%s: failed to unban key %s: %v", c.actor, device.MaskKey(apiKey), err)
+		return "Failed to unban API key."
+	}
+	log.Infof("device-binding: unbanned key %s via %s", device.MaskKey(apiKey), c.actor)
+	events.Publish(events.Event{Type: events.TypeUnban, APIKey: apiKey, Reason: binding.BanReason, Actor: c.actor})
+	return "API key " + device.MaskKey(apiKey) + " unbanned."
+}
+
+// Usage summarises a key's binding, or overall request statistics when apiKey is empty.
+func (c *Commander) Usage(apiKey string) string {
+	if apiKey != "" {
+		if c.store == nil {
+			return "Device binding store is not available."
+		}
+		binding, exists := c.store.Get(apiKey)
+		if !exists {
+			return "No device binding found for this API key."
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Key: %s\nDevices: %d\nLast seen: %s\nLast IP: %s",
+			device.MaskKey(apiKey), len(binding.Devices), binding.LastSeen.Format(time.RFC3339), binding.LastIP)
+		if binding.Banned {
+			sb.WriteString("\nBanned: " + binding.BanReason)
+		}
+		return sb.String()
+	}
+
+	snapshot := usage.GetRequestStatistics().Snapshot()
+	return fmt.Sprintf("Requests: %d (ok %d, failed %d)\nTokens: %d",
+		snapshot.TotalRequests, snapshot.SuccessCount, snapshot.FailureCount, snapshot.TotalTokens)
+}
+
+// FormatEvent renders an event as a short plain-text message.
+func FormatEvent(ev events.Event) string {
+	var sb strings.Builder
+	switch ev.Type {
+	case events.TypeBan:
+		sb.WriteString("🚫 API key banned")
+	case events.TypeUnban:
+		sb.WriteString("✅ API key unbanned")
+	case events.TypeDeviceBanned:
+		sb.WriteString("🚫 Device banned")
+	case events.TypeDeviceUnbanned:
+		sb.WriteString("✅ Device unbanned")
+	case events.TypeDeviceRegistered:
+		sb.WriteString("📱 New device registered")
+	case events.TypeDeviceRejected:
+		sb.WriteString("⛔ Device rejected")
+	case events.TypeDevicePending:
+		sb.WriteString("⏳ Device awaiting approval")
+	case events.TypeDeviceApproved:
+		sb.WriteString("👍 Device approved")
+	case events.TypeTLSFingerprintMismatch:
+		sb.WriteString("🔐 TLS fingerprint mismatch")
+	case events.TypeBoostGranted:
+		sb.WriteString("🚀 Limit boost granted")
+	case events.TypeBoostRevoked:
+		sb.WriteString("↩️ Limit boost revoked")
+	case events.TypeBoostExpired:
+		sb.WriteString("⌛ Limit boost expired")
+	case events.TypeVerboseLoggingDisabled:
+		sb.WriteString("🔇 Verbose logging switched off")
+	case events.TypeKeyCreated:
+		sb.WriteString("🔑 API key created")
+	case events.TypeKeyRotated:
+		sb.WriteString("🔄 API key rotated")
+	case events.TypeKeyRevoked:
+		sb.WriteString("🗑️ API key revoked")
+	case events.TypeAlertFiring:
+		sb.WriteString(fmt.Sprintf("🚨 Alert firing: %v [%v]", ev.Data["rule"], ev.Data["severity"]))
+	case events.TypeAlertResolved:
+		sb.WriteString(fmt.Sprintf("✅ Alert resolved: %v", ev.Data["rule"]))
+	case events.TypeManagementRecovered:
+		sb.WriteString("🆘 Management access recovered")
+	case events.TypeManagementRecoveryFailed:
+		sb.WriteString("⚠️ Management recovery attempt rejected")
+	case events.TypeCanaryPromoted:
+		sb.WriteString("🐤 Canary config promoted to all traffic")
+	case events.TypeCanaryRolledBack:
+		sb.WriteString("↩️ Canary config rolled back")
+	case events.TypeBackupFailed:
+		sb.WriteString("💾 Store backup failed")
+	case events.TypeBackupRestored:
+		sb.WriteString(fmt.Sprintf("💾 Store restored from backup %v", ev.Data["name"]))
+	case events.TypeUpstreamFailover:
+		sb.WriteString(fmt.Sprintf("🔀 Upstream failover on %v: %v → %v", ev.Data["provider"], ev.Data["from_auth"], ev.Data["to_auth"]))
+	case events.TypeRateLimited:
+		sb.WriteString(fmt.Sprintf("🚦 Rate limit hit (%v)", ev.Data["limit"]))
+	default:
+		sb.WriteString("ℹ️ " + string(ev.Type))
+	}
+	if ev.APIKey != "" {
+		sb.WriteString("\nKey: " + device.MaskKey(ev.APIKey))
+	}
+	if ev.DeviceID != "" {
+		sb.WriteString("\nDevice: " + ev.DeviceID)
+	}
+	if ev.IP != "" {
+		sb.WriteString("\nIP: " + ev.IP)
+	}
+	if ev.Reason != "" {
+		sb.WriteString("\nReason: " + ev.Reason)
+	}
+	if multiplier, ok := ev.Data["multiplier"].(float64); ok {
+		sb.WriteString(fmt.Sprintf("\nBoost: %gx", multiplier))
+		if until, okUntil := ev.Data["expires_at"].(time.Time); okUntil && ev.Type == events.TypeBoostGranted {
+			sb.WriteString(" until " + until.Format(time.RFC3339))
+		}
+	}
+	if expr, ok := ev.Data["expr"].(string); ok {
+		sb.WriteString("\nRule: " + expr)
+		if value, okValue := ev.Data["value"].(float64); okValue {
+			sb.WriteString(fmt.Sprintf("\nValue: %g", value))
+		}
+	}
+	if ev.Actor != "" {
+		sb.WriteString("\nBy: " + ev.Actor)
+	}
+	return sb.String()
+}
+
+// EventFilter builds a set of event types from configuration, falling back to
+// ban and unban events when none are configured.
+func EventFilter(types []string) map[events.Type]struct{} {
+	if len(types) == 0 {
+		types = []string{string(events.TypeBan), string(events.TypeUnban)}
+	}
+	set := make(map[events.Type]struct{}, len(types))
+	for _, t := range types {
+		if trimmed := strings.TrimSpace(t); trimmed != "" {
+			set[events.Type(trimmed)] = struct{}{}
+		}
+	}
+	return set
+}
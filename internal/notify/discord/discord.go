@@ -0,0 +1,280 @@
+// Package discord implements Discord webhook notifications for proxy events
+// and a minimal interactions endpoint for /unban, /ban and /usage slash commands.
+package discord
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/notify"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// InteractionsPath is the HTTP path Discord should be configured to call.
+	InteractionsPath = "/discord/interactions"
+
+	outboxCapacity = 100
+	requestTimeout = 10 * time.Second
+
+	interactionPing               = 1
+	interactionApplicationCommand = 2
+	responsePong                  = 1
+	responseChannelMessage        = 4
+	flagEphemeral                 = 64
+)
+
+// Integration delivers notifications to Discord webhooks and answers slash commands.
+type Integration struct {
+	publicKey ed25519.PublicKey
+	channels  []channel
+	commands  *notify.Commander
+	client    *http.Client
+	outbox    chan outgoing
+}
+
+type channel struct {
+	guildID       string
+	channelID     string
+	webhookURL    string
+	events        map[events.Type]struct{}
+	allowCommands bool
+}
+
+type outgoing struct {
+	webhookURL string
+	text       string
+}
+
+// New creates the integration from configuration. It returns nil when disabled.
+func New(cfg *config.Config, store device.Store) *Integration {
+	if cfg == nil || !cfg.Discord.Enabled {
+		return nil
+	}
+
+	var publicKey ed25519.PublicKey
+	if raw := strings.TrimSpace(cfg.Discord.PublicKey); raw != "" {
+		decoded, err := hex.DecodeString(raw)
+		if err != nil || len(decoded) != ed25519.PublicKeySize {
+			log.Warn("discord: invalid public-key, slash commands disabled")
+		} else {
+			publicKey = decoded
+		}
+	}
+
+	channels := make([]channel, 0, len(cfg.Discord.Channels))
+	for _, ch := range cfg.Discord.Channels {
+		channels = append(channels, channel{
+			guildID:       strings.TrimSpace(ch.GuildID),
+			channelID:     strings.TrimSpace(ch.ChannelID),
+			webhookURL:    strings.TrimSpace(ch.WebhookURL),
+			events:        notify.EventFilter(ch.Events),
+			allowCommands: ch.AllowCommands,
+		})
+	}
+	if len(channels) == 0 {
+		log.Warn("discord: enabled but no channels configured, skipping")
+		return nil
+	}
+
+	return &Integration{
+		publicKey: publicKey,
+		channels:  channels,
+		commands:  notify.NewCommander(store, "discord"),
+		client:    util.SetProxy(&cfg.SDKConfig, &http.Client{Timeout: requestTimeout}),
+		outbox:    make(chan outgoing, outboxCapacity),
+	}
+}
+
+// CommandsEnabled reports whether the interactions endpoint should be registered.
+func (d *Integration) CommandsEnabled() bool {
+	return d != nil && len(d.publicKey) == ed25519.PublicKeySize
+}
+
+// Run subscribes to proxy events and delivers webhook notifications until ctx is done.
+func (d *Integration) Run(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	unsubscribe := events.Subscribe(d.handleEvent)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-d.outbox:
+			if err := d.postWebhook(ctx, msg.webhookURL, msg.text); err != nil {
+				log.Warnf("discord: failed to deliver webhook notification: %v", err)
+			}
+		}
+	}
+}
+
+func (d *Integration) handleEvent(ev events.Event) {
+	text := notify.FormatEvent(ev)
+	for _, ch := range d.channels {
+		if ch.webhookURL == "" {
+			continue
+		}
+		if _, ok := ch.events[ev.Type]; !ok {
+			continue
+		}
+		select {
+		case d.outbox <- outgoing{webhookURL: ch.webhookURL, text: text}:
+		default:
+			log.Warn("discord: outbox full, dropping notification")
+		}
+	}
+}
+
+func (d *Integration) postWebhook(ctx context.Context, webhookURL, text string) error {
+	body, err := json.Marshal(map[string]any{
+		"content":          text,
+		"allowed_mentions": map[string]any{"parse": []string{}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			log.Debugf("discord: failed to close response body: %v", errClose)
+		}
+	}()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type interaction struct {
+	Type      int    `json:"type"`
+	GuildID   string `json:"guild_id"`
+	ChannelID string `json:"channel_id"`
+	Data      struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string `json:"name"`
+			Value any    `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+}
+
+// HandleInteraction verifies and answers Discord interaction requests.
+// POST /discord/interactions
+func (d *Integration) HandleInteraction(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if !d.verify(c.GetHeader("X-Signature-Ed25519"), c.GetHeader("X-Signature-Timestamp"), body) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	var in interaction
+	if err = json.Unmarshal(body, &in); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	switch in.Type {
+	case interactionPing:
+		c.JSON(http.StatusOK, gin.H{"type": responsePong})
+	case interactionApplicationCommand:
+		c.JSON(http.StatusOK, gin.H{
+			"type": responseChannelMessage,
+			"data": gin.H{"content": d.execute(in), "flags": flagEphemeral},
+		})
+	default:
+		c.AbortWithStatus(http.StatusBadRequest)
+	}
+}
+
+func (d *Integration) verify(signature, timestamp string, body []byte) bool {
+	if !d.CommandsEnabled() || signature == "" || timestamp == "" {
+		return false
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	msg := make([]byte, 0, len(timestamp)+len(body))
+	msg = append(msg, timestamp...)
+	msg = append(msg, body...)
+	return ed25519.Verify(d.publicKey, msg, sig)
+}
+
+// commandAllowed reports whether commands are accepted from the given guild and channel.
+func (d *Integration) commandAllowed(guildID, channelID string) bool {
+	for _, ch := range d.channels {
+		if !ch.allowCommands {
+			continue
+		}
+		if ch.guildID != "" && ch.guildID != guildID {
+			continue
+		}
+		if ch.channelID != "" && ch.channelID != channelID {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (d *Integration) execute(in interaction) string {
+	if !d.commandAllowed(in.GuildID, in.ChannelID) {
+		log.Warnf("discord: rejected /%s from guild %s channel %s", in.Data.Name, in.GuildID, in.ChannelID)
+		return "Commands are not enabled for this channel."
+	}
+
+	options := make(map[string]string, len(in.Data.Options))
+	for _, opt := range in.Data.Options {
+		options[opt.Name] = strings.TrimSpace(fmt.Sprint(opt.Value))
+	}
+	apiKey := options["api-key"]
+
+	switch in.Data.Name {
+	case "unban":
+		if apiKey == "" {
+			return "The api-key option is required."
+		}
+		return d.commands.Unban(apiKey)
+	case "ban":
+		if apiKey == "" {
+			return "The api-key option is required."
+		}
+		reason := options["reason"]
+		if reason == "" {
+			reason = "Banned via Discord"
+		}
+		return d.commands.Ban(apiKey, reason)
+	case "usage":
+		return d.commands.Usage(apiKey)
+	default:
+		return "Unknown command."
+	}
+}
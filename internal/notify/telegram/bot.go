@@ -0,0 +1,277 @@
+// Package telegram implements an optional Telegram bot that forwards device
+// binding events to allowlisted admin chats and accepts a small set of
+// management commands (/ban, /unban, /usage).
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/notify"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	apiBaseURL     = "https://api.telegram.org"
+	pollTimeout    = 30 * time.Second
+	outboxCapacity = 100
+	retryPollDelay = 5 * time.Second
+)
+
+// Bot is a long-polling Telegram bot bound to the device binding store.
+type Bot struct {
+	token    string
+	allowed  map[int64]struct{}
+	events   map[events.Type]struct{}
+	commands *notify.Commander
+	client   *http.Client
+	baseURL  string
+	outbox   chan outgoing
+}
+
+type outgoing struct {
+	chatID int64
+	text   string
+}
+
+// New creates a bot from configuration. It returns nil when the bot is
+// disabled or incompletely configured.
+func New(cfg *config.Config, store device.Store) *Bot {
+	if cfg == nil || !cfg.Telegram.Enabled {
+		return nil
+	}
+	token := strings.TrimSpace(cfg.Telegram.BotToken)
+	if token == "" || len(cfg.Telegram.AllowedChatIDs) == 0 {
+		log.Warn("telegram: bot enabled but bot-token or allowed-chat-ids is empty, skipping")
+		return nil
+	}
+
+	allowed := make(map[int64]struct{}, len(cfg.Telegram.AllowedChatIDs))
+	for _, id := range cfg.Telegram.AllowedChatIDs {
+		allowed[id] = struct{}{}
+	}
+
+	client := util.SetProxy(&cfg.SDKConfig, &http.Client{Timeout: pollTimeout + 10*time.Second})
+	return &Bot{
+		token:    token,
+		allowed:  allowed,
+		events:   notify.EventFilter(cfg.Telegram.Events),
+		commands: notify.NewCommander(store, "telegram"),
+		client:   client,
+		baseURL:  apiBaseURL,
+		outbox:   make(chan outgoing, outboxCapacity),
+	}
+}
+
+// Run subscribes to proxy events and processes incoming commands until ctx is done.
+func (b *Bot) Run(ctx context.Context) {
+	if b == nil {
+		return
+	}
+	unsubscribe := events.Subscribe(b.handleEvent)
+	defer unsubscribe()
+
+	go b.sendLoop(ctx)
+	log.Infof("telegram: bot started for %d chat(s)", len(b.allowed))
+
+	offset := int64(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		updates, err := b.getUpdates(ctx, offset)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnf("telegram: failed to poll updates: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryPollDelay):
+			}
+			continue
+		}
+		for _, u := range updates {
+			if u.UpdateID >= offset {
+				offset = u.UpdateID + 1
+			}
+			if u.Message == nil {
+				continue
+			}
+			b.handleMessage(u.Message.Chat.ID, u.Message.Text)
+		}
+	}
+}
+
+// handleEvent queues a notification for every allowlisted chat.
+func (b *Bot) handleEvent(ev events.Event) {
+	if _, ok := b.events[ev.Type]; !ok {
+		return
+	}
+	text := notify.FormatEvent(ev)
+	for chatID := range b.allowed {
+		select {
+		case b.outbox <- outgoing{chatID: chatID, text: text}:
+		default:
+			log.Warn("telegram: outbox full, dropping notification")
+		}
+	}
+}
+
+// handleMessage executes a command from an allowlisted chat.
+func (b *Bot) handleMessage(chatID int64, text string) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return
+	}
+	if _, ok := b.allowed[chatID]; !ok {
+		log.Warnf("telegram: ignoring command from non-allowlisted chat %d", chatID)
+		return
+	}
+	b.reply(chatID, b.Execute(text))
+}
+
+// Execute runs a bot command and returns the reply text.
+func (b *Bot) Execute(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return ""
+	}
+	// Commands may be addressed as /cmd@BotName in group chats.
+	cmd := strings.ToLower(strings.SplitN(fields[0], "@", 2)[0])
+	args := fields[1:]
+
+	switch cmd {
+	case "/ban":
+		if len(args) < 1 {
+			return "Usage: /ban <api-key> [reason]"
+		}
+		reason := "Banned via Telegram"
+		if len(args) > 1 {
+			reason = strings.Join(args[1:], " ")
+		}
+		return b.commands.Ban(args[0], reason)
+	case "/unban":
+		if len(args) < 1 {
+			return "Usage: /unban <api-key>"
+		}
+		return b.commands.Unban(args[0])
+	case "/usage":
+		key := ""
+		if len(args) > 0 {
+			key = args[0]
+		}
+		return b.commands.Usage(key)
+	case "/start", "/help":
+		return "Commands:\n/ban <api-key> [reason]\n/unban <api-key>\n/usage [api-key]"
+	default:
+		return "Unknown command. Send /help for the list of commands."
+	}
+}
+
+func (b *Bot) reply(chatID int64, text string) {
+	if text == "" {
+		return
+	}
+	select {
+	case b.outbox <- outgoing{chatID: chatID, text: text}:
+	default:
+		log.Warn("telegram: outbox full, dropping reply")
+	}
+}
+
+func (b *Bot) sendLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-b.outbox:
+			if err := b.sendMessage(ctx, msg.chatID, msg.text); err != nil {
+				log.Warnf("telegram: failed to send message to chat %d: %v", msg.chatID, err)
+			}
+		}
+	}
+}
+
+type update struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+type apiResponse struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+func (b *Bot) getUpdates(ctx context.Context, offset int64) ([]update, error) {
+	params := url.Values{}
+	params.Set("timeout", strconv.Itoa(int(pollTimeout.Seconds())))
+	params.Set("allowed_updates", `["message"]`)
+	if offset > 0 {
+		params.Set("offset", strconv.FormatInt(offset, 10))
+	}
+	var updates []update
+	if err := b.call(ctx, "getUpdates", params, &updates); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+func (b *Bot) sendMessage(ctx context.Context, chatID int64, text string) error {
+	params := url.Values{}
+	params.Set("chat_id", strconv.FormatInt(chatID, 10))
+	params.Set("text", text)
+	params.Set("disable_web_page_preview", "true")
+	return b.call(ctx, "sendMessage", params, nil)
+}
+
+func (b *Bot) call(ctx context.Context, method string, params url.Values, out any) error {
+	endpoint := fmt.Sprintf("%s/bot%s/%s", b.baseURL, b.token, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			log.Debugf("telegram: failed to close response body: %v", errClose)
+		}
+	}()
+
+	var result apiResponse
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decode %s response: %w", method, err)
+	}
+	if !result.OK {
+		return fmt.Errorf("%s failed: %s", method, result.Description)
+	}
+	if out != nil && len(result.Result) > 0 {
+		return json.Unmarshal(result.Result, out)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/notify"
+)
+
+func TestExecuteBanAndUnban(t *testing.T) {
+	store, err := device.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	if _, err = store.AddDeviceWithinLimit("sk-test-key-123", "dev-a", "client_id", "10.0.0.1", false, 10); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	bot := &Bot{commands: notify.NewCommander(store, "telegram"), outbox: make(chan outgoing, 1)}
+
+	if reply := bot.Execute("/ban@ProxyBot sk-test-key-123 leaked on forum"); !strings.Contains(reply, "banned") {
+		t.Fatalf("unexpected ban reply: %q", reply)
+	}
+	binding, _ := store.Get("sk-test-key-123")
+	if !binding.Banned || binding.BanReason != "leaked on forum" {
+		t.Fatalf("expected key to be banned with reason, got %+v", binding)
+	}
+
+	if reply := bot.Execute("/unban sk-test-key-123"); !strings.Contains(reply, "unbanned") {
+		t.Fatalf("unexpected unban reply: %q", reply)
+	}
+	binding, _ = store.Get("sk-test-key-123")
+	if binding.Banned {
+		t.Fatal("expected key to be unbanned")
+	}
+
+	if reply := bot.Execute("/unban unknown-key"); !strings.Contains(reply, "No device binding") {
+		t.Fatalf("unexpected reply for unknown key: %q", reply)
+	}
+}
@@ -0,0 +1,238 @@
+// Package webhook delivers proxy events (bans, unbans, device registrations)
+// as signed JSON POSTs to configured endpoints, retrying failed deliveries
+// with exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/notify"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SignatureHeader carries "sha256=<hex HMAC>" over "<timestamp>.<body>".
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader carries the Unix timestamp used in the signature.
+	TimestampHeader = "X-Webhook-Timestamp"
+	// EventHeader carries the event type.
+	EventHeader = "X-Webhook-Event"
+
+	outboxCapacity     = 100
+	requestTimeout     = 10 * time.Second
+	defaultMaxRetries  = 5
+	defaultBaseBackoff = time.Second
+	maxBackoff         = 5 * time.Minute
+)
+
+// Payload is the JSON body posted for every event. API keys are masked.
+type Payload struct {
+	Type     events.Type    `json:"type"`
+	Time     time.Time      `json:"time"`
+	APIKey   string         `json:"api_key,omitempty"`
+	DeviceID string         `json:"device_id,omitempty"`
+	IP       string         `json:"ip,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+	Actor    string         `json:"actor,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
+	// Text is a human readable summary, accepted as-is by Slack-compatible incoming webhooks.
+	Text string `json:"text"`
+}
+
+// Dispatcher fans events out to webhook endpoints.
+type Dispatcher struct {
+	endpoints   []*endpoint
+	maxRetries  int
+	baseBackoff time.Duration
+	client      *http.Client
+}
+
+type endpoint struct {
+	url    string
+	secret string
+	events map[events.Type]struct{}
+	outbox chan events.Event
+}
+
+// New creates a dispatcher from configuration. It returns nil when webhooks are disabled.
+func New(cfg *config.Config) *Dispatcher {
+	if cfg == nil || !cfg.Webhooks.Enabled {
+		return nil
+	}
+	endpoints := make([]*endpoint, 0, len(cfg.Webhooks.Endpoints))
+	for _, ep := range cfg.Webhooks.Endpoints {
+		target := strings.TrimSpace(ep.URL)
+		if target == "" {
+			continue
+		}
+		types := ep.Events
+		if len(types) == 0 {
+			types = []string{string(events.TypeBan), string(events.TypeUnban), string(events.TypeDeviceRegistered)}
+		}
+		endpoints = append(endpoints, &endpoint{
+			url:    target,
+			secret: ep.Secret,
+			events: notify.EventFilter(types),
+			outbox: make(chan events.Event, outboxCapacity),
+		})
+	}
+	if len(endpoints) == 0 {
+		log.Warn("webhook: enabled but no endpoints configured, skipping")
+		return nil
+	}
+	maxRetries := cfg.Webhooks.MaxRetries
+	if maxRetries < 0 {
+		maxRetries = 0
+	} else if maxRetries == 0 {
+		maxRetries = defaultMaxRetries
+	}
+	return &Dispatcher{
+		endpoints:   endpoints,
+		maxRetries:  maxRetries,
+		baseBackoff: defaultBaseBackoff,
+		client:      util.SetProxy(&cfg.SDKConfig, &http.Client{Timeout: requestTimeout}),
+	}
+}
+
+// Run subscribes to proxy events and delivers them until ctx is done. Each
+// endpoint has its own worker so a failing endpoint does not delay the others.
+func (d *Dispatcher) Run(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	unsubscribe := events.Subscribe(d.handleEvent)
+	defer unsubscribe()
+
+	for _, ep := range d.endpoints {
+		go d.worker(ctx, ep)
+	}
+	log.Infof("webhook: delivering events to %d endpoint(s)", len(d.endpoints))
+	<-ctx.Done()
+}
+
+func (d *Dispatcher) handleEvent(ev events.Event) {
+	for _, ep := range d.endpoints {
+		if _, ok := ep.events[ev.Type]; !ok {
+			continue
+		}
+		select {
+		case ep.outbox <- ev:
+		default:
+			log.Warnf("webhook: outbox full for %s, dropping %s event", ep.url, ev.Type)
+		}
+	}
+}
+
+func (d *Dispatcher) worker(ctx context.Context, ep *endpoint) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-ep.outbox:
+			if err := d.deliver(ctx, ep, ev); err != nil && ctx.Err() == nil {
+				log.Warnf("webhook: giving up on %s event for %s: %v", ev.Type, ep.url, err)
+			}
+		}
+	}
+}
+
+// deliver posts the event, retrying network errors, 429 and 5xx responses with exponential backoff.
+func (d *Dispatcher) deliver(ctx context.Context, ep *endpoint, ev events.Event) error {
+	body, err := json.Marshal(Payload{
+		Type:     ev.Type,
+		Time:     ev.Time,
+		APIKey:   maskKey(ev.APIKey),
+		DeviceID: ev.DeviceID,
+		IP:       ev.IP,
+		Reason:   ev.Reason,
+		Actor:    ev.Actor,
+		Data:     ev.Data,
+		Text:     notify.FormatEvent(ev),
+	})
+	if err != nil {
+		return err
+	}
+
+	backoff := d.baseBackoff
+	for attempt := 0; ; attempt++ {
+		retryable, errPost := d.post(ctx, ep, ev.Type, body)
+		if errPost == nil {
+			return nil
+		}
+		if !retryable || attempt >= d.maxRetries {
+			return errPost
+		}
+		log.Debugf("webhook: delivery to %s failed (attempt %d), retrying in %s: %v", ep.url, attempt+1, backoff, errPost)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, ep *endpoint, eventType events.Type, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(eventType))
+	req.Header.Set(TimestampHeader, timestamp)
+	if ep.secret != "" {
+		req.Header.Set(SignatureHeader, Sign(ep.secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			log.Debugf("webhook: failed to close response body: %v", errClose)
+		}
+	}()
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retryable, fmt.Errorf("endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+// Sign returns the signature header value for a payload: "sha256=" followed by
+// the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the endpoint secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func maskKey(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	return device.MaskKey(apiKey)
+}
@@ -0,0 +1,62 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+)
+
+func TestDeliverSignsAndRetries(t *testing.T) {
+	var calls atomic.Int32
+	var got Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if want := Sign("s3cret", r.Header.Get(TimestampHeader), body); r.Header.Get(SignatureHeader) != want {
+			t.Errorf("signature mismatch: got %q want %q", r.Header.Get(SignatureHeader), want)
+		}
+		_ = json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	d := &Dispatcher{maxRetries: 2, baseBackoff: time.Millisecond, client: server.Client()}
+	ep := &endpoint{url: server.URL, secret: "s3cret"}
+	ev := events.Event{Type: events.TypeBan, APIKey: "sk-abcdefghijkl", Reason: "test"}
+	if err := d.deliver(context.Background(), ep, ev); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected 2 attempts, got %d", calls.Load())
+	}
+	if got.Type != events.TypeBan || got.APIKey == ev.APIKey || got.Text == "" {
+		t.Fatalf("unexpected payload: %+v", got)
+	}
+}
+
+func TestDeliverDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	d := &Dispatcher{maxRetries: 3, baseBackoff: time.Millisecond, client: server.Client()}
+	if err := d.deliver(context.Background(), &endpoint{url: server.URL}, events.Event{Type: events.TypeUnban}); err == nil {
+		t.Fatal("expected error")
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected a single attempt, got %d", calls.Load())
+	}
+}
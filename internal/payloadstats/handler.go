@@ -0,0 +1,14 @@
+package payloadstats
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSummary returns payload and token distributions per model and key tier
+// GET /v0/management/payload-stats?model=claude-sonnet-4
+func (r *Recorder) GetSummary(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"series": r.Summary(strings.TrimSpace(c.Query("model")))})
+}
@@ -0,0 +1,279 @@
+// Package payloadstats collects per model and key tier distributions of prompt
+// tokens, completion tokens and request/response body sizes. Observations feed
+// Prometheus histograms and an in-memory summary served by the management API,
+// which informs pricing and context-limit policies.
+package payloadstats
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+	"github.com/tidwall/gjson"
+)
+
+// Distribution names reported by Summary
+const (
+	PromptTokens     = "prompt_tokens"
+	CompletionTokens = "completion_tokens"
+	RequestBytes     = "request_bytes"
+	ResponseBytes    = "response_bytes"
+)
+
+// DefaultTier labels keys without an assigned plan tier.
+const DefaultTier = "default"
+
+// unknownModel labels requests whose model could not be determined.
+const unknownModel = "unknown"
+
+var (
+	// TokenBuckets are upper bounds for token count distributions.
+	TokenBuckets = []float64{256, 1024, 4096, 8192, 16384, 32768, 65536, 131072, 200000, 500000, 1000000}
+	// ByteBuckets are upper bounds for body size distributions.
+	ByteBuckets = []float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20}
+)
+
+var (
+	promptTokensHistogram = metrics.Default().NewHistogramVec(
+		"cliproxy_prompt_tokens",
+		"Prompt (input) tokens per request.",
+		TokenBuckets,
+		"model", "tier",
+	)
+	completionTokensHistogram = metrics.Default().NewHistogramVec(
+		"cliproxy_completion_tokens",
+		"Completion (output) tokens per request.",
+		TokenBuckets,
+		"model", "tier",
+	)
+	requestBytesHistogram = metrics.Default().NewHistogramVec(
+		"cliproxy_request_body_bytes",
+		"Client request body size in bytes.",
+		ByteBuckets,
+		"model", "tier",
+	)
+	responseBytesHistogram = metrics.Default().NewHistogramVec(
+		"cliproxy_response_body_bytes",
+		"Response body size in bytes sent to the client.",
+		ByteBuckets,
+		"model", "tier",
+	)
+)
+
+// TierFunc resolves the plan tier of a client key.
+type TierFunc func(apiKey string) string
+
+// Recorder aggregates payload distributions.
+type Recorder struct {
+	tier TierFunc
+
+	mu     sync.Mutex
+	series map[seriesKey]map[string]*distribution
+}
+
+type seriesKey struct {
+	model string
+	tier  string
+}
+
+type distribution struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+	max    float64
+}
+
+// New creates a recorder. A nil tier resolver labels every key DefaultTier.
+func New(tier TierFunc) *Recorder {
+	return &Recorder{tier: tier, series: make(map[seriesKey]map[string]*distribution)}
+}
+
+// tierOf resolves the tier label of a client key.
+func (r *Recorder) tierOf(apiKey string) string {
+	if r.tier == nil || apiKey == "" {
+		return DefaultTier
+	}
+	if tier := strings.ToLower(strings.TrimSpace(r.tier(apiKey))); tier != "" {
+		return tier
+	}
+	return DefaultTier
+}
+
+// observe records one value of the named distribution.
+func (r *Recorder) observe(name, model, tier string, value float64) {
+	var bounds []float64
+	switch name {
+	case PromptTokens:
+		bounds = TokenBuckets
+		promptTokensHistogram.Observe(value, model, tier)
+	case CompletionTokens:
+		bounds = TokenBuckets
+		completionTokensHistogram.Observe(value, model, tier)
+	case RequestBytes:
+		bounds = ByteBuckets
+		requestBytesHistogram.Observe(value, model, tier)
+	case ResponseBytes:
+		bounds = ByteBuckets
+		responseBytesHistogram.Observe(value, model, tier)
+	default:
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := seriesKey{model: model, tier: tier}
+	s, ok := r.series[key]
+	if !ok {
+		s = make(map[string]*distribution)
+		r.series[key] = s
+	}
+	d, ok := s[name]
+	if !ok {
+		d = &distribution{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+		s[name] = d
+	}
+	d.counts[sort.SearchFloat64s(bounds, value)]++
+	d.count++
+	d.sum += value
+	if value > d.max {
+		d.max = value
+	}
+}
+
+// Middleware records request and response body sizes. The request body is
+// buffered once to read its model and restored for downstream handlers.
+func (r *Recorder) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if r == nil {
+			c.Next()
+			return
+		}
+		var requestSize int
+		var model string
+		if c.Request.Body != nil {
+			body, err := io.ReadAll(c.Request.Body)
+			_ = c.Request.Body.Close()
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			if err == nil {
+				requestSize = len(body)
+				model = strings.TrimSpace(gjson.GetBytes(body, "model").String())
+			}
+		}
+		if model == "" {
+			// Gemini routes carry the model in the path: /models/{model}:{method}
+			model, _, _ = strings.Cut(strings.TrimPrefix(c.Param("action"), "/"), ":")
+		}
+		if model == "" {
+			model = unknownModel
+		}
+
+		c.Next()
+
+		if c.Request.Method == http.MethodGet {
+			return
+		}
+		tier := r.tierOf(c.GetString("apiKey"))
+		r.observe(RequestBytes, model, tier, float64(requestSize))
+		if size := c.Writer.Size(); size >= 0 {
+			r.observe(ResponseBytes, model, tier, float64(size))
+		}
+	}
+}
+
+// HandleUsage implements coreusage.Plugin and records token distributions.
+func (r *Recorder) HandleUsage(_ context.Context, record coreusage.Record) {
+	if r == nil || record.Failed || usage.IsExcludedAPIKey(record.APIKey) {
+		return
+	}
+	if record.Detail.InputTokens <= 0 && record.Detail.OutputTokens <= 0 {
+		return
+	}
+	model := strings.TrimSpace(record.Model)
+	if model == "" {
+		model = unknownModel
+	}
+	tier := r.tierOf(record.APIKey)
+	r.observe(PromptTokens, model, tier, float64(record.Detail.InputTokens))
+	r.observe(CompletionTokens, model, tier, float64(record.Detail.OutputTokens))
+}
+
+// Stats summarises one distribution. Percentiles are bucket upper bounds, so
+// they overestimate by at most one bucket width; values beyond the largest
+// bucket report the observed maximum.
+type Stats struct {
+	Count uint64  `json:"count"`
+	Sum   float64 `json:"sum"`
+	Mean  float64 `json:"mean"`
+	Max   float64 `json:"max"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+}
+
+// SeriesSummary holds the distributions observed for one model and tier.
+type SeriesSummary struct {
+	Model         string           `json:"model"`
+	Tier          string           `json:"tier"`
+	Distributions map[string]Stats `json:"distributions"`
+}
+
+// Summary returns the distributions of every model and tier, optionally
+// restricted to one model, ordered by model then tier.
+func (r *Recorder) Summary(model string) []SeriesSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]SeriesSummary, 0, len(r.series))
+	for key, s := range r.series {
+		if model != "" && key.model != model {
+			continue
+		}
+		summary := SeriesSummary{Model: key.model, Tier: key.tier, Distributions: make(map[string]Stats, len(s))}
+		for name, d := range s {
+			summary.Distributions[name] = d.stats()
+		}
+		out = append(out, summary)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Model != out[j].Model {
+			return out[i].Model < out[j].Model
+		}
+		return out[i].Tier < out[j].Tier
+	})
+	return out
+}
+
+func (d *distribution) stats() Stats {
+	s := Stats{Count: d.count, Sum: d.sum, Max: d.max}
+	if d.count == 0 {
+		return s
+	}
+	s.Mean = math.Round(d.sum/float64(d.count)*100) / 100
+	s.P50, s.P90, s.P99 = d.quantile(0.5), d.quantile(0.9), d.quantile(0.99)
+	return s
+}
+
+// quantile returns the upper bound of the bucket holding quantile q.
+func (d *distribution) quantile(q float64) float64 {
+	rank := uint64(math.Ceil(q * float64(d.count)))
+	var seen uint64
+	for i, n := range d.counts {
+		seen += n
+		if seen >= rank {
+			if i < len(d.bounds) {
+				return math.Min(d.bounds[i], d.max)
+			}
+			break
+		}
+	}
+	return d.max
+}
@@ -0,0 +1,63 @@
+package payloadstats
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+func TestRecorderSummarisesPerModelAndTier(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := New(func(apiKey string) string {
+		if apiKey == "pro-key" {
+			return "Pro"
+		}
+		return ""
+	})
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("Authorization"))
+		c.Next()
+	}, r.Middleware())
+	engine.POST("/v1/messages", func(c *gin.Context) {
+		body, _ := c.GetRawData()
+		c.String(http.StatusOK, string(body))
+	})
+
+	payload := `{"model":"claude-sonnet-4","messages":[]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(payload))
+	req.Header.Set("Authorization", "pro-key")
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	if rec.Body.String() != payload {
+		t.Fatalf("expected the request body to reach the handler, got %q", rec.Body.String())
+	}
+
+	r.HandleUsage(context.Background(), coreusage.Record{Model: "claude-sonnet-4", APIKey: "pro-key", Detail: coreusage.Detail{InputTokens: 3000, OutputTokens: 200}})
+	r.HandleUsage(context.Background(), coreusage.Record{Model: "claude-sonnet-4", APIKey: "pro-key", Detail: coreusage.Detail{InputTokens: 5000, OutputTokens: 100}})
+	r.HandleUsage(context.Background(), coreusage.Record{Model: "claude-sonnet-4", APIKey: "other", Detail: coreusage.Detail{InputTokens: 10}})
+
+	summary := r.Summary("claude-sonnet-4")
+	if len(summary) != 2 || summary[0].Tier != DefaultTier || summary[1].Tier != "pro" {
+		t.Fatalf("unexpected series %+v", summary)
+	}
+	pro := summary[1].Distributions
+	if got := pro[RequestBytes]; got.Count != 1 || got.Sum != float64(len(payload)) {
+		t.Fatalf("unexpected request size stats %+v", got)
+	}
+	if got := pro[ResponseBytes]; got.Count != 1 || got.Max != float64(len(payload)) {
+		t.Fatalf("unexpected response size stats %+v", got)
+	}
+	prompt := pro[PromptTokens]
+	if prompt.Count != 2 || prompt.Mean != 4000 || prompt.P50 != 4096 || prompt.P99 != 5000 {
+		t.Fatalf("unexpected prompt token stats %+v", prompt)
+	}
+	if len(r.Summary("gpt-4o")) != 0 {
+		t.Fatal("expected no series for an unseen model")
+	}
+}
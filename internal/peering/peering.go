@@ -0,0 +1,141 @@
+// Package peering lets a proxy use another instance of itself as an upstream,
+// so resellers can build hierarchical topologies. Requests sent to a peer carry
+// the local request ID and a hop count; the peer adopts the request ID so one
+// ID identifies the request across the whole chain, and refuses requests that
+// have looped through too many hops. Rate-limit and quota headers returned by
+// the peer are merged into the client response, keeping whichever hop is
+// closest to its limit, so clients throttle to the tightest limit in the chain.
+package peering
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/logging"
+)
+
+// Headers exchanged between peers
+const (
+	HeaderRequestID = logging.RequestIDHeader
+	HeaderHops      = "X-CC-Proxy-Hops"
+)
+
+// MaxHops is the longest chain of peers a request may pass through.
+const MaxHops = 8
+
+// AttributeKey marks upstream credentials that point at a peer.
+const AttributeKey = "peer"
+
+const hopsContextKey = "peerHops"
+
+// limitFamily is a set of rate-limit headers that only make sense together.
+type limitFamily struct {
+	limit, remaining, reset string
+}
+
+var limitFamilies = []limitFamily{
+	{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
+	{"X-RateLimit-Limit-Tokens", "X-RateLimit-Remaining-Tokens", "X-RateLimit-Reset-Tokens"},
+	{"X-Quota-Limit", "X-Quota-Remaining", "X-Quota-Reset"},
+	{"X-Quota-Limit-Output-Tokens", "X-Quota-Remaining-Output-Tokens", "X-Quota-Reset-Output-Tokens"},
+}
+
+// Middleware rejects requests that have already passed through MaxHops peers
+// with 508 Loop Detected and remembers the hop count for outbound peer requests.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := strings.TrimSpace(c.GetHeader(HeaderHops))
+		if raw == "" {
+			c.Next()
+			return
+		}
+		hops, err := strconv.Atoi(raw)
+		if err != nil || hops < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_request",
+				"message": "Invalid " + HeaderHops + " header",
+			})
+			return
+		}
+		if hops >= MaxHops {
+			c.AbortWithStatusJSON(http.StatusLoopDetected, gin.H{
+				"error":   "peer_loop_detected",
+				"message": "Request passed through " + strconv.Itoa(hops) + " proxies; check the peer configuration for loops",
+			})
+			return
+		}
+		c.Set(hopsContextKey, hops)
+		c.Next()
+	}
+}
+
+// IsPeer reports whether upstream credential attributes mark a peer.
+func IsPeer(attributes map[string]string) bool {
+	return strings.EqualFold(strings.TrimSpace(attributes[AttributeKey]), "true")
+}
+
+// PrepareRequest adds the request ID and the incremented hop count to a request
+// sent to a peer. The client request is read from the "gin" context value.
+func PrepareRequest(r *http.Request) {
+	hops := 0
+	if ginCtx, ok := r.Context().Value("gin").(*gin.Context); ok && ginCtx != nil {
+		hops = ginCtx.GetInt(hopsContextKey)
+		if id := logging.GetGinRequestID(ginCtx); id != "" {
+			r.Header.Set(HeaderRequestID, id)
+		}
+	}
+	if r.Header.Get(HeaderRequestID) == "" {
+		if id := logging.GetRequestID(r.Context()); id != "" {
+			r.Header.Set(HeaderRequestID, id)
+		}
+	}
+	r.Header.Set(HeaderHops, strconv.Itoa(hops+1))
+}
+
+// MergeResponseHeaders copies a peer's rate-limit and quota headers into dst.
+// Each family replaces the local one when the peer has fewer requests or
+// tokens remaining.
+func MergeResponseHeaders(dst, peer http.Header) {
+	for _, f := range limitFamilies {
+		peerRemaining, ok := headerInt(peer, f.remaining)
+		if !ok {
+			continue
+		}
+		if local, hasLocal := headerInt(dst, f.remaining); hasLocal && local <= peerRemaining {
+			continue
+		}
+		for _, name := range []string{f.limit, f.remaining, f.reset} {
+			if v := peer.Get(name); v != "" {
+				dst.Set(name, v)
+			} else {
+				dst.Del(name)
+			}
+		}
+	}
+	if peer.Get("X-Quota-Overage") != "" {
+		dst.Set("X-Quota-Overage", peer.Get("X-Quota-Overage"))
+	}
+}
+
+// MergeIntoClientResponse merges a peer's rate-limit headers into the response
+// of the client request carried by ctx, if it has not been written yet.
+func MergeIntoClientResponse(ctx context.Context, peer http.Header) {
+	if ctx == nil {
+		return
+	}
+	if ginCtx, ok := ctx.Value("gin").(*gin.Context); ok && ginCtx != nil && !ginCtx.Writer.Written() {
+		MergeResponseHeaders(ginCtx.Writer.Header(), peer)
+	}
+}
+
+func headerInt(h http.Header, name string) (int64, bool) {
+	v := strings.TrimSpace(h.Get(name))
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,99 @@
+package peering
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/logging"
+)
+
+func TestMiddlewareRejectsLoops(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Middleware())
+	var seen int
+	engine.POST("/v1/messages", func(c *gin.Context) {
+		seen = c.GetInt(hopsContextKey)
+		c.Status(http.StatusOK)
+	})
+
+	cases := []struct {
+		hops string
+		want int
+	}{
+		{"", http.StatusOK},
+		{"3", http.StatusOK},
+		{strconv.Itoa(MaxHops), http.StatusLoopDetected},
+		{"x", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		if tc.hops != "" {
+			req.Header.Set(HeaderHops, tc.hops)
+		}
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Fatalf("hops %q: status = %d, want %d", tc.hops, rec.Code, tc.want)
+		}
+	}
+	if seen != 3 {
+		t.Fatalf("hop count in context = %d, want 3", seen)
+	}
+}
+
+func TestPrepareRequestForwardsRequestIDAndHops(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Set(hopsContextKey, 2)
+	logging.SetGinRequestID(ginCtx, "abc123")
+
+	ctx := context.WithValue(context.Background(), "gin", ginCtx)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://peer/v1/messages", nil)
+	PrepareRequest(req)
+
+	if got := req.Header.Get(HeaderRequestID); got != "abc123" {
+		t.Fatalf("request ID = %q, want abc123", got)
+	}
+	if got := req.Header.Get(HeaderHops); got != "3" {
+		t.Fatalf("hops = %q, want 3", got)
+	}
+}
+
+func TestMergeResponseHeadersKeepsTightestLimit(t *testing.T) {
+	dst := http.Header{}
+	dst.Set("X-RateLimit-Limit", "100")
+	dst.Set("X-RateLimit-Remaining", "10")
+	dst.Set("X-RateLimit-Reset", "60")
+	dst.Set("X-Quota-Limit", "1000")
+	dst.Set("X-Quota-Remaining", "900")
+	dst.Set("X-Quota-Reset", "1700000000")
+
+	peer := http.Header{}
+	peer.Set("X-RateLimit-Limit", "50")
+	peer.Set("X-RateLimit-Remaining", "20")
+	peer.Set("X-RateLimit-Reset", "30")
+	peer.Set("X-Quota-Limit", "500")
+	peer.Set("X-Quota-Remaining", "5")
+	peer.Set("X-Quota-Reset", "1700000100")
+	peer.Set("X-RateLimit-Remaining-Tokens", "4000")
+
+	MergeResponseHeaders(dst, peer)
+
+	if got := dst.Get("X-RateLimit-Remaining"); got != "10" {
+		t.Fatalf("local request limit should win, remaining = %q", got)
+	}
+	if dst.Get("X-Quota-Limit") != "500" || dst.Get("X-Quota-Remaining") != "5" || dst.Get("X-Quota-Reset") != "1700000100" {
+		t.Fatalf("peer quota should replace the local family, got %v", dst)
+	}
+	if got := dst.Get("X-RateLimit-Remaining-Tokens"); got != "4000" {
+		t.Fatalf("peer-only family should be copied, got %q", got)
+	}
+	if dst.Get("X-RateLimit-Limit-Tokens") != "" {
+		t.Fatalf("headers missing from the peer family should not be invented")
+	}
+}
@@ -0,0 +1,147 @@
+// Package pii redacts personal data (email addresses, phone numbers, payment
+// card numbers and operator-defined patterns) from text before the logging and
+// audit subsystems write it, so prompt fragments quoted in request logs, error
+// messages or audit records never land on disk.
+//
+// The redactor is process-wide: Configure installs it and String/Bytes apply
+// it. With redaction disabled both return their input unchanged.
+package pii
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Built-in detector names accepted in pii-redaction.types
+const (
+	TypeEmail      = "email"
+	TypePhone      = "phone"
+	TypeCreditCard = "credit-card"
+)
+
+var builtins = map[string]string{
+	TypeEmail: `[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`,
+	// Numbers need separators or a leading "+" so timestamps, IDs and token counts are not mistaken for phones.
+	TypePhone: `(?:\+\d{1,3}[\s.-]?(?:\(\d{1,4}\)[\s.-]?)?\d{2,4}(?:[\s.-]?\d{2,4}){1,4}|\(?\b\d{3}\)?[\s.-]\d{3}[\s.-]\d{4})\b`,
+	// Candidates are confirmed with the Luhn checksum.
+	TypeCreditCard: `\b\d(?:[ -]?\d){12,18}\b`,
+}
+
+type detector struct {
+	name        string
+	re          *regexp.Regexp
+	replacement string
+	luhn        bool
+}
+
+type redactor struct {
+	detectors []detector
+}
+
+var active atomic.Pointer[redactor]
+
+// Configure installs the redactor described by cfg; a disabled configuration removes it.
+// Invalid custom patterns are logged and skipped.
+func Configure(cfg config.PIIRedactionConfig) {
+	if !cfg.Enabled {
+		active.Store(nil)
+		return
+	}
+	types := cfg.Types
+	if len(types) == 0 {
+		types = []string{TypeEmail, TypePhone, TypeCreditCard}
+	}
+	r := &redactor{}
+	for _, t := range types {
+		name := strings.ToLower(strings.TrimSpace(t))
+		pattern, ok := builtins[name]
+		if !ok {
+			log.Warnf("pii-redaction: ignoring unknown type %q", t)
+			continue
+		}
+		r.detectors = append(r.detectors, detector{
+			name:        name,
+			re:          regexp.MustCompile(pattern),
+			replacement: "[REDACTED:" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "]",
+			luhn:        name == TypeCreditCard,
+		})
+	}
+	for _, p := range cfg.Patterns {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil || strings.TrimSpace(p.Pattern) == "" {
+			log.Warnf("pii-redaction: ignoring pattern %q: %v", p.Name, err)
+			continue
+		}
+		replacement := p.Replacement
+		if replacement == "" {
+			replacement = "[REDACTED]"
+		}
+		r.detectors = append(r.detectors, detector{name: p.Name, re: re, replacement: replacement})
+	}
+	active.Store(r)
+}
+
+// Enabled reports whether redaction is on.
+func Enabled() bool { return active.Load() != nil }
+
+// String returns s with personal data replaced.
+func String(s string) string {
+	r := active.Load()
+	if r == nil || s == "" {
+		return s
+	}
+	for _, d := range r.detectors {
+		s = d.apply(s)
+	}
+	return s
+}
+
+// Bytes returns b with personal data replaced. The input is returned as is when nothing matches.
+func Bytes(b []byte) []byte {
+	if active.Load() == nil || len(b) == 0 {
+		return b
+	}
+	out := String(string(b))
+	if len(out) == len(b) && out == string(b) {
+		return b
+	}
+	return []byte(out)
+}
+
+func (d detector) apply(s string) string {
+	if !d.luhn {
+		return d.re.ReplaceAllLiteralString(s, d.replacement)
+	}
+	return d.re.ReplaceAllStringFunc(s, func(match string) string {
+		if luhnValid(match) {
+			return d.replacement
+		}
+		return match
+	})
+}
+
+// luhnValid reports whether the digits of s pass the Luhn checksum.
+func luhnValid(s string) bool {
+	digits := bytes.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, []byte(s))
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		n := int(digits[i] - '0')
+		if (len(digits)-1-i)%2 == 1 {
+			if n *= 2; n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+	}
+	return len(digits) >= 13 && sum%10 == 0
+}
@@ -0,0 +1,44 @@
+package pii
+
+import (
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func TestRedactsBuiltinAndCustomPatterns(t *testing.T) {
+	Configure(config.PIIRedactionConfig{
+		Enabled:  true,
+		Patterns: []config.PIIPattern{{Name: "employee", Pattern: `EMP-\d{6}`, Replacement: "[EMPLOYEE]"}},
+	})
+	t.Cleanup(func() { Configure(config.PIIRedactionConfig{}) })
+
+	cases := map[string]string{
+		"mail jane.doe@corp.example.com now":          "mail [REDACTED:EMAIL] now",
+		"call +1 415 555 0100 or (415) 555-0199":      "call [REDACTED:PHONE] or [REDACTED:PHONE]",
+		"card 4111 1111 1111 1111 exp 12/29":          "card [REDACTED:CREDIT_CARD] exp 12/29",
+		"order 4111 1111 1111 1112 is not a card":     "order 4111 1111 1111 1112 is not a card",
+		"[2025-12-23 20:14:04] used 1234567 tokens":   "[2025-12-23 20:14:04] used 1234567 tokens",
+		`{"text":"badge EMP-123456","user":"a@b.io"}`: `{"text":"badge [EMPLOYEE]","user":"[REDACTED:EMAIL]"}`,
+	}
+	for in, want := range cases {
+		if got := String(in); got != want {
+			t.Errorf("String(%q) = %q, want %q", in, got, want)
+		}
+	}
+	unchanged := []byte("nothing to see")
+	if got := Bytes(unchanged); &got[0] != &unchanged[0] {
+		t.Error("expected Bytes to return its input when nothing matches")
+	}
+}
+
+func TestDisabledRedactionIsANoop(t *testing.T) {
+	Configure(config.PIIRedactionConfig{Enabled: true, Types: []string{TypePhone}})
+	if got := String("jane@corp.example.com"); got != "jane@corp.example.com" {
+		t.Fatalf("expected only phones to be redacted, got %q", got)
+	}
+	Configure(config.PIIRedactionConfig{})
+	if Enabled() || String("+1 415 555 0100") != "+1 415 555 0100" {
+		t.Fatal("expected no redaction while disabled")
+	}
+}
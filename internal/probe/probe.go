@@ -0,0 +1,241 @@
+// Package probe implements a synthetic end-to-end prober that periodically
+// sends a tiny prompt through the proxy's own public API using a dedicated
+// probe key, recording success and latency for metrics and health checks.
+package probe
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/errorlog"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultInterval         = 60 * time.Second
+	defaultTimeout          = 30 * time.Second
+	defaultEndpoint         = "/v1/messages"
+	defaultPrompt           = "Reply with OK."
+	defaultFailureThreshold = 3
+	maxErrorBody            = 256
+)
+
+var (
+	probeRuns = metrics.Default().NewCounterVec(
+		"cliproxy_probe_runs_total",
+		"Synthetic probe runs by result.",
+		"result",
+	)
+	probeLatency = metrics.Default().NewHistogramVec(
+		"cliproxy_probe_latency_seconds",
+		"End-to-end latency of successful synthetic probes in seconds.",
+		nil,
+	)
+	probeUp = metrics.Default().NewGaugeVec(
+		"cliproxy_probe_up",
+		"1 if the last synthetic probe succeeded, 0 otherwise.",
+	)
+)
+
+// Status is the latest probe outcome.
+type Status struct {
+	Enabled             bool      `json:"enabled"`
+	LastRun             time.Time `json:"last_run,omitempty"`
+	LastSuccess         time.Time `json:"last_success,omitempty"`
+	Success             bool      `json:"success"`
+	LatencyMS           int64     `json:"latency_ms"`
+	StatusCode          int       `json:"status_code,omitempty"`
+	Error               string    `json:"error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Healthy             bool      `json:"healthy"`
+}
+
+// Prober runs synthetic requests against the local proxy.
+type Prober struct {
+	url              string
+	apiKey           string
+	body             []byte
+	interval         time.Duration
+	failureThreshold int
+	client           *http.Client
+
+	mu     sync.RWMutex
+	status Status
+}
+
+// New creates a prober from configuration. It returns nil when probing is disabled.
+func New(cfg *config.Config) *Prober {
+	if cfg == nil || !cfg.Probe.Enabled {
+		return nil
+	}
+	pc := cfg.Probe
+	if strings.TrimSpace(pc.APIKey) == "" || strings.TrimSpace(pc.Model) == "" {
+		log.Warn("probe: enabled but api-key or model is empty, skipping")
+		return nil
+	}
+
+	endpoint := strings.TrimSpace(pc.Endpoint)
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	prompt := pc.Prompt
+	if strings.TrimSpace(prompt) == "" {
+		prompt = defaultPrompt
+	}
+	body, err := buildBody(endpoint, pc.Model, prompt)
+	if err != nil {
+		log.Warnf("probe: %v, skipping", err)
+		return nil
+	}
+
+	interval := time.Duration(pc.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	timeout := time.Duration(pc.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	threshold := pc.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLS.Enable {
+		scheme = "https"
+		// The probe targets this process; the certificate may not cover loopback names.
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	host := strings.TrimSpace(cfg.Host)
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	return &Prober{
+		url:              fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(cfg.Port)), endpoint),
+		apiKey:           strings.TrimSpace(pc.APIKey),
+		body:             body,
+		interval:         interval,
+		failureThreshold: threshold,
+		client:           &http.Client{Timeout: timeout, Transport: transport},
+		status:           Status{Enabled: true, Healthy: true},
+	}
+}
+
+// buildBody renders the probe payload; both supported endpoints accept the same minimal shape.
+func buildBody(endpoint, model, prompt string) ([]byte, error) {
+	if endpoint != "/v1/messages" && endpoint != "/v1/chat/completions" {
+		return nil, fmt.Errorf("unsupported endpoint %q", endpoint)
+	}
+	return json.Marshal(map[string]any{
+		"model":      model,
+		"max_tokens": 8,
+		"messages":   []map[string]string{{"role": "user", "content": prompt}},
+	})
+}
+
+// Run probes on every interval until ctx is done.
+func (p *Prober) Run(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	log.Infof("probe: probing %s every %s", p.url, p.interval)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce executes a single probe and updates the recorded status.
+func (p *Prober) RunOnce(ctx context.Context) Status {
+	start := time.Now()
+	code, err := p.send(ctx)
+	latency := time.Since(start)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.LastRun = start
+	p.status.LatencyMS = latency.Milliseconds()
+	p.status.StatusCode = code
+	if err != nil {
+		p.status.Success = false
+		p.status.Error = errorlog.Sanitize(err.Error())
+		p.status.ConsecutiveFailures++
+		probeRuns.Inc("failure")
+		probeUp.Set(0)
+		log.Warnf("probe: request failed after %s: %v", latency, err)
+	} else {
+		p.status.Success = true
+		p.status.Error = ""
+		p.status.ConsecutiveFailures = 0
+		p.status.LastSuccess = start
+		probeRuns.Inc("success")
+		probeUp.Set(1)
+		probeLatency.Observe(latency.Seconds())
+	}
+	p.status.Healthy = p.status.ConsecutiveFailures < p.failureThreshold
+	return p.status
+}
+
+func (p *Prober) send(ctx context.Context) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(p.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req.Header.Set("User-Agent", "cli-proxy-api-probe")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			log.Debugf("probe: failed to close response body: %v", errClose)
+		}
+	}()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return resp.StatusCode, nil
+}
+
+// Status returns the latest probe outcome. A nil prober reports a disabled, healthy status.
+func (p *Prober) Status() Status {
+	if p == nil {
+		return Status{Healthy: true}
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.status
+}
+
+// APIKey returns the dedicated probe key, or empty when probing is disabled.
+func (p *Prober) APIKey() string {
+	if p == nil {
+		return ""
+	}
+	return p.apiKey
+}
@@ -0,0 +1,44 @@
+package probe
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRunOnceTracksConsecutiveFailures(t *testing.T) {
+	fail := true
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer probe-key" {
+			t.Errorf("unexpected authorization header %q", r.Header.Get("Authorization"))
+		}
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	p := &Prober{
+		url:              upstream.URL + "/v1/messages",
+		apiKey:           "probe-key",
+		body:             []byte(`{}`),
+		failureThreshold: 2,
+		client:           upstream.Client(),
+		status:           Status{Enabled: true, Healthy: true},
+	}
+
+	if st := p.RunOnce(context.Background()); st.Success || !st.Healthy || st.StatusCode != http.StatusBadGateway {
+		t.Fatalf("unexpected status after first failure: %+v", st)
+	}
+	if st := p.RunOnce(context.Background()); st.Healthy || st.ConsecutiveFailures != 2 {
+		t.Fatalf("expected unhealthy after reaching threshold: %+v", st)
+	}
+
+	fail = false
+	if st := p.RunOnce(context.Background()); !st.Success || !st.Healthy || st.ConsecutiveFailures != 0 {
+		t.Fatalf("expected recovery after success: %+v", st)
+	}
+}
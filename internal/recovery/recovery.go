@@ -0,0 +1,225 @@
+// Package recovery provides break-glass access to the management API when the
+// management key is lost. Two credentials are accepted, each valid once:
+//
+//   - a recovery code generated on first start and printed to the console; only
+//     its bcrypt hash is kept on disk;
+//   - a break-glass token the operator writes into a file in the auth directory,
+//     proving filesystem access to the host.
+//
+// Every attempt, successful or not, is appended to a recovery audit file that
+// cannot be disabled and published as an event so notification integrations
+// alert the operators.
+package recovery
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// File names inside the auth directory
+const (
+	CodeHashFile   = ".management-recovery"
+	BreakGlassFile = "management-break-glass"
+)
+
+// Credential kinds recorded for an attempt
+const (
+	MethodCode       = "recovery_code"
+	MethodBreakGlass = "break_glass"
+)
+
+// minBreakGlassLength rejects trivially guessable break-glass tokens.
+const minBreakGlassLength = 16
+
+// codeAlphabet omits characters that are easily confused when read off a console.
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// ErrInvalidCredential is returned when neither the recovery code nor the break-glass token matches.
+var ErrInvalidCredential = errors.New("invalid recovery credential")
+
+var attempts = metrics.Default().NewCounterVec(
+	"cliproxy_management_recovery_attempts_total",
+	"Management recovery attempts by method and outcome.",
+	"method", "outcome",
+)
+
+// Attempt is one line of the recovery audit file.
+type Attempt struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method,omitempty"`
+	Outcome string    `json:"outcome"`
+	IP      string    `json:"ip,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// Manager owns the recovery credentials of one server.
+type Manager struct {
+	dir       string
+	auditPath string
+
+	mu sync.Mutex
+}
+
+// New creates a manager keeping credentials in authDir and writing attempts to auditPath.
+func New(authDir, auditPath string) *Manager {
+	return &Manager{dir: authDir, auditPath: auditPath}
+}
+
+// Init generates a recovery code when none is stored and returns it so the
+// caller can show it once. It returns "" when a code already exists.
+func (m *Manager) Init() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path := filepath.Join(m.dir, CodeHashFile)
+	if _, err := os.Stat(path); err == nil {
+		return "", nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	code, err := generateCode()
+	if err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(normalizeCode(code)), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(m.dir, 0o700); err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(path, hash, 0o600); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Redeem checks a recovery code or break-glass token and, when it matches,
+// calls apply with the credential kind. The credential is set aside while apply
+// runs and consumed only once apply succeeds; when apply fails it is restored so
+// the operator can try again. The attempt is audited and published either way.
+func (m *Manager) Redeem(credential, ip string, apply func(method string) error) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	credential = strings.TrimSpace(credential)
+	method, path, err := m.matchLocked(credential)
+	if err == nil {
+		err = redeemFile(path, method, apply)
+	}
+	attempt := Attempt{Time: time.Now().UTC(), Method: method, Outcome: "success", IP: ip}
+	if err != nil {
+		attempt.Outcome = "failure"
+		attempt.Reason = err.Error()
+	}
+	m.record(attempt)
+	return method, err
+}
+
+// matchLocked returns the kind and file of the credential matching credential.
+func (m *Manager) matchLocked(credential string) (string, string, error) {
+	if credential == "" {
+		return "", "", ErrInvalidCredential
+	}
+	codePath := filepath.Join(m.dir, CodeHashFile)
+	if hash, err := os.ReadFile(codePath); err == nil && len(hash) > 0 {
+		if bcrypt.CompareHashAndPassword(hash, []byte(normalizeCode(credential))) == nil {
+			return MethodCode, codePath, nil
+		}
+	}
+	tokenPath := filepath.Join(m.dir, BreakGlassFile)
+	if raw, err := os.ReadFile(tokenPath); err == nil {
+		token := strings.TrimSpace(string(raw))
+		if len(token) >= minBreakGlassLength && subtle.ConstantTimeCompare([]byte(token), []byte(credential)) == 1 {
+			return MethodBreakGlass, tokenPath, nil
+		}
+	}
+	return "", "", ErrInvalidCredential
+}
+
+// redeemFile moves the credential file aside, runs apply and then deletes the
+// file, or moves it back when apply fails.
+func redeemFile(path, method string, apply func(method string) error) error {
+	reserved := path + ".redeeming"
+	if err := os.Rename(path, reserved); err != nil {
+		return fmt.Errorf("consume %s: %w", method, err)
+	}
+	if err := apply(method); err != nil {
+		if errRestore := os.Rename(reserved, path); errRestore != nil {
+			log.Errorf("recovery: failed to restore %s after a failed recovery: %v", method, errRestore)
+		}
+		return err
+	}
+	if err := os.Remove(reserved); err != nil {
+		log.Warnf("recovery: failed to remove consumed %s: %v", method, err)
+	}
+	return nil
+}
+
+// record appends the attempt to the audit file, logs it and publishes it.
+func (m *Manager) record(a Attempt) {
+	method := a.Method
+	if method == "" {
+		method = "unknown"
+	}
+	attempts.Inc(method, a.Outcome)
+	if a.Outcome == "success" {
+		log.Warnf("management access recovered via %s from %s", a.Method, a.IP)
+		events.Publish(events.Event{Type: events.TypeManagementRecovered, IP: a.IP, Actor: a.Method, Time: a.Time})
+	} else {
+		log.Warnf("failed management recovery attempt from %s: %s", a.IP, a.Reason)
+		events.Publish(events.Event{Type: events.TypeManagementRecoveryFailed, IP: a.IP, Reason: a.Reason, Time: a.Time})
+	}
+	if m.auditPath == "" {
+		return
+	}
+	line, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(m.auditPath), 0o700); err != nil {
+		log.Errorf("recovery: failed to create audit directory: %v", err)
+		return
+	}
+	f, err := os.OpenFile(m.auditPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Errorf("recovery: failed to open audit file: %v", err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		log.Errorf("recovery: failed to write audit record: %v", err)
+	}
+}
+
+// generateCode returns a random code formatted as five groups of five characters.
+func generateCode() (string, error) {
+	raw := make([]byte, 25)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for i, b := range raw {
+		if i > 0 && i%5 == 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteByte(codeAlphabet[int(b)%len(codeAlphabet)])
+	}
+	return sb.String(), nil
+}
+
+// normalizeCode makes codes comparable regardless of case and grouping.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
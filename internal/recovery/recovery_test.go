@@ -0,0 +1,115 @@
+package recovery
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+)
+
+func TestRecoveryCodeIsShownOnceAndConsumed(t *testing.T) {
+	dir := t.TempDir()
+	m := New(dir, filepath.Join(dir, "audit", "recovery.jsonl"))
+
+	code, err := m.Init()
+	if err != nil || code == "" {
+		t.Fatalf("Init() = %q, %v; want a code", code, err)
+	}
+	if again, _ := m.Init(); again != "" {
+		t.Fatalf("second Init() returned a new code while one is stored")
+	}
+	raw, _ := os.ReadFile(filepath.Join(dir, CodeHashFile))
+	if strings.Contains(string(raw), code) {
+		t.Fatalf("recovery code stored in plaintext")
+	}
+
+	if _, err = m.Redeem("AAAAA-AAAAA", "10.0.0.1", accept); !errors.Is(err, ErrInvalidCredential) {
+		t.Fatalf("wrong code: err = %v", err)
+	}
+	method, err := m.Redeem(strings.ToLower(code), "10.0.0.1", accept)
+	if err != nil || method != MethodCode {
+		t.Fatalf("Redeem() = %q, %v", method, err)
+	}
+	if _, err = m.Redeem(code, "10.0.0.1", accept); !errors.Is(err, ErrInvalidCredential) {
+		t.Fatalf("code accepted twice")
+	}
+	if next, _ := m.Init(); next == "" || next == code {
+		t.Fatalf("a fresh code should be issued after the old one is used")
+	}
+}
+
+func TestFailedApplyKeepsRecoveryCode(t *testing.T) {
+	dir := t.TempDir()
+	m := New(dir, "")
+	code, err := m.Init()
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	errSave := errors.New("config not writable")
+	if _, err = m.Redeem(code, "10.0.0.1", func(string) error { return errSave }); !errors.Is(err, errSave) {
+		t.Fatalf("failed apply: err = %v", err)
+	}
+	if method, errRedeem := m.Redeem(code, "10.0.0.1", accept); errRedeem != nil || method != MethodCode {
+		t.Fatalf("code not usable after a failed apply: %q, %v", method, errRedeem)
+	}
+	if _, err = m.Redeem(code, "10.0.0.1", accept); !errors.Is(err, ErrInvalidCredential) {
+		t.Fatalf("code accepted twice")
+	}
+}
+
+func TestBreakGlassTokenIsAuditedAndAlerted(t *testing.T) {
+	dir := t.TempDir()
+	auditPath := filepath.Join(dir, "audit", "recovery.jsonl")
+	m := New(dir, auditPath)
+
+	var published []events.Type
+	unsubscribe := events.Subscribe(func(ev events.Event) { published = append(published, ev.Type) })
+	defer unsubscribe()
+
+	tokenPath := filepath.Join(dir, BreakGlassFile)
+	if err := os.WriteFile(tokenPath, []byte("short\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Redeem("short", "::1", accept); err == nil {
+		t.Fatalf("short break-glass token accepted")
+	}
+	token := "break-glass-0123456789"
+	if err := os.WriteFile(tokenPath, []byte(token+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if method, err := m.Redeem(token, "::1", accept); err != nil || method != MethodBreakGlass {
+		t.Fatalf("Redeem() = %q, %v", method, err)
+	}
+	if _, err := os.Stat(tokenPath); !os.IsNotExist(err) {
+		t.Fatalf("break-glass file not removed after use")
+	}
+
+	f, err := os.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	var outcomes []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var a Attempt
+		if err = json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			t.Fatal(err)
+		}
+		outcomes = append(outcomes, a.Outcome)
+	}
+	if strings.Join(outcomes, ",") != "failure,success" {
+		t.Fatalf("audit outcomes = %v", outcomes)
+	}
+	if len(published) != 2 || published[0] != events.TypeManagementRecoveryFailed || published[1] != events.TypeManagementRecovered {
+		t.Fatalf("published events = %v", published)
+	}
+}
+
+func accept(string) error { return nil }
@@ -0,0 +1,229 @@
+// Package reqfeatures lets clients opt into optional behaviours per request
+// with X-CC-* headers, limited to the features their API key may use. The
+// middleware stores the negotiated Features on the gin context, where the auth
+// manager reads them when routing and scheduling the request.
+package reqfeatures
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+)
+
+// Request headers and the response header echoing what was applied
+const (
+	HeaderCache    = "X-CC-Cache"
+	HeaderFallback = "X-CC-Fallback"
+	HeaderPriority = "X-CC-Priority"
+	HeaderApplied  = "X-CC-Applied"
+)
+
+// ContextKey is the gin context key holding the negotiated Features.
+const ContextKey = "ccFeatures"
+
+// Feature names used in permission lists
+const (
+	CacheBypass  = "cache-bypass"
+	FallbackOff  = "fallback-off"
+	PriorityLow  = "priority-low"
+	PriorityHigh = "priority-high"
+)
+
+// Request priorities
+const (
+	PriorityNormal = ""
+	Low            = "low"
+	High           = "high"
+)
+
+// defaultAllowed applies when request-features.allowed is empty. High priority
+// jumps queues shared with other keys, so it must be granted explicitly.
+var defaultAllowed = []string{CacheBypass, FallbackOff, PriorityLow}
+
+var negotiated = metrics.Default().NewCounterVec(
+	"cliproxy_request_features_total",
+	"Optional features requested with X-CC-* headers, by feature and outcome (applied or denied).",
+	"feature", "outcome",
+)
+
+// Features are the optional behaviours negotiated for one request.
+type Features struct {
+	// CacheBypass routes the request without session affinity.
+	CacheBypass bool
+	// FallbackOff fails the request instead of failing over to another credential or provider.
+	FallbackOff bool
+	// Priority is Low, High or PriorityNormal.
+	Priority string
+}
+
+// names lists the permission names of the requested features.
+func (f Features) names() []string {
+	var out []string
+	if f.CacheBypass {
+		out = append(out, CacheBypass)
+	}
+	if f.FallbackOff {
+		out = append(out, FallbackOff)
+	}
+	switch f.Priority {
+	case Low:
+		out = append(out, PriorityLow)
+	case High:
+		out = append(out, PriorityHigh)
+	}
+	return out
+}
+
+// applied renders the features for the X-CC-Applied header.
+func (f Features) applied() string {
+	var parts []string
+	if f.CacheBypass {
+		parts = append(parts, "cache=bypass")
+	}
+	if f.FallbackOff {
+		parts = append(parts, "fallback=off")
+	}
+	if f.Priority != PriorityNormal {
+		parts = append(parts, "priority="+f.Priority)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Parse reads the X-CC-* headers. It returns the header and value of the first
+// unsupported value as a message.
+func Parse(h http.Header) (Features, string) {
+	var f Features
+	switch v := headerValue(h, HeaderCache); v {
+	case "", "default":
+	case "bypass":
+		f.CacheBypass = true
+	default:
+		return f, HeaderCache + ": unsupported value " + v + " (want bypass or default)"
+	}
+	switch v := headerValue(h, HeaderFallback); v {
+	case "", "on":
+	case "off":
+		f.FallbackOff = true
+	default:
+		return f, HeaderFallback + ": unsupported value " + v + " (want on or off)"
+	}
+	switch v := headerValue(h, HeaderPriority); v {
+	case "", "normal":
+	case Low, High:
+		f.Priority = v
+	default:
+		return f, HeaderPriority + ": unsupported value " + v + " (want low, normal or high)"
+	}
+	return f, ""
+}
+
+func headerValue(h http.Header, name string) string {
+	return strings.ToLower(strings.TrimSpace(h.Get(name)))
+}
+
+// Negotiator checks requested features against key permissions.
+type Negotiator struct {
+	mu      sync.RWMutex
+	enabled bool
+	allowed map[string]bool
+	keys    map[string]map[string]bool
+}
+
+// New creates a negotiator from configuration.
+func New(cfg config.RequestFeaturesConfig) *Negotiator {
+	n := &Negotiator{}
+	n.Update(cfg)
+	return n
+}
+
+// Update replaces the configuration.
+func (n *Negotiator) Update(cfg config.RequestFeaturesConfig) {
+	allowed := cfg.Allowed
+	if len(allowed) == 0 {
+		allowed = defaultAllowed
+	}
+	keys := make(map[string]map[string]bool, len(cfg.Keys))
+	for key, features := range cfg.Keys {
+		keys[key] = featureSet(features)
+	}
+	n.mu.Lock()
+	n.enabled = cfg.Enabled
+	n.allowed = featureSet(allowed)
+	n.keys = keys
+	n.mu.Unlock()
+}
+
+func featureSet(features []string) map[string]bool {
+	set := make(map[string]bool, len(features))
+	for _, feature := range features {
+		set[strings.ToLower(strings.TrimSpace(feature))] = true
+	}
+	return set
+}
+
+// Permitted reports whether apiKey may request feature.
+func (n *Negotiator) Permitted(apiKey, feature string) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if set, ok := n.keys[apiKey]; ok {
+		return set[feature]
+	}
+	return n.allowed[feature]
+}
+
+// Middleware negotiates the X-CC-* headers of a request. Unsupported values are
+// rejected with 400 and features the key may not use with 403. It must run
+// after authentication so per-key permissions apply.
+func (n *Negotiator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n.mu.RLock()
+		enabled := n.enabled
+		n.mu.RUnlock()
+		if !enabled {
+			c.Next()
+			return
+		}
+		features, invalid := Parse(c.Request.Header)
+		if invalid != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_feature_header", "message": invalid})
+			return
+		}
+		apiKey := c.GetString("apiKey")
+		for _, feature := range features.names() {
+			if !n.Permitted(apiKey, feature) {
+				negotiated.Inc(feature, "denied")
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error":   "feature_not_permitted",
+					"message": "This API key may not request " + feature,
+				})
+				return
+			}
+		}
+		for _, feature := range features.names() {
+			negotiated.Inc(feature, "applied")
+		}
+		if applied := features.applied(); applied != "" {
+			c.Set(ContextKey, features)
+			c.Header(HeaderApplied, applied)
+		}
+		c.Next()
+	}
+}
+
+// FromContext returns the features negotiated for the request carried by ctx.
+func FromContext(ctx context.Context) Features {
+	if ctx == nil {
+		return Features{}
+	}
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil {
+		return Features{}
+	}
+	features, _ := ginCtx.Value(ContextKey).(Features)
+	return features
+}
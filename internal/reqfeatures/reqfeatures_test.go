@@ -0,0 +1,66 @@
+package reqfeatures
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func TestParse(t *testing.T) {
+	h := http.Header{}
+	h.Set(HeaderCache, "Bypass")
+	h.Set(HeaderFallback, "off")
+	h.Set(HeaderPriority, " low ")
+	f, invalid := Parse(h)
+	if invalid != "" || !f.CacheBypass || !f.FallbackOff || f.Priority != Low {
+		t.Fatalf("Parse() = %+v, %q", f, invalid)
+	}
+
+	h.Set(HeaderPriority, "urgent")
+	if _, invalid = Parse(h); invalid == "" {
+		t.Fatalf("expected an unsupported priority to be reported")
+	}
+}
+
+func TestMiddlewareEnforcesKeyPermissions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	n := New(config.RequestFeaturesConfig{
+		Enabled: true,
+		Keys:    map[string][]string{"vip": {PriorityHigh}},
+	})
+	var got Features
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) { c.Set("apiKey", c.GetHeader("Authorization")) }, n.Middleware())
+	engine.POST("/v1/messages", func(c *gin.Context) {
+		got = FromContext(context.WithValue(context.Background(), "gin", c))
+	})
+	send := func(key, header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.Header.Set("Authorization", key)
+		req.Header.Set(header, value)
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("plain", HeaderPriority, "high"); rec.Code != http.StatusForbidden {
+		t.Fatalf("high priority without permission: status %d, want 403", rec.Code)
+	}
+	if rec := send("plain", HeaderFallback, "maybe"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unsupported value: status %d, want 400", rec.Code)
+	}
+	rec := send("plain", HeaderCache, "bypass")
+	if rec.Code != http.StatusOK || !got.CacheBypass || rec.Header().Get(HeaderApplied) != "cache=bypass" {
+		t.Fatalf("default permissions: status %d, features %+v, applied %q", rec.Code, got, rec.Header().Get(HeaderApplied))
+	}
+	if rec = send("vip", HeaderPriority, "high"); rec.Code != http.StatusOK || got.Priority != High {
+		t.Fatalf("vip high priority: status %d, features %+v", rec.Code, got)
+	}
+	if rec = send("vip", HeaderCache, "bypass"); rec.Code != http.StatusForbidden {
+		t.Fatalf("per-key list should replace the defaults: status %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,134 @@
+// Package requestclass tells fresh prompts apart from the follow-up traffic of
+// agent loops. Tool-result continuations and heartbeats are cheap for the user
+// to send in rapid succession, so limits and schedulers can treat them
+// differently from a new conversation turn.
+package requestclass
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// ContextKey is the gin context key holding the request class (string).
+const ContextKey = "requestClass"
+
+// Request classes
+const (
+	// New is a fresh prompt: a new conversation or a new user turn.
+	New = "new"
+	// Continuation returns tool results to the model within an agent loop.
+	Continuation = "continuation"
+	// Heartbeat is a lightweight probe: token counting, model listing or a
+	// request generating at most one token.
+	Heartbeat = "heartbeat"
+)
+
+// Classify returns the class of a request from its method, path and JSON body.
+// It understands Claude Messages, OpenAI Chat Completions and Responses, and
+// Gemini generateContent payloads.
+func Classify(method, path string, body []byte) string {
+	if method == http.MethodGet || strings.HasSuffix(path, "/count_tokens") || strings.HasSuffix(path, ":countTokens") {
+		return Heartbeat
+	}
+	if !gjson.ValidBytes(body) {
+		return New
+	}
+	for _, field := range []string{"max_tokens", "max_completion_tokens", "max_output_tokens", "generationConfig.maxOutputTokens"} {
+		if limit := gjson.GetBytes(body, field); limit.Exists() && limit.Int() == 1 {
+			return Heartbeat
+		}
+	}
+	if isContinuation(body) {
+		return Continuation
+	}
+	return New
+}
+
+// isContinuation reports whether the last turn of the payload carries tool
+// results rather than new user input.
+func isContinuation(body []byte) bool {
+	if messages := gjson.GetBytes(body, "messages"); messages.IsArray() {
+		last := lastElement(messages)
+		switch last.Get("role").String() {
+		case "tool", "function":
+			// OpenAI Chat Completions
+			return true
+		case "user":
+			// Claude Messages: tool results arrive as user content blocks.
+			onlyResults := false
+			for _, block := range last.Get("content").Array() {
+				switch block.Get("type").String() {
+				case "tool_result":
+					onlyResults = true
+				case "text":
+					if strings.TrimSpace(block.Get("text").String()) != "" {
+						return false
+					}
+				}
+			}
+			return onlyResults
+		}
+		return false
+	}
+	if input := gjson.GetBytes(body, "input"); input.IsArray() {
+		// OpenAI Responses
+		itemType := lastElement(input).Get("type").String()
+		return itemType == "function_call_output" || itemType == "custom_tool_call_output"
+	}
+	if contents := gjson.GetBytes(body, "contents"); contents.IsArray() {
+		// Gemini
+		parts := lastElement(contents).Get("parts").Array()
+		if len(parts) == 0 {
+			return false
+		}
+		for _, part := range parts {
+			if !part.Get("functionResponse").Exists() {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func lastElement(array gjson.Result) gjson.Result {
+	elements := array.Array()
+	if len(elements) == 0 {
+		return gjson.Result{}
+	}
+	return elements[len(elements)-1]
+}
+
+// Middleware classifies each request and stores the class under ContextKey.
+// The request body is buffered and restored for downstream handlers.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body []byte
+		if c.Request.Body != nil && c.Request.Method != http.MethodGet {
+			data, err := io.ReadAll(c.Request.Body)
+			_ = c.Request.Body.Close()
+			c.Request.Body = io.NopCloser(bytes.NewReader(data))
+			if err == nil {
+				body = data
+			}
+		}
+		c.Set(ContextKey, Classify(c.Request.Method, c.Request.URL.Path, body))
+		c.Next()
+	}
+}
+
+// FromContext returns the class stored by Middleware, defaulting to New.
+func FromContext(c *gin.Context) string {
+	if c == nil {
+		return New
+	}
+	if class := c.GetString(ContextKey); class != "" {
+		return class
+	}
+	return New
+}
@@ -0,0 +1,28 @@
+package requestclass
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		name, path, body, want string
+	}{
+		{"claude prompt", "/v1/messages", `{"max_tokens":1024,"messages":[{"role":"user","content":"hi"}]}`, New},
+		{"claude tool result", "/v1/messages", `{"max_tokens":1024,"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":[{"type":"tool_use","id":"t1"}]},{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"ok"}]}]}`, Continuation},
+		{"claude tool result with new text", "/v1/messages", `{"messages":[{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1"},{"type":"text","text":"also do this"}]}]}`, New},
+		{"openai tool message", "/v1/chat/completions", `{"messages":[{"role":"user","content":"hi"},{"role":"tool","tool_call_id":"c1","content":"42"}]}`, Continuation},
+		{"responses function output", "/v1/responses", `{"input":[{"type":"function_call_output","call_id":"c1","output":"42"}]}`, Continuation},
+		{"gemini function response", "/v1beta/models/gemini:generateContent", `{"contents":[{"role":"user","parts":[{"functionResponse":{"name":"f"}}]}]}`, Continuation},
+		{"quota probe", "/v1/messages", `{"max_tokens":1,"messages":[{"role":"user","content":"quota"}]}`, Heartbeat},
+		{"count tokens", "/v1/messages/count_tokens", `{"messages":[]}`, Heartbeat},
+	} {
+		if got := Classify(http.MethodPost, tc.path, []byte(tc.body)); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+	if got := Classify(http.MethodGet, "/v1/models", nil); got != Heartbeat {
+		t.Errorf("model listing: got %s", got)
+	}
+}
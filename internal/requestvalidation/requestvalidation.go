@@ -0,0 +1,356 @@
+// Package requestvalidation rejects oversized and malformed request bodies at
+// the edge of the proxy, so clients get an actionable 400 naming the offending
+// field instead of an opaque upstream error after a wasted round trip.
+package requestvalidation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/tidwall/gjson"
+)
+
+const defaultMaxBodyMB = 32
+
+// Violation describes why a request body was rejected.
+type Violation struct {
+	// Param is the JSON path of the offending field, empty for the body as a whole.
+	Param   string
+	Message string
+}
+
+func (v *Violation) Error() string {
+	if v.Param == "" {
+		return v.Message
+	}
+	return v.Param + ": " + v.Message
+}
+
+func violation(param, format string, args ...any) *Violation {
+	return &Violation{Param: param, Message: fmt.Sprintf(format, args...)}
+}
+
+// Validator enforces the configured body size limit and schema checks.
+type Validator struct {
+	mu      sync.RWMutex
+	enabled bool
+	maxBody int64
+	schema  bool
+}
+
+// New creates a validator from configuration.
+func New(cfg config.RequestValidationConfig) *Validator {
+	v := &Validator{}
+	v.Update(cfg)
+	return v
+}
+
+// Update replaces the configuration.
+func (v *Validator) Update(cfg config.RequestValidationConfig) {
+	maxMB := cfg.MaxBodyMB
+	if maxMB <= 0 {
+		maxMB = defaultMaxBodyMB
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.enabled = cfg.Enabled
+	v.maxBody = int64(maxMB) << 20
+	v.schema = cfg.ValidateSchema
+}
+
+func (v *Validator) settings() (bool, int64, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.enabled, v.maxBody, v.schema
+}
+
+// Middleware rejects bodies over the size limit with 413 and, when schema
+// validation is on, bodies that do not match the endpoint's API with 400.
+// The body is buffered and restored for downstream handlers.
+func (v *Validator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		enabled, maxBody, schema := v.settings()
+		if !enabled || c.Request.Method == http.MethodGet || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > maxBody {
+			abortTooLarge(c, maxBody)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBody+1))
+		_ = c.Request.Body.Close()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_request",
+				"message": "Failed to read request body: " + err.Error(),
+			})
+			return
+		}
+		if int64(len(body)) > maxBody {
+			abortTooLarge(c, maxBody)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		if schema {
+			if bad := Validate(c.Request.URL.Path, body); bad != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"error":   "invalid_request",
+					"message": "Invalid request body: " + bad.Error(),
+					"param":   bad.Param,
+				})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+func abortTooLarge(c *gin.Context, maxBody int64) {
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+		"error":     "request_too_large",
+		"message":   "Request body exceeds the limit of " + strconv.FormatInt(maxBody>>20, 10) + " MiB; shorten the conversation or send large files by reference",
+		"max_bytes": maxBody,
+	})
+}
+
+// Validate checks a request body against the API served at path. Paths without
+// a known schema only need a well-formed JSON object.
+func Validate(path string, body []byte) *Violation {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return violation("", "request body is required")
+	}
+	if !gjson.ValidBytes(body) {
+		return violation("", "body is not valid JSON")
+	}
+	root := gjson.ParseBytes(body)
+	if !root.IsObject() {
+		return violation("", "body must be a JSON object")
+	}
+	switch {
+	case strings.HasSuffix(path, "/messages/count_tokens"):
+		return validateClaudeMessages(root, true)
+	case strings.HasSuffix(path, "/messages"):
+		return validateClaudeMessages(root, false)
+	case strings.HasSuffix(path, "/chat/completions"):
+		return validateChatCompletions(root)
+	case strings.HasSuffix(path, "/completions"):
+		return validateCompletions(root)
+	case strings.HasSuffix(path, "/responses"):
+		return validateResponses(root)
+	}
+	return nil
+}
+
+var (
+	claudeRoles = []string{"user", "assistant"}
+	openAIRoles = []string{"system", "developer", "user", "assistant", "tool", "function"}
+)
+
+func validateClaudeMessages(root gjson.Result, countTokens bool) *Violation {
+	if bad := requireString(root, "model"); bad != nil {
+		return bad
+	}
+	messages, bad := requireMessages(root)
+	if bad != nil {
+		return bad
+	}
+	for i, message := range messages {
+		param := "messages." + strconv.Itoa(i)
+		if !message.IsObject() {
+			return violation(param, "must be an object")
+		}
+		role := message.Get("role")
+		if role.String() == "system" {
+			return violation(param+".role", `"system" is not a message role; put the system prompt in the top-level "system" field`)
+		}
+		if bad = oneOf(message, param+".role", "role", claudeRoles); bad != nil {
+			return bad
+		}
+		if bad = contentBlocks(message.Get("content"), param+".content", false); bad != nil {
+			return bad
+		}
+	}
+	if system := root.Get("system"); system.Exists() {
+		if bad = contentBlocks(system, "system", false); bad != nil {
+			return bad
+		}
+	}
+	if !countTokens {
+		if bad = optionalPositiveInt(root, "max_tokens"); bad != nil {
+			return bad
+		}
+	}
+	return firstViolation(
+		optionalType(root, "stream", "a boolean", isBool),
+		optionalType(root, "temperature", "a number", isNumber),
+		optionalType(root, "top_p", "a number", isNumber),
+		optionalType(root, "tools", "an array", gjson.Result.IsArray),
+		optionalType(root, "stop_sequences", "an array of strings", isStringArray),
+		optionalType(root, "metadata", "an object", gjson.Result.IsObject),
+	)
+}
+
+func validateChatCompletions(root gjson.Result) *Violation {
+	if bad := requireString(root, "model"); bad != nil {
+		return bad
+	}
+	messages, bad := requireMessages(root)
+	if bad != nil {
+		return bad
+	}
+	for i, message := range messages {
+		param := "messages." + strconv.Itoa(i)
+		if !message.IsObject() {
+			return violation(param, "must be an object")
+		}
+		if bad = oneOf(message, param+".role", "role", openAIRoles); bad != nil {
+			return bad
+		}
+		// Assistant messages carrying only tool calls have null content.
+		if content := message.Get("content"); content.Exists() && content.Type != gjson.Null {
+			if bad = contentBlocks(content, param+".content", true); bad != nil {
+				return bad
+			}
+		}
+	}
+	return firstViolation(
+		optionalPositiveInt(root, "max_tokens"),
+		optionalPositiveInt(root, "max_completion_tokens"),
+		optionalPositiveInt(root, "n"),
+		optionalType(root, "stream", "a boolean", isBool),
+		optionalType(root, "temperature", "a number", isNumber),
+		optionalType(root, "top_p", "a number", isNumber),
+		optionalType(root, "tools", "an array", gjson.Result.IsArray),
+	)
+}
+
+func validateCompletions(root gjson.Result) *Violation {
+	if bad := requireString(root, "model"); bad != nil {
+		return bad
+	}
+	prompt := root.Get("prompt")
+	if !prompt.Exists() || (prompt.Type != gjson.String && !prompt.IsArray()) {
+		return violation("prompt", "is required and must be a string or an array")
+	}
+	return firstViolation(
+		optionalPositiveInt(root, "max_tokens"),
+		optionalType(root, "stream", "a boolean", isBool),
+		optionalType(root, "temperature", "a number", isNumber),
+	)
+}
+
+func validateResponses(root gjson.Result) *Violation {
+	if bad := requireString(root, "model"); bad != nil {
+		return bad
+	}
+	return firstViolation(
+		optionalType(root, "input", "a string or an array", func(r gjson.Result) bool { return r.Type == gjson.String || r.IsArray() }),
+		optionalType(root, "instructions", "a string", isString),
+		optionalPositiveInt(root, "max_output_tokens"),
+		optionalType(root, "stream", "a boolean", isBool),
+		optionalType(root, "temperature", "a number", isNumber),
+		optionalType(root, "tools", "an array", gjson.Result.IsArray),
+	)
+}
+
+// requireMessages returns the non-empty "messages" array.
+func requireMessages(root gjson.Result) ([]gjson.Result, *Violation) {
+	messages := root.Get("messages")
+	if !messages.IsArray() {
+		return nil, violation("messages", "is required and must be an array")
+	}
+	items := messages.Array()
+	if len(items) == 0 {
+		return nil, violation("messages", "must contain at least one message")
+	}
+	return items, nil
+}
+
+// contentBlocks checks message content: a string or an array of typed blocks.
+// OpenAI also accepts bare strings inside the array.
+func contentBlocks(content gjson.Result, param string, allowStrings bool) *Violation {
+	if content.Type == gjson.String {
+		return nil
+	}
+	if !content.IsArray() {
+		return violation(param, "must be a string or an array of content blocks")
+	}
+	for i, block := range content.Array() {
+		if allowStrings && block.Type == gjson.String {
+			continue
+		}
+		blockParam := param + "." + strconv.Itoa(i)
+		if !block.IsObject() {
+			return violation(blockParam, "must be a content block object")
+		}
+		if bad := requireString(block, "type"); bad != nil {
+			return violation(blockParam+".type", "is required and must be a string")
+		}
+	}
+	return nil
+}
+
+func requireString(obj gjson.Result, field string) *Violation {
+	if value := obj.Get(field); value.Type != gjson.String || strings.TrimSpace(value.Str) == "" {
+		return violation(field, "is required and must be a non-empty string")
+	}
+	return nil
+}
+
+func oneOf(obj gjson.Result, param, field string, allowed []string) *Violation {
+	value := obj.Get(field)
+	if value.Type == gjson.String {
+		for _, a := range allowed {
+			if value.Str == a {
+				return nil
+			}
+		}
+	}
+	return violation(param, "must be one of %s", strings.Join(allowed, ", "))
+}
+
+func optionalType(obj gjson.Result, field, want string, ok func(gjson.Result) bool) *Violation {
+	if value := obj.Get(field); value.Exists() && value.Type != gjson.Null && !ok(value) {
+		return violation(field, "must be %s", want)
+	}
+	return nil
+}
+
+func optionalPositiveInt(obj gjson.Result, field string) *Violation {
+	return optionalType(obj, field, "a positive integer", func(r gjson.Result) bool {
+		return r.Type == gjson.Number && r.Num >= 1 && r.Num == float64(int64(r.Num))
+	})
+}
+
+func firstViolation(violations ...*Violation) *Violation {
+	for _, v := range violations {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+func isBool(r gjson.Result) bool   { return r.Type == gjson.True || r.Type == gjson.False }
+func isNumber(r gjson.Result) bool { return r.Type == gjson.Number }
+func isString(r gjson.Result) bool { return r.Type == gjson.String }
+
+func isStringArray(r gjson.Result) bool {
+	if !r.IsArray() {
+		return false
+	}
+	for _, item := range r.Array() {
+		if item.Type != gjson.String {
+			return false
+		}
+	}
+	return true
+}
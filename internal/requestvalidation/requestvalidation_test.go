@@ -0,0 +1,77 @@
+package requestvalidation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		path, body, param string
+		ok                bool
+	}{
+		{"/v1/messages", `{"model":"claude","max_tokens":1024,"messages":[{"role":"user","content":[{"type":"text","text":"hi"}]}]}`, "", true},
+		{"/v1/messages", `{"model":"claude","messages":[{"role":"system","content":"be brief"}]}`, "messages.0.role", false},
+		{"/v1/messages", `{"model":"claude","max_tokens":0,"messages":[{"role":"user","content":"hi"}]}`, "max_tokens", false},
+		{"/v1/messages", `{"model":"claude","messages":[{"role":"user","content":[{"text":"hi"}]}]}`, "messages.0.content.0.type", false},
+		{"/v1/messages/count_tokens", `{"model":"claude","messages":[{"role":"user","content":"hi"}]}`, "", true},
+		{"/v1/messages", `{"messages":[]}`, "model", false},
+		{"/v1/chat/completions", `{"model":"gpt","messages":[{"role":"assistant","content":null,"tool_calls":[]},{"role":"tool","content":"42"}]}`, "", true},
+		{"/v1/chat/completions", `{"model":"gpt","messages":[]}`, "messages", false},
+		{"/v1/chat/completions", `{"model":"gpt","messages":[{"role":"user","content":"hi"}],"stream":"yes"}`, "stream", false},
+		{"/v1/completions", `{"model":"gpt"}`, "prompt", false},
+		{"/v1/responses", `{"model":"gpt","input":"hi","max_output_tokens":1.5}`, "max_output_tokens", false},
+		{"/v1beta/models/gemini:generateContent", `{"contents":[]}`, "", true},
+		{"/v1beta/models/gemini:generateContent", `[1,2]`, "", false},
+		{"/v1/messages", `{"model":`, "", false},
+	}
+	for _, tc := range cases {
+		bad := Validate(tc.path, []byte(tc.body))
+		if tc.ok != (bad == nil) || (bad != nil && bad.Param != tc.param) {
+			t.Errorf("Validate(%s, %s) = %v, want ok=%v param=%q", tc.path, tc.body, bad, tc.ok, tc.param)
+		}
+	}
+}
+
+func TestMiddlewareRejectsOversizedAndInvalidBodies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	v := New(config.RequestValidationConfig{Enabled: true, MaxBodyMB: 1, ValidateSchema: true})
+	engine := gin.New()
+	engine.Use(v.Middleware())
+	engine.POST("/v1/messages", func(c *gin.Context) {
+		var body map[string]any
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.Status(http.StatusTeapot)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := post(`{"model":"claude","messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected a valid body to reach the handler intact, got %d", rec.Code)
+	}
+	rec := post(`{"model":"claude","messages":"hi"}`)
+	var resp struct{ Error, Message, Param string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusBadRequest || resp.Param != "messages" {
+		t.Fatalf("expected a 400 naming messages, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = post(`{"model":"` + strings.Repeat("a", 1<<20) + `"}`); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for an oversized body, got %d", rec.Code)
+	}
+
+	v.Update(config.RequestValidationConfig{})
+	if rec = post(`{"model":"claude","messages":"hi"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected no checks while disabled, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,139 @@
+package responsepolicy
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// minLetters is how many letters a response needs before its language is judged.
+const minLetters = 40
+
+// languageNames are used in the injected instruction.
+var languageNames = map[string]string{
+	"ar": "Arabic", "de": "German", "el": "Greek", "en": "English", "es": "Spanish",
+	"fr": "French", "he": "Hebrew", "hi": "Hindi", "id": "Indonesian", "it": "Italian",
+	"ja": "Japanese", "ko": "Korean", "nl": "Dutch", "pt": "Portuguese", "ru": "Russian",
+	"th": "Thai", "vi": "Vietnamese", "zh": "Chinese",
+}
+
+// stopwords tell apart the Latin-script languages.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "of", "to", "in", "that", "it", "for", "with", "you", "this", "be", "not", "on", "was", "have"},
+	"es": {"el", "los", "las", "que", "y", "es", "por", "para", "con", "una", "se", "del", "como", "más", "pero", "está"},
+	"fr": {"le", "les", "des", "et", "est", "un", "une", "pour", "pas", "dans", "sur", "vous", "avec", "qui", "du", "ce"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "zu", "den", "mit", "sich", "auf", "für", "von", "auch"},
+	"pt": {"os", "que", "e", "é", "em", "um", "uma", "para", "com", "não", "do", "da", "por", "mais", "você", "são"},
+	"it": {"il", "lo", "di", "che", "è", "per", "non", "con", "sono", "della", "gli", "si", "questo", "anche"},
+	"nl": {"het", "een", "en", "van", "is", "dat", "niet", "op", "te", "zijn", "voor", "met", "die", "ook", "er"},
+	"id": {"yang", "dan", "di", "ini", "itu", "dengan", "untuk", "dari", "tidak", "ada", "ke", "akan", "dalam", "saya", "anda"},
+}
+
+// vietnameseLetters only occur in Vietnamese among the supported languages.
+const vietnameseLetters = "ăâđêôơưạảấầẩẫậắằẳẵặẹẻẽếềểễệỉịọỏốồổỗộớờởỡợụủứừửữựỳỵỷỹ"
+
+var (
+	codeFence = regexp.MustCompile("(?m)^\\s*```")
+	fenced    = regexp.MustCompile("(?s)```.*?(?:```|$)")
+	codeLine  = regexp.MustCompile(`(?m)^\s*(?:def \w+\(|class \w+\s*[:({]|function \w*\s*\(|func (?:\(\w+ \*?\w+\) )?\w+\(|#include\s*[<"]|import [\w.{"']|from [\w.]+ import |package \w+;?$|public (?:static |class |void )|(?:const|let|var) \w+\s*=|SELECT .+ FROM )`)
+)
+
+// containsCode reports whether text holds a fenced code block or at least two
+// lines that look like source code.
+func containsCode(text string) bool {
+	return codeFence.MatchString(text) || len(codeLine.FindAllStringIndex(text, 2)) >= 2
+}
+
+// normalizeLanguage reduces a language tag like "pt-BR" to its ISO 639-1 code.
+func normalizeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	return language
+}
+
+// detectLanguage returns the ISO 639-1 code of the dominant language of text,
+// ignoring fenced code, or "" when there is too little text to tell.
+func detectLanguage(text string) string {
+	text = fenced.ReplaceAllString(text, " ")
+	var letters, latin, kana, han, hangul, vietnamese int
+	scripts := map[string]int{}
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+			if strings.ContainsRune(vietnameseLetters, unicode.ToLower(r)) {
+				vietnamese++
+			}
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["ru"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Thai, r):
+			scripts["th"]++
+		case unicode.Is(unicode.Devanagari, r):
+			scripts["hi"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		}
+	}
+	if letters < minLetters {
+		return ""
+	}
+	switch {
+	case kana*10 >= letters:
+		return "ja"
+	case hangul*2 >= letters:
+		return "ko"
+	case han*2 >= letters:
+		return "zh"
+	}
+	for language, count := range scripts {
+		if count*2 >= letters {
+			return language
+		}
+	}
+	if latin*2 < letters {
+		return ""
+	}
+	if vietnamese*100 >= latin*3 {
+		return "vi"
+	}
+	hits := map[string]int{}
+	words := 0
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		words++
+		for language, list := range stopwords {
+			for _, stopword := range list {
+				if word == stopword {
+					hits[language]++
+					break
+				}
+			}
+		}
+	}
+	best, bestHits := "", 0
+	for language, count := range hits {
+		if count > bestHits || (count == bestHits && language < best) {
+			best, bestHits = language, count
+		}
+	}
+	// Require stopwords in at least one word of twenty so lists, names and jargon are not judged.
+	if bestHits < 2 || bestHits*20 < words {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,419 @@
+// Package responsepolicy enforces per key group requirements on completions:
+// the language they are written in and whether they may contain code. Each
+// policy injects an instruction into the system prompt and checks the response
+// text afterwards, logging violations and optionally blocking them.
+package responsepolicy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// MetadataKey is the key metadata attribute naming the response policy of an API key.
+const MetadataKey = "response-policy"
+
+const (
+	defaultPolicy = "default"
+	actionBlock   = "block"
+	actionLog     = "log"
+
+	// Violation kinds
+	kindLanguage = "language"
+	kindCode     = "code"
+
+	// checkEvery is how much streamed text accumulates between checks of a blocking policy.
+	checkEvery = 256
+)
+
+// textFields are the JSON fields carrying generated text in the supported response formats.
+var textFields = map[string]bool{"text": true, "content": true, "delta": true, "output_text": true}
+
+var violations = metrics.Default().NewCounterVec(
+	"cliproxy_response_policy_violations_total",
+	"Responses violating a response policy, by policy, kind (language or code) and action (log or block).",
+	"policy", "kind", "action",
+)
+
+type policy struct {
+	name        string
+	language    string
+	forbidCode  bool
+	block       bool
+	instruction string
+}
+
+// violation describes why text breaks the policy, or returns "" when it does not.
+func (p *policy) violation(text string, final bool) (kind, detail string) {
+	if p.forbidCode && containsCode(text) {
+		return kindCode, "response contains code"
+	}
+	if p.language == "" {
+		return "", ""
+	}
+	// A streamed response is only judged on its language once it is complete
+	// or long enough for the first sentences not to decide it.
+	if !final && len(text) < 4*checkEvery {
+		return "", ""
+	}
+	if detected := detectLanguage(text); detected != "" && detected != p.language {
+		return kindLanguage, "response is in " + detected + ", expected " + p.language
+	}
+	return "", ""
+}
+
+// Enforcer resolves API keys to policies and applies them.
+type Enforcer struct {
+	mu      sync.RWMutex
+	enabled bool
+	byKey   map[string]*policy
+	byName  map[string]*policy
+}
+
+// New creates an enforcer from configuration.
+func New(cfg config.ResponsePolicyConfig) *Enforcer {
+	e := &Enforcer{}
+	e.Update(cfg)
+	return e
+}
+
+// Update replaces the configuration. Policies with an unknown action are logged and skipped.
+func (e *Enforcer) Update(cfg config.ResponsePolicyConfig) {
+	byKey := make(map[string]*policy)
+	byName := make(map[string]*policy, len(cfg.Policies))
+	for _, pc := range cfg.Policies {
+		name := strings.TrimSpace(pc.Name)
+		p := &policy{
+			name:        name,
+			language:    normalizeLanguage(pc.Language),
+			forbidCode:  pc.ForbidCode,
+			instruction: strings.TrimSpace(pc.Instruction),
+		}
+		switch strings.ToLower(strings.TrimSpace(pc.Action)) {
+		case "", actionLog:
+		case actionBlock:
+			p.block = true
+		default:
+			log.Warnf("response-policy: ignoring policy %q: unknown action %q", name, pc.Action)
+			continue
+		}
+		if p.instruction == "" {
+			p.instruction = defaultInstruction(p.language, p.forbidCode)
+		}
+		if name != "" {
+			byName[strings.ToLower(name)] = p
+		}
+		for _, key := range pc.APIKeys {
+			if key = strings.TrimSpace(key); key != "" {
+				byKey[key] = p
+			}
+		}
+	}
+	e.mu.Lock()
+	e.enabled = cfg.Enabled
+	e.byKey = byKey
+	e.byName = byName
+	e.mu.Unlock()
+}
+
+func defaultInstruction(language string, forbidCode bool) string {
+	var parts []string
+	if language != "" {
+		name := languageNames[language]
+		if name == "" {
+			name = language
+		}
+		parts = append(parts, "Always write your responses in "+name+", whatever the language of the request.")
+	}
+	if forbidCode {
+		parts = append(parts, "Do not include source code, code blocks or shell commands in your responses.")
+	}
+	return strings.Join(parts, " ")
+}
+
+// resolve returns the policy of an API key: an explicit key list wins over the
+// "response-policy" metadata attribute, and the "default" policy covers the rest.
+func (e *Enforcer) resolve(apiKey string, metadata map[string]string) *policy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if !e.enabled || apiKey == "" {
+		return nil
+	}
+	if p, ok := e.byKey[apiKey]; ok {
+		return p
+	}
+	if name := strings.ToLower(strings.TrimSpace(metadata[MetadataKey])); name != "" {
+		if p, ok := e.byName[name]; ok {
+			return p
+		}
+	}
+	return e.byName[defaultPolicy]
+}
+
+// Middleware injects the policy instruction into the request and checks the
+// response. Blocking policies hold back non-streaming responses until they are
+// checked; a stream found in violation is ended with an SSE error event. It
+// must run after authentication and device binding so the key's policy can be
+// resolved.
+func (e *Enforcer) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := c.GetString("apiKey")
+		metadata, _ := c.Value(device.MetadataContextKey).(map[string]string)
+		p := e.resolve(apiKey, metadata)
+		if p == nil || (p.language == "" && !p.forbidCode) || c.Request.Method != http.MethodPost || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		_ = c.Request.Body.Close()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_request",
+				"message": "Failed to read request body: " + err.Error(),
+			})
+			return
+		}
+		body = injectInstruction(c.Request.URL.Path, body, p.instruction)
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Request.ContentLength = int64(len(body))
+		c.Request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+		w := &policyWriter{ResponseWriter: c.Writer, policy: p, apiKey: apiKey, status: http.StatusOK}
+		c.Writer = w
+		c.Next()
+		w.finish()
+	}
+}
+
+// injectInstruction adds instruction to the system prompt of an OpenAI chat,
+// OpenAI Responses, Claude Messages or Gemini request. Other bodies are
+// returned unchanged.
+func injectInstruction(path string, body []byte, instruction string) []byte {
+	if !gjson.ValidBytes(body) {
+		return body
+	}
+	var out []byte
+	var err error
+	switch {
+	case strings.HasSuffix(path, "/chat/completions"):
+		system, _ := json.Marshal(map[string]string{"role": "system", "content": instruction})
+		out, err = prependRaw(body, "messages", system)
+	case strings.HasSuffix(path, "/messages"):
+		switch existing := gjson.GetBytes(body, "system"); {
+		case existing.IsArray():
+			block, _ := json.Marshal(map[string]string{"type": "text", "text": instruction})
+			out, err = prependRaw(body, "system", block)
+		case existing.Type == gjson.String && existing.Str != "":
+			out, err = sjson.SetBytes(body, "system", instruction+"\n\n"+existing.Str)
+		default:
+			out, err = sjson.SetBytes(body, "system", instruction)
+		}
+	case strings.HasSuffix(path, "/responses"):
+		if existing := gjson.GetBytes(body, "instructions").Str; existing != "" {
+			instruction += "\n\n" + existing
+		}
+		out, err = sjson.SetBytes(body, "instructions", instruction)
+	case strings.Contains(path, ":generateContent") || strings.Contains(path, ":streamGenerateContent"):
+		field := "systemInstruction"
+		if gjson.GetBytes(body, "system_instruction").Exists() {
+			field = "system_instruction"
+		}
+		part, _ := json.Marshal(map[string]string{"text": instruction})
+		out, err = prependRaw(body, field+".parts", part)
+	default:
+		return body
+	}
+	if err != nil {
+		log.Debugf("response-policy: failed to inject instruction: %v", err)
+		return body
+	}
+	return out
+}
+
+// prependRaw inserts a raw JSON value at the start of the array at path,
+// creating the array when it does not exist.
+func prependRaw(body []byte, path string, value []byte) ([]byte, error) {
+	existing := gjson.GetBytes(body, path)
+	if !existing.IsArray() {
+		return sjson.SetRawBytes(body, path, append(append([]byte{'['}, value...), ']'))
+	}
+	inner := strings.TrimSpace(existing.Raw)
+	inner = strings.TrimSpace(inner[1 : len(inner)-1])
+	raw := "[" + string(value)
+	if inner != "" {
+		raw += "," + inner
+	}
+	return sjson.SetRawBytes(body, path, []byte(raw+"]"))
+}
+
+// policyWriter collects the generated text of a response to check it. For
+// blocking policies a non-streaming response is buffered until finish.
+type policyWriter struct {
+	gin.ResponseWriter
+	policy *policy
+	apiKey string
+
+	text      strings.Builder
+	checked   int
+	decided   bool
+	streaming bool
+	buffered  bool
+	blocked   bool
+	status    int
+	buf       bytes.Buffer
+}
+
+func (w *policyWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.streaming = strings.Contains(w.Header().Get("Content-Type"), "text/event-stream")
+	w.buffered = w.policy.block && !w.streaming
+}
+
+func (w *policyWriter) WriteHeader(code int) {
+	w.decide()
+	w.status = code
+	if w.buffered {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *policyWriter) WriteHeaderNow() {
+	w.decide()
+	if !w.buffered {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *policyWriter) Flush() {
+	if !w.buffered {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *policyWriter) Write(data []byte) (int, error) {
+	w.decide()
+	if w.blocked {
+		return len(data), nil
+	}
+	collectText(data, &w.text)
+	if w.buffered {
+		return w.buf.Write(data)
+	}
+	if w.policy.block && w.text.Len()-w.checked >= checkEvery {
+		w.checked = w.text.Len()
+		if kind, detail := w.policy.violation(w.text.String(), false); kind != "" {
+			w.block(kind, detail)
+			return len(data), nil
+		}
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *policyWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *policyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish checks the complete response, then writes a buffered response or
+// the block in its place.
+func (w *policyWriter) finish() {
+	if w.blocked {
+		return
+	}
+	var kind, detail string
+	if w.status < http.StatusBadRequest {
+		kind, detail = w.policy.violation(w.text.String(), true)
+	}
+	switch {
+	case kind != "" && w.buffered:
+		w.block(kind, detail)
+	case kind != "":
+		w.record(kind, detail, actionLog)
+	}
+	if w.buffered && !w.blocked {
+		w.ResponseWriter.WriteHeader(w.status)
+		_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+}
+
+// record counts and logs a violation.
+func (w *policyWriter) record(kind, detail, action string) {
+	violations.Inc(w.policy.name, kind, action)
+	log.Warnf("response-policy: %s violation of policy %q by key %s (%s, action=%s)",
+		kind, w.policy.name, util.HideAPIKey(w.apiKey), detail, action)
+}
+
+// block replaces the response with a 403, or ends a started stream with an SSE error event.
+func (w *policyWriter) block(kind, detail string) {
+	w.blocked = true
+	w.record(kind, detail, actionBlock)
+	payload, _ := json.Marshal(gin.H{
+		"error":   "response_policy_violation",
+		"message": "Response blocked by policy " + strconv.Quote(w.policy.name) + ": " + detail,
+		"policy":  w.policy.name,
+		"kind":    kind,
+	})
+	if !w.ResponseWriter.Written() {
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Type", "application/json")
+		w.ResponseWriter.WriteHeader(http.StatusForbidden)
+		_, _ = w.ResponseWriter.Write(payload)
+		return
+	}
+	_, _ = w.ResponseWriter.Write([]byte("event: error\ndata: " + string(payload) + "\n\n"))
+	w.ResponseWriter.Flush()
+}
+
+// collectText appends the generated text of a response chunk, a whole JSON
+// document or SSE lines with JSON payloads, to sb.
+func collectText(data []byte, sb *strings.Builder) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' && gjson.ValidBytes(trimmed) {
+		walkText(gjson.ParseBytes(trimmed), "", sb)
+		return
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		payload, _ := bytes.CutPrefix(line, []byte("data:"))
+		payload = bytes.TrimSpace(payload)
+		if len(payload) > 0 && payload[0] == '{' && gjson.ValidBytes(payload) {
+			walkText(gjson.ParseBytes(payload), "", sb)
+		}
+	}
+}
+
+func walkText(r gjson.Result, key string, sb *strings.Builder) {
+	switch {
+	case r.IsObject():
+		r.ForEach(func(k, v gjson.Result) bool {
+			walkText(v, k.String(), sb)
+			return true
+		})
+	case r.IsArray():
+		r.ForEach(func(_, v gjson.Result) bool {
+			walkText(v, key, sb)
+			return true
+		})
+	case r.Type == gjson.String && textFields[key]:
+		sb.WriteString(r.Str)
+	}
+}
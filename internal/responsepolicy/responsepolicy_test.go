@@ -0,0 +1,92 @@
+package responsepolicy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	englishText    = "The deployment finished without errors and all of the services are healthy. You can now open the dashboard to check that the new version is live for your users."
+	vietnameseText = "Quá trình triển khai đã hoàn tất mà không có lỗi và tất cả các dịch vụ đều hoạt động bình thường. Bạn có thể mở bảng điều khiển để kiểm tra phiên bản mới."
+)
+
+func TestDetectLanguage(t *testing.T) {
+	cases := map[string]string{
+		englishText:    "en",
+		vietnameseText: "vi",
+		"Die Bereitstellung ist ohne Fehler abgeschlossen und die Dienste laufen auch wieder. Sie können das Dashboard öffnen, um die neue Version zu prüfen.": "de",
+		"デプロイはエラーなしで完了し、すべてのサービスが正常に動作しています。ダッシュボードを開いて新しいバージョンを確認できます。":                                                                                       "ja",
+		"ok": "",
+	}
+	for text, want := range cases {
+		if got := detectLanguage(text); got != want {
+			t.Errorf("detectLanguage(%.30q) = %q, want %q", text, got, want)
+		}
+	}
+	if !containsCode("Here you go:\n```go\nfmt.Println(1)\n```") || containsCode(englishText) {
+		t.Fatal("unexpected code detection")
+	}
+}
+
+func TestMiddlewareInjectsInstructionAndBlocksViolations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	e := New(config.ResponsePolicyConfig{Enabled: true, Policies: []config.ResponsePolicy{
+		{Name: "default", Language: "en"},
+		{Name: "vi-strict", APIKeys: []string{"vi-key"}, Language: "vi", ForbidCode: true, Action: "block"},
+	}})
+	var seenSystem string
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("X-Test-Key"))
+		c.Next()
+	})
+	engine.Use(e.Middleware())
+	engine.POST("/v1/chat/completions", func(c *gin.Context) {
+		raw, _ := c.GetRawData()
+		seenSystem = gjson.GetBytes(raw, "messages.0.content").String()
+		c.JSON(http.StatusOK, gin.H{"choices": []gin.H{{"message": gin.H{"role": "assistant", "content": c.GetHeader("X-Test-Reply")}}}})
+	})
+	post := func(key, reply string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("X-Test-Key", key)
+		req.Header.Set("X-Test-Reply", reply)
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post("any-key", vietnameseText); rec.Code != http.StatusOK {
+		t.Fatalf("expected a logging policy to pass the response, got %d", rec.Code)
+	}
+	if !strings.Contains(seenSystem, "English") {
+		t.Fatalf("expected the instruction to be injected as the first message, got %q", seenSystem)
+	}
+	if rec := post("vi-key", vietnameseText); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "triển khai") {
+		t.Fatalf("expected a compliant response to pass, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec := post("vi-key", englishText)
+	if rec.Code != http.StatusForbidden || gjson.Get(rec.Body.String(), "kind").String() != "language" {
+		t.Fatalf("expected the English response to be blocked, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = post("vi-key", vietnameseText+"\n```sh\nrm -rf /tmp/x\n```")
+	if rec.Code != http.StatusForbidden || gjson.Get(rec.Body.String(), "kind").String() != "code" {
+		t.Fatalf("expected the code to be blocked, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestInjectInstructionFormats(t *testing.T) {
+	claude := injectInstruction("/v1/messages", []byte(`{"system":"Be brief.","messages":[]}`), "Answer in English.")
+	if got := gjson.GetBytes(claude, "system").String(); got != "Answer in English.\n\nBe brief." {
+		t.Fatalf("unexpected claude system prompt %q", got)
+	}
+	gemini := injectInstruction("/v1beta/models/gemini:generateContent", []byte(`{"contents":[]}`), "Answer in English.")
+	if got := gjson.GetBytes(gemini, "systemInstruction.parts.0.text").String(); got != "Answer in English." {
+		t.Fatalf("unexpected gemini system instruction %q", got)
+	}
+}
@@ -0,0 +1,93 @@
+package executor
+
+import (
+	"bytes"
+
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+	"github.com/tidwall/gjson"
+)
+
+// maxHeldClaudeChunks bounds the preamble held back before the first content token;
+// past it the stream is released and no longer retryable.
+const maxHeldClaudeChunks = 256
+
+// claudeStreamGuard sits between the upstream Claude SSE lines and the output
+// channel. It holds back the stream preamble (message_start, pings, block starts)
+// until the first content delta, so an overloaded_error that arrives before any
+// token leaves nothing on the wire and the request can be retried from scratch.
+// Overloaded errors are turned into a typed OverloadedError instead of being
+// forwarded as a raw event.
+type claudeStreamGuard struct {
+	out     chan<- cliproxyexecutor.StreamChunk
+	started bool
+	held    [][]byte
+	// errorEvent holds the output of an "event: error" line until its data line shows the error type.
+	errorEvent [][]byte
+}
+
+func newClaudeStreamGuard(out chan<- cliproxyexecutor.StreamChunk) *claudeStreamGuard {
+	return &claudeStreamGuard{out: out}
+}
+
+// overloaded inspects an upstream line and returns the typed error when it carries an
+// overloaded_error event. Held output is discarded; the caller must stop reading.
+func (g *claudeStreamGuard) overloaded(line []byte) *cliproxyexecutor.OverloadedError {
+	data, ok := claudeSSEData(line)
+	if !ok || gjson.GetBytes(data, "type").String() != "error" || gjson.GetBytes(data, "error.type").String() != "overloaded_error" {
+		return nil
+	}
+	g.held, g.errorEvent = nil, nil
+	return &cliproxyexecutor.OverloadedError{
+		Message:         gjson.GetBytes(data, "error.message").String(),
+		TokensDelivered: g.started,
+	}
+}
+
+// send forwards the output produced for an upstream line, or holds it while
+// the stream has not produced content yet.
+func (g *claudeStreamGuard) send(line []byte, chunks ...[]byte) {
+	if bytes.Equal(bytes.TrimSpace(line), []byte("event: error")) {
+		g.errorEvent = append(g.errorEvent, chunks...)
+		return
+	}
+	if len(g.errorEvent) > 0 {
+		chunks = append(g.errorEvent, chunks...)
+		g.errorEvent = nil
+	}
+	if !g.started {
+		if data, ok := claudeSSEData(line); ok && gjson.GetBytes(data, "type").String() == "content_block_delta" {
+			g.flush()
+		} else {
+			g.held = append(g.held, chunks...)
+			if len(g.held) > maxHeldClaudeChunks {
+				g.flush()
+			}
+			return
+		}
+	}
+	g.emit(chunks)
+}
+
+// flush releases held output; the stream counts as started from here on.
+func (g *claudeStreamGuard) flush() {
+	g.started = true
+	g.emit(g.held)
+	g.emit(g.errorEvent)
+	g.held, g.errorEvent = nil, nil
+}
+
+func (g *claudeStreamGuard) emit(chunks [][]byte) {
+	for _, chunk := range chunks {
+		if len(chunk) > 0 {
+			g.out <- cliproxyexecutor.StreamChunk{Payload: chunk}
+		}
+	}
+}
+
+func claudeSSEData(line []byte) ([]byte, bool) {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok {
+		return nil, false
+	}
+	return bytes.TrimSpace(data), true
+}
@@ -0,0 +1,75 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+)
+
+func runClaudeStreamGuard(lines ...string) (string, *cliproxyexecutor.OverloadedError) {
+	out := make(chan cliproxyexecutor.StreamChunk, 64)
+	guard := newClaudeStreamGuard(out)
+	var errOverloaded *cliproxyexecutor.OverloadedError
+	for _, line := range lines {
+		if errOverloaded = guard.overloaded([]byte(line)); errOverloaded != nil {
+			break
+		}
+		guard.send([]byte(line), []byte(line+"\n"))
+	}
+	if errOverloaded == nil {
+		guard.flush()
+	}
+	close(out)
+	var sb strings.Builder
+	for chunk := range out {
+		sb.Write(chunk.Payload)
+	}
+	return sb.String(), errOverloaded
+}
+
+const claudeOverloadedEvent = `data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`
+
+func TestClaudeStreamGuardDiscardsPreambleOnEarlyOverload(t *testing.T) {
+	got, errOverloaded := runClaudeStreamGuard(
+		"event: message_start",
+		`data: {"type":"message_start","message":{"id":"msg_1"}}`,
+		"",
+		"event: error",
+		claudeOverloadedEvent,
+	)
+	if errOverloaded == nil || errOverloaded.TokensDelivered {
+		t.Fatalf("expected an overloaded error before the first token, got %+v", errOverloaded)
+	}
+	if got != "" {
+		t.Fatalf("expected nothing on the wire, got %q", got)
+	}
+}
+
+func TestClaudeStreamGuardReportsOverloadAfterFirstToken(t *testing.T) {
+	got, errOverloaded := runClaudeStreamGuard(
+		`data: {"type":"message_start","message":{"id":"msg_1"}}`,
+		`data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"Hel"}}`,
+		"event: error",
+		claudeOverloadedEvent,
+	)
+	if errOverloaded == nil || !errOverloaded.TokensDelivered {
+		t.Fatalf("expected an overloaded error after the first token, got %+v", errOverloaded)
+	}
+	if !strings.Contains(got, "message_start") || !strings.Contains(got, "Hel") || strings.Contains(got, "event: error") {
+		t.Fatalf("unexpected forwarded output %q", got)
+	}
+	if !strings.Contains(errOverloaded.Error(), "resend") {
+		t.Fatalf("expected resume guidance, got %q", errOverloaded.Error())
+	}
+}
+
+func TestClaudeStreamGuardForwardsOtherErrors(t *testing.T) {
+	got, errOverloaded := runClaudeStreamGuard(
+		"event: error",
+		`data: {"type":"error","error":{"type":"api_error","message":"boom"}}`,
+	)
+	if errOverloaded != nil || !strings.Contains(got, "event: error\n") || !strings.Contains(got, "api_error") {
+		t.Fatalf("expected non-overload errors to pass through, got %q %+v", got, errOverloaded)
+	}
+}
@@ -132,6 +132,9 @@ func apiKeyFromContext(ctx context.Context) string {
 }
 
 func resolveUsageSource(auth *cliproxyauth.Auth, ctxAPIKey string) string {
+	if auth.IsBYOK() {
+		return cliproxyauth.BYOKAttribute
+	}
 	if auth != nil {
 		provider := strings.TrimSpace(auth.Provider)
 		if strings.EqualFold(provider, "gemini-cli") {
@@ -0,0 +1,268 @@
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	coreauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+)
+
+// defaultUpstreamTimeout bounds each upstream probe unless self-test.timeout is set.
+const defaultUpstreamTimeout = 10 * time.Second
+
+const (
+	defaultClaudeBaseURL = "https://api.anthropic.com"
+	defaultGeminiBaseURL = "https://generativelanguage.googleapis.com"
+)
+
+// Checks returns the startup checks for cfg. store is the auth token store; it may be nil.
+func Checks(cfg *config.Config, store coreauth.Store) []Check {
+	checks := []Check{
+		{Name: "config.port", Category: "config", Fatal: true, Run: func(context.Context) Result { return checkPort(cfg.Port) }},
+		{Name: "config.tls", Category: "config", Fatal: true, Run: func(context.Context) Result { return checkTLS("tls", cfg.TLS) }},
+		{Name: "config.remote-management", Category: "config", Run: func(context.Context) Result { return checkRemoteManagement(cfg) }},
+		{Name: "config.device-binding", Category: "config", Run: func(context.Context) Result { return checkDeviceBinding(cfg) }},
+		{Name: "store.auth-dir", Category: "store", Fatal: true, Run: func(context.Context) Result { return checkAuthDir(cfg.AuthDir) }},
+		{Name: "store.token-store", Category: "store", Fatal: true, Run: func(ctx context.Context) Result { return checkTokenStore(ctx, store, cfg) }},
+		{Name: "listener.proxy", Category: "listener", Fatal: true, Run: func(context.Context) Result {
+			return checkBind(net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)), "port")
+		}},
+	}
+	if admin := cfg.AdminListener; admin.Enabled {
+		checks = append(checks, Check{Name: "config.admin-listener.tls", Category: "config", Fatal: true, Run: func(context.Context) Result {
+			return checkTLS("admin-listener.tls", admin.TLS)
+		}})
+		if strings.TrimSpace(admin.Socket) == "" && admin.Port > 0 {
+			host := strings.TrimSpace(admin.Host)
+			if host == "" {
+				host = "127.0.0.1"
+			}
+			checks = append(checks, Check{Name: "listener.admin", Category: "listener", Fatal: true, Run: func(context.Context) Result {
+				return checkBind(net.JoinHostPort(host, strconv.Itoa(admin.Port)), "admin-listener.port")
+			}})
+		}
+	}
+	if !cfg.SelfTest.SkipUpstream {
+		checks = append(checks, upstreamChecks(cfg)...)
+	}
+	return checks
+}
+
+func checkPort(port int) Result {
+	if port <= 0 || port > 65535 {
+		return fail(fmt.Sprintf("port %d is out of range", port), "set port to a value between 1 and 65535")
+	}
+	return pass(fmt.Sprintf("port %d", port))
+}
+
+func checkTLS(section string, cfg config.TLSConfig) Result {
+	if !cfg.Enable {
+		if mode := strings.TrimSpace(cfg.ClientAuth); mode != "" && mode != "none" {
+			return warn(section+".client-auth is set but TLS is disabled", "set "+section+".enable: true or remove client-auth")
+		}
+		return Result{Status: StatusSkip, Message: "TLS disabled"}
+	}
+	cert, key := strings.TrimSpace(cfg.Cert), strings.TrimSpace(cfg.Key)
+	if cert == "" || key == "" {
+		return fail(section+".cert or "+section+".key is empty", "point both at PEM files or set "+section+".enable: false")
+	}
+	if _, err := tls.LoadX509KeyPair(cert, key); err != nil {
+		return fail("cannot load certificate: "+err.Error(), "check that "+section+".cert and "+section+".key are a matching, readable PEM pair")
+	}
+	switch mode := strings.ToLower(strings.TrimSpace(cfg.ClientAuth)); mode {
+	case "", "none":
+	case "optional", "require":
+		pem, err := os.ReadFile(strings.TrimSpace(cfg.ClientCA))
+		if err != nil {
+			return fail("cannot read client CA: "+err.Error(), "set "+section+".client-ca to a readable PEM bundle")
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return fail(section+".client-ca contains no certificates", "use a PEM-encoded CA bundle")
+		}
+	default:
+		return fail("unknown "+section+".client-auth "+strconv.Quote(cfg.ClientAuth), "use none, optional or require")
+	}
+	return pass("certificate loaded")
+}
+
+func checkRemoteManagement(cfg *config.Config) Result {
+	if !cfg.RemoteManagement.AllowRemote {
+		return pass("remote management disabled")
+	}
+	if strings.TrimSpace(cfg.RemoteManagement.SecretKey) == "" && strings.TrimSpace(os.Getenv("MANAGEMENT_PASSWORD")) == "" {
+		return warn("allow-remote is true but no management key is set, so the management API stays disabled",
+			"set remote-management.secret-key or MANAGEMENT_PASSWORD")
+	}
+	return pass("management key set")
+}
+
+func checkDeviceBinding(cfg *config.Config) Result {
+	db := cfg.DeviceBinding
+	if !db.Enabled {
+		return Result{Status: StatusSkip, Message: "device binding disabled"}
+	}
+	if db.ClientCertIdentity {
+		if mode := strings.TrimSpace(cfg.TLS.ClientAuth); !cfg.TLS.Enable || mode == "" || mode == "none" {
+			return warn("client-cert-identity is on but clients are never asked for certificates",
+				"set tls.enable and tls.client-auth, or turn off device-binding.client-cert-identity")
+		}
+	}
+	if mode := strings.TrimSpace(db.TLSFingerprint.Mode); mode != "" && mode != device.TLSFingerprintOff &&
+		!cfg.TLS.Enable && strings.TrimSpace(db.TLSFingerprint.Header) == "" {
+		return warn("tls-fingerprint pinning is on but fingerprints are unavailable",
+			"set tls.enable or device-binding.tls-fingerprint.header")
+	}
+	return pass("consistent")
+}
+
+// checkAuthDir writes, reads back and removes a probe file in the auth directory.
+func checkAuthDir(dir string) Result {
+	if strings.TrimSpace(dir) == "" {
+		return fail("auth-dir is empty", "set auth-dir to a writable directory")
+	}
+	remedy := "make " + dir + " writable by the proxy user or point auth-dir elsewhere"
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fail("cannot create auth-dir: "+err.Error(), remedy)
+	}
+	path := filepath.Join(dir, fmt.Sprintf(".selftest-%d", time.Now().UnixNano()))
+	want := []byte("selftest")
+	if err := os.WriteFile(path, want, 0o600); err != nil {
+		return fail("cannot write to auth-dir: "+err.Error(), remedy)
+	}
+	defer func() { _ = os.Remove(path) }()
+	got, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(got, want) {
+		return fail(fmt.Sprintf("cannot read back from auth-dir: %v", err), remedy)
+	}
+	if err = os.Remove(path); err != nil {
+		return fail("cannot delete from auth-dir: "+err.Error(), remedy)
+	}
+	return pass("read/write ok")
+}
+
+func checkTokenStore(ctx context.Context, store coreauth.Store, cfg *config.Config) Result {
+	if store == nil {
+		return Result{Status: StatusSkip, Message: "no token store registered"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultUpstreamTimeout)
+	defer cancel()
+	auths, err := store.List(ctx)
+	if err != nil {
+		return fail("cannot list credentials: "+err.Error(), "check the token store connection settings and permissions")
+	}
+	configured := len(cfg.ClaudeKey) + len(cfg.GeminiKey) + len(cfg.CodexKey) + len(cfg.OpenAICompatibility) + len(cfg.VertexCompatAPIKey)
+	if len(auths) == 0 && configured == 0 {
+		return warn("no upstream credentials configured", "log in with one of the -login flags or add API keys to the config")
+	}
+	return pass(fmt.Sprintf("%d stored credentials, %d configured API key providers", len(auths), configured))
+}
+
+// checkBind verifies the listener address is free by binding and releasing it.
+func checkBind(addr, setting string) Result {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fail("cannot bind "+addr+": "+err.Error(), "stop the process using it or change "+setting)
+	}
+	_ = ln.Close()
+	return pass(addr + " available")
+}
+
+// upstreamProbe is one authenticated request against an upstream model listing.
+type upstreamProbe struct {
+	name     string
+	url      string
+	header   http.Header
+	proxyURL string
+	setting  string
+}
+
+func upstreamChecks(cfg *config.Config) []Check {
+	var probes []upstreamProbe
+	for i, k := range cfg.ClaudeKey {
+		base := strings.TrimRight(strings.TrimSpace(k.BaseURL), "/")
+		h := http.Header{"Anthropic-Version": {"2023-06-01"}}
+		if base == "" || base == defaultClaudeBaseURL {
+			base = defaultClaudeBaseURL
+			h.Set("X-Api-Key", k.APIKey)
+		} else {
+			h.Set("Authorization", "Bearer "+k.APIKey)
+		}
+		probes = append(probes, upstreamProbe{
+			name: fmt.Sprintf("upstream.claude[%d]", i), url: base + "/v1/models", header: h,
+			proxyURL: k.ProxyURL, setting: fmt.Sprintf("claude-api-key[%d]", i),
+		})
+	}
+	for i, k := range cfg.GeminiKey {
+		base := strings.TrimRight(strings.TrimSpace(k.BaseURL), "/")
+		if base == "" {
+			base = defaultGeminiBaseURL
+		}
+		probes = append(probes, upstreamProbe{
+			name: fmt.Sprintf("upstream.gemini[%d]", i), url: base + "/v1beta/models",
+			header: http.Header{"X-Goog-Api-Key": {k.APIKey}}, proxyURL: k.ProxyURL,
+			setting: fmt.Sprintf("gemini-api-key[%d]", i),
+		})
+	}
+	for _, compat := range cfg.OpenAICompatibility {
+		base := strings.TrimRight(strings.TrimSpace(compat.BaseURL), "/")
+		for i, entry := range compat.APIKeyEntries {
+			probes = append(probes, upstreamProbe{
+				name: fmt.Sprintf("upstream.%s[%d]", compat.Name, i), url: base + "/models",
+				header: http.Header{"Authorization": {"Bearer " + entry.APIKey}}, proxyURL: entry.ProxyURL,
+				setting: fmt.Sprintf("openai-compatibility %s api-key-entries[%d]", compat.Name, i),
+			})
+		}
+	}
+
+	timeout := time.Duration(cfg.SelfTest.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultUpstreamTimeout
+	}
+	checks := make([]Check, 0, len(probes))
+	for _, p := range probes {
+		p := p
+		checks = append(checks, Check{Name: p.name, Category: "upstream", Run: func(ctx context.Context) Result {
+			return runUpstreamProbe(ctx, cfg, p, timeout)
+		}})
+	}
+	return checks
+}
+
+func runUpstreamProbe(ctx context.Context, cfg *config.Config, p upstreamProbe, timeout time.Duration) Result {
+	sdkCfg := cfg.SDKConfig
+	if proxyURL := strings.TrimSpace(p.proxyURL); proxyURL != "" {
+		sdkCfg.ProxyURL = proxyURL
+	}
+	client := util.SetProxy(&sdkCfg, &http.Client{Timeout: timeout})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return fail("invalid upstream URL: "+err.Error(), "fix the base-url of "+p.setting)
+	}
+	req.Header = p.header.Clone()
+	resp, err := client.Do(req)
+	if err != nil {
+		return warn("upstream unreachable: "+err.Error(), "check network access, proxy-url and base-url of "+p.setting)
+	}
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return pass(fmt.Sprintf("authenticated (%d)", resp.StatusCode))
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fail(fmt.Sprintf("upstream rejected the API key (%d)", resp.StatusCode), "replace or remove the api-key of "+p.setting)
+	default:
+		return warn(fmt.Sprintf("unexpected upstream status %d", resp.StatusCode), "check the base-url of "+p.setting)
+	}
+}
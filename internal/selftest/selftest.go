@@ -0,0 +1,129 @@
+// Package selftest runs the startup self-test: configuration consistency, auth
+// store read/write, listener port binding and an authentication probe of every
+// configured upstream API key. Each check reports pass, warn or fail together
+// with a remedy, so a misconfigured deployment says what to fix instead of
+// failing on the first request.
+package selftest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Check outcomes
+const (
+	StatusPass = "pass"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+	StatusSkip = "skip"
+)
+
+// Result is the outcome of one check.
+type Result struct {
+	Name       string `json:"name"`
+	Category   string `json:"category"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	Remedy     string `json:"remedy,omitempty"`
+	Fatal      bool   `json:"fatal,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Check is one self-test. Fatal checks abort startup when they fail.
+type Check struct {
+	Name     string
+	Category string
+	Fatal    bool
+	Run      func(ctx context.Context) Result
+}
+
+// Report is the outcome of a self-test run.
+type Report struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// OK is false when any check failed.
+	OK      bool     `json:"ok"`
+	Results []Result `json:"results"`
+}
+
+// Run executes the checks concurrently and returns their results in check order.
+func Run(ctx context.Context, checks []Check) Report {
+	report := Report{StartedAt: time.Now().UTC(), OK: true, Results: make([]Result, len(checks))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			start := time.Now()
+			result := check.Run(ctx)
+			result.Name, result.Category, result.Fatal = check.Name, check.Category, check.Fatal
+			if result.Status == "" {
+				result.Status = StatusPass
+			}
+			result.DurationMS = time.Since(start).Milliseconds()
+			report.Results[i] = result
+		}(i, check)
+	}
+	wg.Wait()
+	for _, r := range report.Results {
+		if r.Status == StatusFail {
+			report.OK = false
+		}
+	}
+	report.FinishedAt = time.Now().UTC()
+	return report
+}
+
+// FatalErr returns an error listing the failed fatal checks, or nil.
+func (r Report) FatalErr() error {
+	var failed []string
+	for _, res := range r.Results {
+		if res.Fatal && res.Status == StatusFail {
+			failed = append(failed, describe(res))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("self-test failed:\n  %s", strings.Join(failed, "\n  "))
+}
+
+// Log writes one line per check at a level matching its outcome.
+func (r Report) Log() {
+	for _, res := range r.Results {
+		switch res.Status {
+		case StatusFail:
+			log.Errorf("self-test: %s", describe(res))
+		case StatusWarn:
+			log.Warnf("self-test: %s", describe(res))
+		default:
+			log.Debugf("self-test: %s: %s", res.Name, res.Status)
+		}
+	}
+	if r.OK {
+		log.Infof("self-test: %d checks passed", len(r.Results))
+	}
+}
+
+func describe(r Result) string {
+	s := r.Name + ": " + r.Message
+	if r.Remedy != "" {
+		s += " (" + r.Remedy + ")"
+	}
+	return s
+}
+
+func pass(message string) Result { return Result{Status: StatusPass, Message: message} }
+
+func warn(message, remedy string) Result {
+	return Result{Status: StatusWarn, Message: message, Remedy: remedy}
+}
+
+func fail(message, remedy string) Result {
+	return Result{Status: StatusFail, Message: message, Remedy: remedy}
+}
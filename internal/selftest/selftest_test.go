@@ -0,0 +1,90 @@
+package selftest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func findResult(t *testing.T, report Report, name string) Result {
+	t.Helper()
+	for _, r := range report.Results {
+		if r.Name == name {
+			return r
+		}
+	}
+	t.Fatalf("no result for %s in %+v", name, report.Results)
+	return Result{}
+}
+
+func TestRunReportsFatalFailuresWithRemedies(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = busy.Close() }()
+
+	cfg := &config.Config{
+		Host:    "127.0.0.1",
+		Port:    busy.Addr().(*net.TCPAddr).Port,
+		AuthDir: t.TempDir(),
+		TLS:     config.TLSConfig{Enable: true},
+	}
+	cfg.SelfTest.SkipUpstream = true
+	report := Run(context.Background(), Checks(cfg, nil))
+
+	if report.OK {
+		t.Fatalf("report should fail")
+	}
+	if r := findResult(t, report, "store.auth-dir"); r.Status != StatusPass {
+		t.Fatalf("auth-dir: %+v", r)
+	}
+	if r := findResult(t, report, "listener.proxy"); r.Status != StatusFail || !strings.Contains(r.Remedy, "port") {
+		t.Fatalf("listener: %+v", r)
+	}
+	if r := findResult(t, report, "config.tls"); r.Status != StatusFail || r.Remedy == "" {
+		t.Fatalf("tls: %+v", r)
+	}
+	err = report.FatalErr()
+	if err == nil || !strings.Contains(err.Error(), "listener.proxy") || !strings.Contains(err.Error(), "config.tls") {
+		t.Fatalf("FatalErr() = %v", err)
+	}
+}
+
+func TestUpstreamProbeFlagsRejectedKeysWithoutFailingStartup(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		ClaudeKey: []config.ClaudeKey{
+			{APIKey: "good", BaseURL: upstream.URL},
+			{APIKey: "revoked", BaseURL: upstream.URL},
+		},
+	}
+	report := Run(context.Background(), upstreamChecks(cfg))
+
+	if r := findResult(t, report, "upstream.claude[0]"); r.Status != StatusPass {
+		t.Fatalf("good key: %+v", r)
+	}
+	r := findResult(t, report, "upstream.claude[1]")
+	if r.Status != StatusFail || !strings.Contains(r.Remedy, "claude-api-key[1]") {
+		t.Fatalf("revoked key: %+v", r)
+	}
+	if report.OK {
+		t.Fatalf("report should record the failure")
+	}
+	if err := report.FatalErr(); err != nil {
+		t.Fatalf("upstream failures must not abort startup: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+// Package snapshot periodically saves in-memory runtime state (rate-limit
+// counters, limit boosts and upstream health) to disk and restores it on boot,
+// so a restart does not reset quotas or cooldowns. Device bindings are already
+// persisted by their store and are not part of the snapshot.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/limits"
+	"github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultFileName is used when no snapshot path is configured.
+	DefaultFileName = "state-snapshot.json"
+
+	defaultInterval = time.Minute
+	defaultMaxAge   = 24 * time.Hour
+	formatVersion   = 1
+)
+
+// Snapshot is the on-disk document.
+type Snapshot struct {
+	Version   int                         `json:"version"`
+	TakenAt   time.Time                   `json:"taken_at"`
+	Limits    *limits.State               `json:"limits,omitempty"`
+	Upstreams map[string]auth.HealthState `json:"upstreams,omitempty"`
+}
+
+// Manager saves and restores snapshots.
+type Manager struct {
+	path     string
+	interval time.Duration
+	maxAge   time.Duration
+	limiter  *limits.Limiter
+	auths    *auth.Manager
+}
+
+// New creates a snapshot manager for the given components; either may be nil.
+func New(cfg config.SnapshotConfig, limiter *limits.Limiter, auths *auth.Manager) *Manager {
+	m := &Manager{
+		path:     strings.TrimSpace(cfg.Path),
+		interval: time.Duration(cfg.Interval) * time.Second,
+		maxAge:   time.Duration(cfg.MaxAge) * time.Second,
+		limiter:  limiter,
+		auths:    auths,
+	}
+	if m.path == "" {
+		m.path = DefaultFileName
+	}
+	if m.interval <= 0 {
+		m.interval = defaultInterval
+	}
+	if m.maxAge <= 0 {
+		m.maxAge = defaultMaxAge
+	}
+	return m
+}
+
+// Restore loads the last snapshot, if any, into the components. Snapshots
+// older than the configured maximum age are ignored.
+func (m *Manager) Restore() error {
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("snapshot: read %s: %w", m.path, err)
+	}
+	var snap Snapshot
+	if err = json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("snapshot: decode %s: %w", m.path, err)
+	}
+	if snap.Version != formatVersion {
+		return fmt.Errorf("snapshot: unsupported version %d", snap.Version)
+	}
+	if age := time.Since(snap.TakenAt); age > m.maxAge {
+		log.Infof("snapshot: ignoring snapshot taken %s ago (max age %s)", age.Round(time.Second), m.maxAge)
+		return nil
+	}
+	if m.limiter != nil && snap.Limits != nil {
+		m.limiter.Restore(*snap.Limits)
+	}
+	restored := 0
+	if m.auths != nil && len(snap.Upstreams) > 0 {
+		restored = m.auths.RestoreHealth(snap.Upstreams)
+	}
+	counters := 0
+	if snap.Limits != nil {
+		counters = len(snap.Limits.Counters)
+	}
+	log.Infof("snapshot: restored state from %s (%d rate-limit counters, %d upstream health entries, %d applied now)",
+		snap.TakenAt.Format(time.RFC3339), counters, len(snap.Upstreams), restored)
+	return nil
+}
+
+// Save writes the current state atomically.
+func (m *Manager) Save() error {
+	snap := Snapshot{Version: formatVersion, TakenAt: time.Now().UTC()}
+	if m.limiter != nil {
+		state := m.limiter.Snapshot()
+		snap.Limits = &state
+	}
+	if m.auths != nil {
+		snap.Upstreams = m.auths.HealthSnapshot()
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("snapshot: encode: %w", err)
+	}
+	dir := filepath.Dir(m.path)
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("snapshot: create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".snapshot-*")
+	if err != nil {
+		return fmt.Errorf("snapshot: create temp file: %w", err)
+	}
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("snapshot: write: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("snapshot: write: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), m.path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("snapshot: replace %s: %w", m.path, err)
+	}
+	return nil
+}
+
+// Run saves a snapshot every interval until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Save(); err != nil {
+				log.Warn(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/limits"
+	"github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
+)
+
+func TestSaveAndRestorePreservesQuotaAndCooldowns(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.json")
+	cfg := config.SnapshotConfig{Enabled: true, Path: path}
+	limitCfg := limits.Config{Enabled: true, Default: limits.Limit{DailyRequests: 5}}
+
+	limiter := limits.New(limitCfg)
+	for i := 0; i < 3; i++ {
+		if _, ok := limiter.Allow("k1"); !ok {
+			t.Fatal("expected limiting to be enabled")
+		}
+	}
+	manager := auth.NewManager(nil, &auth.RoundRobinSelector{}, nil)
+	retryAt := time.Now().Add(time.Hour)
+	cooling := &auth.Auth{ID: "a", Provider: "claude", Status: auth.StatusError, Unavailable: true, NextRetryAfter: retryAt}
+	if _, err := manager.Register(ctx, cooling); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := New(cfg, limiter, manager).Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	restoredLimiter := limits.New(limitCfg)
+	restoredManager := auth.NewManager(nil, &auth.RoundRobinSelector{}, nil)
+	if err := New(cfg, restoredLimiter, restoredManager).Restore(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	status, _ := restoredLimiter.Allow("k1")
+	if status.QuotaRemaining != 1 {
+		t.Fatalf("expected 1 request left after restore, got %d", status.QuotaRemaining)
+	}
+
+	// The auth is loaded after the snapshot, as on boot, and still picks up its cooldown.
+	if _, err := restoredManager.Register(ctx, &auth.Auth{ID: "a", Provider: "claude"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	got, ok := restoredManager.GetByID("a")
+	if !ok || !got.Unavailable || !got.NextRetryAfter.Equal(retryAt) {
+		t.Fatalf("expected restored cooldown until %v, got %+v", retryAt, got)
+	}
+}
+
+func TestRestoreIgnoresMissingAndStaleSnapshots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	limiter := limits.New(limits.Config{Enabled: true, Default: limits.Limit{DailyRequests: 1}})
+	m := New(config.SnapshotConfig{Path: path, MaxAge: 60}, limiter, nil)
+	if err := m.Restore(); err != nil {
+		t.Fatalf("expected missing snapshot to be ignored, got %v", err)
+	}
+
+	stale := `{"version":1,"taken_at":"2000-01-01T00:00:00Z","limits":{"counters":{"k1":{"day_count":1}}}}`
+	if err := os.WriteFile(path, []byte(stale), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Restore(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if status, _ := limiter.Allow("k1"); !status.Allowed {
+		t.Fatal("expected stale snapshot to be ignored")
+	}
+}
@@ -0,0 +1,308 @@
+// Package spend prices upstream token usage per model and enforces a monthly
+// spend cap per client key. Spend is charged once a response reports its
+// usage, so the request that crosses the cap still completes; later requests
+// are rejected with 402 until the month ends or the cap is raised.
+package spend
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+// periodLayout formats the calendar month (UTC) spend accrues in.
+const periodLayout = "2006-01"
+
+// Cap sources reported by Cap
+const (
+	SourceKey     = "key"
+	SourceDefault = "default"
+)
+
+var rejections = metrics.Default().NewCounterVec(
+	"cliproxy_spend_cap_rejections_total",
+	"Requests rejected because the client key exceeded its monthly spend cap.",
+)
+
+var unavailable = metrics.Default().NewCounterVec(
+	"cliproxy_spend_unavailable_rejections_total",
+	"Requests of capped keys rejected because this month's spend could not be loaded (degraded-mode.quotas: fail-closed).",
+)
+
+// Tracker accrues spend per key and enforces monthly caps.
+type Tracker struct {
+	mu       sync.RWMutex
+	enabled  bool
+	cap      float64
+	keys     map[string]float64
+	exact    map[string]config.ModelPrice
+	prefixes []pricePrefix
+	fallback config.ModelPrice
+
+	period string
+	spent  map[string]float64
+	// seeded is set once this month's spend was loaded; failClosed rejects capped
+	// keys with 503 until then.
+	seeded     bool
+	failClosed bool
+
+	now func() time.Time
+}
+
+type pricePrefix struct {
+	prefix string
+	price  config.ModelPrice
+}
+
+// New creates a tracker from configuration.
+func New(cfg config.SpendConfig) *Tracker {
+	t := &Tracker{spent: make(map[string]float64), now: time.Now}
+	t.Update(cfg)
+	return t
+}
+
+// Update replaces the configuration. Accrued spend is kept.
+func (t *Tracker) Update(cfg config.SpendConfig) {
+	exact := make(map[string]config.ModelPrice)
+	var prefixes []pricePrefix
+	var fallback config.ModelPrice
+	for model, price := range cfg.Prices {
+		model = strings.ToLower(strings.TrimSpace(model))
+		switch {
+		case model == "*":
+			fallback = price
+		case strings.HasSuffix(model, "*"):
+			prefixes = append(prefixes, pricePrefix{prefix: strings.TrimSuffix(model, "*"), price: price})
+		case model != "":
+			exact[model] = price
+		}
+	}
+	// Longest prefix wins.
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i].prefix) > len(prefixes[j].prefix) })
+	keys := make(map[string]float64, len(cfg.Keys))
+	for key, v := range cfg.Keys {
+		keys[key] = v
+	}
+
+	t.mu.Lock()
+	t.enabled = cfg.Enabled
+	t.cap = cfg.MonthlyCap
+	t.keys = keys
+	t.exact = exact
+	t.prefixes = prefixes
+	t.fallback = fallback
+	t.mu.Unlock()
+}
+
+// Enabled reports whether spend is tracked and caps are enforced.
+func (t *Tracker) Enabled() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.enabled
+}
+
+// Price returns the price of a model in USD per million tokens.
+func (t *Tracker) Price(model string) config.ModelPrice {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.priceLocked(model)
+}
+
+func (t *Tracker) priceLocked(model string) config.ModelPrice {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if price, ok := t.exact[model]; ok {
+		return price
+	}
+	for _, p := range t.prefixes {
+		if strings.HasPrefix(model, p.prefix) {
+			return p.price
+		}
+	}
+	return t.fallback
+}
+
+// Cost returns the USD cost of the given token counts for a model.
+func (t *Tracker) Cost(model string, inputTokens, outputTokens int64) float64 {
+	price := t.Price(model)
+	return (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1e6
+}
+
+// Cap returns the monthly cap in USD applied to a key and whether it is a
+// per-key override ("key") or the default ("default"). A cap of 0 means none.
+func (t *Tracker) Cap(apiKey string) (float64, string) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if v, ok := t.keys[apiKey]; ok {
+		return v, SourceKey
+	}
+	return t.cap, SourceDefault
+}
+
+// rollLocked starts a new period when the month has changed. Callers must hold t.mu.
+func (t *Tracker) rollLocked(now time.Time) {
+	if period := now.UTC().Format(periodLayout); period != t.period {
+		t.period = period
+		t.spent = make(map[string]float64)
+	}
+}
+
+// Charge adds cost to the key's spend in the current month.
+func (t *Tracker) Charge(apiKey string, cost float64) {
+	if apiKey == "" || cost <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollLocked(t.now())
+	t.spent[apiKey] += cost
+}
+
+// Seed prices daily key usage rows of the current month, replacing accrued
+// spend. It restores spend after a restart.
+func (t *Tracker) Seed(rows []device.KeyUsage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.period = ""
+	t.seeded = true
+	now := t.now()
+	t.rollLocked(now)
+	for _, row := range rows {
+		if !strings.HasPrefix(row.Day, t.period) {
+			continue
+		}
+		price := t.priceLocked(row.Model)
+		t.spent[row.APIKey] += (float64(row.InputTokens)*price.Input + float64(row.OutputTokens)*price.Output) / 1e6
+	}
+}
+
+// Seeded reports whether this month's spend was loaded with Seed.
+func (t *Tracker) Seeded() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.seeded
+}
+
+// SetFailClosed makes Middleware reject keys with a cap (503) while this
+// month's spend has not been loaded, instead of enforcing caps against the
+// spend accrued since startup only.
+func (t *Tracker) SetFailClosed(failClosed bool) {
+	t.mu.Lock()
+	t.failClosed = failClosed
+	t.mu.Unlock()
+}
+
+// Spent returns the key's spend in the current month.
+func (t *Tracker) Spent(apiKey string) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollLocked(t.now())
+	return t.spent[apiKey]
+}
+
+// Period returns the current month (YYYY-MM) and when it resets.
+func (t *Tracker) Period() (string, time.Time) {
+	now := t.now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return now.Format(periodLayout), start.AddDate(0, 1, 0)
+}
+
+// KeySpend is the spend of one key in the current month.
+type KeySpend struct {
+	APIKey   string  `json:"api_key"`
+	Spent    float64 `json:"spent"`
+	Cap      float64 `json:"cap"`
+	Source   string  `json:"source"`
+	Exceeded bool    `json:"exceeded"`
+}
+
+// Keys returns the spend of every key with spend or a per-key cap this month, ordered by key.
+func (t *Tracker) Keys() []KeySpend {
+	t.mu.Lock()
+	t.rollLocked(t.now())
+	seen := make(map[string]struct{}, len(t.spent)+len(t.keys))
+	for key := range t.spent {
+		seen[key] = struct{}{}
+	}
+	for key := range t.keys {
+		seen[key] = struct{}{}
+	}
+	t.mu.Unlock()
+
+	out := make([]KeySpend, 0, len(seen))
+	for key := range seen {
+		out = append(out, t.KeySpend(key))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].APIKey < out[j].APIKey })
+	return out
+}
+
+// KeySpend returns the spend and cap of one key.
+func (t *Tracker) KeySpend(apiKey string) KeySpend {
+	spent := t.Spent(apiKey)
+	limit, source := t.Cap(apiKey)
+	return KeySpend{
+		APIKey:   apiKey,
+		Spent:    math.Round(spent*1e6) / 1e6,
+		Cap:      limit,
+		Source:   source,
+		Exceeded: limit > 0 && spent >= limit,
+	}
+}
+
+// HandleUsage implements coreusage.Plugin and charges reported usage.
+func (t *Tracker) HandleUsage(_ context.Context, record coreusage.Record) {
+	if t == nil || record.APIKey == "" || usage.IsExcludedAPIKey(record.APIKey) {
+		return
+	}
+	t.Charge(record.APIKey, t.Cost(record.Model, record.Detail.InputTokens, record.Detail.OutputTokens))
+}
+
+// Middleware rejects requests from keys that exceeded their monthly cap.
+func (t *Tracker) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := c.GetString("apiKey")
+		if !t.Enabled() || apiKey == "" {
+			c.Next()
+			return
+		}
+		status := t.KeySpend(apiKey)
+		t.mu.RLock()
+		unverified := t.failClosed && !t.seeded
+		t.mu.RUnlock()
+		if unverified && status.Cap > 0 {
+			unavailable.Inc()
+			c.Header("Retry-After", "30")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error":   "spend_unavailable",
+				"message": "Spend caps cannot be checked right now. Please retry shortly.",
+			})
+			return
+		}
+		if !status.Exceeded {
+			c.Next()
+			return
+		}
+		_, resetsAt := t.Period()
+		rejections.Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(resetsAt.Sub(t.now()).Seconds()))))
+		c.AbortWithStatusJSON(http.StatusPaymentRequired, gin.H{
+			"error":     "spend_cap_exceeded",
+			"message":   "Monthly spend cap of $" + strconv.FormatFloat(status.Cap, 'f', 2, 64) + " reached for this API key",
+			"spent":     status.Spent,
+			"cap":       status.Cap,
+			"resets_at": resetsAt,
+		})
+	}
+}
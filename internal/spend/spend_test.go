@@ -0,0 +1,102 @@
+package spend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+func TestTrackerRejectsOverCapUntilRaisedOrReset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.SpendConfig{
+		Enabled:    true,
+		MonthlyCap: 1,
+		Prices: map[string]config.ModelPrice{
+			"claude-sonnet-*": {Input: 3, Output: 15},
+			"*":               {Input: 1, Output: 1},
+		},
+	}
+	tracker := New(cfg)
+	now := time.Date(2025, 5, 20, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+	tracker.Seed([]device.KeyUsage{
+		{Day: "2025-04-30", APIKey: "k1", Model: "claude-sonnet-4", InputTokens: 1_000_000},
+		{Day: "2025-05-01", APIKey: "k1", Model: "claude-sonnet-4", InputTokens: 100_000, OutputTokens: 20_000},
+	})
+	if got := tracker.Spent("k1"); got < 0.5999 || got > 0.6001 {
+		t.Fatalf("expected $0.60 seeded from this month's usage, got %v", got)
+	}
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", "k1")
+		c.Next()
+	}, tracker.Middleware())
+	engine.POST("/v1/messages", func(c *gin.Context) { c.Status(http.StatusOK) })
+	call := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+		return rec
+	}
+
+	if rec := call(); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 under the cap, got %d", rec.Code)
+	}
+	tracker.HandleUsage(context.Background(), coreusage.Record{APIKey: "k1", Model: "claude-sonnet-4", Detail: coreusage.Detail{OutputTokens: 30_000}})
+	rec := call()
+	if rec.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected 402 over the cap, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "993600" {
+		t.Fatalf("Retry-After = %q, want seconds until June", got)
+	}
+
+	cfg.Keys = map[string]float64{"k1": 5}
+	tracker.Update(cfg)
+	if rec = call(); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 after raising the cap, got %d", rec.Code)
+	}
+
+	tracker.Update(config.SpendConfig{Enabled: true, MonthlyCap: 1, Prices: cfg.Prices})
+	now = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	if rec = call(); rec.Code != http.StatusOK || tracker.Spent("k1") != 0 {
+		t.Fatalf("expected spend to reset with the month, got %d and $%v", rec.Code, tracker.Spent("k1"))
+	}
+}
+
+func TestFailClosedRejectsCappedKeysUntilSeeded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tracker := New(config.SpendConfig{Enabled: true, Keys: map[string]float64{"capped": 10}})
+	tracker.SetFailClosed(true)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("apiKey", c.GetHeader("X-Test-Key"))
+		c.Next()
+	}, tracker.Middleware())
+	engine.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	do := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Test-Key", key)
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := do("capped"); code != http.StatusServiceUnavailable {
+		t.Fatalf("capped key before seeding: %d, want 503", code)
+	}
+	if code := do("uncapped"); code != http.StatusOK {
+		t.Fatalf("key without a cap: %d, want 200", code)
+	}
+	tracker.Seed(nil)
+	if code := do("capped"); code != http.StatusOK {
+		t.Fatalf("capped key after seeding: %d, want 200", code)
+	}
+}
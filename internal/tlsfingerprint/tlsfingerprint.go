@@ -0,0 +1,251 @@
+// Package tlsfingerprint computes JA3 and JA4 fingerprints of TLS clients from
+// their ClientHello. Fingerprints identify the TLS stack of a client (library,
+// version and configuration) and, unlike headers, cannot be changed by a user
+// without swapping the client itself, which makes them a useful device signal.
+package tlsfingerprint
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Supported fingerprint algorithms
+const (
+	AlgorithmJA4 = "ja4"
+	AlgorithmJA3 = "ja3"
+)
+
+// TLS extension IDs excluded from the JA4 extension hash.
+const (
+	extensionServerName = 0x0000
+	extensionALPN       = 0x0010
+)
+
+// Fingerprint holds both fingerprints of one TLS client.
+type Fingerprint struct {
+	JA3 string
+	JA4 string
+}
+
+// Get returns the fingerprint for algorithm, defaulting to JA4.
+func (f Fingerprint) Get(algorithm string) string {
+	if strings.EqualFold(algorithm, AlgorithmJA3) {
+		return f.JA3
+	}
+	return f.JA4
+}
+
+// Compute fingerprints a ClientHello.
+func Compute(hello *tls.ClientHelloInfo) Fingerprint {
+	if hello == nil {
+		return Fingerprint{}
+	}
+	return Fingerprint{JA3: JA3(hello), JA4: JA4(hello)}
+}
+
+// isGREASE reports whether v is a GREASE value (RFC 8701), which clients insert
+// randomly and fingerprints must ignore.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGREASE(values []uint16) []uint16 {
+	out := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// maxVersion returns the highest offered TLS version.
+func maxVersion(hello *tls.ClientHelloInfo) uint16 {
+	var highest uint16
+	for _, v := range withoutGREASE(hello.SupportedVersions) {
+		if v > highest {
+			highest = v
+		}
+	}
+	return highest
+}
+
+func joinDecimal(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(parts, "-")
+}
+
+// JA3 returns the JA3 fingerprint: the MD5 of
+// "version,ciphers,extensions,curves,point-formats". The ClientHello legacy
+// version is not exposed by crypto/tls, so TLS 1.3 clients report 771 (TLS 1.2)
+// as they do on the wire.
+func JA3(hello *tls.ClientHelloInfo) string {
+	version := maxVersion(hello)
+	if version > tls.VersionTLS12 {
+		version = tls.VersionTLS12
+	}
+	curves := make([]uint16, 0, len(hello.SupportedCurves))
+	for _, c := range hello.SupportedCurves {
+		curves = append(curves, uint16(c))
+	}
+	points := make([]uint16, 0, len(hello.SupportedPoints))
+	for _, p := range hello.SupportedPoints {
+		points = append(points, uint16(p))
+	}
+	raw := strings.Join([]string{
+		strconv.Itoa(int(version)),
+		joinDecimal(withoutGREASE(hello.CipherSuites)),
+		joinDecimal(withoutGREASE(hello.Extensions)),
+		joinDecimal(withoutGREASE(curves)),
+		joinDecimal(points),
+	}, ",")
+	sum := md5.Sum([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// JA4 returns the JA4 fingerprint of a TCP TLS client, e.g.
+// "t13d1516h2_8daaf6152771_e5627efa2ab1".
+func JA4(hello *tls.ClientHelloInfo) string {
+	var version string
+	switch maxVersion(hello) {
+	case tls.VersionTLS13:
+		version = "13"
+	case tls.VersionTLS12:
+		version = "12"
+	case tls.VersionTLS11:
+		version = "11"
+	case tls.VersionTLS10:
+		version = "10"
+	default:
+		version = "00"
+	}
+	sni := "i"
+	if hello.ServerName != "" {
+		sni = "d"
+	}
+	ciphers := withoutGREASE(hello.CipherSuites)
+	extensions := withoutGREASE(hello.Extensions)
+	alpn := "00"
+	if len(hello.SupportedProtos) > 0 && hello.SupportedProtos[0] != "" {
+		first := hello.SupportedProtos[0]
+		alpn = string(first[0]) + string(first[len(first)-1])
+	}
+	prefix := fmt.Sprintf("t%s%s%02d%02d%s", version, sni, min(len(ciphers), 99), min(len(extensions), 99), alpn)
+
+	sortedCiphers := append([]uint16(nil), ciphers...)
+	sort.Slice(sortedCiphers, func(i, j int) bool { return sortedCiphers[i] < sortedCiphers[j] })
+
+	sortedExtensions := make([]uint16, 0, len(extensions))
+	for _, e := range extensions {
+		if e != extensionServerName && e != extensionALPN {
+			sortedExtensions = append(sortedExtensions, e)
+		}
+	}
+	sort.Slice(sortedExtensions, func(i, j int) bool { return sortedExtensions[i] < sortedExtensions[j] })
+	extensionPart := joinHex(sortedExtensions)
+	if len(hello.SignatureSchemes) > 0 {
+		schemes := make([]uint16, 0, len(hello.SignatureSchemes))
+		for _, s := range hello.SignatureSchemes {
+			schemes = append(schemes, uint16(s))
+		}
+		extensionPart += "_" + joinHex(withoutGREASE(schemes))
+	}
+	return prefix + "_" + truncatedHash(joinHex(sortedCiphers), len(sortedCiphers) == 0) + "_" + truncatedHash(extensionPart, len(sortedExtensions) == 0)
+}
+
+func joinHex(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// truncatedHash returns the first 12 hex characters of the SHA-256 of s, or
+// zeros when there is nothing to hash.
+func truncatedHash(s string, empty bool) string {
+	if empty {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Registry remembers the fingerprint of every open TLS connection so HTTP
+// handlers can look it up by the request's remote address.
+type Registry struct {
+	mu    sync.RWMutex
+	conns map[string]Fingerprint
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{conns: make(map[string]Fingerprint)}
+}
+
+// GetConfigForClient records the ClientHello of a new connection. It is meant
+// for tls.Config.GetConfigForClient and keeps the server's configuration.
+func (r *Registry) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if hello != nil && hello.Conn != nil {
+		fp := Compute(hello)
+		r.mu.Lock()
+		r.conns[hello.Conn.RemoteAddr().String()] = fp
+		r.mu.Unlock()
+	}
+	return nil, nil
+}
+
+// ConnState forgets connections once they close. It is meant for http.Server.ConnState.
+func (r *Registry) ConnState(conn net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	r.mu.Lock()
+	delete(r.conns, conn.RemoteAddr().String())
+	r.mu.Unlock()
+}
+
+// Lookup returns the fingerprint of the connection with the given remote address.
+func (r *Registry) Lookup(remoteAddr string) (Fingerprint, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fp, ok := r.conns[remoteAddr]
+	return fp, ok
+}
+
+// Source resolves the fingerprint of a request, either from the registry of
+// directly terminated TLS connections or from a header set by a TLS-terminating
+// proxy in front of the server.
+type Source struct {
+	Registry  *Registry
+	Algorithm string
+	// Header, when set, is trusted as the fingerprint computed by a fronting proxy.
+	// The proxy must overwrite any client-supplied value.
+	Header string
+}
+
+// Fingerprint returns the request's fingerprint, or "" when it is unknown.
+func (s Source) Fingerprint(req *http.Request) string {
+	if s.Header != "" {
+		if v := strings.TrimSpace(req.Header.Get(s.Header)); v != "" {
+			return v
+		}
+	}
+	if s.Registry == nil || req.TLS == nil {
+		return ""
+	}
+	fp, _ := s.Registry.Lookup(req.RemoteAddr)
+	return fp.Get(s.Algorithm)
+}
@@ -0,0 +1,53 @@
+package tlsfingerprint
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+)
+
+func testHello() *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		CipherSuites:      []uint16{0x0a0a, tls.TLS_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		Extensions:        []uint16{0x1a1a, 0x0000, 0x0010, 0x000d, 0x002b, 0x000a},
+		SupportedCurves:   []tls.CurveID{0x2a2a, tls.X25519, tls.CurveP256},
+		SupportedPoints:   []uint8{0},
+		SupportedVersions: []uint16{0x3a3a, tls.VersionTLS13, tls.VersionTLS12},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256},
+		SupportedProtos:   []string{"h2", "http/1.1"},
+		ServerName:        "proxy.example.com",
+	}
+}
+
+func TestJA4(t *testing.T) {
+	ja4 := JA4(testHello())
+	parts := strings.Split(ja4, "_")
+	if len(parts) != 3 || parts[0] != "t13d0205h2" || len(parts[1]) != 12 || len(parts[2]) != 12 {
+		t.Fatalf("unexpected ja4 %q", ja4)
+	}
+
+	// GREASE values and cipher order must not change the fingerprint.
+	reordered := testHello()
+	reordered.CipherSuites = []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, 0x4a4a, tls.TLS_AES_128_GCM_SHA256}
+	if got := JA4(reordered); got != ja4 {
+		t.Fatalf("expected %q for reordered ciphers, got %q", ja4, got)
+	}
+
+	noSNI := testHello()
+	noSNI.ServerName = ""
+	if got := JA4(noSNI); !strings.HasPrefix(got, "t13i") {
+		t.Fatalf("expected i marker without SNI, got %q", got)
+	}
+}
+
+func TestJA3IgnoresGREASE(t *testing.T) {
+	hello := testHello()
+	ja3 := JA3(hello)
+	if len(ja3) != 32 {
+		t.Fatalf("unexpected ja3 %q", ja3)
+	}
+	hello.CipherSuites = append([]uint16{0x5a5a}, hello.CipherSuites[1:]...)
+	if got := JA3(hello); got != ja3 {
+		t.Fatalf("expected GREASE to be ignored, got %q want %q", got, ja3)
+	}
+}
@@ -0,0 +1,234 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultServiceName = "cli-proxy-api"
+	exportInterval     = 5 * time.Second
+	exportTimeout      = 10 * time.Second
+	maxBatchSize       = 512
+	queueSize          = 4096
+)
+
+// New creates a tracer from configuration. It returns nil when tracing is disabled.
+func New(cfg config.TracingConfig) *Tracer {
+	if !cfg.Enabled {
+		return nil
+	}
+	endpoint := strings.TrimSpace(cfg.Endpoint)
+	if endpoint == "" {
+		log.Warn("tracing: enabled but endpoint is empty, skipping")
+		return nil
+	}
+	serviceName := strings.TrimSpace(cfg.ServiceName)
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	ratio := 1.0
+	if cfg.SampleRatio != nil {
+		ratio = *cfg.SampleRatio
+	}
+	return &Tracer{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		headers:     cfg.Headers,
+		sampleRatio: ratio,
+		client:      &http.Client{Timeout: exportTimeout},
+		queue:       make(chan *Span, queueSize),
+	}
+}
+
+// Run exports finished spans in batches until ctx is done, with a final flush on exit.
+func (t *Tracer) Run(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	log.Infof("tracing: exporting spans to %s (sample ratio %g)", t.endpoint, t.sampleRatio)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, maxBatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(ctx, batch); err != nil {
+			log.Warnf("tracing: export of %d span(s) failed: %v", len(batch), err)
+		}
+		if dropped := t.dropped.Swap(0); dropped > 0 {
+			log.Warnf("tracing: dropped %d span(s), export queue full", dropped)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for drained := false; !drained; {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+				default:
+					drained = true
+				}
+			}
+			finalCtx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+			flush(finalCtx)
+			cancel()
+			return
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= maxBatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+func (t *Tracer) export(ctx context.Context, spans []*Span) error {
+	payload, err := json.Marshal(buildOTLP(t.serviceName, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			log.Debugf("tracing: failed to close response body: %v", errClose)
+		}
+	}()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned status %d: %s", t.endpoint, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// OTLP/HTTP JSON payload types (subset of opentelemetry-proto traces).
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+const (
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+func otlpValue(value any) otlpAnyValue {
+	switch v := value.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpAnyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpAnyValue{IntValue: &s}
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return otlpAnyValue{StringValue: &s}
+	}
+}
+
+func otlpAttributes(attributes map[string]any) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]otlpAttribute, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, otlpAttribute{Key: k, Value: otlpValue(attributes[k])})
+	}
+	return out
+}
+
+func buildOTLP(serviceName string, spans []*Span) map[string]any {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.context.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attributes),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.errMessage != "" {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: s.errMessage}
+		}
+		s.mu.Unlock()
+		out = append(out, span)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": serviceName}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "cliproxy"},
+				"spans": out,
+			}},
+		}},
+	}
+}
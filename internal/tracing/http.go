@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware starts a server span for every request, continuing the caller's
+// trace when it sends a traceparent header. Downstream handlers find the span
+// in the request context.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if Default() == nil {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		if remote, ok := ParseTraceparent(c.GetHeader(TraceparentHeader)); ok {
+			ctx = ContextWithRemoteParent(ctx, remote)
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := Start(ctx, c.Request.Method+" "+route, KindServer)
+		c.Request = c.Request.WithContext(ctx)
+		defer span.End()
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttribute("http.request.method", c.Request.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("url.path", c.Request.URL.Path)
+		span.SetAttribute("client.address", c.ClientIP())
+		span.SetAttribute("http.response.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetError("HTTP " + strconv.Itoa(status))
+		}
+	}
+}
+
+// Stage wraps a middleware in an internal span named name. The span ends when
+// the middleware hands over to the next handler, or when it returns if it
+// aborts or never calls Next. Register both returned handlers, in order.
+func Stage(name string, handler gin.HandlerFunc) []gin.HandlerFunc {
+	key := "tracing.stage." + name
+	start := func(c *gin.Context) {
+		_, span := Start(c.Request.Context(), name, KindInternal)
+		if span == nil {
+			handler(c)
+			return
+		}
+		c.Set(key, span)
+		handler(c)
+		if c.IsAborted() {
+			span.SetError("aborted with HTTP " + strconv.Itoa(c.Writer.Status()))
+		}
+		span.End()
+	}
+	end := func(c *gin.Context) {
+		if span, ok := c.Get(key); ok {
+			span.(*Span).End()
+		}
+	}
+	return []gin.HandlerFunc{start, end}
+}
+
+// Transport wraps base so that every upstream request gets a client span and
+// a traceparent header linking the provider's view to the proxy trace.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if _, ok := base.(*transport); ok {
+		return base
+	}
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if Default() == nil || SpanFromContext(req.Context()) == nil {
+		return t.base.RoundTrip(req)
+	}
+	ctx, span := Start(req.Context(), req.Method+" "+req.URL.Host, KindClient)
+	req = req.Clone(ctx)
+	req.Header.Set(TraceparentHeader, span.Context().Traceparent())
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("url.path", req.URL.Path)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err.Error())
+		span.End()
+		return nil, err
+	}
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetError("HTTP " + strconv.Itoa(resp.StatusCode))
+	}
+	// The span ends when the response headers arrive; streamed bodies are
+	// covered by the server span.
+	span.End()
+	return resp, nil
+}
@@ -0,0 +1,232 @@
+// Package tracing records request spans across the proxy path (gin handlers,
+// selected middleware and upstream HTTP calls), propagates W3C trace context
+// to upstream providers and exports the spans as OTLP/HTTP JSON, without
+// pulling in the OpenTelemetry SDK.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Span kinds, numbered as in the OTLP protocol.
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// TraceparentHeader is the W3C trace context header.
+const TraceparentHeader = "traceparent"
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Span is one timed operation. A nil *Span is valid and records nothing, so
+// callers need not check whether tracing is enabled.
+type Span struct {
+	tracer   *Tracer
+	context  SpanContext
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes map[string]any
+	errMessage string
+	ended      bool
+}
+
+// Context returns the span's identifiers.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttribute records a string, bool, int or float attribute.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil || !s.context.Sampled {
+		return
+	}
+	s.mu.Lock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]any)
+	}
+	s.attributes[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.errMessage = message
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.context.Sampled && s.tracer != nil {
+		s.tracer.enqueue(s)
+	}
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// ContextWithSpan returns ctx carrying span as the current span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent returns ctx carrying a parent received from a caller.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Inherit copies the current span of from into ctx. Handlers use it when they
+// derive a fresh context for upstream calls from context.Background.
+func Inherit(ctx, from context.Context) context.Context {
+	if span := SpanFromContext(from); span != nil && SpanFromContext(ctx) == nil {
+		return ContextWithSpan(ctx, span)
+	}
+	return ctx
+}
+
+// Tracer creates spans and exports finished ones in batches.
+type Tracer struct {
+	endpoint    string
+	serviceName string
+	headers     map[string]string
+	sampleRatio float64
+	client      *http.Client
+	queue       chan *Span
+	dropped     atomic.Uint64
+}
+
+var current atomic.Pointer[Tracer]
+
+// SetDefault installs the process-wide tracer; nil disables tracing.
+func SetDefault(t *Tracer) { current.Store(t) }
+
+// Default returns the process-wide tracer, or nil when tracing is disabled.
+func Default() *Tracer { return current.Load() }
+
+// Start begins a span named name as a child of the current or remote parent
+// of ctx. It returns ctx unchanged and a nil span when tracing is disabled.
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	t := Default()
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.context.TraceID = parent.context.TraceID
+		span.context.Sampled = parent.context.Sampled
+		span.parentID = parent.context.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok && remote.IsValid() {
+		span.context.TraceID = remote.TraceID
+		span.context.Sampled = remote.Sampled
+		span.parentID = remote.SpanID
+	} else {
+		_, _ = rand.Read(span.context.TraceID[:])
+		span.context.Sampled = t.sample(span.context.TraceID)
+	}
+	_, _ = rand.Read(span.context.SpanID[:])
+	return ContextWithSpan(ctx, span), span
+}
+
+// sample decides on new traces from the trace ID, so the decision is stable.
+func (t *Tracer) sample(traceID [16]byte) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	if t.sampleRatio <= 0 {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>11)/float64(1<<53) < t.sampleRatio
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case t.queue <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func TestParseTraceparentRoundTrip(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(header)
+	if !ok || !sc.Sampled || sc.Traceparent() != header {
+		t.Fatalf("ParseTraceparent = %+v, %v", sc, ok)
+	}
+	for _, invalid := range []string{"", "00-0000-00f067aa0ba902b7-01", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		if _, ok := ParseTraceparent(invalid); ok {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestProxyPathSpansAreLinkedAndExported(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		exported []map[string]any
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []map[string]any `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		_ = json.Unmarshal(body, &payload)
+		mu.Lock()
+		exported = append(exported, payload.ResourceSpans[0].ScopeSpans[0].Spans...)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get(TraceparentHeader)
+	}))
+	defer upstream.Close()
+
+	tracer := New(config.TracingConfig{Enabled: true, Endpoint: collector.URL})
+	SetDefault(tracer)
+	defer SetDefault(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { tracer.Run(ctx); close(done) }()
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Middleware())
+	engine.Use(Stage("device-binding", func(c *gin.Context) { c.Next() })...)
+	engine.POST("/v1/messages", func(c *gin.Context) {
+		client := &http.Client{Transport: Transport(nil)}
+		req, _ := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, upstream.URL+"/v1/messages", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("upstream: %v", err)
+			return
+		}
+		_ = resp.Body.Close()
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("exporter did not stop")
+	}
+
+	if !strings.HasPrefix(upstreamTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Fatalf("upstream traceparent = %q", upstreamTraceparent)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	byName := make(map[string]map[string]any)
+	for _, span := range exported {
+		if span["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Fatalf("span outside the caller's trace: %+v", span)
+		}
+		byName[span["name"].(string)] = span
+	}
+	server := byName["POST /v1/messages"]
+	stage := byName["device-binding"]
+	client := byName["POST "+strings.TrimPrefix(upstream.URL, "http://")]
+	if server == nil || stage == nil || client == nil {
+		t.Fatalf("exported spans = %+v", exported)
+	}
+	if server["parentSpanId"] != "00f067aa0ba902b7" || stage["parentSpanId"] != server["spanId"] || client["parentSpanId"] != server["spanId"] {
+		t.Fatalf("unexpected span tree: server=%v stage=%v client=%v", server, stage, client)
+	}
+	if !strings.Contains(upstreamTraceparent, client["spanId"].(string)) {
+		t.Fatalf("upstream traceparent %q does not name the client span", upstreamTraceparent)
+	}
+}
+
+func TestDisabledTracingIsNoop(t *testing.T) {
+	SetDefault(nil)
+	ctx, span := Start(context.Background(), "noop", KindInternal)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Fatal("expected no span when tracing is disabled")
+	}
+	span.SetAttribute("k", "v")
+	span.End()
+	if New(config.TracingConfig{Enabled: true}) != nil {
+		t.Fatal("expected no tracer without an endpoint")
+	}
+}
@@ -101,9 +101,12 @@ func ConvertOpenAIRequestToClaude(modelName string, inputRawJSON []byte, stream
 	// Model mapping to specify which Claude Code model to use
 	out, _ = sjson.Set(out, "model", modelName)
 
-	// Max tokens configuration with fallback to default value
+	// Max tokens configuration with fallback to default value. Newer OpenAI clients
+	// send max_completion_tokens instead of the deprecated max_tokens.
 	if maxTokens := root.Get("max_tokens"); maxTokens.Exists() {
 		out, _ = sjson.Set(out, "max_tokens", maxTokens.Int())
+	} else if maxTokens = root.Get("max_completion_tokens"); maxTokens.Exists() {
+		out, _ = sjson.Set(out, "max_tokens", maxTokens.Int())
 	}
 
 	// Temperature setting for controlling response randomness
@@ -136,18 +139,27 @@ func ConvertOpenAIRequestToClaude(modelName string, inputRawJSON []byte, stream
 	out, _ = sjson.Set(out, "stream", stream)
 
 	// Process messages and transform them to Claude Code format
+	// pendingToolResults is the path of the user message collecting consecutive tool results.
+	pendingToolResults := ""
 	if messages := root.Get("messages"); messages.Exists() && messages.IsArray() {
 		messages.ForEach(func(_, message gjson.Result) bool {
 			role := message.Get("role").String()
 			contentResult := message.Get("content")
 
+			if role != "tool" {
+				pendingToolResults = ""
+			}
+
 			switch role {
-			case "system", "user", "assistant":
-				// Create Claude Code message with appropriate role mapping
-				if role == "system" {
-					role = "user"
+			case "system", "developer":
+				// System and developer instructions map to the top-level system prompt
+				for _, text := range messageTexts(contentResult) {
+					part := `{"type":"text","text":""}`
+					part, _ = sjson.Set(part, "text", text)
+					out, _ = sjson.SetRaw(out, "system.-1", part)
 				}
 
+			case "user", "assistant":
 				msg := `{"role":"","content":[]}`
 				msg, _ = sjson.Set(msg, "role", role)
 
@@ -169,7 +181,11 @@ func ConvertOpenAIRequestToClaude(modelName string, inputRawJSON []byte, stream
 						case "image_url":
 							// Convert OpenAI image format to Claude Code format
 							imageURL := part.Get("image_url.url").String()
-							if strings.HasPrefix(imageURL, "data:") {
+							if strings.HasPrefix(imageURL, "http://") || strings.HasPrefix(imageURL, "https://") {
+								imagePart := `{"type":"image","source":{"type":"url","url":""}}`
+								imagePart, _ = sjson.Set(imagePart, "source.url", imageURL)
+								msg, _ = sjson.SetRaw(msg, "content.-1", imagePart)
+							} else if strings.HasPrefix(imageURL, "data:") {
 								// Extract base64 data and media type from data URL
 								parts := strings.Split(imageURL, ",")
 								if len(parts) == 2 {
@@ -228,14 +244,24 @@ func ConvertOpenAIRequestToClaude(modelName string, inputRawJSON []byte, stream
 				out, _ = sjson.SetRaw(out, "messages.-1", msg)
 
 			case "tool":
-				// Handle tool result messages conversion
-				toolCallID := message.Get("tool_call_id").String()
-				content := message.Get("content").String()
+				// Handle tool result messages conversion. Results of parallel tool calls
+				// arrive as consecutive tool messages and must share one user turn.
+				toolResult := `{"type":"tool_result","tool_use_id":"","content":""}`
+				toolResult, _ = sjson.Set(toolResult, "tool_use_id", message.Get("tool_call_id").String())
+				if contentResult.IsArray() {
+					toolResult, _ = sjson.Set(toolResult, "content", strings.Join(messageTexts(contentResult), "\n"))
+				} else {
+					toolResult, _ = sjson.Set(toolResult, "content", contentResult.String())
+				}
 
-				msg := `{"role":"user","content":[{"type":"tool_result","tool_use_id":"","content":""}]}`
-				msg, _ = sjson.Set(msg, "content.0.tool_use_id", toolCallID)
-				msg, _ = sjson.Set(msg, "content.0.content", content)
-				out, _ = sjson.SetRaw(out, "messages.-1", msg)
+				if pendingToolResults != "" {
+					out, _ = sjson.SetRaw(out, pendingToolResults+".content.-1", toolResult)
+				} else {
+					msg := `{"role":"user","content":[]}`
+					msg, _ = sjson.SetRaw(msg, "content.-1", toolResult)
+					out, _ = sjson.SetRaw(out, "messages.-1", msg)
+					pendingToolResults = fmt.Sprintf("messages.%d", len(gjson.Get(out, "messages").Array())-1)
+				}
 			}
 			return true
 		})
@@ -294,5 +320,36 @@ func ConvertOpenAIRequestToClaude(modelName string, inputRawJSON []byte, stream
 		}
 	}
 
+	// parallel_tool_calls=false maps to disabling parallel tool use on the tool choice
+	if parallel := root.Get("parallel_tool_calls"); parallel.Exists() && !parallel.Bool() && gjson.Get(out, "tools").Exists() && root.Get("tool_choice").String() != "none" {
+		if !gjson.Get(out, "tool_choice").Exists() {
+			out, _ = sjson.SetRaw(out, "tool_choice", `{"type":"auto"}`)
+		}
+		out, _ = sjson.Set(out, "tool_choice.disable_parallel_tool_use", true)
+	}
+
 	return []byte(out)
 }
+
+// messageTexts returns the text of an OpenAI message content, which is either a
+// string or an array of content parts.
+func messageTexts(content gjson.Result) []string {
+	if content.Type == gjson.String {
+		if text := content.String(); text != "" {
+			return []string{text}
+		}
+		return nil
+	}
+	var texts []string
+	if content.IsArray() {
+		content.ForEach(func(_, part gjson.Result) bool {
+			if part.Get("type").String() == "text" {
+				if text := part.Get("text").String(); text != "" {
+					texts = append(texts, text)
+				}
+			}
+			return true
+		})
+	}
+	return texts
+}
@@ -0,0 +1,61 @@
+package chat_completions
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestConvertOpenAIRequestToClaude_SystemAndParallelToolResults(t *testing.T) {
+	input := `{
+		"model": "gpt-4o",
+		"max_completion_tokens": 512,
+		"parallel_tool_calls": false,
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "developer", "content": [{"type": "text", "text": "Answer in English."}]},
+			{"role": "user", "content": [
+				{"type": "text", "text": "Weather in Paris and Rome?"},
+				{"type": "image_url", "image_url": {"url": "https://example.com/map.png"}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "weather", "arguments": "{\"city\":\"Paris\"}"}},
+				{"id": "call_2", "type": "function", "function": {"name": "weather", "arguments": "{\"city\":\"Rome\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "18C"},
+			{"role": "tool", "tool_call_id": "call_2", "content": [{"type": "text", "text": "24C"}]},
+			{"role": "user", "content": "Thanks"}
+		],
+		"tools": [{"type": "function", "function": {"name": "weather", "parameters": {"type": "object"}}}]
+	}`
+
+	out := gjson.ParseBytes(ConvertOpenAIRequestToClaude("claude-sonnet-4", []byte(input), false))
+
+	if got := out.Get("max_tokens").Int(); got != 512 {
+		t.Fatalf("expected max_completion_tokens to map to max_tokens, got %d", got)
+	}
+	if got := out.Get("system.#").Int(); got != 2 || out.Get("system.0.text").String() != "Be brief." || out.Get("system.1.text").String() != "Answer in English." {
+		t.Fatalf("expected system and developer messages in the system prompt, got %s", out.Get("system").Raw)
+	}
+	messages := out.Get("messages").Array()
+	if len(messages) != 4 {
+		t.Fatalf("expected 4 messages, got %d: %s", len(messages), out.Get("messages").Raw)
+	}
+	if src := messages[0].Get("content.1.source"); src.Get("type").String() != "url" || src.Get("url").String() != "https://example.com/map.png" {
+		t.Fatalf("expected URL image source, got %s", messages[0].Get("content").Raw)
+	}
+	if messages[1].Get("content.#").Int() != 2 || messages[1].Get("content.1.input.city").String() != "Rome" {
+		t.Fatalf("unexpected tool uses %s", messages[1].Get("content").Raw)
+	}
+	results := messages[2]
+	if results.Get("role").String() != "user" || results.Get("content.#").Int() != 2 ||
+		results.Get("content.0.tool_use_id").String() != "call_1" || results.Get("content.1.content").String() != "24C" {
+		t.Fatalf("expected both tool results in one user message, got %s", results.Raw)
+	}
+	if messages[3].Get("content.0.text").String() != "Thanks" {
+		t.Fatalf("unexpected final message %s", messages[3].Raw)
+	}
+	if !out.Get("tool_choice.disable_parallel_tool_use").Bool() {
+		t.Fatalf("expected parallel tool use to be disabled, got %s", out.Get("tool_choice").Raw)
+	}
+}
@@ -0,0 +1,278 @@
+// Package trial provides anonymous access for public demo deployments. It
+// issues ephemeral, heavily rate-limited virtual keys to unauthenticated users.
+// A key is an HMAC over the client IP, a fingerprint header and an expiry, so
+// it needs no storage and only works from the client it was issued to. Trial
+// keys then flow through device binding, limits and usage like normal keys.
+package trial
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/limits"
+	log "github.com/sirupsen/logrus"
+)
+
+// ProviderName is the accessProvider context value of requests authenticated with a trial key.
+const ProviderName = "trial"
+
+const (
+	defaultKeyPrefix         = "trial-"
+	defaultTTL               = time.Hour
+	defaultRequestsPerMinute = 2
+	defaultDailyRequests     = 20
+	defaultMaxKeysPerIP      = 3
+	defaultFingerprint       = "User-Agent"
+)
+
+// Trial issues and validates trial keys.
+type Trial struct {
+	mu                sync.RWMutex
+	enabled           bool
+	secret            []byte
+	prefix            string
+	ttl               time.Duration
+	maxKeysPerIP      int
+	fingerprintHeader string
+
+	limiter    *limits.Limiter
+	limiterMW  gin.HandlerFunc
+	issuedMu   sync.Mutex
+	issued     map[issueWindow]map[string]struct{}
+	randSecret []byte
+	now        func() time.Time
+}
+
+// issueWindow identifies the keys issued to one IP in one TTL window.
+type issueWindow struct {
+	expiresAt time.Time
+	ip        string
+}
+
+// New creates trial access from configuration.
+func New(cfg config.TrialConfig) *Trial {
+	t := &Trial{
+		limiter: limits.New(limits.Config{}),
+		issued:  make(map[issueWindow]map[string]struct{}),
+		now:     time.Now,
+	}
+	t.randSecret = make([]byte, 32)
+	if _, err := rand.Read(t.randSecret); err != nil {
+		log.Errorf("trial: failed to generate signing secret: %v", err)
+	}
+	t.limiterMW = t.limiter.Middleware()
+	t.Update(cfg)
+	return t
+}
+
+// Update replaces the configuration. Changing the secret or prefix invalidates issued keys.
+func (t *Trial) Update(cfg config.TrialConfig) {
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		secret = t.randSecret
+	}
+	prefix := strings.TrimSpace(cfg.KeyPrefix)
+	if prefix == "" {
+		prefix = defaultKeyPrefix
+	}
+	ttl := time.Duration(cfg.TTL) * time.Second
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	limit := limits.Limit{RequestsPerMinute: cfg.RequestsPerMinute, DailyRequests: cfg.DailyRequests}
+	if limit.RequestsPerMinute <= 0 {
+		limit.RequestsPerMinute = defaultRequestsPerMinute
+	}
+	if limit.DailyRequests <= 0 {
+		limit.DailyRequests = defaultDailyRequests
+	}
+	maxKeys := cfg.MaxKeysPerIP
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeysPerIP
+	}
+	header := strings.TrimSpace(cfg.FingerprintHeader)
+	if header == "" {
+		header = defaultFingerprint
+	}
+
+	t.mu.Lock()
+	t.enabled = cfg.Enabled
+	t.secret = secret
+	t.prefix = prefix
+	t.ttl = ttl
+	t.maxKeysPerIP = maxKeys
+	t.fingerprintHeader = header
+	t.mu.Unlock()
+	t.limiter.Update(limits.Config{Enabled: cfg.Enabled, Default: limit})
+}
+
+// Enabled reports whether trial access is on.
+func (t *Trial) Enabled() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.enabled
+}
+
+// Issue returns the trial key for the request's client and its expiry. The
+// same client gets the same key within one TTL window.
+func (t *Trial) Issue(r *http.Request, clientIP string) (string, time.Time) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	now := t.now()
+	window := now.Truncate(t.ttl)
+	expiresAt := window.Add(t.ttl)
+	return t.sign(clientIP, r.Header.Get(t.fingerprintHeader), expiresAt), expiresAt
+}
+
+// Validate reports whether key is an unexpired trial key issued to this client.
+func (t *Trial) Validate(r *http.Request, clientIP, key string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if !t.enabled || !strings.HasPrefix(key, t.prefix) {
+		return false
+	}
+	expiry, _, found := strings.Cut(strings.TrimPrefix(key, t.prefix), ".")
+	if !found {
+		return false
+	}
+	unix, err := strconv.ParseInt(expiry, 36, 64)
+	if err != nil {
+		return false
+	}
+	expiresAt := time.Unix(unix, 0)
+	if !t.now().Before(expiresAt) || expiresAt.Sub(t.now()) > t.ttl {
+		return false
+	}
+	expected := t.sign(clientIP, r.Header.Get(t.fingerprintHeader), expiresAt)
+	return hmac.Equal([]byte(expected), []byte(key))
+}
+
+// Limit returns the rate limit applied to every trial key.
+func (t *Trial) Limit() limits.Limit {
+	limit, _, _ := t.limiter.Effective("")
+	return limit
+}
+
+// IsTrialKey reports whether key carries the trial prefix.
+func (t *Trial) IsTrialKey(key string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.enabled && strings.HasPrefix(key, t.prefix)
+}
+
+// sign builds a key. Callers must hold t.mu.
+func (t *Trial) sign(clientIP, fingerprint string, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 36)
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(clientIP + "\n" + fingerprint + "\n" + expiry))
+	return t.prefix + expiry + "." + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// allowIssue records key for the client IP and reports whether the IP is within
+// its per-window key allowance.
+func (t *Trial) allowIssue(clientIP, key string, expiresAt time.Time) bool {
+	t.mu.RLock()
+	maxKeys := t.maxKeysPerIP
+	t.mu.RUnlock()
+
+	t.issuedMu.Lock()
+	defer t.issuedMu.Unlock()
+	now := t.now()
+	for w := range t.issued {
+		if !now.Before(w.expiresAt) {
+			delete(t.issued, w)
+		}
+	}
+	w := issueWindow{expiresAt: expiresAt, ip: clientIP}
+	keys := t.issued[w]
+	if _, ok := keys[key]; ok {
+		return true
+	}
+	if len(keys) >= maxKeys {
+		return false
+	}
+	if keys == nil {
+		keys = make(map[string]struct{})
+		t.issued[w] = keys
+	}
+	keys[key] = struct{}{}
+	return true
+}
+
+// IssueKey hands out a trial key to an unauthenticated client.
+// POST /v0/trial/key
+func (t *Trial) IssueKey(c *gin.Context) {
+	if !t.Enabled() {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	clientIP := c.ClientIP()
+	key, expiresAt := t.Issue(c.Request, clientIP)
+	if !t.allowIssue(clientIP, key, expiresAt) {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":   "trial_limit_exceeded",
+			"message": "Too many trial keys were issued to this address; try again later",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"api_key":    key,
+		"expires_at": expiresAt.UTC(),
+		"note":       "Trial keys only work from this address and client, and are heavily rate limited.",
+	})
+}
+
+// Authenticate wraps an API key authentication middleware: requests bearing a
+// trial key are authenticated as that key, everything else is passed to next.
+func (t *Trial) Authenticate(next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := t.requestKey(c.Request)
+		if key == "" {
+			next(c)
+			return
+		}
+		if !t.Validate(c.Request, c.ClientIP(), key) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired trial key"})
+			return
+		}
+		c.Set("apiKey", key)
+		c.Set("accessProvider", ProviderName)
+		c.Next()
+	}
+}
+
+// Middleware applies the trial rate limits to requests authenticated with a trial key.
+func (t *Trial) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("accessProvider") != ProviderName {
+			c.Next()
+			return
+		}
+		t.limiterMW(c)
+	}
+}
+
+// requestKey returns the first trial key among the credentials the inline API
+// key provider accepts, or "" when the request carries none.
+func (t *Trial) requestKey(r *http.Request) string {
+	bearer := strings.TrimSpace(r.Header.Get("Authorization"))
+	if scheme, value, found := strings.Cut(bearer, " "); found && strings.EqualFold(scheme, "bearer") {
+		bearer = strings.TrimSpace(value)
+	}
+	query := r.URL.Query()
+	for _, candidate := range []string{bearer, r.Header.Get("X-Goog-Api-Key"), r.Header.Get("X-Api-Key"), query.Get("key"), query.Get("auth_token")} {
+		if candidate != "" && t.IsTrialKey(candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
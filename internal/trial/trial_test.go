@@ -0,0 +1,94 @@
+package trial
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+)
+
+func newTestEngine(tr *Trial) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/v0/trial/key", tr.IssueKey)
+	reject := func(c *gin.Context) { c.AbortWithStatus(http.StatusUnauthorized) }
+	engine.GET("/v1/models", tr.Authenticate(reject), tr.Middleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("apiKey"))
+	})
+	return engine
+}
+
+func issue(t *testing.T, engine *gin.Engine, ip, agent string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v0/trial/key", nil)
+	req.RemoteAddr = ip + ":1234"
+	req.Header.Set("User-Agent", agent)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	var body struct {
+		APIKey string `json:"api_key"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec.Code, body.APIKey
+}
+
+func call(engine *gin.Engine, ip, agent, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	req.RemoteAddr = ip + ":1234"
+	req.Header.Set("User-Agent", agent)
+	req.Header.Set("Authorization", "Bearer "+key)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTrialKeysAreScopedAndLimited(t *testing.T) {
+	tr := New(config.TrialConfig{Enabled: true, Secret: "s3cret", RequestsPerMinute: 2, MaxKeysPerIP: 2})
+	engine := newTestEngine(tr)
+
+	code, key := issue(t, engine, "203.0.113.7", "demo/1.0")
+	if code != http.StatusOK || key == "" {
+		t.Fatalf("expected a trial key, got %d %q", code, key)
+	}
+	if _, again := issue(t, engine, "203.0.113.7", "demo/1.0"); again != key {
+		t.Fatalf("expected the same key within the window, got %q and %q", key, again)
+	}
+
+	if rec := call(engine, "203.0.113.7", "demo/1.0", key); rec.Code != http.StatusOK || rec.Body.String() != key {
+		t.Fatalf("expected trial key to authenticate, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := call(engine, "198.51.100.1", "demo/1.0", key); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected key to be rejected from another IP, got %d", rec.Code)
+	}
+	if rec := call(engine, "203.0.113.7", "other/2.0", key); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected key to be rejected from another client, got %d", rec.Code)
+	}
+	call(engine, "203.0.113.7", "demo/1.0", key)
+	if rec := call(engine, "203.0.113.7", "demo/1.0", key); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected trial rate limit, got %d", rec.Code)
+	}
+	if rec := call(engine, "203.0.113.7", "demo/1.0", "sk-regular"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected non-trial keys to go to the wrapped middleware, got %d", rec.Code)
+	}
+
+	issue(t, engine, "203.0.113.7", "agent-2")
+	if code, _ = issue(t, engine, "203.0.113.7", "agent-3"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected per-IP issuance cap, got %d", code)
+	}
+
+	tr.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if rec := call(engine, "203.0.113.7", "demo/1.0", key); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected expired key to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestIssueKeyDisabled(t *testing.T) {
+	engine := newTestEngine(New(config.TrialConfig{}))
+	if code, _ := issue(t, engine, "203.0.113.7", "demo/1.0"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 while disabled, got %d", code)
+	}
+}
@@ -0,0 +1,158 @@
+package usage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/degraded"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// KeyUsageDayLayout formats the UTC day of a key usage row.
+const KeyUsageDayLayout = "2006-01-02"
+
+// DefaultKeyUsageFlushInterval is how often buffered key usage is written to the store.
+const DefaultKeyUsageFlushInterval = 30 * time.Second
+
+type keyUsageID struct {
+	day, apiKey, model string
+}
+
+// KeyUsageRecorder accounts input and output tokens per client key, model and
+// UTC day. Records are buffered in memory and flushed to the store in batches;
+// without a store the buffer is the only copy and is lost on restart.
+type KeyUsageRecorder struct {
+	store device.KeyUsageStore
+
+	mu      sync.Mutex
+	pending map[keyUsageID]device.KeyUsage
+}
+
+// NewKeyUsageRecorder creates a recorder persisting to store, which may be nil.
+func NewKeyUsageRecorder(store device.KeyUsageStore) *KeyUsageRecorder {
+	return &KeyUsageRecorder{store: store, pending: make(map[keyUsageID]device.KeyUsage)}
+}
+
+// HandleUsage implements coreusage.Plugin.
+func (r *KeyUsageRecorder) HandleUsage(_ context.Context, record coreusage.Record) {
+	if r == nil || record.APIKey == "" || IsExcludedAPIKey(record.APIKey) {
+		return
+	}
+	at := record.RequestedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	model := strings.TrimSpace(record.Model)
+	if model == "" {
+		model = "unknown"
+	}
+	entry := device.KeyUsage{
+		Day:          at.UTC().Format(KeyUsageDayLayout),
+		APIKey:       record.APIKey,
+		Model:        model,
+		Requests:     1,
+		InputTokens:  record.Detail.InputTokens,
+		OutputTokens: record.Detail.OutputTokens,
+	}
+	r.mu.Lock()
+	r.addLocked(entry)
+	r.mu.Unlock()
+}
+
+func (r *KeyUsageRecorder) addLocked(entry device.KeyUsage) {
+	id := keyUsageID{entry.Day, entry.APIKey, entry.Model}
+	row, ok := r.pending[id]
+	if !ok {
+		row = device.KeyUsage{Day: entry.Day, APIKey: entry.APIKey, Model: entry.Model}
+	}
+	row.Add(entry)
+	r.pending[id] = row
+}
+
+// Flush writes buffered usage to the store. Failed batches stay buffered for
+// the next flush.
+func (r *KeyUsageRecorder) Flush() error {
+	if r == nil || r.store == nil {
+		return nil
+	}
+	r.mu.Lock()
+	batch := make([]device.KeyUsage, 0, len(r.pending))
+	for _, row := range r.pending {
+		batch = append(batch, row)
+	}
+	r.pending = make(map[keyUsageID]device.KeyUsage)
+	r.mu.Unlock()
+
+	err := r.store.AddKeyUsage(batch)
+	degraded.Default().Report(degraded.ComponentKeyUsage, err)
+	if err != nil {
+		r.mu.Lock()
+		for _, row := range batch {
+			r.addLocked(row)
+		}
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run flushes buffered usage every interval until ctx is cancelled.
+func (r *KeyUsageRecorder) Run(ctx context.Context, interval time.Duration) {
+	if r == nil || r.store == nil {
+		return
+	}
+	if interval <= 0 {
+		interval = DefaultKeyUsageFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				log.Warnf("usage: failed to persist key usage: %v", err)
+			}
+		}
+	}
+}
+
+// Query returns persisted and buffered usage matching filter, ordered by day,
+// key and model.
+func (r *KeyUsageRecorder) Query(filter device.KeyUsageFilter) ([]device.KeyUsage, error) {
+	merged := make(map[keyUsageID]device.KeyUsage)
+	if r.store != nil {
+		rows, err := r.store.KeyUsage(filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			merged[keyUsageID{row.Day, row.APIKey, row.Model}] = row
+		}
+	}
+	r.mu.Lock()
+	for id, row := range r.pending {
+		if !filter.Matches(row) {
+			continue
+		}
+		existing, ok := merged[id]
+		if !ok {
+			existing = device.KeyUsage{Day: row.Day, APIKey: row.APIKey, Model: row.Model}
+		}
+		existing.Add(row)
+		merged[id] = existing
+	}
+	r.mu.Unlock()
+
+	out := make([]device.KeyUsage, 0, len(merged))
+	for _, row := range merged {
+		out = append(out, row)
+	}
+	device.SortKeyUsage(out)
+	return out, nil
+}
@@ -0,0 +1,62 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+type memoryKeyUsageStore struct {
+	rows []device.KeyUsage
+	fail bool
+}
+
+func (s *memoryKeyUsageStore) AddKeyUsage(entries []device.KeyUsage) error {
+	if s.fail {
+		return errors.New("store unavailable")
+	}
+	s.rows = append(s.rows, entries...)
+	return nil
+}
+
+func (s *memoryKeyUsageStore) KeyUsage(filter device.KeyUsageFilter) ([]device.KeyUsage, error) {
+	var out []device.KeyUsage
+	for _, row := range s.rows {
+		if filter.Matches(row) {
+			out = append(out, row)
+		}
+	}
+	return out, nil
+}
+
+func TestKeyUsageRecorderMergesBufferedAndPersistedUsage(t *testing.T) {
+	store := &memoryKeyUsageStore{fail: true}
+	r := NewKeyUsageRecorder(store)
+	day := time.Date(2025, 3, 4, 23, 30, 0, 0, time.UTC)
+	record := coreusage.Record{APIKey: "k1", Model: "claude", RequestedAt: day, Detail: coreusage.Detail{InputTokens: 100, OutputTokens: 20}}
+
+	r.HandleUsage(context.Background(), record)
+	if err := r.Flush(); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	store.fail = false
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	r.HandleUsage(context.Background(), record)
+
+	rows, err := r.Query(device.KeyUsageFilter{From: "2025-03-04", To: "2025-03-04", APIKey: "k1"})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(rows) != 1 || rows[0].Requests != 2 || rows[0].InputTokens != 200 || rows[0].OutputTokens != 40 {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+	if rows, _ = r.Query(device.KeyUsageFilter{From: "2025-03-05"}); len(rows) != 0 {
+		t.Fatalf("expected no rows after the range, got %+v", rows)
+	}
+}
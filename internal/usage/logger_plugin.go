@@ -12,11 +12,15 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/limits"
 	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
 )
 
 var statisticsEnabled atomic.Bool
 
+// excludedAPIKeys holds client keys whose usage is never recorded (e.g. the synthetic probe key).
+var excludedAPIKeys atomic.Value // map[string]struct{}
+
 func init() {
 	statisticsEnabled.Store(true)
 	coreusage.RegisterPlugin(NewLoggerPlugin())
@@ -47,9 +51,33 @@ func (p *LoggerPlugin) HandleUsage(ctx context.Context, record coreusage.Record)
 	if p == nil || p.stats == nil {
 		return
 	}
+	if IsExcludedAPIKey(record.APIKey) {
+		return
+	}
 	p.stats.Record(ctx, record)
 }
 
+// SetExcludedAPIKeys replaces the set of client keys excluded from usage accounting.
+func SetExcludedAPIKeys(keys ...string) {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			set[key] = struct{}{}
+		}
+	}
+	excludedAPIKeys.Store(set)
+}
+
+// IsExcludedAPIKey reports whether usage for the given client key is excluded from accounting.
+func IsExcludedAPIKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	set, _ := excludedAPIKeys.Load().(map[string]struct{})
+	_, excluded := set[key]
+	return excluded
+}
+
 // SetStatisticsEnabled toggles whether in-memory statistics are recorded.
 func SetStatisticsEnabled(enabled bool) { statisticsEnabled.Store(enabled) }
 
@@ -75,9 +103,12 @@ type RequestStatistics struct {
 
 // apiStats holds aggregated metrics for a single API key.
 type apiStats struct {
-	TotalRequests int64
-	TotalTokens   int64
-	Models        map[string]*modelStats
+	TotalRequests   int64
+	TotalTokens     int64
+	OverageRequests int64
+	OverageTokens   int64
+	BillableTokens  float64
+	Models          map[string]*modelStats
 }
 
 // modelStats holds aggregated metrics for a specific model within an API.
@@ -98,6 +129,9 @@ type RequestDetail struct {
 	AuthIndex string     `json:"auth_index"`
 	Tokens    TokenStats `json:"tokens"`
 	Failed    bool       `json:"failed"`
+	// Overage marks requests served beyond a soft quota; CostMultiplier weights them in billable tokens.
+	Overage        bool    `json:"overage,omitempty"`
+	CostMultiplier float64 `json:"cost_multiplier,omitempty"`
 }
 
 // TokenStats captures the token usage breakdown for a request.
@@ -126,9 +160,12 @@ type StatisticsSnapshot struct {
 
 // APISnapshot summarises metrics for a single API key.
 type APISnapshot struct {
-	TotalRequests int64                    `json:"total_requests"`
-	TotalTokens   int64                    `json:"total_tokens"`
-	Models        map[string]ModelSnapshot `json:"models"`
+	TotalRequests   int64                    `json:"total_requests"`
+	TotalTokens     int64                    `json:"total_tokens"`
+	OverageRequests int64                    `json:"overage_requests,omitempty"`
+	OverageTokens   int64                    `json:"overage_tokens,omitempty"`
+	BillableTokens  float64                  `json:"billable_tokens,omitempty"`
+	Models          map[string]ModelSnapshot `json:"models"`
 }
 
 // ModelSnapshot summarises metrics for a specific model.
@@ -176,6 +213,7 @@ func (s *RequestStatistics) Record(ctx context.Context, record coreusage.Record)
 	if !failed {
 		failed = !resolveSuccess(ctx)
 	}
+	overageMultiplier, overage := resolveOverage(ctx)
 	success := !failed
 	modelName := record.Model
 	if modelName == "" {
@@ -201,11 +239,13 @@ func (s *RequestStatistics) Record(ctx context.Context, record coreusage.Record)
 		s.apis[statsKey] = stats
 	}
 	s.updateAPIStats(stats, modelName, RequestDetail{
-		Timestamp: timestamp,
-		Source:    record.Source,
-		AuthIndex: record.AuthIndex,
-		Tokens:    detail,
-		Failed:    failed,
+		Timestamp:      timestamp,
+		Source:         record.Source,
+		AuthIndex:      record.AuthIndex,
+		Tokens:         detail,
+		Failed:         failed,
+		Overage:        overage,
+		CostMultiplier: overageMultiplier,
 	})
 
 	s.requestsByDay[dayKey]++
@@ -217,6 +257,15 @@ func (s *RequestStatistics) Record(ctx context.Context, record coreusage.Record)
 func (s *RequestStatistics) updateAPIStats(stats *apiStats, model string, detail RequestDetail) {
 	stats.TotalRequests++
 	stats.TotalTokens += detail.Tokens.TotalTokens
+	multiplier := 1.0
+	if detail.Overage {
+		stats.OverageRequests++
+		stats.OverageTokens += detail.Tokens.TotalTokens
+		if detail.CostMultiplier > 0 {
+			multiplier = detail.CostMultiplier
+		}
+	}
+	stats.BillableTokens += float64(detail.Tokens.TotalTokens) * multiplier
 	modelStatsValue, ok := stats.Models[model]
 	if !ok {
 		modelStatsValue = &modelStats{}
@@ -250,9 +299,12 @@ func (s *RequestStatistics) Snapshot() StatisticsSnapshot {
 	result.APIs = make(map[string]APISnapshot, len(s.apis))
 	for apiName, stats := range s.apis {
 		apiSnapshot := APISnapshot{
-			TotalRequests: stats.TotalRequests,
-			TotalTokens:   stats.TotalTokens,
-			Models:        make(map[string]ModelSnapshot, len(stats.Models)),
+			TotalRequests:   stats.TotalRequests,
+			TotalTokens:     stats.TotalTokens,
+			OverageRequests: stats.OverageRequests,
+			OverageTokens:   stats.OverageTokens,
+			BillableTokens:  stats.BillableTokens,
+			Models:          make(map[string]ModelSnapshot, len(stats.Models)),
 		}
 		for modelName, modelStatsValue := range stats.Models {
 			requestDetails := make([]RequestDetail, len(modelStatsValue.Details))
@@ -428,6 +480,22 @@ func resolveAPIIdentifier(ctx context.Context, record coreusage.Record) string {
 	return "unknown"
 }
 
+// resolveOverage returns the cost multiplier when the request was served beyond a soft quota.
+func resolveOverage(ctx context.Context) (float64, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil {
+		return 0, false
+	}
+	multiplier, ok := ginCtx.Value(limits.OverageContextKey).(float64)
+	if !ok {
+		return 0, false
+	}
+	return multiplier, true
+}
+
 func resolveSuccess(ctx context.Context) bool {
 	if ctx == nil {
 		return true
@@ -0,0 +1,81 @@
+package usagewebhook
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// RegisterRoutes registers the usage webhook management routes on a group
+// that already has management authentication applied.
+func (s *Sender) RegisterRoutes(group *gin.RouterGroup) {
+	group.GET("/usage-webhooks/deliveries", s.GetDeliveries)
+	group.POST("/usage-webhooks/replay", s.PostReplay)
+}
+
+// GetDeliveries lists outbox entries, newest first
+// GET /v0/management/usage-webhooks/deliveries?status=failed&limit=100
+func (s *Sender) GetDeliveries(c *gin.Context) {
+	status := strings.TrimSpace(c.Query("status"))
+	switch status {
+	case "", StatusPending, StatusDelivered, StatusFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_parameter", "message": "status must be pending, delivered or failed"})
+		return
+	}
+	limit := 0
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_parameter", "message": "limit must be a non-negative integer"})
+			return
+		}
+		limit = n
+	}
+	deliveries, err := s.List(ListFilter{Status: status, Limit: limit})
+	if err != nil {
+		log.Errorf("usage-webhooks: failed to list deliveries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal_error", "message": "Failed to list deliveries"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// replayRequest is the body of a replay request
+type replayRequest struct {
+	IDs      []int64   `json:"ids"`
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	Endpoint string    `json:"endpoint"`
+}
+
+// PostReplay sends outbox entries again, e.g. after the billing system lost data
+// POST /v0/management/usage-webhooks/replay  {"ids": [1, 2]}
+// POST /v0/management/usage-webhooks/replay  {"since": "2024-05-01T00:00:00Z", "until": "...", "endpoint": "https://..."}
+func (s *Sender) PostReplay(c *gin.Context) {
+	var req replayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_body", "message": "Invalid replay request: " + err.Error()})
+		return
+	}
+	if len(req.IDs) == 0 && req.Since.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_body", "message": "ids or since is required"})
+		return
+	}
+	if !req.Until.IsZero() && !req.Until.After(req.Since) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_body", "message": "until must be after since"})
+		return
+	}
+	queued, err := s.Replay(ReplayFilter{IDs: req.IDs, Since: req.Since, Until: req.Until, Endpoint: strings.TrimSpace(req.Endpoint)})
+	if err != nil {
+		log.Errorf("usage-webhooks: failed to replay deliveries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal_error", "message": "Failed to replay deliveries"})
+		return
+	}
+	log.Infof("usage-webhooks: queued %d delivery(ies) for replay by admin", queued)
+	c.JSON(http.StatusOK, gin.H{"queued": queued})
+}
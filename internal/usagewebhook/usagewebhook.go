@@ -0,0 +1,651 @@
+// Package usagewebhook posts the usage of completed requests (key, model,
+// tokens and cost) to external billing and CRM systems, so they stay in sync
+// without polling the usage APIs. Deliveries are written to a SQLite outbox
+// before they are sent, retried with exponential backoff and kept for a
+// retention period so they can be replayed. Receivers get every delivery at
+// least once and deduplicate on its delivery ID.
+package usagewebhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/notify/webhook"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultFileName is the outbox database used when no path is configured.
+const DefaultFileName = "usage-webhooks.db"
+
+const (
+	// DeliveryHeader carries the delivery ID, stable across retries and replays.
+	DeliveryHeader = "X-Webhook-Delivery"
+	// EventType is sent in the webhook.EventHeader of every usage delivery.
+	EventType = "usage"
+
+	defaultMaxAttempts   = 10
+	defaultRetentionDays = 7
+	requestTimeout       = 10 * time.Second
+	pollInterval         = time.Second
+	baseBackoff          = time.Second
+	maxBackoff           = 10 * time.Minute
+	deliveryBatchSize    = 50
+	pruneEvery           = time.Hour
+	dbTimeout            = 5 * time.Second
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// timeLayout has a fixed width so stored times compare correctly as text.
+const timeLayout = "2006-01-02T15:04:05.000000000Z"
+
+const schema = `CREATE TABLE IF NOT EXISTS usage_webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	delivery_id TEXT NOT NULL,
+	endpoint TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	records INTEGER NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TEXT NOT NULL,
+	last_error TEXT NOT NULL DEFAULT '',
+	delivered_at TEXT NOT NULL DEFAULT ''
+)`
+
+var deliveryAttempts = metrics.Default().NewCounterVec(
+	"cliproxy_usage_webhook_attempts_total",
+	"Usage webhook delivery attempts by outcome (delivered, retry, failed).",
+	"outcome",
+)
+
+// Record is the usage of one completed request. The API key is masked;
+// KeyHash, the hex SHA-256 of the key, identifies it without revealing it.
+type Record struct {
+	RequestedAt     time.Time         `json:"requested_at"`
+	APIKey          string            `json:"api_key"`
+	KeyHash         string            `json:"key_hash"`
+	KeyMetadata     map[string]string `json:"key_metadata,omitempty"`
+	Provider        string            `json:"provider,omitempty"`
+	Model           string            `json:"model"`
+	InputTokens     int64             `json:"input_tokens"`
+	OutputTokens    int64             `json:"output_tokens"`
+	ReasoningTokens int64             `json:"reasoning_tokens,omitempty"`
+	CachedTokens    int64             `json:"cached_tokens,omitempty"`
+	TotalTokens     int64             `json:"total_tokens"`
+	CostUSD         float64           `json:"cost_usd"`
+	Failed          bool              `json:"failed,omitempty"`
+}
+
+// Payload is the JSON body of one delivery.
+type Payload struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Records   []Record  `json:"records"`
+}
+
+// Delivery is one outbox entry: a payload addressed to one endpoint.
+type Delivery struct {
+	ID          int64      `json:"id"`
+	DeliveryID  string     `json:"delivery_id"`
+	Endpoint    string     `json:"endpoint"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Records     int        `json:"records"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// Options supplies the data a Record is enriched with.
+type Options struct {
+	// Cost prices a request in USD; nil reports a cost of 0.
+	Cost func(model string, inputTokens, outputTokens int64) float64
+	// Metadata returns the admin-defined metadata of a key (customer ID, CRM link); may be nil.
+	Metadata func(apiKey string) map[string]string
+}
+
+type settings struct {
+	endpoints     map[string]string // URL -> secret
+	order         []string
+	batchInterval time.Duration
+	maxAttempts   int
+	retention     time.Duration
+}
+
+// Sender collects usage records and delivers them through the outbox.
+type Sender struct {
+	db     *sql.DB
+	client *http.Client
+	opts   Options
+	wake   chan struct{}
+	now    func() time.Time
+
+	mu        sync.Mutex
+	settings  settings
+	buffer    []Record
+	closed    bool
+	lastFlush time.Time
+	lastPrune time.Time
+
+	// runMu serialises delivery rounds with Close.
+	runMu sync.Mutex
+}
+
+// New opens the outbox and creates a sender. It returns nil when usage
+// webhooks are disabled or no endpoint is configured.
+func New(cfg *config.Config, opts Options) (*Sender, error) {
+	if cfg == nil || !cfg.UsageWebhooks.Enabled {
+		return nil, nil
+	}
+	applied := buildSettings(cfg.UsageWebhooks)
+	if len(applied.endpoints) == 0 {
+		log.Warn("usage-webhooks: enabled but no endpoints configured, skipping")
+		return nil, nil
+	}
+	path := strings.TrimSpace(cfg.UsageWebhooks.Path)
+	if path == "" {
+		path = DefaultFileName
+	}
+	db, err := openOutbox(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Sender{
+		db:        db,
+		client:    util.SetProxy(&cfg.SDKConfig, &http.Client{Timeout: requestTimeout}),
+		opts:      opts,
+		wake:      make(chan struct{}, 1),
+		now:       time.Now,
+		settings:  applied,
+		lastFlush: time.Now(),
+	}, nil
+}
+
+func openOutbox(path string) (*sql.DB, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("usage-webhooks: create directory: %w", err)
+		}
+	}
+	params := url.Values{}
+	params.Set("_journal_mode", "WAL")
+	params.Set("_busy_timeout", "5000")
+	params.Set("_synchronous", "NORMAL")
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("usage-webhooks: open outbox: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	for _, stmt := range []string{
+		schema,
+		`CREATE INDEX IF NOT EXISTS idx_usage_webhook_deliveries_due ON usage_webhook_deliveries (status, next_attempt_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_usage_webhook_deliveries_created_at ON usage_webhook_deliveries (created_at)`,
+	} {
+		if _, err = db.ExecContext(ctx, stmt); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("usage-webhooks: create schema: %w", err)
+		}
+	}
+	return db, nil
+}
+
+func buildSettings(cfg config.UsageWebhooksConfig) settings {
+	s := settings{
+		endpoints:     make(map[string]string, len(cfg.Endpoints)),
+		batchInterval: time.Duration(cfg.BatchInterval) * time.Second,
+		maxAttempts:   cfg.MaxAttempts,
+		retention:     time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+	}
+	for _, ep := range cfg.Endpoints {
+		target := strings.TrimSpace(ep.URL)
+		if target == "" {
+			continue
+		}
+		if _, dup := s.endpoints[target]; !dup {
+			s.order = append(s.order, target)
+		}
+		s.endpoints[target] = ep.Secret
+	}
+	if s.batchInterval < 0 {
+		s.batchInterval = 0
+	}
+	if s.maxAttempts <= 0 {
+		s.maxAttempts = defaultMaxAttempts
+	}
+	if cfg.RetentionDays <= 0 {
+		s.retention = defaultRetentionDays * 24 * time.Hour
+	}
+	return s
+}
+
+// Update applies reloaded endpoints, batching, attempts and retention. The
+// enabled flag and outbox path take effect on restart.
+func (s *Sender) Update(cfg config.UsageWebhooksConfig) {
+	if s == nil {
+		return
+	}
+	applied := buildSettings(cfg)
+	s.mu.Lock()
+	s.settings = applied
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *Sender) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// HandleUsage implements coreusage.Plugin. Records are written to the outbox
+// immediately, or with the next batch when a batch interval is configured.
+func (s *Sender) HandleUsage(_ context.Context, record coreusage.Record) {
+	if s == nil || record.APIKey == "" || usage.IsExcludedAPIKey(record.APIKey) {
+		return
+	}
+	sum := sha256.Sum256([]byte(record.APIKey))
+	entry := Record{
+		RequestedAt:     record.RequestedAt.UTC(),
+		APIKey:          device.MaskKey(record.APIKey),
+		KeyHash:         hex.EncodeToString(sum[:]),
+		Provider:        record.Provider,
+		Model:           record.Model,
+		InputTokens:     record.Detail.InputTokens,
+		OutputTokens:    record.Detail.OutputTokens,
+		ReasoningTokens: record.Detail.ReasoningTokens,
+		CachedTokens:    record.Detail.CachedTokens,
+		TotalTokens:     record.Detail.TotalTokens,
+		Failed:          record.Failed,
+	}
+	if entry.RequestedAt.IsZero() {
+		entry.RequestedAt = s.now().UTC()
+	}
+	if s.opts.Cost != nil {
+		entry.CostUSD = s.opts.Cost(record.Model, record.Detail.InputTokens, record.Detail.OutputTokens)
+	}
+	if s.opts.Metadata != nil {
+		entry.KeyMetadata = s.opts.Metadata(record.APIKey)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.buffer = append(s.buffer, entry)
+	immediate := s.settings.batchInterval == 0
+	s.mu.Unlock()
+	if immediate {
+		s.flush()
+	}
+}
+
+// flush writes the buffered records to the outbox as one delivery per endpoint.
+func (s *Sender) flush() {
+	s.mu.Lock()
+	records := s.buffer
+	s.buffer = nil
+	s.lastFlush = s.now()
+	endpoints := append([]string(nil), s.settings.order...)
+	s.mu.Unlock()
+	if len(records) == 0 {
+		return
+	}
+	if err := s.enqueue(records, endpoints); err != nil {
+		log.Errorf("usage-webhooks: failed to write %d record(s) to the outbox: %v", len(records), err)
+		return
+	}
+	s.notify()
+}
+
+func (s *Sender) enqueue(records []Record, endpoints []string) error {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	now := s.now().UTC()
+	body, err := json.Marshal(Payload{ID: hex.EncodeToString(id), CreatedAt: now, Records: records})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	stamp := now.Format(timeLayout)
+	for _, endpoint := range endpoints {
+		if _, err = tx.ExecContext(ctx, `INSERT INTO usage_webhook_deliveries
+			(delivery_id, endpoint, created_at, records, payload, status, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			hex.EncodeToString(id), endpoint, stamp, len(records), string(body), StatusPending, stamp); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Run flushes batches and delivers due outbox entries until ctx is done.
+func (s *Sender) Run(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	log.Infof("usage-webhooks: delivering usage to %d endpoint(s)", len(s.settings.order))
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		s.runMu.Lock()
+		s.mu.Lock()
+		closed := s.closed
+		interval, due := s.settings.batchInterval, s.now().Sub(s.lastFlush) >= s.settings.batchInterval
+		s.mu.Unlock()
+		if closed {
+			s.runMu.Unlock()
+			return
+		}
+		if interval > 0 && due {
+			s.flush()
+		}
+		s.deliverDue(ctx)
+		s.prune()
+		s.runMu.Unlock()
+	}
+}
+
+type outboxEntry struct {
+	id         int64
+	deliveryID string
+	endpoint   string
+	payload    []byte
+	attempts   int
+}
+
+// deliverDue posts the pending entries whose next attempt is due. After a
+// failure the remaining entries of that endpoint wait for the next round.
+func (s *Sender) deliverDue(ctx context.Context) {
+	entries, err := s.dueEntries(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warnf("usage-webhooks: failed to read the outbox: %v", err)
+		}
+		return
+	}
+	s.mu.Lock()
+	current := s.settings
+	s.mu.Unlock()
+	blocked := make(map[string]bool)
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if blocked[entry.endpoint] {
+			continue
+		}
+		secret, configured := current.endpoints[entry.endpoint]
+		var retryable bool
+		if configured {
+			retryable, err = s.post(ctx, entry, secret)
+		} else {
+			err = fmt.Errorf("endpoint is no longer configured")
+		}
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		s.recordAttempt(entry, err, retryable, current.maxAttempts)
+		if err != nil {
+			blocked[entry.endpoint] = true
+		}
+	}
+}
+
+func (s *Sender) dueEntries(ctx context.Context) ([]outboxEntry, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(queryCtx, `SELECT id, delivery_id, endpoint, payload, attempts FROM usage_webhook_deliveries
+		WHERE status = ? AND next_attempt_at <= ? ORDER BY id LIMIT ?`,
+		StatusPending, s.now().UTC().Format(timeLayout), deliveryBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var entries []outboxEntry
+	for rows.Next() {
+		var entry outboxEntry
+		var payload string
+		if err = rows.Scan(&entry.id, &entry.deliveryID, &entry.endpoint, &payload, &entry.attempts); err != nil {
+			return nil, err
+		}
+		entry.payload = []byte(payload)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// recordAttempt stores the outcome of a delivery attempt, scheduling a retry
+// with exponential backoff or marking the entry failed once it runs out of attempts.
+func (s *Sender) recordAttempt(entry outboxEntry, errPost error, retryable bool, maxAttempts int) {
+	now := s.now().UTC()
+	attempts := entry.attempts + 1
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	var err error
+	switch {
+	case errPost == nil:
+		deliveryAttempts.Inc("delivered")
+		_, err = s.db.ExecContext(ctx, `UPDATE usage_webhook_deliveries SET status = ?, attempts = ?, last_error = '', delivered_at = ? WHERE id = ?`,
+			StatusDelivered, attempts, now.Format(timeLayout), entry.id)
+	case retryable && attempts < maxAttempts:
+		deliveryAttempts.Inc("retry")
+		backoff := baseBackoff << min(attempts-1, 20)
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		log.Debugf("usage-webhooks: delivery %s to %s failed (attempt %d), retrying in %s: %v", entry.deliveryID, entry.endpoint, attempts, backoff, errPost)
+		_, err = s.db.ExecContext(ctx, `UPDATE usage_webhook_deliveries SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?`,
+			attempts, errPost.Error(), now.Add(backoff).Format(timeLayout), entry.id)
+	default:
+		deliveryAttempts.Inc("failed")
+		log.Warnf("usage-webhooks: giving up on delivery %s to %s after %d attempt(s): %v", entry.deliveryID, entry.endpoint, attempts, errPost)
+		_, err = s.db.ExecContext(ctx, `UPDATE usage_webhook_deliveries SET status = ?, attempts = ?, last_error = ? WHERE id = ?`,
+			StatusFailed, attempts, errPost.Error(), entry.id)
+	}
+	if err != nil {
+		log.Warnf("usage-webhooks: failed to update delivery %s: %v", entry.deliveryID, err)
+	}
+}
+
+func (s *Sender) post(ctx context.Context, entry outboxEntry, secret string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, entry.endpoint, bytes.NewReader(entry.payload))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.EventHeader, EventType)
+	req.Header.Set(webhook.TimestampHeader, timestamp)
+	req.Header.Set(DeliveryHeader, entry.deliveryID)
+	if secret != "" {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(secret, timestamp, entry.payload))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			log.Debugf("usage-webhooks: failed to close response body: %v", errClose)
+		}
+	}()
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retryable, fmt.Errorf("endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+// prune drops delivered and failed entries older than the retention period.
+func (s *Sender) prune() {
+	s.mu.Lock()
+	now := s.now()
+	if now.Sub(s.lastPrune) < pruneEvery {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPrune = now
+	cutoff := now.Add(-s.settings.retention).UTC().Format(timeLayout)
+	s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM usage_webhook_deliveries WHERE status != ? AND created_at < ?`, StatusPending, cutoff); err != nil {
+		log.Warnf("usage-webhooks: failed to prune the outbox: %v", err)
+	}
+}
+
+// ListFilter selects outbox entries.
+type ListFilter struct {
+	// Status restricts the result to one status; empty lists all.
+	Status string
+	// Limit caps the number of entries; 0 uses 100.
+	Limit int
+}
+
+// List returns outbox entries, newest first.
+func (s *Sender) List(filter ListFilter) ([]Delivery, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	query := `SELECT id, delivery_id, endpoint, created_at, records, status, attempts, last_error, delivered_at FROM usage_webhook_deliveries`
+	args := []any{}
+	if filter.Status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, filter.Status)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var createdAt, deliveredAt string
+		if err = rows.Scan(&d.ID, &d.DeliveryID, &d.Endpoint, &createdAt, &d.Records, &d.Status, &d.Attempts, &d.LastError, &deliveredAt); err != nil {
+			return nil, err
+		}
+		d.CreatedAt, _ = time.Parse(timeLayout, createdAt)
+		if t, errParse := time.Parse(timeLayout, deliveredAt); errParse == nil {
+			d.DeliveredAt = &t
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ReplayFilter selects the outbox entries to send again. Entries match when
+// their ID is listed or they were created inside [Since, Until).
+type ReplayFilter struct {
+	IDs      []int64
+	Since    time.Time
+	Until    time.Time
+	Endpoint string
+}
+
+// Replay queues matching entries for delivery again, whatever their status,
+// and returns how many were queued. Receivers see the original delivery IDs.
+func (s *Sender) Replay(filter ReplayFilter) (int, error) {
+	var conds []string
+	var args []any
+	if len(filter.IDs) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(filter.IDs)), ",")
+		conds = append(conds, "id IN ("+placeholders+")")
+		for _, id := range filter.IDs {
+			args = append(args, id)
+		}
+	}
+	if !filter.Since.IsZero() {
+		until := filter.Until
+		if until.IsZero() {
+			until = s.now()
+		}
+		conds = append(conds, "(created_at >= ? AND created_at < ?)")
+		args = append(args, filter.Since.UTC().Format(timeLayout), until.UTC().Format(timeLayout))
+	}
+	if len(conds) == 0 {
+		return 0, fmt.Errorf("replay needs delivery ids or a since time")
+	}
+	where := "(" + strings.Join(conds, " OR ") + ")"
+	if filter.Endpoint != "" {
+		where += " AND endpoint = ?"
+		args = append(args, filter.Endpoint)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, `UPDATE usage_webhook_deliveries
+		SET status = ?, attempts = 0, last_error = '', delivered_at = '', next_attempt_at = ? WHERE `+where,
+		append([]any{StatusPending, s.now().UTC().Format(timeLayout)}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	if n > 0 {
+		s.notify()
+	}
+	return int(n), nil
+}
+
+// Close writes buffered records to the outbox, so they are delivered after a
+// restart, and closes it.
+func (s *Sender) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	s.flush()
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return s.db.Close()
+}
@@ -0,0 +1,163 @@
+package usagewebhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/notify/webhook"
+	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []Payload
+	headers  []http.Header
+	raw      [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	if status == http.StatusOK {
+		var payload Payload
+		_ = json.Unmarshal(body, &payload)
+		r.bodies = append(r.bodies, payload)
+		r.headers = append(r.headers, req.Header.Clone())
+		r.raw = append(r.raw, body)
+	}
+	w.WriteHeader(status)
+}
+
+func newTestSender(t *testing.T, batchInterval int, recv *receiver) (*Sender, *config.Config) {
+	t.Helper()
+	server := httptest.NewServer(recv)
+	t.Cleanup(server.Close)
+	cfg := &config.Config{UsageWebhooks: config.UsageWebhooksConfig{
+		Enabled:       true,
+		BatchInterval: batchInterval,
+		MaxAttempts:   3,
+		Path:          filepath.Join(t.TempDir(), DefaultFileName),
+		Endpoints:     []config.UsageWebhookEndpoint{{URL: server.URL, Secret: "s3cret"}},
+	}}
+	sender, err := New(cfg, Options{
+		Cost:     func(string, int64, int64) float64 { return 0.25 },
+		Metadata: func(string) map[string]string { return map[string]string{"customer": "acme"} },
+	})
+	if err != nil || sender == nil {
+		t.Fatalf("New = %v, %v", sender, err)
+	}
+	t.Cleanup(func() { _ = sender.Close() })
+	return sender, cfg
+}
+
+func usageRecord(model string) coreusage.Record {
+	return coreusage.Record{
+		Provider:    "claude",
+		Model:       model,
+		APIKey:      "sk-customer-key-1234",
+		RequestedAt: time.Now(),
+		Detail:      coreusage.Detail{InputTokens: 100, OutputTokens: 20, TotalTokens: 120},
+	}
+}
+
+func TestSenderDeliversSignedUsagePerRequest(t *testing.T) {
+	recv := &receiver{}
+	sender, _ := newTestSender(t, 0, recv)
+
+	sender.HandleUsage(context.Background(), usageRecord("claude-sonnet-4"))
+	sender.deliverDue(context.Background())
+
+	if len(recv.bodies) != 1 || len(recv.bodies[0].Records) != 1 {
+		t.Fatalf("expected one delivery with one record, got %+v", recv.bodies)
+	}
+	header := recv.headers[0]
+	if got, want := header.Get(webhook.SignatureHeader), webhook.Sign("s3cret", header.Get(webhook.TimestampHeader), recv.raw[0]); got != want {
+		t.Fatalf("signature = %q, want %q", got, want)
+	}
+	if header.Get(DeliveryHeader) != recv.bodies[0].ID || header.Get(webhook.EventHeader) != EventType {
+		t.Fatalf("unexpected headers %v", header)
+	}
+	record := recv.bodies[0].Records[0]
+	if record.APIKey == "sk-customer-key-1234" || record.KeyHash == "" || record.CostUSD != 0.25 ||
+		record.KeyMetadata["customer"] != "acme" || record.InputTokens != 100 || record.Model != "claude-sonnet-4" {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	deliveries, err := sender.List(ListFilter{})
+	if err != nil || len(deliveries) != 1 || deliveries[0].Status != StatusDelivered || deliveries[0].DeliveredAt == nil {
+		t.Fatalf("unexpected outbox state %+v, %v", deliveries, err)
+	}
+}
+
+func TestSenderRetriesWithBackoffAndReplays(t *testing.T) {
+	recv := &receiver{statuses: []int{http.StatusBadGateway}}
+	sender, _ := newTestSender(t, 0, recv)
+	now := time.Now()
+	sender.now = func() time.Time { return now }
+
+	sender.HandleUsage(context.Background(), usageRecord("gpt-5"))
+	sender.deliverDue(context.Background())
+	deliveries, _ := sender.List(ListFilter{Status: StatusPending})
+	if len(deliveries) != 1 || deliveries[0].Attempts != 1 || deliveries[0].LastError == "" {
+		t.Fatalf("expected a pending retry after the failure, got %+v", deliveries)
+	}
+	sender.deliverDue(context.Background())
+	if len(recv.bodies) != 0 {
+		t.Fatal("expected the retry to wait for its backoff")
+	}
+
+	now = now.Add(2 * baseBackoff)
+	sender.deliverDue(context.Background())
+	if len(recv.bodies) != 1 {
+		t.Fatalf("expected the retry to be delivered, got %d deliveries", len(recv.bodies))
+	}
+
+	queued, err := sender.Replay(ReplayFilter{IDs: []int64{deliveries[0].ID}})
+	if err != nil || queued != 1 {
+		t.Fatalf("Replay = %d, %v", queued, err)
+	}
+	sender.deliverDue(context.Background())
+	if len(recv.bodies) != 2 || recv.bodies[1].ID != recv.bodies[0].ID {
+		t.Fatalf("expected replay to resend the same delivery ID, got %+v", recv.bodies)
+	}
+	if _, err = sender.Replay(ReplayFilter{}); err == nil {
+		t.Fatal("expected replay without a filter to be rejected")
+	}
+}
+
+func TestSenderBatchesAndPersistsBufferedRecordsOnClose(t *testing.T) {
+	recv := &receiver{}
+	sender, cfg := newTestSender(t, 60, recv)
+
+	sender.HandleUsage(context.Background(), usageRecord("a"))
+	sender.HandleUsage(context.Background(), usageRecord("b"))
+	if deliveries, _ := sender.List(ListFilter{}); len(deliveries) != 0 {
+		t.Fatalf("expected records to wait for the batch, got %+v", deliveries)
+	}
+	if err := sender.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened, err := New(cfg, Options{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	reopened.deliverDue(context.Background())
+	if len(recv.bodies) != 1 || len(recv.bodies[0].Records) != 2 {
+		t.Fatalf("expected one batch of two records after restart, got %+v", recv.bodies)
+	}
+}
@@ -122,9 +122,15 @@ func BuildConfigChangeDetails(oldCfg, newCfg *config.Config) []string {
 			if strings.TrimSpace(o.APIKey) != strings.TrimSpace(n.APIKey) {
 				changes = append(changes, fmt.Sprintf("claude[%d].api-key: updated", i))
 			}
+			if !equalStringSet(o.APIKeyPool, n.APIKeyPool) {
+				changes = append(changes, fmt.Sprintf("claude[%d].api-key-pool: updated (%d -> %d keys)", i, len(o.APIKeyPool), len(n.APIKeyPool)))
+			}
 			if !equalStringMap(o.Headers, n.Headers) {
 				changes = append(changes, fmt.Sprintf("claude[%d].headers: updated", i))
 			}
+			if o.Peer != n.Peer {
+				changes = append(changes, fmt.Sprintf("claude[%d].peer: %t -> %t", i, o.Peer, n.Peer))
+			}
 			oldModels := SummarizeClaudeModels(o.Models)
 			newModels := SummarizeClaudeModels(n.Models)
 			if oldModels.hash != newModels.hash {
@@ -157,6 +163,9 @@ func BuildConfigChangeDetails(oldCfg, newCfg *config.Config) []string {
 			if strings.TrimSpace(o.APIKey) != strings.TrimSpace(n.APIKey) {
 				changes = append(changes, fmt.Sprintf("codex[%d].api-key: updated", i))
 			}
+			if !equalStringSet(o.APIKeyPool, n.APIKeyPool) {
+				changes = append(changes, fmt.Sprintf("codex[%d].api-key-pool: updated (%d -> %d keys)", i, len(o.APIKeyPool), len(n.APIKeyPool)))
+			}
 			if !equalStringMap(o.Headers, n.Headers) {
 				changes = append(changes, fmt.Sprintf("codex[%d].headers: updated", i))
 			}
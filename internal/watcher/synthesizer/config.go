@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/peering"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/watcher/diff"
 	coreauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
 )
@@ -84,94 +86,97 @@ func (s *ConfigSynthesizer) synthesizeGeminiKeys(ctx *SynthesisContext) []*corea
 }
 
 // synthesizeClaudeKeys creates Auth entries for Claude API keys.
+// Every key of an entry's pool becomes its own credential.
 func (s *ConfigSynthesizer) synthesizeClaudeKeys(ctx *SynthesisContext) []*coreauth.Auth {
 	cfg := ctx.Config
-	now := ctx.Now
-	idGen := ctx.IDGenerator
-
 	out := make([]*coreauth.Auth, 0, len(cfg.ClaudeKey))
 	for i := range cfg.ClaudeKey {
 		ck := cfg.ClaudeKey[i]
-		key := strings.TrimSpace(ck.APIKey)
-		if key == "" {
-			continue
-		}
-		prefix := strings.TrimSpace(ck.Prefix)
-		base := strings.TrimSpace(ck.BaseURL)
-		id, token := idGen.Next("claude:apikey", key, base)
-		attrs := map[string]string{
-			"source":  fmt.Sprintf("config:claude[%s]", token),
-			"api_key": key,
+		for _, key := range config.PoolKeys(ck.APIKey, ck.APIKeyPool) {
+			out = append(out, claudeKeyAuth(ctx, ck, key))
 		}
-		if base != "" {
-			attrs["base_url"] = base
-		}
-		if hash := diff.ComputeClaudeModelsHash(ck.Models); hash != "" {
-			attrs["models_hash"] = hash
-		}
-		addConfigHeadersToAttrs(ck.Headers, attrs)
-		proxyURL := strings.TrimSpace(ck.ProxyURL)
-		a := &coreauth.Auth{
-			ID:         id,
-			Provider:   "claude",
-			Label:      "claude-apikey",
-			Prefix:     prefix,
-			Status:     coreauth.StatusActive,
-			ProxyURL:   proxyURL,
-			Attributes: attrs,
-			CreatedAt:  now,
-			UpdatedAt:  now,
-		}
-		ApplyAuthExcludedModelsMeta(a, cfg, ck.ExcludedModels, "apikey")
-		out = append(out, a)
 	}
 	return out
 }
 
+// claudeKeyAuth creates the Auth entry of one key of a Claude API key entry.
+func claudeKeyAuth(ctx *SynthesisContext, ck config.ClaudeKey, key string) *coreauth.Auth {
+	prefix := strings.TrimSpace(ck.Prefix)
+	base := strings.TrimSpace(ck.BaseURL)
+	id, token := ctx.IDGenerator.Next("claude:apikey", key, base)
+	attrs := map[string]string{
+		"source":  fmt.Sprintf("config:claude[%s]", token),
+		"api_key": key,
+	}
+	if base != "" {
+		attrs["base_url"] = base
+	}
+	if hash := diff.ComputeClaudeModelsHash(ck.Models); hash != "" {
+		attrs["models_hash"] = hash
+	}
+	if ck.Peer {
+		attrs[peering.AttributeKey] = "true"
+	}
+	addConfigHeadersToAttrs(ck.Headers, attrs)
+	a := &coreauth.Auth{
+		ID:         id,
+		Provider:   "claude",
+		Label:      "claude-apikey",
+		Prefix:     prefix,
+		Status:     coreauth.StatusActive,
+		ProxyURL:   strings.TrimSpace(ck.ProxyURL),
+		Attributes: attrs,
+		CreatedAt:  ctx.Now,
+		UpdatedAt:  ctx.Now,
+	}
+	ApplyAuthExcludedModelsMeta(a, ctx.Config, ck.ExcludedModels, "apikey")
+	return a
+}
+
 // synthesizeCodexKeys creates Auth entries for Codex API keys.
+// Every key of an entry's pool becomes its own credential.
 func (s *ConfigSynthesizer) synthesizeCodexKeys(ctx *SynthesisContext) []*coreauth.Auth {
 	cfg := ctx.Config
-	now := ctx.Now
-	idGen := ctx.IDGenerator
-
 	out := make([]*coreauth.Auth, 0, len(cfg.CodexKey))
 	for i := range cfg.CodexKey {
 		ck := cfg.CodexKey[i]
-		key := strings.TrimSpace(ck.APIKey)
-		if key == "" {
-			continue
-		}
-		prefix := strings.TrimSpace(ck.Prefix)
-		id, token := idGen.Next("codex:apikey", key, ck.BaseURL)
-		attrs := map[string]string{
-			"source":  fmt.Sprintf("config:codex[%s]", token),
-			"api_key": key,
-		}
-		if ck.BaseURL != "" {
-			attrs["base_url"] = ck.BaseURL
-		}
-		if hash := diff.ComputeCodexModelsHash(ck.Models); hash != "" {
-			attrs["models_hash"] = hash
-		}
-		addConfigHeadersToAttrs(ck.Headers, attrs)
-		proxyURL := strings.TrimSpace(ck.ProxyURL)
-		a := &coreauth.Auth{
-			ID:         id,
-			Provider:   "codex",
-			Label:      "codex-apikey",
-			Prefix:     prefix,
-			Status:     coreauth.StatusActive,
-			ProxyURL:   proxyURL,
-			Attributes: attrs,
-			CreatedAt:  now,
-			UpdatedAt:  now,
+		for _, key := range config.PoolKeys(ck.APIKey, ck.APIKeyPool) {
+			out = append(out, codexKeyAuth(ctx, ck, key))
 		}
-		ApplyAuthExcludedModelsMeta(a, cfg, ck.ExcludedModels, "apikey")
-		out = append(out, a)
 	}
 	return out
 }
 
+// codexKeyAuth creates the Auth entry of one key of a Codex API key entry.
+func codexKeyAuth(ctx *SynthesisContext, ck config.CodexKey, key string) *coreauth.Auth {
+	prefix := strings.TrimSpace(ck.Prefix)
+	id, token := ctx.IDGenerator.Next("codex:apikey", key, ck.BaseURL)
+	attrs := map[string]string{
+		"source":  fmt.Sprintf("config:codex[%s]", token),
+		"api_key": key,
+	}
+	if ck.BaseURL != "" {
+		attrs["base_url"] = ck.BaseURL
+	}
+	if hash := diff.ComputeCodexModelsHash(ck.Models); hash != "" {
+		attrs["models_hash"] = hash
+	}
+	addConfigHeadersToAttrs(ck.Headers, attrs)
+	a := &coreauth.Auth{
+		ID:         id,
+		Provider:   "codex",
+		Label:      "codex-apikey",
+		Prefix:     prefix,
+		Status:     coreauth.StatusActive,
+		ProxyURL:   strings.TrimSpace(ck.ProxyURL),
+		Attributes: attrs,
+		CreatedAt:  ctx.Now,
+		UpdatedAt:  ctx.Now,
+	}
+	ApplyAuthExcludedModelsMeta(a, ctx.Config, ck.ExcludedModels, "apikey")
+	return a
+}
+
 // synthesizeOpenAICompat creates Auth entries for OpenAI-compatible providers.
 func (s *ConfigSynthesizer) synthesizeOpenAICompat(ctx *SynthesisContext) []*coreauth.Auth {
 	cfg := ctx.Config
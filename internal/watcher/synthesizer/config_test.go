@@ -225,6 +225,44 @@ func TestConfigSynthesizer_ClaudeKeys_SkipsEmptyAndHeaders(t *testing.T) {
 	}
 }
 
+func TestConfigSynthesizer_ClaudeKeys_APIKeyPool(t *testing.T) {
+	synth := NewConfigSynthesizer()
+	ctx := &SynthesisContext{
+		Config: &config.Config{
+			ClaudeKey: []config.ClaudeKey{
+				{
+					APIKey:     "key-a",
+					APIKeyPool: []string{"key-b", "key-a", " ", "key-c"},
+					BaseURL:    "https://api.anthropic.com",
+				},
+			},
+		},
+		Now:         time.Now(),
+		IDGenerator: NewStableIDGenerator(),
+	}
+
+	auths, err := synth.Synthesize(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(auths) != 3 {
+		t.Fatalf("expected 3 auths (one per distinct pool key), got %d", len(auths))
+	}
+	ids := make(map[string]struct{})
+	for i, want := range []string{"key-a", "key-b", "key-c"} {
+		if got := auths[i].Attributes["api_key"]; got != want {
+			t.Errorf("auth %d api_key = %s, want %s", i, got, want)
+		}
+		if auths[i].Attributes["base_url"] != "https://api.anthropic.com" {
+			t.Errorf("auth %d should share the entry base_url", i)
+		}
+		ids[auths[i].ID] = struct{}{}
+	}
+	if len(ids) != 3 {
+		t.Errorf("pool keys must get distinct auth IDs, got %v", ids)
+	}
+}
+
 func TestConfigSynthesizer_CodexKeys(t *testing.T) {
 	synth := NewConfigSynthesizer()
 	ctx := &SynthesisContext{
@@ -0,0 +1,113 @@
+package access
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries bounds the verdicts one cached provider remembers.
+const maxCacheEntries = 10000
+
+type cacheEntry struct {
+	result  *Result
+	err     error
+	expires time.Time
+}
+
+// cachedProvider remembers the verdicts of a provider per credential.
+type cachedProvider struct {
+	Provider
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	now     func() time.Time
+}
+
+// NewCachedProvider wraps p so accepted credentials are remembered for ttl and
+// rejected ones for negativeTTL, sparing providers that call out to a database
+// or service a round trip per request. Missing credentials and provider errors
+// are never cached. A non-positive ttl returns p unchanged.
+func NewCachedProvider(p Provider, ttl, negativeTTL time.Duration) Provider {
+	if p == nil || ttl <= 0 {
+		return p
+	}
+	return &cachedProvider{Provider: p, ttl: ttl, negativeTTL: negativeTTL, entries: make(map[string]cacheEntry), now: time.Now}
+}
+
+// Authenticate returns the cached verdict for the request credentials or asks
+// the wrapped provider.
+func (c *cachedProvider) Authenticate(ctx context.Context, r *http.Request) (*Result, error) {
+	key := credentialKey(r)
+	if key == "" {
+		return c.Provider.Authenticate(ctx, r)
+	}
+	now := c.now()
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.result, entry.err
+	}
+
+	result, err := c.Provider.Authenticate(ctx, r)
+	var ttl time.Duration
+	switch {
+	case err == nil:
+		ttl = c.ttl
+	case errors.Is(err, ErrInvalidCredential):
+		ttl = c.negativeTTL
+	}
+	if ttl > 0 {
+		c.mu.Lock()
+		if len(c.entries) >= maxCacheEntries {
+			c.pruneLocked(now)
+		}
+		c.entries[key] = cacheEntry{result: result, err: err, expires: now.Add(ttl)}
+		c.mu.Unlock()
+	}
+	return result, err
+}
+
+// Close closes the wrapped provider when it holds resources.
+func (c *cachedProvider) Close() error {
+	if closer, ok := c.Provider.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// pruneLocked drops expired entries, or every entry when none expired.
+// Callers must hold c.mu.
+func (c *cachedProvider) pruneLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) >= maxCacheEntries {
+		clear(c.entries)
+	}
+}
+
+// credentialKey digests every credential r carries, or returns "" without any.
+func credentialKey(r *http.Request) string {
+	credentials := Credentials(r)
+	cert := ClientCertFingerprint(r)
+	if len(credentials) == 0 && cert == "" {
+		return ""
+	}
+	h := sha256.New()
+	for _, credential := range credentials {
+		_, _ = io.WriteString(h, credential.Source+"\x00"+credential.Value+"\x00")
+	}
+	_, _ = io.WriteString(h, cert)
+	return hex.EncodeToString(h.Sum(nil))
+}
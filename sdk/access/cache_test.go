@@ -0,0 +1,72 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type countingProvider struct {
+	calls int
+}
+
+func (p *countingProvider) Identifier() string { return "counting" }
+
+func (p *countingProvider) Authenticate(_ context.Context, r *http.Request) (*Result, error) {
+	p.calls++
+	switch r.Header.Get("X-Api-Key") {
+	case "":
+		return nil, ErrNoCredentials
+	case "good":
+		return &Result{Provider: "counting", Principal: "good"}, nil
+	default:
+		return nil, ErrInvalidCredential
+	}
+}
+
+func TestCachedProvider(t *testing.T) {
+	inner := &countingProvider{}
+	cached := NewCachedProvider(inner, time.Minute, time.Second).(*cachedProvider)
+	now := time.Unix(1000, 0)
+	cached.now = func() time.Time { return now }
+
+	request := func(key string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if key != "" {
+			r.Header.Set("X-Api-Key", key)
+		}
+		return r
+	}
+
+	for i := 0; i < 3; i++ {
+		if res, err := cached.Authenticate(t.Context(), request("good")); err != nil || res.Principal != "good" {
+			t.Fatalf("good key: res=%+v err=%v", res, err)
+		}
+		if _, err := cached.Authenticate(t.Context(), request("bad")); !errors.Is(err, ErrInvalidCredential) {
+			t.Fatalf("bad key: err = %v", err)
+		}
+	}
+	if inner.calls != 2 {
+		t.Fatalf("calls = %d, want 2 (one per key)", inner.calls)
+	}
+
+	now = now.Add(2 * time.Second)
+	_, _ = cached.Authenticate(t.Context(), request("good"))
+	_, _ = cached.Authenticate(t.Context(), request("bad"))
+	if inner.calls != 3 {
+		t.Fatalf("calls = %d, want 3 (rejection expired, acceptance cached)", inner.calls)
+	}
+
+	_, _ = cached.Authenticate(t.Context(), request(""))
+	_, _ = cached.Authenticate(t.Context(), request(""))
+	if inner.calls != 5 {
+		t.Fatalf("calls = %d, want 5 (missing credentials are not cached)", inner.calls)
+	}
+
+	if NewCachedProvider(inner, 0, time.Second) != Provider(inner) {
+		t.Fatal("zero ttl should return the provider unchanged")
+	}
+}
@@ -0,0 +1,67 @@
+package access
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Credential is an API key candidate found on a request.
+type Credential struct {
+	Value string
+	// Source names where the value was found, e.g. "authorization" or "x-api-key".
+	Source string
+}
+
+// Credentials returns the API key candidates of r in the order providers
+// should try them: the bearer token, the Google and Anthropic key headers, then
+// the key and auth_token query parameters.
+func Credentials(r *http.Request) []Credential {
+	if r == nil {
+		return nil
+	}
+	candidates := []Credential{
+		{extractBearerToken(r.Header.Get("Authorization")), "authorization"},
+		{r.Header.Get("X-Goog-Api-Key"), "x-goog-api-key"},
+		{r.Header.Get("X-Api-Key"), "x-api-key"},
+	}
+	if r.URL != nil {
+		query := r.URL.Query()
+		candidates = append(candidates,
+			Credential{query.Get("key"), "query-key"},
+			Credential{query.Get("auth_token"), "query-auth-token"},
+		)
+	}
+	out := candidates[:0]
+	for _, candidate := range candidates {
+		if candidate.Value != "" {
+			out = append(out, candidate)
+		}
+	}
+	return out
+}
+
+// ClientCertFingerprint returns "cert:" and the hex SHA-256 of the client
+// certificate of r, or "" without a certificate verified against the client CAs.
+func ClientCertFingerprint(r *http.Request) string {
+	if r == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+	return "cert:" + hex.EncodeToString(sum[:])
+}
+
+func extractBearerToken(header string) string {
+	if header == "" {
+		return ""
+	}
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 {
+		return header
+	}
+	if strings.ToLower(parts[0]) != "bearer" {
+		return header
+	}
+	return strings.TrimSpace(parts[1])
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/router-for-me/CLIProxyAPI/v6/sdk/config"
 )
@@ -54,9 +55,41 @@ func BuildProvider(cfg *config.AccessProvider, root *config.SDKConfig) (Provider
 	if err != nil {
 		return nil, fmt.Errorf("access: failed to build provider %q: %w", cfg.Name, err)
 	}
+	if ttl := configSeconds(cfg.Config, CacheTTLOption); ttl > 0 {
+		negativeTTL := min(ttl, DefaultNegativeCacheTTL)
+		if _, ok := cfg.Config[NegativeCacheTTLOption]; ok {
+			negativeTTL = configSeconds(cfg.Config, NegativeCacheTTLOption)
+		}
+		provider = NewCachedProvider(provider, ttl, negativeTTL)
+	}
 	return provider, nil
 }
 
+// Provider options read by BuildProvider for every provider type
+const (
+	// CacheTTLOption caches accepted credentials for the given number of seconds.
+	CacheTTLOption = "cache-ttl-seconds"
+	// NegativeCacheTTLOption caches rejected credentials for the given number of
+	// seconds. Default: the cache TTL, at most DefaultNegativeCacheTTL.
+	NegativeCacheTTLOption = "negative-cache-ttl-seconds"
+)
+
+// DefaultNegativeCacheTTL bounds how long rejected credentials are cached by default.
+const DefaultNegativeCacheTTL = 5 * time.Second
+
+// configSeconds reads a number of seconds from provider options.
+func configSeconds(options map[string]any, key string) time.Duration {
+	switch v := options[key].(type) {
+	case int:
+		return time.Duration(v) * time.Second
+	case int64:
+		return time.Duration(v) * time.Second
+	case float64:
+		return time.Duration(v * float64(time.Second))
+	}
+	return 0
+}
+
 // BuildProviders constructs providers declared in configuration.
 func BuildProviders(root *config.SDKConfig) ([]Provider, error) {
 	if root == nil {
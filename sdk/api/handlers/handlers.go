@@ -6,6 +6,7 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -13,8 +14,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/errorlog"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/interfaces"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/logging"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/tracing"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	coreauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
 	coreexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
@@ -46,10 +50,63 @@ type ErrorDetail struct {
 const idempotencyKeyMetadataKey = "idempotency_key"
 
 const (
-	defaultStreamingKeepAliveSeconds = 0
-	defaultStreamingBootstrapRetries = 0
+	defaultStreamingKeepAliveSeconds  = 0
+	defaultStreamingBootstrapRetries  = 0
+	defaultStreamingOverloadedRetries = 2
 )
 
+// Phases reported by cliproxy_stream_overloaded_total
+const (
+	overloadedBeforeFirstToken = "before_first_token"
+	overloadedAfterFirstToken  = "after_first_token"
+)
+
+var streamOverloads = metrics.Default().NewCounterVec(
+	"cliproxy_stream_overloaded_total",
+	"Streams that hit an upstream overloaded error, by whether tokens had reached the client and whether the request was retried or the error surfaced.",
+	"phase", "outcome",
+)
+
+// StreamOutputBudgetContextKey is the gin context key holding the StreamOutputBudget of a request.
+const StreamOutputBudgetContextKey = "streamOutputBudget"
+
+// StreamOutputBudget caps the output a streaming response may deliver, such as a
+// per-key output token quota. Middleware stores one in the gin context.
+type StreamOutputBudget interface {
+	// Consume reserves the output carried by a payload chunk before it is written
+	// and reports whether the budget is used up, in which case the chunk is dropped.
+	Consume(chunk []byte) bool
+	// Release returns what the stream reserved once it has ended.
+	Release()
+}
+
+// QuotaExhaustedError ends a stream whose output budget ran out mid-response.
+type QuotaExhaustedError struct{}
+
+// Error implements error.
+func (e *QuotaExhaustedError) Error() string {
+	return "output token quota exhausted for this API key; the response was truncated"
+}
+
+// StatusCode reports 429 Too Many Requests.
+func (e *QuotaExhaustedError) StatusCode() int { return http.StatusTooManyRequests }
+
+func streamOutputBudget(ctx context.Context) StreamOutputBudget {
+	if ctx == nil {
+		return nil
+	}
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil {
+		return nil
+	}
+	if value, exists := ginCtx.Get(StreamOutputBudgetContextKey); exists {
+		if budget, okBudget := value.(StreamOutputBudget); okBudget {
+			return budget
+		}
+	}
+	return nil
+}
+
 // BuildErrorResponseBody builds an OpenAI-compatible JSON error response body.
 // If errText is already valid JSON, it is returned as-is to preserve upstream error payloads.
 func BuildErrorResponseBody(status int, errText string) []byte {
@@ -113,6 +170,22 @@ func StreamingKeepAliveInterval(cfg *config.SDKConfig) time.Duration {
 	return time.Duration(seconds) * time.Second
 }
 
+// StreamingWriteTimeout returns how long writing one chunk to a streaming client may block, or 0 for no limit.
+func StreamingWriteTimeout(cfg *config.SDKConfig) time.Duration {
+	if cfg == nil || cfg.Streaming.WriteTimeoutSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(cfg.Streaming.WriteTimeoutSeconds) * time.Second
+}
+
+// StreamingFlushInterval returns how often coalesced stream chunks are flushed, or 0 to flush every chunk.
+func StreamingFlushInterval(cfg *config.SDKConfig) time.Duration {
+	if cfg == nil || cfg.Streaming.FlushIntervalMs <= 0 {
+		return 0
+	}
+	return time.Duration(cfg.Streaming.FlushIntervalMs) * time.Millisecond
+}
+
 // StreamingBootstrapRetries returns how many times a streaming request may be retried before any bytes are sent.
 func StreamingBootstrapRetries(cfg *config.SDKConfig) int {
 	retries := defaultStreamingBootstrapRetries
@@ -125,6 +198,19 @@ func StreamingBootstrapRetries(cfg *config.SDKConfig) int {
 	return retries
 }
 
+// StreamingOverloadedRetries returns how many times a stream that hit an upstream overloaded
+// error before its first token may be retried from scratch.
+func StreamingOverloadedRetries(cfg *config.SDKConfig) int {
+	retries := defaultStreamingOverloadedRetries
+	if cfg != nil && cfg.Streaming.OverloadedRetries != 0 {
+		retries = cfg.Streaming.OverloadedRetries
+	}
+	if retries < 0 {
+		retries = 0
+	}
+	return retries
+}
+
 func requestExecutionMetadata(ctx context.Context) map[string]any {
 	// Idempotency-Key is an optional client-supplied header used to correlate retries.
 	// It is forwarded as execution metadata; when absent we generate a UUID.
@@ -240,6 +326,9 @@ func (h *BaseAPIHandler) GetContextWithCancel(handler interfaces.APIHandler, c *
 			parentCtx = logging.WithRequestID(parentCtx, requestID)
 		}
 	}
+	if requestCtx != nil {
+		parentCtx = tracing.Inherit(parentCtx, requestCtx)
+	}
 	newCtx, cancel := context.WithCancel(parentCtx)
 	if requestCtx != nil && requestCtx != parentCtx {
 		go func() {
@@ -449,6 +538,12 @@ func (h *BaseAPIHandler) ExecuteStreamWithAuthManager(ctx context.Context, handl
 		sentPayload := false
 		bootstrapRetries := 0
 		maxBootstrapRetries := StreamingBootstrapRetries(h.Cfg)
+		overloadedRetries := 0
+		maxOverloadedRetries := StreamingOverloadedRetries(h.Cfg)
+		budget := streamOutputBudget(ctx)
+		if budget != nil {
+			defer budget.Release()
+		}
 
 		bootstrapEligible := func(err error) bool {
 			status := statusFromError(err)
@@ -483,9 +578,29 @@ func (h *BaseAPIHandler) ExecuteStreamWithAuthManager(ctx context.Context, handl
 				}
 				if chunk.Err != nil {
 					streamErr := chunk.Err
-					// Safe bootstrap recovery: if the upstream fails before any payload bytes are sent,
-					// retry a few times (to allow auth rotation / transient recovery) and then attempt model fallback.
-					if !sentPayload {
+					var overloaded *coreexecutor.OverloadedError
+					if errors.As(streamErr, &overloaded) {
+						// An overloaded stream that delivered nothing is retried from scratch on its own
+						// budget; once tokens reached the client the typed error is surfaced instead.
+						phase := overloadedBeforeFirstToken
+						if overloaded.TokensDelivered || sentPayload {
+							phase = overloadedAfterFirstToken
+						}
+						if phase == overloadedBeforeFirstToken && overloadedRetries < maxOverloadedRetries {
+							overloadedRetries++
+							streamOverloads.Inc(phase, "retried")
+							retryChunks, retryErr := h.AuthManager.ExecuteStream(ctx, providers, req, opts)
+							if retryErr == nil {
+								chunks = retryChunks
+								continue outer
+							}
+							streamErr = retryErr
+						} else {
+							streamOverloads.Inc(phase, "surfaced")
+						}
+					} else if !sentPayload {
+						// Safe bootstrap recovery: if the upstream fails before any payload bytes are sent,
+						// retry a few times (to allow auth rotation / transient recovery) and then attempt model fallback.
 						if bootstrapRetries < maxBootstrapRetries && bootstrapEligible(streamErr) {
 							bootstrapRetries++
 							retryChunks, retryErr := h.AuthManager.ExecuteStream(ctx, providers, req, opts)
@@ -513,8 +628,24 @@ func (h *BaseAPIHandler) ExecuteStreamWithAuthManager(ctx context.Context, handl
 					return
 				}
 				if len(chunk.Payload) > 0 {
+					// End the stream as soon as its output budget is used up rather than
+					// letting the rest of the completion through.
+					if budget != nil && budget.Consume(chunk.Payload) {
+						errChan <- &interfaces.ErrorMessage{StatusCode: http.StatusTooManyRequests, Error: &QuotaExhaustedError{}}
+						return
+					}
 					sentPayload = true
-					dataChan <- cloneBytes(chunk.Payload)
+					if ctx == nil {
+						dataChan <- cloneBytes(chunk.Payload)
+						continue
+					}
+					// Stop reading upstream once the client is gone instead of
+					// blocking on a consumer that no longer receives.
+					select {
+					case dataChan <- cloneBytes(chunk.Payload):
+					case <-ctx.Done():
+						return
+					}
 				}
 			}
 		}
@@ -617,6 +748,9 @@ func (h *BaseAPIHandler) WriteErrorResponse(c *gin.Context, msg *interfaces.Erro
 		}
 	}
 
+	c.Set(errorlog.ContextSourceKey, errorlog.SourceUpstream)
+	c.Set(errorlog.ContextMessageKey, errText)
+
 	body := BuildErrorResponseBody(status, errText)
 	// Append first to preserve upstream response logs, then drop duplicate payloads if already recorded.
 	var previous []byte
@@ -2,10 +2,15 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
 
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/interfaces"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/registry"
 	coreauth "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
 	coreexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
@@ -122,3 +127,115 @@ func TestExecuteStreamWithAuthManager_RetriesBeforeFirstByte(t *testing.T) {
 		t.Fatalf("expected 2 stream attempts, got %d", executor.Calls())
 	}
 }
+
+type overloadedStreamExecutor struct {
+	failOnceStreamExecutor
+	tokensDelivered bool
+}
+
+func (e *overloadedStreamExecutor) ExecuteStream(context.Context, *coreauth.Auth, coreexecutor.Request, coreexecutor.Options) (<-chan coreexecutor.StreamChunk, error) {
+	e.mu.Lock()
+	e.calls++
+	call := e.calls
+	e.mu.Unlock()
+
+	ch := make(chan coreexecutor.StreamChunk, 2)
+	if call == 1 {
+		if e.tokensDelivered {
+			ch <- coreexecutor.StreamChunk{Payload: []byte("partial")}
+		}
+		ch <- coreexecutor.StreamChunk{Err: &coreexecutor.OverloadedError{Message: "Overloaded", TokensDelivered: e.tokensDelivered}}
+		close(ch)
+		return ch, nil
+	}
+	ch <- coreexecutor.StreamChunk{Payload: []byte("ok")}
+	close(ch)
+	return ch, nil
+}
+
+func runOverloadedStream(t *testing.T, executor *overloadedStreamExecutor) (string, []*interfaces.ErrorMessage) {
+	t.Helper()
+	manager := coreauth.NewManager(nil, nil, nil)
+	manager.RegisterExecutor(executor)
+	auth := &coreauth.Auth{ID: "overloaded-auth", Provider: "codex", Status: coreauth.StatusActive}
+	if _, err := manager.Register(context.Background(), auth); err != nil {
+		t.Fatalf("manager.Register: %v", err)
+	}
+	registry.GetGlobalRegistry().RegisterClient(auth.ID, auth.Provider, []*registry.ModelInfo{{ID: "overloaded-model"}})
+	t.Cleanup(func() { registry.GetGlobalRegistry().UnregisterClient(auth.ID) })
+
+	handler := NewBaseAPIHandlers(&sdkconfig.SDKConfig{}, manager)
+	dataChan, errChan := handler.ExecuteStreamWithAuthManager(context.Background(), "claude", "overloaded-model", []byte(`{"model":"overloaded-model"}`), "")
+	var got []byte
+	for chunk := range dataChan {
+		got = append(got, chunk...)
+	}
+	var errs []*interfaces.ErrorMessage
+	for msg := range errChan {
+		if msg != nil {
+			errs = append(errs, msg)
+		}
+	}
+	return string(got), errs
+}
+
+func TestExecuteStreamWithAuthManager_RetriesOverloadBeforeFirstToken(t *testing.T) {
+	executor := &overloadedStreamExecutor{}
+	got, errs := runOverloadedStream(t, executor)
+	if len(errs) != 0 || got != "ok" || executor.Calls() != 2 {
+		t.Fatalf("expected a transparent retry, got %q errs=%v calls=%d", got, errs, executor.Calls())
+	}
+}
+
+func TestExecuteStreamWithAuthManager_SurfacesOverloadAfterFirstToken(t *testing.T) {
+	executor := &overloadedStreamExecutor{tokensDelivered: true}
+	got, errs := runOverloadedStream(t, executor)
+	if got != "partial" || executor.Calls() != 1 {
+		t.Fatalf("expected no retry after tokens were delivered, got %q calls=%d", got, executor.Calls())
+	}
+	if len(errs) != 1 || errs[0].StatusCode != coreexecutor.StatusOverloaded {
+		t.Fatalf("expected a single 529 terminal error, got %v", errs)
+	}
+}
+
+// exhaustedBudget is used up by the first chunk.
+type exhaustedBudget struct {
+	released atomic.Bool
+}
+
+func (*exhaustedBudget) Consume([]byte) bool { return true }
+
+func (b *exhaustedBudget) Release() { b.released.Store(true) }
+
+func TestExecuteStreamWithAuthManager_EndsStreamWhenOutputBudgetIsUsedUp(t *testing.T) {
+	executor := &overloadedStreamExecutor{}
+	executor.calls = 1 // skip the overloaded first attempt
+	manager := coreauth.NewManager(nil, nil, nil)
+	manager.RegisterExecutor(executor)
+	auth := &coreauth.Auth{ID: "budget-auth", Provider: "codex", Status: coreauth.StatusActive}
+	if _, err := manager.Register(context.Background(), auth); err != nil {
+		t.Fatalf("manager.Register: %v", err)
+	}
+	registry.GetGlobalRegistry().RegisterClient(auth.ID, auth.Provider, []*registry.ModelInfo{{ID: "budget-model"}})
+	t.Cleanup(func() { registry.GetGlobalRegistry().UnregisterClient(auth.ID) })
+
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	budget := &exhaustedBudget{}
+	ginCtx.Set(StreamOutputBudgetContextKey, budget)
+	ctx := context.WithValue(context.Background(), "gin", ginCtx)
+
+	handler := NewBaseAPIHandlers(&sdkconfig.SDKConfig{}, manager)
+	dataChan, errChan := handler.ExecuteStreamWithAuthManager(ctx, "claude", "budget-model", []byte(`{"model":"budget-model"}`), "")
+	var got []byte
+	for chunk := range dataChan {
+		got = append(got, chunk...)
+	}
+	msg := <-errChan
+	var exhausted *QuotaExhaustedError
+	if len(got) != 0 || msg == nil || msg.StatusCode != http.StatusTooManyRequests || !errors.As(msg.Error, &exhausted) {
+		t.Fatalf("expected the chunk to be held back for a quota exhausted error, got %q %+v", got, msg)
+	}
+	if !budget.released.Load() {
+		t.Fatal("expected the stream to release its budget once it ended")
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/interfaces"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
 )
 
 type StreamForwardOptions struct {
@@ -29,6 +30,23 @@ type StreamForwardOptions struct {
 	WriteKeepAlive func()
 }
 
+// Stream abort reasons reported by cliproxy_stream_aborts_total
+const (
+	streamAbortClientGone   = "client_disconnect"
+	streamAbortWriteFailure = "write_failed"
+)
+
+var streamAborts = metrics.Default().NewCounterVec(
+	"cliproxy_stream_aborts_total",
+	"Streaming responses aborted before completion because the client went away or stopped reading.",
+	"reason",
+)
+
+// ForwardStream relays upstream chunks to the client as they arrive, flushing
+// after each chunk or once per configured flush interval. A client that goes
+// away, fails a write or stops reading past the configured write timeout ends
+// the stream and cancels the upstream request. flusher is kept for callers;
+// flushing goes through c.Writer.
 func (h *BaseAPIHandler) ForwardStream(c *gin.Context, flusher http.Flusher, cancel func(error), data <-chan []byte, errs <-chan *interfaces.ErrorMessage, opts StreamForwardOptions) {
 	if c == nil {
 		return
@@ -61,10 +79,45 @@ func (h *BaseAPIHandler) ForwardStream(c *gin.Context, flusher http.Flusher, can
 		keepAliveC = keepAlive.C
 	}
 
+	// Route the callers' writes through out so write failures end the stream.
+	out := newStreamOutput(c.Writer, StreamingWriteTimeout(h.Cfg))
+	c.Writer = out
+	defer func() {
+		out.clearDeadline()
+		c.Writer = out.ResponseWriter
+	}()
+
+	var flushTicker *time.Ticker
+	var flushC <-chan time.Time
+	if interval := StreamingFlushInterval(h.Cfg); interval > 0 {
+		flushTicker = time.NewTicker(interval)
+		defer flushTicker.Stop()
+		flushC = flushTicker.C
+	}
+	pending := false
+	// write runs fn and flushes now or on the next flush tick. It reports false
+	// when the client can no longer be written to, after cancelling upstream.
+	write := func(fn func(), immediate bool) bool {
+		out.arm()
+		fn()
+		if flushC != nil && !immediate {
+			pending = true
+			return true
+		}
+		pending = false
+		if err := out.flush(); err != nil {
+			streamAborts.Inc(streamAbortWriteFailure)
+			cancel(err)
+			return false
+		}
+		return true
+	}
+
 	var terminalErr *interfaces.ErrorMessage
 	for {
 		select {
 		case <-c.Request.Context().Done():
+			streamAborts.Inc(streamAbortClientGone)
 			cancel(c.Request.Context().Err())
 			return
 		case chunk, ok := <-data:
@@ -80,31 +133,35 @@ func (h *BaseAPIHandler) ForwardStream(c *gin.Context, flusher http.Flusher, can
 					}
 				}
 				if terminalErr != nil {
-					if opts.WriteTerminalError != nil {
-						opts.WriteTerminalError(terminalErr)
+					if write(func() {
+						if opts.WriteTerminalError != nil {
+							opts.WriteTerminalError(terminalErr)
+						}
+					}, true) {
+						cancel(terminalErr.Error)
 					}
-					flusher.Flush()
-					cancel(terminalErr.Error)
 					return
 				}
-				if opts.WriteDone != nil {
-					opts.WriteDone()
+				if write(func() {
+					if opts.WriteDone != nil {
+						opts.WriteDone()
+					}
+				}, true) {
+					cancel(nil)
 				}
-				flusher.Flush()
-				cancel(nil)
 				return
 			}
-			writeChunk(chunk)
-			flusher.Flush()
+			if !write(func() { writeChunk(chunk) }, false) {
+				return
+			}
 		case errMsg, ok := <-errs:
 			if !ok {
 				continue
 			}
 			if errMsg != nil {
 				terminalErr = errMsg
-				if opts.WriteTerminalError != nil {
-					opts.WriteTerminalError(errMsg)
-					flusher.Flush()
+				if opts.WriteTerminalError != nil && !write(func() { opts.WriteTerminalError(errMsg) }, true) {
+					return
 				}
 			}
 			var execErr error
@@ -113,9 +170,79 @@ func (h *BaseAPIHandler) ForwardStream(c *gin.Context, flusher http.Flusher, can
 			}
 			cancel(execErr)
 			return
+		case <-flushC:
+			if pending && !write(func() {}, true) {
+				return
+			}
 		case <-keepAliveC:
-			writeKeepAlive()
-			flusher.Flush()
+			if !write(writeKeepAlive, true) {
+				return
+			}
 		}
 	}
 }
+
+// streamOutput wraps the response writer for the duration of a stream. It
+// remembers the first write error, which is how a closed connection or an
+// expired write deadline surfaces, and bounds how long each write may block.
+type streamOutput struct {
+	gin.ResponseWriter
+	rc           *http.ResponseController
+	writeTimeout time.Duration
+	err          error
+}
+
+func newStreamOutput(w gin.ResponseWriter, writeTimeout time.Duration) *streamOutput {
+	o := &streamOutput{ResponseWriter: w, writeTimeout: writeTimeout}
+	o.rc = http.NewResponseController(w)
+	return o
+}
+
+func (o *streamOutput) Write(data []byte) (int, error) {
+	n, err := o.ResponseWriter.Write(data)
+	if err != nil && o.err == nil {
+		o.err = err
+	}
+	return n, err
+}
+
+func (o *streamOutput) WriteString(data string) (int, error) {
+	n, err := o.ResponseWriter.WriteString(data)
+	if err != nil && o.err == nil {
+		o.err = err
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (o *streamOutput) Unwrap() http.ResponseWriter {
+	return o.ResponseWriter
+}
+
+// arm sets the write deadline for the next write and flush.
+func (o *streamOutput) arm() {
+	if o.writeTimeout <= 0 {
+		return
+	}
+	if err := o.rc.SetWriteDeadline(time.Now().Add(o.writeTimeout)); err != nil {
+		// The writer chain does not expose the connection; write without a deadline.
+		o.writeTimeout = 0
+	}
+}
+
+// clearDeadline removes the write deadline so it does not outlive the stream.
+func (o *streamOutput) clearDeadline() {
+	if o.writeTimeout > 0 {
+		_ = o.rc.SetWriteDeadline(time.Time{})
+	}
+}
+
+// flush sends buffered data to the client. A failed flush is reported by the
+// next write, since buffered writers keep their error.
+func (o *streamOutput) flush() error {
+	if o.err != nil {
+		return o.err
+	}
+	o.ResponseWriter.Flush()
+	return o.err
+}
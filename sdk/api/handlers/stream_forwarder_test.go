@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/interfaces"
+	sdkconfig "github.com/router-for-me/CLIProxyAPI/v6/sdk/config"
+)
+
+// brokenWriter fails every write like a connection the client has closed.
+type brokenWriter struct {
+	header http.Header
+}
+
+func (w *brokenWriter) Header() http.Header       { return w.header }
+func (w *brokenWriter) WriteHeader(int)           {}
+func (w *brokenWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+func (w *brokenWriter) Flush()                    {}
+
+func TestForwardStreamCancelsUpstreamOnWriteFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(&brokenWriter{header: http.Header{}})
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	data := make(chan []byte, 2)
+	data <- []byte("data: one\n\n")
+	data <- []byte("data: two\n\n")
+	errs := make(chan *interfaces.ErrorMessage)
+
+	var cancelled error
+	calls := 0
+	h := &BaseAPIHandler{Cfg: &sdkconfig.SDKConfig{}}
+	h.ForwardStream(c, nil, func(err error) { calls++; cancelled = err }, data, errs, StreamForwardOptions{
+		WriteChunk: func(chunk []byte) { _, _ = c.Writer.Write(chunk) },
+	})
+
+	if calls != 1 || cancelled == nil {
+		t.Fatalf("expected upstream to be cancelled once with the write error, got %d calls, err=%v", calls, cancelled)
+	}
+	if len(data) != 1 {
+		t.Fatalf("expected forwarding to stop after the failed write, %d chunks left", len(data))
+	}
+}
+
+// countingRecorder counts flushes reaching the connection.
+type countingRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (w *countingRecorder) Flush() {
+	w.flushes++
+	w.ResponseRecorder.Flush()
+}
+
+func TestForwardStreamCoalescesFlushes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := &countingRecorder{ResponseRecorder: httptest.NewRecorder()}
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	data := make(chan []byte, 3)
+	for _, chunk := range []string{"a", "b", "c"} {
+		data <- []byte(chunk)
+	}
+	close(data)
+
+	h := &BaseAPIHandler{Cfg: &sdkconfig.SDKConfig{Streaming: sdkconfig.StreamingConfig{FlushIntervalMs: 60000}}}
+	h.ForwardStream(c, nil, func(error) {}, data, make(chan *interfaces.ErrorMessage), StreamForwardOptions{
+		WriteChunk: func(chunk []byte) { _, _ = c.Writer.Write(chunk) },
+	})
+
+	if rec.Body.String() != "abc" || rec.flushes != 1 {
+		t.Fatalf("expected one flush of all chunks at stream end, got %q after %d flushes", rec.Body.String(), rec.flushes)
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BYOKContextKey is the gin context key holding the upstream provider key a
+// bring-your-own-key client supplied with its request.
+const BYOKContextKey = "byokUpstreamKey"
+
+// BYOKAttribute marks an auth copy whose credential was supplied by the client.
+const BYOKAttribute = "byok"
+
+// byokKeyFromContext returns the client-supplied upstream key, or "".
+func byokKeyFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil {
+		return ""
+	}
+	return strings.TrimSpace(ginCtx.GetString(BYOKContextKey))
+}
+
+// withUpstreamKey returns a copy of auth that authenticates upstream with key
+// instead of the shared credential. The selected auth only provides the base
+// URL, proxy and other provider settings.
+func withUpstreamKey(auth *Auth, key string) *Auth {
+	byok := auth.Clone()
+	if byok.Attributes == nil {
+		byok.Attributes = make(map[string]string, 2)
+	}
+	byok.Attributes["api_key"] = key
+	byok.Attributes[BYOKAttribute] = "true"
+	return byok
+}
+
+// IsBYOK reports whether auth carries a client-supplied credential.
+func (a *Auth) IsBYOK() bool {
+	return a != nil && a.Attributes != nil && a.Attributes[BYOKAttribute] == "true"
+}
+
+// acquireShared takes a fair-share slot on the shared auth. Bring-your-own-key
+// requests do not use shared capacity and never wait.
+func (m *Manager) acquireShared(ctx context.Context, authID string) (func(), error) {
+	if byokKeyFromContext(ctx) != "" {
+		return func() {}, nil
+	}
+	return m.fairShare.acquire(ctx, authID, clientKeyFromContext(ctx))
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+)
+
+type quotaError struct{}
+
+func (quotaError) Error() string   { return "quota exceeded" }
+func (quotaError) StatusCode() int { return http.StatusTooManyRequests }
+
+// keyRecordingExecutor fails every call and records the upstream key it was given.
+type keyRecordingExecutor struct {
+	payloadExecutor
+	keys []string
+}
+
+func (e *keyRecordingExecutor) Execute(_ context.Context, auth *Auth, _ cliproxyexecutor.Request, _ cliproxyexecutor.Options) (cliproxyexecutor.Response, error) {
+	e.keys = append(e.keys, auth.Attributes["api_key"])
+	return cliproxyexecutor.Response{}, quotaError{}
+}
+
+func TestBYOKUsesClientKeyAndSparesSharedAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewManager(nil, &RoundRobinSelector{}, nil)
+	executor := &keyRecordingExecutor{}
+	m.RegisterExecutor(executor)
+	for _, id := range []string{"a", "b"} {
+		if _, err := m.Register(context.Background(), &Auth{ID: id, Provider: "claude", Attributes: map[string]string{"api_key": "shared-" + id}}); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+	}
+
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Set(BYOKContextKey, "sk-client")
+	ctx := context.WithValue(context.Background(), "gin", ginCtx)
+	if _, err := m.executeWithProvider(ctx, "claude", cliproxyexecutor.Request{}, cliproxyexecutor.Options{}); err == nil {
+		t.Fatal("expected the upstream error to be returned")
+	}
+	if len(executor.keys) != 1 || executor.keys[0] != "sk-client" {
+		t.Fatalf("expected a single call with the client key, got %v", executor.keys)
+	}
+	for _, id := range []string{"a", "b"} {
+		auth, _ := m.GetByID(id)
+		if auth.Unavailable || auth.LastError != nil || auth.Attributes["api_key"] != "shared-"+id {
+			t.Fatalf("expected shared auth %s to be untouched, got %+v", id, auth)
+		}
+	}
+
+	if _, err := m.executeWithProvider(context.Background(), "claude", cliproxyexecutor.Request{}, cliproxyexecutor.Options{}); err == nil {
+		t.Fatal("expected the upstream error to be returned")
+	}
+	if len(executor.keys) != 3 || executor.keys[1] == "sk-client" {
+		t.Fatalf("expected shared keys without BYOK, got %v", executor.keys)
+	}
+}
@@ -0,0 +1,401 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Circuit breaker states.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+const (
+	defaultBreakerWindow      = time.Minute
+	defaultBreakerMinRequests = 10
+	defaultBreakerErrorRate   = 0.5
+	defaultBreakerSlowRate    = 0.5
+	defaultBreakerCooldown    = 30 * time.Second
+	// breakerBuckets is how many slices the rolling window is divided into.
+	breakerBuckets = 10
+)
+
+var circuitTransitions = metrics.Default().NewCounterVec(
+	"cliproxy_upstream_circuit_transitions_total",
+	"Upstream circuit breaker state changes by endpoint and new state.",
+	"endpoint", "state",
+)
+
+// CircuitStatus is the circuit breaker state of one upstream endpoint.
+type CircuitStatus struct {
+	Endpoint string `json:"endpoint"`
+	State    string `json:"state"`
+	// Requests, Failures and SlowCalls count the current rolling window.
+	Requests  int     `json:"requests"`
+	Failures  int     `json:"failures"`
+	SlowCalls int     `json:"slow_calls"`
+	ErrorRate float64 `json:"error_rate"`
+	// Trips is how often the circuit opened since the proxy started.
+	Trips     int       `json:"trips"`
+	OpenedAt  time.Time `json:"opened_at,omitempty"`
+	RetryAt   time.Time `json:"retry_at,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type breakerSettings struct {
+	enabled     bool
+	window      time.Duration
+	minRequests int
+	errorRate   float64
+	slowCall    time.Duration
+	slowRate    float64
+	cooldown    time.Duration
+	halfOpen    int
+}
+
+type breakerBucket struct {
+	epoch     int64
+	requests  int
+	failures  int
+	slowCalls int
+}
+
+type circuit struct {
+	state    string
+	buckets  [breakerBuckets]breakerBucket
+	openedAt time.Time
+	// trials counts half-open requests in flight; successes counts the ones that passed.
+	trials    int
+	successes int
+	// trialAt is when the last trial was admitted; trials that never report back
+	// (e.g. cancelled before reaching the upstream) are forgotten after the cooldown.
+	trialAt   time.Time
+	trips     int
+	lastError string
+}
+
+// circuitBreakers tracks one circuit per upstream endpoint.
+type circuitBreakers struct {
+	mu       sync.Mutex
+	settings breakerSettings
+	circuits map[string]*circuit
+}
+
+func newCircuitBreakers() *circuitBreakers {
+	return &circuitBreakers{circuits: make(map[string]*circuit)}
+}
+
+// configure applies new settings; disabling the breaker forgets all circuits.
+func (b *circuitBreakers) configure(cfg internalconfig.CircuitBreakerConfig) {
+	settings := breakerSettings{
+		enabled:     cfg.Enabled,
+		window:      time.Duration(cfg.Window) * time.Second,
+		minRequests: cfg.MinRequests,
+		errorRate:   cfg.ErrorRate,
+		slowCall:    time.Duration(cfg.SlowCallDuration) * time.Millisecond,
+		slowRate:    cfg.SlowCallRate,
+		cooldown:    time.Duration(cfg.Cooldown) * time.Second,
+		halfOpen:    cfg.HalfOpenRequests,
+	}
+	if settings.window <= 0 {
+		settings.window = defaultBreakerWindow
+	}
+	if settings.minRequests <= 0 {
+		settings.minRequests = defaultBreakerMinRequests
+	}
+	if settings.errorRate <= 0 || settings.errorRate > 1 {
+		settings.errorRate = defaultBreakerErrorRate
+	}
+	if settings.slowRate <= 0 || settings.slowRate > 1 {
+		settings.slowRate = defaultBreakerSlowRate
+	}
+	if settings.cooldown <= 0 {
+		settings.cooldown = defaultBreakerCooldown
+	}
+	if settings.halfOpen <= 0 {
+		settings.halfOpen = 1
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !settings.enabled || settings.window != b.settings.window {
+		b.circuits = make(map[string]*circuit)
+	}
+	b.settings = settings
+}
+
+// bucketEpoch returns the index of the window slice t falls into.
+func (b *circuitBreakers) bucketEpoch(t time.Time) int64 {
+	return t.UnixNano() / int64(b.settings.window/breakerBuckets)
+}
+
+// totals sums the buckets still inside the rolling window. Callers must hold b.mu.
+func (b *circuitBreakers) totals(c *circuit, now time.Time) (requests, failures, slowCalls int) {
+	oldest := b.bucketEpoch(now) - breakerBuckets
+	for _, bucket := range c.buckets {
+		if bucket.epoch > oldest {
+			requests += bucket.requests
+			failures += bucket.failures
+			slowCalls += bucket.slowCalls
+		}
+	}
+	return requests, failures, slowCalls
+}
+
+// currentState returns the circuit state, half-opening an open circuit whose
+// cooldown has run out. Callers must hold b.mu.
+func (b *circuitBreakers) currentState(endpoint string, c *circuit, now time.Time) string {
+	if c.state == CircuitOpen && !now.Before(c.openedAt.Add(b.settings.cooldown)) {
+		c.state = CircuitHalfOpen
+		c.trials, c.successes = 0, 0
+		circuitTransitions.Inc(endpoint, CircuitHalfOpen)
+		log.Infof("upstream circuit for %s half-open, letting trial requests through", endpoint)
+	}
+	if c.state == CircuitHalfOpen && c.trials > 0 && now.After(c.trialAt.Add(b.settings.cooldown)) {
+		c.trials = 0
+	}
+	return c.state
+}
+
+// allows reports whether a request may be sent to the endpoint. Callers must hold b.mu.
+func (b *circuitBreakers) allows(endpoint string, now time.Time) bool {
+	c := b.circuits[endpoint]
+	if c == nil {
+		return true
+	}
+	switch b.currentState(endpoint, c, now) {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return c.trials < b.settings.halfOpen-c.successes
+	}
+	return true
+}
+
+// filter drops candidates whose endpoint circuit rejects traffic. Unlike
+// endpoint health it can leave no candidates; the earliest time an open
+// circuit half-opens is returned for the error message.
+func (b *circuitBreakers) filter(candidates []*Auth, now time.Time) ([]*Auth, time.Time) {
+	if b == nil || len(candidates) == 0 {
+		return candidates, time.Time{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.settings.enabled || len(b.circuits) == 0 {
+		return candidates, time.Time{}
+	}
+	kept := make([]*Auth, 0, len(candidates))
+	var retryAt time.Time
+	for _, candidate := range candidates {
+		endpoint := endpointKey(candidate)
+		if b.allows(endpoint, now) {
+			kept = append(kept, candidate)
+			continue
+		}
+		if c := b.circuits[endpoint]; c.state == CircuitOpen {
+			if at := c.openedAt.Add(b.settings.cooldown); retryAt.IsZero() || at.Before(retryAt) {
+				retryAt = at
+			}
+		}
+	}
+	return kept, retryAt
+}
+
+// admit reserves a half-open trial slot for a request about to be sent to the endpoint.
+func (b *circuitBreakers) admit(endpoint string, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.circuits[endpoint]; c != nil && c.state == CircuitHalfOpen {
+		c.trials++
+		c.trialAt = now
+	}
+}
+
+// record feeds a request outcome into the endpoint's circuit. Neutral outcomes,
+// such as client errors, only release a half-open trial slot.
+func (b *circuitBreakers) record(endpoint string, failed, neutral bool, latency time.Duration, reason string, now time.Time) {
+	if b == nil || endpoint == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.settings
+	if !s.enabled {
+		return
+	}
+	c := b.circuits[endpoint]
+	if c == nil {
+		if neutral {
+			return
+		}
+		c = &circuit{state: CircuitClosed}
+		b.circuits[endpoint] = c
+	}
+	slow := s.slowCall > 0 && latency >= s.slowCall
+	if failed {
+		c.lastError = reason
+	} else if slow {
+		c.lastError = fmt.Sprintf("slow response (%s)", latency.Round(time.Millisecond))
+	}
+
+	switch b.currentState(endpoint, c, now) {
+	case CircuitOpen:
+		// Late results of requests sent before the circuit opened.
+		return
+	case CircuitHalfOpen:
+		if c.trials > 0 {
+			c.trials--
+		}
+		switch {
+		case neutral:
+		case failed || slow:
+			b.open(endpoint, c, now, "trial request failed: "+c.lastError)
+		default:
+			c.successes++
+			if c.successes >= s.halfOpen {
+				c.state = CircuitClosed
+				c.buckets = [breakerBuckets]breakerBucket{}
+				circuitTransitions.Inc(endpoint, CircuitClosed)
+				log.Infof("upstream circuit for %s closed after %d successful trial request(s)", endpoint, c.successes)
+			}
+		}
+		return
+	}
+	if neutral {
+		return
+	}
+
+	epoch := b.bucketEpoch(now)
+	bucket := &c.buckets[epoch%breakerBuckets]
+	if bucket.epoch != epoch {
+		*bucket = breakerBucket{epoch: epoch}
+	}
+	bucket.requests++
+	if failed {
+		bucket.failures++
+	}
+	if slow {
+		bucket.slowCalls++
+	}
+	requests, failures, slowCalls := b.totals(c, now)
+	if requests < s.minRequests {
+		return
+	}
+	switch {
+	case float64(failures) >= s.errorRate*float64(requests):
+		b.open(endpoint, c, now, fmt.Sprintf("%d of %d requests failed in the last %s", failures, requests, s.window))
+	case s.slowCall > 0 && float64(slowCalls) >= s.slowRate*float64(requests):
+		b.open(endpoint, c, now, fmt.Sprintf("%d of %d requests took longer than %s", slowCalls, requests, s.slowCall))
+	}
+}
+
+// open trips the circuit. Callers must hold b.mu.
+func (b *circuitBreakers) open(endpoint string, c *circuit, now time.Time, why string) {
+	c.state = CircuitOpen
+	c.openedAt = now
+	c.trials, c.successes = 0, 0
+	c.trips++
+	c.buckets = [breakerBuckets]breakerBucket{}
+	circuitTransitions.Inc(endpoint, CircuitOpen)
+	log.Warnf("upstream circuit for %s opened for %s: %s", endpoint, b.settings.cooldown, why)
+}
+
+// status lists every tracked circuit, sorted by endpoint.
+func (b *circuitBreakers) status(now time.Time) []CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]CircuitStatus, 0, len(b.circuits))
+	for endpoint, c := range b.circuits {
+		state := b.currentState(endpoint, c, now)
+		requests, failures, slowCalls := b.totals(c, now)
+		st := CircuitStatus{
+			Endpoint:  endpoint,
+			State:     state,
+			Requests:  requests,
+			Failures:  failures,
+			SlowCalls: slowCalls,
+			Trips:     c.trips,
+			LastError: c.lastError,
+		}
+		if requests > 0 {
+			st.ErrorRate = float64(failures) / float64(requests)
+		}
+		if state != CircuitClosed {
+			st.OpenedAt = c.openedAt
+		}
+		if state == CircuitOpen {
+			st.RetryAt = c.openedAt.Add(b.settings.cooldown)
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Endpoint < out[j].Endpoint })
+	return out
+}
+
+// reset closes the circuit of an endpoint, or of every endpoint when endpoint
+// is empty. It reports how many circuits were reset.
+func (b *circuitBreakers) reset(endpoint string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if endpoint == "" {
+		n := len(b.circuits)
+		b.circuits = make(map[string]*circuit)
+		return n
+	}
+	if _, ok := b.circuits[endpoint]; !ok {
+		return 0
+	}
+	delete(b.circuits, endpoint)
+	return 1
+}
+
+// CircuitBreakers returns the circuit state of every upstream endpoint that has served traffic.
+func (m *Manager) CircuitBreakers() []CircuitStatus {
+	if m == nil || m.breakers == nil {
+		return nil
+	}
+	return m.breakers.status(time.Now())
+}
+
+// ResetCircuitBreaker closes the circuit of one endpoint, or all circuits when
+// endpoint is empty, and returns how many were reset.
+func (m *Manager) ResetCircuitBreaker(endpoint string) int {
+	if m == nil || m.breakers == nil {
+		return 0
+	}
+	return m.breakers.reset(endpoint)
+}
+
+// circuitOpenError is returned when every upstream of a provider has an open circuit.
+func circuitOpenError(provider string, retryAt time.Time) *Error {
+	msg := fmt.Sprintf("all upstreams for %s are temporarily unavailable (circuit open)", provider)
+	if !retryAt.IsZero() {
+		msg += fmt.Sprintf("; retry after %s", retryAt.UTC().Format(time.RFC3339))
+	}
+	return &Error{Code: "circuit_open", Message: msg, Retryable: true, HTTPStatus: http.StatusServiceUnavailable}
+}
+
+// recordCircuitResult feeds an execution result into the endpoint's circuit breaker.
+func (m *Manager) recordCircuitResult(result Result) {
+	m.mu.RLock()
+	endpoint := endpointKey(m.auths[result.AuthID])
+	m.mu.RUnlock()
+	failed := !result.Success && isEndpointFailure(result.Error)
+	reason := ""
+	if result.Error != nil {
+		reason = result.Error.Message
+	}
+	m.breakers.record(endpoint, failed, !result.Success && !failed, result.Latency, reason, time.Now())
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+)
+
+const (
+	primaryEndpoint = "https://primary.example.com"
+	backupEndpoint  = "https://backup.example.com"
+)
+
+func pickID(t *testing.T, m *Manager) (string, error) {
+	t.Helper()
+	auth, _, err := m.pickNext(context.Background(), "claude", "", cliproxyexecutor.Options{}, map[string]struct{}{})
+	if err != nil {
+		return "", err
+	}
+	return auth.ID, nil
+}
+
+func TestCircuitBreakerOpensFailsOverAndRecovers(t *testing.T) {
+	m := newRoutingTestManager(t, internalconfig.RoutingConfig{CircuitBreaker: internalconfig.CircuitBreakerConfig{
+		Enabled: true, MinRequests: 4, ErrorRate: 0.5, Cooldown: 30,
+	}})
+	now := time.Now()
+	for i, failed := range []bool{false, true, false, true} {
+		m.breakers.record(primaryEndpoint, failed, false, 0, "bad gateway", now.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	for i := 0; i < 3; i++ {
+		if id, err := pickID(t, m); err != nil || id != "backup" {
+			t.Fatalf("expected failover to backup, got %q, %v", id, err)
+		}
+	}
+	status := m.CircuitBreakers()
+	if len(status) != 1 || status[0].State != CircuitOpen || status[0].Trips != 1 || status[0].RetryAt.IsZero() {
+		t.Fatalf("unexpected circuit status %+v", status)
+	}
+
+	for i := 0; i < 4; i++ {
+		m.breakers.record(backupEndpoint, true, false, 0, "timeout", now)
+	}
+	_, err := pickID(t, m)
+	var authErr *Error
+	if !errors.As(err, &authErr) || authErr.Code != "circuit_open" || authErr.HTTPStatus != http.StatusServiceUnavailable {
+		t.Fatalf("expected a fast 503 with every circuit open, got %v", err)
+	}
+
+	// After the cooldown a single trial request is let through; its success closes the circuit.
+	m.breakers.mu.Lock()
+	m.breakers.circuits[primaryEndpoint].openedAt = now.Add(-time.Minute)
+	m.breakers.mu.Unlock()
+	if id, errPick := pickID(t, m); errPick != nil || id != "primary" {
+		t.Fatalf("expected the half-open trial to reach primary, got %q, %v", id, errPick)
+	}
+	if _, errPick := pickID(t, m); errPick == nil {
+		t.Fatal("expected no second trial while the first is in flight")
+	}
+	m.MarkResult(context.Background(), Result{AuthID: "primary", Provider: "claude", Success: true})
+	if status = m.CircuitBreakers(); status[1].Endpoint != primaryEndpoint || status[1].State != CircuitClosed {
+		t.Fatalf("expected primary to close after the trial, got %+v", status)
+	}
+}
+
+func TestCircuitBreakerTripsOnSlowCallsAndReopensOnFailedTrial(t *testing.T) {
+	m := newRoutingTestManager(t, internalconfig.RoutingConfig{CircuitBreaker: internalconfig.CircuitBreakerConfig{
+		Enabled: true, MinRequests: 2, SlowCallDuration: 500, SlowCallRate: 1,
+	}})
+	now := time.Now()
+	m.breakers.record(primaryEndpoint, false, false, 100*time.Millisecond, "", now)
+	m.breakers.record(primaryEndpoint, false, false, time.Second, "", now)
+	if status := m.CircuitBreakers(); status[0].State != CircuitClosed || status[0].SlowCalls != 1 {
+		t.Fatalf("expected the circuit to stay closed below the slow-call rate, got %+v", status)
+	}
+	m.breakers.reset("")
+	m.breakers.record(primaryEndpoint, false, false, time.Second, "", now)
+	m.breakers.record(primaryEndpoint, false, false, 2*time.Second, "", now)
+	if status := m.CircuitBreakers(); status[0].State != CircuitOpen {
+		t.Fatalf("expected slow calls to open the circuit, got %+v", status)
+	}
+
+	later := now.Add(time.Minute)
+	m.breakers.mu.Lock()
+	allowed := m.breakers.allows(primaryEndpoint, later)
+	m.breakers.mu.Unlock()
+	if !allowed {
+		t.Fatal("expected the circuit to half-open after the cooldown")
+	}
+	m.breakers.admit(primaryEndpoint, later)
+	// Client errors neither close nor reopen a half-open circuit.
+	m.breakers.record(primaryEndpoint, false, true, 0, "bad request", later)
+	m.breakers.admit(primaryEndpoint, later)
+	m.breakers.record(primaryEndpoint, true, false, 0, "bad gateway", later)
+	if status := m.CircuitBreakers(); status[0].State != CircuitOpen || status[0].Trips != 2 {
+		t.Fatalf("expected a failed trial to reopen the circuit, got %+v", status)
+	}
+	if n := m.ResetCircuitBreaker(primaryEndpoint); n != 1 || len(m.CircuitBreakers()) != 0 {
+		t.Fatalf("expected reset to forget the circuit, got %d", n)
+	}
+}
@@ -13,8 +13,11 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/events"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/logging"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/registry"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/reqfeatures"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/util"
 	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
 	log "github.com/sirupsen/logrus"
@@ -68,6 +71,13 @@ type Result struct {
 	RetryAfter *time.Duration
 	// Error describes the failure when Success is false.
 	Error *Error
+	// Latency is how long the upstream took to answer, up to the start of the
+	// stream for streaming requests. Zero when unknown.
+	Latency time.Duration
+
+	// transientBackoff replaces the cooldown of a transient failure the upstream
+	// retry policy resends, so the request retry loop picks the credential again.
+	transientBackoff time.Duration
 }
 
 // Selector chooses an auth candidate for execution.
@@ -118,6 +128,30 @@ type Manager struct {
 	// Optional HTTP RoundTripper provider injected by host.
 	rtProvider RoundTripperProvider
 
+	// fairShare schedules client keys sharing one upstream auth.
+	fairShare *fairScheduler
+
+	// rotations drains traffic from retiring credentials to their replacements.
+	rotations *rotationTracker
+
+	// upstreams applies per-model routing rules and upstream endpoint health.
+	upstreams *upstreamRouter
+
+	// breakers stops traffic to upstream endpoints with high error rates or latency.
+	breakers *circuitBreakers
+
+	// sticky pins client keys or conversations to one upstream credential.
+	sticky *stickyRouter
+
+	// upstreamRetry resends transient upstream failures; nil when disabled.
+	upstreamRetry atomic.Pointer[retryPolicy]
+
+	// contracts holds assertions checked against non-streaming upstream responses.
+	contracts atomic.Pointer[contractChecker]
+
+	// pendingHealth holds snapshot health for auths that have not registered yet.
+	pendingHealth map[string]HealthState
+
 	// Auto refresh state
 	refreshCancel context.CancelFunc
 }
@@ -137,6 +171,11 @@ func NewManager(store Store, selector Selector, hook Hook) *Manager {
 		hook:            hook,
 		auths:           make(map[string]*Auth),
 		providerOffsets: make(map[string]int),
+		fairShare:       newFairScheduler(),
+		rotations:       newRotationTracker(),
+		upstreams:       newUpstreamRouter(),
+		breakers:        newCircuitBreakers(),
+		sticky:          newStickyRouter(),
 	}
 }
 
@@ -212,6 +251,7 @@ func (m *Manager) Register(ctx context.Context, auth *Auth) (*Auth, error) {
 	}
 	auth.EnsureIndex()
 	m.mu.Lock()
+	m.applyPendingHealth(auth)
 	m.auths[auth.ID] = auth.Clone()
 	m.mu.Unlock()
 	_ = m.persist(ctx, auth)
@@ -230,6 +270,7 @@ func (m *Manager) Update(ctx context.Context, auth *Auth) (*Auth, error) {
 		auth.indexAssigned = existing.indexAssigned
 	}
 	auth.EnsureIndex()
+	m.applyPendingHealth(auth)
 	m.auths[auth.ID] = auth.Clone()
 	m.mu.Unlock()
 	_ = m.persist(ctx, auth)
@@ -273,10 +314,11 @@ func (m *Manager) Execute(ctx context.Context, providers []string, req cliproxye
 	if attempts < 1 {
 		attempts = 1
 	}
+	rotated, attempts = limitFallback(ctx, rotated, attempts)
 
 	var lastErr error
 	for attempt := 0; attempt < attempts; attempt++ {
-		resp, errExec := m.executeProvidersOnce(ctx, rotated, func(execCtx context.Context, provider string) (cliproxyexecutor.Response, error) {
+		resp, errExec := m.executeProvidersOnce(withRetryAttempt(ctx, attempt), rotated, func(execCtx context.Context, provider string) (cliproxyexecutor.Response, error) {
 			return m.executeWithProvider(execCtx, provider, req, opts)
 		})
 		if errExec == nil {
@@ -311,10 +353,11 @@ func (m *Manager) ExecuteCount(ctx context.Context, providers []string, req clip
 	if attempts < 1 {
 		attempts = 1
 	}
+	rotated, attempts = limitFallback(ctx, rotated, attempts)
 
 	var lastErr error
 	for attempt := 0; attempt < attempts; attempt++ {
-		resp, errExec := m.executeProvidersOnce(ctx, rotated, func(execCtx context.Context, provider string) (cliproxyexecutor.Response, error) {
+		resp, errExec := m.executeProvidersOnce(withRetryAttempt(ctx, attempt), rotated, func(execCtx context.Context, provider string) (cliproxyexecutor.Response, error) {
 			return m.executeCountWithProvider(execCtx, provider, req, opts)
 		})
 		if errExec == nil {
@@ -349,10 +392,11 @@ func (m *Manager) ExecuteStream(ctx context.Context, providers []string, req cli
 	if attempts < 1 {
 		attempts = 1
 	}
+	rotated, attempts = limitFallback(ctx, rotated, attempts)
 
 	var lastErr error
 	for attempt := 0; attempt < attempts; attempt++ {
-		chunks, errStream := m.executeStreamProvidersOnce(ctx, rotated, func(execCtx context.Context, provider string) (<-chan cliproxyexecutor.StreamChunk, error) {
+		chunks, errStream := m.executeStreamProvidersOnce(withRetryAttempt(ctx, attempt), rotated, func(execCtx context.Context, provider string) (<-chan cliproxyexecutor.StreamChunk, error) {
 			return m.executeStreamWithProvider(execCtx, provider, req, opts)
 		})
 		if errStream == nil {
@@ -380,9 +424,19 @@ func (m *Manager) executeWithProvider(ctx context.Context, provider string, req
 	routeModel := req.Model
 	tried := make(map[string]struct{})
 	var lastErr error
+	// failedAuth and failCause describe the previous credential when failing over.
+	var (
+		failedAuth string
+		failCause  error
+	)
+	// violatingResp is returned when every credential failed contract checks.
+	var violatingResp *cliproxyexecutor.Response
 	for {
 		auth, executor, errPick := m.pickNext(ctx, provider, routeModel, opts, tried)
 		if errPick != nil {
+			if violatingResp != nil {
+				return *violatingResp, nil
+			}
 			if lastErr != nil {
 				return cliproxyexecutor.Response{}, lastErr
 			}
@@ -391,8 +445,15 @@ func (m *Manager) executeWithProvider(ctx context.Context, provider string, req
 
 		entry := logEntryWithRequestID(ctx)
 		debugLogAuthSelection(entry, auth, provider, req.Model)
+		if failedAuth != "" {
+			publishFailover(ctx, provider, routeModel, failedAuth, auth.ID, failCause)
+		}
 
 		tried[auth.ID] = struct{}{}
+		byokKey := byokKeyFromContext(ctx)
+		if byokKey != "" {
+			auth = withUpstreamKey(auth, byokKey)
+		}
 		execCtx := ctx
 		if rt := m.roundTripperFor(auth); rt != nil {
 			execCtx = context.WithValue(execCtx, roundTripperContextKey{}, rt)
@@ -401,8 +462,14 @@ func (m *Manager) executeWithProvider(ctx context.Context, provider string, req
 		execReq := req
 		execReq.Model, execReq.Metadata = rewriteModelForAuth(routeModel, req.Metadata, auth)
 		execReq.Model, execReq.Metadata = m.applyOAuthModelMapping(auth, execReq.Model, execReq.Metadata)
+		release, errWait := m.acquireShared(ctx, auth.ID)
+		if errWait != nil {
+			return cliproxyexecutor.Response{}, errWait
+		}
+		started := time.Now()
 		resp, errExec := executor.Execute(execCtx, auth, execReq, opts)
-		result := Result{AuthID: auth.ID, Provider: provider, Model: routeModel, Success: errExec == nil}
+		release()
+		result := Result{AuthID: auth.ID, Provider: provider, Model: routeModel, Success: errExec == nil, Latency: time.Since(started)}
 		if errExec != nil {
 			result.Error = &Error{Message: errExec.Error()}
 			var se cliproxyexecutor.StatusError
@@ -412,10 +479,27 @@ func (m *Manager) executeWithProvider(ctx context.Context, provider string, req
 			if ra := retryAfterFromError(errExec); ra != nil {
 				result.RetryAfter = ra
 			}
+			result.transientBackoff = m.transientBackoff(ctx, provider, errExec)
 			m.MarkResult(execCtx, result)
+			if byokKey != "" {
+				// Other shared credentials would be called with the same client key.
+				return cliproxyexecutor.Response{}, errExec
+			}
 			lastErr = errExec
+			failedAuth, failCause = auth.ID, errExec
 			continue
 		}
+		if violated, failover := m.checkContract(provider, opts.SourceFormat.String(), resp.Payload); len(violated) > 0 {
+			entry.Warnf("upstream response from auth %s failed contract checks: %s", auth.ID, strings.Join(violated, ", "))
+			if failover {
+				result.Success = false
+				result.Error = contractError(violated)
+				m.MarkResult(execCtx, result)
+				violatingResp = &resp
+				failedAuth, failCause = auth.ID, result.Error
+				continue
+			}
+		}
 		m.MarkResult(execCtx, result)
 		return resp, nil
 	}
@@ -428,6 +512,11 @@ func (m *Manager) executeCountWithProvider(ctx context.Context, provider string,
 	routeModel := req.Model
 	tried := make(map[string]struct{})
 	var lastErr error
+	// failedAuth and failCause describe the previous credential when failing over.
+	var (
+		failedAuth string
+		failCause  error
+	)
 	for {
 		auth, executor, errPick := m.pickNext(ctx, provider, routeModel, opts, tried)
 		if errPick != nil {
@@ -439,8 +528,15 @@ func (m *Manager) executeCountWithProvider(ctx context.Context, provider string,
 
 		entry := logEntryWithRequestID(ctx)
 		debugLogAuthSelection(entry, auth, provider, req.Model)
+		if failedAuth != "" {
+			publishFailover(ctx, provider, routeModel, failedAuth, auth.ID, failCause)
+		}
 
 		tried[auth.ID] = struct{}{}
+		byokKey := byokKeyFromContext(ctx)
+		if byokKey != "" {
+			auth = withUpstreamKey(auth, byokKey)
+		}
 		execCtx := ctx
 		if rt := m.roundTripperFor(auth); rt != nil {
 			execCtx = context.WithValue(execCtx, roundTripperContextKey{}, rt)
@@ -449,8 +545,14 @@ func (m *Manager) executeCountWithProvider(ctx context.Context, provider string,
 		execReq := req
 		execReq.Model, execReq.Metadata = rewriteModelForAuth(routeModel, req.Metadata, auth)
 		execReq.Model, execReq.Metadata = m.applyOAuthModelMapping(auth, execReq.Model, execReq.Metadata)
+		release, errWait := m.acquireShared(ctx, auth.ID)
+		if errWait != nil {
+			return cliproxyexecutor.Response{}, errWait
+		}
+		started := time.Now()
 		resp, errExec := executor.CountTokens(execCtx, auth, execReq, opts)
-		result := Result{AuthID: auth.ID, Provider: provider, Model: routeModel, Success: errExec == nil}
+		release()
+		result := Result{AuthID: auth.ID, Provider: provider, Model: routeModel, Success: errExec == nil, Latency: time.Since(started)}
 		if errExec != nil {
 			result.Error = &Error{Message: errExec.Error()}
 			var se cliproxyexecutor.StatusError
@@ -460,8 +562,14 @@ func (m *Manager) executeCountWithProvider(ctx context.Context, provider string,
 			if ra := retryAfterFromError(errExec); ra != nil {
 				result.RetryAfter = ra
 			}
+			result.transientBackoff = m.transientBackoff(ctx, provider, errExec)
 			m.MarkResult(execCtx, result)
+			if byokKey != "" {
+				// Other shared credentials would be called with the same client key.
+				return cliproxyexecutor.Response{}, errExec
+			}
 			lastErr = errExec
+			failedAuth, failCause = auth.ID, errExec
 			continue
 		}
 		m.MarkResult(execCtx, result)
@@ -476,6 +584,11 @@ func (m *Manager) executeStreamWithProvider(ctx context.Context, provider string
 	routeModel := req.Model
 	tried := make(map[string]struct{})
 	var lastErr error
+	// failedAuth and failCause describe the previous credential when failing over.
+	var (
+		failedAuth string
+		failCause  error
+	)
 	for {
 		auth, executor, errPick := m.pickNext(ctx, provider, routeModel, opts, tried)
 		if errPick != nil {
@@ -487,8 +600,15 @@ func (m *Manager) executeStreamWithProvider(ctx context.Context, provider string
 
 		entry := logEntryWithRequestID(ctx)
 		debugLogAuthSelection(entry, auth, provider, req.Model)
+		if failedAuth != "" {
+			publishFailover(ctx, provider, routeModel, failedAuth, auth.ID, failCause)
+		}
 
 		tried[auth.ID] = struct{}{}
+		byokKey := byokKeyFromContext(ctx)
+		if byokKey != "" {
+			auth = withUpstreamKey(auth, byokKey)
+		}
 		execCtx := ctx
 		if rt := m.roundTripperFor(auth); rt != nil {
 			execCtx = context.WithValue(execCtx, roundTripperContextKey{}, rt)
@@ -497,22 +617,37 @@ func (m *Manager) executeStreamWithProvider(ctx context.Context, provider string
 		execReq := req
 		execReq.Model, execReq.Metadata = rewriteModelForAuth(routeModel, req.Metadata, auth)
 		execReq.Model, execReq.Metadata = m.applyOAuthModelMapping(auth, execReq.Model, execReq.Metadata)
+		release, errWait := m.acquireShared(ctx, auth.ID)
+		if errWait != nil {
+			return nil, errWait
+		}
+		started := time.Now()
 		chunks, errStream := executor.ExecuteStream(execCtx, auth, execReq, opts)
+		latency := time.Since(started)
 		if errStream != nil {
+			release()
 			rerr := &Error{Message: errStream.Error()}
 			var se cliproxyexecutor.StatusError
 			if errors.As(errStream, &se) && se != nil {
 				rerr.HTTPStatus = se.StatusCode()
 			}
-			result := Result{AuthID: auth.ID, Provider: provider, Model: routeModel, Success: false, Error: rerr}
+			result := Result{AuthID: auth.ID, Provider: provider, Model: routeModel, Success: false, Error: rerr, Latency: latency}
 			result.RetryAfter = retryAfterFromError(errStream)
+			// Only opening the stream is retried: chunks are forwarded to the client
+			// as they arrive, so a failure after that point is never resent.
+			result.transientBackoff = m.transientBackoff(ctx, provider, errStream)
 			m.MarkResult(execCtx, result)
+			if byokKey != "" {
+				return nil, errStream
+			}
 			lastErr = errStream
+			failedAuth, failCause = auth.ID, errStream
 			continue
 		}
 		out := make(chan cliproxyexecutor.StreamChunk)
 		go func(streamCtx context.Context, streamAuth *Auth, streamProvider string, streamChunks <-chan cliproxyexecutor.StreamChunk) {
 			defer close(out)
+			defer release()
 			var failed bool
 			for chunk := range streamChunks {
 				if chunk.Err != nil && !failed {
@@ -522,18 +657,41 @@ func (m *Manager) executeStreamWithProvider(ctx context.Context, provider string
 					if errors.As(chunk.Err, &se) && se != nil {
 						rerr.HTTPStatus = se.StatusCode()
 					}
-					m.MarkResult(streamCtx, Result{AuthID: streamAuth.ID, Provider: streamProvider, Model: routeModel, Success: false, Error: rerr})
+					m.MarkResult(streamCtx, Result{AuthID: streamAuth.ID, Provider: streamProvider, Model: routeModel, Success: false, Error: rerr, Latency: latency})
 				}
 				out <- chunk
 			}
 			if !failed {
-				m.MarkResult(streamCtx, Result{AuthID: streamAuth.ID, Provider: streamProvider, Model: routeModel, Success: true})
+				m.MarkResult(streamCtx, Result{AuthID: streamAuth.ID, Provider: streamProvider, Model: routeModel, Success: true, Latency: latency})
 			}
 		}(execCtx, auth.Clone(), provider, chunks)
 		return out, nil
 	}
 }
 
+var upstreamFailovers = metrics.Default().NewCounterVec(
+	"cliproxy_upstream_failovers_total",
+	"Requests moved on to another credential after an upstream failure, by provider.",
+	"provider",
+)
+
+// publishFailover reports that a request moved on from a failed credential to
+// another one of the same provider.
+func publishFailover(ctx context.Context, provider, model, from, to string, cause error) {
+	upstreamFailovers.Inc(provider)
+	reason := ""
+	if cause != nil {
+		reason = cause.Error()
+	}
+	events.Publish(events.Event{
+		Type:   events.TypeUpstreamFailover,
+		APIKey: clientKeyFromContext(ctx),
+		Actor:  "system",
+		Reason: reason,
+		Data:   map[string]any{"provider": provider, "model": model, "from_auth": from, "to_auth": to},
+	})
+}
+
 func rewriteModelForAuth(model string, metadata map[string]any, auth *Auth) (string, map[string]any) {
 	if auth == nil || model == "" {
 		return model, metadata
@@ -693,7 +851,11 @@ func (m *Manager) shouldRetryAfterError(err error, attempt, maxAttempts int, pro
 		return 0, false
 	}
 	wait, found := m.closestCooldownWait(providers, model)
-	if !found || wait > maxWait {
+	if !found {
+		// A short transient backoff may already be over: the credential is usable again.
+		return 0, m.upstreamRetry.Load().resends(err, attempt+1)
+	}
+	if wait > maxWait {
 		return 0, false
 	}
 	return wait, true
@@ -713,6 +875,15 @@ func waitForCooldown(ctx context.Context, wait time.Duration) error {
 	}
 }
 
+// limitFallback keeps only the first provider and a single attempt when the
+// request disabled fallback with X-CC-Fallback: off.
+func limitFallback(ctx context.Context, providers []string, attempts int) ([]string, int) {
+	if !reqfeatures.FromContext(ctx).FallbackOff || len(providers) == 0 {
+		return providers, attempts
+	}
+	return providers[:1], 1
+}
+
 func (m *Manager) executeProvidersOnce(ctx context.Context, providers []string, fn func(context.Context, string) (cliproxyexecutor.Response, error)) (cliproxyexecutor.Response, error) {
 	if len(providers) == 0 {
 		return cliproxyexecutor.Response{}, &Error{Code: "provider_not_found", Message: "no provider supplied"}
@@ -754,6 +925,13 @@ func (m *Manager) MarkResult(ctx context.Context, result Result) {
 	if result.AuthID == "" {
 		return
 	}
+	if byokKeyFromContext(ctx) != "" {
+		// Failures of a client-supplied key say nothing about the shared credential.
+		return
+	}
+	m.rotations.record(result.AuthID, result.Success)
+	m.recordEndpointResult(result)
+	m.recordCircuitResult(result)
 
 	shouldResumeModel := false
 	shouldSuspendModel := false
@@ -834,17 +1012,19 @@ func (m *Manager) MarkResult(ctx context.Context, result Result) {
 					shouldSuspendModel = true
 					setModelQuota = true
 				case 408, 500, 502, 503, 504:
-					next := now.Add(1 * time.Minute)
-					state.NextRetryAfter = next
+					state.NextRetryAfter = now.Add(transientCooldown(result.transientBackoff))
 				default:
 					state.NextRetryAfter = time.Time{}
+					if result.transientBackoff > 0 {
+						state.NextRetryAfter = now.Add(result.transientBackoff)
+					}
 				}
 
 				auth.Status = StatusError
 				auth.UpdatedAt = now
 				updateAggregatedAvailability(auth, now)
 			} else {
-				applyAuthFailureState(auth, result.Error, result.RetryAfter, now)
+				applyAuthFailureState(auth, result.Error, result.RetryAfter, result.transientBackoff, now)
 			}
 		}
 
@@ -1044,7 +1224,7 @@ func statusCodeFromResult(err *Error) int {
 	return err.StatusCode()
 }
 
-func applyAuthFailureState(auth *Auth, resultErr *Error, retryAfter *time.Duration, now time.Time) {
+func applyAuthFailureState(auth *Auth, resultErr *Error, retryAfter *time.Duration, transientBackoff time.Duration, now time.Time) {
 	if auth == nil {
 		return
 	}
@@ -1086,11 +1266,14 @@ func applyAuthFailureState(auth *Auth, resultErr *Error, retryAfter *time.Durati
 		auth.NextRetryAfter = next
 	case 408, 500, 502, 503, 504:
 		auth.StatusMessage = "transient upstream error"
-		auth.NextRetryAfter = now.Add(1 * time.Minute)
+		auth.NextRetryAfter = now.Add(transientCooldown(transientBackoff))
 	default:
 		if auth.StatusMessage == "" {
 			auth.StatusMessage = "request failed"
 		}
+		if transientBackoff > 0 {
+			auth.NextRetryAfter = now.Add(transientBackoff)
+		}
 	}
 }
 
@@ -1139,6 +1322,9 @@ func (m *Manager) GetByID(id string) (*Auth, bool) {
 }
 
 func (m *Manager) pickNext(ctx context.Context, provider, model string, opts cliproxyexecutor.Options, tried map[string]struct{}) (*Auth, ProviderExecutor, error) {
+	if len(tried) > 0 && reqfeatures.FromContext(ctx).FallbackOff {
+		return nil, nil, &Error{Code: "auth_not_found", Message: "fallback disabled by request"}
+	}
 	m.mu.RLock()
 	executor, okExecutor := m.executors[provider]
 	if !okExecutor {
@@ -1164,17 +1350,32 @@ func (m *Manager) pickNext(ctx context.Context, provider, model string, opts cli
 		m.mu.RUnlock()
 		return nil, nil, &Error{Code: "auth_not_found", Message: "no auth available"}
 	}
-	selected, errPick := m.selector.Pick(ctx, provider, model, opts, candidates)
-	if errPick != nil {
+	now := time.Now()
+	candidates = m.rotations.filter(candidates, now)
+	candidates = m.upstreams.filter(candidates, model, now)
+	candidates, retryAt := m.breakers.filter(candidates, now)
+	if len(candidates) == 0 {
 		m.mu.RUnlock()
-		return nil, nil, errPick
+		return nil, nil, circuitOpenError(provider, retryAt)
 	}
+	stickyKey := m.sticky.key(ctx, provider, opts)
+	selected, hadPin := m.sticky.pinned(stickyKey, candidates, model, now)
 	if selected == nil {
-		m.mu.RUnlock()
-		return nil, nil, &Error{Code: "auth_not_found", Message: "selector returned no auth"}
+		var errPick error
+		selected, errPick = m.upstreams.pick(ctx, provider, model, opts, candidates, m.selector)
+		if errPick != nil {
+			m.mu.RUnlock()
+			return nil, nil, errPick
+		}
+		if selected == nil {
+			m.mu.RUnlock()
+			return nil, nil, &Error{Code: "auth_not_found", Message: "selector returned no auth"}
+		}
+		m.sticky.pin(stickyKey, selected.ID, hadPin, now)
 	}
 	authCopy := selected.Clone()
 	m.mu.RUnlock()
+	m.breakers.admit(endpointKey(authCopy), now)
 	if !selected.indexAssigned {
 		m.mu.Lock()
 		if current := m.auths[authCopy.ID]; current != nil && !current.indexAssigned {
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	"github.com/tidwall/gjson"
+)
+
+// Contract check requirements
+const (
+	RequireExists   = "exists"
+	RequireNonEmpty = "non-empty"
+)
+
+var contractViolations = metrics.Default().NewCounterVec(
+	"cliproxy_upstream_contract_violations_total",
+	"Upstream responses that failed a configured contract check, by rule and provider.",
+	"rule", "provider",
+)
+
+// builtinContractRules are the named checks available via contract-checks.checks.
+// Paths are keyed by response format; any one path satisfying the requirement passes.
+var builtinContractRules = map[string]struct {
+	require string
+	paths   map[string][]string
+}{
+	"usage": {require: RequireExists, paths: map[string][]string{
+		"claude":          {"usage"},
+		"openai":          {"usage"},
+		"openai-response": {"usage", "response.usage"},
+		"gemini":          {"usageMetadata"},
+		"gemini-cli":      {"response.usageMetadata", "usageMetadata"},
+	}},
+	"stop-reason": {require: RequireNonEmpty, paths: map[string][]string{
+		"claude":          {"stop_reason"},
+		"openai":          {"choices.0.finish_reason"},
+		"openai-response": {"status", "response.status"},
+		"gemini":          {"candidates.0.finishReason"},
+		"gemini-cli":      {"response.candidates.0.finishReason", "candidates.0.finishReason"},
+	}},
+	"content": {require: RequireNonEmpty, paths: map[string][]string{
+		"claude":          {"content"},
+		"openai":          {"choices.0.message.content", "choices.0.message.tool_calls"},
+		"openai-response": {"output", "response.output"},
+		"gemini":          {"candidates.0.content.parts"},
+		"gemini-cli":      {"response.candidates.0.content.parts", "candidates.0.content.parts"},
+	}},
+}
+
+// contractRule is a compiled assertion on upstream response payloads.
+type contractRule struct {
+	name    string
+	require string
+	// paths maps a response format to candidate JSON paths; the "" key applies to every format.
+	paths map[string][]string
+}
+
+// contractChecker holds the active contract rules.
+type contractChecker struct {
+	failover bool
+	rules    []contractRule
+}
+
+// SetContractChecks replaces the upstream response contract checks.
+func (m *Manager) SetContractChecks(cfg internalconfig.ContractChecksConfig) {
+	if m == nil {
+		return
+	}
+	if !cfg.Enabled {
+		m.contracts.Store(nil)
+		return
+	}
+	checker := &contractChecker{failover: cfg.Failover}
+	for _, name := range cfg.Checks {
+		name = strings.ToLower(strings.TrimSpace(name))
+		builtin, ok := builtinContractRules[name]
+		if !ok {
+			continue
+		}
+		checker.rules = append(checker.rules, contractRule{name: name, require: builtin.require, paths: builtin.paths})
+	}
+	for _, rc := range cfg.Rules {
+		if len(rc.Paths) == 0 {
+			continue
+		}
+		rule := contractRule{name: strings.TrimSpace(rc.Name), require: strings.ToLower(strings.TrimSpace(rc.Require)), paths: make(map[string][]string)}
+		if rule.name == "" {
+			rule.name = strings.Join(rc.Paths, "|")
+		}
+		if rule.require != RequireNonEmpty {
+			rule.require = RequireExists
+		}
+		if len(rc.Formats) == 0 {
+			rule.paths[""] = rc.Paths
+		}
+		for _, format := range rc.Formats {
+			rule.paths[strings.ToLower(strings.TrimSpace(format))] = rc.Paths
+		}
+		checker.rules = append(checker.rules, rule)
+	}
+	m.contracts.Store(checker)
+}
+
+// checkContract runs the active rules against a non-streaming response payload
+// in the given format. It returns the names of violated rules and whether the
+// caller should fail over to another credential.
+func (m *Manager) checkContract(provider, format string, payload []byte) ([]string, bool) {
+	checker := m.contracts.Load()
+	if checker == nil || len(checker.rules) == 0 {
+		return nil, false
+	}
+	format = strings.ToLower(format)
+	var violated []string
+	for _, rule := range checker.rules {
+		paths, ok := rule.paths[format]
+		if !ok {
+			paths, ok = rule.paths[""]
+		}
+		if !ok {
+			continue
+		}
+		if !satisfies(payload, paths, rule.require) {
+			contractViolations.Inc(rule.name, provider)
+			violated = append(violated, rule.name)
+		}
+	}
+	return violated, checker.failover && len(violated) > 0
+}
+
+func satisfies(payload []byte, paths []string, require string) bool {
+	for _, path := range paths {
+		value := gjson.GetBytes(payload, path)
+		if !value.Exists() {
+			continue
+		}
+		if require != RequireNonEmpty {
+			return true
+		}
+		switch {
+		case value.Type == gjson.Null:
+		case value.IsArray() && len(value.Array()) == 0:
+		case value.IsObject() && len(value.Map()) == 0:
+		case value.Type == gjson.String && strings.TrimSpace(value.String()) == "":
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// contractError describes a response that failed contract checks.
+func contractError(violated []string) *Error {
+	return &Error{
+		Code:       "contract_violation",
+		Message:    fmt.Sprintf("upstream response failed contract checks: %s", strings.Join(violated, ", ")),
+		Retryable:  true,
+		HTTPStatus: http.StatusBadGateway,
+	}
+}
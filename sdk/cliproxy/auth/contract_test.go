@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+)
+
+// payloadExecutor returns a fixed payload per auth ID.
+type payloadExecutor struct {
+	payloads map[string]string
+	calls    []string
+}
+
+func (e *payloadExecutor) Identifier() string { return "claude" }
+
+func (e *payloadExecutor) Execute(_ context.Context, auth *Auth, _ cliproxyexecutor.Request, _ cliproxyexecutor.Options) (cliproxyexecutor.Response, error) {
+	e.calls = append(e.calls, auth.ID)
+	return cliproxyexecutor.Response{Payload: []byte(e.payloads[auth.ID])}, nil
+}
+
+func (e *payloadExecutor) ExecuteStream(context.Context, *Auth, cliproxyexecutor.Request, cliproxyexecutor.Options) (<-chan cliproxyexecutor.StreamChunk, error) {
+	return nil, nil
+}
+
+func (e *payloadExecutor) Refresh(_ context.Context, auth *Auth) (*Auth, error) { return auth, nil }
+
+func (e *payloadExecutor) CountTokens(context.Context, *Auth, cliproxyexecutor.Request, cliproxyexecutor.Options) (cliproxyexecutor.Response, error) {
+	return cliproxyexecutor.Response{}, nil
+}
+
+func TestContractChecksFailOverOnViolation(t *testing.T) {
+	m := NewManager(nil, &RoundRobinSelector{}, nil)
+	ctx := context.Background()
+	executor := &payloadExecutor{payloads: map[string]string{
+		"a": `{"content":[],"stop_reason":"end_turn"}`,
+		"b": `{"content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":1}}`,
+	}}
+	m.RegisterExecutor(executor)
+	for _, id := range []string{"a", "b"} {
+		if _, err := m.Register(ctx, &Auth{ID: id, Provider: "claude"}); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+	}
+
+	violated, _ := m.checkContract("claude", "claude", []byte(executor.payloads["a"]))
+	if violated != nil {
+		t.Fatalf("expected no checks while disabled, got %v", violated)
+	}
+
+	m.SetContractChecks(internalconfig.ContractChecksConfig{
+		Enabled:  true,
+		Checks:   []string{"usage", "stop-reason", "content"},
+		Failover: true,
+	})
+	violated, failover := m.checkContract("claude", "claude", []byte(executor.payloads["a"]))
+	if len(violated) != 2 || violated[0] != "usage" || violated[1] != "content" || !failover {
+		t.Fatalf("unexpected violations %v (failover=%v)", violated, failover)
+	}
+
+	opts := cliproxyexecutor.Options{SourceFormat: "claude"}
+	for i := 0; i < 2; i++ {
+		resp, err := m.executeWithProvider(ctx, "claude", cliproxyexecutor.Request{}, opts)
+		if err != nil {
+			t.Fatalf("execute: %v", err)
+		}
+		if string(resp.Payload) != executor.payloads["b"] {
+			t.Fatalf("expected the valid response, got %s", resp.Payload)
+		}
+	}
+
+	// Without another credential the violating response is still returned.
+	executor.payloads["b"] = executor.payloads["a"]
+	resp, err := m.executeWithProvider(ctx, "claude", cliproxyexecutor.Request{}, opts)
+	if err != nil || string(resp.Payload) != executor.payloads["a"] {
+		t.Fatalf("expected violating response when all credentials fail checks, got %s, %v", resp.Payload, err)
+	}
+}
@@ -0,0 +1,436 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/device"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/reqfeatures"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/requestclass"
+)
+
+const defaultFairShareMaxWait = 60 * time.Second
+
+var (
+	fairShareQueueDepth = metrics.Default().NewGaugeVec(
+		"cliproxy_fair_share_queue_depth",
+		"Requests waiting for a fair-share slot, by upstream credential.",
+		"auth",
+	)
+	fairShareQueued = metrics.Default().NewCounterVec(
+		"cliproxy_fair_share_queued_total",
+		"Queued fair-share requests by outcome (granted, timeout, cancelled, rejected_full, evicted).",
+		"outcome",
+	)
+	fairShareWait = metrics.Default().NewHistogramVec(
+		"cliproxy_fair_share_queue_wait_seconds",
+		"Time queued requests waited for a fair-share slot.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	)
+)
+
+// fairScheduler caps in-flight requests per upstream auth and hands out freed
+// slots to waiting client keys in weighted fair order, so a single heavy client
+// sharing an upstream key cannot starve the others.
+type fairScheduler struct {
+	mu            sync.Mutex
+	enabled       bool
+	maxConcurrent int
+	maxWait       time.Duration
+	// maxQueue caps waiters per upstream; 0 is unbounded.
+	maxQueue    int
+	tierMaxWait map[string]time.Duration
+	tierWeights map[string]int
+	// tierLevels are strict priority levels: waiters of a higher level always go first.
+	tierLevels map[string]int
+	keyTiers   map[string]string
+	// metadataTiers caches the "tier" attribute from key metadata seen on requests.
+	metadataTiers map[string]string
+	// boostTiers caches tiers granted by temporary priority boosts; they win over configured tiers.
+	boostTiers map[string]string
+	// priorityClasses are request classes served ahead of queued requests of other classes.
+	priorityClasses map[string]bool
+	upstreams       map[string]*upstreamSlots
+}
+
+type upstreamSlots struct {
+	authID   string
+	inFlight int
+	// served is the weighted virtual time per client key; lower is served first.
+	served  map[string]float64
+	floor   float64
+	waiters []*fairWaiter
+}
+
+type fairWaiter struct {
+	client   string
+	priority bool
+	// level is the strict priority level of the client's tier.
+	level int
+	// low waiters asked for X-CC-Priority: low and go after all others of their level.
+	low     bool
+	ready   chan struct{}
+	granted bool
+	// evicted is set when a higher priority request took the waiter's place in a full queue.
+	evicted bool
+}
+
+func newFairScheduler() *fairScheduler {
+	return &fairScheduler{
+		upstreams:     make(map[string]*upstreamSlots),
+		metadataTiers: make(map[string]string),
+		boostTiers:    make(map[string]string),
+	}
+}
+
+// SetFairShare updates the fair-share scheduling configuration. In-flight
+// accounting is preserved across updates.
+func (m *Manager) SetFairShare(cfg internalconfig.FairShareConfig) {
+	if m == nil || m.fairShare == nil {
+		return
+	}
+	m.fairShare.configure(cfg)
+}
+
+func (s *fairScheduler) configure(cfg internalconfig.FairShareConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enabled = cfg.Enabled && cfg.MaxConcurrentPerUpstream > 0
+	s.maxConcurrent = cfg.MaxConcurrentPerUpstream
+	s.maxWait = time.Duration(cfg.MaxWait) * time.Second
+	if s.maxWait <= 0 {
+		s.maxWait = defaultFairShareMaxWait
+	}
+	s.maxQueue = cfg.MaxQueue
+	s.tierMaxWait = make(map[string]time.Duration, len(cfg.TierMaxWait))
+	for tier, seconds := range cfg.TierMaxWait {
+		if seconds > 0 {
+			s.tierMaxWait[strings.ToLower(strings.TrimSpace(tier))] = time.Duration(seconds) * time.Second
+		}
+	}
+	s.tierWeights = make(map[string]int, len(cfg.Tiers))
+	for tier, weight := range cfg.Tiers {
+		s.tierWeights[strings.ToLower(strings.TrimSpace(tier))] = weight
+	}
+	s.tierLevels = make(map[string]int, len(cfg.TierPriorities))
+	for tier, level := range cfg.TierPriorities {
+		s.tierLevels[strings.ToLower(strings.TrimSpace(tier))] = level
+	}
+	s.keyTiers = make(map[string]string, len(cfg.KeyTiers))
+	for key, tier := range cfg.KeyTiers {
+		s.keyTiers[key] = strings.ToLower(strings.TrimSpace(tier))
+	}
+	s.priorityClasses = make(map[string]bool, len(cfg.PriorityClasses))
+	for _, class := range cfg.PriorityClasses {
+		s.priorityClasses[strings.ToLower(strings.TrimSpace(class))] = true
+	}
+	// Wake waiters that now fit under a raised limit, or all of them when disabled.
+	for _, u := range s.upstreams {
+		s.dispatch(u)
+	}
+}
+
+// tier returns the plan tier of a client key. Callers must hold s.mu.
+func (s *fairScheduler) tier(client string) string {
+	tier, ok := s.boostTiers[client]
+	if !ok {
+		tier, ok = s.keyTiers[client]
+	}
+	if !ok {
+		tier, ok = s.metadataTiers[client]
+	}
+	if !ok {
+		tier = "default"
+	}
+	return tier
+}
+
+// weight returns the scheduling weight for a client key. Callers must hold s.mu.
+func (s *fairScheduler) weight(client string) float64 {
+	if w := s.tierWeights[s.tier(client)]; w > 0 {
+		return float64(w)
+	}
+	return 1
+}
+
+// acquire blocks until the client may send a request through the upstream auth.
+// The returned release function must be called once the request has finished.
+func (s *fairScheduler) acquire(ctx context.Context, authID, client string) (func(), error) {
+	noop := func() {}
+	if s == nil {
+		return noop, nil
+	}
+	tier, boosted := clientTierFromContext(ctx)
+	s.mu.Lock()
+	if !s.enabled {
+		s.mu.Unlock()
+		return noop, nil
+	}
+	delete(s.metadataTiers, client)
+	delete(s.boostTiers, client)
+	switch {
+	case boosted:
+		s.boostTiers[client] = tier
+	case tier != "":
+		s.metadataTiers[client] = tier
+	}
+	u, ok := s.upstreams[authID]
+	if !ok {
+		u = &upstreamSlots{authID: authID, served: make(map[string]float64)}
+		s.upstreams[authID] = u
+	}
+	// Idle clients rejoin at the current virtual time instead of bursting on stale credit.
+	if u.served[client] < u.floor {
+		u.served[client] = u.floor
+	}
+	release := func() { s.release(authID) }
+	if len(u.waiters) == 0 && u.inFlight < s.maxConcurrent {
+		s.grant(u, client)
+		s.mu.Unlock()
+		return release, nil
+	}
+	requested := reqfeatures.FromContext(ctx).Priority
+	clientTier := s.tier(client)
+	w := &fairWaiter{
+		client:   client,
+		priority: s.priorityClasses[requestClassFromContext(ctx)] || requested == reqfeatures.High,
+		level:    s.tierLevels[clientTier],
+		low:      requested == reqfeatures.Low,
+		ready:    make(chan struct{}),
+	}
+	if s.maxQueue > 0 && len(u.waiters) >= s.maxQueue {
+		// A full queue admits the request only in place of a lower priority waiter.
+		last := s.lastWaiter(u)
+		if !s.before(u, w, u.waiters[last]) {
+			s.mu.Unlock()
+			fairShareQueued.Inc("rejected_full")
+			return noop, queueFullError(s.maxQueue)
+		}
+		evicted := u.waiters[last]
+		u.waiters = append(u.waiters[:last], u.waiters[last+1:]...)
+		evicted.evicted = true
+		close(evicted.ready)
+	}
+	u.waiters = append(u.waiters, w)
+	fairShareQueueDepth.Set(float64(len(u.waiters)), authID)
+	maxWait := s.maxWait
+	if tierWait, ok := s.tierMaxWait[clientTier]; ok {
+		maxWait = tierWait
+	}
+	s.mu.Unlock()
+
+	queuedAt := time.Now()
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	var errWait error
+	outcome := "timeout"
+	select {
+	case <-w.ready:
+		if w.evicted {
+			fairShareQueued.Inc("evicted")
+			return noop, queueFullError(s.maxQueue)
+		}
+		fairShareQueued.Inc("granted")
+		fairShareWait.Observe(time.Since(queuedAt).Seconds())
+		return release, nil
+	case <-ctx.Done():
+		errWait = ctx.Err()
+		outcome = "cancelled"
+	case <-timer.C:
+		errWait = &Error{
+			Code:       "upstream_busy",
+			Message:    fmt.Sprintf("timed out after %s waiting for a fair-share slot on the upstream credential", maxWait),
+			Retryable:  true,
+			HTTPStatus: http.StatusServiceUnavailable,
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.granted {
+		// Granted concurrently with the timeout; hand the slot back.
+		s.releaseLocked(authID)
+		fairShareQueued.Inc(outcome)
+		return noop, errWait
+	}
+	if w.evicted {
+		fairShareQueued.Inc("evicted")
+		return noop, queueFullError(s.maxQueue)
+	}
+	for i, candidate := range u.waiters {
+		if candidate == w {
+			u.waiters = append(u.waiters[:i], u.waiters[i+1:]...)
+			break
+		}
+	}
+	fairShareQueueDepth.Set(float64(len(u.waiters)), authID)
+	fairShareQueued.Inc(outcome)
+	return noop, errWait
+}
+
+func queueFullError(maxQueue int) error {
+	return &Error{
+		Code:       "upstream_queue_full",
+		Message:    fmt.Sprintf("the fair-share queue of the upstream credential is full (%d waiting)", maxQueue),
+		Retryable:  true,
+		HTTPStatus: http.StatusServiceUnavailable,
+	}
+}
+
+// grant hands a slot to the client. Callers must hold s.mu.
+func (s *fairScheduler) grant(u *upstreamSlots, client string) {
+	u.inFlight++
+	u.floor = u.served[client]
+	u.served[client] += 1 / s.weight(client)
+}
+
+func (s *fairScheduler) release(authID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked(authID)
+}
+
+func (s *fairScheduler) releaseLocked(authID string) {
+	u, ok := s.upstreams[authID]
+	if !ok {
+		return
+	}
+	if u.inFlight > 0 {
+		u.inFlight--
+	}
+	s.dispatch(u)
+}
+
+// before reports whether waiter a is served ahead of b. Waiters of a priority
+// request class or with X-CC-Priority: high go first, then higher tier priority
+// levels; within a level low priority waiters go last and the rest by lowest
+// virtual time. Callers must hold s.mu.
+func (s *fairScheduler) before(u *upstreamSlots, a, b *fairWaiter) bool {
+	if a.priority != b.priority {
+		return a.priority
+	}
+	if a.level != b.level {
+		return a.level > b.level
+	}
+	if a.low != b.low {
+		return b.low
+	}
+	return u.served[a.client] < u.served[b.client]
+}
+
+// lastWaiter returns the index of the waiter served last, preferring the most
+// recent arrival on ties. Callers must hold s.mu.
+func (s *fairScheduler) lastWaiter(u *upstreamSlots) int {
+	last := len(u.waiters) - 1
+	for i := last - 1; i >= 0; i-- {
+		if s.before(u, u.waiters[last], u.waiters[i]) {
+			last = i
+		}
+	}
+	return last
+}
+
+// dispatch grants free slots to waiters in before order, falling back to
+// arrival order on ties. Callers must hold s.mu.
+func (s *fairScheduler) dispatch(u *upstreamSlots) {
+	for len(u.waiters) > 0 && (!s.enabled || u.inFlight < s.maxConcurrent) {
+		best := 0
+		for i := 1; i < len(u.waiters); i++ {
+			if s.before(u, u.waiters[i], u.waiters[best]) {
+				best = i
+			}
+		}
+		w := u.waiters[best]
+		u.waiters = append(u.waiters[:best], u.waiters[best+1:]...)
+		s.grant(u, w.client)
+		w.granted = true
+		close(w.ready)
+	}
+	fairShareQueueDepth.Set(float64(len(u.waiters)), u.authID)
+}
+
+// FairShareQueue is the fair-share state of one upstream credential.
+type FairShareQueue struct {
+	AuthID   string `json:"auth_id"`
+	InFlight int    `json:"in_flight"`
+	Queued   int    `json:"queued"`
+	// QueuedByLevel counts waiters per tier priority level.
+	QueuedByLevel map[int]int `json:"queued_by_level,omitempty"`
+}
+
+// FairShareQueues returns the in-flight and queued requests of every upstream
+// credential the scheduler has seen.
+func (m *Manager) FairShareQueues() []FairShareQueue {
+	if m == nil || m.fairShare == nil {
+		return nil
+	}
+	s := m.fairShare
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]FairShareQueue, 0, len(s.upstreams))
+	for authID, u := range s.upstreams {
+		queue := FairShareQueue{AuthID: authID, InFlight: u.inFlight, Queued: len(u.waiters)}
+		for _, w := range u.waiters {
+			if queue.QueuedByLevel == nil {
+				queue.QueuedByLevel = make(map[int]int)
+			}
+			queue.QueuedByLevel[w.level]++
+		}
+		out = append(out, queue)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AuthID < out[j].AuthID })
+	return out
+}
+
+// clientKeyFromContext returns the authenticated client API key of the request, if any.
+func clientKeyFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil {
+		return ""
+	}
+	return ginCtx.GetString("apiKey")
+}
+
+// requestClassFromContext returns the request class set by the request class
+// middleware, if any.
+func requestClassFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil {
+		return ""
+	}
+	return ginCtx.GetString(requestclass.ContextKey)
+}
+
+// clientTierFromContext returns the tier granted by an active priority boost,
+// reporting boosted=true, or else the "tier" attribute of the client key metadata.
+func clientTierFromContext(ctx context.Context) (tier string, boosted bool) {
+	if ctx == nil {
+		return "", false
+	}
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil {
+		return "", false
+	}
+	if priority := strings.ToLower(strings.TrimSpace(ginCtx.GetString("priorityTier"))); priority != "" {
+		return priority, true
+	}
+	metadata, ok := ginCtx.Value(device.MetadataContextKey).(map[string]string)
+	if !ok {
+		return "", false
+	}
+	return strings.ToLower(strings.TrimSpace(metadata["tier"])), false
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/requestclass"
+)
+
+func TestFairSchedulerServesLightClientBeforeHeavyBacklog(t *testing.T) {
+	s := newFairScheduler()
+	s.configure(internalconfig.FairShareConfig{Enabled: true, MaxConcurrentPerUpstream: 1})
+	ctx := context.Background()
+
+	release, err := s.acquire(ctx, "auth-1", "heavy")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	order := make(chan string, 3)
+	start := func(client string) {
+		go func() {
+			rel, errAcquire := s.acquire(ctx, "auth-1", client)
+			if errAcquire != nil {
+				order <- "error"
+				return
+			}
+			order <- client
+			rel()
+		}()
+	}
+	waitForWaiters := func(n int) {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			s.mu.Lock()
+			got := len(s.upstreams["auth-1"].waiters)
+			s.mu.Unlock()
+			if got == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("expected %d waiters", n)
+	}
+
+	start("heavy")
+	waitForWaiters(1)
+	start("heavy")
+	waitForWaiters(2)
+	start("light")
+	waitForWaiters(3)
+
+	release()
+	if first := <-order; first != "light" {
+		t.Fatalf("expected light client to be served first, got %s", first)
+	}
+	<-order
+	<-order
+}
+
+func TestFairSchedulerTimesOut(t *testing.T) {
+	s := newFairScheduler()
+	s.configure(internalconfig.FairShareConfig{Enabled: true, MaxConcurrentPerUpstream: 1})
+	s.maxWait = 10 * time.Millisecond
+
+	release, _ := s.acquire(context.Background(), "auth-1", "a")
+	defer release()
+	if _, err := s.acquire(context.Background(), "auth-1", "b"); err == nil {
+		t.Fatal("expected timeout error")
+	}
+}
+
+func TestFairSchedulerServesPriorityClassFirst(t *testing.T) {
+	s := newFairScheduler()
+	s.configure(internalconfig.FairShareConfig{Enabled: true, MaxConcurrentPerUpstream: 1, PriorityClasses: []string{"continuation"}})
+	withClass := func(class string) context.Context {
+		ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ginCtx.Set(requestclass.ContextKey, class)
+		return context.WithValue(context.Background(), "gin", ginCtx)
+	}
+
+	release, err := s.acquire(withClass(requestclass.New), "auth-1", "agent")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	order := make(chan string, 2)
+	start := func(client, class string) {
+		go func() {
+			rel, errAcquire := s.acquire(withClass(class), "auth-1", client)
+			if errAcquire != nil {
+				order <- "error"
+				return
+			}
+			order <- client
+			rel()
+		}()
+	}
+	waitForWaiters := func(n int) {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			s.mu.Lock()
+			got := len(s.upstreams["auth-1"].waiters)
+			s.mu.Unlock()
+			if got == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("expected %d waiters", n)
+	}
+
+	// The idle client would win on virtual time, but the agent's tool result goes first.
+	start("idle", requestclass.New)
+	waitForWaiters(1)
+	start("agent", requestclass.Continuation)
+	waitForWaiters(2)
+
+	release()
+	if first := <-order; first != "agent" {
+		t.Fatalf("expected the continuation to be served first, got %s", first)
+	}
+	<-order
+}
+
+func TestFairSchedulerBoundedQueueEvictsLowerPriority(t *testing.T) {
+	s := newFairScheduler()
+	s.configure(internalconfig.FairShareConfig{
+		Enabled:                  true,
+		MaxConcurrentPerUpstream: 1,
+		MaxQueue:                 1,
+		KeyTiers:                 map[string]string{"vip": "enterprise"},
+		TierPriorities:           map[string]int{"enterprise": 1},
+	})
+	ctx := context.Background()
+
+	release, err := s.acquire(ctx, "auth-1", "holder")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	evicted := make(chan error, 1)
+	go func() {
+		_, errAcquire := s.acquire(ctx, "auth-1", "free")
+		evicted <- errAcquire
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.Lock()
+		got := len(s.upstreams["auth-1"].waiters)
+		s.mu.Unlock()
+		if got == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected one waiter")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Same level as the queued waiter: the full queue rejects it at once.
+	if _, errFull := s.acquire(ctx, "auth-1", "other"); errFull == nil {
+		t.Fatal("expected queue full error")
+	}
+
+	granted := make(chan error, 1)
+	go func() {
+		rel, errAcquire := s.acquire(ctx, "auth-1", "vip")
+		if errAcquire == nil {
+			rel()
+		}
+		granted <- errAcquire
+	}()
+	errEvicted := <-evicted
+	if e, ok := errEvicted.(*Error); !ok || e.Code != "upstream_queue_full" {
+		t.Fatalf("expected the lower priority waiter to be evicted, got %v", errEvicted)
+	}
+	release()
+	if errGranted := <-granted; errGranted != nil {
+		t.Fatalf("expected vip to be served, got %v", errGranted)
+	}
+	if queues := (&Manager{fairShare: s}).FairShareQueues(); len(queues) != 1 || queues[0].Queued != 0 {
+		t.Fatalf("unexpected queues: %+v", queues)
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"time"
+)
+
+// HealthState is the runtime availability of one auth: cooldowns, quota
+// backoff and per-model errors. It is not persisted with the credential and is
+// carried across restarts through snapshots.
+type HealthState struct {
+	Status         Status                 `json:"status"`
+	StatusMessage  string                 `json:"status_message,omitempty"`
+	Unavailable    bool                   `json:"unavailable"`
+	NextRetryAfter time.Time              `json:"next_retry_after"`
+	Quota          QuotaState             `json:"quota"`
+	LastError      *Error                 `json:"last_error,omitempty"`
+	ModelStates    map[string]*ModelState `json:"model_states,omitempty"`
+}
+
+// HealthSnapshot returns the health of every auth that is cooling down or has
+// recorded errors. Healthy auths are omitted.
+func (m *Manager) HealthSnapshot() map[string]HealthState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[string]HealthState)
+	for id, auth := range m.auths {
+		if auth == nil || (auth.Status != StatusError && !auth.Unavailable && len(auth.ModelStates) == 0 && auth.LastError == nil) {
+			continue
+		}
+		out[id] = HealthState{
+			Status:         auth.Status,
+			StatusMessage:  auth.StatusMessage,
+			Unavailable:    auth.Unavailable,
+			NextRetryAfter: auth.NextRetryAfter,
+			Quota:          auth.Quota,
+			LastError:      cloneError(auth.LastError),
+			ModelStates:    cloneModelStates(auth.ModelStates),
+		}
+	}
+	return out
+}
+
+// RestoreHealth applies health captured by HealthSnapshot. Cooldowns that have
+// already run out are dropped. Each state is also kept until the auth is next
+// registered or updated, so the initial sync from the token store and config
+// does not wipe it; it returns the number of auths restored immediately.
+func (m *Manager) RestoreHealth(states map[string]HealthState) int {
+	now := time.Now()
+	restored := 0
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pendingHealth == nil {
+		m.pendingHealth = make(map[string]HealthState)
+	}
+	for id, state := range states {
+		state = activeHealth(state, now)
+		if state.ModelStates == nil && !state.Unavailable {
+			continue
+		}
+		if auth, ok := m.auths[id]; ok && auth != nil {
+			// activeHealth copies the model states so the auth and the pending entry never share them.
+			applyHealth(auth, activeHealth(state, now), now)
+			restored++
+		}
+		m.pendingHealth[id] = state
+	}
+	return restored
+}
+
+// applyPendingHealth restores snapshot health to an auth being registered or
+// updated. Callers must hold m.mu.
+func (m *Manager) applyPendingHealth(auth *Auth) {
+	state, ok := m.pendingHealth[auth.ID]
+	if !ok {
+		return
+	}
+	delete(m.pendingHealth, auth.ID)
+	now := time.Now()
+	applyHealth(auth, activeHealth(state, now), now)
+}
+
+// activeHealth drops model states and auth-level cooldowns that have expired.
+func activeHealth(state HealthState, now time.Time) HealthState {
+	if !state.NextRetryAfter.After(now) {
+		state.Unavailable = false
+		state.NextRetryAfter = time.Time{}
+		state.Quota = QuotaState{}
+	}
+	var models map[string]*ModelState
+	for model, ms := range state.ModelStates {
+		if ms == nil || !ms.NextRetryAfter.After(now) {
+			continue
+		}
+		if models == nil {
+			models = make(map[string]*ModelState)
+		}
+		copied := *ms
+		copied.LastError = cloneError(ms.LastError)
+		models[model] = &copied
+	}
+	state.ModelStates = models
+	return state
+}
+
+func applyHealth(auth *Auth, state HealthState, now time.Time) {
+	if auth.Disabled {
+		return
+	}
+	if state.Unavailable {
+		auth.Unavailable = true
+		auth.NextRetryAfter = state.NextRetryAfter
+		auth.Quota = state.Quota
+	}
+	if len(state.ModelStates) > 0 {
+		if auth.ModelStates == nil {
+			auth.ModelStates = make(map[string]*ModelState, len(state.ModelStates))
+		}
+		for model, ms := range state.ModelStates {
+			auth.ModelStates[model] = ms
+		}
+		updateAggregatedAvailability(auth, now)
+	}
+	if state.Unavailable || len(state.ModelStates) > 0 {
+		auth.Status = StatusError
+		auth.StatusMessage = state.StatusMessage
+		auth.LastError = cloneError(state.LastError)
+	}
+}
+
+func cloneModelStates(states map[string]*ModelState) map[string]*ModelState {
+	if len(states) == 0 {
+		return nil
+	}
+	out := make(map[string]*ModelState, len(states))
+	for model, ms := range states {
+		if ms == nil {
+			continue
+		}
+		copied := *ms
+		copied.LastError = cloneError(ms.LastError)
+		out[model] = &copied
+	}
+	return out
+}
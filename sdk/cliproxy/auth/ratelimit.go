@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+)
+
+// maxRateLimitPark caps how long a key is parked, so a malformed reset header
+// cannot take a key out of rotation for good.
+const maxRateLimitPark = 24 * time.Hour
+
+var keysParked = metrics.Default().NewCounterVec(
+	"cliproxy_upstream_key_parked_total",
+	"Upstream credentials parked until their rate limit resets, by provider and cause.",
+	"provider", "cause",
+)
+
+// anthropicBuckets are the rate-limit header families sent by the Anthropic API
+// as anthropic-ratelimit-<bucket>-{limit,remaining,reset}; resets are RFC 3339 times.
+var anthropicBuckets = []string{"requests", "tokens", "input-tokens", "output-tokens"}
+
+// openAIBuckets are the families sent by OpenAI-compatible APIs as
+// x-ratelimit-{limit,remaining,reset}-<bucket>; resets are durations such as "6m0s".
+var openAIBuckets = []string{"requests", "tokens"}
+
+// RateLimitBucket is one upstream rate limit as last reported for a credential.
+type RateLimitBucket struct {
+	Name      string    `json:"name"`
+	Limit     int64     `json:"limit,omitempty"`
+	Remaining int64     `json:"remaining"`
+	Reset     time.Time `json:"reset,omitempty"`
+}
+
+// RateLimitStatus is what the upstream last reported about a credential's rate limits.
+type RateLimitStatus struct {
+	AuthID      string            `json:"auth_id"`
+	Provider    string            `json:"provider,omitempty"`
+	Label       string            `json:"label,omitempty"`
+	Buckets     []RateLimitBucket `json:"buckets,omitempty"`
+	ParkedUntil time.Time         `json:"parked_until,omitempty"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+type rateLimitTracker struct {
+	mu     sync.Mutex
+	states map[string]*RateLimitStatus
+}
+
+var rateLimits = &rateLimitTracker{states: make(map[string]*RateLimitStatus)}
+
+// ObserveRateLimits records the rate-limit headers of an upstream response for
+// the credential that sent the request. A 429, or a response reporting an
+// exhausted limit, parks the credential until the limit resets; selection skips
+// parked credentials so requests rotate to the rest of the pool.
+func ObserveRateLimits(auth *Auth, status int, header http.Header) {
+	if auth == nil || auth.ID == "" {
+		return
+	}
+	now := time.Now()
+	buckets := parseRateLimitBuckets(header, now)
+	var parkUntil time.Time
+	cause := ""
+	if status == http.StatusTooManyRequests {
+		if d := RetryAfterFromHeaders(header, now); d != nil {
+			parkUntil, cause = now.Add(*d), "rate_limited"
+		}
+	} else if until, exhausted := exhaustedUntil(buckets, now); exhausted {
+		parkUntil, cause = until, "exhausted"
+	}
+	success := status >= 200 && status < 300
+
+	rateLimits.mu.Lock()
+	defer rateLimits.mu.Unlock()
+	state := rateLimits.states[auth.ID]
+	if len(buckets) == 0 && parkUntil.IsZero() {
+		if success && state != nil {
+			state.ParkedUntil = time.Time{}
+		}
+		return
+	}
+	if state == nil {
+		state = &RateLimitStatus{AuthID: auth.ID}
+		rateLimits.states[auth.ID] = state
+	}
+	state.Provider, state.Label, state.UpdatedAt = auth.Provider, auth.Label, now
+	if len(buckets) > 0 {
+		state.Buckets = buckets
+	}
+	switch {
+	case !parkUntil.IsZero():
+		if parkUntil.After(state.ParkedUntil) {
+			keysParked.Inc(auth.Provider, cause)
+		}
+		state.ParkedUntil = parkUntil
+	case success:
+		state.ParkedUntil = time.Time{}
+	}
+}
+
+// RetryAfterFromHeaders returns how long to wait before retrying a rate-limited
+// request: the Retry-After header, else the latest reset of an exhausted limit.
+// It returns nil when the headers give no hint.
+func RetryAfterFromHeaders(header http.Header, now time.Time) *time.Duration {
+	if header == nil {
+		return nil
+	}
+	if raw := strings.TrimSpace(header.Get("Retry-After")); raw != "" {
+		if secs, err := strconv.ParseFloat(raw, 64); err == nil && secs >= 0 {
+			return capPark(time.Duration(secs * float64(time.Second)))
+		}
+		if at, err := http.ParseTime(raw); err == nil && at.After(now) {
+			return capPark(at.Sub(now))
+		}
+	}
+	if until, ok := exhaustedUntil(parseRateLimitBuckets(header, now), now); ok {
+		return capPark(until.Sub(now))
+	}
+	return nil
+}
+
+// RateLimitStates returns the tracked rate limits of every credential, sorted by auth ID.
+func RateLimitStates() []RateLimitStatus {
+	rateLimits.mu.Lock()
+	defer rateLimits.mu.Unlock()
+	out := make([]RateLimitStatus, 0, len(rateLimits.states))
+	for _, state := range rateLimits.states {
+		copied := *state
+		copied.Buckets = append([]RateLimitBucket(nil), state.Buckets...)
+		out = append(out, copied)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AuthID < out[j].AuthID })
+	return out
+}
+
+// rateLimitParkedUntil reports whether the credential is parked at now and until when.
+func rateLimitParkedUntil(authID string, now time.Time) (time.Time, bool) {
+	rateLimits.mu.Lock()
+	defer rateLimits.mu.Unlock()
+	state := rateLimits.states[authID]
+	if state == nil || !state.ParkedUntil.After(now) {
+		return time.Time{}, false
+	}
+	return state.ParkedUntil, true
+}
+
+func parseRateLimitBuckets(header http.Header, now time.Time) []RateLimitBucket {
+	var out []RateLimitBucket
+	for _, name := range anthropicBuckets {
+		prefix := "Anthropic-Ratelimit-" + name + "-"
+		remaining, ok := headerInt64(header, prefix+"Remaining")
+		if !ok {
+			continue
+		}
+		b := RateLimitBucket{Name: name, Remaining: remaining}
+		b.Limit, _ = headerInt64(header, prefix+"Limit")
+		if at, err := time.Parse(time.RFC3339, strings.TrimSpace(header.Get(prefix+"Reset"))); err == nil {
+			b.Reset = at
+		}
+		out = append(out, b)
+	}
+	for _, name := range openAIBuckets {
+		remaining, ok := headerInt64(header, "X-Ratelimit-Remaining-"+name)
+		if !ok {
+			continue
+		}
+		b := RateLimitBucket{Name: name, Remaining: remaining}
+		b.Limit, _ = headerInt64(header, "X-Ratelimit-Limit-"+name)
+		if d, err := time.ParseDuration(strings.TrimSpace(header.Get("X-Ratelimit-Reset-" + name))); err == nil {
+			b.Reset = now.Add(d)
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// exhaustedUntil returns the latest future reset among limits with nothing remaining.
+func exhaustedUntil(buckets []RateLimitBucket, now time.Time) (time.Time, bool) {
+	var until time.Time
+	for _, b := range buckets {
+		if b.Remaining <= 0 && b.Reset.After(now) && b.Reset.After(until) {
+			until = b.Reset
+		}
+	}
+	if until.IsZero() {
+		return until, false
+	}
+	if until.Sub(now) > maxRateLimitPark {
+		until = now.Add(maxRateLimitPark)
+	}
+	return until, true
+}
+
+func capPark(d time.Duration) *time.Duration {
+	if d > maxRateLimitPark {
+		d = maxRateLimitPark
+	}
+	return &d
+}
+
+func headerInt64(header http.Header, name string) (int64, bool) {
+	raw := strings.TrimSpace(header.Get(name))
+	if raw == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	return n, err == nil
+}
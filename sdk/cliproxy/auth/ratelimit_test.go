@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func resetRateLimits(t *testing.T) {
+	t.Helper()
+	rateLimits.mu.Lock()
+	rateLimits.states = make(map[string]*RateLimitStatus)
+	rateLimits.mu.Unlock()
+	t.Cleanup(func() {
+		rateLimits.mu.Lock()
+		rateLimits.states = make(map[string]*RateLimitStatus)
+		rateLimits.mu.Unlock()
+	})
+}
+
+func TestObserveRateLimitsParksOn429UntilRetryAfter(t *testing.T) {
+	resetRateLimits(t)
+	auth := &Auth{ID: "claude-a", Provider: "claude"}
+	header := http.Header{}
+	header.Set("Retry-After", "30")
+
+	ObserveRateLimits(auth, http.StatusTooManyRequests, header)
+
+	now := time.Now()
+	until, parked := rateLimitParkedUntil(auth.ID, now)
+	if !parked {
+		t.Fatalf("credential should be parked after 429")
+	}
+	if d := until.Sub(now); d < 25*time.Second || d > 31*time.Second {
+		t.Fatalf("parked for %v, want ~30s", d)
+	}
+	blocked, reason, _ := isAuthBlockedForModel(auth, "", now)
+	if !blocked || reason != blockReasonCooldown {
+		t.Fatalf("isAuthBlockedForModel() = %v, %v; want parked credential blocked", blocked, reason)
+	}
+
+	ObserveRateLimits(auth, http.StatusOK, http.Header{})
+	if _, parked := rateLimitParkedUntil(auth.ID, time.Now()); parked {
+		t.Fatalf("a successful response should clear the park")
+	}
+}
+
+func TestObserveRateLimitsParksExhaustedAnthropicBucket(t *testing.T) {
+	resetRateLimits(t)
+	auth := &Auth{ID: "claude-b", Provider: "claude"}
+	reset := time.Now().Add(2 * time.Minute).UTC().Truncate(time.Second)
+	header := http.Header{}
+	header.Set("anthropic-ratelimit-requests-limit", "50")
+	header.Set("anthropic-ratelimit-requests-remaining", "0")
+	header.Set("anthropic-ratelimit-requests-reset", reset.Format(time.RFC3339))
+	header.Set("anthropic-ratelimit-tokens-remaining", "1000")
+
+	ObserveRateLimits(auth, http.StatusOK, header)
+
+	until, parked := rateLimitParkedUntil(auth.ID, time.Now())
+	if !parked || !until.Equal(reset) {
+		t.Fatalf("parked = %v until %v, want until %v", parked, until, reset)
+	}
+	states := RateLimitStates()
+	if len(states) != 1 || len(states[0].Buckets) != 2 || states[0].Buckets[0].Limit != 50 {
+		t.Fatalf("RateLimitStates() = %+v", states)
+	}
+}
+
+func TestRetryAfterFromHeadersUsesOpenAIResetAndCaps(t *testing.T) {
+	now := time.Now()
+	header := http.Header{}
+	header.Set("x-ratelimit-remaining-requests", "0")
+	header.Set("x-ratelimit-reset-requests", "6m0s")
+	if d := RetryAfterFromHeaders(header, now); d == nil || *d != 6*time.Minute {
+		t.Fatalf("RetryAfterFromHeaders() = %v, want 6m", d)
+	}
+
+	header = http.Header{}
+	header.Set("Retry-After", "999999")
+	if d := RetryAfterFromHeaders(header, now); d == nil || *d != maxRateLimitPark {
+		t.Fatalf("RetryAfterFromHeaders() = %v, want cap %v", d, maxRateLimitPark)
+	}
+	if d := RetryAfterFromHeaders(http.Header{}, now); d != nil {
+		t.Fatalf("RetryAfterFromHeaders() = %v, want nil", *d)
+	}
+}
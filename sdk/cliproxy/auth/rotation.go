@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRotationDrainPeriod is used when a rotation is started without a drain period.
+	DefaultRotationDrainPeriod = time.Hour
+	// DefaultRotationMaxErrorRate is the highest error rate on the new credential
+	// that still allows the old one to be retired.
+	DefaultRotationMaxErrorRate = 0.05
+)
+
+// RotationStats counts the requests observed on one side of a rotation.
+type RotationStats struct {
+	Requests  int64   `json:"requests"`
+	Failures  int64   `json:"failures"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// RotationStatus is a point-in-time view of a credential rotation.
+type RotationStatus struct {
+	OldID        string        `json:"old_id"`
+	NewID        string        `json:"new_id"`
+	Provider     string        `json:"provider"`
+	StartedAt    time.Time     `json:"started_at"`
+	DrainPeriod  time.Duration `json:"-"`
+	DrainSeconds int64         `json:"drain_seconds"`
+	MaxErrorRate float64       `json:"max_error_rate"`
+	// Drained is the share of traffic currently steered away from the old credential.
+	Drained float64       `json:"drained"`
+	Ready   bool          `json:"ready"`
+	Old     RotationStats `json:"old"`
+	New     RotationStats `json:"new"`
+}
+
+type rotation struct {
+	oldID        string
+	newID        string
+	provider     string
+	startedAt    time.Time
+	drainPeriod  time.Duration
+	maxErrorRate float64
+	old          RotationStats
+	new          RotationStats
+}
+
+// rotationTracker drains traffic from an old upstream credential to its
+// replacement over a period, so in-flight prompt caches on the old credential
+// expire naturally instead of being cut off by an abrupt swap.
+type rotationTracker struct {
+	mu        sync.Mutex
+	rotations map[string]*rotation
+	rand      func() float64
+}
+
+func newRotationTracker() *rotationTracker {
+	return &rotationTracker{rotations: make(map[string]*rotation), rand: rand.Float64}
+}
+
+func (r *rotation) drained(now time.Time) float64 {
+	if r.drainPeriod <= 0 {
+		return 1
+	}
+	fraction := float64(now.Sub(r.startedAt)) / float64(r.drainPeriod)
+	switch {
+	case fraction < 0:
+		return 0
+	case fraction > 1:
+		return 1
+	}
+	return fraction
+}
+
+func (r *rotation) status(now time.Time) RotationStatus {
+	st := RotationStatus{
+		OldID:        r.oldID,
+		NewID:        r.newID,
+		Provider:     r.provider,
+		StartedAt:    r.startedAt,
+		DrainPeriod:  r.drainPeriod,
+		DrainSeconds: int64(r.drainPeriod / time.Second),
+		MaxErrorRate: r.maxErrorRate,
+		Drained:      r.drained(now),
+		Old:          withErrorRate(r.old),
+		New:          withErrorRate(r.new),
+	}
+	st.Ready = st.Drained >= 1 && st.New.Requests > 0 && st.New.ErrorRate <= r.maxErrorRate
+	return st
+}
+
+func withErrorRate(stats RotationStats) RotationStats {
+	if stats.Requests > 0 {
+		stats.ErrorRate = float64(stats.Failures) / float64(stats.Requests)
+	}
+	return stats
+}
+
+// filter drops draining credentials from the candidate list with a probability
+// equal to their drained fraction. The old credential is kept when it is the
+// only candidate left so requests never fail because of a rotation.
+func (t *rotationTracker) filter(candidates []*Auth, now time.Time) []*Auth {
+	if t == nil || len(candidates) < 2 {
+		return candidates
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.rotations) == 0 {
+		return candidates
+	}
+	kept := make([]*Auth, 0, len(candidates))
+	for _, candidate := range candidates {
+		if rot, ok := t.rotations[candidate.ID]; ok {
+			if fraction := rot.drained(now); fraction >= 1 || t.rand() < fraction {
+				continue
+			}
+		}
+		kept = append(kept, candidate)
+	}
+	if len(kept) == 0 {
+		return candidates
+	}
+	return kept
+}
+
+// record counts a request result against any rotation involving the auth.
+func (t *rotationTracker) record(authID string, success bool) {
+	if t == nil || authID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, rot := range t.rotations {
+		var stats *RotationStats
+		switch authID {
+		case rot.oldID:
+			stats = &rot.old
+		case rot.newID:
+			stats = &rot.new
+		default:
+			continue
+		}
+		stats.Requests++
+		if !success {
+			stats.Failures++
+		}
+	}
+}
+
+// StartRotation begins draining traffic from oldID to newID. Both credentials
+// must exist, be enabled, and belong to the same provider. A non-positive drain
+// period uses DefaultRotationDrainPeriod and a non-positive maxErrorRate uses
+// DefaultRotationMaxErrorRate.
+func (m *Manager) StartRotation(oldID, newID string, drainPeriod time.Duration, maxErrorRate float64) (RotationStatus, error) {
+	if oldID == "" || newID == "" || oldID == newID {
+		return RotationStatus{}, &Error{Code: "invalid_rotation", Message: "old and new auth ids must be different and non-empty", HTTPStatus: http.StatusBadRequest}
+	}
+	oldAuth, okOld := m.GetByID(oldID)
+	newAuth, okNew := m.GetByID(newID)
+	if !okOld || !okNew {
+		return RotationStatus{}, &Error{Code: "auth_not_found", Message: "auth not found", HTTPStatus: http.StatusNotFound}
+	}
+	if oldAuth.Provider != newAuth.Provider {
+		return RotationStatus{}, &Error{Code: "invalid_rotation", Message: "old and new auths must use the same provider", HTTPStatus: http.StatusBadRequest}
+	}
+	if oldAuth.Disabled || newAuth.Disabled {
+		return RotationStatus{}, &Error{Code: "invalid_rotation", Message: "old and new auths must be enabled", HTTPStatus: http.StatusBadRequest}
+	}
+	if drainPeriod <= 0 {
+		drainPeriod = DefaultRotationDrainPeriod
+	}
+	if maxErrorRate <= 0 {
+		maxErrorRate = DefaultRotationMaxErrorRate
+	}
+
+	t := m.rotations
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.rotations[oldID]; exists {
+		return RotationStatus{}, &Error{Code: "rotation_exists", Message: "auth is already being rotated", HTTPStatus: http.StatusConflict}
+	}
+	if _, exists := t.rotations[newID]; exists {
+		return RotationStatus{}, &Error{Code: "invalid_rotation", Message: "new auth is itself being drained", HTTPStatus: http.StatusBadRequest}
+	}
+	rot := &rotation{
+		oldID:        oldID,
+		newID:        newID,
+		provider:     oldAuth.Provider,
+		startedAt:    time.Now(),
+		drainPeriod:  drainPeriod,
+		maxErrorRate: maxErrorRate,
+	}
+	t.rotations[oldID] = rot
+	return rot.status(rot.startedAt), nil
+}
+
+// Rotations returns the status of all credential rotations ordered by start time.
+func (m *Manager) Rotations() []RotationStatus {
+	t := m.rotations
+	now := time.Now()
+	t.mu.Lock()
+	out := make([]RotationStatus, 0, len(t.rotations))
+	for _, rot := range t.rotations {
+		out = append(out, rot.status(now))
+	}
+	t.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+// CancelRotation stops draining oldID; the old credential keeps serving traffic.
+func (m *Manager) CancelRotation(oldID string) bool {
+	t := m.rotations
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.rotations[oldID]; !ok {
+		return false
+	}
+	delete(t.rotations, oldID)
+	return true
+}
+
+// RetireRotation disables the old credential of a rotation once traffic has
+// fully drained and the new credential's error rate is within the allowed
+// threshold. force skips both checks.
+func (m *Manager) RetireRotation(ctx context.Context, oldID string, force bool) (RotationStatus, error) {
+	t := m.rotations
+	t.mu.Lock()
+	rot, ok := t.rotations[oldID]
+	if !ok {
+		t.mu.Unlock()
+		return RotationStatus{}, &Error{Code: "rotation_not_found", Message: "rotation not found", HTTPStatus: http.StatusNotFound}
+	}
+	status := rot.status(time.Now())
+	t.mu.Unlock()
+
+	if !force {
+		switch {
+		case status.Drained < 1:
+			return status, &Error{Code: "rotation_not_drained", Message: fmt.Sprintf("old auth is %.0f%% drained", status.Drained*100), HTTPStatus: http.StatusConflict}
+		case status.New.Requests == 0:
+			return status, &Error{Code: "rotation_unverified", Message: "no traffic observed on the new auth yet", HTTPStatus: http.StatusConflict}
+		case status.New.ErrorRate > status.MaxErrorRate:
+			return status, &Error{Code: "rotation_error_rate", Message: fmt.Sprintf("new auth error rate %.2f%% exceeds %.2f%%", status.New.ErrorRate*100, status.MaxErrorRate*100), HTTPStatus: http.StatusConflict}
+		}
+	}
+
+	auth, ok := m.GetByID(oldID)
+	if !ok {
+		m.CancelRotation(oldID)
+		return status, &Error{Code: "auth_not_found", Message: "auth not found", HTTPStatus: http.StatusNotFound}
+	}
+	auth.Disabled = true
+	auth.Status = StatusDisabled
+	auth.StatusMessage = "retired by rotation to " + status.NewID
+	auth.UpdatedAt = time.Now()
+	if _, err := m.Update(ctx, auth); err != nil {
+		return status, err
+	}
+	m.CancelRotation(oldID)
+	return status, nil
+}
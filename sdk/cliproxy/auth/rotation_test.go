@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRotationDrainsAndRetiresOldAuth(t *testing.T) {
+	m := NewManager(nil, nil, nil)
+	ctx := context.Background()
+	for _, id := range []string{"old", "new"} {
+		if _, err := m.Register(ctx, &Auth{ID: id, Provider: "claude"}); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+	}
+
+	if _, err := m.StartRotation("old", "new", time.Hour, 0.1); err != nil {
+		t.Fatalf("StartRotation: %v", err)
+	}
+	if _, err := m.StartRotation("old", "new", time.Hour, 0.1); err == nil {
+		t.Fatal("expected duplicate rotation to fail")
+	}
+
+	candidates := []*Auth{{ID: "old"}, {ID: "new"}}
+	m.rotations.rand = func() float64 { return 0.99 }
+	if got := m.rotations.filter(candidates, time.Now()); len(got) != 2 {
+		t.Fatalf("expected old auth to stay eligible early in the drain, got %d candidates", len(got))
+	}
+	if _, err := m.RetireRotation(ctx, "old", false); err == nil {
+		t.Fatal("expected retire to fail before drain completes")
+	}
+
+	m.rotations.mu.Lock()
+	m.rotations.rotations["old"].startedAt = time.Now().Add(-2 * time.Hour)
+	m.rotations.mu.Unlock()
+	got := m.rotations.filter(candidates, time.Now())
+	if len(got) != 1 || got[0].ID != "new" {
+		t.Fatalf("expected only new auth after drain, got %v", got)
+	}
+	if only := m.rotations.filter(candidates[:1], time.Now()); len(only) != 1 {
+		t.Fatal("expected old auth to be kept when it is the only candidate")
+	}
+
+	m.MarkResult(ctx, Result{AuthID: "new", Provider: "claude", Success: true})
+	m.MarkResult(ctx, Result{AuthID: "new", Provider: "claude", Success: false, Error: &Error{Message: "boom", HTTPStatus: 500}})
+	if _, err := m.RetireRotation(ctx, "old", false); err == nil {
+		t.Fatal("expected retire to fail while new auth error rate exceeds threshold")
+	}
+	for i := 0; i < 18; i++ {
+		m.MarkResult(ctx, Result{AuthID: "new", Provider: "claude", Success: true})
+	}
+
+	status, err := m.RetireRotation(ctx, "old", false)
+	if err != nil {
+		t.Fatalf("RetireRotation: %v", err)
+	}
+	if status.New.Requests != 20 || status.New.Failures != 1 {
+		t.Fatalf("unexpected stats: %+v", status.New)
+	}
+	old, _ := m.GetByID("old")
+	if !old.Disabled || old.Status != StatusDisabled {
+		t.Fatalf("expected old auth to be disabled, got %+v", old)
+	}
+	if len(m.Rotations()) != 0 {
+		t.Fatal("expected rotation to be removed after retire")
+	}
+}
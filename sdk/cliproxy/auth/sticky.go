@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/reqfeatures"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+	"github.com/tidwall/gjson"
+)
+
+// Sticky routing keys accepted in routing.sticky.key.
+const (
+	StickyKeyAPIKey       = "api-key"
+	StickyKeyConversation = "conversation"
+)
+
+const (
+	defaultStickyConversationHeader = "X-Conversation-ID"
+	defaultStickyTTL                = time.Hour
+	// stickySweepEvery is how many new pins are set between sweeps of idle pins.
+	stickySweepEvery = 1024
+)
+
+var stickyDecisions = metrics.Default().NewCounterVec(
+	"cliproxy_sticky_routing_total",
+	"Sticky routing decisions: hit (pinned credential used), pinned (first pin) or failover (pinned credential unavailable, re-pinned).",
+	"outcome",
+)
+
+type stickyPin struct {
+	authID   string
+	lastUsed time.Time
+}
+
+// stickyRouter pins client keys or conversations to the upstream credential that
+// first served them, so upstreams that throttle or cache per conversation see a
+// stable caller.
+type stickyRouter struct {
+	mu             sync.Mutex
+	enabled        bool
+	byConversation bool
+	header         string
+	ttl            time.Duration
+	pins           map[string]*stickyPin
+	newPins        int
+}
+
+func newStickyRouter() *stickyRouter {
+	return &stickyRouter{pins: make(map[string]*stickyPin)}
+}
+
+func (s *stickyRouter) configure(cfg internalconfig.StickyRoutingConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enabled = cfg.Enabled
+	s.byConversation = strings.EqualFold(strings.TrimSpace(cfg.Key), StickyKeyConversation)
+	s.header = strings.TrimSpace(cfg.ConversationHeader)
+	if s.header == "" {
+		s.header = defaultStickyConversationHeader
+	}
+	s.ttl = time.Duration(cfg.TTL) * time.Second
+	if s.ttl <= 0 {
+		s.ttl = defaultStickyTTL
+	}
+	if !s.enabled {
+		s.pins = make(map[string]*stickyPin)
+	}
+}
+
+// key returns the affinity key of a request, or "" when sticky routing is off,
+// the request asked to bypass it, or it carries neither a conversation ID nor
+// a client key.
+func (s *stickyRouter) key(ctx context.Context, provider string, opts cliproxyexecutor.Options) string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	enabled, byConversation, header := s.enabled, s.byConversation, s.header
+	s.mu.Unlock()
+	if !enabled || reqfeatures.FromContext(ctx).CacheBypass {
+		return ""
+	}
+	if byConversation {
+		if id := conversationID(ctx, header, opts.OriginalRequest); id != "" {
+			return provider + "\x00conversation\x00" + id
+		}
+	}
+	if client := clientKeyFromContext(ctx); client != "" {
+		return provider + "\x00key\x00" + client
+	}
+	return ""
+}
+
+// pinned returns the credential pinned to key when it is among the candidates
+// and can serve model now. hadPin reports whether a live pin existed, so a
+// replacement is counted as a failover.
+func (s *stickyRouter) pinned(key string, candidates []*Auth, model string, now time.Time) (auth *Auth, hadPin bool) {
+	if s == nil || key == "" {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pin := s.pins[key]
+	if pin == nil || now.Sub(pin.lastUsed) > s.ttl {
+		return nil, false
+	}
+	for _, candidate := range candidates {
+		if candidate.ID != pin.authID {
+			continue
+		}
+		if blocked, _, _ := isAuthBlockedForModel(candidate, model, now); blocked {
+			break
+		}
+		pin.lastUsed = now
+		stickyDecisions.Inc("hit")
+		return candidate, true
+	}
+	return nil, true
+}
+
+// pin records authID as the credential serving key.
+func (s *stickyRouter) pin(key, authID string, failover bool, now time.Time) {
+	if s == nil || key == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.enabled {
+		return
+	}
+	s.pins[key] = &stickyPin{authID: authID, lastUsed: now}
+	if failover {
+		stickyDecisions.Inc("failover")
+	} else {
+		stickyDecisions.Inc("pinned")
+	}
+	s.newPins++
+	if s.newPins >= stickySweepEvery {
+		s.newPins = 0
+		for k, p := range s.pins {
+			if now.Sub(p.lastUsed) > s.ttl {
+				delete(s.pins, k)
+			}
+		}
+	}
+}
+
+// conversationID reads the conversation ID from the configured header, then
+// from the prompt_cache_key or metadata.user_id of the original request body.
+func conversationID(ctx context.Context, header string, body []byte) string {
+	if ctx != nil {
+		if ginCtx, ok := ctx.Value("gin").(*gin.Context); ok && ginCtx != nil && ginCtx.Request != nil {
+			if id := strings.TrimSpace(ginCtx.Request.Header.Get(header)); id != "" {
+				return id
+			}
+		}
+	}
+	if len(body) == 0 {
+		return ""
+	}
+	for _, path := range []string{"prompt_cache_key", "metadata.user_id"} {
+		if id := strings.TrimSpace(gjson.GetBytes(body, path).String()); id != "" {
+			return id
+		}
+	}
+	return ""
+}
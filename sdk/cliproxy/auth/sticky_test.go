@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/reqfeatures"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+)
+
+func stickyTestContext(clientKey, conversation string) context.Context {
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	ginCtx.Set("apiKey", clientKey)
+	if conversation != "" {
+		ginCtx.Request.Header.Set(defaultStickyConversationHeader, conversation)
+	}
+	return context.WithValue(context.Background(), "gin", ginCtx)
+}
+
+func TestStickyRoutingPinsClientKeyUntilUnhealthy(t *testing.T) {
+	m := newRoutingTestManager(t, internalconfig.RoutingConfig{
+		HealthCheck: internalconfig.UpstreamHealthCheckConfig{Enabled: true, FailureThreshold: 1},
+		Sticky:      internalconfig.StickyRoutingConfig{Enabled: true},
+	})
+	pick := func(ctx context.Context) string {
+		t.Helper()
+		auth, _, err := m.pickNext(ctx, "claude", "", cliproxyexecutor.Options{}, map[string]struct{}{})
+		if err != nil {
+			t.Fatalf("pick: %v", err)
+		}
+		return auth.ID
+	}
+
+	alice, bob := stickyTestContext("sk-alice", ""), stickyTestContext("sk-bob", "")
+	first := pick(alice)
+	other := pick(bob)
+	if first == other {
+		t.Fatalf("round-robin should give the second client the other credential, both got %s", first)
+	}
+	for i := 0; i < 4; i++ {
+		if got := pick(alice); got != first {
+			t.Fatalf("pick #%d for pinned client = %s, want %s", i, got, first)
+		}
+	}
+
+	m.MarkResult(context.Background(), Result{AuthID: first, Provider: "claude", Error: &Error{Message: "bad gateway", HTTPStatus: http.StatusBadGateway}})
+	if got := pick(alice); got != other {
+		t.Fatalf("pinned credential is unhealthy, want failover to %s, got %s", other, got)
+	}
+	if got := pick(alice); got != other {
+		t.Fatalf("failover should become the new pin, got %s", got)
+	}
+}
+
+func TestStickyRoutingByConversation(t *testing.T) {
+	m := newRoutingTestManager(t, internalconfig.RoutingConfig{
+		Sticky: internalconfig.StickyRoutingConfig{Enabled: true, Key: StickyKeyConversation},
+	})
+	pick := func(ctx context.Context, body string) string {
+		t.Helper()
+		auth, _, err := m.pickNext(ctx, "claude", "", cliproxyexecutor.Options{OriginalRequest: []byte(body)}, map[string]struct{}{})
+		if err != nil {
+			t.Fatalf("pick: %v", err)
+		}
+		return auth.ID
+	}
+
+	one := pick(stickyTestContext("sk-shared", "conv-1"), "")
+	two := pick(stickyTestContext("sk-shared", "conv-2"), "")
+	if one == two {
+		t.Fatalf("conversations of one key should be pinned independently, both got %s", one)
+	}
+	if got := pick(stickyTestContext("sk-shared", "conv-1"), ""); got != one {
+		t.Fatalf("conv-1 moved from %s to %s", one, got)
+	}
+	body := `{"metadata":{"user_id":"session-9"}}`
+	fromBody := pick(stickyTestContext("sk-shared", ""), body)
+	for i := 0; i < 3; i++ {
+		if got := pick(stickyTestContext("sk-shared", ""), body); got != fromBody {
+			t.Fatalf("conversation from metadata.user_id moved from %s to %s", fromBody, got)
+		}
+	}
+}
+
+func TestRequestFeaturesBypassStickyAndDisableFallback(t *testing.T) {
+	m := newRoutingTestManager(t, internalconfig.RoutingConfig{Sticky: internalconfig.StickyRoutingConfig{Enabled: true}})
+	ctx := stickyTestContext("sk-alice", "")
+	ginCtx := ctx.Value("gin").(*gin.Context)
+	ginCtx.Set(reqfeatures.ContextKey, reqfeatures.Features{CacheBypass: true, FallbackOff: true})
+
+	if key := m.sticky.key(ctx, "claude", cliproxyexecutor.Options{}); key != "" {
+		t.Fatalf("cache bypass should skip sticky routing, got key %q", key)
+	}
+	first, _, err := m.pickNext(ctx, "claude", "", cliproxyexecutor.Options{}, map[string]struct{}{})
+	if err != nil {
+		t.Fatalf("first pick: %v", err)
+	}
+	if _, _, err = m.pickNext(ctx, "claude", "", cliproxyexecutor.Options{}, map[string]struct{}{first.ID: {}}); err == nil {
+		t.Fatalf("fallback off should not pick a second credential")
+	}
+	providers, attempts := limitFallback(ctx, []string{"claude", "codex"}, 3)
+	if len(providers) != 1 || attempts != 1 {
+		t.Fatalf("limitFallback() = %v, %d; want one provider and one attempt", providers, attempts)
+	}
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+)
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryBackoff    = 250 * time.Millisecond
+	defaultRetryMaxBackoff = 5 * time.Second
+	defaultRetryMultiplier = 2.0
+)
+
+// defaultRetryStatusCodes never include 429: rate-limited credentials are left
+// to the quota cooldown and key pool parking instead.
+var defaultRetryStatusCodes = []int{
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+var upstreamRetries = metrics.Default().NewCounterVec(
+	"cliproxy_upstream_retries_total",
+	"Upstream requests resent after a transient failure, by provider and status (0 for network errors).",
+	"provider", "status",
+)
+
+// retryPolicy is the compiled form of UpstreamRetryConfig.
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	multiplier float64
+	statuses   []int
+}
+
+func newRetryPolicy(cfg internalconfig.UpstreamRetryConfig) *retryPolicy {
+	if !cfg.Enabled {
+		return nil
+	}
+	p := &retryPolicy{
+		attempts:   cfg.MaxAttempts,
+		backoff:    time.Duration(cfg.InitialBackoff) * time.Millisecond,
+		maxBackoff: time.Duration(cfg.MaxBackoff) * time.Millisecond,
+		multiplier: cfg.Multiplier,
+		statuses:   defaultRetryStatusCodes,
+	}
+	if p.attempts <= 0 {
+		p.attempts = defaultRetryAttempts
+	}
+	if p.backoff <= 0 {
+		p.backoff = defaultRetryBackoff
+	}
+	if p.maxBackoff <= 0 {
+		p.maxBackoff = defaultRetryMaxBackoff
+	}
+	if p.maxBackoff < p.backoff {
+		p.maxBackoff = p.backoff
+	}
+	if p.multiplier < 1 {
+		p.multiplier = defaultRetryMultiplier
+	}
+	if len(cfg.StatusCodes) > 0 {
+		p.statuses = slices.DeleteFunc(slices.Clone(cfg.StatusCodes), func(code int) bool { return code == http.StatusTooManyRequests })
+	}
+	return p
+}
+
+// SetUpstreamRetry replaces the policy for retrying transient upstream failures.
+func (m *Manager) SetUpstreamRetry(cfg internalconfig.UpstreamRetryConfig) {
+	if m == nil {
+		return
+	}
+	m.upstreamRetry.Store(newRetryPolicy(cfg))
+}
+
+// retryable reports whether err is a transient upstream failure worth resending:
+// a configured status code, or a connection that was refused, reset or timed out
+// before producing a status.
+func (p *retryPolicy) retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if status := statusCodeFromError(err); status != 0 {
+		return slices.Contains(p.statuses, status)
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// resends reports whether err is resent as retry number retry (starting at 1).
+func (p *retryPolicy) resends(err error, retry int) bool {
+	if p == nil || retry >= p.attempts || !p.retryable(err) {
+		return false
+	}
+	hint := retryAfterFromError(err)
+	return hint == nil || *hint <= p.maxBackoff
+}
+
+// delay returns the full-jitter backoff before retry number n (starting at 1).
+func (p *retryPolicy) delay(n int) time.Duration {
+	ceiling := float64(p.backoff)
+	for i := 1; i < n && ceiling < float64(p.maxBackoff); i++ {
+		ceiling *= p.multiplier
+	}
+	if ceiling > float64(p.maxBackoff) {
+		ceiling = float64(p.maxBackoff)
+	}
+	return time.Duration(rand.Int64N(int64(ceiling)) + 1)
+}
+
+type retryAttemptContextKey struct{}
+
+// withRetryAttempt records which pass of the request retry loop ctx belongs to.
+func withRetryAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, retryAttemptContextKey{}, attempt)
+}
+
+func retryAttemptFromContext(ctx context.Context) int {
+	attempt, _ := ctx.Value(retryAttemptContextKey{}).(int)
+	return attempt
+}
+
+// transientBackoff returns how long a credential that failed with err is
+// parked before the request retry loop resends the request to it, or 0 when
+// the policy does not retry err or its attempt budget is spent. The wait is
+// spent by the request retry loop, after the fair-share slot was released. A
+// Retry-After hint longer than the maximum backoff gets no retry so the
+// request fails over instead.
+func (m *Manager) transientBackoff(ctx context.Context, provider string, err error) time.Duration {
+	p := m.upstreamRetry.Load()
+	retry := retryAttemptFromContext(ctx) + 1
+	if !p.resends(err, retry) {
+		return 0
+	}
+	wait := p.delay(retry)
+	if hint := retryAfterFromError(err); hint != nil {
+		wait = max(wait, *hint)
+	}
+	logEntryWithRequestID(ctx).Debugf("retrying %s request after transient upstream error in %s: %v", provider, wait, err)
+	upstreamRetries.Inc(provider, strconv.Itoa(statusCodeFromError(err)))
+	return wait
+}
+
+// transientCooldown is how long a credential rests after a transient upstream
+// error: the retry backoff when the request is resent, a minute otherwise.
+func transientCooldown(backoff time.Duration) time.Duration {
+	if backoff > 0 {
+		return backoff
+	}
+	return time.Minute
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/registry"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+)
+
+type statusError int
+
+func (e statusError) Error() string   { return http.StatusText(int(e)) }
+func (e statusError) StatusCode() int { return int(e) }
+
+// flakyExecutor fails calls with queued errors before succeeding.
+type flakyExecutor struct {
+	payloadExecutor
+	errs []error
+}
+
+func (e *flakyExecutor) next(auth *Auth) error {
+	e.calls = append(e.calls, auth.ID)
+	if len(e.errs) == 0 {
+		return nil
+	}
+	err := e.errs[0]
+	e.errs = e.errs[1:]
+	return err
+}
+
+func (e *flakyExecutor) Execute(_ context.Context, auth *Auth, _ cliproxyexecutor.Request, _ cliproxyexecutor.Options) (cliproxyexecutor.Response, error) {
+	if err := e.next(auth); err != nil {
+		return cliproxyexecutor.Response{}, err
+	}
+	return cliproxyexecutor.Response{Payload: []byte("ok")}, nil
+}
+
+func (e *flakyExecutor) ExecuteStream(_ context.Context, auth *Auth, _ cliproxyexecutor.Request, _ cliproxyexecutor.Options) (<-chan cliproxyexecutor.StreamChunk, error) {
+	if err := e.next(auth); err != nil {
+		return nil, err
+	}
+	chunks := make(chan cliproxyexecutor.StreamChunk, 2)
+	chunks <- cliproxyexecutor.StreamChunk{Payload: []byte("partial")}
+	chunks <- cliproxyexecutor.StreamChunk{Err: errors.New("connection reset by peer")}
+	close(chunks)
+	return chunks, nil
+}
+
+func newRetryTestManager(t *testing.T, executor *flakyExecutor) *Manager {
+	t.Helper()
+	m := NewManager(nil, &FillFirstSelector{}, nil)
+	m.RegisterExecutor(executor)
+	id := "retry-" + t.Name()
+	if _, err := m.Register(context.Background(), &Auth{ID: id, Provider: "claude"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	registry.GetGlobalRegistry().RegisterClient(id, "claude", []*registry.ModelInfo{{ID: "claude-test"}})
+	t.Cleanup(func() { registry.GetGlobalRegistry().UnregisterClient(id) })
+	m.SetRetryConfig(5, time.Second)
+	m.SetUpstreamRetry(internalconfig.UpstreamRetryConfig{Enabled: true, MaxAttempts: 3, InitialBackoff: 1, MaxBackoff: 5})
+	return m
+}
+
+func TestUpstreamRetryResendsTransientFailures(t *testing.T) {
+	executor := &flakyExecutor{errs: []error{statusError(http.StatusServiceUnavailable), fmt.Errorf("read: %w", syscall.ECONNRESET)}}
+	m := newRetryTestManager(t, executor)
+	resp, err := m.Execute(context.Background(), []string{"claude"}, cliproxyexecutor.Request{Model: "claude-test"}, cliproxyexecutor.Options{})
+	if err != nil || string(resp.Payload) != "ok" {
+		t.Fatalf("expected the third attempt to succeed, got %q, %v", resp.Payload, err)
+	}
+	if len(executor.calls) != 3 {
+		t.Fatalf("expected three attempts on the only credential, got %v", executor.calls)
+	}
+}
+
+func TestUpstreamRetryLeavesOtherFailuresToCooldowns(t *testing.T) {
+	for name, tc := range map[string]struct {
+		errs  []error
+		calls int
+	}{
+		// Client errors are not retried at all.
+		"client error": {errs: []error{statusError(http.StatusBadRequest)}, calls: 1},
+		// A persistent 503 spends the attempt budget, then rests for the usual minute.
+		"persistent 503": {errs: []error{statusError(503), statusError(503), statusError(503), statusError(503)}, calls: 3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			executor := &flakyExecutor{errs: tc.errs}
+			m := newRetryTestManager(t, executor)
+			if _, err := m.Execute(context.Background(), []string{"claude"}, cliproxyexecutor.Request{Model: "claude-test"}, cliproxyexecutor.Options{}); err == nil {
+				t.Fatal("expected the request to fail")
+			}
+			if len(executor.calls) != tc.calls {
+				t.Fatalf("expected %d attempts, got %v", tc.calls, executor.calls)
+			}
+		})
+	}
+}
+
+func TestUpstreamRetryNeverResendsStartedStreams(t *testing.T) {
+	executor := &flakyExecutor{errs: []error{statusError(http.StatusBadGateway)}}
+	m := newRetryTestManager(t, executor)
+	chunks, err := m.ExecuteStream(context.Background(), []string{"claude"}, cliproxyexecutor.Request{Model: "claude-test"}, cliproxyexecutor.Options{})
+	if err != nil {
+		t.Fatalf("expected the retried stream to open, got %v", err)
+	}
+	var got []cliproxyexecutor.StreamChunk
+	for chunk := range chunks {
+		got = append(got, chunk)
+	}
+	if len(got) != 2 || got[1].Err == nil {
+		t.Fatalf("expected the mid-stream error to reach the client, got %+v", got)
+	}
+	if len(executor.calls) != 2 {
+		t.Fatalf("expected one retry to open the stream and none after, got %v", executor.calls)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := newRetryPolicy(internalconfig.UpstreamRetryConfig{Enabled: true, InitialBackoff: 100, MaxBackoff: 300, StatusCodes: []int{429, 503}})
+	for n, ceiling := range map[int]int64{1: 100, 2: 200, 3: 300, 6: 300} {
+		for i := 0; i < 20; i++ {
+			if d := p.delay(n).Milliseconds(); d < 0 || d > ceiling {
+				t.Fatalf("retry %d waited %dms, want at most %dms", n, d, ceiling)
+			}
+		}
+	}
+	if newRetryPolicy(internalconfig.UpstreamRetryConfig{}) != nil {
+		t.Fatal("expected no policy while disabled")
+	}
+	wrapped := fmt.Errorf("upstream: %w", statusError(503))
+	if !p.retryable(wrapped) || !p.retryable(fmt.Errorf("dial: %w", syscall.ECONNREFUSED)) {
+		t.Fatal("expected transient failures to be retryable")
+	}
+	for _, err := range []error{statusError(429), statusError(401), context.Canceled, errors.New("unexpected EOF in request body")} {
+		if p.retryable(err) {
+			t.Fatalf("expected %v not to be retryable", err)
+		}
+	}
+}
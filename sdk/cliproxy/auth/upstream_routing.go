@@ -0,0 +1,433 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/metrics"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+	log "github.com/sirupsen/logrus"
+)
+
+// Routing strategies accepted by per-model rules.
+const (
+	StrategyRoundRobin = "round-robin"
+	StrategyFillFirst  = "fill-first"
+	StrategyWeighted   = "weighted"
+)
+
+const (
+	defaultEndpointFailureThreshold = 3
+	defaultEndpointCooldown         = 30 * time.Second
+	defaultEndpointProbePath        = "/v1/models"
+	defaultEndpointProbeTimeout     = 5 * time.Second
+)
+
+var endpointEjections = metrics.Default().NewCounterVec(
+	"cliproxy_upstream_endpoint_ejections_total",
+	"Times an upstream endpoint was taken out of rotation after consecutive failures.",
+	"endpoint",
+)
+
+// EndpointHealth is the health of one upstream endpoint.
+type EndpointHealth struct {
+	Endpoint            string    `json:"endpoint"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	UnhealthyUntil      time.Time `json:"unhealthy_until,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastCheckedAt       time.Time `json:"last_checked_at,omitempty"`
+}
+
+type routingRule struct {
+	model    string
+	prefix   bool
+	strategy string
+	weights  map[string]int
+}
+
+type endpointState struct {
+	failures       int
+	unhealthyUntil time.Time
+	lastError      string
+	lastChecked    time.Time
+}
+
+// upstreamRouter applies per-model routing rules and tracks upstream endpoint
+// health, so failing endpoints are skipped until they recover.
+type upstreamRouter struct {
+	mu            sync.Mutex
+	rules         []routingRule
+	healthEnabled bool
+	threshold     int
+	cooldown      time.Duration
+	endpoints     map[string]*endpointState
+	// current holds the smooth weighted round-robin state per provider and model.
+	current     map[string]map[string]int
+	roundRobin  RoundRobinSelector
+	fillFirst   FillFirstSelector
+	probeCancel context.CancelFunc
+}
+
+func newUpstreamRouter() *upstreamRouter {
+	return &upstreamRouter{
+		endpoints: make(map[string]*endpointState),
+		current:   make(map[string]map[string]int),
+	}
+}
+
+// endpointKey identifies the upstream endpoint an auth talks to: its base URL,
+// or the auth ID when it uses the provider default.
+func endpointKey(auth *Auth) string {
+	if auth == nil {
+		return ""
+	}
+	if auth.Attributes != nil {
+		if base := strings.TrimRight(strings.TrimSpace(auth.Attributes["base_url"]), "/"); base != "" {
+			return base
+		}
+	}
+	return auth.ID
+}
+
+// SetUpstreamRouting replaces the per-model routing rules and endpoint health
+// settings, restarting active health probes when configured.
+func (m *Manager) SetUpstreamRouting(cfg internalconfig.RoutingConfig) {
+	if m == nil || m.upstreams == nil {
+		return
+	}
+	m.breakers.configure(cfg.CircuitBreaker)
+	m.sticky.configure(cfg.Sticky)
+	m.SetUpstreamRetry(cfg.Retry)
+	r := m.upstreams
+	rules := make([]routingRule, 0, len(cfg.Models))
+	for _, rc := range cfg.Models {
+		model := strings.ToLower(strings.TrimSpace(rc.Model))
+		if model == "" {
+			continue
+		}
+		rule := routingRule{model: model, strategy: strings.ToLower(strings.TrimSpace(rc.Strategy))}
+		if strings.HasSuffix(model, "*") {
+			rule.model, rule.prefix = strings.TrimSuffix(model, "*"), true
+		}
+		if len(rc.Weights) > 0 {
+			rule.weights = make(map[string]int, len(rc.Weights))
+			for endpoint, weight := range rc.Weights {
+				if weight < 0 {
+					weight = 0
+				}
+				rule.weights[strings.TrimRight(strings.TrimSpace(endpoint), "/")] = weight
+			}
+		}
+		rules = append(rules, rule)
+	}
+	health := cfg.HealthCheck
+	threshold := health.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultEndpointFailureThreshold
+	}
+	cooldown := time.Duration(health.Cooldown) * time.Second
+	if cooldown <= 0 {
+		cooldown = defaultEndpointCooldown
+	}
+
+	r.mu.Lock()
+	r.rules = rules
+	r.healthEnabled = health.Enabled
+	r.threshold = threshold
+	r.cooldown = cooldown
+	if !health.Enabled {
+		r.endpoints = make(map[string]*endpointState)
+	}
+	r.current = make(map[string]map[string]int)
+	if r.probeCancel != nil {
+		r.probeCancel()
+		r.probeCancel = nil
+	}
+	var probeCtx context.Context
+	if health.Enabled && health.Interval > 0 {
+		probeCtx, r.probeCancel = context.WithCancel(context.Background())
+	}
+	r.mu.Unlock()
+
+	if probeCtx != nil {
+		path := strings.TrimSpace(health.Path)
+		if path == "" {
+			path = defaultEndpointProbePath
+		}
+		timeout := time.Duration(health.Timeout) * time.Second
+		if timeout <= 0 {
+			timeout = defaultEndpointProbeTimeout
+		}
+		go m.runEndpointProbes(probeCtx, time.Duration(health.Interval)*time.Second, path, timeout)
+	}
+}
+
+// UpstreamHealth returns the tracked upstream endpoints, sorted by endpoint.
+func (m *Manager) UpstreamHealth() []EndpointHealth {
+	if m == nil || m.upstreams == nil {
+		return nil
+	}
+	r := m.upstreams
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]EndpointHealth, 0, len(r.endpoints))
+	for endpoint, state := range r.endpoints {
+		out = append(out, EndpointHealth{
+			Endpoint:            endpoint,
+			Healthy:             !now.Before(state.unhealthyUntil),
+			ConsecutiveFailures: state.failures,
+			UnhealthyUntil:      state.unhealthyUntil,
+			LastError:           state.lastError,
+			LastCheckedAt:       state.lastChecked,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Endpoint < out[j].Endpoint })
+	return out
+}
+
+// ruleFor returns the first rule matching model. Callers must hold r.mu.
+func (r *upstreamRouter) ruleFor(model string) *routingRule {
+	model = strings.ToLower(strings.TrimSpace(model))
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if rule.model == model || (rule.prefix && strings.HasPrefix(model, rule.model)) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// weight returns an auth's weight under rule; unlisted endpoints weigh 1.
+func (rule *routingRule) weight(auth *Auth) int {
+	if rule == nil || rule.weights == nil {
+		return 1
+	}
+	if w, ok := rule.weights[endpointKey(auth)]; ok {
+		return w
+	}
+	if w, ok := rule.weights[auth.ID]; ok {
+		return w
+	}
+	return 1
+}
+
+// filter drops endpoints excluded for the model and endpoints marked unhealthy.
+// Each step is skipped when it would leave no candidates, so routing never
+// fails outright because of it.
+func (r *upstreamRouter) filter(candidates []*Auth, model string, now time.Time) []*Auth {
+	if r == nil || len(candidates) == 0 {
+		return candidates
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rule := r.ruleFor(model); rule != nil && rule.weights != nil {
+		candidates = keepAuths(candidates, func(a *Auth) bool { return rule.weight(a) > 0 })
+	}
+	if r.healthEnabled && len(r.endpoints) > 0 {
+		candidates = keepAuths(candidates, func(a *Auth) bool {
+			state := r.endpoints[endpointKey(a)]
+			return state == nil || !now.Before(state.unhealthyUntil)
+		})
+	}
+	return candidates
+}
+
+func keepAuths(candidates []*Auth, keep func(*Auth) bool) []*Auth {
+	kept := make([]*Auth, 0, len(candidates))
+	for _, candidate := range candidates {
+		if keep(candidate) {
+			kept = append(kept, candidate)
+		}
+	}
+	if len(kept) == 0 {
+		return candidates
+	}
+	return kept
+}
+
+// pick selects an auth using the model's routing rule, or fallback when no rule
+// overrides the strategy.
+func (r *upstreamRouter) pick(ctx context.Context, provider, model string, opts cliproxyexecutor.Options, candidates []*Auth, fallback Selector) (*Auth, error) {
+	if r == nil {
+		return fallback.Pick(ctx, provider, model, opts, candidates)
+	}
+	r.mu.Lock()
+	rule := r.ruleFor(model)
+	strategy := ""
+	if rule != nil {
+		strategy = rule.strategy
+	}
+	r.mu.Unlock()
+	switch strategy {
+	case StrategyRoundRobin:
+		return r.roundRobin.Pick(ctx, provider, model, opts, candidates)
+	case StrategyFillFirst:
+		return r.fillFirst.Pick(ctx, provider, model, opts, candidates)
+	case StrategyWeighted:
+		available, err := getAvailableAuths(candidates, provider, model, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return r.pickWeighted(provider+":"+model, rule, available), nil
+	default:
+		return fallback.Pick(ctx, provider, model, opts, candidates)
+	}
+}
+
+// pickWeighted implements smooth weighted round-robin: every candidate gains its
+// weight, the highest current value wins and pays back the total.
+func (r *upstreamRouter) pickWeighted(key string, rule *routingRule, available []*Auth) *Auth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current := r.current[key]
+	if current == nil {
+		current = make(map[string]int)
+		r.current[key] = current
+	}
+	var best *Auth
+	total := 0
+	for _, candidate := range available {
+		w := rule.weight(candidate)
+		if w <= 0 {
+			w = 1
+		}
+		total += w
+		current[candidate.ID] += w
+		if best == nil || current[candidate.ID] > current[best.ID] {
+			best = candidate
+		}
+	}
+	current[best.ID] -= total
+	return best
+}
+
+// record updates endpoint health from an execution or probe outcome.
+func (r *upstreamRouter) record(endpoint string, failed bool, reason string, now time.Time) {
+	if r == nil || endpoint == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.healthEnabled {
+		return
+	}
+	state := r.endpoints[endpoint]
+	if state == nil {
+		state = &endpointState{}
+		r.endpoints[endpoint] = state
+	}
+	state.lastChecked = now
+	if !failed {
+		state.failures = 0
+		state.unhealthyUntil = time.Time{}
+		state.lastError = ""
+		return
+	}
+	state.failures++
+	state.lastError = reason
+	if state.failures >= r.threshold && !now.Before(state.unhealthyUntil) {
+		state.unhealthyUntil = now.Add(r.cooldown)
+		endpointEjections.Inc(endpoint)
+		log.Warnf("upstream endpoint %s marked unhealthy for %s after %d consecutive failures: %s", endpoint, r.cooldown, state.failures, reason)
+	}
+}
+
+// isEndpointFailure reports whether an execution error points at the upstream
+// endpoint itself (5xx or a network failure) rather than the request or credential.
+func isEndpointFailure(err *Error) bool {
+	if err == nil {
+		return false
+	}
+	if err.HTTPStatus >= http.StatusInternalServerError {
+		return true
+	}
+	if err.HTTPStatus != 0 {
+		return false
+	}
+	msg := strings.ToLower(err.Message)
+	for _, marker := range []string{"timeout", "deadline exceeded", "connection refused", "connection reset", "no such host", "eof"} {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordEndpointResult feeds an execution result into endpoint health tracking.
+func (m *Manager) recordEndpointResult(result Result) {
+	m.mu.RLock()
+	endpoint := endpointKey(m.auths[result.AuthID])
+	m.mu.RUnlock()
+	failed := !result.Success && isEndpointFailure(result.Error)
+	if !result.Success && !failed {
+		// Client and credential errors say nothing about the endpoint.
+		return
+	}
+	reason := ""
+	if result.Error != nil {
+		reason = result.Error.Message
+	}
+	m.upstreams.record(endpoint, failed, reason, time.Now())
+}
+
+// runEndpointProbes requests path on every configured base URL each interval.
+func (m *Manager) runEndpointProbes(ctx context.Context, interval time.Duration, path string, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.probeEndpoints(ctx, path, timeout)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) probeEndpoints(ctx context.Context, path string, timeout time.Duration) {
+	seen := make(map[string]struct{})
+	for _, auth := range m.snapshotAuths() {
+		if auth.Disabled || auth.Attributes == nil || strings.TrimSpace(auth.Attributes["base_url"]) == "" {
+			continue
+		}
+		endpoint := endpointKey(auth)
+		if _, done := seen[endpoint]; done {
+			continue
+		}
+		seen[endpoint] = struct{}{}
+		client := &http.Client{Timeout: timeout}
+		if rt := m.roundTripperFor(auth); rt != nil {
+			client.Transport = rt
+		}
+		failed, reason := probeEndpoint(ctx, client, endpoint+path)
+		if ctx.Err() != nil {
+			return
+		}
+		m.upstreams.record(endpoint, failed, reason, time.Now())
+	}
+}
+
+// probeEndpoint treats any response below 500 as healthy; authentication errors
+// still prove the endpoint is up.
+func probeEndpoint(ctx context.Context, client *http.Client, url string) (bool, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return true, err.Error()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err.Error()
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return true, "health probe returned " + resp.Status
+	}
+	return false, ""
+}
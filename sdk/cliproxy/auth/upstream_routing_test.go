@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	internalconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	cliproxyexecutor "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/executor"
+)
+
+func newRoutingTestManager(t *testing.T, cfg internalconfig.RoutingConfig) *Manager {
+	t.Helper()
+	m := NewManager(nil, &RoundRobinSelector{}, nil)
+	m.RegisterExecutor(&payloadExecutor{payloads: map[string]string{}})
+	for id, base := range map[string]string{"primary": "https://primary.example.com/", "backup": "https://backup.example.com"} {
+		auth := &Auth{ID: id, Provider: "claude", Attributes: map[string]string{"base_url": base}}
+		if _, err := m.Register(context.Background(), auth); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+	}
+	m.SetUpstreamRouting(cfg)
+	return m
+}
+
+func TestWeightedRoutingSplitsTrafficByWeight(t *testing.T) {
+	m := newRoutingTestManager(t, internalconfig.RoutingConfig{Models: []internalconfig.ModelRoutingConfig{{
+		Model:    "*",
+		Strategy: StrategyWeighted,
+		Weights:  map[string]int{"https://primary.example.com": 3},
+	}}})
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		auth, _, err := m.pickNext(context.Background(), "claude", "", cliproxyexecutor.Options{}, map[string]struct{}{})
+		if err != nil {
+			t.Fatalf("pick: %v", err)
+		}
+		counts[auth.ID]++
+	}
+	if counts["primary"] != 6 || counts["backup"] != 2 {
+		t.Fatalf("expected a 3:1 split, got %v", counts)
+	}
+
+	m.SetUpstreamRouting(internalconfig.RoutingConfig{Models: []internalconfig.ModelRoutingConfig{{
+		Model:   "*",
+		Weights: map[string]int{"backup": 0},
+	}}})
+	for i := 0; i < 3; i++ {
+		auth, _, _ := m.pickNext(context.Background(), "claude", "", cliproxyexecutor.Options{}, map[string]struct{}{})
+		if auth.ID != "primary" {
+			t.Fatalf("expected zero-weight endpoint to be skipped, got %s", auth.ID)
+		}
+	}
+}
+
+func TestUnhealthyEndpointIsSkippedUntilCooldown(t *testing.T) {
+	m := newRoutingTestManager(t, internalconfig.RoutingConfig{HealthCheck: internalconfig.UpstreamHealthCheckConfig{Enabled: true, FailureThreshold: 2}})
+	fail := Result{AuthID: "primary", Provider: "claude", Error: &Error{Message: "bad gateway", HTTPStatus: http.StatusBadGateway}}
+	m.MarkResult(context.Background(), Result{AuthID: "primary", Provider: "claude", Error: &Error{Message: "bad request", HTTPStatus: http.StatusBadRequest}})
+	m.MarkResult(context.Background(), fail)
+	m.MarkResult(context.Background(), fail)
+
+	for i := 0; i < 3; i++ {
+		auth, _, _ := m.pickNext(context.Background(), "claude", "", cliproxyexecutor.Options{}, map[string]struct{}{})
+		if auth.ID != "backup" {
+			t.Fatalf("expected unhealthy endpoint to be skipped, got %s", auth.ID)
+		}
+	}
+	health := m.UpstreamHealth()
+	if len(health) != 1 || health[0].Endpoint != "https://primary.example.com" || health[0].Healthy || health[0].ConsecutiveFailures != 2 {
+		t.Fatalf("unexpected health %+v", health)
+	}
+
+	// Every endpoint down still routes somewhere.
+	m.upstreams.record("https://backup.example.com", true, "timeout", time.Now())
+	m.upstreams.record("https://backup.example.com", true, "timeout", time.Now())
+	if _, _, err := m.pickNext(context.Background(), "claude", "", cliproxyexecutor.Options{}, map[string]struct{}{}); err != nil {
+		t.Fatalf("expected a pick with every endpoint unhealthy: %v", err)
+	}
+}
+
+func TestEndpointProbesRecoverEndpoint(t *testing.T) {
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	m := NewManager(nil, &RoundRobinSelector{}, nil)
+	if _, err := m.Register(context.Background(), &Auth{ID: "a", Provider: "claude", Attributes: map[string]string{"base_url": server.URL}}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	m.SetUpstreamRouting(internalconfig.RoutingConfig{HealthCheck: internalconfig.UpstreamHealthCheckConfig{Enabled: true, FailureThreshold: 1}})
+
+	m.probeEndpoints(context.Background(), "/v1/models", time.Second)
+	if health := m.UpstreamHealth(); len(health) != 1 || health[0].Healthy {
+		t.Fatalf("expected endpoint to be unhealthy, got %+v", health)
+	}
+	healthy.Store(true)
+	m.probeEndpoints(context.Background(), "/v1/models", time.Second)
+	if health := m.UpstreamHealth(); len(health) != 1 || !health[0].Healthy {
+		t.Fatalf("expected endpoint to recover, got %+v", health)
+	}
+}
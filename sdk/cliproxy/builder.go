@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/access"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/api"
 	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
 	sdkAuth "github.com/router-for-me/CLIProxyAPI/v6/sdk/auth"
@@ -186,11 +187,9 @@ func (b *Builder) Build() (*Service, error) {
 		accessManager = sdkaccess.NewManager()
 	}
 
-	providers, err := sdkaccess.BuildProviders(&b.cfg.SDKConfig)
-	if err != nil {
+	if _, err := access.ApplyAccessProviders(accessManager, nil, b.cfg); err != nil {
 		return nil, err
 	}
-	accessManager.SetProviders(providers)
 
 	coreManager := b.coreManager
 	if coreManager == nil {
@@ -216,6 +215,9 @@ func (b *Builder) Build() (*Service, error) {
 	// Attach a default RoundTripper provider so providers can opt-in per-auth transports.
 	coreManager.SetRoundTripperProvider(newDefaultRoundTripperProvider())
 	coreManager.SetOAuthModelMappings(b.cfg.OAuthModelMappings)
+	coreManager.SetFairShare(b.cfg.FairShare)
+	coreManager.SetContractChecks(b.cfg.ContractChecks)
+	coreManager.SetUpstreamRouting(b.cfg.Routing)
 
 	service := &Service{
 		cfg:            b.cfg,
@@ -0,0 +1,68 @@
+// Package contentfilter lets embedders plug custom content inspection into the
+// proxy's prompt and response filter chain. A registered filter is referenced
+// from the content-filters configuration by name and reports the spans of text
+// it objects to; the configured rule decides whether they are redacted or the
+// request is blocked.
+package contentfilter
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Direction tells a filter which way the inspected text is flowing.
+type Direction string
+
+// Directions
+const (
+	// Request is prompt content sent by the client.
+	Request Direction = "request"
+	// Response is content generated by the upstream model.
+	Response Direction = "response"
+)
+
+// Match is a half-open byte range [Start, End) of the inspected text.
+type Match struct {
+	Start int
+	End   int
+}
+
+// Filter inspects a piece of text and returns the ranges that match it.
+// Implementations must be safe for concurrent use.
+type Filter interface {
+	Inspect(ctx context.Context, direction Direction, text string) []Match
+}
+
+// FilterFunc adapts a function to Filter.
+type FilterFunc func(ctx context.Context, direction Direction, text string) []Match
+
+// Inspect implements Filter.
+func (f FilterFunc) Inspect(ctx context.Context, direction Direction, text string) []Match {
+	return f(ctx, direction, text)
+}
+
+var (
+	mu      sync.RWMutex
+	filters = make(map[string]Filter)
+)
+
+// Register makes a filter available to content-filters rules under name,
+// replacing any filter registered before under the same name.
+func Register(name string, filter Filter) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || filter == nil {
+		return
+	}
+	mu.Lock()
+	filters[name] = filter
+	mu.Unlock()
+}
+
+// Lookup returns the filter registered under name.
+func Lookup(name string) (Filter, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	filter, ok := filters[strings.ToLower(strings.TrimSpace(name))]
+	return filter, ok
+}
@@ -0,0 +1,263 @@
+// Package events exposes the proxy's domain events to programs embedding it, as
+// typed values delivered over channels, so embedders can react to bans, device
+// and key changes or usage in-process instead of through webhooks.
+package events
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	internalevents "github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+// Kind is a set of event categories; combine them with |.
+type Kind uint
+
+const (
+	// BanEvents are bans and unbans of API keys, devices and client IPs.
+	BanEvents Kind = 1 << iota
+	// DeviceEvents are device registrations, rejections, approvals and TLS fingerprint mismatches.
+	DeviceEvents
+	// KeyEvents are client API keys minted, rotated or revoked by an admin.
+	KeyEvents
+	// AlertEvents are alert rules firing and resolving.
+	AlertEvents
+	// SystemEvents are limit boosts, rate limit hits, upstream failovers, verbose
+	// logging timeouts and management recovery.
+	SystemEvents
+	// UsageEvents are usage records of completed upstream requests.
+	UsageEvents
+
+	// AllEvents subscribes to every category.
+	AllEvents = BanEvents | DeviceEvents | KeyEvents | AlertEvents | SystemEvents | UsageEvents
+)
+
+// DefaultBuffer is the channel capacity of a subscription when none is given.
+const DefaultBuffer = 256
+
+// Event is implemented by every event type: BanEvent, DeviceEvent, KeyEvent,
+// AlertEvent, SystemEvent and UsageEvent.
+type Event interface {
+	// Kind returns the category the event belongs to.
+	Kind() Kind
+	// OccurredAt returns when the event happened.
+	OccurredAt() time.Time
+}
+
+// Domain carries the fields shared by events published by the proxy runtime.
+type Domain struct {
+	// Type is the event type, e.g. "ban" or "device_registered".
+	Type   string
+	Time   time.Time
+	APIKey string
+	// Actor is "system" for automatic actions, otherwise the admin source.
+	Actor  string
+	Reason string
+	// Data holds type-specific details, e.g. "strikes" of a ban.
+	Data map[string]any
+}
+
+// OccurredAt returns when the event happened.
+func (d Domain) OccurredAt() time.Time { return d.Time }
+
+// BanEvent is published when an API key, or a device or client IP of a key, is
+// banned or unbanned.
+type BanEvent struct {
+	Domain
+	DeviceID string
+	IP       string
+	// Lifted is true for unbans.
+	Lifted bool
+	// DeviceOnly is true when a single device or client IP was (un)banned rather than the key.
+	DeviceOnly bool
+}
+
+// Kind returns BanEvents.
+func (BanEvent) Kind() Kind { return BanEvents }
+
+// DeviceEvent is published when a device of a key is registered, rejected,
+// awaiting approval or approved, or presents an unpinned TLS fingerprint.
+type DeviceEvent struct {
+	Domain
+	DeviceID string
+	IP       string
+}
+
+// Kind returns DeviceEvents.
+func (DeviceEvent) Kind() Kind { return DeviceEvents }
+
+// KeyEvent is published when an admin mints, rotates or revokes a client API key.
+type KeyEvent struct {
+	Domain
+}
+
+// Kind returns KeyEvents.
+func (KeyEvent) Kind() Kind { return KeyEvents }
+
+// AlertEvent is published when an alert rule starts or stops firing.
+type AlertEvent struct {
+	Domain
+	// Resolved is true when the rule's condition no longer holds.
+	Resolved bool
+}
+
+// Kind returns AlertEvents.
+func (AlertEvent) Kind() Kind { return AlertEvents }
+
+// SystemEvent covers the remaining operational events: limit boosts, verbose
+// logging timeouts, management recovery, rate limit hits and upstream failovers.
+type SystemEvent struct {
+	Domain
+}
+
+// Kind returns SystemEvents.
+func (SystemEvent) Kind() Kind { return SystemEvents }
+
+// UsageEvent carries the usage record of one upstream request.
+type UsageEvent struct {
+	usage.Record
+}
+
+// Kind returns UsageEvents.
+func (UsageEvent) Kind() Kind { return UsageEvents }
+
+// OccurredAt returns when the request was made.
+func (e UsageEvent) OccurredAt() time.Time { return e.RequestedAt }
+
+// Subscription delivers the events of the subscribed kinds until its context
+// ends, after which C is closed.
+type Subscription struct {
+	// C receives the events. Delivery never blocks the proxy: events that do not
+	// fit into the buffer are dropped and counted.
+	C <-chan Event
+
+	kinds   Kind
+	ch      chan Event
+	mu      sync.Mutex
+	closed  bool
+	dropped atomic.Uint64
+}
+
+// Dropped returns how many events were discarded because C was full.
+func (s *Subscription) Dropped() uint64 { return s.dropped.Load() }
+
+func (s *Subscription) deliver(ev Event) {
+	if s.kinds&ev.Kind() == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- ev:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *Subscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// Stream fans proxy events out to typed subscriptions.
+type Stream struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	attach sync.Once
+}
+
+var defaultStream = &Stream{subs: make(map[*Subscription]struct{})}
+
+// Default returns the stream of the process-wide event bus and usage manager.
+func Default() *Stream { return defaultStream }
+
+// Subscribe delivers events of the given kinds, e.g. BanEvents|UsageEvents,
+// until ctx is cancelled.
+func (s *Stream) Subscribe(ctx context.Context, kinds Kind) *Subscription {
+	return s.SubscribeBuffered(ctx, kinds, DefaultBuffer)
+}
+
+// SubscribeBuffered is Subscribe with a custom channel capacity.
+func (s *Stream) SubscribeBuffered(ctx context.Context, kinds Kind, buffer int) *Subscription {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	s.attach.Do(func() {
+		internalevents.Subscribe(func(ev internalevents.Event) { s.publish(fromDomain(ev)) })
+		usage.RegisterPlugin(usagePlugin{stream: s})
+	})
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, kinds: kinds, ch: ch}
+	s.mu.Lock()
+	s.subs[sub] = struct{}{}
+	s.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.subs, sub)
+		s.mu.Unlock()
+		sub.close()
+	}()
+	return sub
+}
+
+func (s *Stream) publish(ev Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for sub := range s.subs {
+		sub.deliver(ev)
+	}
+}
+
+// usagePlugin forwards usage records to the stream.
+type usagePlugin struct {
+	stream *Stream
+}
+
+func (p usagePlugin) HandleUsage(_ context.Context, record usage.Record) {
+	p.stream.publish(UsageEvent{Record: record})
+}
+
+// fromDomain converts an event of the internal bus into its typed form.
+func fromDomain(ev internalevents.Event) Event {
+	domain := Domain{
+		Type:   string(ev.Type),
+		Time:   ev.Time,
+		APIKey: ev.APIKey,
+		Actor:  ev.Actor,
+		Reason: ev.Reason,
+		Data:   ev.Data,
+	}
+	switch ev.Type {
+	case internalevents.TypeBan, internalevents.TypeUnban, internalevents.TypeDeviceBanned, internalevents.TypeDeviceUnbanned:
+		return BanEvent{
+			Domain:     domain,
+			DeviceID:   ev.DeviceID,
+			IP:         ev.IP,
+			Lifted:     ev.Type == internalevents.TypeUnban || ev.Type == internalevents.TypeDeviceUnbanned,
+			DeviceOnly: ev.Type == internalevents.TypeDeviceBanned || ev.Type == internalevents.TypeDeviceUnbanned,
+		}
+	case internalevents.TypeDeviceRegistered, internalevents.TypeDeviceRejected, internalevents.TypeDevicePending,
+		internalevents.TypeDeviceApproved, internalevents.TypeTLSFingerprintMismatch:
+		return DeviceEvent{Domain: domain, DeviceID: ev.DeviceID, IP: ev.IP}
+	case internalevents.TypeKeyCreated, internalevents.TypeKeyRotated, internalevents.TypeKeyRevoked:
+		return KeyEvent{Domain: domain}
+	case internalevents.TypeAlertFiring, internalevents.TypeAlertResolved:
+		return AlertEvent{Domain: domain, Resolved: ev.Type == internalevents.TypeAlertResolved}
+	default:
+		return SystemEvent{Domain: domain}
+	}
+}
@@ -0,0 +1,49 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	internalevents "github.com/router-for-me/CLIProxyAPI/v6/internal/events"
+	"github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
+)
+
+func TestSubscribeDeliversSubscribedKindsUntilCancelled(t *testing.T) {
+	stream := &Stream{subs: make(map[*Subscription]struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := stream.Subscribe(ctx, BanEvents|UsageEvents)
+
+	stream.publish(fromDomain(internalevents.Event{Type: internalevents.TypeDeviceRegistered, APIKey: "k"}))
+	stream.publish(fromDomain(internalevents.Event{Type: internalevents.TypeUnban, APIKey: "k", Reason: "appeal"}))
+	usagePlugin{stream: stream}.HandleUsage(ctx, usage.Record{Model: "m"})
+
+	ban, ok := (<-sub.C).(BanEvent)
+	if !ok || !ban.Lifted || ban.DeviceOnly || ban.Reason != "appeal" {
+		t.Fatalf("expected the unban first, got %+v", ban)
+	}
+	if used, okUsage := (<-sub.C).(UsageEvent); !okUsage || used.Model != "m" {
+		t.Fatalf("expected the usage event, got %+v", used)
+	}
+
+	cancel()
+	select {
+	case _, open := <-sub.C:
+		if open {
+			t.Fatal("expected no further events")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the channel to close after cancellation")
+	}
+}
+
+func TestSubscriptionDropsWhenFull(t *testing.T) {
+	stream := &Stream{subs: make(map[*Subscription]struct{})}
+	sub := stream.SubscribeBuffered(context.Background(), AlertEvents, 1)
+	for i := 0; i < 3; i++ {
+		stream.publish(fromDomain(internalevents.Event{Type: internalevents.TypeAlertFiring}))
+	}
+	if sub.Dropped() != 2 {
+		t.Fatalf("expected 2 dropped events, got %d", sub.Dropped())
+	}
+}
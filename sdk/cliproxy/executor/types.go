@@ -60,3 +60,33 @@ type StatusError interface {
 	error
 	StatusCode() int
 }
+
+// StatusOverloaded is the status Anthropic uses for overloaded_error responses.
+const StatusOverloaded = 529
+
+// OverloadedError reports an upstream "overloaded" error event received inside a
+// stream that had already started. TokensDelivered tells whether generated content
+// was emitted before the failure; when it was not, nothing but held-back preamble
+// was produced and the request can safely be retried from scratch.
+type OverloadedError struct {
+	// Message is the upstream error message.
+	Message string
+	// TokensDelivered reports whether content was emitted before the failure.
+	TokensDelivered bool
+}
+
+// Error implements error. When content was already delivered the message carries
+// resume guidance, since the partial response cannot be retried transparently.
+func (e *OverloadedError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = "upstream overloaded"
+	}
+	if e.TokensDelivered {
+		msg += "; the response was cut off after partial output. To continue, resend the request with the partial assistant response appended as the last assistant message"
+	}
+	return msg
+}
+
+// StatusCode implements StatusError.
+func (e *OverloadedError) StatusCode() int { return StatusOverloaded }
@@ -42,14 +42,14 @@ type queueItem struct {
 
 // Manager maintains a queue of usage records and delivers them to registered plugins.
 type Manager struct {
-	once     sync.Once
-	stopOnce sync.Once
-	cancel   context.CancelFunc
-
-	mu     sync.Mutex
-	cond   *sync.Cond
-	queue  []queueItem
-	closed bool
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []queueItem
+	started bool
+	closed  bool
+	// gen identifies the current dispatcher so one replaced by a restart exits.
+	gen    int
+	cancel context.CancelFunc
 
 	pluginsMu sync.RWMutex
 	plugins   []Plugin
@@ -62,19 +62,31 @@ func NewManager(buffer int) *Manager {
 	return m
 }
 
-// Start launches the background dispatcher. Calling Start multiple times is safe.
+// Start launches the background dispatcher. Calling Start multiple times is safe;
+// calling it after Stop launches a new dispatcher, e.g. for a restarted service.
 func (m *Manager) Start(ctx context.Context) {
 	if m == nil {
 		return
 	}
-	m.once.Do(func() {
-		if ctx == nil {
-			ctx = context.Background()
-		}
-		var workerCtx context.Context
-		workerCtx, m.cancel = context.WithCancel(ctx)
-		go m.run(workerCtx)
-	})
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.startLocked(ctx)
+}
+
+func (m *Manager) startLocked(ctx context.Context) {
+	if m.started && !m.closed {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	m.started = true
+	m.closed = false
+	m.gen++
+	var workerCtx context.Context
+	workerCtx, m.cancel = context.WithCancel(ctx)
+	go m.run(workerCtx, m.gen)
+	m.cond.Broadcast()
 }
 
 // Stop stops the dispatcher and drains the queue.
@@ -82,15 +94,17 @@ func (m *Manager) Stop() {
 	if m == nil {
 		return
 	}
-	m.stopOnce.Do(func() {
-		if m.cancel != nil {
-			m.cancel()
-		}
-		m.mu.Lock()
-		m.closed = true
+	m.mu.Lock()
+	if !m.started || m.closed {
 		m.mu.Unlock()
-		m.cond.Broadcast()
-	})
+		return
+	}
+	m.closed = true
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.mu.Unlock()
+	m.cond.Broadcast()
 }
 
 // Register appends a plugin to the delivery list.
@@ -109,9 +123,11 @@ func (m *Manager) Publish(ctx context.Context, record Record) {
 	if m == nil {
 		return
 	}
-	// ensure worker is running even if Start was not called explicitly
-	m.Start(context.Background())
 	m.mu.Lock()
+	// ensure worker is running even if Start was not called explicitly
+	if !m.started {
+		m.startLocked(context.Background())
+	}
 	if m.closed {
 		m.mu.Unlock()
 		return
@@ -121,13 +137,13 @@ func (m *Manager) Publish(ctx context.Context, record Record) {
 	m.cond.Signal()
 }
 
-func (m *Manager) run(ctx context.Context) {
+func (m *Manager) run(ctx context.Context, gen int) {
 	for {
 		m.mu.Lock()
-		for !m.closed && len(m.queue) == 0 {
+		for !m.closed && m.gen == gen && len(m.queue) == 0 {
 			m.cond.Wait()
 		}
-		if len(m.queue) == 0 && m.closed {
+		if m.gen != gen || (len(m.queue) == 0 && m.closed) {
 			m.mu.Unlock()
 			return
 		}
@@ -0,0 +1,45 @@
+package usage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type recordingPlugin struct {
+	records chan Record
+}
+
+func (p *recordingPlugin) HandleUsage(_ context.Context, record Record) {
+	p.records <- record
+}
+
+func TestManagerRestartsAfterStop(t *testing.T) {
+	m := NewManager(0)
+	plugin := &recordingPlugin{records: make(chan Record, 4)}
+	m.Register(plugin)
+
+	m.Start(context.Background())
+	m.Publish(context.Background(), Record{Model: "first"})
+	expectRecord(t, plugin, "first")
+
+	m.Stop()
+	m.Publish(context.Background(), Record{Model: "dropped"})
+
+	m.Start(context.Background())
+	m.Publish(context.Background(), Record{Model: "second"})
+	expectRecord(t, plugin, "second")
+	m.Stop()
+}
+
+func expectRecord(t *testing.T, plugin *recordingPlugin, model string) {
+	t.Helper()
+	select {
+	case record := <-plugin.records:
+		if record.Model != model {
+			t.Fatalf("got record for %q, want %q", record.Model, model)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("record for %q was not dispatched", model)
+	}
+}
@@ -0,0 +1,285 @@
+// Package testserver runs a fully configured proxy in-process for integration
+// tests. The proxy listens on a free loopback port, keeps its config, auth
+// directory and device binding store in a temporary directory, and forwards to
+// a fake Anthropic upstream, so tests need neither credentials nor containers:
+//
+//	srv := testserver.Start(t)
+//	resp, err := srv.Post("/v1/messages", `{"model":"claude-test","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`)
+//
+// The proxy uses process-wide registries (models, usage plugins, token store),
+// so servers should not run in parallel tests.
+package testserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	chainaccess "github.com/router-for-me/CLIProxyAPI/v6/internal/access/chain_access"
+	configaccess "github.com/router-for-me/CLIProxyAPI/v6/internal/access/config_access"
+	sdkAuth "github.com/router-for-me/CLIProxyAPI/v6/sdk/auth"
+	"github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy"
+	"github.com/router-for-me/CLIProxyAPI/v6/sdk/config"
+	_ "github.com/router-for-me/CLIProxyAPI/v6/sdk/translator/builtin"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults of a started server
+const (
+	DefaultAPIKey        = "test-key"
+	DefaultModel         = "claude-test"
+	DefaultManagementKey = "test-management-key"
+)
+
+// startTimeout bounds how long Start waits for the proxy to serve its model.
+const startTimeout = 15 * time.Second
+
+// Options customise a test server.
+type Options struct {
+	// APIKeys are the client keys the proxy accepts. Default: DefaultAPIKey.
+	APIKeys []string
+	// Models are the model names routed to the fake upstream. Default: DefaultModel.
+	Models []string
+	// Configure adjusts the configuration before the proxy starts, e.g. to enable
+	// device binding or rate limits.
+	Configure func(cfg *config.Config)
+}
+
+// Server is a running proxy with its fake upstream.
+type Server struct {
+	// URL is the proxy's base URL, e.g. "http://127.0.0.1:41234".
+	URL string
+	// APIKey is the first client key.
+	APIKey string
+	// ManagementKey authenticates management API requests.
+	ManagementKey string
+	// Dir is the temporary directory holding config, auths and stores.
+	Dir string
+	// Config is the configuration the proxy started with.
+	Config *config.Config
+	// Upstream is the fake upstream the proxy forwards to.
+	Upstream *Upstream
+
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// Start runs a proxy for the duration of the test and stops it on cleanup.
+// It fails the test when the proxy does not come up.
+func Start(t testing.TB, opts ...Options) *Server {
+	t.Helper()
+	var opt Options
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	s, err := New(t.TempDir(), opt)
+	if err != nil {
+		t.Fatalf("testserver: %v", err)
+	}
+	t.Cleanup(func() {
+		if errClose := s.Close(); errClose != nil {
+			t.Errorf("testserver: close: %v", errClose)
+		}
+	})
+	return s
+}
+
+// New runs a proxy keeping its files in dir. Callers must Close it; tests
+// should prefer Start.
+func New(dir string, opt Options) (*Server, error) {
+	if len(opt.APIKeys) == 0 {
+		opt.APIKeys = []string{DefaultAPIKey}
+	}
+	if len(opt.Models) == 0 {
+		opt.Models = []string{DefaultModel}
+	}
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	authDir := filepath.Join(dir, "auths")
+	if err = os.MkdirAll(authDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	// The management handler compares the secret key as a bcrypt hash, the form
+	// config loading stores it in.
+	managementHash, err := bcrypt.GenerateFromPassword([]byte(DefaultManagementKey), bcrypt.MinCost)
+	if err != nil {
+		return nil, err
+	}
+
+	upstream := NewUpstream()
+	models := make([]config.ClaudeModel, 0, len(opt.Models))
+	for _, model := range opt.Models {
+		models = append(models, config.ClaudeModel{Name: model, Alias: model})
+	}
+	cfg := &config.Config{
+		SDKConfig: config.SDKConfig{APIKeys: append([]string(nil), opt.APIKeys...)},
+		Host:      "127.0.0.1",
+		Port:      port,
+		AuthDir:   authDir,
+		ClaudeKey: []config.ClaudeKey{{APIKey: "upstream-test-key", BaseURL: upstream.URL(), Models: models}},
+		RemoteManagement: config.RemoteManagement{
+			AllowRemote:         false,
+			SecretKey:           string(managementHash),
+			DisableControlPanel: true,
+		},
+	}
+	cfg.DeviceBinding.Store.Backend = "sqlite"
+	cfg.DeviceBinding.Store.Path = filepath.Join(dir, "device-bindings.db")
+	cfg.Audit.Path = filepath.Join(dir, "audit.jsonl")
+	// Tests assert on the exact upstream requests, so the startup key probe stays off.
+	cfg.SelfTest.SkipUpstream = true
+	if opt.Configure != nil {
+		opt.Configure(cfg)
+	}
+
+	configPath := filepath.Join(dir, "config.yaml")
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		upstream.Close()
+		return nil, fmt.Errorf("encode config: %w", err)
+	}
+	if err = os.WriteFile(configPath, data, 0o600); err != nil {
+		upstream.Close()
+		return nil, err
+	}
+
+	configaccess.Register()
+	chainaccess.Register()
+	sdkAuth.RegisterTokenStore(sdkAuth.NewFileTokenStore())
+	service, err := cliproxy.NewBuilder().WithConfig(cfg).WithConfigPath(configPath).Build()
+	if err != nil {
+		upstream.Close()
+		return nil, fmt.Errorf("build service: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		URL:           fmt.Sprintf("http://127.0.0.1:%d", port),
+		APIKey:        opt.APIKeys[0],
+		ManagementKey: DefaultManagementKey,
+		Dir:           dir,
+		Config:        cfg,
+		Upstream:      upstream,
+		cancel:        cancel,
+		done:          make(chan error, 1),
+	}
+	go func() { s.done <- service.Run(ctx) }()
+	if err = s.waitReady(opt.Models[0]); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// freePort reserves a free loopback port. The port is released before the
+// proxy binds it, which is racy only against other processes.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = l.Close() }()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// waitReady polls the models endpoint until model is routable.
+func (s *Server) waitReady(model string) error {
+	deadline := time.Now().Add(startTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-s.done:
+			s.done <- err
+			return fmt.Errorf("proxy exited during startup: %v", err)
+		default:
+		}
+		if resp, err := s.Do(http.MethodGet, "/v1/models", ""); err == nil {
+			body := readBody(resp)
+			if resp.StatusCode == http.StatusOK && strings.Contains(body, `"`+model+`"`) {
+				return nil
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("proxy did not serve model %q within %s", model, startTimeout)
+}
+
+// Close stops the proxy and the fake upstream.
+func (s *Server) Close() error {
+	if s == nil || s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+	s.cancel = nil
+	defer s.Upstream.Close()
+	select {
+	case err := <-s.done:
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+		return nil
+	case <-time.After(30 * time.Second):
+		return fmt.Errorf("proxy did not stop")
+	}
+}
+
+// NewRequest builds a request to path authenticated with the server's API key.
+func (s *Server) NewRequest(method, path, body string) (*http.Request, error) {
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// Do sends a request authenticated with the server's API key.
+func (s *Server) Do(method, path, body string) (*http.Response, error) {
+	req, err := s.NewRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// Post sends an authenticated JSON POST request.
+func (s *Server) Post(path, body string) (*http.Response, error) {
+	return s.Do(http.MethodPost, path, body)
+}
+
+// Management sends a management API request authenticated with the management key.
+// path is relative to /v1/management, e.g. "/device-bindings".
+func (s *Server) Management(method, path, body string) (*http.Response, error) {
+	req, err := s.NewRequest(method, "/v1/management"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.ManagementKey)
+	return http.DefaultClient.Do(req)
+}
+
+func readBody(resp *http.Response) string {
+	defer func() { _ = resp.Body.Close() }()
+	var sb strings.Builder
+	buf := make([]byte, 4096)
+	for {
+		n, err := resp.Body.Read(buf)
+		sb.Write(buf[:n])
+		if err != nil {
+			return sb.String()
+		}
+	}
+}
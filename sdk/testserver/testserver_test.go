@@ -0,0 +1,57 @@
+package testserver
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestServerProxiesToFakeUpstream(t *testing.T) {
+	srv := Start(t)
+
+	resp, err := srv.Post("/v1/messages", `{"model":"claude-test","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || gjson.GetBytes(body, "content.0.text").String() != DefaultReply {
+		t.Fatalf("unexpected response %d: %s", resp.StatusCode, body)
+	}
+
+	resp, err = srv.Post("/v1/chat/completions", `{"model":"claude-test","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if err != nil {
+		t.Fatalf("stream request: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "fake") || !strings.Contains(string(body), "[DONE]") {
+		t.Fatalf("unexpected stream %d: %s", resp.StatusCode, body)
+	}
+
+	requests := srv.Upstream.Requests()
+	if len(requests) != 2 || requests[0].Model() != "claude-test" || requests[0].Header.Get("Authorization") != "Bearer upstream-test-key" {
+		t.Fatalf("unexpected upstream requests: %+v", requests)
+	}
+
+	resp, err = srv.Management(http.MethodGet, "/api-versions", "")
+	if err != nil {
+		t.Fatalf("management request: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("management API returned %d", resp.StatusCode)
+	}
+
+	unauthorized, err := http.Get(srv.URL + "/v1/models")
+	if err != nil {
+		t.Fatalf("unauthenticated request: %v", err)
+	}
+	_ = unauthorized.Body.Close()
+	if unauthorized.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a key, got %d", unauthorized.StatusCode)
+	}
+}
@@ -0,0 +1,154 @@
+package testserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+// Default reply of the fake upstream
+const (
+	DefaultReply        = "Hello from the fake upstream."
+	DefaultInputTokens  = 12
+	DefaultOutputTokens = 7
+)
+
+// UpstreamRequest is a request received by the fake upstream.
+type UpstreamRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// Model returns the model the proxy requested upstream.
+func (r UpstreamRequest) Model() string {
+	return gjson.GetBytes(r.Body, "model").String()
+}
+
+// Failure is a canned error response of the fake upstream.
+type Failure struct {
+	Status int
+	Body   string
+}
+
+// Upstream is a fake Anthropic Messages API. It answers every request with a
+// fixed reply, streamed as server-sent events when the request asks for it, and
+// records the requests it receives.
+type Upstream struct {
+	server *httptest.Server
+
+	mu           sync.Mutex
+	requests     []UpstreamRequest
+	reply        string
+	inputTokens  int64
+	outputTokens int64
+	failures     []Failure
+}
+
+// NewUpstream starts a fake upstream. Close it when done.
+func NewUpstream() *Upstream {
+	u := &Upstream{reply: DefaultReply, inputTokens: DefaultInputTokens, outputTokens: DefaultOutputTokens}
+	u.server = httptest.NewServer(http.HandlerFunc(u.serveHTTP))
+	return u
+}
+
+// URL is the base URL of the fake upstream.
+func (u *Upstream) URL() string { return u.server.URL }
+
+// Close shuts the fake upstream down.
+func (u *Upstream) Close() { u.server.Close() }
+
+// SetReply changes the reply text and the usage reported with it.
+func (u *Upstream) SetReply(text string, inputTokens, outputTokens int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.reply, u.inputTokens, u.outputTokens = text, inputTokens, outputTokens
+}
+
+// FailNext makes the next len(failures) requests fail with the given responses,
+// e.g. to exercise retries and cooldowns.
+func (u *Upstream) FailNext(failures ...Failure) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.failures = append(u.failures, failures...)
+}
+
+// Requests returns the requests received so far.
+func (u *Upstream) Requests() []UpstreamRequest {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]UpstreamRequest(nil), u.requests...)
+}
+
+func (u *Upstream) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	u.mu.Lock()
+	u.requests = append(u.requests, UpstreamRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+	reply, inputTokens, outputTokens := u.reply, u.inputTokens, u.outputTokens
+	var failure *Failure
+	if len(u.failures) > 0 {
+		failure = &u.failures[0]
+		u.failures = u.failures[1:]
+	}
+	u.mu.Unlock()
+
+	if failure != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(failure.Status)
+		_, _ = io.WriteString(w, failure.Body)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/v1/messages") {
+		http.NotFound(w, r)
+		return
+	}
+	model := gjson.GetBytes(body, "model").String()
+	if gjson.GetBytes(body, "stream").Bool() {
+		writeStream(w, model, reply, inputTokens, outputTokens)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id":            "msg_testserver",
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       []map[string]any{{"type": "text", "text": reply}},
+		"stop_reason":   "end_turn",
+		"stop_sequence": nil,
+		"usage":         map[string]any{"input_tokens": inputTokens, "output_tokens": outputTokens},
+	})
+}
+
+// writeStream sends the reply as Anthropic server-sent events.
+func writeStream(w http.ResponseWriter, model, reply string, inputTokens, outputTokens int64) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	event := func(name string, data map[string]any) {
+		encoded, _ := json.Marshal(data)
+		_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, encoded)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	event("message_start", map[string]any{"type": "message_start", "message": map[string]any{
+		"id": "msg_testserver", "type": "message", "role": "assistant", "model": model, "content": []any{},
+		"stop_reason": nil, "stop_sequence": nil, "usage": map[string]any{"input_tokens": inputTokens, "output_tokens": 0},
+	}})
+	event("content_block_start", map[string]any{"type": "content_block_start", "index": 0, "content_block": map[string]any{"type": "text", "text": ""}})
+	for _, chunk := range strings.SplitAfter(reply, " ") {
+		event("content_block_delta", map[string]any{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "text_delta", "text": chunk}})
+	}
+	event("content_block_stop", map[string]any{"type": "content_block_stop", "index": 0})
+	event("message_delta", map[string]any{"type": "message_delta", "delta": map[string]any{"stop_reason": "end_turn", "stop_sequence": nil},
+		"usage": map[string]any{"output_tokens": outputTokens}})
+	event("message_stop", map[string]any{"type": "message_stop"})
+}